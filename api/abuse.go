@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// abuseBatchSize is the maximum number of abuse-scanner emails we process
+	// at once.
+	abuseBatchSize = 100
+	// reporterAbuseScanner prefixes the reporter tag of skylinks reported via
+	// the abuse-scanner.
+	reporterAbuseScanner = "abuse-scanner"
+)
+
+// StartAbuseConsumer launches a background thread which polls the
+// abuse-scanner's collection of abuse emails every interval and queues the
+// reported skylinks for scanning with high priority until the context is
+// cancelled. On startup it goes back lookback in time, so it picks up reports
+// received while the service was down. Queueing the same report twice is
+// harmless.
+func (api *API) StartAbuseConsumer(ctx context.Context, abuseDB, collection string, interval, lookback time.Duration) error {
+	if abuseDB == "" || collection == "" {
+		return errors.New("invalid abuse-scanner collection")
+	}
+	if interval <= 0 {
+		return errors.New("invalid abuse-scanner poll interval")
+	}
+	go func() {
+		cursor := database.AbuseCursor{InsertedAt: database.Clock.Now().UTC().Add(-lookback)}
+		ticker := database.Clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			var err error
+			cursor, err = api.consumeAbuseReports(ctx, abuseDB, collection, cursor)
+			if err != nil {
+				api.staticLogger.Warnln(errors.AddContext(err, "failed to consume abuse reports"))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+			}
+		}
+	}()
+	return nil
+}
+
+// consumeAbuseReports queues the skylinks of all abuse reports received after
+// the given cursor. It returns the cursor up to which it processed the
+// reports.
+func (api *API) consumeAbuseReports(ctx context.Context, abuseDB, collection string, cursor database.AbuseCursor) (database.AbuseCursor, error) {
+	for {
+		reports, next, err := api.staticDB.AbuseReports(ctx, abuseDB, collection, cursor, abuseBatchSize)
+		if err != nil {
+			return cursor, err
+		}
+		// Every email has its own ID, so the cursor only keeps its ID if
+		// there are no new emails.
+		if next.EmailID == cursor.EmailID {
+			return cursor, nil
+		}
+		for _, r := range reports {
+			for _, sl := range r.Skylinks {
+				err = api.enqueueReported(ctx, sl, reporterTag(r.Reporter))
+				if errors.Contains(err, errInvalidSkylink) {
+					api.staticLogger.Debugf("Ignored invalid skylink '%s' from abuse report %s", sl, r.EmailID.Hex())
+					continue
+				}
+				if err != nil {
+					// Retry the whole batch next time.
+					return cursor, err
+				}
+			}
+		}
+		cursor = next
+	}
+}
+
+// enqueueReported adds the given skylink to the scanning queue with high
+// priority and tags it with the reporter. Errors caused by an invalid skylink
+// extend errInvalidSkylink.
+func (api *API) enqueueReported(ctx context.Context, skylinkStr, reporter string) error {
+	skylink, err := parseSkylink(skylinkStr, api.staticClamAV.PreferredPortal())
+	if err != nil {
+		return errors.Extend(err, errInvalidSkylink)
+	}
+	created, err := api.staticDB.SkylinkEnqueueReported(ctx, skylink, database.PriorityHigh, reporter)
+	if err != nil {
+		return err
+	}
+	if created {
+		api.staticEvents.Emit(events.Event{
+			Type:    events.TypeSubmitted,
+			Hash:    skylink.Hash.String(),
+			Skylink: skylink.Skylink,
+			Status:  database.SkylinkStatusNew,
+		})
+	}
+	api.staticLogger.Debugf("enqueueReported queued %s reported by '%s'", skylink.Skylink, reporter)
+	return nil
+}
+
+// reporterTag returns the tag of skylinks reported via the abuse-scanner by
+// the given reporter.
+func reporterTag(reporter string) string {
+	if reporter == "" {
+		return reporterAbuseScanner
+	}
+	return reporterAbuseScanner + ":" + reporter
+}
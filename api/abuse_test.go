@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/test"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+)
+
+// newAbuseAPI returns an API which queues reported skylinks in the given DB.
+func newAbuseAPI(t *testing.T, db *database.DB) *API {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mc.Close() })
+	ip, port := mc.Addr()
+	clam, err := clamav.New(ip, port, "http://portal.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = clam.Close() })
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ev, err := events.NewEmitter(context.Background(), nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &API{
+		staticDB:     db,
+		staticClamAV: clam,
+		staticEvents: ev,
+		staticLogger: logger,
+	}
+}
+
+// abuseSkylinks returns the given number of skylinks to report.
+func abuseSkylinks(t *testing.T, n int) []*database.Skylink {
+	sls := make([]*database.Skylink, n)
+	for i := range sls {
+		skylink, err := skymodules.NewSkylinkV1(crypto.HashBytes([]byte(fmt.Sprint("abuse", i))), 0, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sls[i] = &database.Skylink{}
+		if err = sls[i].LoadString(skylink.String(), "http://portal.invalid"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sls
+}
+
+// reportAbuse stores an abuse-scanner email received at the given time, in
+// which the given reporter reports the given skylinks.
+func reportAbuse(t *testing.T, db *database.DB, at time.Time, reporter string, skylinks ...string) {
+	email := bson.M{
+		"inserted_at": at,
+		"parsed":      true,
+		"parsed_reports": bson.A{bson.M{
+			"skylinks": skylinks,
+			"reporter": bson.M{"email": reporter},
+		}},
+	}
+	if _, err := abuseEmails(db).InsertOne(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// abuseEmails returns the abuse-scanner's collection of emails the tests use.
+func abuseEmails(db *database.DB) *mongo.Collection {
+	return db.Collection("skylinks").Database().Client().Database("abuse").Collection("emails")
+}
+
+// TestReporterTag ensures we tag reported skylinks with their reporter, if
+// the abuse-scanner knows who it is.
+func TestReporterTag(t *testing.T) {
+	if tag := reporterTag(""); tag != "abuse-scanner" {
+		t.Fatalf("Unexpected tag '%s'", tag)
+	}
+	if tag := reporterTag("alice@example.com"); tag != "abuse-scanner:alice@example.com" {
+		t.Fatalf("Unexpected tag '%s'", tag)
+	}
+}
+
+// TestConsumeAbuseReports ensures we queue the skylinks of abuse reports with
+// high priority, skip the invalid ones and retry the whole batch if any of the
+// skylinks fails to be queued.
+func TestConsumeAbuseReports(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	api := newAbuseAPI(t, db)
+	sls := abuseSkylinks(t, 3)
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	reportAbuse(t, db, t0, "alice@example.com", sls[0].Skylink, "not-a-skylink")
+	reportAbuse(t, db, t0.Add(time.Minute), "bob@example.com", sls[1].Skylink, sls[2].Skylink)
+	// check ensures the i-th skylink is queued by the given reporter, or not
+	// queued at all.
+	check := func(i int, queued bool, reporter string) {
+		t.Helper()
+		sl, err := db.Skylink(ctx, sls[i].Hash)
+		if !queued {
+			if !errors.Contains(err, database.ErrNoDocumentsFound) {
+				t.Fatalf("Expected skylink %d not to be queued, got %+v, %v", i, sl, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sl.Status != database.SkylinkStatusNew || sl.Priority != database.PriorityHigh || sl.Reporter != reporterTag(reporter) {
+			t.Fatalf("Expected skylink %d to be queued by '%s', got %+v", i, reporter, sl)
+		}
+	}
+
+	// A unique index on the reporter only lets one skylink per reporter be
+	// queued, so the second skylink Bob reported fails.
+	index := mongo.IndexModel{
+		Keys:    bson.D{{"reporter", 1}},
+		Options: options.Index().SetUnique(true).SetName("test_unique_reporter"),
+	}
+	if _, err := db.Collection("skylinks").Indexes().CreateOne(ctx, index); err != nil {
+		t.Fatal(err)
+	}
+	start := database.AbuseCursor{InsertedAt: t0.Add(-time.Hour)}
+	cursor, err := api.consumeAbuseReports(ctx, "abuse", "emails", start)
+	if err == nil || cursor != start {
+		t.Fatalf("Expected the batch to fail without moving the cursor, got %+v, %v", cursor, err)
+	}
+	check(0, true, "alice@example.com")
+	check(1, true, "bob@example.com")
+	check(2, false, "")
+
+	// The whole batch is retried next time.
+	if _, err = db.Collection("skylinks").Indexes().DropOne(ctx, "test_unique_reporter"); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err = api.consumeAbuseReports(ctx, "abuse", "emails", cursor)
+	if err != nil || !cursor.InsertedAt.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("Expected the cursor at Bob's report, got %+v, %v", cursor, err)
+	}
+	check(0, true, "alice@example.com")
+	check(1, true, "bob@example.com")
+	check(2, true, "bob@example.com")
+	if next, err := api.consumeAbuseReports(ctx, "abuse", "emails", cursor); err != nil || next != cursor {
+		t.Fatalf("Expected no new reports, got %+v, %v", next, err)
+	}
+}
+
+// TestStartAbuseConsumer ensures the consumer looks back for reports received
+// before it started and polls for new ones on the DB's clock.
+func TestStartAbuseConsumer(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = fake
+	t.Cleanup(func() { database.Clock = real })
+	db := containers.MongoDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := newAbuseAPI(t, db)
+	sls := abuseSkylinks(t, 3)
+	// waitQueued waits for the given skylink to be queued.
+	waitQueued := func(sl *database.Skylink) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := db.Skylink(ctx, sl.Hash); err == nil {
+				return
+			}
+		}
+		t.Fatalf("Expected %s to be queued", sl.Skylink)
+	}
+
+	reportAbuse(t, db, fake.Now().Add(-2*time.Hour), "alice@example.com", sls[0].Skylink)
+	reportAbuse(t, db, fake.Now().Add(-30*time.Minute), "alice@example.com", sls[1].Skylink)
+	if err := api.StartAbuseConsumer(ctx, "abuse", "emails", time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	waitQueued(sls[1])
+	if _, err := db.Skylink(ctx, sls[0].Hash); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected the report from before the lookback to be ignored, got %v", err)
+	}
+
+	reportAbuse(t, db, fake.Now(), "bob@example.com", sls[2].Skylink)
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	waitQueued(sls[2])
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/jwt"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// accountsCookieName is the name of the cookie skynet-accounts stores
+	// the JWT of logged-in users in.
+	accountsCookieName = "skynet-jwt"
+	// accountsCookieKeySize is the size of the keys skynet-accounts signs
+	// and encrypts its cookie with. Longer keys are truncated.
+	accountsCookieKeySize = 32
+	// accountsCookieMaxAge is the age above which skynet-accounts' cookie
+	// library rejects a cookie, regardless of the JWT's expiration.
+	accountsCookieMaxAge = 30 * 24 * time.Hour
+)
+
+var (
+	// AccountsAdmins is the emails and subjects of the skynet-accounts users
+	// who can call the admin endpoints with their JWT, passed as a bearer
+	// token or in skynet-accounts' cookie. Their JWT is only checked when
+	// it's not empty and LoadAccountsJWKS succeeded.
+	// Set according to the MALWARE_SCANNER_ACCOUNTS_ADMINS env var.
+	AccountsAdmins map[string]bool
+	// AccountsCookieHashKey and AccountsCookieEncKey are the keys
+	// skynet-accounts signs and encrypts its cookie with, i.e. its
+	// COOKIE_HASH_KEY and COOKIE_ENC_KEY. Cookies aren't accepted without
+	// them.
+	// Set according to the MALWARE_SCANNER_ACCOUNTS_COOKIE_HASH_KEY and
+	// MALWARE_SCANNER_ACCOUNTS_COOKIE_ENC_KEY env vars.
+	AccountsCookieHashKey []byte
+	AccountsCookieEncKey  []byte
+)
+
+// ParseAccountsAdmins parses a comma-separated list of skynet-accounts
+// emails and subjects.
+func ParseAccountsAdmins(s string) map[string]bool {
+	admins := make(map[string]bool)
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			admins[a] = true
+		}
+	}
+	return admins
+}
+
+// LoadAccountsJWKS loads the JSON Web Key Set skynet-accounts signs its JWTs
+// with from the given file, so we can verify them.
+func LoadAccountsJWKS(file string, logger *logrus.Logger) error {
+	jwt.AccountsJWKSFile = file
+	return errors.AddContext(jwt.LoadAccountsKeySet(logger), "failed to load the accounts JWKS")
+}
+
+// accountsAdmin returns the email, or the subject if there's none, of the
+// skynet-accounts user whose JWT the request holds, if they're one of the
+// AccountsAdmins.
+func accountsAdmin(req *http.Request) (string, bool) {
+	if len(AccountsAdmins) == 0 || jwt.AccountsPublicJWKS == nil {
+		return "", false
+	}
+	t, ok := accountsToken(req)
+	if !ok {
+		return "", false
+	}
+	token, err := jwt.ValidateToken(t)
+	if err != nil {
+		return "", false
+	}
+	sub, email, err := jwt.UserDetailsFromJWT(jwt.ContextWithToken(context.Background(), token))
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case email != "" && AccountsAdmins[email]:
+		return email, true
+	case AccountsAdmins[sub]:
+		return sub, true
+	}
+	return "", false
+}
+
+// accountsToken returns the JWT in the request's Authorization header or, if
+// there's none, in skynet-accounts' cookie. The cookie is only accepted for
+// GET and HEAD requests: any site can make a browser POST to us with the
+// cookie attached, so it must not authorise changes.
+func accountsToken(req *http.Request) (string, bool) {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), true
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+	c, err := req.Cookie(accountsCookieName)
+	if err != nil || len(AccountsCookieHashKey) == 0 || len(AccountsCookieEncKey) == 0 {
+		return "", false
+	}
+	t, err := decodeAccountsCookie(c.Value, time.Now())
+	if err != nil {
+		return "", false
+	}
+	return t, true
+}
+
+// decodeAccountsCookie verifies and decrypts the value of skynet-accounts'
+// cookie the way its cookie library, gorilla's securecookie, does. The value
+// is the base64 of `<timestamp>|<base64 of the encrypted token>|<mac>`, where
+// the MAC is an HMAC-SHA256 of the cookie's name and the first two parts and
+// the token is gob-encoded and encrypted with AES in CTR mode.
+func decodeAccountsCookie(value string, now time.Time) (string, error) {
+	b, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return "", errors.AddContext(err, "invalid cookie encoding")
+	}
+	parts := bytes.SplitN(b, []byte("|"), 3)
+	if len(parts) != 3 {
+		return "", errors.New("invalid cookie format")
+	}
+	h := hmac.New(sha256.New, truncateKey(AccountsCookieHashKey))
+	_, _ = h.Write([]byte(accountsCookieName + "|"))
+	_, _ = h.Write(b[:len(b)-len(parts[2])-1])
+	if !hmac.Equal(h.Sum(nil), parts[2]) {
+		return "", errors.New("invalid cookie MAC")
+	}
+	ts, err := strconv.ParseInt(string(parts[0]), 10, 64)
+	if err != nil {
+		return "", errors.AddContext(err, "invalid cookie timestamp")
+	}
+	if time.Unix(ts, 0).Before(now.Add(-accountsCookieMaxAge)) {
+		return "", errors.New("expired cookie")
+	}
+	enc, err := base64.URLEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		return "", errors.AddContext(err, "invalid cookie value encoding")
+	}
+	block, err := aes.NewCipher(truncateKey(AccountsCookieEncKey))
+	if err != nil {
+		return "", errors.AddContext(err, "invalid cookie encryption key")
+	}
+	if len(enc) <= block.BlockSize() {
+		return "", errors.New("cookie value too short")
+	}
+	iv, enc := enc[:block.BlockSize()], enc[block.BlockSize():]
+	cipher.NewCTR(block, iv).XORKeyStream(enc, enc)
+	var token string
+	if err = gob.NewDecoder(bytes.NewReader(enc)).Decode(&token); err != nil {
+		return "", errors.AddContext(err, "invalid cookie value")
+	}
+	return token, nil
+}
+
+// truncateKey truncates a cookie key the way skynet-accounts does.
+func truncateKey(key []byte) []byte {
+	if len(key) > accountsCookieKeySize {
+		return key[:accountsCookieKeySize]
+	}
+	return key
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/jwt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// writeJWKS generates an RSA key and writes it as a JSON Web Key Set like
+// skynet-accounts' to the given directory, returning the file's path.
+func writeJWKS(t *testing.T, dir string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Precompute()
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwk := map[string]string{
+		"use": "sig",
+		"kty": "RSA",
+		"kid": "test",
+		"alg": "RS256",
+		"n":   b64(key.N),
+		"e":   b64(big.NewInt(int64(key.E))),
+		"d":   b64(key.D),
+		"p":   b64(key.Primes[0]),
+		"q":   b64(key.Primes[1]),
+		"dp":  b64(key.Precomputed.Dp),
+		"dq":  b64(key.Precomputed.Dq),
+		"qi":  b64(key.Precomputed.Qinv),
+	}
+	b, err := json.Marshal(map[string]interface{}{"keys": []interface{}{jwk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "jwks.json")
+	if err = ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// encodeAccountsCookie encodes the given token the way skynet-accounts does
+// for its cookie.
+func encodeAccountsCookie(t *testing.T, token string, ts time.Time) string {
+	var val bytes.Buffer
+	if err := gob.NewEncoder(&val).Encode(token); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(truncateKey(AccountsCookieEncKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := make([]byte, block.BlockSize()+val.Len())
+	if _, err = rand.Read(enc[:block.BlockSize()]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCTR(block, enc[:block.BlockSize()]).XORKeyStream(enc[block.BlockSize():], val.Bytes())
+	b := []byte(strconv.FormatInt(ts.Unix(), 10) + "|" + base64.URLEncoding.EncodeToString(enc))
+	h := hmac.New(sha256.New, truncateKey(AccountsCookieHashKey))
+	_, _ = h.Write([]byte(accountsCookieName + "|"))
+	_, _ = h.Write(b)
+	b = append(append(b, '|'), h.Sum(nil)...)
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// TestAccountsAdmin ensures skynet-accounts admins can call the admin
+// endpoints with their JWT, as a bearer token or, for GET requests, in the
+// accounts cookie.
+func TestAccountsAdmin(t *testing.T) {
+	defer func(keys map[string]string, admins map[string]bool, hashKey, encKey []byte) {
+		AdminKeys, AccountsAdmins, AccountsCookieHashKey, AccountsCookieEncKey = keys, admins, hashKey, encKey
+		jwt.AccountsJWKS, jwt.AccountsPublicJWKS = nil, nil
+	}(AdminKeys, AccountsAdmins, AccountsCookieHashKey, AccountsCookieEncKey)
+	if err := LoadAccountsJWKS(writeJWKS(t, t.TempDir()), logrus.New()); err != nil {
+		t.Fatal(err)
+	}
+	_, admin, err := jwt.TokenForUser("admin@example.com", "sub-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, user, err := jwt.TokenForUser("user@example.com", "sub-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AdminKeys = nil
+	AccountsAdmins = ParseAccountsAdmins("admin@example.com, sub-service")
+	AccountsCookieHashKey = []byte("0123456789abcdef0123456789abcdef-and-more")
+	AccountsCookieEncKey = []byte("fedcba9876543210fedcba9876543210")
+
+	var name string
+	h := withAdmin(func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		name = caller(req)
+	})
+	method := http.MethodGet
+	request := func(auth, cookie string) int {
+		req := httptest.NewRequest(method, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: accountsCookieName, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		h(w, req, nil)
+		return w.Code
+	}
+
+	if code := request("Bearer "+string(admin), ""); code != http.StatusOK || name != "admin@example.com" {
+		t.Fatalf("Expected the admin to be let through, got %d, '%s'", code, name)
+	}
+	name = ""
+	if code := request("", encodeAccountsCookie(t, string(admin), time.Now())); code != http.StatusOK || name != "admin@example.com" {
+		t.Fatalf("Expected the admin's cookie to be let through, got %d, '%s'", code, name)
+	}
+	tests := map[string][2]string{
+		"non-admin":      {"Bearer " + string(user), ""},
+		"invalid token":  {"Bearer " + string(admin[:len(admin)-2]), ""},
+		"expired cookie": {"", encodeAccountsCookie(t, string(admin), time.Now().Add(-2*accountsCookieMaxAge))},
+		"forged cookie":  {"", base64.URLEncoding.EncodeToString([]byte("1|abc|def"))},
+	}
+	for name, tt := range tests {
+		if code := request(tt[0], tt[1]); code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected status %d, got %d", name, http.StatusUnauthorized, code)
+		}
+	}
+	// The cookie isn't accepted for POST requests, which any site can make a
+	// browser send with it.
+	method = http.MethodPost
+	if code := request("", encodeAccountsCookie(t, string(admin), time.Now())); code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := request("Bearer "+string(admin), ""); code != http.StatusOK {
+		t.Fatalf("Expected the admin to be let through, got %d", code)
+	}
+	method = http.MethodGet
+	// The cookie isn't accepted without the keys.
+	AccountsCookieHashKey = nil
+	if code := request("", encodeAccountsCookie(t, string(admin), time.Now())); code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// actionPause is the audited action of pausing the scanner.
+	actionPause = "pause"
+	// actionResume is the audited action of resuming the scanner.
+	actionResume = "resume"
+	// actionRescan is the audited action of queueing a skylink for a rescan.
+	actionRescan = "rescan"
+	// actionPurge is the audited action of removing a skylink's record.
+	actionPurge = "purge"
+	// actionFalsePositive is the audited action of overriding an infected
+	// verdict.
+	actionFalsePositive = "false_positive"
+	// actionUnblock is the audited action of asking blocker to unblock a
+	// skylink.
+	actionUnblock = "unblock"
+	// actionLiftBan is the audited action of lifting a submitter's ban.
+	actionLiftBan = "lift_ban"
+	// actionRequestConfirmation is the audited action of requesting a
+	// destructive action which waits for confirmation.
+	actionRequestConfirmation = "request_confirmation"
+
+	// defaultAuditLimit is the number of entries /admin/audit returns by
+	// default.
+	defaultAuditLimit = 100
+	// maxAuditLimit is the maximum number of entries /admin/audit can
+	// return.
+	maxAuditLimit = 1000
+
+	// defaultUploadersLimit is the number of uploaders /admin/uploaders
+	// returns by default.
+	defaultUploadersLimit = 100
+	// maxUploadersLimit is the maximum number of uploaders /admin/uploaders
+	// can return.
+	maxUploadersLimit = 1000
+	// defaultUploadersMin is the minimum number of infected uploads of the
+	// uploaders /admin/uploaders returns by default.
+	defaultUploadersMin = 2
+)
+
+var (
+	// AdminKeys maps the API keys which grant access to the admin endpoints
+	// to the names of their holders. Admin endpoints are disabled when it's
+	// empty.
+	// Set according to the MALWARE_SCANNER_ADMIN_KEYS env var.
+	AdminKeys map[string]string
+)
+
+type (
+	// callerKey is the context key under which we store the name of the
+	// admin making the request.
+	callerKey struct{}
+
+	// falsePositiveResponse is the response to false positive requests.
+	// WasReported tells whether the skylink had already been reported to
+	// blocker, in which case we ask blocker to unblock it. If that fails, the
+	// skylink stays blocked until unblocked there and UnblockError says why.
+	falsePositiveResponse struct {
+		WasReported  bool   `json:"wasReported"`
+		Unblocked    bool   `json:"unblocked"`
+		UnblockError string `json:"unblockError,omitempty"`
+	}
+
+	// blockerStatusResponse is the response to blocker status requests.
+	blockerStatusResponse struct {
+		Blocked bool `json:"blocked"`
+	}
+
+	// auditResponse is the response to audit log requests.
+	auditResponse struct {
+		Entries []database.AuditEntry `json:"entries"`
+	}
+
+	// uploadersResponse is the response to repeat uploader requests.
+	uploadersResponse struct {
+		Uploaders []database.RepeatUploader `json:"uploaders"`
+	}
+)
+
+// ParseAdminKeys parses a comma-separated list of `name:key` pairs.
+func ParseAdminKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("admin keys must be in the form `name:key`")
+		}
+		if _, exists := keys[parts[1]]; exists {
+			return nil, errors.New("duplicate admin key for " + parts[0])
+		}
+		keys[parts[1]] = parts[0]
+	}
+	return keys, nil
+}
+
+// withAdmin wraps the given handler, so it's only accessible from the
+// AdminAllowlist networks with one of the AdminKeys passed as a bearer token,
+// or the JWT of one of the AccountsAdmins. The name of the key's holder, or
+// the admin's email, is then available to the handler via caller.
+func withAdmin(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		if len(AdminKeys) == 0 && len(AccountsAdmins) == 0 {
+			skyapi.WriteError(w, skyapi.Error{"admin endpoints are disabled"}, http.StatusForbidden)
+			return
+		}
+		if !allowedAddr(req, AdminAllowlist) {
+			skyapi.WriteError(w, skyapi.Error{"address not allowed to call admin endpoints"}, http.StatusForbidden)
+			return
+		}
+		name, ok := adminName(req)
+		if !ok {
+			skyapi.WriteError(w, skyapi.Error{"invalid admin key"}, http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(req.Context(), callerKey{}, name)
+		h(w, req.WithContext(ctx), ps)
+	}
+}
+
+// isAdmin returns whether the request would be allowed to call the admin
+// endpoints, for public endpoints which tell admins more than others.
+func isAdmin(req *http.Request) bool {
+	if len(AdminKeys) == 0 && len(AccountsAdmins) == 0 {
+		return false
+	}
+	if !allowedAddr(req, AdminAllowlist) {
+		return false
+	}
+	_, ok := adminName(req)
+	return ok
+}
+
+// adminName returns the name of the holder of the admin key in the request's
+// Authorization header, or the email of the skynet-accounts admin whose JWT
+// the request holds.
+func adminName(req *http.Request) (string, bool) {
+	if name, ok := keyHolder(req, AdminKeys); ok {
+		return name, true
+	}
+	return accountsAdmin(req)
+}
+
+// keyHolder returns the name of the holder of the key in the request's
+// Authorization header, looking the key up in the given keys.
+func keyHolder(req *http.Request, keys map[string]string) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	var name string
+	var found bool
+	// Compare against all keys, so the response time doesn't tell how many
+	// keys we checked before finding a match.
+	for key, n := range keys {
+		if subtle.ConstantTimeCompare(token, []byte(key)) == 1 {
+			name, found = n, true
+		}
+	}
+	return name, found
+}
+
+// caller returns the name of the admin making the request.
+func caller(req *http.Request) string {
+	name, _ := req.Context().Value(callerKey{}).(string)
+	return name
+}
+
+// adminPausePOST pauses the scanning of new skylinks.
+func (api *API) adminPausePOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	api.staticScanner.Pause()
+	api.audit(r, actionPause, nil, nil)
+	skyapi.WriteSuccess(w)
+}
+
+// adminResumePOST resumes the scanning of new skylinks.
+func (api *API) adminResumePOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	api.staticScanner.Resume()
+	api.audit(r, actionResume, nil, nil)
+	skyapi.WriteSuccess(w)
+}
+
+// adminRescanPOST queues a skylink for scanning again, regardless of its
+// current verdict.
+func (api *API) adminRescanPOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	params := map[string]string{"skylink": privateSkylinkParam(ps.ByName("skylink"))}
+	sl, err := parseSkylink(ps.ByName("skylink"), api.staticClamAV.PreferredPortal())
+	if err != nil {
+		api.audit(r, actionRescan, params, err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	params["hash"] = sl.Hash.String()
+	sl.Source = database.SourceAdmin
+	sl.Submitter = caller(r)
+	err = api.staticDB.SkylinkRescan(r.Context(), sl)
+	api.audit(r, actionRescan, params, err)
+	if err != nil {
+		api.staticLogger.Warnf("adminRescanPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteSuccess(w)
+}
+
+// adminPurgeDELETE removes a skylink's record from the database.
+func (api *API) adminPurgeDELETE(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	params := map[string]string{"skylink": privateSkylinkParam(ps.ByName("skylink"))}
+	sl, err := parseSkylink(ps.ByName("skylink"), api.staticClamAV.PreferredPortal())
+	if err != nil {
+		api.audit(r, actionPurge, params, err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	params["hash"] = sl.Hash.String()
+	err = api.staticDB.SkylinkPurge(r.Context(), sl.Hash)
+	api.audit(r, actionPurge, params, err)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"skylink not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminPurgeDELETE failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteSuccess(w)
+}
+
+// adminFalsePositivePOST overrides the infected verdict of a skylink.
+func (api *API) adminFalsePositivePOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	params := map[string]string{"skylink": privateSkylinkParam(ps.ByName("skylink"))}
+	sl, err := parseSkylink(ps.ByName("skylink"), api.staticClamAV.PreferredPortal())
+	if err != nil {
+		api.audit(r, actionFalsePositive, params, err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	params["hash"] = sl.Hash.String()
+	old, err := api.staticDB.SkylinkMarkFalsePositive(r.Context(), sl.Hash)
+	api.audit(r, actionFalsePositive, params, err)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"no infected record of this skylink"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminFalsePositivePOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	resp := falsePositiveResponse{
+		WasReported: old.Status == database.SkylinkStatusComplete,
+	}
+	if resp.WasReported {
+		var errs []error
+		for _, b := range api.staticBlockers {
+			targetParams := map[string]string{"target": b.Name()}
+			for k, v := range params {
+				targetParams[k] = v
+			}
+			err = b.Unblock(r.Context(), sl.Skylink)
+			api.audit(r, actionUnblock, targetParams, err)
+			if err != nil {
+				api.staticLogger.Warnf("adminFalsePositivePOST failed to unblock %s on blocker %s: %s", sl.Skylink, b.Name(), err)
+				errs = append(errs, errors.AddContext(err, "blocker "+b.Name()))
+			}
+		}
+		err = errors.Compose(errs...)
+		if err != nil {
+			resp.UnblockError = err.Error()
+		}
+		resp.Unblocked = err == nil
+	}
+	skyapi.WriteJSON(w, resp)
+}
+
+// adminBlockerStatusGET returns whether blocker has blocked the given skylink.
+// The `target` parameter selects the blocker target and defaults to the first
+// one.
+func (api *API) adminBlockerStatusGET(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sl, err := parseSkylink(ps.ByName("skylink"), api.staticClamAV.PreferredPortal())
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	b := api.staticBlockers[0]
+	p := newParams(r)
+	target := p.String("target")
+	if p.invalid(w) {
+		return
+	}
+	if target != "" {
+		b = nil
+		for _, t := range api.staticBlockers {
+			if t.Name() == target {
+				b = t
+			}
+		}
+		if b == nil {
+			skyapi.WriteError(w, skyapi.Error{"unknown blocker target"}, http.StatusBadRequest)
+			return
+		}
+	}
+	blocked, err := b.Status(r.Context(), sl.Skylink)
+	if errors.Contains(err, blocker.ErrUnsupported) {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminBlockerStatusGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadGateway)
+		return
+	}
+	skyapi.WriteJSON(w, blockerStatusResponse{blocked})
+}
+
+// adminAuditGET returns the audit log of admin actions, newest first.
+func (api *API) adminAuditGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	entries, err := api.staticDB.AuditEntries(r.Context(), f)
+	if err != nil {
+		api.staticLogger.Warnf("adminAuditGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, auditResponse{entries})
+}
+
+// adminUploadersGET returns the portal users who uploaded the most infected
+// skylinks, so the abuse team can act on repeat offenders. The `min` parameter
+// sets the minimum number of infected skylinks per user.
+func (api *API) adminUploadersGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	p := newParams(r)
+	minInfected := p.Int("min", defaultUploadersMin, 1, math.MaxInt32)
+	limit := p.Int("limit", defaultUploadersLimit, 1, maxUploadersLimit)
+	if p.invalid(w) {
+		return
+	}
+	uploaders, err := api.staticDB.RepeatUploaders(r.Context(), minInfected, limit)
+	if err != nil {
+		api.staticLogger.Warnf("adminUploadersGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, uploadersResponse{uploaders})
+}
+
+// audit records the given admin action in the audit log. Confirmed actions
+// record who requested them as well. Failing to do so doesn't fail the action
+// but it's logged as an error.
+func (api *API) audit(r *http.Request, action string, params map[string]string, err error) {
+	if name, ok := requester(r); ok {
+		withRequester := map[string]string{"requested_by": name}
+		for k, v := range params {
+			withRequester[k] = v
+		}
+		params = withRequester
+	}
+	e := &database.AuditEntry{
+		Caller: caller(r),
+		Action: action,
+		Params: params,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	errAudit := api.staticDB.AuditLog(r.Context(), e)
+	if errAudit != nil {
+		api.staticLogger.Errorf("Failed to record admin action '%s' by '%s' with params %v in the audit log: %s", action, e.Caller, params, errAudit)
+	}
+}
+
+// parseAuditFilter parses the audit log filter from the request's `from`,
+// `to`, `caller`, `action` and `limit` parameters.
+func parseAuditFilter(r *http.Request) (database.AuditFilter, error) {
+	p := newParams(r)
+	f := database.AuditFilter{
+		Caller: p.String("caller"),
+		Action: p.String("action"),
+		From:   p.Time("from", time.Time{}),
+		To:     p.Time("to", time.Time{}),
+		Limit:  p.Int("limit", defaultAuditLimit, 1, maxAuditLimit),
+	}
+	p.Range(f.From, f.To)
+	if p.Err() != nil {
+		return database.AuditFilter{}, p.Err()
+	}
+	return f, nil
+}
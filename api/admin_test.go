@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestParseAdminKeys ensures we parse admin keys and reject invalid ones.
+func TestParseAdminKeys(t *testing.T) {
+	keys, err := ParseAdminKeys(" alice:key1, bob:key:2 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys["key1"] != "alice" || keys["key:2"] != "bob" {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+	keys, err = ParseAdminKeys("")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("Expected no keys, got %v, %v", keys, err)
+	}
+	for _, s := range []string{"alice", "alice:", ":key", "alice:key,bob:key"} {
+		if _, err = ParseAdminKeys(s); err == nil {
+			t.Fatalf("Expected an error for '%s'", s)
+		}
+	}
+}
+
+// TestWithAdmin ensures withAdmin only lets through requests with a valid
+// admin key and exposes the caller's name to the handler.
+func TestWithAdmin(t *testing.T) {
+	defer func(keys map[string]string) { AdminKeys = keys }(AdminKeys)
+	var name string
+	h := withAdmin(func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		name = caller(req)
+	})
+	request := func(auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, req, nil)
+		return w.Code
+	}
+
+	// Admin endpoints are disabled without keys.
+	AdminKeys = nil
+	if code := request("Bearer key1"); code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, code)
+	}
+
+	AdminKeys = map[string]string{"key1": "alice", "key2": "bob"}
+	for _, auth := range []string{"", "key1", "Bearer key3", "Basic key1"} {
+		if code := request(auth); code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d for '%s', got %d", http.StatusUnauthorized, auth, code)
+		}
+	}
+	if code := request("Bearer key2"); code != http.StatusOK || name != "bob" {
+		t.Fatalf("Expected bob to be let through, got %d, '%s'", code, name)
+	}
+
+	// A valid key doesn't help from outside the allowlist. httptest's
+	// requests come from 192.0.2.1.
+	defer func(nets []*net.IPNet) { AdminAllowlist = nets }(AdminAllowlist)
+	AdminAllowlist, _ = ParseCIDRs("10.0.0.0/8")
+	if code := request("Bearer key2"); code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, code)
+	}
+	AdminAllowlist, _ = ParseCIDRs("192.0.2.0/24")
+	if code := request("Bearer key2"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+}
+
+// TestParseAuditFilter ensures we parse and validate the audit log filter.
+func TestParseAuditFilter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit?from=2021-12-01&to=2021-12-02T12:00:00Z&caller=alice&action=purge&limit=5", nil)
+	f, err := parseAuditFilter(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 12, 2, 12, 0, 0, 0, time.UTC)
+	if !f.From.Equal(from) || !f.To.Equal(to) || f.Caller != "alice" || f.Action != "purge" || f.Limit != 5 {
+		t.Fatalf("Unexpected filter %+v", f)
+	}
+	f, err = parseAuditFilter(httptest.NewRequest(http.MethodGet, "/admin/audit", nil))
+	if err != nil || !f.From.IsZero() || !f.To.IsZero() || f.Limit != defaultAuditLimit {
+		t.Fatalf("Unexpected default filter %+v, %v", f, err)
+	}
+	for _, q := range []string{"from=yesterday", "to=1", "from=2021-12-02&to=2021-12-01", "limit=0", "limit=1001"} {
+		_, err = parseAuditFilter(httptest.NewRequest(http.MethodGet, "/admin/audit?"+q, nil))
+		if err == nil {
+			t.Fatalf("Expected an error for '%s'", q)
+		}
+	}
+}
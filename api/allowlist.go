@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+var (
+	// SubmitAllowlist is the networks which may submit skylinks for
+	// scanning, through /scan and the hooks. Any address may when it's
+	// empty.
+	// Set according to the MALWARE_SCANNER_SUBMIT_ALLOWED_CIDRS env var.
+	SubmitAllowlist []*net.IPNet
+	// AdminAllowlist is the networks which may call the admin endpoints,
+	// on top of presenting an admin key. Any address may when it's empty.
+	// Set according to the MALWARE_SCANNER_ADMIN_ALLOWED_CIDRS env var.
+	AdminAllowlist []*net.IPNet
+)
+
+// ParseCIDRs parses a comma-separated list of networks in CIDR notation, e.g.
+// `10.0.0.0/8`. Plain IP addresses are accepted as networks of their own.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, errors.New("invalid IP address " + c)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, errors.AddContext(err, "invalid network")
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// withSubmitAllowlist wraps the given handler, so it's only accessible from
+// the SubmitAllowlist networks.
+func withSubmitAllowlist(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		if !allowedAddr(req, SubmitAllowlist) {
+			skyapi.WriteError(w, skyapi.Error{"address not allowed to submit skylinks"}, http.StatusForbidden)
+			return
+		}
+		h(w, req, ps)
+	}
+}
+
+// allowedAddr returns whether the request comes from one of the given
+// networks, or any address if there are none. We go by the address of the
+// connection rather than X-Forwarded-For, which the client controls, so the
+// lists must cover the proxies in front of the scanner.
+func allowedAddr(req *http.Request, nets []*net.IPNet) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestParseCIDRs ensures we parse networks and plain addresses and reject
+// invalid ones.
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs(" 10.0.0.0/8, 192.168.1.7 ,::1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 || nets[0].String() != "10.0.0.0/8" || nets[1].String() != "192.168.1.7/32" || nets[2].String() != "::1/128" {
+		t.Fatalf("Unexpected networks %v", nets)
+	}
+	nets, err = ParseCIDRs("")
+	if err != nil || len(nets) != 0 {
+		t.Fatalf("Expected no networks, got %v, %v", nets, err)
+	}
+	for _, s := range []string{"10.0.0.0/33", "localhost", "10.0.0"} {
+		if _, err = ParseCIDRs(s); err == nil {
+			t.Fatalf("Expected an error for '%s'", s)
+		}
+	}
+}
+
+// TestWithSubmitAllowlist ensures only requests from allowlisted networks get
+// through once there's an allowlist.
+func TestWithSubmitAllowlist(t *testing.T) {
+	defer func(nets []*net.IPNet) { SubmitAllowlist = nets }(SubmitAllowlist)
+	h := withSubmitAllowlist(func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {})
+	request := func(addr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/scan/abc", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-Forwarded-For", "10.1.2.3")
+		w := httptest.NewRecorder()
+		h(w, req, nil)
+		return w.Code
+	}
+
+	SubmitAllowlist = nil
+	if code := request("203.0.113.1:1234"); code != http.StatusOK {
+		t.Fatalf("Unexpected status %d without an allowlist", code)
+	}
+	var err error
+	SubmitAllowlist, err = ParseCIDRs("10.0.0.0/8,fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]int{
+		"10.1.2.3:1234":    http.StatusOK,
+		"[fd00::1]:1234":   http.StatusOK,
+		"203.0.113.1:1234": http.StatusForbidden,
+		"[::1]:1234":       http.StatusForbidden,
+		"invalid":          http.StatusForbidden,
+	}
+	for addr, status := range tests {
+		if code := request(addr); code != status {
+			t.Fatalf("Unexpected status %d for '%s', expected %d", code, addr, status)
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/SkynetLabs/malware-scanner/blocker"
 	"github.com/SkynetLabs/malware-scanner/clamav"
 	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/scanner"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/NebulousLabs/errors"
@@ -15,18 +18,33 @@ import (
 type API struct {
 	staticDB     *database.DB
 	staticClamAV *clamav.ClamAV
-	staticRouter *httprouter.Router
-	staticLogger *logrus.Logger
+	// staticBlockers are the blocker targets we report to. There is at
+	// least one.
+	staticBlockers []*blocker.Client
+	staticEvents   *events.Emitter
+	staticHealth   *healthMonitor
+	staticScanner  *scanner.Scanner
+	staticRouter   *httprouter.Router
+	staticLogger   *logrus.Logger
 }
 
 // New creates a new API instance.
-func New(db *database.DB, clam *clamav.ClamAV, logger *logrus.Logger) (*API, error) {
+func New(db *database.DB, clam *clamav.ClamAV, blockers []*blocker.Client, scan *scanner.Scanner, ev *events.Emitter, logger *logrus.Logger) (*API, error) {
 	if db == nil {
 		return nil, errors.New("no DB provided")
 	}
 	if clam == nil {
 		return nil, errors.New("no ClamAV instance provided")
 	}
+	if len(blockers) == 0 {
+		return nil, errors.New("no blocker client provided")
+	}
+	if scan == nil {
+		return nil, errors.New("no scanner provided")
+	}
+	if ev == nil {
+		return nil, errors.New("no events emitter provided")
+	}
 	if logger == nil {
 		return nil, errors.New("no logger provided")
 	}
@@ -34,11 +52,16 @@ func New(db *database.DB, clam *clamav.ClamAV, logger *logrus.Logger) (*API, err
 	router.RedirectTrailingSlash = true
 
 	api := &API{
-		staticDB:     db,
-		staticClamAV: clam,
-		staticRouter: router,
-		staticLogger: logger,
+		staticDB:       db,
+		staticClamAV:   clam,
+		staticBlockers: blockers,
+		staticEvents:   ev,
+		staticHealth:   newHealthMonitor(),
+		staticScanner:  scan,
+		staticRouter:   router,
+		staticLogger:   logger,
 	}
+	router.PanicHandler = api.panicHandler
 
 	api.buildHTTPRoutes()
 	return api, nil
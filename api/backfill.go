@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// actionBackfill is the audited action of starting a backfill.
+	actionBackfill = "backfill"
+)
+
+type (
+	// backfillRequest is the body of requests to start a backfill.
+	backfillRequest struct {
+		Source string `json:"source"`
+		Total  int64  `json:"total"`
+	}
+
+	// backfillBatchRequest is the body of requests to enqueue a batch of a
+	// backfill. The batch covers the backfill's source from From up to To.
+	// Failed counts the skylinks of it the caller already failed to parse.
+	backfillBatchRequest struct {
+		From     int64    `json:"from"`
+		To       int64    `json:"to"`
+		Failed   int64    `json:"failed"`
+		Skylinks []string `json:"skylinks"`
+	}
+
+	// backfillBatchResponse is the response to requests to enqueue a batch
+	// of a backfill. Invalid lists the skylinks of the batch which weren't
+	// enqueued because they're invalid.
+	backfillBatchResponse struct {
+		Job     *database.BackfillJob `json:"job"`
+		Invalid []string              `json:"invalid"`
+	}
+)
+
+// adminBackfillPOST starts a backfill job, which tracks the progress of a
+// bulk submission of skylinks.
+func (api *API) adminBackfillPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req backfillRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBulkStatusBodySize)).Decode(&req)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid request body: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if req.Total < 0 {
+		skyapi.WriteError(w, skyapi.Error{"total must not be negative"}, http.StatusBadRequest)
+		return
+	}
+	job, err := api.staticDB.BackfillCreate(r.Context(), req.Source, req.Total)
+	params := map[string]string{"source": req.Source, "total": strconv.FormatInt(req.Total, 10)}
+	if err == nil {
+		params["id"] = job.ID.Hex()
+	}
+	api.audit(r, actionBackfill, params, err)
+	if err != nil {
+		api.staticLogger.Warnf("adminBackfillPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, job)
+}
+
+// adminBackfillGET returns the progress of a backfill job.
+func (api *API) adminBackfillGET(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid backfill ID"}, http.StatusBadRequest)
+		return
+	}
+	job, err := api.staticDB.Backfill(r.Context(), id)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"backfill not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminBackfillGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, job)
+}
+
+// adminBackfillSkylinksPOST enqueues a batch of up to 1000 skylinks of a
+// backfill job at low priority and records the job's progress. The batch
+// must start where the job left off, otherwise it's rejected with a 409 and
+// the job, so callers can resume from the job's offset.
+func (api *API) adminBackfillSkylinksPOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid backfill ID"}, http.StatusBadRequest)
+		return
+	}
+	var req backfillBatchRequest
+	err = json.NewDecoder(io.LimitReader(r.Body, maxBulkStatusBodySize)).Decode(&req)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid request body: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if len(req.Skylinks) > maxBulkStatusSkylinks {
+		skyapi.WriteError(w, skyapi.Error{fmt.Sprintf("at most %d skylinks can be provided", maxBulkStatusSkylinks)}, http.StatusBadRequest)
+		return
+	}
+	if req.From < 0 || req.To < req.From || req.Failed < 0 {
+		skyapi.WriteError(w, skyapi.Error{"invalid batch range"}, http.StatusBadRequest)
+		return
+	}
+	invalid := []string{}
+	sls := make([]*database.Skylink, 0, len(req.Skylinks))
+	for _, s := range req.Skylinks {
+		sl, err := parseSkylink(s, api.staticClamAV.PreferredPortal())
+		if err != nil {
+			invalid = append(invalid, s)
+			continue
+		}
+		sls = append(sls, sl)
+	}
+	failed := req.Failed + int64(len(invalid))
+	job, err := api.staticDB.BackfillEnqueue(r.Context(), id, sls, failed, req.From, req.To)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"backfill not found"}, http.StatusNotFound)
+		return
+	}
+	if errors.Contains(err, database.ErrBackfillOffset) {
+		msg := err.Error()
+		if job != nil {
+			msg = fmt.Sprintf("%s %d", msg, job.Offset)
+		}
+		skyapi.WriteError(w, skyapi.Error{msg}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminBackfillSkylinksPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, backfillBatchResponse{Job: job, Invalid: invalid})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// actionCampaign is the audited action of starting a re-scan campaign.
+	actionCampaign = "campaign"
+
+	// maxCampaignBodySize is the maximum size of a campaign request's body.
+	maxCampaignBodySize = 1 << 12
+)
+
+// adminCampaignPOST starts a re-scan campaign, which queues the records that
+// match the given criteria for scanning again at low priority.
+func (api *API) adminCampaignPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var criteria database.CampaignCriteria
+	err := json.NewDecoder(io.LimitReader(r.Body, maxCampaignBodySize)).Decode(&criteria)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid request body: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if criteria.MaxSize > 0 && criteria.MinSize > criteria.MaxSize {
+		skyapi.WriteError(w, skyapi.Error{"minSize must not be more than maxSize"}, http.StatusBadRequest)
+		return
+	}
+	c, err := api.staticDB.CampaignCreate(r.Context(), criteria)
+	params := campaignParams(criteria)
+	if err == nil {
+		params["id"] = c.ID.Hex()
+		params["queued"] = strconv.FormatInt(c.Queued, 10)
+	}
+	api.audit(r, actionCampaign, params, err)
+	if err != nil {
+		api.staticLogger.Warnf("adminCampaignPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	api.staticLogger.Infof("Campaign %s queued %d records for rescan, skipped %d", c.ID.Hex(), c.Queued, c.Skipped)
+	skyapi.WriteJSON(w, c)
+}
+
+// adminCampaignGET returns a re-scan campaign with the summary of its progress
+// and results.
+func (api *API) adminCampaignGET(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid campaign ID"}, http.StatusBadRequest)
+		return
+	}
+	c, err := api.staticDB.Campaign(r.Context(), id)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"campaign not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminCampaignGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, c)
+}
+
+// campaignParams returns the audit log params of the given criteria.
+func campaignParams(c database.CampaignCriteria) map[string]string {
+	params := make(map[string]string)
+	if c.ScannedBefore != nil {
+		params["scannedBefore"] = c.ScannedBefore.UTC().Format(time.RFC3339)
+	}
+	if c.MinSize > 0 {
+		params["minSize"] = strconv.FormatUint(c.MinSize, 10)
+	}
+	if c.MaxSize > 0 {
+		params["maxSize"] = strconv.FormatUint(c.MaxSize, 10)
+	}
+	if c.Infected != nil {
+		params["infected"] = strconv.FormatBool(*c.Infected)
+	}
+	return params
+}
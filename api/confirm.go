@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// ConfirmationSelf makes destructive admin actions require a second
+	// call with the confirmation token, by any admin.
+	ConfirmationSelf = "self"
+	// ConfirmationTwoPerson makes destructive admin actions require a
+	// second call with the confirmation token by another admin.
+	ConfirmationTwoPerson = "two-person"
+)
+
+var (
+	// AdminConfirmation is the kind of confirmation destructive admin
+	// actions require, ConfirmationSelf or ConfirmationTwoPerson. They
+	// don't require any if it's empty.
+	// Set according to the MALWARE_SCANNER_ADMIN_CONFIRMATION env var.
+	AdminConfirmation string
+	// ConfirmationTTL is how long a destructive admin action can be
+	// confirmed after it was requested.
+	// Set according to the MALWARE_SCANNER_ADMIN_CONFIRMATION_TTL env var.
+	ConfirmationTTL = 10 * time.Minute
+)
+
+type (
+	// requesterKey is the context key under which we store the name of the
+	// admin who requested a confirmed action.
+	requesterKey struct{}
+
+	// confirmationResponse is the response to destructive admin actions
+	// which wait for confirmation. Repeating the request with the token in
+	// the `confirmation` parameter confirms the action.
+	confirmationResponse struct {
+		Confirmation string    `json:"confirmation"`
+		ExpiresAt    time.Time `json:"expiresAt"`
+	}
+)
+
+// ParseConfirmationMode validates the given kind of admin confirmation.
+func ParseConfirmationMode(s string) (string, error) {
+	switch s {
+	case "", ConfirmationSelf, ConfirmationTwoPerson:
+		return s, nil
+	}
+	return "", errors.New("admin confirmation must be empty, " + ConfirmationSelf + " or " + ConfirmationTwoPerson)
+}
+
+// withConfirmation wraps the handler of the given destructive admin action,
+// so it only runs once it's confirmed, as AdminConfirmation requires. The
+// first request responds with a confirmation token and only repeating it
+// with the token runs the action. Pending actions are stored in the DB, so
+// they can be confirmed through any instance. It must be wrapped by
+// withAdmin.
+func (api *API) withConfirmation(action string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if AdminConfirmation == "" {
+			h(w, r, ps)
+			return
+		}
+		// The path holds the skylink, which stays out of the DB in
+		// privacy mode.
+		path := logging.Redact(r.URL.Path)
+		query := confirmationQuery(r.URL.Query())
+		token := r.URL.Query().Get("confirmation")
+		if token == "" {
+			api.requestConfirmation(w, r, action, path, query)
+			return
+		}
+		var exclude string
+		if AdminConfirmation == ConfirmationTwoPerson {
+			exclude = caller(r)
+		}
+		c, err := api.staticDB.Confirm(r.Context(), confirmationID(token), r.Method, path, query, exclude)
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			msg := "invalid or expired confirmation"
+			if exclude != "" {
+				msg += ", or it's not confirmed by another admin"
+			}
+			skyapi.WriteError(w, skyapi.Error{msg}, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			api.staticLogger.Warnf("withConfirmation failed: %s", err)
+			skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), requesterKey{}, c.Requester)
+		h(w, r.WithContext(ctx), ps)
+	}
+}
+
+// requestConfirmation stores the given action with the given path and query as
+// waiting for confirmation and responds with the confirmation token.
+func (api *API) requestConfirmation(w http.ResponseWriter, r *http.Request, action, path, query string) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(b)
+	c := database.Confirmation{
+		ID:        confirmationID(token),
+		Action:    action,
+		Method:    r.Method,
+		Path:      path,
+		Query:     query,
+		Requester: caller(r),
+		ExpiresAt: database.Clock.Now().Add(ConfirmationTTL),
+	}
+	err = api.staticDB.RequestConfirmation(r.Context(), c)
+	api.audit(r, actionRequestConfirmation, map[string]string{"action": action, "path": path}, err)
+	if err != nil {
+		api.staticLogger.Warnf("requestConfirmation failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(confirmationResponse{Confirmation: token, ExpiresAt: c.ExpiresAt.UTC()})
+}
+
+// confirmationQuery returns the canonical form of the given query without the
+// confirmation token, which binds a confirmation to the action's parameters.
+// Skylinks are redacted like in the path.
+func confirmationQuery(q url.Values) string {
+	c := make(url.Values, len(q))
+	for k, v := range q {
+		if k != "confirmation" {
+			c[k] = v
+		}
+	}
+	return logging.Redact(c.Encode())
+}
+
+// confirmationID returns the ID under which the confirmation with the given
+// token is stored.
+func confirmationID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// requester returns the name of the admin who requested the confirmed action
+// the request performs, if it's one.
+func requester(req *http.Request) (string, bool) {
+	name, ok := req.Context().Value(requesterKey{}).(string)
+	return name, ok
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestParseConfirmationMode ensures we accept the known kinds of admin
+// confirmation only.
+func TestParseConfirmationMode(t *testing.T) {
+	for _, s := range []string{"", ConfirmationSelf, ConfirmationTwoPerson} {
+		if mode, err := ParseConfirmationMode(s); err != nil || mode != s {
+			t.Fatalf("Unexpected mode %s, %v", mode, err)
+		}
+	}
+	if _, err := ParseConfirmationMode("always"); err == nil {
+		t.Fatal("Expected an error for an unknown mode")
+	}
+}
+
+// TestWithConfirmationDisabled ensures destructive actions run right away
+// unless confirmation is required.
+func TestWithConfirmationDisabled(t *testing.T) {
+	defer func(mode string) { AdminConfirmation = mode }(AdminConfirmation)
+	AdminConfirmation = ""
+	var ran bool
+	api := &API{}
+	h := api.withConfirmation(actionPurge, func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		ran = true
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/skylink/abc", nil), nil)
+	if !ran {
+		t.Fatal("Expected the action to run")
+	}
+}
+
+// TestConfirmationQuery ensures confirmations are bound to the action's
+// parameters in a canonical order, but not to the confirmation token.
+func TestConfirmationQuery(t *testing.T) {
+	q := confirmationQuery(url.Values{"reason": {"upstream"}, "confirmation": {"c0ffee"}, "force": {"true"}})
+	if q != "force=true&reason=upstream" {
+		t.Fatalf("Unexpected query '%s'", q)
+	}
+	if q == confirmationQuery(url.Values{"reason": {"other"}, "force": {"true"}}) {
+		t.Fatal("Expected other parameters to change the query")
+	}
+	if q = confirmationQuery(url.Values{"confirmation": {"c0ffee"}}); q != "" {
+		t.Fatalf("Unexpected query '%s'", q)
+	}
+}
+
+// TestConfirmationID ensures confirmations are stored under a hash of their
+// token, which differs between tokens.
+func TestConfirmationID(t *testing.T) {
+	id := confirmationID("token")
+	if id == "token" || len(id) != 64 || id == confirmationID("other") {
+		t.Fatalf("Unexpected ID %s", id)
+	}
+}
+
+// TestRequester ensures we only report a requester for confirmed actions.
+func TestRequester(t *testing.T) {
+	req := httptest.NewRequest(http.MethodDelete, "/admin/skylink/abc", nil)
+	if _, ok := requester(req); ok {
+		t.Fatal("Expected no requester")
+	}
+	req = req.WithContext(context.WithValue(req.Context(), requesterKey{}, "alice"))
+	if name, ok := requester(req); !ok || name != "alice" {
+		t.Fatalf("Unexpected requester %s", name)
+	}
+}
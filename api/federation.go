@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// defaultVerdictsLimit is the number of verdicts /federation/verdicts
+	// returns by default.
+	defaultVerdictsLimit = 1000
+	// maxVerdictsLimit is the maximum number of verdicts
+	// /federation/verdicts can return.
+	maxVerdictsLimit = 10000
+)
+
+var (
+	// FederationKeys maps the API keys which federated scanner instances use
+	// to pull our verdicts to the names of the instances. Federation is
+	// disabled when it's empty.
+	// Set according to the MALWARE_SCANNER_FEDERATION_KEYS env var.
+	FederationKeys map[string]string
+)
+
+type (
+	// verdictsResponse is the response to federation verdicts requests.
+	verdictsResponse struct {
+		Verdicts []database.PeerVerdict `json:"verdicts"`
+	}
+)
+
+// withFederation wraps the given handler, so it's only accessible with one of
+// the FederationKeys passed as a bearer token.
+func withFederation(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		if len(FederationKeys) == 0 {
+			skyapi.WriteError(w, skyapi.Error{"federation is disabled"}, http.StatusForbidden)
+			return
+		}
+		if _, ok := keyHolder(req, FederationKeys); !ok {
+			skyapi.WriteError(w, skyapi.Error{"invalid federation key"}, http.StatusUnauthorized)
+			return
+		}
+		h(w, req, ps)
+	}
+}
+
+// federationVerdictsGET returns the verdicts of our own scans reached after
+// the given `since` time, oldest first, so federated scanner instances can
+// skip content we've already scanned.
+func (api *API) federationVerdictsGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	p := newParams(r)
+	since := p.Time("since", time.Time{})
+	limit := p.Int("limit", defaultVerdictsLimit, 1, maxVerdictsLimit)
+	if p.invalid(w) {
+		return
+	}
+	verdicts, err := api.staticDB.VerdictsSince(r.Context(), since, limit)
+	if err != nil {
+		api.staticLogger.Warnf("federationVerdictsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, verdictsResponse{verdicts})
+}
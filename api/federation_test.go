@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestWithFederation ensures withFederation only lets through requests with a
+// valid federation key.
+func TestWithFederation(t *testing.T) {
+	defer func(keys map[string]string) { FederationKeys = keys }(FederationKeys)
+	defer func(keys map[string]string) { AdminKeys = keys }(AdminKeys)
+	h := withFederation(func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {})
+	request := func(auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, req, nil)
+		return w.Code
+	}
+
+	// Federation is disabled without keys.
+	FederationKeys = nil
+	if code := request("Bearer key1"); code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, code)
+	}
+
+	// Admin keys don't grant access to federation endpoints.
+	FederationKeys = map[string]string{"key1": "eu"}
+	AdminKeys = map[string]string{"admin": "alice"}
+	for _, auth := range []string{"", "key1", "Bearer key2", "Bearer admin"} {
+		if code := request(auth); code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d for '%s', got %d", http.StatusUnauthorized, auth, code)
+		}
+	}
+	if code := request("Bearer key1"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,353 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.sia.tech/siad/crypto"
+)
+
+// updateGolden regenerates the golden files instead of comparing against
+// them: go test ./api -run Golden -update
+var updateGolden = flag.Bool("update", false, "update the golden files of the API responses")
+
+var (
+	// goldenTime is the timestamp used throughout the golden responses.
+	goldenTime = time.Date(2021, 12, 1, 10, 20, 30, 0, time.UTC)
+	// goldenHash is the skylink hash used throughout the golden responses.
+	goldenHash = crypto.HashBytes([]byte("golden"))
+	// goldenSkylink is the skylink used throughout the golden responses.
+	goldenSkylink = "AACogzrAimYPG42tDOKhS3lXZD8YvlF8Q8R17afe95iV2Q"
+)
+
+// checkGolden compares the status and body of the given response with the
+// golden file of the given name, or writes the golden file if -update is set.
+func checkGolden(t *testing.T, name string, w *httptest.ResponseRecorder) {
+	t.Helper()
+	var body bytes.Buffer
+	if err := json.Indent(&body, bytes.TrimSpace(w.Body.Bytes()), "", "  "); err != nil {
+		t.Fatalf("%s: response isn't JSON: %v\n%s", name, err, w.Body.String())
+	}
+	got := fmt.Sprintf("%d\n%s\n", w.Code, body.String())
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s: failed to read golden file, run with -update to create it: %v", name, err)
+	}
+	if got != string(expected) {
+		t.Fatalf("%s: response differs from %s, run with -update if the change is intended\nexpected:\n%s\ngot:\n%s", name, path, expected, got)
+	}
+}
+
+// TestGoldenResponses ensures the success responses of the API keep their
+// shape.
+func TestGoldenResponses(t *testing.T) {
+	userID, err := primitive.ObjectIDFromHex("61a74c6e3df8d57bd7d05fdd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl := database.Skylink{
+		Hash:                 goldenHash,
+		Skylink:              goldenSkylink,
+		Status:               database.SkylinkStatusComplete,
+		Infected:             true,
+		InfectionDescription: "Win.Test.EICAR_HDB-1",
+		ScannedAllContent:    true,
+		ScannedAllOffsets:    true,
+		Size:                 68,
+		ScannedSize:          68,
+		Timestamp:            goldenTime,
+		SubmittedAt:          goldenTime,
+		ScannedAt:            goldenTime,
+		Blocker: &database.BlockerResponse{
+			Result:     database.BlockerResultBlocked,
+			StatusCode: http.StatusOK,
+			ReportedAt: goldenTime,
+		},
+		SignatureVersion: 26391,
+		Filename:         "eicar.com",
+		ContentType:      "application/octet-stream",
+		Source:           database.SourceUser,
+		Submitter:        "key:0123456789abcdef",
+		Reporter:         "abuse-scanner:abuse@example.com",
+		Uploaders:        []database.Uploader{{UserID: userID, Sub: "sub", Email: "user@example.com", Uploads: 1, FirstUpload: goldenTime, LastUpload: goldenTime}},
+	}
+	portals := []clamav.PortalStats{{
+		Portal:          "https://siasky.net",
+		Requests:        10,
+		Failures:        1,
+		SuccessRate:     0.9,
+		AvgLatency:      0.25,
+		BytesDownloaded: 680,
+	}}
+	jobID, err := primitive.ObjectIDFromHex("61a74c46a1b2c3d4e5f60718")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backfill := database.BackfillJob{
+		ID:        jobID,
+		Source:    "skylinks.txt",
+		Total:     1000,
+		Offset:    500,
+		Enqueued:  498,
+		Failed:    2,
+		Completed: 120,
+		CreatedAt: goldenTime,
+		UpdatedAt: goldenTime,
+	}
+	clean := false
+	review := sl
+	review.Status = database.SkylinkStatusPendingReview
+	review.Blocker = nil
+	review.Severity = 40
+	review.PolicyAction = database.PolicyActionReview
+	si := clamav.SignatureInfo{Engine: "0.104.1", Version: 26391, Date: goldenTime}
+	tests := []struct {
+		name string
+		resp interface{}
+	}{
+		{"health", healthResponse{
+			DBAlive:       true,
+			ClamAVAlive:   true,
+			DBHistory:     dependencyHealth{Checks: 10, Uptime: 1},
+			ClamAVHistory: dependencyHealth{Checks: 10, Uptime: 0.9, Flaps: 1, LastFailure: &goldenTime, LastError: "connection refused"},
+			Signatures:    signatureHealth{SignatureInfo: &si, AgeDays: 1.5},
+		}},
+		{"stats", statsResponse{
+			ScanStats: &database.ScanStats{
+				Since:        goldenTime,
+				Records:      1,
+				BytesScanned: 68,
+				Hourly:       []database.HourlyThroughput{{Hour: goldenTime.Truncate(time.Hour), Records: 1, BytesScanned: 68}},
+				Latency:      database.LatencyPercentiles{P50: 1, P90: 2, P95: 3, P99: 4, Max: 5},
+				SLATarget:    300,
+				WithinSLA:    1,
+			},
+			Anomaly: &database.Anomaly{Window: 3600, Baseline: 86400, RecentScanned: 100, RecentInfected: 1, RecentRate: 0.01, BaselineRate: 0.01},
+			Queue:   &database.QueueDepth{New: 1, Scanning: 2, Unreported: 3, PendingReview: 1, Total: 10},
+			Portals: portals,
+		}},
+		{"stats_signatures", signatureStatsResponse{
+			From:       goldenTime.Add(-24 * time.Hour),
+			To:         goldenTime,
+			Signatures: []database.SignatureCount{{Signature: "Win.Test.EICAR_HDB-1", Count: 2, FirstSeen: goldenTime, LastSeen: goldenTime}},
+		}},
+		{"scan", scanResponse{statusQueued}},
+		{"status", statusRecord(sl, false)},
+		{"status_admin", statusRecord(sl, true)},
+		{"status_hash", hashStatusRecord(sl, false)},
+		{"bulk_status", bulkStatusResponse{
+			Statuses: map[string]database.Skylink{goldenSkylink: statusRecord(sl, false)},
+			NotFound: []string{"_A2zt5LQgEp9-HPKS9D2J8ZgX2Dx8JjQyEKp0F9GzpTMBw"},
+			Invalid:  []string{"not-a-skylink"},
+		}},
+		{"upload_hook", uploadHookResponse{Queued: 1, Duplicate: 1, Invalid: []string{"not-a-skylink"}}},
+		{"signature_hook", signatureHookResponse{
+			SignatureUpdate: database.SignatureUpdate{Version: 26391, Date: goldenTime, Source: "freshclam", ReceivedAt: goldenTime},
+			New:             true,
+		}},
+		{"federation_verdicts", verdictsResponse{
+			Verdicts: []database.PeerVerdict{{Hash: goldenHash, Infected: true, InfectionDescription: "Win.Test.EICAR_HDB-1", ScannedAt: goldenTime}},
+		}},
+		{"debug_state", debugStateResponse{
+			State: scanner.State{
+				Loops:                 map[string]scanner.LoopState{"scan": {Running: true, Iterations: 3, LastRun: goldenTime}},
+				BlockerFailures:       1,
+				BlockerTargetFailures: map[string]int{"default": 1},
+			},
+			InFlight: []clamav.ScanProgress{{Skylink: goldenSkylink, Portal: "https://siasky.net", Started: goldenTime, Size: 68, ScannedBytes: 34}},
+			Portals:  portals,
+			Config: debugConfig{
+				Blocker:           "http://blocker:4000",
+				BlockerTargets:    map[string]string{"default": "http://blocker:4000"},
+				ScanTimeout:       "10m0s",
+				SLATarget:         "5m0s",
+				SlowScanThreshold: "1m0s",
+				AnomalyWindow:     "1h0m0s",
+				AnomalyBaseline:   "24h0m0s",
+				AnomalyThreshold:  3,
+				AnomalyMinScans:   100,
+				SignatureMaxAge:   "72h0m0s",
+				LogSampleBurst:    10,
+				LogSampleInterval: "1m0s",
+			},
+		}},
+		{"admin_confirmation", confirmationResponse{Confirmation: "c0ffee", ExpiresAt: goldenTime}},
+		{"admin_falsepositive", falsePositiveResponse{WasReported: true, Unblocked: false, UnblockError: "blocker default: endpoint not supported by blocker"}},
+		{"admin_blocker", blockerStatusResponse{Blocked: true}},
+		{"admin_audit", auditResponse{
+			Entries: []database.AuditEntry{{Timestamp: goldenTime, Caller: "alice", Action: actionRescan, Params: map[string]string{"hash": goldenHash.String()}}},
+		}},
+		{"admin_reports", reportLogResponse{
+			Entries: []database.ReportLogEntry{{
+				Seq:         1,
+				Timestamp:   goldenTime,
+				Action:      database.ReportActionBlock,
+				Target:      "default",
+				TargetURL:   "http://blocker:4000",
+				SkylinkHash: goldenHash.String(),
+				Skylink:     goldenSkylink,
+				Result:      database.BlockerResultBlocked,
+			}},
+		}},
+		{"admin_reports_verify", reportLogVerifyResponse{Verified: 1, Head: goldenHash.String(), Intact: true}},
+		{"admin_uploaders", uploadersResponse{
+			Uploaders: []database.RepeatUploader{{UserID: userID, Sub: "sub", Email: "user@example.com", InfectedSkylinks: 3, LastDetected: goldenTime}},
+		}},
+		{"admin_bans", bansResponse{
+			Bans: []database.SubmitterBan{{Submitter: "ip:192.0.2.1", Reason: banReasonQuota, BannedAt: goldenTime, Until: goldenTime.Add(SubmissionBanDuration)}},
+		}},
+		{"admin_export", database.Snapshot{
+			ExportedAt: goldenTime,
+			Records: []database.RecordSnapshot{{
+				Record: sl,
+				Audit:  []database.AuditEntry{{Timestamp: goldenTime, Caller: "alice", Action: actionRescan, Params: map[string]string{"hash": goldenHash.String()}}},
+				Reports: []database.ReportLogEntry{{
+					Seq:         1,
+					Timestamp:   goldenTime,
+					Action:      database.ReportActionBlock,
+					Target:      "default",
+					TargetURL:   "http://blocker:4000",
+					SkylinkHash: goldenHash.String(),
+					Skylink:     goldenSkylink,
+					Result:      database.BlockerResultBlocked,
+				}},
+			}},
+		}},
+		{"admin_import", importResponse{Imported: 1}},
+		{"admin_backfill", backfill},
+		{"admin_backfill_skylinks", backfillBatchResponse{Job: &backfill, Invalid: []string{"not-a-skylink"}}},
+		{"admin_signature_withdrawals", signatureWithdrawalsResponse{
+			Withdrawals: []database.SignatureWithdrawal{{Signature: "Win.Test.EICAR_HDB-1", Reason: "upstream false positive", Caller: "alice", WithdrawnAt: goldenTime, Queued: 3, Skipped: 1, Pending: 2}},
+		}},
+		{"admin_campaign", database.Campaign{
+			ID:        jobID,
+			Criteria:  database.CampaignCriteria{ScannedBefore: &goldenTime, MaxSize: 1 << 20, Infected: &clean},
+			Queued:    100,
+			Skipped:   20,
+			CreatedAt: goldenTime,
+			Summary:   database.CampaignSummary{Pending: 40, Completed: 60, Infected: 1, NewlyInfected: 1},
+		}},
+		{"admin_reviews", reviewsResponse{Reviews: []database.Skylink{privateSkylink(review)}}},
+		{"graphql", graphQLResponse{Errors: []graphQLError{{Message: "unknown field"}}}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		skyapi.WriteJSON(w, tt.resp)
+		checkGolden(t, tt.name, w)
+	}
+}
+
+// TestGoldenErrors ensures the error responses of the API keep their shape.
+// It only covers the errors which are returned before the DB is queried.
+func TestGoldenErrors(t *testing.T) {
+	defer func(keys map[string]string) { AdminKeys = keys }(AdminKeys)
+	defer func(keys map[string]string) { FederationKeys = keys }(FederationKeys)
+	defer func(token string) { UploadHookToken = token }(UploadHookToken)
+	defer func(token string) { SignatureHookToken = token }(SignatureHookToken)
+	defer func(enabled bool) { ImportEnabled = enabled }(ImportEnabled)
+	AdminKeys = map[string]string{"admin-key": "alice"}
+	UploadHookToken = "upload-token"
+	SignatureHookToken = "signature-token"
+	ImportEnabled = true
+
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	ip, port := mc.Addr()
+	clam, err := clamav.New(ip, port, "http://portal.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = clam.Close() }()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	api := &API{
+		staticClamAV: clam,
+		staticHealth: newHealthMonitor(),
+		staticRouter: httprouter.New(),
+		staticLogger: logger,
+	}
+	api.staticRouter.PanicHandler = api.panicHandler
+	api.buildHTTPRoutes()
+
+	admin := "Bearer admin-key"
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		body   string
+		// noFederation disables federation for the request.
+		noFederation bool
+	}{
+		{"error_stats_signatures_params", http.MethodGet, "/stats/signatures?from=yesterday&limit=0", "", "", false},
+		{"error_status_skylink", http.MethodGet, "/status/not-a-skylink", "", "", false},
+		{"error_scan_root_length", http.MethodPost, "/scanroot/82a925be13a9d970a4bda34ed67c8e5be179a499e39895b15ff081d62a317ec8", "", "", false},
+		{"error_scan_root_root", http.MethodPost, "/scanroot/not-a-root?length=4096", "", "", false},
+		{"error_bulk_status_body", http.MethodPost, "/status", "", "{", false},
+		{"error_bulk_status_empty", http.MethodPost, "/status", "", `{"skylinks":[]}`, false},
+		{"error_upload_hook_token", http.MethodPost, "/hooks/upload", "Bearer wrong", "{}", false},
+		{"error_signature_hook_token", http.MethodPost, "/hooks/signatures", "Bearer wrong", "{}", false},
+		{"error_federation_disabled", http.MethodGet, "/federation/verdicts", "Bearer peer-key", "", true},
+		{"error_federation_key", http.MethodGet, "/federation/verdicts", "Bearer wrong", "", false},
+		{"error_federation_params", http.MethodGet, "/federation/verdicts?since=yesterday", "Bearer peer-key", "", false},
+		{"error_admin_key", http.MethodGet, "/debug/state", "Bearer wrong", "", false},
+		{"error_admin_blocker_skylink", http.MethodGet, "/admin/blocker/not-a-skylink", admin, "", false},
+		{"error_admin_audit_params", http.MethodGet, "/admin/audit?limit=0", admin, "", false},
+		{"error_admin_reports_params", http.MethodGet, "/admin/reports?after=-1", admin, "", false},
+		{"error_admin_uploaders_params", http.MethodGet, "/admin/uploaders?min=0", admin, "", false},
+		{"error_admin_ban_submitter", http.MethodDelete, "/admin/bans/nobody", admin, "", false},
+		{"error_admin_export_skylink", http.MethodPost, "/admin/export", admin, `{"skylinks":["not-a-skylink"]}`, false},
+		{"error_admin_import_hash", http.MethodPost, "/admin/import", admin, `{"records":[{"record":{"status":"new"}}]}`, false},
+		{"error_admin_backfill_total", http.MethodPost, "/admin/backfills", admin, `{"source":"skylinks.txt","total":-1}`, false},
+		{"error_admin_backfill_id", http.MethodGet, "/admin/backfills/not-an-id", admin, "", false},
+		{"error_admin_withdraw_signature_reason", http.MethodPost, "/admin/signatures/Win.Test.EICAR_HDB-1/withdraw?reason=%01", admin, "", false},
+		{"error_admin_reviews_params", http.MethodGet, "/admin/reviews?limit=0", admin, "", false},
+		{"error_admin_campaign_size", http.MethodPost, "/admin/campaigns", admin, `{"minSize":10,"maxSize":5}`, false},
+		{"error_admin_campaign_id", http.MethodGet, "/admin/campaigns/not-an-id", admin, "", false},
+		{"error_admin_backfill_range", http.MethodPost, "/admin/backfills/61a74c46a1b2c3d4e5f60718/skylinks", admin, `{"from":10,"to":5}`, false},
+	}
+	for _, tt := range tests {
+		FederationKeys = map[string]string{"peer-key": "peer"}
+		if tt.noFederation {
+			FederationKeys = nil
+		}
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		api.staticRouter.ServeHTTP(w, r)
+		checkGolden(t, tt.name, w)
+	}
+}
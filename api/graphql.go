@@ -0,0 +1,376 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/graphql"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultGraphQLPageSize is the number of skylinks we return per page
+	// by default.
+	defaultGraphQLPageSize = 50
+	// maxGraphQLPageSize is the maximum number of skylinks we return per
+	// page.
+	maxGraphQLPageSize = 500
+	// maxGraphQLBodySize is the maximum size of a GraphQL request.
+	maxGraphQLBodySize = 1 << 16
+)
+
+var (
+	// GraphQLEnabled enables the read-only GraphQL endpoint over the scan
+	// records. It's only open to admins.
+	// Set according to the MALWARE_SCANNER_GRAPHQL env var.
+	GraphQLEnabled bool
+
+	// skylinkFields are the fields of the Skylink GraphQL type, which are the
+	// JSON fields of a skylink record.
+	skylinkFields = jsonFields(reflect.TypeOf(database.Skylink{}))
+)
+
+type (
+	// graphQLRequest is a GraphQL request, as sent by GraphQL clients.
+	graphQLRequest struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}
+
+	// graphQLResponse is a GraphQL response.
+	graphQLResponse struct {
+		Data   map[string]interface{} `json:"data,omitempty"`
+		Errors []graphQLError         `json:"errors,omitempty"`
+	}
+
+	// graphQLError is an error in a GraphQL response.
+	graphQLError struct {
+		Message string `json:"message"`
+	}
+
+	// queryError is returned for queries which don't match the schema.
+	queryError string
+)
+
+// Error implements error.
+func (e queryError) Error() string {
+	return string(e)
+}
+
+// graphQLPOST executes a read-only GraphQL query over the scan records. The
+// schema is:
+//
+//	type Query {
+//	  skylinks(status: String, infected: Boolean, falsePositive: Boolean,
+//	    scannedFrom: String, scannedTo: String, submittedFrom: String,
+//	    submittedTo: String, first: Int, after: String): SkylinkConnection
+//	}
+//	type SkylinkConnection {
+//	  totalCount: Int
+//	  nodes: [Skylink]
+//	  pageInfo: PageInfo
+//	}
+//	type PageInfo { endCursor: String, hasNextPage: Boolean }
+//
+// Skylink has the same fields as the records returned by /status. Times are
+// RFC 3339 timestamps or dates. Records are returned newest first.
+func (api *API) graphQLPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req graphQLRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if v := r.FormValue("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeGraphQLError(w, errors.New("invalid variables"), http.StatusBadRequest)
+				return
+			}
+		}
+	} else if err := json.NewDecoder(io.LimitReader(r.Body, maxGraphQLBodySize)).Decode(&req); err != nil {
+		writeGraphQLError(w, errors.New("invalid GraphQL request"), http.StatusBadRequest)
+		return
+	}
+	fields, err := graphql.Parse(req.Query, req.Variables, req.OperationName)
+	if err != nil {
+		writeGraphQLError(w, err, http.StatusBadRequest)
+		return
+	}
+	data, err := api.resolveQuery(r.Context(), fields)
+	if _, ok := err.(queryError); ok {
+		writeGraphQLError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("graphQLPOST failed: %s", err)
+		writeGraphQLError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(graphQLResponse{Data: data})
+}
+
+// resolveQuery resolves the fields selected on the Query type.
+func (api *API) resolveQuery(ctx context.Context, fields []*graphql.Field) (map[string]interface{}, error) {
+	data := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		switch f.Name {
+		case "__typename":
+			data[f.Alias] = "Query"
+		case "skylinks":
+			v, err := api.resolveSkylinks(ctx, f)
+			if err != nil {
+				return nil, err
+			}
+			data[f.Alias] = v
+		default:
+			return nil, invalidQueryf("cannot query field %s on type Query", f.Name)
+		}
+	}
+	return data, nil
+}
+
+// resolveSkylinks resolves the skylinks field into a SkylinkConnection.
+func (api *API) resolveSkylinks(ctx context.Context, f *graphql.Field) (map[string]interface{}, error) {
+	if len(f.Fields) == 0 {
+		return nil, invalidQueryf("field skylinks must have a selection")
+	}
+	filter, err := skylinkFilter(f.Args)
+	if err != nil {
+		return nil, err
+	}
+	// Only fetch what the query selects.
+	var sls []database.Skylink
+	var hasNextPage bool
+	if selects(f.Fields, "nodes") || selects(f.Fields, "pageInfo") {
+		limit := filter.Limit
+		filter.Limit++
+		sls, err = api.staticDB.FindSkylinks(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(sls) > limit {
+			sls = sls[:limit]
+			hasNextPage = true
+		}
+	}
+	conn := make(map[string]interface{}, len(f.Fields))
+	for _, sub := range f.Fields {
+		switch sub.Name {
+		case "__typename":
+			conn[sub.Alias] = "SkylinkConnection"
+		case "totalCount":
+			n, err := api.staticDB.CountSkylinks(ctx, filter)
+			if err != nil {
+				return nil, err
+			}
+			conn[sub.Alias] = n
+		case "nodes":
+			nodes, err := projectSkylinks(sls, sub.Fields)
+			if err != nil {
+				return nil, err
+			}
+			conn[sub.Alias] = nodes
+		case "pageInfo":
+			if len(sub.Fields) == 0 {
+				return nil, invalidQueryf("field pageInfo must have a selection")
+			}
+			var endCursor interface{}
+			if len(sls) > 0 {
+				endCursor = sls[len(sls)-1].ID.Hex()
+			}
+			pageInfo := make(map[string]interface{}, len(sub.Fields))
+			for _, pf := range sub.Fields {
+				switch pf.Name {
+				case "__typename":
+					pageInfo[pf.Alias] = "PageInfo"
+				case "endCursor":
+					pageInfo[pf.Alias] = endCursor
+				case "hasNextPage":
+					pageInfo[pf.Alias] = hasNextPage
+				default:
+					return nil, invalidQueryf("cannot query field %s on type PageInfo", pf.Name)
+				}
+			}
+			conn[sub.Alias] = pageInfo
+		default:
+			return nil, invalidQueryf("cannot query field %s on type SkylinkConnection", sub.Name)
+		}
+	}
+	return conn, nil
+}
+
+// skylinkFilter builds a filter from the arguments of the skylinks field.
+func skylinkFilter(args map[string]interface{}) (database.SkylinkFilter, error) {
+	f := database.SkylinkFilter{Limit: defaultGraphQLPageSize}
+	for name, v := range args {
+		if v == nil {
+			continue
+		}
+		var err error
+		switch name {
+		case "status":
+			f.Status, err = stringArg(name, v)
+		case "infected":
+			f.Infected, err = boolArg(name, v)
+		case "falsePositive":
+			f.FalsePositive, err = boolArg(name, v)
+		case "scannedFrom", "scannedTo", "submittedFrom", "submittedTo":
+			var s string
+			s, err = stringArg(name, v)
+			if err != nil {
+				break
+			}
+			t, errTime := parseTime(s)
+			if errTime != nil {
+				err = invalidQueryf("invalid time for argument %s", name)
+				break
+			}
+			switch name {
+			case "scannedFrom":
+				f.ScannedFrom = t
+			case "scannedTo":
+				f.ScannedTo = t
+			case "submittedFrom":
+				f.SubmittedFrom = t
+			case "submittedTo":
+				f.SubmittedTo = t
+			}
+		case "first":
+			n, ok := v.(int64)
+			if !ok || n < 1 || n > maxGraphQLPageSize {
+				err = invalidQueryf("argument first must be between 1 and %d", maxGraphQLPageSize)
+				break
+			}
+			f.Limit = int(n)
+		case "after":
+			var s string
+			s, err = stringArg(name, v)
+			if err != nil {
+				break
+			}
+			f.After, err = primitive.ObjectIDFromHex(s)
+			if err != nil {
+				err = invalidQueryf("invalid cursor for argument after")
+			}
+		default:
+			err = invalidQueryf("unknown argument %s on field skylinks", name)
+		}
+		if err != nil {
+			return database.SkylinkFilter{}, err
+		}
+	}
+	return f, nil
+}
+
+// projectSkylinks returns the given fields of the given skylink records.
+func projectSkylinks(sls []database.Skylink, fields []*graphql.Field) ([]interface{}, error) {
+	if len(fields) == 0 {
+		return nil, invalidQueryf("field nodes must have a selection")
+	}
+	for _, f := range fields {
+		if _, ok := skylinkFields[f.Name]; !ok && f.Name != "__typename" {
+			return nil, invalidQueryf("cannot query field %s on type Skylink", f.Name)
+		}
+	}
+	nodes := make([]interface{}, 0, len(sls))
+	for _, sl := range sls {
+		b, err := json.Marshal(privateSkylink(sl))
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to encode skylink")
+		}
+		var m map[string]interface{}
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to decode skylink")
+		}
+		m["__typename"] = "Skylink"
+		nodes = append(nodes, project(m, fields))
+	}
+	return nodes, nil
+}
+
+// project returns the given fields of the given JSON value. Objects and lists
+// of objects are projected recursively. Values without a selection are
+// returned as they are, so maps like the blocker reports can be selected as a
+// whole.
+func project(v interface{}, fields []*graphql.Field) interface{} {
+	if len(fields) == 0 {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			out[f.Alias] = project(val[f.Name], f.Fields)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i := range val {
+			out[i] = project(val[i], fields)
+		}
+		return out
+	}
+	return v
+}
+
+// selects returns whether the given fields select the field with the given
+// name.
+func selects(fields []*graphql.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stringArg returns the value of the given string argument.
+func stringArg(name string, v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", invalidQueryf("argument %s must be a string", name)
+	}
+	return s, nil
+}
+
+// boolArg returns the value of the given boolean argument.
+func boolArg(name string, v interface{}) (*bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return nil, invalidQueryf("argument %s must be a boolean", name)
+	}
+	return &b, nil
+}
+
+// invalidQueryf returns a queryError with the given message.
+func invalidQueryf(format string, args ...interface{}) error {
+	return queryError(fmt.Sprintf(format, args...))
+}
+
+// writeGraphQLError writes the given error as a GraphQL response.
+func writeGraphQLError(w http.ResponseWriter, err error, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(graphQLResponse{Errors: []graphQLError{{Message: err.Error()}}})
+}
+
+// jsonFields returns the JSON field names of the given struct type.
+func jsonFields(t reflect.Type) map[string]struct{} {
+	fields := make(map[string]struct{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = struct{}{}
+		}
+	}
+	return fields
+}
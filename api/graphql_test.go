@@ -0,0 +1,83 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/graphql"
+)
+
+// TestSkylinkFilter ensures we build the right filter from the arguments of
+// the skylinks field and reject invalid ones.
+func TestSkylinkFilter(t *testing.T) {
+	fields, err := graphql.Parse(`{ skylinks(status: "complete", infected: true, scannedFrom: "2022-03-01", first: 10, after: "62227d6b7a5fa1bd4c9c9a3b", falsePositive: null) { totalCount } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := skylinkFilter(fields[0].Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != database.SkylinkStatusComplete || f.Infected == nil || !*f.Infected || f.FalsePositive != nil || f.Limit != 10 {
+		t.Fatalf("Unexpected filter %+v", f)
+	}
+	if !f.ScannedFrom.Equal(time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)) || f.After.Hex() != "62227d6b7a5fa1bd4c9c9a3b" {
+		t.Fatalf("Unexpected filter %+v", f)
+	}
+	if f, err = skylinkFilter(nil); err != nil || f.Limit != defaultGraphQLPageSize {
+		t.Fatalf("Unexpected default filter %+v, %v", f, err)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"first": int64(0)},
+		{"first": int64(maxGraphQLPageSize + 1)},
+		{"infected": "yes"},
+		{"scannedTo": "yesterday"},
+		{"after": "abc"},
+		{"hash": "abc"},
+	} {
+		_, err = skylinkFilter(args)
+		if _, ok := err.(queryError); !ok {
+			t.Fatalf("Expected a query error for %v, got %v", args, err)
+		}
+	}
+}
+
+// TestProjectSkylinks ensures we only return the selected fields of skylink
+// records.
+func TestProjectSkylinks(t *testing.T) {
+	fields, err := graphql.Parse(`{ skylinks { nodes { __typename status sig: infectionDescription blocker { result } uploaders { uploads } } } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sls := []database.Skylink{{
+		Status:               database.SkylinkStatusComplete,
+		Infected:             true,
+		InfectionDescription: "Eicar-Signature",
+		Blocker:              &database.BlockerResponse{Result: database.BlockerResultBlocked, StatusCode: 204},
+		Uploaders:            []database.Uploader{{Uploads: 2}},
+	}}
+	nodes, err := projectSkylinks(sls, fields[0].Fields[0].Fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := nodes[0].(map[string]interface{})
+	if len(node) != 5 || node["__typename"] != "Skylink" || node["status"] != "complete" || node["sig"] != "Eicar-Signature" {
+		t.Fatalf("Unexpected node %v", node)
+	}
+	if blocker := node["blocker"].(map[string]interface{}); len(blocker) != 1 || blocker["result"] != "blocked" {
+		t.Fatalf("Unexpected blocker %v", blocker)
+	}
+	if uploaders := node["uploaders"].([]interface{}); len(uploaders) != 1 || uploaders[0].(map[string]interface{})["uploads"] != float64(2) {
+		t.Fatalf("Unexpected uploaders %v", uploaders)
+	}
+
+	fields, err = graphql.Parse(`{ skylinks { nodes { rescanSkylink } } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = projectSkylinks(sls, fields[0].Fields[0].Fields); err == nil {
+		t.Fatal("Expected an error for an unknown field")
+	}
+}
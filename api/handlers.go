@@ -1,67 +1,442 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/SkynetLabs/malware-scanner/clamav"
 	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/SkynetLabs/malware-scanner/metrics"
+	"github.com/SkynetLabs/malware-scanner/scanner"
 	"github.com/julienschmidt/httprouter"
 	"gitlab.com/NebulousLabs/errors"
 	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// statusQueued is the response status of a skylink added to the queue.
+	statusQueued = "queued"
+	// statusDuplicate is the response status of a skylink which was already
+	// in the queue.
+	statusDuplicate = "duplicate"
+
+	// defaultStatsHours is the number of hours /stats covers by default.
+	defaultStatsHours = 24
+	// maxStatsHours is the maximum number of hours /stats can cover.
+	maxStatsHours = 24 * 30
+
+	// defaultSignaturesLimit is the number of signatures /stats/signatures
+	// returns by default.
+	defaultSignaturesLimit = 20
+	// maxSignaturesLimit is the maximum number of signatures
+	// /stats/signatures can return.
+	maxSignaturesLimit = 1000
+	// defaultSignaturesPeriod is the period /stats/signatures covers when no
+	// start date is given.
+	defaultSignaturesPeriod = 30 * 24 * time.Hour
+
+	// maxBulkStatusSkylinks is the maximum number of skylinks a single bulk
+	// status request can ask about.
+	maxBulkStatusSkylinks = 1000
+	// maxBulkStatusBodySize is the maximum size of a bulk status request.
+	maxBulkStatusBodySize = 1 << 20
+)
+
+var (
+	// errInvalidSkylink is returned when the skylink we're asked to scan is
+	// invalid.
+	errInvalidSkylink = errors.New("invalid skylink")
 )
 
 type (
+	// statsResponse is the response to stats requests. Portal stats cover
+	// the time since the service started.
+	statsResponse struct {
+		*database.ScanStats
+		Anomaly *database.Anomaly    `json:"infectionAnomaly"`
+		Queue   *database.QueueDepth `json:"queue"`
+		Portals []clamav.PortalStats `json:"portals"`
+	}
+
+	// debugStateResponse is a snapshot of the service's internal state.
+	debugStateResponse struct {
+		scanner.State
+		InFlight []clamav.ScanProgress `json:"inFlight"`
+		Portals  []clamav.PortalStats  `json:"portals"`
+		Config   debugConfig           `json:"config"`
+	}
+
+	// debugConfig is the configuration currently in effect. It deliberately
+	// leaves out any credentials.
+	debugConfig struct {
+		Blocker               string            `json:"blocker"`
+		BlockerTargets        map[string]string `json:"blockerTargets"`
+		ScanTimeout           string            `json:"scanTimeout"`
+		SLATarget             string            `json:"slaTarget"`
+		SlowScanThreshold     string            `json:"slowScanThreshold"`
+		LargeFileThreshold    uint64            `json:"largeFileThreshold"`
+		AnomalyWindow         string            `json:"anomalyWindow"`
+		AnomalyBaseline       string            `json:"anomalyBaseline"`
+		AnomalyThreshold      float64           `json:"anomalyThreshold"`
+		AnomalyMinScans       int64             `json:"anomalyMinScans"`
+		SignatureMaxAge       string            `json:"signatureMaxAge"`
+		SignatureStaleUnready bool              `json:"signatureStaleUnready"`
+		LogSampleBurst        int               `json:"logSampleBurst"`
+		LogSampleInterval     string            `json:"logSampleInterval"`
+	}
+
+	// healthResponse is the response to health requests.
+	healthResponse struct {
+		DBAlive       bool             `json:"dbAlive"`
+		ClamAVAlive   bool             `json:"clamAVAlive"`
+		DBHistory     dependencyHealth `json:"dbHistory"`
+		ClamAVHistory dependencyHealth `json:"clamAVHistory"`
+		Signatures    signatureHealth  `json:"signatures"`
+	}
+
+	// signatureStatsResponse is the response to signature stats requests.
+	signatureStatsResponse struct {
+		From       time.Time                 `json:"from"`
+		To         time.Time                 `json:"to"`
+		Signatures []database.SignatureCount `json:"signatures"`
+	}
+
 	// scanResponse is the response to scan requests
 	scanResponse struct {
 		Status string `json:"status"`
 	}
+
+	// scanRootResponse is the response to scan requests by merkle root. It
+	// includes the skylink built from the root and the hash of the root, by
+	// which the status of the scan can be looked up.
+	scanRootResponse struct {
+		Status  string      `json:"status"`
+		Skylink string      `json:"skylink"`
+		Hash    crypto.Hash `json:"hash"`
+	}
+
+	// bulkStatusRequest is the body of bulk status requests.
+	bulkStatusRequest struct {
+		Skylinks []string `json:"skylinks"`
+	}
+
+	// bulkStatusResponse is the response to bulk status requests. Statuses
+	// are keyed by the skylinks as given in the request.
+	bulkStatusResponse struct {
+		Statuses map[string]database.Skylink `json:"statuses"`
+		NotFound []string                    `json:"notFound,omitempty"`
+		Invalid  []string                    `json:"invalid,omitempty"`
+	}
 )
 
+// debugStateGET returns a snapshot of the service's internal state, for
+// operational debugging.
+func (api *API) debugStateGET(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	targets := make(map[string]string, len(api.staticBlockers))
+	for _, b := range api.staticBlockers {
+		targets[b.Name()] = b.BaseURL()
+	}
+	skyapi.WriteJSON(w, debugStateResponse{
+		State:    api.staticScanner.State(),
+		InFlight: privateInFlight(api.staticClamAV.InFlight()),
+		Portals:  api.staticClamAV.PortalStats(),
+		Config: debugConfig{
+			Blocker:               api.staticBlockers[0].BaseURL(),
+			BlockerTargets:        targets,
+			ScanTimeout:           database.ScanTimeout.String(),
+			SLATarget:             database.SLATarget.String(),
+			SlowScanThreshold:     scanner.SlowScanThreshold.String(),
+			LargeFileThreshold:    scanner.LargeFileThreshold,
+			AnomalyWindow:         database.AnomalyWindow.String(),
+			AnomalyBaseline:       database.AnomalyBaseline.String(),
+			AnomalyThreshold:      database.AnomalyThreshold,
+			AnomalyMinScans:       database.AnomalyMinScans,
+			SignatureMaxAge:       SignatureMaxAge.String(),
+			SignatureStaleUnready: SignatureStaleUnready,
+			LogSampleBurst:        logging.SampleBurst,
+			LogSampleInterval:     logging.SampleInterval.String(),
+		},
+	})
+}
+
 // healthGET returns the status of the service
 func (api *API) healthGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	status := struct {
-		DBAlive     bool `json:"dbAlive"`
-		ClamAVAlive bool `json:"clamAVAlive"`
-	}{}
+	var status healthResponse
 	err := api.staticClamAV.Ping()
 	status.ClamAVAlive = err == nil
 	err = api.staticDB.Ping(r.Context())
 	status.DBAlive = err == nil
+	status.DBHistory = api.staticHealth.staticDB.summary()
+	status.ClamAVHistory = api.staticHealth.staticClamAV.summary()
+	si, err := api.staticClamAV.SignatureInfo()
+	status.Signatures = newSignatureHealth(si, err, time.Now())
+	if status.Signatures.Stale && SignatureStaleUnready {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	skyapi.WriteJSON(w, status)
 }
 
+// metricsGET returns the service's metrics in the Prometheus text exposition
+// format.
+func (api *API) metricsGET(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := metrics.DefaultRegistry.WritePrometheus(w)
+	if err != nil {
+		api.staticLogger.Warnf("metricsGET failed to write metrics: %s", err)
+	}
+}
+
+// statsGET returns the scanner's throughput and SLA compliance over the last
+// `hours` hours.
+func (api *API) statsGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	p := newParams(r)
+	hours := p.Int("hours", defaultStatsHours, 1, maxStatsHours)
+	if p.invalid(w) {
+		return
+	}
+	since := time.Now().UTC().Add(-time.Duration(hours) * time.Hour)
+	stats, err := api.staticDB.ScanStats(r.Context(), since)
+	if err != nil {
+		api.staticLogger.Warnf("statsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	anomaly, err := api.staticDB.InfectionAnomaly(r.Context())
+	if err != nil {
+		api.staticLogger.Warnf("statsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	queue, err := api.staticDB.QueueDepth(r.Context())
+	if err != nil {
+		api.staticLogger.Warnf("statsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, statsResponse{
+		ScanStats: stats,
+		Anomaly:   anomaly,
+		Queue:     queue,
+		Portals:   api.staticClamAV.PortalStats(),
+	})
+}
+
+// statsSignaturesGET returns the most frequently detected signatures within
+// the given date range. The range is given by the `from` and `to` parameters,
+// either as RFC3339 timestamps or YYYY-MM-DD dates, and defaults to the last 30
+// days.
+func (api *API) statsSignaturesGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	p := newParams(r)
+	to := p.Time("to", time.Now().UTC())
+	from := p.Time("from", to.Add(-defaultSignaturesPeriod))
+	p.Range(from, to)
+	limit := p.Int("limit", defaultSignaturesLimit, 1, maxSignaturesLimit)
+	if p.invalid(w) {
+		return
+	}
+	sigs, err := api.staticDB.SignatureStats(r.Context(), from, to, limit)
+	if err != nil {
+		api.staticLogger.Warnf("statsSignaturesGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, signatureStatsResponse{from, to, sigs})
+}
+
 // scanPOST adds a new skylink to the scanning queue. If the skylink is already
 // in the queue we respond with 200 OK but we don't add it again.
 func (api *API) scanPOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	skylink, err := parseSkylink(ps.ByName("skylink"), api.staticClamAV.PreferredPortal())
-	if err != nil {
+	if !api.admitSubmissions(w, r, 1) {
+		return
+	}
+	status, err := api.enqueue(r.Context(), ps.ByName("skylink"), database.SourceUser, submitter(r))
+	if errors.Contains(err, errInvalidSkylink) {
+		api.recordInvalidSubmissions(r, 1)
 		api.staticLogger.Debugf("scanPost failed with bad param: %s", err)
 		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
 		return
 	}
-	err = api.staticDB.SkylinkCreate(r.Context(), skylink)
+	if err != nil {
+		api.staticLogger.Warnf("scanPost failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, scanResponse{status})
+}
+
+// scanRootPOST adds the sector with the given merkle root to the scanning
+// queue, for internal tools which don't have a skylink of the content. The
+// `length` parameter is the number of bytes of the sector to scan. The content
+// is downloaded from the portal's /skynet/root endpoint.
+func (api *API) scanRootPOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !api.admitSubmissions(w, r, 1) {
+		return
+	}
+	p := newParams(r)
+	length := p.Int64("length", 0, 1, int64(database.MaxRootLength))
+	if length == 0 {
+		p.fail("missing length parameter")
+	}
+	if p.invalid(w) {
+		return
+	}
+	var sl database.Skylink
+	if err := sl.LoadRoot(ps.ByName("root"), uint64(length)); err != nil {
+		api.recordInvalidSubmissions(r, 1)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	sl.Source = database.SourceUser
+	sl.Submitter = submitter(r)
+	status, err := api.enqueueRecord(r.Context(), &sl)
+	if err != nil {
+		api.staticLogger.Warnf("scanRootPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, scanRootResponse{Status: status, Skylink: sl.Skylink, Hash: sl.Hash})
+}
+
+// enqueue adds the given skylink to the scanning queue, recording the source
+// and the submitter of the scan. It returns statusQueued, or statusDuplicate
+// if the skylink is already in the queue. Errors caused by an invalid skylink
+// extend errInvalidSkylink.
+func (api *API) enqueue(ctx context.Context, skylinkStr, source, sub string) (string, error) {
+	skylink, err := parseSkylink(skylinkStr, api.staticClamAV.PreferredPortal())
+	if err != nil {
+		return "", errors.Extend(err, errInvalidSkylink)
+	}
+	skylink.Source = source
+	skylink.Submitter = sub
+	return api.enqueueRecord(ctx, skylink)
+}
+
+// enqueueRecord adds the given new record to the scanning queue. It returns
+// statusQueued, or statusDuplicate if its content is already in the queue. A
+// duplicate keeps the source and the submitter of the scan it's already had.
+func (api *API) enqueueRecord(ctx context.Context, skylink *database.Skylink) (string, error) {
+	err := api.staticDB.SkylinkCreate(ctx, skylink)
 	if errors.Contains(err, database.ErrSkylinkExists) {
-		api.staticLogger.Tracef("scanPost duplicate %s", skylink.Skylink)
-		skyapi.WriteJSON(w, scanResponse{"duplicate"})
+		api.staticLogger.Tracef("enqueue duplicate %s", skylink.Skylink)
+		return statusDuplicate, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	api.staticEvents.Emit(events.Event{
+		Type:    events.TypeSubmitted,
+		Hash:    skylink.Hash.String(),
+		Skylink: skylink.Skylink,
+		Status:  skylink.Status,
+		Source:  skylink.Source,
+	})
+	api.staticLogger.Debugf("enqueue queued %s", skylink.Skylink)
+	return statusQueued, nil
+}
+
+// statusGET returns the scanning status of the given skylink, including
+// blocker's response if the skylink was reported and the source of its latest
+// scan. Admins also learn who submitted it. The skylink can be given as
+// the hash of its merkle root as well, as records are identified in privacy
+// mode.
+func (api *API) statusGET(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if h, err := parseHash(ps.ByName("skylink")); err == nil {
+		api.statusByHash(w, r, h)
 		return
 	}
+	skylink, err := parseSkylink(ps.ByName("skylink"), api.staticClamAV.PreferredPortal())
 	if err != nil {
-		api.staticLogger.Warnf("scanPost failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	sl, err := api.staticDB.Skylink(r.Context(), skylink.Hash)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"skylink not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("statusGET failed: %s", err)
 		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
 		return
 	}
-	api.staticLogger.Debugf("scanPost queued %s", skylink.Skylink)
-	skyapi.WriteJSON(w, scanResponse{"queued"})
+	// We clear the skylink from the record once we're done with it, so we
+	// fill it in from the request.
+	sl.Skylink = skylink.Skylink
+	skyapi.WriteJSON(w, statusRecord(*sl, isAdmin(r)))
 }
 
-// parseSkylink parses the given string into a skylink and validates it.
-func parseSkylink(s, portal string) (*database.Skylink, error) {
-	if s == "" {
-		return nil, errors.New("empty skylink")
+// statusByHash returns the scanning status of the skylink with the given
+// merkle root hash. The record only holds the skylink until it's done with,
+// and only admins learn it.
+func (api *API) statusByHash(w http.ResponseWriter, r *http.Request, h crypto.Hash) {
+	sl, err := api.staticDB.Skylink(r.Context(), h)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"skylink not found"}, http.StatusNotFound)
+		return
 	}
-	var sl database.Skylink
-	err := sl.LoadString(s, portal)
 	if err != nil {
-		return nil, err
+		api.staticLogger.Warnf("statusGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, hashStatusRecord(*sl, isAdmin(r)))
+}
+
+// bulkStatusPOST returns the scanning status of all skylinks in the request
+// body, in the same format as statusGET.
+func (api *API) bulkStatusPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req bulkStatusRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBulkStatusBodySize)).Decode(&req)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid request body: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if len(req.Skylinks) == 0 || len(req.Skylinks) > maxBulkStatusSkylinks {
+		skyapi.WriteError(w, skyapi.Error{fmt.Sprintf("between 1 and %d skylinks must be provided", maxBulkStatusSkylinks)}, http.StatusBadRequest)
+		return
+	}
+	resp := bulkStatusResponse{Statuses: make(map[string]database.Skylink)}
+	requested := make(map[crypto.Hash][]string)
+	var hashes []crypto.Hash
+	for _, s := range req.Skylinks {
+		sl, err := parseSkylink(s, api.staticClamAV.PreferredPortal())
+		if err != nil {
+			resp.Invalid = append(resp.Invalid, s)
+			continue
+		}
+		if _, exists := requested[sl.Hash]; !exists {
+			hashes = append(hashes, sl.Hash)
+		}
+		requested[sl.Hash] = append(requested[sl.Hash], s)
+	}
+	var sls []database.Skylink
+	if len(hashes) > 0 {
+		sls, err = api.staticDB.Skylinks(r.Context(), hashes)
+		if err != nil {
+			api.staticLogger.Warnf("bulkStatusPOST failed: %s", err)
+			skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+	admin := isAdmin(r)
+	for _, sl := range sls {
+		for _, s := range requested[sl.Hash] {
+			// As in statusGET, the record might no longer hold the skylink.
+			sl.Skylink = s
+			resp.Statuses[s] = statusRecord(sl, admin)
+		}
+		delete(requested, sl.Hash)
+	}
+	for _, h := range hashes {
+		resp.NotFound = append(resp.NotFound, requested[h]...)
 	}
-	return &sl, nil
+	skyapi.WriteJSON(w, resp)
 }
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseTime ensures parseTime accepts both RFC3339 timestamps and plain
+// dates.
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Time
+		valid    bool
+	}{
+		{"2021-12-01", time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2021-12-01T10:20:30Z", time.Date(2021, 12, 1, 10, 20, 30, 0, time.UTC), true},
+		{"2021-12-01T12:20:30+02:00", time.Date(2021, 12, 1, 10, 20, 30, 0, time.UTC), true},
+		{"yesterday", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		ts, err := parseTime(tt.in)
+		if (err == nil) != tt.valid {
+			t.Fatalf("Input '%s': expected valid %t, got error %v", tt.in, tt.valid, err)
+		}
+		if tt.valid && !ts.Equal(tt.expected) {
+			t.Fatalf("Input '%s': expected %s, got %s", tt.in, tt.expected, ts)
+		}
+	}
+}
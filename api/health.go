@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+)
+
+const (
+	// healthHistorySize is the number of health checks we keep per
+	// dependency.
+	healthHistorySize = 360
+)
+
+var (
+	// SignatureMaxAge is the age after which we consider ClamAV's signature
+	// database stale. Zero disables the check.
+	// Set according to the MALWARE_SCANNER_SIGNATURE_MAX_AGE_DAYS env var.
+	SignatureMaxAge time.Duration
+	// SignatureStaleUnready makes /health respond with 503 Service
+	// Unavailable when the signature database is stale, so the instance is
+	// taken out of rotation instead of giving false confidence.
+	// Set according to the MALWARE_SCANNER_SIGNATURE_STALE_UNREADY env var.
+	SignatureStaleUnready bool
+)
+
+type (
+	// signatureHealth describes the freshness of ClamAV's signature
+	// database.
+	signatureHealth struct {
+		*clamav.SignatureInfo
+		AgeDays float64 `json:"ageDays"`
+		Stale   bool    `json:"stale"`
+		Error   string  `json:"error,omitempty"`
+	}
+
+	// dependencyHealth is the summary of a dependency's recent health checks.
+	dependencyHealth struct {
+		Checks      int        `json:"checks"`
+		Uptime      float64    `json:"uptime"`
+		Flaps       int        `json:"flaps"`
+		LastFailure *time.Time `json:"lastFailure,omitempty"`
+		LastError   string     `json:"lastError,omitempty"`
+	}
+
+	// healthHistory keeps the results of the most recent health checks of a
+	// dependency in a ring buffer.
+	healthHistory struct {
+		results     []bool
+		next        int
+		full        bool
+		lastFailure time.Time
+		lastError   string
+		mu          sync.Mutex
+	}
+
+	// healthMonitor periodically checks the health of the service's
+	// dependencies and keeps a history of the results.
+	healthMonitor struct {
+		staticDB     *healthHistory
+		staticClamAV *healthHistory
+	}
+)
+
+// newHealthHistory returns an empty health history with the given capacity.
+func newHealthHistory(size int) *healthHistory {
+	return &healthHistory{
+		results: make([]bool, size),
+	}
+}
+
+// record adds the result of a health check to the history.
+func (h *healthHistory) record(err error, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.results[h.next] = err == nil
+	h.next = (h.next + 1) % len(h.results)
+	if h.next == 0 {
+		h.full = true
+	}
+	if err != nil {
+		h.lastFailure = t.UTC()
+		h.lastError = err.Error()
+	}
+}
+
+// summary returns the uptime percentage, number of state changes and the last
+// failure within the history.
+func (h *healthHistory) summary() dependencyHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Collect the results in chronological order.
+	var results []bool
+	if h.full {
+		results = append(results, h.results[h.next:]...)
+	}
+	results = append(results, h.results[:h.next]...)
+
+	dh := dependencyHealth{
+		Checks:    len(results),
+		LastError: h.lastError,
+	}
+	if !h.lastFailure.IsZero() {
+		lf := h.lastFailure
+		dh.LastFailure = &lf
+	}
+	if len(results) == 0 {
+		return dh
+	}
+	up := 0
+	for i, r := range results {
+		if r {
+			up++
+		}
+		if i > 0 && r != results[i-1] {
+			dh.Flaps++
+		}
+	}
+	dh.Uptime = float64(up) / float64(len(results))
+	return dh
+}
+
+// newHealthMonitor returns a health monitor with empty histories.
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{
+		staticDB:     newHealthHistory(healthHistorySize),
+		staticClamAV: newHealthHistory(healthHistorySize),
+	}
+}
+
+// StartHealthMonitor launches a background thread which checks the health of
+// the service's dependencies every interval and keeps a history of the
+// results, which is then exposed on /health.
+func (api *API) StartHealthMonitor(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			api.staticHealth.staticDB.record(api.staticDB.Ping(ctx), now)
+			api.staticHealth.staticClamAV.record(api.staticClamAV.Ping(), now)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// newSignatureHealth describes the freshness of the given signature database
+// at the given time. A signature database we failed to query is considered
+// stale, as long as the freshness check is enabled.
+func newSignatureHealth(si clamav.SignatureInfo, err error, now time.Time) signatureHealth {
+	if err != nil {
+		return signatureHealth{
+			Stale: SignatureMaxAge > 0,
+			Error: err.Error(),
+		}
+	}
+	age := now.Sub(si.Date)
+	return signatureHealth{
+		SignatureInfo: &si,
+		AgeDays:       math.Round(age.Hours()/24*100) / 100,
+		Stale:         SignatureMaxAge > 0 && age > SignatureMaxAge,
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+)
+
+// TestHealthHistory ensures healthHistory computes uptime and flaps over the
+// most recent checks only.
+func TestHealthHistory(t *testing.T) {
+	h := newHealthHistory(4)
+	if s := h.summary(); s.Checks != 0 || s.Uptime != 0 || s.LastFailure != nil {
+		t.Fatalf("Unexpected summary of an empty history %+v", s)
+	}
+
+	failedAt := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	h.record(nil, failedAt.Add(-time.Minute))
+	h.record(errors.New("connection refused"), failedAt)
+	h.record(nil, failedAt.Add(time.Minute))
+	s := h.summary()
+	if s.Checks != 3 || s.Flaps != 2 || s.Uptime != 2.0/3 {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+	if s.LastFailure == nil || !s.LastFailure.Equal(failedAt) || s.LastError != "connection refused" {
+		t.Fatalf("Unexpected last failure %+v", s)
+	}
+
+	// Wrap around the ring buffer, pushing the failure out of the window.
+	for i := 0; i < 4; i++ {
+		h.record(nil, time.Now())
+	}
+	s = h.summary()
+	if s.Checks != 4 || s.Flaps != 0 || s.Uptime != 1 {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+	// The last failure is still reported.
+	if s.LastFailure == nil {
+		t.Fatal("Expected the last failure to be kept")
+	}
+}
+
+// TestNewSignatureHealth ensures the signature database is reported as stale
+// when it's older than SignatureMaxAge.
+func TestNewSignatureHealth(t *testing.T) {
+	defer func(age time.Duration) { SignatureMaxAge = age }(SignatureMaxAge)
+	now := time.Date(2021, 12, 10, 12, 0, 0, 0, time.UTC)
+	si := clamav.SignatureInfo{Version: 26390, Date: now.Add(-36 * time.Hour)}
+
+	// The check is disabled.
+	SignatureMaxAge = 0
+	sh := newSignatureHealth(si, nil, now)
+	if sh.Stale || sh.AgeDays != 1.5 || sh.Version != 26390 {
+		t.Fatalf("Unexpected signature health %+v", sh)
+	}
+	sh = newSignatureHealth(clamav.SignatureInfo{}, errors.New("failed"), now)
+	if sh.Stale || sh.Error != "failed" {
+		t.Fatalf("Unexpected signature health %+v", sh)
+	}
+
+	// The check is enabled.
+	SignatureMaxAge = 24 * time.Hour
+	sh = newSignatureHealth(si, nil, now)
+	if !sh.Stale {
+		t.Fatalf("Expected stale signatures, got %+v", sh)
+	}
+	sh = newSignatureHealth(clamav.SignatureInfo{Date: now.Add(-time.Hour)}, nil, now)
+	if sh.Stale {
+		t.Fatalf("Expected fresh signatures, got %+v", sh)
+	}
+	sh = newSignatureHealth(clamav.SignatureInfo{}, errors.New("failed"), now)
+	if !sh.Stale {
+		t.Fatalf("Expected stale signatures on error, got %+v", sh)
+	}
+}
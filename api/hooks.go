@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/mq"
+	accdb "github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// maxUploadHookBodySize is the maximum size of an upload hook request.
+	maxUploadHookBodySize = 1 << 20
+)
+
+var (
+	// UploadHookToken is the bearer token the portal must present when
+	// calling the upload hook. The hook is open when it's empty.
+	// Set according to the MALWARE_SCANNER_UPLOAD_HOOK_TOKEN env var.
+	UploadHookToken string
+	// HookSigningSecret is the secret hook requests must be signed with, the
+	// same way the scanner signs its blocker reports. Signatures aren't
+	// checked when it's empty.
+	// Set according to the MALWARE_SCANNER_HOOK_SIGNING_SECRET env var.
+	HookSigningSecret []byte
+)
+
+type (
+	// uploadHookEntry is a single upload notification. It covers both the
+	// body of skyd's upload response, which nginx forwards, and batches of
+	// skylinks.
+	uploadHookEntry struct {
+		Skylink  string   `json:"skylink"`
+		Skylinks []string `json:"skylinks"`
+	}
+
+	// uploadHookResponse is the response to upload hook requests.
+	uploadHookResponse struct {
+		Queued    int      `json:"queued"`
+		Duplicate int      `json:"duplicate"`
+		Invalid   []string `json:"invalid,omitempty"`
+	}
+)
+
+// uploadHookPOST queues newly uploaded skylinks for scanning. The body is a
+// JSON object, or a stream of newline-delimited JSON objects, each holding a
+// `skylink` and/or a list of `skylinks`. Skylinks can be given as plain
+// skylinks, `sia://` links or portal URLs.
+func (api *API) uploadHookPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !hookAuthorized(r, UploadHookToken) {
+		skyapi.WriteError(w, skyapi.Error{"invalid upload hook token"}, http.StatusUnauthorized)
+		return
+	}
+	body, err := readHookBody(r, maxUploadHookBodySize)
+	if errors.Contains(err, blocker.ErrInvalidSignature) {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	skylinks, err := parseUploadHook(bytes.NewReader(body))
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if !api.admitSubmissions(w, r, len(skylinks)) {
+		return
+	}
+	var resp uploadHookResponse
+	defer func() { api.recordInvalidSubmissions(r, len(resp.Invalid)) }()
+	for _, sl := range skylinks {
+		status, err := api.enqueue(r.Context(), sl, database.SourceUploadHook, submitter(r))
+		if errors.Contains(err, errInvalidSkylink) {
+			resp.Invalid = append(resp.Invalid, sl)
+			continue
+		}
+		if err != nil {
+			api.staticLogger.Warnf("uploadHookPOST failed: %s", err)
+			skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		if status == statusDuplicate {
+			resp.Duplicate++
+		} else {
+			resp.Queued++
+		}
+	}
+	skyapi.WriteJSON(w, resp)
+}
+
+// hookAuthorized returns whether the request presents the given bearer token.
+// Hooks without a token are open.
+func hookAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	return subtle.ConstantTimeCompare(got, []byte(token)) == 1
+}
+
+// readHookBody reads the body of a hook request, up to the given size, and
+// verifies its signature if HookSigningSecret is set.
+func readHookBody(r *http.Request, maxSize int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSize))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read hook body")
+	}
+	if len(HookSigningSecret) > 0 {
+		if err = blocker.VerifySignature(r.Header, HookSigningSecret, body, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// parseUploadHook extracts all skylinks from the body of an upload hook
+// request.
+func parseUploadHook(body io.Reader) ([]string, error) {
+	var skylinks []string
+	dec := json.NewDecoder(body)
+	for {
+		var e uploadHookEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.AddContext(err, "invalid upload hook body")
+		}
+		for _, s := range append([]string{e.Skylink}, e.Skylinks...) {
+			if s != "" {
+				skylinks = append(skylinks, extractSkylink(s))
+			}
+		}
+	}
+	if len(skylinks) == 0 {
+		return nil, errors.New("no skylinks provided")
+	}
+	return skylinks, nil
+}
+
+// extractSkylink extracts the skylink from a `sia://` link or a portal URL. If
+// it doesn't find one, it returns the input as is, so it's reported as
+// invalid.
+func extractSkylink(s string) string {
+	s = strings.TrimSpace(s)
+	sl := strings.TrimPrefix(s, "sia://")
+	if accdb.ValidSkylinkHash(sl) {
+		return sl
+	}
+	// Portal URLs have the skylink as one of the path segments.
+	sl = strings.TrimPrefix(strings.TrimPrefix(sl, "https://"), "http://")
+	for _, part := range strings.FieldsFunc(sl, func(r rune) bool { return r == '/' || r == '?' || r == '#' }) {
+		if accdb.ValidSkylinkHash(part) {
+			return part
+		}
+	}
+	return s
+}
+
+// StartQueueConsumer consumes scan requests from the given subject of the
+// message queue and adds them to the scanning queue until the context is
+// cancelled. Messages have the same format as upload hook requests, or are
+// plain whitespace-separated skylinks.
+func (api *API) StartQueueConsumer(ctx context.Context, n *mq.NATS, subject, durable string) error {
+	return n.Consume(ctx, subject, durable, func(data []byte) error {
+		skylinks, err := parseScanRequest(data)
+		if err != nil {
+			return errors.Extend(err, mq.ErrPermanent)
+		}
+		var invalid []string
+		for _, sl := range skylinks {
+			_, err = api.enqueue(ctx, sl, database.SourceQueue, "")
+			if errors.Contains(err, errInvalidSkylink) {
+				invalid = append(invalid, sl)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(invalid) > 0 {
+			api.staticLogger.Debugf("Ignored invalid skylinks from the message queue: %v", invalid)
+		}
+		return nil
+	}, api.staticLogger)
+}
+
+// parseScanRequest extracts all skylinks from a scan request received from
+// the message queue.
+func parseScanRequest(data []byte) ([]string, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseUploadHook(bytes.NewReader(trimmed))
+	}
+	var skylinks []string
+	for _, s := range strings.Fields(string(data)) {
+		skylinks = append(skylinks, extractSkylink(s))
+	}
+	if len(skylinks) == 0 {
+		return nil, errors.New("no skylinks provided")
+	}
+	return skylinks, nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestParseUploadHook ensures we extract all skylinks from the supported upload
+// hook formats.
+func TestParseUploadHook(t *testing.T) {
+	sl1 := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	sl2 := "AAC0uO43g64ULpyrW0zO3bjEknSFbAhm8c-RFP21EQlmSQ"
+	body := `{"skylink":"` + sl1 + `","merkleroot":"abc","bitfield":0}
+{"skylinks":["sia://` + sl2 + `","https://siasky.net/` + sl1 + `/index.html?x=1","invalid"]}`
+	skylinks, err := parseUploadHook(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{sl1, sl2, sl1, "invalid"}
+	if strings.Join(skylinks, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, got %v", expected, skylinks)
+	}
+
+	for _, b := range []string{"", "{}", `{"skylink":`, "not json"} {
+		if _, err = parseUploadHook(strings.NewReader(b)); err == nil {
+			t.Fatalf("Expected an error for '%s'", b)
+		}
+	}
+}
+
+// TestParseScanRequest ensures we extract all skylinks from both JSON and
+// plain text scan requests.
+func TestParseScanRequest(t *testing.T) {
+	sl1 := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	sl2 := "AAC0uO43g64ULpyrW0zO3bjEknSFbAhm8c-RFP21EQlmSQ"
+	skylinks, err := parseScanRequest([]byte(" {\"skylinks\":[\"" + sl1 + "\"]}\n"))
+	if err != nil || len(skylinks) != 1 || skylinks[0] != sl1 {
+		t.Fatalf("Unexpected skylinks %v, %v", skylinks, err)
+	}
+	skylinks, err = parseScanRequest([]byte(sl1 + "\nsia://" + sl2 + " \n"))
+	if err != nil || len(skylinks) != 2 || skylinks[0] != sl1 || skylinks[1] != sl2 {
+		t.Fatalf("Unexpected skylinks %v, %v", skylinks, err)
+	}
+	for _, b := range []string{"", " \n ", "{invalid"} {
+		if _, err = parseScanRequest([]byte(b)); err == nil {
+			t.Fatalf("Expected an error for '%s'", b)
+		}
+	}
+}
+
+// TestParseSignatureHook ensures we parse signature hook bodies, including
+// empty ones.
+func TestParseSignatureHook(t *testing.T) {
+	su, err := parseSignatureHook(strings.NewReader(`{"version":26391,"date":"2021-12-08T09:21:39Z","source":"freshclam"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if su.Version != 26391 || su.Date.IsZero() || su.Source != "freshclam" {
+		t.Fatalf("Unexpected signature update %+v", su)
+	}
+	su, err = parseSignatureHook(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if su.Version != 0 || !su.Date.IsZero() || su.Source != signatureSourceHook {
+		t.Fatalf("Unexpected signature update %+v", su)
+	}
+	for _, b := range []string{`{"version":-1}`, `{"version":"abc"}`, "not json"} {
+		if _, err = parseSignatureHook(strings.NewReader(b)); err == nil {
+			t.Fatalf("Expected an error for '%s'", b)
+		}
+	}
+}
+
+// TestReadHookBody ensures hook bodies must be signed once a signing secret is
+// set.
+func TestReadHookBody(t *testing.T) {
+	defer func(secret []byte) { HookSigningSecret = secret }(HookSigningSecret)
+	body := `{"skylink":"abc"}`
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/hooks/upload", strings.NewReader(body))
+	}
+
+	HookSigningSecret = nil
+	b, err := readHookBody(newRequest(), maxUploadHookBodySize)
+	if err != nil || string(b) != body {
+		t.Fatalf("Unexpected body '%s' %v", b, err)
+	}
+	HookSigningSecret = []byte("secret")
+	if _, err = readHookBody(newRequest(), maxUploadHookBodySize); !errors.Contains(err, blocker.ErrInvalidSignature) {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	r := newRequest()
+	blocker.SignRequest(r, HookSigningSecret, []byte(body))
+	b, err = readHookBody(r, maxUploadHookBodySize)
+	if err != nil || string(b) != body {
+		t.Fatalf("Unexpected body '%s' %v", b, err)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"github.com/SkynetLabs/malware-scanner/metrics"
+)
+
+var (
+	// requestDurationBuckets are the histogram buckets, in seconds, we use
+	// for the duration of API requests.
+	requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+	// metricRequests counts the API requests by route, method and status
+	// class, e.g. "2xx".
+	metricRequests = metrics.NewCounterVec("api_requests_total", "Number of API requests by route, method and status class.", "route", "method", "status")
+	// metricRequestDuration tracks the duration of API requests by route and
+	// method.
+	metricRequestDuration = metrics.NewHistogramVec("api_request_duration_seconds", "Duration of API requests by route and method.", requestDurationBuckets, "route", "method")
+	// metricRejectedSubmissions counts the submitted skylinks we rejected
+	// by reason, "quota" or "banned".
+	metricRejectedSubmissions = metrics.NewCounterVec("api_rejected_submissions_total", "Number of submitted skylinks rejected over quota or from banned submitters.", "reason")
+	// metricSubmitterBans counts the bans of abusive submitters by reason.
+	metricSubmitterBans = metrics.NewCounterVec("api_submitter_bans_total", "Number of abusive submitters banned by reason.", "reason")
+)
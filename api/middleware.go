@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/julienschmidt/httprouter"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+// statusRecorder is an http.ResponseWriter which remembers the status code of
+// the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter. Error responses are redacted, since
+// they may echo secrets from the errors of failed dependency calls.
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	if sr.status >= http.StatusBadRequest {
+		if _, err := io.WriteString(sr.ResponseWriter, logging.Redact(string(b))); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// withMetrics wraps the given handler, so it records the count and duration of
+// requests to the given route.
+func withMetrics(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		h(sr, req, ps)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		metricRequests.With(route, req.Method, statusClass(sr.status)).Inc()
+		metricRequestDuration.With(route, req.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// statusClass returns the class of the given status code, e.g. "2xx".
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// panicHandler responds with a 500 to requests whose handler panicked, so
+// malformed input we failed to validate can't take down the service.
+func (api *API) panicHandler(w http.ResponseWriter, req *http.Request, v interface{}) {
+	api.staticLogger.Errorf("Handler of %s %s panicked: %v", req.Method, logging.Redact(req.URL.Path), v)
+	skyapi.WriteError(w, skyapi.Error{"internal error"}, http.StatusInternalServerError)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/julienschmidt/httprouter"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+// TestWithMetrics ensures withMetrics counts requests by route, method and
+// status class.
+func TestWithMetrics(t *testing.T) {
+	route := "/test/with-metrics/:id"
+	h := withMetrics(route, func(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+		if ps.ByName("id") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	router := httprouter.New()
+	router.GET(route, h)
+
+	for _, id := range []string{"good", "good", "bad"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/with-metrics/"+id, nil))
+	}
+	if n := metricRequests.With(route, http.MethodGet, "2xx").Value(); n != 2 {
+		t.Fatalf("Expected 2 successful requests, got %f", n)
+	}
+	if n := metricRequests.With(route, http.MethodGet, "4xx").Value(); n != 1 {
+		t.Fatalf("Expected 1 failed request, got %f", n)
+	}
+	if n := metricRequestDuration.With(route, http.MethodGet).Count(); n != 3 {
+		t.Fatalf("Expected 3 observations, got %d", n)
+	}
+}
+
+// TestRedactErrorResponses ensures error responses are redacted while
+// successful ones are passed through.
+func TestRedactErrorResponses(t *testing.T) {
+	logging.AddSecrets("response-secret")
+	h := withMetrics("/test/redact", func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		if req.URL.Query().Get("fail") != "" {
+			skyapi.WriteError(w, skyapi.Error{"failed with response-secret"}, http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("response-secret"))
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/test/redact?fail=1", nil), nil)
+	if strings.Contains(w.Body.String(), "response-secret") || !strings.Contains(w.Body.String(), "[REDACTED]") {
+		t.Fatalf("Unexpected error response '%s'", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/test/redact", nil), nil)
+	if w.Body.String() != "response-secret" {
+		t.Fatalf("Unexpected response '%s'", w.Body.String())
+	}
+}
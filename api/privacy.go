@@ -0,0 +1,65 @@
+package api
+
+import (
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/logging"
+)
+
+// privateSkylink returns the given record without the fields which identify
+// its content or the people behind it in privacy mode: its skylinks, filename,
+// submitter, reporter and uploaders. Callers identify records by their hash
+// instead.
+func privateSkylink(sl database.Skylink) database.Skylink {
+	if logging.PrivacyMode {
+		sl.Skylink = ""
+		sl.RescanSkylink = ""
+		sl.Filename = ""
+		sl.Submitter = ""
+		sl.Reporter = ""
+		sl.Uploaders = nil
+	}
+	return sl
+}
+
+// statusRecord returns the given record as the status endpoints respond with
+// it. Only admins learn who submitted, reported and uploaded the skylink, so
+// they can tell which submission caused a block. The uploaders are otherwise
+// only served by /admin/uploaders.
+func statusRecord(sl database.Skylink, admin bool) database.Skylink {
+	if !admin {
+		sl.Submitter = ""
+		sl.Reporter = ""
+		sl.Uploaders = nil
+	}
+	return privateSkylink(sl)
+}
+
+// hashStatusRecord returns the given record as the status endpoint responds
+// with it to a lookup by hash. Whoever knows the hash doesn't necessarily know
+// the skylink, so only admins learn it.
+func hashStatusRecord(sl database.Skylink, admin bool) database.Skylink {
+	sl = statusRecord(sl, admin)
+	if !admin {
+		sl.Skylink = ""
+	}
+	return sl
+}
+
+// privateSkylinkParam returns the given skylink as it may be recorded in the
+// audit log, which is the hash of its merkle root in privacy mode.
+func privateSkylinkParam(skylink string) string {
+	return logging.Redact(skylink)
+}
+
+// privateInFlight returns the given in-flight scans with their skylinks
+// replaced by hashes in privacy mode.
+func privateInFlight(scans []clamav.ScanProgress) []clamav.ScanProgress {
+	if !logging.PrivacyMode {
+		return scans
+	}
+	for i := range scans {
+		scans[i].Skylink = logging.Redact(scans[i].Skylink)
+	}
+	return scans
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/logging"
+)
+
+// TestPrivacyMode ensures skylinks, filenames, submitters, reporters and
+// uploaders are only left out of responses and audit params in privacy mode,
+// and that only admins learn the submitters, reporters and uploaders of
+// skylinks.
+func TestPrivacyMode(t *testing.T) {
+	defer func(privacy bool) { logging.PrivacyMode = privacy }(logging.PrivacyMode)
+	skylink := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	sl := database.Skylink{Skylink: skylink, Filename: "eicar.com", Source: database.SourceUser, Submitter: "ip:192.0.2.1", Reporter: "abuse-scanner:abuse@example.com", Uploaders: []database.Uploader{{Sub: "sub", Uploads: 1}}}
+	scans := func() []clamav.ScanProgress { return []clamav.ScanProgress{{Skylink: skylink}} }
+
+	logging.PrivacyMode = false
+	if privateSkylink(sl).Skylink != skylink || privateSkylink(sl).Filename != sl.Filename || privateSkylinkParam(skylink) != skylink || privateInFlight(scans())[0].Skylink != skylink {
+		t.Fatal("Expected skylinks to be kept outside of privacy mode")
+	}
+	if s := statusRecord(sl, false); s.Submitter != "" || s.Reporter != "" || s.Uploaders != nil || s.Source != sl.Source {
+		t.Fatalf("Expected only admins to learn the submitter, the reporter and the uploaders, got %+v", s)
+	}
+	if s := statusRecord(sl, true); s.Submitter != sl.Submitter || s.Reporter != sl.Reporter || len(s.Uploaders) != 1 {
+		t.Fatalf("Expected admins to learn the submitter, the reporter and the uploaders, got %+v", s)
+	}
+
+	logging.PrivacyMode = true
+	if s := privateSkylink(sl); s.Skylink != "" || s.Filename != "" || s.Submitter != "" || s.Reporter != "" || s.Uploaders != nil {
+		t.Fatalf("Unexpected skylink '%s', filename '%s', submitter '%s', reporter '%s' and uploaders %v", s.Skylink, s.Filename, s.Submitter, s.Reporter, s.Uploaders)
+	}
+	if s := statusRecord(sl, true); s.Submitter != "" || s.Reporter != "" || s.Uploaders != nil || s.Source != sl.Source {
+		t.Fatalf("Expected the submitter, the reporter and the uploaders to be left out for admins too, got %+v", s)
+	}
+	h, _ := logging.SkylinkHash(skylink)
+	if p := privateSkylinkParam(skylink); p != "skylink:"+h {
+		t.Fatalf("Unexpected param '%s'", p)
+	}
+	if s := privateInFlight(scans())[0].Skylink; strings.Contains(s, skylink) || !strings.HasSuffix(s, h) {
+		t.Fatalf("Unexpected in-flight skylink '%s'", s)
+	}
+}
+
+// nonZero returns a non-zero value of the given type.
+func nonZero(t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint8, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Array:
+		v.Index(0).Set(nonZero(t.Elem()))
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(nonZero(t.Elem()))
+	case reflect.Slice:
+		v.Set(reflect.Append(v, nonZero(t.Elem())))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		v.SetMapIndex(nonZero(t.Key()), nonZero(t.Elem()))
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return reflect.ValueOf(time.Unix(1, 0))
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				v.Field(i).Set(nonZero(t.Field(i).Type))
+			}
+		}
+	default:
+		panic("unexpected kind " + t.Kind().String())
+	}
+	return v
+}
+
+// TestIdentifyingFields walks every field of skylink records and ensures the
+// ones which identify the content or the people behind it are left out of the
+// responses which mustn't reveal them, while the others are kept. New fields
+// have to be classified here.
+func TestIdentifyingFields(t *testing.T) {
+	defer func(privacy bool) { logging.PrivacyMode = privacy }(logging.PrivacyMode)
+	// identifying lists the fields left out in privacy mode. The ones which
+	// are true are only served to admins by the status endpoints.
+	identifying := map[string]bool{
+		"Skylink":       false,
+		"RescanSkylink": false,
+		"Filename":      false,
+		"Submitter":     true,
+		"Reporter":      true,
+		"Uploaders":     true,
+	}
+	public := map[string]bool{
+		"ID": true, "Hash": true, "Status": true, "Infected": true, "InfectionDescription": true,
+		"ScannedAllContent": true, "ScannedAllOffsets": true, "Size": true, "ScannedSize": true,
+		"Timestamp": true, "SubmittedAt": true, "ScannedAt": true, "Failures": true, "LastErrorKind": true,
+		"LastError": true, "NotFound": true, "Blocker": true, "Reports": true, "FalsePositive": true,
+		"Priority": true, "Backfill": true, "Campaign": true, "PriorInfected": true, "Rollback": true,
+		"Unpinned": true, "VerdictSource": true, "SignatureVersion": true, "Severity": true,
+		"PolicyAction": true, "RootLength": true, "ContentType": true, "Directory": true, "Source": true,
+	}
+	typ := reflect.TypeOf(database.Skylink{})
+	var sl database.Skylink
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if _, ok := identifying[f.Name]; !ok && !public[f.Name] {
+			t.Fatalf("Field %s of skylink records is neither identifying nor public", f.Name)
+		}
+		reflect.ValueOf(&sl).Elem().Field(i).Set(nonZero(f.Type))
+	}
+	// check ensures the given fields of the response are cleared and the
+	// others are kept.
+	check := func(resp database.Skylink, cleared func(field string) bool) {
+		t.Helper()
+		for i := 0; i < typ.NumField(); i++ {
+			name := typ.Field(i).Name
+			got, expected := reflect.ValueOf(resp).Field(i), reflect.ValueOf(sl).Field(i)
+			if cleared(name) && !got.IsZero() {
+				t.Fatalf("Expected %s to be left out, got %v", name, got)
+			}
+			if !cleared(name) && !reflect.DeepEqual(got.Interface(), expected.Interface()) {
+				t.Fatalf("Expected %s to be kept, got %v", name, got)
+			}
+		}
+	}
+	none := func(string) bool { return false }
+	adminOnly := func(name string) bool { return identifying[name] }
+	private := func(name string) bool {
+		_, ok := identifying[name]
+		return ok
+	}
+
+	logging.PrivacyMode = false
+	check(privateSkylink(sl), none)
+	check(statusRecord(sl, true), none)
+	check(hashStatusRecord(sl, true), none)
+	check(statusRecord(sl, false), adminOnly)
+	check(hashStatusRecord(sl, false), func(name string) bool { return adminOnly(name) || name == "Skylink" })
+
+	logging.PrivacyMode = true
+	check(privateSkylink(sl), private)
+	check(statusRecord(sl, true), private)
+	check(statusRecord(sl, false), private)
+	check(hashStatusRecord(sl, true), private)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// quotaBanFactor is the multiple of their daily quota submitters are
+	// banned at for continuing to submit after they exceeded it.
+	quotaBanFactor = 2
+
+	// banReasonQuota is the reason of bans of submitters who kept
+	// submitting over their quota.
+	banReasonQuota = "quota"
+	// banReasonInvalid is the reason of bans of submitters who submitted
+	// too many invalid skylinks.
+	banReasonInvalid = "invalid"
+)
+
+var (
+	// SubmissionQuota is the number of skylinks each submitter, i.e. API
+	// key or IP address, may submit per UTC day through /scan and the
+	// upload hook. Submitters who keep submitting until they've attempted
+	// twice their quota are banned. Zero disables the quota.
+	// Set according to the MALWARE_SCANNER_SUBMISSION_QUOTA env var.
+	SubmissionQuota int64
+	// SubmissionInvalidLimit is the number of invalid skylinks a submitter
+	// may submit per UTC day before it's banned. Zero disables the limit.
+	// Set according to the MALWARE_SCANNER_SUBMISSION_INVALID_LIMIT env var.
+	SubmissionInvalidLimit int64
+	// SubmissionBanDuration is how long abusive submitters are banned for.
+	// Set according to the MALWARE_SCANNER_SUBMISSION_BAN_DURATION env var.
+	SubmissionBanDuration = 24 * time.Hour
+)
+
+type (
+	// bansResponse is the response to submitter ban requests.
+	bansResponse struct {
+		Bans []database.SubmitterBan `json:"bans"`
+	}
+)
+
+// submitter identifies who makes the request: the API key they pass as a
+// bearer token or, without one, their IP address. Keys are hashed, so they
+// aren't stored.
+func submitter(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		h := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+		return "key:" + hex.EncodeToString(h[:8])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// admitSubmissions counts the given number of submissions towards the
+// submitter's quota and returns whether they may be processed. If they may
+// not, it writes the error response. Failing to check the limits lets the
+// submissions through, so the DB being slow doesn't stop the portal's
+// uploads from being scanned.
+func (api *API) admitSubmissions(w http.ResponseWriter, r *http.Request, n int) bool {
+	if SubmissionQuota <= 0 && SubmissionInvalidLimit <= 0 {
+		return true
+	}
+	sub := submitter(r)
+	ban, err := api.staticDB.SubmitterBanned(r.Context(), sub)
+	if err != nil {
+		api.staticLogger.Warnf("Failed to check the ban of submitter %s: %s", sub, err)
+		return true
+	}
+	if ban != nil {
+		metricRejectedSubmissions.With("banned").Add(float64(n))
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(ban.Until).Seconds())+1))
+		skyapi.WriteError(w, skyapi.Error{fmt.Sprintf("submitter banned until %s for %s", ban.Until.Format(time.RFC3339), ban.Reason)}, http.StatusForbidden)
+		return false
+	}
+	if SubmissionQuota <= 0 {
+		return true
+	}
+	usage, err := api.staticDB.RecordSubmissions(r.Context(), sub, int64(n), 0)
+	if err != nil {
+		api.staticLogger.Warnf("Failed to count the submissions of submitter %s: %s", sub, err)
+		return true
+	}
+	if usage.Submitted <= SubmissionQuota {
+		return true
+	}
+	if usage.Submitted >= quotaBanFactor*SubmissionQuota && usage.Submitted-int64(n) < quotaBanFactor*SubmissionQuota {
+		api.ban(r, sub, banReasonQuota)
+	}
+	metricRejectedSubmissions.With("quota").Add(float64(n))
+	tomorrow := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(tomorrow).Seconds())+1))
+	skyapi.WriteError(w, skyapi.Error{fmt.Sprintf("daily quota of %d submissions exceeded", SubmissionQuota)}, http.StatusTooManyRequests)
+	return false
+}
+
+// recordInvalidSubmissions counts the given number of invalid submissions
+// and bans the submitter once it exceeds SubmissionInvalidLimit.
+func (api *API) recordInvalidSubmissions(r *http.Request, n int) {
+	if SubmissionInvalidLimit <= 0 || n == 0 {
+		return
+	}
+	sub := submitter(r)
+	usage, err := api.staticDB.RecordSubmissions(r.Context(), sub, 0, int64(n))
+	if err != nil {
+		api.staticLogger.Warnf("Failed to count the invalid submissions of submitter %s: %s", sub, err)
+		return
+	}
+	if usage.Invalid > SubmissionInvalidLimit && usage.Invalid-int64(n) <= SubmissionInvalidLimit {
+		api.ban(r, sub, banReasonInvalid)
+	}
+}
+
+// ban bans the given submitter for SubmissionBanDuration.
+func (api *API) ban(r *http.Request, sub, reason string) {
+	err := api.staticDB.BanSubmitter(r.Context(), sub, reason, time.Now().Add(SubmissionBanDuration))
+	if err != nil {
+		api.staticLogger.Errorf("Failed to ban submitter %s: %s", sub, err)
+		return
+	}
+	metricSubmitterBans.With(reason).Inc()
+	api.staticLogger.Warnf("Banned submitter %s for %s for %s", sub, SubmissionBanDuration, reason)
+}
+
+// adminBansGET returns the active bans of abusive submitters.
+func (api *API) adminBansGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	bans, err := api.staticDB.SubmitterBans(r.Context())
+	if err != nil {
+		api.staticLogger.Warnf("adminBansGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, bansResponse{bans})
+}
+
+// adminBanDELETE lifts the ban of a submitter.
+func (api *API) adminBanDELETE(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sub := ps.ByName("submitter")
+	if !strings.HasPrefix(sub, "ip:") && !strings.HasPrefix(sub, "key:") || validateString(sub) != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid submitter"}, http.StatusBadRequest)
+		return
+	}
+	err := api.staticDB.LiftSubmitterBan(r.Context(), sub)
+	api.audit(r, actionLiftBan, map[string]string{"submitter": sub}, err)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"submitter not banned"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("adminBanDELETE failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteSuccess(w)
+}
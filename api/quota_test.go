@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSubmitter ensures we identify submitters by their hashed API key or,
+// without one, by their IP address.
+func TestSubmitter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/scan/abc", nil)
+	req.RemoteAddr = "203.0.113.7:4321"
+	if sub := submitter(req); sub != "ip:203.0.113.7" {
+		t.Fatalf("Unexpected submitter %s", sub)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	sub := submitter(req)
+	if !strings.HasPrefix(sub, "key:") || len(sub) != len("key:")+16 || strings.Contains(sub, "secret") {
+		t.Fatalf("Unexpected submitter %s", sub)
+	}
+	req.Header.Set("Authorization", "Bearer other")
+	if submitter(req) == sub {
+		t.Fatal("Expected different keys to be different submitters")
+	}
+}
+
+// TestAdmitSubmissionsDisabled ensures submissions aren't counted, so they
+// don't hit the DB, without a quota or an invalid limit.
+func TestAdmitSubmissionsDisabled(t *testing.T) {
+	defer func(q, l int64) { SubmissionQuota, SubmissionInvalidLimit = q, l }(SubmissionQuota, SubmissionInvalidLimit)
+	SubmissionQuota, SubmissionInvalidLimit = 0, 0
+	api := &API{}
+	w := httptest.NewRecorder()
+	if !api.admitSubmissions(w, httptest.NewRequest(http.MethodPost, "/scan/abc", nil), 1) {
+		t.Fatal("Expected submissions to be admitted")
+	}
+	api.recordInvalidSubmissions(httptest.NewRequest(http.MethodPost, "/scan/abc", nil), 1)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", w.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// defaultReportLogLimit is the number of entries /admin/reports returns
+	// by default.
+	defaultReportLogLimit = 100
+	// maxReportLogLimit is the maximum number of entries /admin/reports can
+	// return.
+	maxReportLogLimit = 1000
+)
+
+type (
+	// reportLogResponse is the response to report log requests.
+	reportLogResponse struct {
+		Entries []database.ReportLogEntry `json:"entries"`
+	}
+
+	// reportLogVerifyResponse is the response of /admin/reports/verify.
+	reportLogVerifyResponse struct {
+		Verified int64  `json:"verified"`
+		Head     string `json:"head"`
+		Intact   bool   `json:"intact"`
+		Error    string `json:"error,omitempty"`
+	}
+)
+
+// adminReportsGET returns the entries of the report log after the `after`
+// sequence number, oldest first, up to `limit` entries.
+func (api *API) adminReportsGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	p := newParams(r)
+	after := p.Int64("after", 0, 0, math.MaxInt64)
+	limit := p.Int("limit", defaultReportLogLimit, 1, maxReportLogLimit)
+	if p.invalid(w) {
+		return
+	}
+	entries, err := api.staticDB.ReportLogEntries(r.Context(), after, limit)
+	if err != nil {
+		api.staticLogger.Warnf("adminReportsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	if logging.PrivacyMode {
+		for i := range entries {
+			entries[i].Skylink = ""
+		}
+	}
+	skyapi.WriteJSON(w, reportLogResponse{entries})
+}
+
+// adminReportsVerifyGET verifies the hash chain of the whole report log and
+// returns the hash of its last intact entry.
+func (api *API) adminReportsVerifyGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	n, head, err := api.staticDB.VerifyReportLog(r.Context())
+	if err != nil && !errors.Contains(err, database.ErrReportLogTampered) {
+		api.staticLogger.Warnf("adminReportsVerifyGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	resp := reportLogVerifyResponse{Verified: n, Head: head, Intact: err == nil}
+	if err != nil {
+		api.staticLogger.Errorf("The report log failed to verify: %s", err)
+		resp.Error = err.Error()
+	}
+	skyapi.WriteJSON(w, resp)
+}
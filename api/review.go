@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// actionApproveReview is the audited action of approving a detection
+	// which is pending review, so it's reported to blocker.
+	actionApproveReview = "approve_review"
+	// actionRejectReview is the audited action of rejecting a detection
+	// which is pending review, which marks it as a false positive.
+	actionRejectReview = "reject_review"
+
+	// defaultReviewsLimit is the number of detections /admin/reviews returns
+	// by default.
+	defaultReviewsLimit = 100
+	// maxReviewsLimit is the maximum number of detections /admin/reviews
+	// returns.
+	maxReviewsLimit = 1000
+)
+
+// reviewsResponse is the response of /admin/reviews.
+type reviewsResponse struct {
+	Reviews []database.Skylink `json:"reviews"`
+}
+
+// adminReviewsGET lists the detections which are pending review, the most
+// severe first. The `limit` parameter caps their number.
+func (api *API) adminReviewsGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	p := newParams(r)
+	limit := p.Int("limit", defaultReviewsLimit, 1, maxReviewsLimit)
+	if p.invalid(w) {
+		return
+	}
+	sls, err := api.staticDB.PendingReviews(r.Context(), limit)
+	if err != nil {
+		api.staticLogger.Warnf("adminReviewsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	for i := range sls {
+		sls[i] = privateSkylink(sls[i])
+	}
+	skyapi.WriteJSON(w, reviewsResponse{Reviews: sls})
+}
+
+// adminReviewApprovePOST approves the detection of the record with the given
+// hash, which is pending review, so it's reported to blocker.
+func (api *API) adminReviewApprovePOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	api.review(w, r, ps, actionApproveReview, api.staticDB.SkylinkApproveReview)
+}
+
+// adminReviewRejectPOST rejects the detection of the record with the given
+// hash, which is pending review, and marks the record as a false positive.
+func (api *API) adminReviewRejectPOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	api.review(w, r, ps, actionRejectReview, api.staticDB.SkylinkRejectReview)
+}
+
+// review applies the given review to the record with the hash in the path,
+// audits it as the given action and responds with the updated record.
+func (api *API) review(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string, review func(ctx context.Context, hash crypto.Hash) (*database.Skylink, error)) {
+	params := map[string]string{"hash": ps.ByName("hash")}
+	hash, err := parseHash(ps.ByName("hash"))
+	if err != nil {
+		api.audit(r, action, params, err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	sl, err := review(r.Context(), hash)
+	api.audit(r, action, params, err)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		skyapi.WriteError(w, skyapi.Error{"no detection of this hash is pending review"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.staticLogger.Warnf("%s failed: %s", action, err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, privateSkylink(*sl))
+}
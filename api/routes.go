@@ -1,7 +1,60 @@
 package api
 
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
 // buildHTTPRoutes registers all HTTP routes and their handlers.
 func (api *API) buildHTTPRoutes() {
-	api.staticRouter.GET("/health", api.healthGET)
-	api.staticRouter.POST("/scan/:skylink", api.scanPOST)
+	api.handle(http.MethodGet, "/health", api.healthGET)
+	api.handle(http.MethodGet, "/metrics", api.metricsGET)
+	api.handle(http.MethodGet, "/stats", api.statsGET)
+	api.handle(http.MethodGet, "/stats/signatures", api.statsSignaturesGET)
+	api.handle(http.MethodPost, "/scan/:skylink", withSubmitAllowlist(api.scanPOST))
+	api.handle(http.MethodPost, "/scanroot/:root", withSubmitAllowlist(api.scanRootPOST))
+	api.handle(http.MethodGet, "/status/:skylink", api.statusGET)
+	api.handle(http.MethodPost, "/status", api.bulkStatusPOST)
+	api.handle(http.MethodPost, "/hooks/upload", withSubmitAllowlist(api.uploadHookPOST))
+	api.handle(http.MethodPost, "/hooks/signatures", withSubmitAllowlist(api.signatureHookPOST))
+	api.handle(http.MethodGet, "/federation/verdicts", withFederation(api.federationVerdictsGET))
+
+	api.handle(http.MethodGet, "/debug/state", withAdmin(api.debugStateGET))
+	api.handle(http.MethodPost, "/admin/pause", withAdmin(api.adminPausePOST))
+	api.handle(http.MethodPost, "/admin/resume", withAdmin(api.adminResumePOST))
+	api.handle(http.MethodPost, "/admin/rescan/:skylink", withAdmin(api.adminRescanPOST))
+	api.handle(http.MethodPost, "/admin/falsepositive/:skylink", withAdmin(api.withConfirmation(actionFalsePositive, api.adminFalsePositivePOST)))
+	api.handle(http.MethodDelete, "/admin/skylink/:skylink", withAdmin(api.withConfirmation(actionPurge, api.adminPurgeDELETE)))
+	api.handle(http.MethodGet, "/admin/blocker/:skylink", withAdmin(api.adminBlockerStatusGET))
+	api.handle(http.MethodGet, "/admin/audit", withAdmin(api.adminAuditGET))
+	api.handle(http.MethodGet, "/admin/reports", withAdmin(api.adminReportsGET))
+	api.handle(http.MethodGet, "/admin/reports/verify", withAdmin(api.adminReportsVerifyGET))
+	api.handle(http.MethodGet, "/admin/uploaders", withAdmin(api.adminUploadersGET))
+	api.handle(http.MethodGet, "/admin/bans", withAdmin(api.adminBansGET))
+	api.handle(http.MethodDelete, "/admin/bans/:submitter", withAdmin(api.adminBanDELETE))
+	api.handle(http.MethodPost, "/admin/export", withAdmin(api.adminExportPOST))
+	api.handle(http.MethodPost, "/admin/backfills", withAdmin(api.adminBackfillPOST))
+	api.handle(http.MethodGet, "/admin/backfills/:id", withAdmin(api.adminBackfillGET))
+	api.handle(http.MethodPost, "/admin/backfills/:id/skylinks", withAdmin(api.adminBackfillSkylinksPOST))
+	api.handle(http.MethodGet, "/admin/reviews", withAdmin(api.adminReviewsGET))
+	api.handle(http.MethodPost, "/admin/reviews/:hash/approve", withAdmin(api.adminReviewApprovePOST))
+	api.handle(http.MethodPost, "/admin/reviews/:hash/reject", withAdmin(api.adminReviewRejectPOST))
+	api.handle(http.MethodPost, "/admin/campaigns", withAdmin(api.adminCampaignPOST))
+	api.handle(http.MethodPost, "/admin/signatures/:signature/withdraw", withAdmin(api.withConfirmation(actionWithdrawSignature, api.adminWithdrawSignaturePOST)))
+	api.handle(http.MethodGet, "/admin/signatures/withdrawn", withAdmin(api.adminSignatureWithdrawalsGET))
+	api.handle(http.MethodGet, "/admin/campaigns/:id", withAdmin(api.adminCampaignGET))
+	if ImportEnabled {
+		api.handle(http.MethodPost, "/admin/import", withAdmin(api.adminImportPOST))
+	}
+	if GraphQLEnabled {
+		api.handle(http.MethodGet, "/graphql", withAdmin(api.graphQLPOST))
+		api.handle(http.MethodPost, "/graphql", withAdmin(api.graphQLPOST))
+	}
+}
+
+// handle registers the given handler for the given method and path, wrapped
+// in the middleware every route uses.
+func (api *API) handle(method, path string, h httprouter.Handle) {
+	api.staticRouter.Handle(method, path, withMetrics(path, h))
 }
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// maxSignatureHookBodySize is the maximum size of a signature hook
+	// request.
+	maxSignatureHookBodySize = 1 << 12
+	// signatureSourceClamd is the source of signature updates whose version
+	// we looked up in clamd because the hook didn't provide one.
+	signatureSourceClamd = "clamd"
+	// signatureSourceHook is the default source of signature updates.
+	signatureSourceHook = "hook"
+)
+
+var (
+	// SignatureHookToken is the bearer token the freshclam tooling must
+	// present when calling the signature hook. The hook is open when it's
+	// empty.
+	// Set according to the MALWARE_SCANNER_SIGNATURE_HOOK_TOKEN env var.
+	SignatureHookToken string
+)
+
+type (
+	// signatureHookRequest is the optional body of signature hook requests.
+	signatureHookRequest struct {
+		Version int       `json:"version"`
+		Date    time.Time `json:"date"`
+		Source  string    `json:"source"`
+	}
+
+	// signatureHookResponse is the response to signature hook requests.
+	signatureHookResponse struct {
+		database.SignatureUpdate
+		New bool `json:"new"`
+	}
+)
+
+// signatureHookPOST records that ClamAV's signatures were updated and triggers
+// a re-scan of recent clean skylinks if it's a new version. freshclam's
+// OnUpdateExecute can call it. The body may give the new version, e.g.
+// {"version":26391}, otherwise we ask clamd for the version it's running.
+func (api *API) signatureHookPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !hookAuthorized(r, SignatureHookToken) {
+		skyapi.WriteError(w, skyapi.Error{"invalid signature hook token"}, http.StatusUnauthorized)
+		return
+	}
+	body, err := readHookBody(r, maxSignatureHookBodySize)
+	if errors.Contains(err, blocker.ErrInvalidSignature) {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	su, err := parseSignatureHook(bytes.NewReader(body))
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if su.Version == 0 {
+		si, err := api.staticClamAV.SignatureInfo()
+		if err != nil {
+			skyapi.WriteError(w, skyapi.Error{errors.AddContext(err, "failed to fetch the signature version").Error()}, http.StatusServiceUnavailable)
+			return
+		}
+		su.Version = si.Version
+		su.Date = si.Date
+		su.Source = signatureSourceClamd
+	}
+	su.ReceivedAt = time.Now().UTC()
+	isNew, err := api.staticScanner.SignaturesUpdated(r.Context(), su)
+	if err != nil {
+		api.staticLogger.Warnf("signatureHookPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, signatureHookResponse{SignatureUpdate: su, New: isNew})
+}
+
+// parseSignatureHook parses the optional body of a signature hook request. The
+// version is zero if the body doesn't give one.
+func parseSignatureHook(body io.Reader) (database.SignatureUpdate, error) {
+	var req signatureHookRequest
+	err := json.NewDecoder(body).Decode(&req)
+	if err != nil && err != io.EOF {
+		return database.SignatureUpdate{}, errors.AddContext(err, "invalid signature hook body")
+	}
+	if req.Version < 0 {
+		return database.SignatureUpdate{}, errors.New("invalid signature version")
+	}
+	if req.Source == "" {
+		req.Source = signatureSourceHook
+	}
+	return database.SignatureUpdate{
+		Version: req.Version,
+		Date:    req.Date.UTC(),
+		Source:  req.Source,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/julienschmidt/httprouter"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// actionExport is the audited action of exporting skylink records.
+	actionExport = "export"
+	// actionImport is the audited action of importing skylink records.
+	actionImport = "import"
+
+	// maxImportBodySize is the maximum size of an import request.
+	maxImportBodySize = 64 << 20
+)
+
+var (
+	// ImportEnabled enables the import of exported skylink records. Imports
+	// overwrite records, so they are meant for dev environments only.
+	// Set according to the MALWARE_SCANNER_IMPORT env var.
+	ImportEnabled bool
+)
+
+type (
+	// importResponse is the response to import requests.
+	importResponse struct {
+		Imported int `json:"imported"`
+	}
+)
+
+// adminExportPOST exports the records of the given skylinks together with
+// their history. It takes the same body as bulk status requests. In privacy
+// mode the export leaves out the skylinks, like the status endpoints do.
+func (api *API) adminExportPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req bulkStatusRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBulkStatusBodySize)).Decode(&req)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid request body: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if len(req.Skylinks) == 0 || len(req.Skylinks) > maxBulkStatusSkylinks {
+		skyapi.WriteError(w, skyapi.Error{fmt.Sprintf("between 1 and %d skylinks must be provided", maxBulkStatusSkylinks)}, http.StatusBadRequest)
+		return
+	}
+	hashes := make([]crypto.Hash, 0, len(req.Skylinks))
+	for _, s := range req.Skylinks {
+		sl, err := parseSkylink(s, api.staticClamAV.PreferredPortal())
+		if err != nil {
+			skyapi.WriteError(w, skyapi.Error{fmt.Sprintf("invalid skylink '%s': %s", s, err)}, http.StatusBadRequest)
+			return
+		}
+		hashes = append(hashes, sl.Hash)
+	}
+	s, err := api.staticDB.ExportSnapshot(r.Context(), hashes)
+	params := map[string]string{"skylinks": strconv.Itoa(len(hashes))}
+	if err == nil {
+		params["records"] = strconv.Itoa(len(s.Records))
+	}
+	api.audit(r, actionExport, params, err)
+	if err != nil {
+		api.staticLogger.Warnf("adminExportPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	if logging.PrivacyMode {
+		for i := range s.Records {
+			s.Records[i].Record = privateSkylink(s.Records[i].Record)
+			s.Records[i].RescanSkylink = ""
+			for j := range s.Records[i].Reports {
+				s.Records[i].Reports[j].Skylink = ""
+			}
+		}
+	}
+	skyapi.WriteJSON(w, s)
+}
+
+// adminImportPOST imports the records of an export, replacing the records of
+// the same skylinks. It's only available while ImportEnabled is set.
+func (api *API) adminImportPOST(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var s database.Snapshot
+	err := json.NewDecoder(io.LimitReader(r.Body, maxImportBodySize)).Decode(&s)
+	if err != nil {
+		skyapi.WriteError(w, skyapi.Error{"invalid request body: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	for _, rec := range s.Records {
+		if rec.Record.Hash == (crypto.Hash{}) {
+			skyapi.WriteError(w, skyapi.Error{"every record must have a hash"}, http.StatusBadRequest)
+			return
+		}
+	}
+	n, err := api.staticDB.ImportSnapshot(r.Context(), &s)
+	api.audit(r, actionImport, map[string]string{"records": strconv.Itoa(n)}, err)
+	if err != nil {
+		api.staticLogger.Warnf("adminImportPOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, importResponse{n})
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// maxParamLength is the maximum length of free-form string parameters,
+	// like the audit log's caller.
+	maxParamLength = 256
+)
+
+// params validates the query parameters of a request. Each getter returns the
+// parameter's value, or the given default if it's not set, and remembers the
+// first invalid parameter. Handlers read all their parameters and then call
+// invalid, which responds with a 400 if any of them was invalid.
+type params struct {
+	r   *http.Request
+	err error
+}
+
+// newParams returns a validator of the given request's parameters.
+func newParams(r *http.Request) *params {
+	return &params{r: r}
+}
+
+// fail records the given validation error, unless there already is one.
+func (p *params) fail(msg string) {
+	if p.err == nil {
+		p.err = errors.New(msg)
+	}
+}
+
+// Err returns the first validation error.
+func (p *params) Err() error {
+	return p.err
+}
+
+// invalid responds with a 400 and returns true if any parameter was invalid.
+func (p *params) invalid(w http.ResponseWriter) bool {
+	if p.err == nil {
+		return false
+	}
+	skyapi.WriteError(w, skyapi.Error{p.err.Error()}, http.StatusBadRequest)
+	return true
+}
+
+// Int returns the given integer parameter, which must be between min and max.
+func (p *params) Int(name string, def, min, max int) int {
+	s := p.r.FormValue(name)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		p.fail("invalid " + name + " parameter")
+		return def
+	}
+	return n
+}
+
+// Int64 returns the given 64-bit integer parameter, which must be between min
+// and max.
+func (p *params) Int64(name string, def, min, max int64) int64 {
+	s := p.r.FormValue(name)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < min || n > max {
+		p.fail("invalid " + name + " parameter")
+		return def
+	}
+	return n
+}
+
+// Time returns the given time parameter, given either as an RFC3339 timestamp
+// or as a YYYY-MM-DD date.
+func (p *params) Time(name string, def time.Time) time.Time {
+	s := p.r.FormValue(name)
+	if s == "" {
+		return def
+	}
+	t, err := parseTime(s)
+	if err != nil {
+		p.fail("invalid " + name + " parameter")
+		return def
+	}
+	return t
+}
+
+// String returns the given free-form string parameter, which must be at most
+// maxParamLength long and can't contain control characters.
+func (p *params) String(name string) string {
+	s := p.r.FormValue(name)
+	if err := validateString(s); err != nil {
+		p.fail("invalid " + name + " parameter: " + err.Error())
+		return ""
+	}
+	return s
+}
+
+// Range checks that the given range, whose ends are optional, isn't empty.
+func (p *params) Range(from, to time.Time) {
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		p.fail("from must be before to")
+	}
+}
+
+// validateString returns an error if the given free-form string is longer than
+// maxParamLength or contains control characters.
+func validateString(s string) error {
+	if len(s) > maxParamLength {
+		return errors.New("too long")
+	}
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return errors.New("contains control characters")
+	}
+	return nil
+}
+
+// parseSkylink parses the given string into a skylink and validates it.
+func parseSkylink(s, portal string) (*database.Skylink, error) {
+	if s == "" {
+		return nil, errors.New("empty skylink")
+	}
+	var sl database.Skylink
+	err := sl.LoadString(s, portal)
+	if err != nil {
+		return nil, err
+	}
+	return &sl, nil
+}
+
+// parseHash parses the hex-encoded hash of a skylink's merkle root, as records
+// are identified by when skylinks are kept private.
+func parseHash(s string) (crypto.Hash, error) {
+	var h crypto.Hash
+	if len(s) != 2*crypto.HashSize {
+		return h, errors.New("hash must be 64 hex characters")
+	}
+	if err := h.LoadString(s); err != nil {
+		return h, errors.AddContext(err, "invalid hash")
+	}
+	return h, nil
+}
+
+// parseTime parses a timestamp given either in RFC3339 format or as a
+// YYYY-MM-DD date.
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", s)
+}
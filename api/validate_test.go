@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	accdb "github.com/SkynetLabs/skynet-accounts/database"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestParams ensures the parameter validator returns the defaults for missing
+// parameters, the values of valid ones and the first error for invalid ones.
+func TestParams(t *testing.T) {
+	request := func(query string) *params {
+		return newParams(httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+	}
+	def := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	p := request("limit=10&after=5&from=2022-01-01&caller=alice")
+	if n := p.Int("limit", 100, 1, 1000); n != 10 {
+		t.Fatalf("Unexpected limit %d", n)
+	}
+	if n := p.Int("missing", 100, 1, 1000); n != 100 {
+		t.Fatalf("Unexpected default %d", n)
+	}
+	if n := p.Int64("after", 0, 0, 10); n != 5 {
+		t.Fatalf("Unexpected after %d", n)
+	}
+	from := p.Time("from", def)
+	if !from.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected from %s", from)
+	}
+	p.Range(from, def)
+	if s := p.String("caller"); s != "alice" || p.Err() != nil {
+		t.Fatalf("Unexpected caller %s, %v", s, p.Err())
+	}
+
+	tests := []struct {
+		query    string
+		validate func(p *params)
+		expected string
+	}{
+		{"limit=0", func(p *params) { p.Int("limit", 100, 1, 1000) }, "invalid limit parameter"},
+		{"limit=1001", func(p *params) { p.Int("limit", 100, 1, 1000) }, "invalid limit parameter"},
+		{"limit=abc", func(p *params) { p.Int("limit", 100, 1, 1000) }, "invalid limit parameter"},
+		{"limit=99999999999999999999", func(p *params) { p.Int("limit", 100, 1, 1000) }, "invalid limit parameter"},
+		{"after=-1", func(p *params) { p.Int64("after", 0, 0, 10) }, "invalid after parameter"},
+		{"to=yesterday", func(p *params) { p.Time("to", def) }, "invalid to parameter"},
+		{"from=2022-03-02", func(p *params) { p.Range(p.Time("from", def), def) }, "from must be before to"},
+		{"caller=" + strings.Repeat("a", maxParamLength+1), func(p *params) { p.String("caller") }, "invalid caller parameter: too long"},
+		{"caller=" + url.QueryEscape("alice\x00"), func(p *params) { p.String("caller") }, "invalid caller parameter: contains control characters"},
+		// Only the first error is kept.
+		{"limit=0&to=x", func(p *params) { p.Int("limit", 100, 1, 1000); p.Time("to", def) }, "invalid limit parameter"},
+	}
+	for _, tt := range tests {
+		p := request(tt.query)
+		tt.validate(p)
+		w := httptest.NewRecorder()
+		if !p.invalid(w) || w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.expected) {
+			t.Fatalf("Query '%s': expected a 400 with '%s', got %d %s", tt.query, tt.expected, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestParseHash ensures we only accept hex-encoded hashes of the right length.
+func TestParseHash(t *testing.T) {
+	s := strings.Repeat("ab", 32)
+	h, err := parseHash(s)
+	if err != nil || h.String() != s {
+		t.Fatalf("Unexpected hash %s, %v", h, err)
+	}
+	for _, s := range []string{"", "ab", strings.Repeat("ab", 33), strings.Repeat("zz", 32), "AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw"} {
+		if _, err = parseHash(s); err == nil {
+			t.Fatalf("Expected an error for '%s'", s)
+		}
+	}
+}
+
+// FuzzParseTime ensures parsing arbitrary times doesn't panic and yields UTC
+// timestamps.
+func FuzzParseTime(f *testing.F) {
+	for _, s := range []string{"2022-01-01", "2022-01-01T12:00:00Z", "2022-01-01T12:00:00.123+02:00", "", "9999-99-99"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		tm, err := parseTime(s)
+		if err == nil && tm.Location() != time.UTC {
+			t.Fatalf("Expected a UTC time for '%s', got %s", s, tm)
+		}
+	})
+}
+
+// FuzzParseHash ensures parsing arbitrary hashes doesn't panic and that the
+// hashes we accept round-trip.
+func FuzzParseHash(f *testing.F) {
+	for _, s := range []string{strings.Repeat("ab", 32), strings.Repeat("AB", 32), "", "0x"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		h, err := parseHash(s)
+		if err == nil && !strings.EqualFold(h.String(), s) {
+			t.Fatalf("Hash '%s' parsed as %s", s, h)
+		}
+	})
+}
+
+// FuzzParams ensures arbitrary query strings either validate within bounds or
+// yield a 400.
+func FuzzParams(f *testing.F) {
+	for _, s := range []string{"limit=10&from=2022-01-01&to=2022-02-01", "limit=-1", "from=x", "caller=%00", "limit=1&limit=2"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.RawQuery = query
+		p := newParams(req)
+		limit := p.Int("limit", 100, 1, 1000)
+		from := p.Time("from", time.Time{})
+		to := p.Time("to", time.Time{})
+		p.Range(from, to)
+		caller := p.String("caller")
+		if p.Err() != nil {
+			if !p.invalid(httptest.NewRecorder()) {
+				t.Fatal("Expected an invalid request")
+			}
+			return
+		}
+		if limit < 1 || limit > 1000 || validateString(caller) != nil {
+			t.Fatalf("Query '%s' validated out of bounds", query)
+		}
+		if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+			t.Fatalf("Query '%s' validated an empty range", query)
+		}
+	})
+}
+
+// FuzzParseUploadHook ensures parsing arbitrary upload hook bodies doesn't
+// panic.
+func FuzzParseUploadHook(f *testing.F) {
+	for _, s := range []string{`{"skylink":"AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw"}`, `{"skylinks":["a","b"]}`, `[`, ``, `null`} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		_, _ = parseUploadHook(bytes.NewReader(body))
+	})
+}
+
+// FuzzParseSkylink ensures parsing arbitrary skylinks, as given directly or in
+// upload hooks, doesn't panic and only accepts valid skylinks.
+func FuzzParseSkylink(f *testing.F) {
+	v1 := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("skynet-skylink", v1)
+	}))
+	defer portal.Close()
+	for _, s := range []string{v1, "AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw", "sia://" + v1, "https://siasky.net/" + v1 + "/index.html?x=1", " " + v1 + "\n", "", "not-a-skylink"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		extracted := extractSkylink(s)
+		if extracted != strings.TrimSpace(s) && !accdb.ValidSkylinkHash(extracted) {
+			t.Fatalf("Extracted invalid skylink '%s' from '%s'", extracted, s)
+		}
+		for _, in := range []string{s, extracted} {
+			sl, err := parseSkylink(in, portal.URL)
+			if err != nil {
+				continue
+			}
+			if !accdb.ValidSkylinkHash(in) || sl.Skylink != in || sl.Hash == (crypto.Hash{}) {
+				t.Fatalf("Skylink '%s' parsed as %+v", in, sl)
+			}
+		}
+	})
+}
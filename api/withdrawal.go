@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/julienschmidt/httprouter"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+)
+
+const (
+	// actionWithdrawSignature is the audited action of withdrawing a ClamAV
+	// signature.
+	actionWithdrawSignature = "withdraw_signature"
+)
+
+// signatureWithdrawalsResponse is the response of /admin/signatures/withdrawn.
+type signatureWithdrawalsResponse struct {
+	Withdrawals []database.SignatureWithdrawal `json:"withdrawals"`
+}
+
+// adminWithdrawSignaturePOST withdraws a ClamAV signature, e.g. after upstream
+// confirmed it as a false positive: its detections are ignored from now on and
+// the skylinks blocked under it are scanned again. The optional `reason`
+// parameter is recorded with it.
+func (api *API) adminWithdrawSignaturePOST(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sig := ps.ByName("signature")
+	p := newParams(r)
+	reason := p.String("reason")
+	if err := validateString(sig); err != nil || sig == "" {
+		p.fail("invalid signature")
+	}
+	if p.invalid(w) {
+		return
+	}
+	sw, err := api.staticDB.WithdrawSignature(r.Context(), database.SignatureWithdrawal{
+		Signature: sig,
+		Reason:    reason,
+		Caller:    caller(r),
+	})
+	params := map[string]string{"signature": sig, "reason": reason}
+	if err == nil {
+		params["queued"] = strconv.FormatInt(sw.Queued, 10)
+	}
+	api.audit(r, actionWithdrawSignature, params, err)
+	if err != nil {
+		api.staticLogger.Warnf("adminWithdrawSignaturePOST failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	api.staticLogger.Infof("Withdrew signature %s, queued %d skylinks for rollback, skipped %d", sig, sw.Queued, sw.Skipped)
+	skyapi.WriteJSON(w, sw)
+}
+
+// adminSignatureWithdrawalsGET lists the withdrawn signatures with the progress
+// of their rollbacks.
+func (api *API) adminSignatureWithdrawalsGET(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ws, err := api.staticDB.SignatureWithdrawals(r.Context())
+	if err != nil {
+		api.staticLogger.Warnf("adminSignatureWithdrawalsGET failed: %s", err)
+		skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	skyapi.WriteJSON(w, signatureWithdrawalsResponse{Withdrawals: ws})
+}
@@ -0,0 +1,141 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/SkynetLabs/malware-scanner/metrics"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// cursorName is the name of the cursor which tracks the last exported
+	// day.
+	cursorName = "archive_s3"
+	// checkInterval defines how often we check for days to export.
+	checkInterval = time.Hour
+	// day is the period covered by a single dump.
+	day = 24 * time.Hour
+)
+
+var (
+	// metricExportedRecords counts the scan results written to the archive.
+	metricExportedRecords = metrics.NewCounter("archive_exported_records_total", "Number of scan results written to the archive.")
+	// metricExportFailures counts the failed daily dumps.
+	metricExportFailures = metrics.NewCounter("archive_export_failures_total", "Number of failed daily archive dumps.")
+)
+
+type (
+	// Uploader stores objects, e.g. in an S3-compatible bucket.
+	Uploader interface {
+		Put(ctx context.Context, key, contentType string, body []byte) error
+	}
+)
+
+// Start launches a background thread which writes a gzipped NDJSON dump of the
+// scan results of every completed UTC day to the given uploader, until the
+// context is cancelled. Dumps are stored under
+// `{prefix}scans/YYYY/MM/DD.ndjson.gz`. The last exported day is stored in the
+// database, so no day is skipped across restarts. The first run exports the
+// days of the last lookback period.
+func Start(ctx context.Context, db *database.DB, u Uploader, prefix string, lookback time.Duration, logger *logrus.Logger) error {
+	if db == nil {
+		return errors.New("no DB provided")
+	}
+	if u == nil {
+		return errors.New("no uploader provided")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			err := exportDays(ctx, db, u, prefix, lookback, logger)
+			if err != nil {
+				metricExportFailures.Inc()
+				logger.Warnln(errors.AddContext(err, "failed to archive scan results"))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// exportDays exports all completed days which haven't been exported yet.
+func exportDays(ctx context.Context, db *database.DB, u Uploader, prefix string, lookback time.Duration, logger *logrus.Logger) error {
+	last, err := db.Cursor(ctx, cursorName)
+	if err != nil {
+		return err
+	}
+	today := time.Now().UTC().Truncate(day)
+	next := last.Add(day)
+	if last.IsZero() {
+		next = today.Add(-lookback).Truncate(day)
+	}
+	for ; next.Before(today); next = next.Add(day) {
+		n, err := exportDay(ctx, db, u, prefix, next)
+		if err != nil {
+			return errors.AddContext(err, "failed to archive "+next.Format("2006-01-02"))
+		}
+		err = db.SetCursor(ctx, cursorName, next)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Archived %d scan results of %s", n, next.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// exportDay writes the dump of the scan results of the day starting at the
+// given time. It returns the number of exported records.
+func exportDay(ctx context.Context, db *database.DB, u Uploader, prefix string, start time.Time) (int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	var count int
+	err := db.ForEachScanned(ctx, start, start.Add(day), func(sl database.Skylink) error {
+		count++
+		return enc.Encode(record(sl))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = gz.Close(); err != nil {
+		return 0, errors.AddContext(err, "failed to compress dump")
+	}
+	err = u.Put(ctx, Key(prefix, start), "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	metricExportedRecords.Add(float64(count))
+	return count, nil
+}
+
+// record returns the archived version of the given scan result. We leave out
+// the uploaders, so the archive holds no personal data of portal users, and
+// the skylink in privacy mode.
+func record(sl database.Skylink) database.Skylink {
+	sl.Uploaders = nil
+	if logging.PrivacyMode {
+		sl.Skylink = ""
+	}
+	return sl
+}
+
+// Key returns the object key of the dump of the day starting at the given
+// time.
+func Key(prefix string, start time.Time) string {
+	return prefix + "scans/" + start.Format("2006/01/02") + ".ndjson.gz"
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// amzDateFormat is the timestamp format of AWS signature version 4.
+	amzDateFormat = "20060102T150405Z"
+	// amzShortDateFormat is the date format of AWS signature version 4
+	// credential scopes.
+	amzShortDateFormat = "20060102"
+)
+
+type (
+	// S3Options configures the connection to an S3-compatible bucket.
+	// Endpoint is the URL of the storage service, e.g.
+	// "https://s3.us-east-1.amazonaws.com" or a MinIO server. Objects are
+	// addressed path-style, i.e. as {Endpoint}/{Bucket}/{key}, which all
+	// S3-compatible services support.
+	S3Options struct {
+		Endpoint  string
+		Bucket    string
+		Region    string
+		AccessKey string
+		SecretKey string
+	}
+
+	// S3Uploader uploads objects to an S3-compatible bucket.
+	S3Uploader struct {
+		staticEndpoint  string
+		staticBucket    string
+		staticRegion    string
+		staticAccessKey string
+		staticSecretKey string
+		staticClient    *http.Client
+	}
+)
+
+// NewS3Uploader returns an uploader to the bucket with the given options.
+// Region defaults to us-east-1.
+func NewS3Uploader(opts S3Options) (*S3Uploader, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid S3 endpoint: " + opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("missing S3 bucket")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, errors.New("missing S3 credentials")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	return &S3Uploader{
+		staticEndpoint:  strings.TrimSuffix(opts.Endpoint, "/"),
+		staticBucket:    opts.Bucket,
+		staticRegion:    opts.Region,
+		staticAccessKey: opts.AccessKey,
+		staticSecretKey: opts.SecretKey,
+		staticClient:    &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// Put uploads the given object under the given key, replacing any existing
+// object with the same key.
+func (s *S3Uploader) Put(ctx context.Context, key, contentType string, body []byte) error {
+	u := s.staticEndpoint + "/" + escapePath(s.staticBucket+"/"+key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.AddContext(err, "failed to build S3 request")
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", contentType)
+	signV4(req, hex.EncodeToString(sum[:]), s.staticAccessKey, s.staticSecretKey, s.staticRegion, time.Now())
+	res, err := s.staticClient.Do(req)
+	if err != nil {
+		return errors.AddContext(err, "failed to call S3")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return errors.New(fmt.Sprintf("S3 upload failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	return nil
+}
+
+// signV4 signs the given S3 request with AWS signature version 4. It signs the
+// host and all headers set on the request.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := now.Format(amzShortDateFormat) + "/" + region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format(amzShortDateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the given data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath escapes each segment of the given slash-separated path.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,164 @@
+// Package bench measures the throughput of the scanning pipeline, from the
+// portal download to clamd's verdict, against a mock portal and a mock clamd.
+package bench
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// Env is a mock portal and a mock clamd, along with a ClamAV client
+	// which uses them.
+	Env struct {
+		staticClam   *clamav.ClamAV
+		staticMock   *test.MockClam
+		staticPortal *test.MockPortal
+	}
+
+	// Result describes a benchmark run.
+	Result struct {
+		Size     int
+		Workers  int
+		Batch    int
+		Scans    int64
+		Bytes    int64
+		Errors   int64
+		Duration time.Duration
+	}
+)
+
+// NewEnv starts a mock portal and a mock clamd.
+func NewEnv() (*Env, error) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to start mock clamd")
+	}
+	portal := test.NewMockPortal()
+	ip, port := mc.Addr()
+	c, err := clamav.New(ip, port, portal.URL)
+	if err != nil {
+		portal.Close()
+		return nil, errors.Compose(err, mc.Close())
+	}
+	return &Env{
+		staticClam:   c,
+		staticMock:   mc,
+		staticPortal: portal,
+	}, nil
+}
+
+// Close stops the mocks.
+func (e *Env) Close() error {
+	err := e.staticClam.Close()
+	e.staticPortal.Close()
+	return errors.Compose(err, e.staticMock.Close())
+}
+
+// Run scans files of the given size with the given number of concurrent
+// workers, each scanning batches of the given number of files, until n files
+// are scanned or, if n isn't positive, until the context is done.
+func (e *Env) Run(ctx context.Context, size, workers, batch, n int) Result {
+	if workers < 1 {
+		workers = 1
+	}
+	if batch < 1 {
+		batch = 1
+	}
+	skylink := test.MockPortalPath(size)
+	abort := make(chan bool)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		close(abort)
+	}()
+
+	var scans, bytes, errs, remaining int64
+	remaining = int64(n)
+	// take returns the number of files the next batch should scan.
+	take := func() int {
+		if n <= 0 {
+			if ctx.Err() != nil {
+				return 0
+			}
+			return batch
+		}
+		left := atomic.AddInt64(&remaining, -int64(batch)) + int64(batch)
+		switch {
+		case left <= 0:
+			return 0
+		case left < int64(batch):
+			return int(left)
+		}
+		return batch
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := take(); k > 0; k = take() {
+				if k == 1 {
+					r := e.staticClam.ScanSkylink(skylink, abort)
+					record(&scans, &bytes, &errs, r.ScannedSize, r.Err)
+					continue
+				}
+				skylinks := make([]string, k)
+				for i := range skylinks {
+					skylinks[i] = skylink
+				}
+				for _, r := range e.staticClam.ScanSkylinks(skylinks, abort) {
+					record(&scans, &bytes, &errs, r.ScannedSize, r.Err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return Result{
+		Size:     size,
+		Workers:  workers,
+		Batch:    batch,
+		Scans:    scans,
+		Bytes:    bytes,
+		Errors:   errs,
+		Duration: time.Since(start),
+	}
+}
+
+// ScansPerSecond returns the number of successful scans per second.
+func (r Result) ScansPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Scans) / r.Duration.Seconds()
+}
+
+// MBPerSecond returns the number of megabytes scanned per second.
+func (r Result) MBPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / 1e6 / r.Duration.Seconds()
+}
+
+// record counts the result of a single scan.
+func record(scans, bytes, errs *int64, scanned uint64, err error) {
+	if err != nil {
+		atomic.AddInt64(errs, 1)
+		return
+	}
+	atomic.AddInt64(scans, 1)
+	atomic.AddInt64(bytes, int64(scanned))
+}
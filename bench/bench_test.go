@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// benchSizes are the file sizes we benchmark.
+var benchSizes = []int{1 << 10, 64 << 10, 1 << 20, 16 << 20}
+
+// TestRun ensures a run scans the requested number of files.
+func TestRun(t *testing.T) {
+	env, err := NewEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = env.Close() }()
+	for _, batch := range []int{1, 4} {
+		r := env.Run(context.Background(), 1000, 3, batch, 10)
+		if r.Scans != 10 || r.Errors != 0 || r.Bytes != 10000 {
+			t.Fatalf("Unexpected result %+v", r)
+		}
+	}
+}
+
+// BenchmarkScan measures the throughput of scanning files of various sizes
+// with various numbers of workers.
+func BenchmarkScan(b *testing.B) {
+	env, err := NewEnv()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = env.Close() }()
+	for _, size := range benchSizes {
+		for _, workers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("size=%d/workers=%d", size, workers), func(b *testing.B) {
+				benchmarkRun(b, env, size, workers, 1)
+			})
+		}
+	}
+}
+
+// BenchmarkScanBatch measures the throughput of scanning small files in
+// batches.
+func BenchmarkScanBatch(b *testing.B) {
+	env, err := NewEnv()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = env.Close() }()
+	for _, batch := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("size=1024/batch=%d", batch), func(b *testing.B) {
+			benchmarkRun(b, env, 1<<10, 1, batch)
+		})
+	}
+}
+
+// benchmarkRun scans b.N files and reports the throughput.
+func benchmarkRun(b *testing.B, env *Env, size, workers, batch int) {
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	r := env.Run(context.Background(), size, workers, batch, b.N)
+	b.StopTimer()
+	if r.Errors > 0 {
+		b.Fatalf("%d scans failed", r.Errors)
+	}
+	b.ReportMetric(r.ScansPerSecond(), "scans/s")
+}
@@ -0,0 +1,290 @@
+package blocker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	blockapi "github.com/SkynetLabs/blocker/api"
+	blockdb "github.com/SkynetLabs/blocker/database"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// MalwareTag marks the skylink as blocked by malware-scanner, as opposed
+	// to user-reported malware.
+	MalwareTag = "malware-scanner"
+	// reporterName is the name under which we report skylinks.
+	reporterName = "Malware Scanner"
+
+	// DefaultTarget is the name of the blocker target when only a single,
+	// unnamed one is configured.
+	DefaultTarget = "default"
+
+	// defaultTokenHeader is the header which carries the token by default.
+	defaultTokenHeader = "Authorization"
+
+	// defaultTimeout is the timeout of a single blocker call by default.
+	defaultTimeout = 30 * time.Second
+	// maxResponseSize is the maximum number of bytes of blocker's response
+	// body we read.
+	maxResponseSize = 1 << 16
+)
+
+var (
+	// ErrUnsupported is returned by the calls the blocker version we build
+	// against doesn't serve.
+	ErrUnsupported = errors.New("endpoint not supported by blocker")
+)
+
+type (
+	// Client is a client of the blocker service's API.
+	Client struct {
+		staticName          string
+		staticBaseURL       string
+		staticHeaders       http.Header
+		staticHTTPClient    *http.Client
+		staticTimeout       time.Duration
+		staticSigningSecret []byte
+	}
+
+	// Options configure a client. Zero values are replaced by defaults.
+	// Name identifies the blocker instance when we report to several of
+	// them. Headers are sent with every request, e.g. for authentication.
+	// Token is sent with every request in TokenHeader, as a bearer token if
+	// that's the Authorization header. TLSConfig applies to https URLs,
+	// which it requires if it holds a client certificate. SigningSecret signs
+	// every request, so blocker can verify it came from the scanner.
+	Options struct {
+		Name          string
+		Headers       http.Header
+		HTTPClient    *http.Client
+		Timeout       time.Duration
+		Token         string
+		TokenHeader   string
+		TLSConfig     *tls.Config
+		SigningSecret []byte
+	}
+)
+
+// New creates a client of the blocker API at the given base URL. The URL can
+// use http or https and include a path prefix, e.g.
+// "https://portal.example.com/blocker".
+func New(baseURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid blocker URL")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid blocker URL: " + baseURL)
+	}
+	// Sending the client certificate's credentials in the clear would
+	// defeat its purpose.
+	if opts.TLSConfig != nil && len(opts.TLSConfig.Certificates) > 0 && u.Scheme != "https" {
+		return nil, errors.New("a client certificate requires an https blocker URL: " + baseURL)
+	}
+	c := &Client{
+		staticName:          opts.Name,
+		staticBaseURL:       strings.TrimSuffix(baseURL, "/"),
+		staticHeaders:       opts.Headers.Clone(),
+		staticHTTPClient:    opts.HTTPClient,
+		staticTimeout:       opts.Timeout,
+		staticSigningSecret: opts.SigningSecret,
+	}
+	if c.staticName == "" {
+		c.staticName = DefaultTarget
+	}
+	if c.staticHTTPClient == nil {
+		c.staticHTTPClient = http.DefaultClient
+	}
+	if opts.TLSConfig != nil {
+		c.staticHTTPClient = withTLS(c.staticHTTPClient, opts.TLSConfig)
+	}
+	if opts.Token != "" {
+		if c.staticHeaders == nil {
+			c.staticHeaders = make(http.Header)
+		}
+		header, value := opts.TokenHeader, opts.Token
+		if header == "" {
+			header = defaultTokenHeader
+		}
+		if http.CanonicalHeaderKey(header) == defaultTokenHeader {
+			value = "Bearer " + value
+		}
+		c.staticHeaders.Set(header, value)
+	}
+	if c.staticTimeout == 0 {
+		c.staticTimeout = defaultTimeout
+	}
+	return c, nil
+}
+
+// ParseHeaders parses a comma-separated list of `Name: value` pairs.
+func ParseHeaders(s string) (http.Header, error) {
+	h := make(http.Header)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.New("blocker headers must be in the form `Name: value`")
+		}
+		h.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return h, nil
+}
+
+// ParseTargets parses a comma-separated list of `name=url` pairs into clients
+// of the blocker instances we report to, e.g. a staging and a production one.
+// All clients share the given options, except for their names.
+func ParseTargets(s string, opts Options) ([]*Client, error) {
+	var clients []*Client
+	names := make(map[string]struct{})
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, errors.New("blocker targets must be in the form `name=url`")
+		}
+		name := pair[:i]
+		if _, exists := names[name]; exists {
+			return nil, errors.New("duplicate blocker target " + name)
+		}
+		names[name] = struct{}{}
+		opts.Name = name
+		c, err := New(pair[i+1:], opts)
+		if err != nil {
+			return nil, errors.AddContext(err, "invalid blocker target "+name)
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// Name returns the name of the blocker instance.
+func (c *Client) Name() string {
+	return c.staticName
+}
+
+// BaseURL returns the base URL of the blocker API.
+func (c *Client) BaseURL() string {
+	return c.staticBaseURL
+}
+
+// Block instructs blocker to block the given skylink as malware. It returns
+// blocker's response, which is also set when the call fails.
+func (c *Client) Block(ctx context.Context, skylink string) (*database.BlockerResponse, error) {
+	br := &database.BlockerResponse{
+		Result:     database.BlockerResultFailed,
+		ReportedAt: database.Clock.Now().UTC(),
+	}
+	body := blockapi.BlockPOST{
+		Skylink: skylink,
+		Reporter: blockdb.Reporter{
+			Name: reporterName,
+		},
+		Tags: []string{MalwareTag},
+	}
+	status, resp, err := c.call(ctx, http.MethodPost, "/block", body)
+	br.StatusCode = status
+	if err == nil && status != http.StatusOK && status != http.StatusNoContent {
+		err = errors.New(fmt.Sprintf("blocker failed. status code %d, body: '%s'", status, string(resp)))
+	}
+	if err != nil {
+		br.Error = err.Error()
+		return br, err
+	}
+	br.Result, br.BlockID = parseBlockResponse(resp)
+	return br, nil
+}
+
+// Unblock instructs blocker to unblock the given skylink, e.g. after its
+// verdict was overridden as a false positive. The blocker version we build
+// against has no unblock endpoint, so it always returns ErrUnsupported.
+func (c *Client) Unblock(ctx context.Context, skylink string) error {
+	return ErrUnsupported
+}
+
+// Status returns whether blocker has blocked the given skylink. The blocker
+// version we build against has no status endpoint, so it always returns
+// ErrUnsupported.
+func (c *Client) Status(ctx context.Context, skylink string) (bool, error) {
+	return false, ErrUnsupported
+}
+
+// call sends a request with the given JSON body to the given path of the
+// blocker API and returns the status code and body of the response.
+func (c *Client) call(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.staticTimeout)
+	defer cancel()
+	var r io.Reader
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return 0, nil, errors.AddContext(err, "failed to build request body")
+		}
+		r = bytes.NewBuffer(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.staticBaseURL+path, r)
+	if err != nil {
+		return 0, nil, errors.AddContext(err, "failed to build blocker request")
+	}
+	for name, values := range c.staticHeaders {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.staticSigningSecret) > 0 {
+		SignRequest(req, c.staticSigningSecret, reqBody)
+	}
+	res, err := c.staticHTTPClient.Do(req)
+	if err != nil {
+		return 0, nil, errors.AddContext(err, "failed to call blocker")
+	}
+	defer func() { _ = res.Body.Close() }()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return res.StatusCode, nil, errors.AddContext(err, "failed to read blocker response")
+	}
+	return res.StatusCode, b, nil
+}
+
+// parseBlockResponse extracts the result and the block ID, if any, from the
+// body of a successful block response. Blocker responds with an empty body
+// when it blocks a skylink and with a JSON string when the skylink was already
+// blocked. We also accept a JSON object with the ID of the block.
+func parseBlockResponse(body []byte) (result, blockID string) {
+	if bytes.Contains(body, []byte("already exists")) {
+		return database.BlockerResultDuplicate, ""
+	}
+	var obj struct {
+		ID        string `json:"id"`
+		Duplicate bool   `json:"duplicate"`
+	}
+	if json.Unmarshal(body, &obj) == nil {
+		if obj.Duplicate {
+			return database.BlockerResultDuplicate, obj.ID
+		}
+		return database.BlockerResultBlocked, obj.ID
+	}
+	return database.BlockerResultBlocked, ""
+}
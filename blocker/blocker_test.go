@@ -0,0 +1,209 @@
+package blocker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	blockapi "github.com/SkynetLabs/blocker/api"
+	blockdb "github.com/SkynetLabs/blocker/database"
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// blockerURL is the base URL of the mocked blocker, including a path prefix.
+const blockerURL = "https://10.10.10.110:4000/blocker"
+
+// testSkylink is a valid skylink used in the tests.
+const testSkylink = "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+
+// newTestClient returns a client of the mocked blocker.
+func newTestClient(t *testing.T) *Client {
+	c, err := New(blockerURL+"/", Options{Headers: http.Header{"Authorization": {"Bearer secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestNew ensures New validates the blocker URL.
+func TestNew(t *testing.T) {
+	for _, u := range []string{"", "10.10.10.110:4000", "ftp://blocker", "http://"} {
+		if _, err := New(u, Options{}); err == nil {
+			t.Fatalf("Expected an error for URL '%s'", u)
+		}
+	}
+	c, err := New("http://blocker:4000/", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseURL() != "http://blocker:4000" {
+		t.Fatalf("Unexpected base URL '%s'", c.BaseURL())
+	}
+}
+
+// TestParseHeaders ensures ParseHeaders works as expected.
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders("Authorization: Bearer abc, X-Portal:eu-1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("Authorization") != "Bearer abc" || h.Get("X-Portal") != "eu-1" {
+		t.Fatalf("Unexpected headers %v", h)
+	}
+	if _, err = ParseHeaders("no-colon"); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+// TestParseTargets ensures ParseTargets works as expected.
+func TestParseTargets(t *testing.T) {
+	cs, err := ParseTargets(" staging=http://10.0.0.1:4000, production=https://portal.example.com/blocker,", Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(cs))
+	}
+	if cs[0].Name() != "staging" || cs[0].BaseURL() != "http://10.0.0.1:4000" || cs[0].staticTimeout != time.Second {
+		t.Fatalf("Unexpected target %s %s", cs[0].Name(), cs[0].BaseURL())
+	}
+	if cs[1].Name() != "production" || cs[1].BaseURL() != "https://portal.example.com/blocker" {
+		t.Fatalf("Unexpected target %s %s", cs[1].Name(), cs[1].BaseURL())
+	}
+	for _, s := range []string{"http://10.0.0.1:4000", "a=http://10.0.0.1:4000,a=http://10.0.0.2:4000", "a=10.0.0.1:4000"} {
+		if _, err = ParseTargets(s, Options{}); err == nil {
+			t.Fatalf("Expected an error for '%s'", s)
+		}
+	}
+	if c := newTestClient(t); c.Name() != DefaultTarget {
+		t.Fatalf("Expected name %s, got %s", DefaultTarget, c.Name())
+	}
+}
+
+// TestBlock ensures Block works as expected.
+func TestBlock(t *testing.T) {
+	defer gock.Off()
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = fake
+	t.Cleanup(func() { database.Clock = real })
+	c := newTestClient(t)
+
+	// Happy case.
+	blockReqBody := blockapi.BlockPOST{
+		Skylink: testSkylink,
+		Reporter: blockdb.Reporter{
+			Name: "Malware Scanner",
+		},
+		Tags: []string{MalwareTag},
+	}
+	blockReqBodyBytes, err := json.Marshal(blockReqBody)
+	if err != nil {
+		t.Fatalf("Failed to serialize request, Error: %s", err.Error())
+	}
+
+	gock.New(blockerURL).
+		Post("/block").
+		MatchHeader("Authorization", "Bearer secret").
+		Body(bytes.NewBuffer(blockReqBodyBytes)).
+		Reply(http.StatusNoContent)
+
+	br, err := c.Block(context.Background(), testSkylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Result != database.BlockerResultBlocked || br.StatusCode != http.StatusNoContent || !br.ReportedAt.Equal(fake.Now()) {
+		t.Fatalf("Unexpected blocker response %+v", br)
+	}
+
+	// Skylink already blocked.
+	gock.New(blockerURL).
+		Post("/block").
+		Body(bytes.NewBuffer(blockReqBodyBytes)).
+		Reply(http.StatusOK).
+		BodyString(`"BlockedSkylink already exists in the database"`)
+
+	br, err = c.Block(context.Background(), testSkylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Result != database.BlockerResultDuplicate || br.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected blocker response %+v", br)
+	}
+
+	// Error when calling blocker.
+	gock.New(blockerURL).
+		Post("/block").
+		Body(bytes.NewBuffer(blockReqBodyBytes)).
+		ReplyError(errors.New("simulated error"))
+
+	br, err = c.Block(context.Background(), testSkylink)
+	if err == nil || !strings.Contains(err.Error(), "simulated error") {
+		t.Fatalf("Expected error 'simulated error', got '%s'", err)
+	}
+	if br.Result != database.BlockerResultFailed || br.Error != err.Error() {
+		t.Fatalf("Unexpected blocker response %+v", br)
+	}
+
+	// Blocker failed to block
+	gock.New(blockerURL).
+		Post("/block").
+		Body(bytes.NewBuffer(blockReqBodyBytes)).
+		Reply(http.StatusInternalServerError)
+
+	br, err = c.Block(context.Background(), testSkylink)
+	if err == nil || !strings.Contains(err.Error(), "blocker failed. status code 500") {
+		t.Fatalf("Expected error 'blocker failed. status code 500', got '%s'", err)
+	}
+	if br.Result != database.BlockerResultFailed || br.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Unexpected blocker response %+v", br)
+	}
+}
+
+// TestUnblockAndStatus ensures Unblock and Status return ErrUnsupported
+// without calling blocker, since the blocker version we build against doesn't
+// serve them.
+func TestUnblockAndStatus(t *testing.T) {
+	defer gock.Off()
+	gock.Intercept()
+	c := newTestClient(t)
+
+	if err := c.Unblock(context.Background(), testSkylink); !errors.Contains(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported, got %v", err)
+	}
+	if blocked, err := c.Status(context.Background(), testSkylink); blocked || !errors.Contains(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported, got %t, error %v", blocked, err)
+	}
+	if gock.HasUnmatchedRequest() {
+		t.Fatal("Expected no request to blocker")
+	}
+}
+
+// TestParseBlockResponse ensures we recognise the different kinds of
+// successful block responses.
+func TestParseBlockResponse(t *testing.T) {
+	tests := []struct {
+		body    string
+		result  string
+		blockID string
+	}{
+		{"", database.BlockerResultBlocked, ""},
+		{`"BlockedSkylink already exists in the database"`, database.BlockerResultDuplicate, ""},
+		{`{"id":"abc"}`, database.BlockerResultBlocked, "abc"},
+		{`{"id":"abc","duplicate":true}`, database.BlockerResultDuplicate, "abc"},
+		{"not json", database.BlockerResultBlocked, ""},
+	}
+	for _, tt := range tests {
+		result, id := parseBlockResponse([]byte(tt.body))
+		if result != tt.result || id != tt.blockID {
+			t.Fatalf("Expected '%s', '%s' for body '%s', got '%s', '%s'", tt.result, tt.blockID, tt.body, result, id)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package blocker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	blockapi "github.com/SkynetLabs/blocker/api"
+	"github.com/SkynetLabs/malware-scanner/database"
+	skyapi "gitlab.com/SkynetLabs/skyd/node/api"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+)
+
+// blockRequestJSON is the body of the block request blocker expects from us.
+// The tag is spelled out rather than taken from MalwareTag, since portals
+// filter on it and changing it changes the contract.
+const blockRequestJSON = `{
+	"skylink": "` + testSkylink + `",
+	"reporter": {"name": "Malware Scanner", "email": "", "otherContact": ""},
+	"tags": ["malware-scanner"]
+}`
+
+// TestBlockContract ensures blocker's own request type decodes the block
+// requests we send without losing or ignoring anything and that we understand
+// the responses blocker's handler writes. It breaks when an upgrade of the
+// blocker dependency changes BlockPOST.
+func TestBlockContract(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	var decoded []blockapi.BlockPOST
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Decode the body like blocker does, but reject fields blocker
+		// would silently drop.
+		var body blockapi.BlockPOST
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&body); err != nil {
+			skyapi.WriteError(w, skyapi.Error{err.Error()}, http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		bodies = append(bodies, b)
+		decoded = append(decoded, body)
+		n := len(bodies)
+		mu.Unlock()
+		// Respond like blocker's blockPOST handler does to a new and a
+		// repeated block.
+		if n == 1 {
+			skyapi.WriteSuccess(w)
+			return
+		}
+		skyapi.WriteJSON(w, "BlockedSkylink already exists in the database")
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br, err := c.Block(context.Background(), testSkylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Result != database.BlockerResultBlocked || br.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected response to a new block %+v", br)
+	}
+	br, err = c.Block(context.Background(), testSkylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Result != database.BlockerResultDuplicate || br.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response to a repeated block %+v", br)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 decodable requests, got %d", len(bodies))
+	}
+	var expected, actual interface{}
+	if err = json.Unmarshal([]byte(blockRequestJSON), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(bodies[0], &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Expected block request %s, got %s", blockRequestJSON, bodies[0])
+	}
+	// Encoding the decoded request again must give the same request, i.e.
+	// blocker keeps every field we send.
+	reencoded, err := json.Marshal(decoded[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip interface{}
+	if err = json.Unmarshal(reencoded, &roundTrip); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, roundTrip) {
+		t.Fatalf("Block request %s changed to %s after decoding", bodies[0], reencoded)
+	}
+	// Blocker rejects skylinks it can't parse.
+	var sl skymodules.Skylink
+	if err = sl.LoadString(decoded[0].Skylink); err != nil {
+		t.Fatalf("Blocker can't parse skylink '%s': %v", decoded[0].Skylink, err)
+	}
+	if len(decoded[0].Tags) != 1 || decoded[0].Tags[0] != MalwareTag {
+		t.Fatalf("Expected tags [%s], got %v", MalwareTag, decoded[0].Tags)
+	}
+}
@@ -0,0 +1,71 @@
+package blocker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// SignatureHeader is the header which carries the HMAC-SHA256 signature
+	// of a request, as `sha256=<hex>`.
+	SignatureHeader = "X-Scanner-Signature"
+	// TimestampHeader is the header which carries the unix time a request
+	// was signed at. It's part of the signature, so a captured request can't
+	// be replayed once it's older than MaxSignatureAge.
+	TimestampHeader = "X-Scanner-Timestamp"
+
+	// MaxSignatureAge is how far a signed request's timestamp may be from
+	// the receiver's clock.
+	MaxSignatureAge = 5 * time.Minute
+
+	// signaturePrefix prefixes the hex-encoded signature, which leaves room
+	// for other algorithms.
+	signaturePrefix = "sha256="
+)
+
+var (
+	// ErrInvalidSignature is returned by VerifySignature if a request isn't
+	// signed with the secret.
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// Sign returns the signature of the given body at the given unix time. It's
+// the HMAC-SHA256 of `<timestamp>.<body>` with the secret.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	_, _ = h.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	_, _ = h.Write(body)
+	return signaturePrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// SignRequest sets the signature headers of a request with the given body.
+func SignRequest(req *http.Request, secret, body []byte) {
+	ts := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, ts, body))
+}
+
+// VerifySignature checks the signature headers of a request with the given
+// body. It fails if the signature doesn't match or the timestamp is more than
+// MaxSignatureAge away from now.
+func VerifySignature(h http.Header, secret, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(h.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.Extend(errors.New("missing or invalid signature timestamp"), ErrInvalidSignature)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > MaxSignatureAge || d < -MaxSignatureAge {
+		return errors.Extend(errors.New("signature timestamp is too far from now"), ErrInvalidSignature)
+	}
+	sig := h.Get(SignatureHeader)
+	if !strings.HasPrefix(sig, signaturePrefix) || !hmac.Equal([]byte(sig), []byte(Sign(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
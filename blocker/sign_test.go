@@ -0,0 +1,68 @@
+package blocker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestVerifySignature ensures VerifySignature only accepts recent requests
+// signed with the secret.
+func TestVerifySignature(t *testing.T) {
+	secret, body := []byte("secret"), []byte(`{"skylink":"abc"}`)
+	now := time.Now()
+	header := func(ts time.Time, secret, body []byte) http.Header {
+		h := make(http.Header)
+		h.Set(TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		h.Set(SignatureHeader, Sign(secret, ts.Unix(), body))
+		return h
+	}
+	if err := VerifySignature(header(now, secret, body), secret, body, now); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]http.Header{
+		"unsigned":     make(http.Header),
+		"wrong secret": header(now, []byte("other"), body),
+		"wrong body":   header(now, secret, []byte("{}")),
+		"old":          header(now.Add(-2*MaxSignatureAge), secret, body),
+		"future":       header(now.Add(2*MaxSignatureAge), secret, body),
+	}
+	for name, h := range tests {
+		if err := VerifySignature(h, secret, body, now); !errors.Contains(err, ErrInvalidSignature) {
+			t.Fatalf("%s: unexpected error %v", name, err)
+		}
+	}
+}
+
+// TestSignedCalls ensures the client signs its calls when it has a secret.
+func TestSignedCalls(t *testing.T) {
+	secret := []byte("secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifySignature(r.Header, secret, body, time.Now()); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Options{SigningSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Block(context.Background(), testSkylink); err != nil {
+		t.Fatal(err)
+	}
+	c, err = New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Block(context.Background(), testSkylink); err == nil {
+		t.Fatal("Expected an unsigned call to fail")
+	}
+}
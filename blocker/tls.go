@@ -0,0 +1,57 @@
+package blocker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TLSConfig returns the TLS configuration of blocker calls. The certificate
+// and key files hold the PEM-encoded client certificate we authenticate with,
+// for blockers which require mutual TLS. The CA file holds the PEM-encoded
+// certificates blocker's certificate is verified against, instead of the
+// system's, e.g. for an internal CA. All of them are optional, but the
+// certificate and key go together.
+func TLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("the client certificate and key must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to load the client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to read the CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in the CA file")
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// withTLS returns a copy of the given client which uses the given TLS
+// configuration. It keeps the client's transport settings, e.g. a custom
+// resolver, if it's an http.Transport.
+func withTLS(client *http.Client, cfg *tls.Config) *http.Client {
+	t, ok := client.Transport.(*http.Transport)
+	if !ok || t == nil {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+	t.TLSClientConfig = cfg
+	c := *client
+	c.Transport = t
+	return &c
+}
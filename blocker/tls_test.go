@@ -0,0 +1,157 @@
+package blocker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+// writeClientCert generates a self-signed client certificate and writes it
+// and its key to the given directory. It returns the certificate and the
+// paths of the files.
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "malware-scanner"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+// TestTLSConfig ensures TLSConfig validates its files.
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeClientCert(t, dir)
+	if _, err := TLSConfig(certFile, "", ""); err == nil {
+		t.Fatal("Expected an error for a certificate without a key")
+	}
+	if _, err := TLSConfig("", "", certFile+".missing"); err == nil {
+		t.Fatal("Expected an error for a missing CA file")
+	}
+	if _, err := TLSConfig("", "", keyFile); err == nil {
+		t.Fatal("Expected an error for a CA file without certificates")
+	}
+	cfg, err := TLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 || cfg.RootCAs == nil || cfg.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("Unexpected config %+v", cfg)
+	}
+	// A client certificate is never sent in the clear.
+	if _, err = New("http://blocker:4000", Options{TLSConfig: cfg}); err == nil {
+		t.Fatal("Expected an error for an http URL")
+	}
+}
+
+// TestMutualTLS ensures the client authenticates with its certificate and
+// token to a blocker which requires both.
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeClientCert(t, dir)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Blocker-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.crt")
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a client certificate, the handshake fails.
+	cfg, err := TLSConfig("", "", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(srv.URL, Options{TLSConfig: cfg, Token: "secret", TokenHeader: "X-Blocker-Token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Block(context.Background(), testSkylink); err == nil {
+		t.Fatal("Expected the call to fail without a client certificate")
+	}
+
+	cfg, err = TLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = New(srv.URL, Options{TLSConfig: cfg, Token: "secret", TokenHeader: "X-Blocker-Token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, err := c.Block(context.Background(), testSkylink)
+	if err != nil || br.Result != database.BlockerResultBlocked {
+		t.Fatalf("Unexpected response %+v %v", br, err)
+	}
+}
+
+// TestToken ensures the token is sent as a bearer token by default.
+func TestToken(t *testing.T) {
+	c, err := New(blockerURL, Options{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := c.staticHeaders.Get("Authorization"); h != "Bearer secret" {
+		t.Fatalf("Unexpected header '%s'", h)
+	}
+	c, err = New(blockerURL, Options{Token: "secret", TokenHeader: "X-Blocker-Token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := c.staticHeaders.Get("X-Blocker-Token"); h != "secret" || c.staticHeaders.Get("Authorization") != "" {
+		t.Fatalf("Unexpected headers %v", c.staticHeaders)
+	}
+}
@@ -0,0 +1,205 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/metrics"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// maxBlocklistSize is the maximum size of a blocklist we download.
+	maxBlocklistSize = 256 << 20
+	// markBatchSize is the number of hashes we look up in the queue at once.
+	markBatchSize = 1000
+	// skydUserAgent is the user agent skyd requires on its API calls.
+	skydUserAgent = "Sia-Agent"
+)
+
+var (
+	// metricImported counts the queued skylinks marked as infected because
+	// they're on an external blocklist, by blocklist.
+	metricImported = metrics.NewCounterVec("blocklist_imported_verdicts_total", "Number of queued skylinks marked as infected from external blocklists.", "source")
+	// metricFetchFailures counts the failed blocklist downloads by
+	// blocklist.
+	metricFetchFailures = metrics.NewCounterVec("blocklist_fetch_failures_total", "Number of failed blocklist downloads.", "source")
+)
+
+type (
+	// Source is an external blocklist of hashes of skylinks' merkle roots,
+	// such as the blocklist of another portal's skyd or Skynet's central
+	// list.
+	Source struct {
+		Name string
+		URL  string
+	}
+)
+
+// ParseSources parses a comma-separated list of blocklist URLs, each
+// optionally preceded by a name, e.g. `central=https://example.com/list`.
+// Blocklists without a name are named after their host.
+func ParseSources(s string) ([]Source, error) {
+	var sources []Source
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var src Source
+		src.URL = entry
+		if i := strings.Index(entry, "="); i > 0 && !strings.Contains(entry[:i], "/") {
+			src.Name, src.URL = entry[:i], entry[i+1:]
+		}
+		u, err := url.Parse(src.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("invalid blocklist URL: " + src.URL)
+		}
+		if src.Name == "" {
+			src.Name = u.Host
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// Start launches a background thread which downloads the given blocklists
+// every interval and marks the queued skylinks they list as infected, until
+// the context is cancelled.
+func Start(ctx context.Context, db *database.DB, sources []Source, interval time.Duration, logger *logrus.Logger) error {
+	if db == nil {
+		return errors.New("no DB provided")
+	}
+	if len(sources) == 0 {
+		return errors.New("no blocklists provided")
+	}
+	if interval <= 0 {
+		return errors.New("invalid blocklist import interval")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, src := range sources {
+				n, err := importSource(ctx, db, client, src)
+				if err != nil {
+					logger.Warnln(errors.AddContext(err, "failed to import blocklist "+src.Name))
+				}
+				if n > 0 {
+					logger.Infof("Marked %d queued skylinks as infected from blocklist %s", n, src.Name)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// importSource downloads the given blocklist and marks the queued skylinks it
+// lists as infected. It returns the number of marked skylinks.
+func importSource(ctx context.Context, db *database.DB, client *http.Client, src Source) (int64, error) {
+	hashes, err := Fetch(ctx, client, src.URL)
+	if err != nil {
+		metricFetchFailures.With(src.Name).Inc()
+		return 0, err
+	}
+	var total int64
+	for i := 0; i < len(hashes); i += markBatchSize {
+		end := i + markBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		n, err := db.MarkKnownInfected(ctx, hashes[i:end], src.Name)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	metricImported.With(src.Name).Add(float64(total))
+	return total, nil
+}
+
+// Fetch downloads the blocklist at the given URL. It accepts skyd's blocklist
+// format, i.e. a JSON object with a `blocklist` list of hex-encoded hashes, a
+// JSON list of hashes, or plain text with one hash per line.
+func Fetch(ctx context.Context, client *http.Client, url string) ([]crypto.Hash, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build blocklist request")
+	}
+	req.Header.Set("User-Agent", skydUserAgent)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to download blocklist")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return nil, errors.New(fmt.Sprintf("blocklist download failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBlocklistSize))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read blocklist")
+	}
+	return parse(b)
+}
+
+// parse parses a blocklist in any of the formats Fetch accepts.
+func parse(b []byte) ([]crypto.Hash, error) {
+	var strs []string
+	trimmed := bytes.TrimSpace(b)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		var obj struct {
+			Blocklist []string `json:"blocklist"`
+		}
+		if err := json.Unmarshal(trimmed, &obj); err != nil {
+			return nil, errors.AddContext(err, "invalid blocklist")
+		}
+		strs = obj.Blocklist
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &strs); err != nil {
+			return nil, errors.AddContext(err, "invalid blocklist")
+		}
+	default:
+		sc := bufio.NewScanner(bytes.NewReader(trimmed))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				strs = append(strs, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, errors.AddContext(err, "invalid blocklist")
+		}
+	}
+	hashes := make([]crypto.Hash, 0, len(strs))
+	for _, s := range strs {
+		var h crypto.Hash
+		if err := h.LoadString(s); err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("invalid hash '%s' in blocklist", s))
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,81 @@
+package blocklist
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.sia.tech/siad/crypto"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestParseSources ensures we parse named and unnamed blocklist URLs.
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources("central=https://example.com/list, https://portal.example.com/skynet/blocklist?a=b,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(sources))
+	}
+	if sources[0].Name != "central" || sources[0].URL != "https://example.com/list" {
+		t.Fatalf("Unexpected source %+v", sources[0])
+	}
+	if sources[1].Name != "portal.example.com" || sources[1].URL != "https://portal.example.com/skynet/blocklist?a=b" {
+		t.Fatalf("Unexpected source %+v", sources[1])
+	}
+	_, err = ParseSources("central=ftp://example.com/list")
+	if err == nil {
+		t.Fatal("Expected an error for a non-http URL.")
+	}
+}
+
+// TestFetch ensures we download and parse blocklists in all supported formats.
+func TestFetch(t *testing.T) {
+	defer gock.Off()
+	client := &http.Client{}
+	gock.InterceptClient(client)
+
+	h1 := crypto.HashBytes([]byte("one"))
+	h2 := crypto.HashBytes([]byte("two"))
+
+	tests := map[string]string{
+		"/skyd": `{"blocklist":["` + h1.String() + `","` + h2.String() + `"]}`,
+		"/json": `["` + h1.String() + `","` + h2.String() + `"]`,
+		"/text": "# known malware\n" + h1.String() + "\n\n" + h2.String() + "\n",
+	}
+	for path, body := range tests {
+		gock.New("https://example.com").
+			Get(path).
+			MatchHeader("User-Agent", skydUserAgent).
+			Reply(http.StatusOK).
+			BodyString(body)
+		hashes, err := Fetch(context.Background(), client, "https://example.com"+path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		if len(hashes) != 2 || hashes[0] != h1 || hashes[1] != h2 {
+			t.Fatalf("%s: unexpected hashes %v", path, hashes)
+		}
+	}
+
+	// Invalid hashes and failed downloads are errors.
+	gock.New("https://example.com").
+		Get("/invalid").
+		Reply(http.StatusOK).
+		BodyString("not-a-hash\n")
+	_, err := Fetch(context.Background(), client, "https://example.com/invalid")
+	if err == nil {
+		t.Fatal("Expected an error for an invalid hash.")
+	}
+	gock.New("https://example.com").
+		Get("/missing").
+		Reply(http.StatusNotFound)
+	_, err = Fetch(context.Background(), client, "https://example.com/missing")
+	if err == nil {
+		t.Fatal("Expected an error for a failed download.")
+	}
+	if !gock.IsDone() {
+		t.Fatal("Not all expected requests were made.")
+	}
+}
@@ -0,0 +1,170 @@
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// BatchFileSize is the maximum size of the files ScanSkylinks downloads into
+// memory and streams to clamd back to back over a single session. Larger
+// files, and files the memory budget has no room for, are scanned one by one.
+// Set according to the MALWARE_SCANNER_BATCH_FILE_SIZE env var.
+var BatchFileSize uint64 = 1 << 20
+
+type (
+	// SkylinkScan is the result of scanning a skylink. File is only set
+	// when the portal served the content.
+	SkylinkScan struct {
+		Infected    bool
+		Description string
+		Size        uint64
+		ScannedSize uint64
+		File        FileInfo
+		Err         error
+	}
+
+	// batchDownload is the download of a skylink we're about to scan as
+	// part of a batch. Small files are downloaded into content, the
+	// responses of others are kept open, so they can be streamed to clamd.
+	batchDownload struct {
+		content []byte
+		size    uint64
+		file    FileInfo
+		resp    *http.Response
+		portal  string
+		err     error
+	}
+)
+
+// ScanSkylinks scans the given skylinks and returns their results in the same
+// order. Files of up to BatchFileSize are downloaded concurrently and streamed
+// to clamd back to back over a single session, which saves most of the
+// overhead of scanning them one at a time. Larger files are scanned one by
+// one, like ScanSkylink does, once the batch released its memory. Batching
+// requires clamd sessions.
+func (c *ClamAV) ScanSkylinks(skylinks []string, abort chan bool) []SkylinkScan {
+	results := make([]SkylinkScan, len(skylinks))
+	if c.staticSessions == nil {
+		for i, skylink := range skylinks {
+			results[i] = c.ScanSkylink(skylink, abort)
+		}
+		return results
+	}
+
+	downloads := make([]batchDownload, len(skylinks))
+	var wg sync.WaitGroup
+	for i := range skylinks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			downloads[i] = c.downloadForBatch(skylinks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var batch []int
+	var contents [][]byte
+	for i, d := range downloads {
+		switch {
+		case d.err != nil:
+			results[i].Err = d.err
+		case d.resp == nil:
+			batch = append(batch, i)
+			contents = append(contents, d.content)
+		}
+	}
+	if len(batch) > 0 {
+		var batchSize int64
+		for _, content := range contents {
+			batchSize += int64(cap(content))
+		}
+		verdicts := c.scanBatch(contents, abort)
+		c.staticMemory.release(batchSize)
+		for j, i := range batch {
+			results[i] = SkylinkScan{
+				Infected:    verdicts[j].infected,
+				Description: verdicts[j].description,
+				Size:        downloads[i].size,
+				ScannedSize: uint64(len(contents[j])),
+				File:        downloads[i].file,
+				Err:         verdicts[j].err,
+			}
+		}
+	}
+	for i, d := range downloads {
+		if d.resp != nil {
+			results[i] = c.scanResponse(skylinks[i], d.resp, d.portal, abort)
+		}
+	}
+	return results
+}
+
+// batchVerdict is the verdict on one of the contents of a batch.
+type batchVerdict struct {
+	streamVerdict
+	err error
+}
+
+// scanBatch scans the given contents over a single session. If the batch
+// fails, e.g. because clamd rejected one of the contents, we scan them one by
+// one, so a single bad file doesn't fail the others.
+func (c *ClamAV) scanBatch(contents [][]byte, abort chan bool) []batchVerdict {
+	verdicts := make([]batchVerdict, len(contents))
+	// The contents are in memory, so the batch takes as long as clamd takes
+	// to respond.
+	start := time.Now()
+	svs, err := c.staticSessions.ScanBatch(contents, abort)
+	if err == nil {
+		c.staticLoad.recordScan(time.Since(start))
+		metricBatchedScans.Add(float64(len(contents)))
+		for i := range svs {
+			verdicts[i].streamVerdict = svs[i]
+		}
+		return verdicts
+	}
+	if errors.Contains(err, errSessionAborted) {
+		for i := range verdicts {
+			verdicts[i].err = errors.Extend(err, ErrClamd)
+		}
+		return verdicts
+	}
+	for i, content := range contents {
+		v := &verdicts[i]
+		v.infected, v.description, v.err = c.Scan(bytes.NewReader(content), abort)
+	}
+	return verdicts
+}
+
+// downloadForBatch downloads the content of the given skylink if it's small
+// enough to be scanned as part of a batch and the memory budget has room for
+// it. Otherwise, it returns the open response. The content's memory is taken
+// from the budget and is released by the caller.
+func (c *ClamAV) downloadForBatch(skylink string) batchDownload {
+	resp, portal, err := c.download(skylink)
+	if err != nil {
+		return batchDownload{err: err}
+	}
+	size, err := strconv.ParseUint(resp.Header.Get("content-length"), 10, 64)
+	// We don't wait for memory here, the downloads of the batch would hold
+	// on to what they got while they wait for each other.
+	if err != nil || size > BatchFileSize || contentEncoding(resp) != "" || !c.staticMemory.tryAcquire(int64(size)) {
+		// scanResponse also reports a missing content length and decompresses
+		// the content.
+		return batchDownload{resp: resp, portal: portal}
+	}
+	content := make([]byte, size)
+	n, err := io.ReadFull(newIdleTimeoutReader(resp.Body), content)
+	_ = resp.Body.Close()
+	c.staticPortalStats.recordBytes(portal, uint64(n))
+	if err != nil {
+		c.staticMemory.release(int64(size))
+		return batchDownload{err: errors.AddContext(err, "failed to download content")}
+	}
+	return batchDownload{content: content, size: size, file: fileInfo(resp)}
+}
@@ -0,0 +1,76 @@
+package clamav
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+)
+
+// TestScanSkylinks ensures batches of skylinks are scanned over a single
+// clamd session and each of them gets its own result.
+func TestScanSkylinks(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	check := func(results []SkylinkScan) {
+		t.Helper()
+		if len(results) != 4 {
+			t.Fatalf("Expected 4 results, got %d", len(results))
+		}
+		for _, i := range []int{0, 3} {
+			if r := results[i]; r.Err != nil || r.Infected || r.Size != 5 || r.ScannedSize != 5 {
+				t.Fatalf("Unexpected clean scan result %+v", r)
+			}
+		}
+		if r := results[1]; r.Err != nil || !r.Infected || r.Description != test.EICARSignature || r.Size != uint64(len(test.EICAR)) {
+			t.Fatalf("Unexpected infected scan result %+v", r)
+		}
+		if r := results[2]; r.Err == nil || !strings.Contains(r.Err.Error(), ErrPortalNotFound.Error()) {
+			t.Fatalf("Expected error '%s', got %v", ErrPortalNotFound, r.Err)
+		}
+	}
+	skylinks := []string{"clean", "eicar", "missing", "clean"}
+	connections := mc.Connections()
+	check(c.ScanSkylinks(skylinks, abort))
+	if mc.Scans() != 3 || mc.Connections() != connections+1 {
+		t.Fatalf("Expected 3 scans over 1 connection, got %d scans over %d connections", mc.Scans(), mc.Connections()-connections)
+	}
+	if n := c.staticMemory.inUse(); n != 0 {
+		t.Fatalf("Expected the batch to release its memory, %d bytes are in use", n)
+	}
+
+	// Files the memory budget has no room for are scanned one by one.
+	// Without pipelining, those scans don't need any memory.
+	defer func(size int) { PipelineBufferSize = size }(PipelineBufferSize)
+	PipelineBufferSize = 0
+	if !c.staticMemory.tryAcquire(MemoryBudget) {
+		t.Fatal("Failed to fill the memory budget")
+	}
+	check(c.ScanSkylinks(skylinks, abort))
+	c.staticMemory.release(MemoryBudget)
+	if mc.Scans() != 6 || mc.Connections() != connections+1 {
+		t.Fatalf("Expected 6 scans over 1 connection, got %d scans over %d connections", mc.Scans(), mc.Connections()-connections)
+	}
+
+	// Files too large for batching are scanned one by one.
+	defer func(size uint64) { BatchFileSize = size }(BatchFileSize)
+	BatchFileSize = 1
+	check(c.ScanSkylinks(skylinks, abort))
+	if mc.Scans() != 9 {
+		t.Fatalf("Expected 9 scans, got %d", mc.Scans())
+	}
+}
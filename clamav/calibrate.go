@@ -0,0 +1,72 @@
+package clamav
+
+import (
+	"bytes"
+	"math/rand"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// calibrationSampleSize is the size of the content we scan to measure
+	// each chunk size. It's below clamd's default StreamMaxLength of 25MiB.
+	calibrationSampleSize = 16 << 20
+	// calibrationRounds is the number of times we measure each chunk size.
+	// We keep the fastest round, which is the least affected by noise.
+	calibrationRounds = 3
+)
+
+// calibrationChunkSizes are the chunk sizes CalibrateChunkSize measures.
+var calibrationChunkSizes = []int{16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// ChunkSizeThroughput is the throughput of scanning content streamed to clamd
+// in chunks of a given size.
+type ChunkSizeThroughput struct {
+	ChunkSize int
+	// Throughput is in bytes per second. It includes the time clamd takes
+	// to scan the content.
+	Throughput float64
+}
+
+// CalibrateChunkSize scans the same content with each of the candidate chunk
+// sizes and returns the size with the highest throughput, along with all the
+// measurements. Chunks are never larger than the buffers of the pipelined
+// reader, so larger sizes aren't measured. The chunk size only applies to
+// clamd sessions, so it fails if they're disabled. It doesn't change
+// ClamdChunkSize.
+func (c *ClamAV) CalibrateChunkSize(abort chan bool) (int, []ChunkSizeThroughput, error) {
+	if c.staticSessions == nil {
+		return 0, nil, errors.New("calibrating the chunk size requires clamd sessions")
+	}
+	// Any content works as long as each size scans the same, random bytes
+	// just don't match a file type clamd can skip through.
+	sample := make([]byte, calibrationSampleSize)
+	_, _ = rand.New(rand.NewSource(1)).Read(sample)
+
+	var best int
+	var bestThroughput float64
+	var results []ChunkSizeThroughput
+	for _, size := range calibrationChunkSizes {
+		if PipelineBufferSize > 0 && size > PipelineBufferSize && len(results) > 0 {
+			break
+		}
+		var fastest time.Duration
+		for i := 0; i < calibrationRounds; i++ {
+			start := time.Now()
+			_, _, err := c.staticSessions.scanStream(bytes.NewReader(sample), size, abort)
+			if err != nil {
+				return 0, nil, errors.Extend(errors.AddContext(err, "failed to scan the calibration sample"), ErrClamd)
+			}
+			if d := time.Since(start); fastest == 0 || d < fastest {
+				fastest = d
+			}
+		}
+		throughput := float64(len(sample)) / fastest.Seconds()
+		results = append(results, ChunkSizeThroughput{ChunkSize: size, Throughput: throughput})
+		if throughput > bestThroughput {
+			best, bestThroughput = size, throughput
+		}
+	}
+	return best, results, nil
+}
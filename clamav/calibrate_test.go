@@ -0,0 +1,70 @@
+package clamav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+)
+
+// TestChunkWriter ensures content is framed in chunks of up to the given
+// size.
+func TestChunkWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &chunkWriter{w: &buf, size: 4}
+	if n, err := cw.Write([]byte("0123456789")); err != nil || n != 10 {
+		t.Fatalf("Unexpected write %d %v", n, err)
+	}
+	for _, size := range []uint32{4, 4, 2} {
+		if l := binary.BigEndian.Uint32(buf.Next(4)); l != size {
+			t.Fatalf("Expected a chunk of %d bytes, got %d", size, l)
+		}
+		buf.Next(int(size))
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no more bytes, got %d", buf.Len())
+	}
+}
+
+// TestCalibrateChunkSize ensures the calibration measures the candidate chunk
+// sizes up to the pipeline's buffer size and picks one of them.
+func TestCalibrateChunkSize(t *testing.T) {
+	defer func(sizes []int) { calibrationChunkSizes = sizes }(calibrationChunkSizes)
+	calibrationChunkSizes = []int{16 << 10, 64 << 10, 256 << 10}
+	defer func(size int) { PipelineBufferSize = size }(PipelineBufferSize)
+	PipelineBufferSize = 64 << 10
+
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, "http://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	best, results, err := c.CalibrateChunkSize(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ChunkSize != 16<<10 || results[1].ChunkSize != 64<<10 {
+		t.Fatalf("Unexpected measurements %v", results)
+	}
+	if best != 16<<10 && best != 64<<10 {
+		t.Fatalf("Unexpected best chunk size %d", best)
+	}
+	if mc.Scans() != 2*calibrationRounds {
+		t.Fatalf("Expected %d scans, got %d", 2*calibrationRounds, mc.Scans())
+	}
+
+	// Calibration requires sessions.
+	_ = c.Close()
+	c.staticSessions = nil
+	if _, _, err = c.CalibrateChunkSize(nil); err == nil {
+		t.Fatal("Expected the calibration to fail without sessions")
+	}
+}
@@ -4,25 +4,62 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/dutchcoders/go-clamd"
 	"gitlab.com/NebulousLabs/errors"
 )
 
+var (
+	// ErrClamd is returned when ClamAV fails to scan the content.
+	ErrClamd = errors.New("clamd error")
+	// ErrPortalNotFound is returned when the portal responds with 404 Not
+	// Found.
+	ErrPortalNotFound = errors.New("portal responded with 404")
+	// ErrPortalGone is returned when the portal responds with 410 Gone.
+	ErrPortalGone = errors.New("portal responded with 410")
+	// ErrPortalRateLimited is returned when the portal responds with 429
+	// Too Many Requests, or while we back off from it because it did.
+	ErrPortalRateLimited = errors.New("portal rate limited us")
+	// ErrPortalServerError is returned when the portal responds with a 5xx
+	// status code.
+	ErrPortalServerError = errors.New("portal responded with a server error")
+	// ErrPortalUnexpectedStatus is returned when the portal responds with a
+	// status code other than 200, 404, 410, 429 and 5xx.
+	ErrPortalUnexpectedStatus = errors.New("portal responded with an unexpected status")
+	// ErrTimeout is returned when a request times out.
+	ErrTimeout = errors.New("timeout")
+)
+
 // ClamAV is a client that allows scanning of content for malware.
 type ClamAV struct {
-	staticClam   *clamd.Clamd
-	staticPortal string
+	staticClam        *clamd.Clamd
+	staticSessions    *sessionPool
+	staticHTTPClient  *http.Client
+	staticPortals     []string
+	staticPortalStats *portalStats
+	staticInFlight    *inFlightScans
+	staticMemory      *memoryBudget
+	staticLoad        *loadStats
 }
 
 // New creates a new ClamAV client that will try to connect to the ClamAV
 // service listening on a TCP socket at the given address and port. Before
 // returning the client, New verifies the connection to ClamAV.
-func New(clamIP, clamPort, portal string) (*ClamAV, error) {
-	if portal == "" {
-		return nil, errors.New("invalid portal")
+//
+// Content is downloaded from the first of the given portals. The rest of them
+// are only used for failover, in the given order.
+func New(clamIP, clamPort string, portals ...string) (*ClamAV, error) {
+	if len(portals) == 0 {
+		return nil, errors.New("no portals provided")
+	}
+	for _, p := range portals {
+		if p == "" {
+			return nil, errors.New("invalid portal")
+		}
 	}
 	var err error
 	defer func() {
@@ -32,8 +69,16 @@ func New(clamIP, clamPort, portal string) (*ClamAV, error) {
 		}
 	}()
 	clam := &ClamAV{
-		staticClam:   clamd.NewClamd(fmt.Sprintf("tcp://%s:%s", clamIP, clamPort)),
-		staticPortal: portal,
+		staticClam:        clamd.NewClamd(fmt.Sprintf("tcp://%s:%s", clamIP, clamPort)),
+		staticHTTPClient:  PortalClient(),
+		staticPortals:     portals,
+		staticPortalStats: newPortalStats(portals),
+		staticInFlight:    newInFlightScans(),
+		staticMemory:      newMemoryBudget(MemoryBudget),
+		staticLoad:        &loadStats{},
+	}
+	if ClamdSessions > 0 {
+		clam.staticSessions = newSessionPool(net.JoinHostPort(clamIP, clamPort), ClamdSessions)
 	}
 	err = clam.Ping()
 	if err != nil {
@@ -42,57 +87,289 @@ func New(clamIP, clamPort, portal string) (*ClamAV, error) {
 	return clam, nil
 }
 
+// Close closes the idle clamd sessions.
+func (c *ClamAV) Close() error {
+	if c.staticSessions == nil {
+		return nil
+	}
+	return c.staticSessions.Close()
+}
+
 // Ping checks the ClamAV  daemon's state.
 func (c *ClamAV) Ping() error {
+	metricClamdConnections.Inc()
+	defer metricClamdConnections.Dec()
 	return c.staticClam.Ping()
 }
 
+// InFlight returns the progress of all scans currently in progress.
+func (c *ClamAV) InFlight() []ScanProgress {
+	return c.staticInFlight.progress()
+}
+
+// PortalStats returns the download statistics of each configured portal.
+func (c *ClamAV) PortalStats() []PortalStats {
+	return c.staticPortalStats.summary()
+}
+
 // PreferredPortal returns the portal ClamAV uses to download content.
 func (c *ClamAV) PreferredPortal() string {
-	return c.staticPortal
+	return c.staticPortals[0]
 }
 
 // Scan streams the content of the reader to ClamAV for malware scanning.
 // It returns an `infected` flag, a description of the detected malware and an
 // error.
 func (c *ClamAV) Scan(r io.Reader, abort chan bool) (infected bool, description string, err error) {
-	result, err := c.staticClam.ScanStream(r, abort)
+	// Time clamd's response from the end of the content, so we can tell
+	// when clamd is saturated.
+	et := &eofTimer{r: r}
+	defer func() {
+		if err == nil && !et.eofAt.IsZero() {
+			c.staticLoad.recordScan(time.Since(et.eofAt))
+		}
+	}()
+	if c.staticSessions != nil {
+		infected, description, err = c.staticSessions.ScanStream(et, abort)
+		if err != nil {
+			err = errors.Extend(err, ErrClamd)
+		}
+		return
+	}
+	// go-clamd keeps the connection and a goroutine watching the abort
+	// channel alive until that channel is closed. The channel we get is only
+	// closed on shutdown, so we give each scan its own abort channel and close
+	// it once the scan is done.
+	done := make(chan struct{})
+	scanAbort := make(chan bool)
+	go func() {
+		select {
+		case <-abort:
+		case <-done:
+		}
+		close(scanAbort)
+	}()
+	defer close(done)
+
+	metricClamdConnections.Inc()
+	defer metricClamdConnections.Dec()
+	result, err := c.staticClam.ScanStream(et, scanAbort)
 	if err != nil {
+		err = errors.Extend(err, ErrClamd)
 		return
 	}
+	// Drain the results channel, so go-clamd's reading goroutine can exit.
+	// A scan without a verdict, e.g. because clamd failed or closed the
+	// connection early, isn't clean.
+	verdict := false
 	for s := range result {
-		if s.Status == clamd.RES_FOUND {
-			return true, s.Description, nil
+		switch s.Status {
+		case clamd.RES_FOUND:
+			if !infected {
+				infected = true
+				description = s.Description
+			}
+			verdict = true
+		case clamd.RES_OK:
+			verdict = true
+		default:
+			err = errors.AddContext(ErrClamd, "unexpected clamd response: "+s.Raw)
 		}
 	}
+	if err == nil && !verdict {
+		err = errors.AddContext(ErrClamd, "no response from clamd")
+	}
+	if err != nil {
+		infected, description = false, ""
+	}
 	return
 }
 
 // ScanSkylink downloads the content of the given skylink and streams it to
-// ClamAV for scanning. It returns an `infected` flag, a description of the
-// detected malware and an error.
-func (c *ClamAV) ScanSkylink(skylink string, abort chan bool) (infected bool, description string, size, scannedSize uint64, err error) {
-	resp, err := http.Get(fmt.Sprintf("%s/%s", c.staticPortal, skylink))
+// ClamAV for scanning. It returns the verdict, together with the size of the
+// content, how much of it we scanned and the skyfile's metadata.
+func (c *ClamAV) ScanSkylink(skylink string, abort chan bool) SkylinkScan {
+	resp, portal, err := c.download(skylink)
 	if err != nil {
-		return
+		return SkylinkScan{Err: err}
 	}
+	return c.scanResponse(skylink, resp, portal, abort)
+}
+
+// scanResponse streams the content of the given download response to ClamAV
+// for scanning and closes it.
+func (c *ClamAV) scanResponse(skylink string, resp *http.Response, portal string, abort chan bool) SkylinkScan {
+	r := SkylinkScan{File: fileInfo(resp)}
+	r.Infected, r.Description, r.Size, r.ScannedSize, r.Err = c.scanBody(skylink, resp, portal, abort)
+	return r
+}
+
+// scanBody streams the body of the given download response to ClamAV for
+// scanning and closes it.
+func (c *ClamAV) scanBody(skylink string, resp *http.Response, portal string, abort chan bool) (infected bool, description string, size, scannedSize uint64, err error) {
 	defer func() {
-		if err = resp.Body.Close(); err != nil {
-			log.Println(errors.AddContext(err, "error on closing response body"))
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Println(errors.AddContext(errClose, "error on closing response body"))
 		}
 	}()
-	size, err = strconv.ParseUint(resp.Header.Get("content-length"), 10, 64)
+	// The size of compressed content is only known once it's decompressed,
+	// so it's never downloaded in ranges.
+	encoding := contentEncoding(resp)
+	if encoding == "" {
+		size, err = strconv.ParseUint(resp.Header.Get("content-length"), 10, 64)
+		if err != nil {
+			size = 0
+			err = errors.AddContext(err, "failed to fetch content length")
+			return
+		}
+	}
+	// The buffers of the pipelined reader never need to be larger than the
+	// content.
+	ranges := useRanges(resp, size)
+	pipelined := PipelineBufferSize > 0 && PipelineBuffers > 0
+	bufSize := PipelineBufferSize
+	if size > 0 && size < uint64(bufSize) {
+		bufSize = int(size)
+	}
+	// Reserve the memory the scan needs to make progress, a buffer and a
+	// range. Anything more is only used while the budget has room for it.
+	// The content itself is streamed to clamd, so it's never held in
+	// memory as a whole, whatever its size.
+	var reserve int64
+	if ranges {
+		reserve += int64(ParallelDownloadRangeSize)
+	}
+	if pipelined {
+		reserve += int64(bufSize)
+	}
+	if encoding == "zstd" {
+		reserve += zstdMaxWindow
+	}
+	release, err := c.staticMemory.reserve(reserve, abort)
 	if err != nil {
-		size = 0
-		err = errors.AddContext(err, "failed to fetch content length")
 		return
 	}
-	// Wrap the body's ReadCloser in a counting reader and check how may bytes
-	// have been read from it. That's how we'll know how much of the content we
-	// managed to scan.
-	rc := NewReaderCounter(resp.Body)
+	defer release()
+	// Abort the download if the portal stops sending the content.
+	resp.Body = newIdleTimeoutReader(resp.Body)
+	// Download large files over several connections. The reader is closed
+	// before the response, so that's where we read from once it's done.
+	var body io.Reader = resp.Body
+	if ranges {
+		rr := newRangeReader(c.staticHTTPClient, resp, size, ParallelDownloadRangeSize, ParallelDownloads, c.staticMemory)
+		defer func() { _ = rr.Close() }()
+		body = rr
+	}
+	// Count the bytes we download separately from the ones we scan when
+	// they differ.
+	wire := NewReaderCounter(body)
+	if encoding != "" {
+		var dec *decompressor
+		dec, err = newDecompressor(wire, encoding)
+		if err != nil {
+			return
+		}
+		defer func() { _ = dec.Close() }()
+		body = dec
+		metricCompressedDownloads.With(encoding).Inc()
+	} else {
+		body = wire
+	}
+	// Download the content ahead of clamd, so the download doesn't stall
+	// while clamd processes each chunk.
+	if pipelined {
+		pr := newPipelinedReader(body, bufSize, PipelineBuffers, c.staticMemory)
+		defer func() { _ = pr.Close() }()
+		body = pr
+	}
+	// Wrap the body in a counting reader and check how may bytes have been
+	// read from it. That's how we'll know how much of the content we managed
+	// to scan.
+	rc := NewReaderCounter(body)
+	er := &errRecorder{r: rc}
+	id := c.staticInFlight.add(&inFlightScan{
+		skylink: skylink,
+		portal:  portal,
+		started: time.Now().UTC(),
+		size:    size,
+		rc:      rc,
+	})
+	defer c.staticInFlight.remove(id)
 	// Scan the content.
-	infected, description, err = c.Scan(rc, abort)
+	scanStart := time.Now()
+	infected, description, err = c.Scan(er, abort)
 	scannedSize = rc.ReadBytes()
+	// A stalled or truncated download isn't scanned completely, so the scan
+	// fails and is retried unless what we got is already infected. The same
+	// goes for compressed content, which we can't tell is complete
+	// otherwise.
+	if encoding != "" && er.err == nil {
+		size = scannedSize
+	}
+	if err == nil && !infected && er.err != nil && (encoding != "" || errors.Contains(er.err, ErrTimeout) || errors.Contains(er.err, io.ErrUnexpectedEOF)) {
+		err = errors.AddContext(er.err, "failed to download the content")
+	}
+	if d := time.Since(scanStart).Seconds(); err == nil && d > 0 {
+		metricScanThroughput.Observe(float64(scannedSize) / d)
+	}
+	c.staticPortalStats.recordBytes(portal, wire.ReadBytes())
 	return
 }
+
+// download requests the content of the given skylink from the configured
+// portals in order and returns the first successful response, together with
+// the portal that served it. If all portals fail, it returns the last error.
+// Portals which rate limited us are skipped until their backoff ends.
+func (c *ClamAV) download(skylink string) (*http.Response, string, error) {
+	var err error
+	for _, portal := range c.staticPortals {
+		if c.staticPortalStats.rateLimited(portal) {
+			err = errors.AddContext(ErrPortalRateLimited, "backing off from "+portal)
+			continue
+		}
+		start := time.Now()
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", portal, skylink), nil)
+		if err != nil {
+			return nil, "", errors.AddContext(err, "failed to create download request")
+		}
+		setAcceptEncoding(req)
+		var resp *http.Response
+		resp, err = c.staticHTTPClient.Do(req)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				err = errors.Extend(err, ErrTimeout)
+			}
+		} else if err = checkPortalStatus(resp.StatusCode); err != nil {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				c.staticPortalStats.rateLimit(portal, retryAfter(resp))
+			}
+			_ = resp.Body.Close()
+		}
+		c.staticPortalStats.recordRequest(portal, time.Since(start), err)
+		c.staticLoad.recordRequest(err)
+		if err == nil {
+			return resp, portal, nil
+		}
+	}
+	return nil, "", err
+}
+
+// checkPortalStatus returns an error describing the given portal response
+// status code, or nil if the status is 200 OK.
+func checkPortalStatus(status int) error {
+	switch {
+	case status == http.StatusOK:
+		return nil
+	case status == http.StatusNotFound:
+		return ErrPortalNotFound
+	case status == http.StatusGone:
+		return ErrPortalGone
+	case status == http.StatusTooManyRequests:
+		return ErrPortalRateLimited
+	case status >= 500:
+		return errors.AddContext(ErrPortalServerError, fmt.Sprintf("status code %d", status))
+	default:
+		return errors.AddContext(ErrPortalUnexpectedStatus, fmt.Sprintf("status code %d", status))
+	}
+}
@@ -0,0 +1,335 @@
+package clamav
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestScanSkylink ensures ScanSkylink downloads the content from the portal
+// and reports the verdict, size and scanned size.
+func TestScanSkylink(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	r := c.ScanSkylink("clean", abort)
+	if r.Err != nil || r.Infected || r.Size != 5 || r.ScannedSize != 5 {
+		t.Fatalf("Unexpected clean scan result: %+v", r)
+	}
+	r = c.ScanSkylink("eicar", abort)
+	if r.Err != nil || !r.Infected || r.Description != test.EICARSignature || r.Size != uint64(len(test.EICAR)) {
+		t.Fatalf("Unexpected infected scan result: %+v", r)
+	}
+	r = c.ScanSkylink("missing", abort)
+	if r.Err == nil || !strings.Contains(r.Err.Error(), ErrPortalNotFound.Error()) {
+		t.Fatalf("Expected error '%s', got '%v'", ErrPortalNotFound, r.Err)
+	}
+}
+
+// TestScanSkylinkFailover ensures ScanSkylink falls back to the next portal
+// when the preferred one fails and tracks per-portal statistics.
+func TestScanSkylinkFailover(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, down.URL, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	if c.PreferredPortal() != down.URL {
+		t.Fatalf("Expected preferred portal %s, got %s", down.URL, c.PreferredPortal())
+	}
+	abort := make(chan bool)
+	defer close(abort)
+
+	r := c.ScanSkylink("eicar", abort)
+	if r.Err != nil || !r.Infected {
+		t.Fatalf("Expected an infected verdict from the failover portal, got %t, %v", r.Infected, r.Err)
+	}
+	stats := c.PortalStats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected stats for 2 portals, got %d", len(stats))
+	}
+	if stats[0].Requests != 1 || stats[0].Failures != 1 || stats[0].SuccessRate != 0 || stats[0].BytesDownloaded != 0 {
+		t.Fatalf("Unexpected stats of the failing portal %+v", stats[0])
+	}
+	if stats[1].Requests != 1 || stats[1].Failures != 0 || stats[1].SuccessRate != 1 || stats[1].BytesDownloaded != r.ScannedSize {
+		t.Fatalf("Unexpected stats of the failover portal %+v", stats[1])
+	}
+
+	// When all portals fail, we get the last portal's error.
+	r = c.ScanSkylink("missing", abort)
+	if r.Err == nil || !strings.Contains(r.Err.Error(), ErrPortalNotFound.Error()) {
+		t.Fatalf("Expected error '%s', got '%v'", ErrPortalNotFound, r.Err)
+	}
+}
+
+// TestScanNoLeaks ensures that neither successful nor failed scans leak
+// goroutines or connections.
+func TestScanNoLeaks(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	// The abort channel is only closed at the end, just like in production.
+	abort := make(chan bool)
+	defer close(abort)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		for _, sl := range []string{"clean", "eicar", "missing", "error"} {
+			_ = c.ScanSkylink(sl, abort)
+		}
+		_ = c.Ping()
+	}
+	c.staticHTTPClient.CloseIdleConnections()
+	_ = c.Close()
+
+	// Give the background goroutines a moment to wind down.
+	var after int
+	for i := 0; i < 100; i++ {
+		after = runtime.NumGoroutine()
+		if after <= before && metricClamdConnections.Value() == 0 && metricPortalConnections.Value() == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Leak detected: goroutines before %d, after %d, clamd connections %f, portal connections %f",
+		before, after, metricClamdConnections.Value(), metricPortalConnections.Value())
+}
+
+// newMockPortal returns a server which serves "clean" and "eicar" content,
+// responds with 404 to "missing" and with 500 to everything else.
+func newMockPortal() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content string
+		switch r.URL.Path {
+		case "/clean":
+			content = "hello"
+		case "/eicar":
+			content = test.EICAR
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		_, _ = w.Write([]byte(content))
+	}))
+}
+
+// TestSignatureInfo ensures we can query and parse the signature database
+// version and date.
+func TestSignatureInfo(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, "http://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	si, err := c.SignatureInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDate := time.Date(2021, 12, 7, 9, 21, 39, 0, time.UTC)
+	if si.Engine != "ClamAV 0.104.1" || si.Version != 26390 || !si.Date.Equal(expectedDate) {
+		t.Fatalf("Unexpected signature info %+v", si)
+	}
+
+	_, err = parseVersion("ClamAV 0.104.1")
+	if err == nil {
+		t.Fatal("Expected an error for a version without a signature database")
+	}
+}
+
+// TestInFlightScans ensures we track the progress of scans in progress.
+func TestInFlightScans(t *testing.T) {
+	ifs := newInFlightScans()
+	rc := NewReaderCounter(strings.NewReader("content"))
+	now := time.Now()
+	id1 := ifs.add(&inFlightScan{skylink: "second", started: now, size: 7, rc: rc})
+	_ = ifs.add(&inFlightScan{skylink: "first", started: now.Add(-time.Minute), rc: NewReaderCounter(nil)})
+	_, _ = rc.Read(make([]byte, 3))
+
+	ps := ifs.progress()
+	if len(ps) != 2 || ps[0].Skylink != "first" || ps[1].Skylink != "second" {
+		t.Fatalf("Unexpected scans in progress %+v", ps)
+	}
+	if ps[1].Size != 7 || ps[1].ScannedBytes != 3 {
+		t.Fatalf("Unexpected progress %+v", ps[1])
+	}
+	ifs.remove(id1)
+	if ps = ifs.progress(); len(ps) != 1 {
+		t.Fatalf("Expected one scan in progress, got %+v", ps)
+	}
+}
+
+// TestPortalClient ensures all portal requests share one tuned client.
+func TestPortalClient(t *testing.T) {
+	c := PortalClient()
+	if c != PortalClient() {
+		t.Fatal("Expected the portal client to be shared")
+	}
+	tr, ok := c.Transport.(*http.Transport)
+	if !ok || tr.MaxIdleConnsPerHost != PortalMaxIdleConnsPerHost || tr.MaxIdleConns < tr.MaxIdleConnsPerHost || tr.ResponseHeaderTimeout != PortalResponseTimeout {
+		t.Fatalf("Unexpected portal transport %+v", c.Transport)
+	}
+}
+
+// TestScanChaos ensures scans which clamd fails or cuts short return an error
+// instead of a clean verdict, with and without sessions.
+func TestScanChaos(t *testing.T) {
+	defer func(n int) { ClamdSessions = n }(ClamdSessions)
+	for _, sessions := range []int{0, 2} {
+		ClamdSessions = sessions
+		mc, err := test.NewMockClam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mc.SetChaos(test.Chaos{ErrorRate: 0.3, PartialRate: 0.3, Seed: 1})
+		ip, port := mc.Addr()
+		c, err := New(ip, port, "http://portal.invalid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		abort := make(chan bool)
+		var failed int
+		for i := 0; i < 50; i++ {
+			inf, desc, err := c.Scan(strings.NewReader(test.EICAR), abort)
+			if err != nil {
+				if !errors.Contains(err, ErrClamd) {
+					t.Fatalf("Expected a clamd error, got %v", err)
+				}
+				failed++
+				continue
+			}
+			if !inf || desc != test.EICARSignature {
+				t.Fatalf("Expected an infected verdict with %d sessions, got %t '%s'", sessions, inf, desc)
+			}
+		}
+		if failed == 0 || failed != mc.Faults() {
+			t.Fatalf("Expected %d failed scans with %d sessions, got %d", mc.Faults(), sessions, failed)
+		}
+		close(abort)
+		_ = c.Close()
+		_ = mc.Close()
+	}
+}
+
+// TestScanTruncated ensures a download the portal cuts short fails the scan
+// instead of giving a clean verdict for the part we got.
+func TestScanTruncated(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := test.NewMockPortal()
+	defer portal.Close()
+	portal.SetContent("eicar", append(test.Content(4096), test.EICAR...))
+	portal.SetChaos(test.Chaos{PartialRate: 1})
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	r := c.ScanSkylink("eicar", abort)
+	if r.Err == nil || r.Infected || r.ScannedSize >= r.Size {
+		t.Fatalf("Expected a truncated download to fail, got %t, %d of %d bytes, %v", r.Infected, r.ScannedSize, r.Size, r.Err)
+	}
+}
+
+// TestScanAssets ensures we scan realistic skyfiles of every kind with the
+// right verdict and size, and stop at decompression bombs when we accept
+// compressed content.
+func TestScanAssets(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := test.NewMockPortal()
+	defer portal.Close()
+	assets := test.StandardAssets()
+	for name, a := range assets {
+		portal.SetAsset(name, a)
+	}
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	for name, a := range assets {
+		if a.Encoding != "" {
+			// Compressed assets are only scanned when we accept
+			// compressed content, below.
+			continue
+		}
+		r := c.ScanSkylink(name, abort)
+		if r.Err != nil || r.Infected != a.Infected || r.Size != uint64(a.Size) || r.ScannedSize != r.Size {
+			t.Fatalf("Unexpected scan of %s: %+v", name, r)
+		}
+		if r.File.Directory != a.Metadata.IsDirectory() || !strings.HasPrefix(r.File.Filename, name) || !strings.HasPrefix(a.ContentType, r.File.ContentType) {
+			t.Fatalf("Unexpected file info of %s: %+v", name, r.File)
+		}
+	}
+
+	defer func(compression bool) { PortalCompression = compression }(PortalCompression)
+	PortalCompression = true
+	if r := c.ScanSkylink("bomb.bin", abort); !errors.Contains(r.Err, ErrDecompressionBomb) {
+		t.Fatalf("Expected a decompression bomb, got %v", r.Err)
+	}
+}
@@ -0,0 +1,140 @@
+package clamav
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// zstdMaxWindow is the largest zstd window we decode. It bounds the
+	// memory of the decoder, which holds on to a window of decoded content.
+	// Portals compress with much smaller windows by default.
+	zstdMaxWindow = 8 << 20
+	// decompressionSlack is the number of decompressed bytes we read
+	// regardless of MaxDecompressionRatio, so small, highly compressible
+	// files aren't mistaken for bombs.
+	decompressionSlack = 1 << 20
+)
+
+var (
+	// PortalCompression makes us ask the portal to compress downloads with
+	// zstd or gzip. Only compressible content is usually compressed, at the
+	// cost of some CPU on both ends, so it's worth it when the portal is
+	// remote and the download bandwidth is the bottleneck.
+	// Set according to the MALWARE_SCANNER_PORTAL_COMPRESSION env var.
+	PortalCompression bool
+	// MaxDecompressionRatio is the number of bytes compressed content may
+	// decompress to per compressed byte, beyond the first MiB. Content
+	// which decompresses further is a decompression bomb and we stop
+	// reading it. Zero disables the limit.
+	// Set according to the MALWARE_SCANNER_MAX_DECOMPRESSION_RATIO env var.
+	MaxDecompressionRatio uint64 = 200
+
+	// ErrDecompressionBomb is returned when compressed content decompresses
+	// beyond MaxDecompressionRatio.
+	ErrDecompressionBomb = errors.New("content exceeds the maximum decompression ratio")
+)
+
+// decompressor is a reader of the decompressed content of a response.
+type decompressor struct {
+	io.Reader
+	close func()
+}
+
+// Close implements io.Closer. It releases the decoder, it doesn't close the
+// response's body.
+func (d *decompressor) Close() error {
+	if d.close != nil {
+		d.close()
+	}
+	return nil
+}
+
+// setAcceptEncoding asks the portal for a compressed response, if
+// PortalCompression is enabled. Setting the header ourselves stops the HTTP
+// client from transparently decompressing gzip, so we decompress both.
+func setAcceptEncoding(req *http.Request) {
+	if PortalCompression {
+		req.Header.Set("Accept-Encoding", "zstd, gzip")
+	}
+}
+
+// contentEncoding returns the encoding of the given response's body, or an
+// empty string if it's not compressed.
+func contentEncoding(resp *http.Response) string {
+	enc := resp.Header.Get("Content-Encoding")
+	if enc == "identity" {
+		return ""
+	}
+	return enc
+}
+
+// ratioLimitReader reads decompressed content and fails with
+// ErrDecompressionBomb once it exceeds MaxDecompressionRatio relative to the
+// compressed content read so far. It's meant for any decompression we do,
+// including extracting archives.
+type ratioLimitReader struct {
+	r          io.Reader
+	compressed *ReaderCounter
+	n          uint64
+}
+
+// newRatioLimitReader returns a reader of the content decompressed by r from
+// the compressed content read through the given counter.
+func newRatioLimitReader(r io.Reader, compressed *ReaderCounter) io.Reader {
+	if MaxDecompressionRatio == 0 {
+		return r
+	}
+	return &ratioLimitReader{r: r, compressed: compressed}
+}
+
+// Read implements io.Reader.
+func (rl *ratioLimitReader) Read(p []byte) (int, error) {
+	n, err := rl.r.Read(p)
+	rl.n += uint64(n)
+	if rl.n > decompressionSlack && rl.n-decompressionSlack > MaxDecompressionRatio*rl.compressed.ReadBytes() {
+		return n, ErrDecompressionBomb
+	}
+	return n, err
+}
+
+// newDecompressor returns a reader of the decompressed content of the given
+// body, which is compressed with the given encoding. It stops at
+// decompression bombs.
+func newDecompressor(body *ReaderCounter, encoding string) (*decompressor, error) {
+	d, err := newDecoder(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	d.Reader = newRatioLimitReader(d.Reader, body)
+	return d, nil
+}
+
+// newDecoder returns a reader of the decompressed content of the given body,
+// which is compressed with the given encoding.
+func newDecoder(body io.Reader, encoding string) (*decompressor, error) {
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to read gzip header")
+		}
+		return &decompressor{Reader: gz}, nil
+	case "zstd":
+		dec, err := zstd.NewReader(body,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(zstdMaxWindow),
+		)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to create zstd decoder")
+		}
+		return &decompressor{Reader: dec, close: dec.Close}, nil
+	default:
+		return nil, errors.New("unsupported content encoding " + encoding)
+	}
+}
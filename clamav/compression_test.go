@@ -0,0 +1,110 @@
+package clamav
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+	"github.com/klauspost/compress/zstd"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestScanCompressed ensures we ask for compressed downloads when configured
+// to, and scan the decompressed content.
+func TestScanCompressed(t *testing.T) {
+	defer func(compression bool) { PortalCompression = compression }(PortalCompression)
+	PortalCompression = true
+
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte(test.EICAR))
+	if err = gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := enc.EncodeAll([]byte("hello"), nil)
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "zstd, gzip" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gz.Bytes())
+		case "/zstd":
+			w.Header().Set("Content-Encoding", "zstd")
+			_, _ = w.Write(zst)
+		case "/corrupt":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gz.Bytes()[:gz.Len()/2])
+		}
+	}))
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	r := c.ScanSkylink("gzip", abort)
+	if r.Err != nil || !r.Infected || r.Description != test.EICARSignature || r.Size != uint64(len(test.EICAR)) || r.ScannedSize != r.Size {
+		t.Fatalf("Unexpected scan %+v", r)
+	}
+	r = c.ScanSkylink("zstd", abort)
+	if r.Err != nil || r.Infected || r.Size != 5 || r.ScannedSize != r.Size {
+		t.Fatalf("Unexpected scan %+v", r)
+	}
+	// Content which can't be decompressed completely isn't scanned
+	// completely.
+	if r = c.ScanSkylink("corrupt", abort); r.Err == nil {
+		t.Fatal("Expected the scan to fail")
+	}
+}
+
+// TestDecompressionBomb ensures we stop reading content which decompresses far
+// beyond MaxDecompressionRatio, but not highly compressible small content.
+func TestDecompressionBomb(t *testing.T) {
+	compress := func(size int64) []byte {
+		return test.GzipBombAsset("zeros", size).Bytes()
+	}
+	read := func(content []byte) error {
+		dec, err := newDecompressor(NewReaderCounter(bytes.NewReader(content)), "gzip")
+		if err != nil {
+			return err
+		}
+		defer func() { _ = dec.Close() }()
+		_, err = io.Copy(io.Discard, dec)
+		return err
+	}
+	// 512 KiB of zeros compresses about a thousandfold, but it's within
+	// the slack.
+	if err := read(compress(512 << 10)); err != nil {
+		t.Fatal(err)
+	}
+	bomb := compress(16 << 20)
+	if err := read(bomb); !errors.Contains(err, ErrDecompressionBomb) {
+		t.Fatalf("Expected a decompression bomb, got %v", err)
+	}
+	defer func(ratio uint64) { MaxDecompressionRatio = ratio }(MaxDecompressionRatio)
+	MaxDecompressionRatio = 0
+	if err := read(bomb); err != nil {
+		t.Fatal(err)
+	}
+}
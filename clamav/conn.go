@@ -0,0 +1,96 @@
+package clamav
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// Dial connects to the portal. It defaults to a regular net.Dialer. It
+	// must be set before creating a ClamAV client.
+	// Set when the MALWARE_SCANNER_HNS_RESOLVER env var is set.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// PortalMaxIdleConnsPerHost is the number of idle keep-alive connections
+	// we keep open to each portal. It should cover the number of concurrent
+	// scans, so scanning doesn't pay for a TLS handshake per file.
+	// Set according to the MALWARE_SCANNER_PORTAL_MAX_IDLE_CONNS env var.
+	PortalMaxIdleConnsPerHost = 64
+	// PortalResponseTimeout is how long we wait for the portal to respond
+	// with the headers of a response. It doesn't limit the time it takes to
+	// download the content.
+	// Set according to the MALWARE_SCANNER_PORTAL_RESPONSE_TIMEOUT env var.
+	PortalResponseTimeout = 2 * time.Minute
+	// PortalMaxHeaderBytes is the size of the headers of a portal response
+	// we read at most, so a hostile portal can't make us buffer endless
+	// headers.
+	// Set according to the MALWARE_SCANNER_PORTAL_MAX_HEADER_BYTES env var.
+	PortalMaxHeaderBytes int64 = 64 << 10
+
+	// portalClient is the HTTP client shared by all portal requests.
+	portalClient     *http.Client
+	portalClientOnce sync.Once
+)
+
+// countingConn is a net.Conn which keeps the open connections gauge up to
+// date.
+type countingConn struct {
+	net.Conn
+	once sync.Once
+}
+
+// Close implements net.Conn.
+func (cc *countingConn) Close() error {
+	cc.once.Do(metricPortalConnections.Dec)
+	return cc.Conn.Close()
+}
+
+// PortalClient returns the HTTP client we use for all requests to the portals,
+// both downloads and metadata requests, so they share the same keep-alive
+// connections. The settings above must be set before it's first called.
+func PortalClient() *http.Client {
+	portalClientOnce.Do(func() {
+		portalClient = newPortalClient()
+	})
+	return portalClient
+}
+
+// newPortalClient returns the HTTP client we use for talking to the portal. It
+// tracks the number of open connections and keeps requests within the pinned
+// portal hosts.
+func newPortalClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	dial := dialer.DialContext
+	if Dial != nil {
+		dial = Dial
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = PortalMaxIdleConnsPerHost
+	if transport.MaxIdleConns < PortalMaxIdleConnsPerHost {
+		transport.MaxIdleConns = PortalMaxIdleConnsPerHost
+	}
+	transport.ResponseHeaderTimeout = PortalResponseTimeout
+	transport.MaxResponseHeaderBytes = PortalMaxHeaderBytes
+	if portalTLSConfig != nil {
+		transport.TLSClientConfig = portalTLSConfig.Clone()
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		if err = checkPortalConn(host, conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		metricPortalConnections.Inc()
+		return &countingConn{Conn: conn}, nil
+	}
+	return &http.Client{Transport: transport, CheckRedirect: checkPortalRedirect}
+}
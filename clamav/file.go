@@ -0,0 +1,35 @@
+package clamav
+
+import (
+	"mime"
+	"net/http"
+)
+
+// FileInfo describes a skyfile the way the portal serves it.
+type FileInfo struct {
+	// Filename is the name the portal serves the skyfile under. Directories
+	// are served as archives, so their name carries the archive's
+	// extension.
+	Filename string
+	// ContentType is the media type of the content, without parameters.
+	ContentType string
+	// Directory is set when the skyfile holds several files and the portal
+	// serves them as an archive. Directories with a default path are served
+	// as their default file instead and aren't flagged.
+	Directory bool
+}
+
+// fileInfo reads the skyfile's metadata from the headers of the portal's
+// download response. skyd serves single files inline and attaches the
+// archives it builds of directories.
+func fileInfo(resp *http.Response) FileInfo {
+	var fi FileInfo
+	if ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		fi.ContentType = ct
+	}
+	if disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		fi.Filename = params["filename"]
+		fi.Directory = disposition == "attachment"
+	}
+	return fi
+}
@@ -0,0 +1,30 @@
+package clamav
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestFileInfo ensures we read the skyfile's metadata from the headers skyd
+// serves it with.
+func TestFileInfo(t *testing.T) {
+	tests := []struct {
+		contentType string
+		disposition string
+		fi          FileInfo
+	}{
+		{"text/plain; charset=utf-8", `inline; filename="readme.txt"`, FileInfo{Filename: "readme.txt", ContentType: "text/plain"}},
+		{"application/zip", `attachment; filename="site.zip"`, FileInfo{Filename: "site.zip", ContentType: "application/zip", Directory: true}},
+		{"application/octet-stream", `inline; filename*=utf-8''%E2%82%AC.exe`, FileInfo{Filename: "€.exe", ContentType: "application/octet-stream"}},
+		{"", "", FileInfo{}},
+		{"not a type", "inline; filename", FileInfo{}},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: make(http.Header)}
+		resp.Header.Set("Content-Type", tt.contentType)
+		resp.Header.Set("Content-Disposition", tt.disposition)
+		if fi := fileInfo(resp); fi != tt.fi {
+			t.Errorf("Expected %+v for '%s' and '%s', got %+v", tt.fi, tt.contentType, tt.disposition, fi)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package clamav
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// ScanProgress describes a scan which is currently in progress.
+	ScanProgress struct {
+		Skylink      string    `json:"skylink"`
+		Portal       string    `json:"portal"`
+		Started      time.Time `json:"started"`
+		Size         uint64    `json:"size"`
+		ScannedBytes uint64    `json:"scannedBytes"`
+	}
+
+	// inFlightScan is a scan in progress, together with the reader which
+	// tells us how far along it is.
+	inFlightScan struct {
+		skylink string
+		portal  string
+		started time.Time
+		size    uint64
+		rc      *ReaderCounter
+	}
+
+	// inFlightScans keeps track of all scans in progress.
+	inFlightScans struct {
+		scans  map[uint64]*inFlightScan
+		nextID uint64
+		mu     sync.Mutex
+	}
+)
+
+// newInFlightScans returns an empty set of scans in progress.
+func newInFlightScans() *inFlightScans {
+	return &inFlightScans{
+		scans: make(map[uint64]*inFlightScan),
+	}
+}
+
+// add registers a new scan in progress and returns its id.
+func (ifs *inFlightScans) add(s *inFlightScan) uint64 {
+	ifs.mu.Lock()
+	defer ifs.mu.Unlock()
+	id := ifs.nextID
+	ifs.nextID++
+	ifs.scans[id] = s
+	return id
+}
+
+// remove unregisters the scan with the given id.
+func (ifs *inFlightScans) remove(id uint64) {
+	ifs.mu.Lock()
+	defer ifs.mu.Unlock()
+	delete(ifs.scans, id)
+}
+
+// progress returns the progress of all scans in progress, oldest first.
+func (ifs *inFlightScans) progress() []ScanProgress {
+	ifs.mu.Lock()
+	defer ifs.mu.Unlock()
+	ps := make([]ScanProgress, 0, len(ifs.scans))
+	for _, s := range ifs.scans {
+		ps = append(ps, ScanProgress{
+			Skylink:      s.skylink,
+			Portal:       s.portal,
+			Started:      s.started,
+			Size:         s.size,
+			ScannedBytes: s.rc.ReadBytes(),
+		})
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Started.Before(ps[j].Started) })
+	return ps
+}
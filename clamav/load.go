@@ -0,0 +1,97 @@
+package clamav
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// LoadStats describes how loaded clamd and the portals were since the
+	// previous call to TakeLoadStats.
+	LoadStats struct {
+		// Scans is the number of scans clamd responded to.
+		Scans int
+		// ClamdLatency is the average time clamd took to respond once it
+		// got all the content. It grows when clamd is saturated, unlike the
+		// scan duration, which mostly depends on the file size.
+		ClamdLatency time.Duration
+		// PortalRequests is the number of download requests we sent.
+		PortalRequests int
+		// PortalErrors is the number of download requests which failed for
+		// any reason other than the content not being found, e.g. timeouts
+		// and server errors.
+		PortalErrors int
+	}
+
+	// loadStats accumulates the load statistics between two calls to take.
+	loadStats struct {
+		stats        LoadStats
+		totalLatency time.Duration
+		mu           sync.Mutex
+	}
+
+	// eofTimer records when its source is exhausted.
+	eofTimer struct {
+		r     io.Reader
+		eofAt time.Time
+	}
+)
+
+// TakeLoadStats returns the load statistics since the previous call and
+// resets them.
+func (c *ClamAV) TakeLoadStats() LoadStats {
+	return c.staticLoad.take()
+}
+
+// recordScan records the time clamd took to respond to a scan.
+func (ls *loadStats) recordScan(latency time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.stats.Scans++
+	ls.totalLatency += latency
+}
+
+// recordRequest records the outcome of a download request.
+func (ls *loadStats) recordRequest(err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.stats.PortalRequests++
+	if err != nil && !errors.Contains(err, ErrPortalNotFound) && !errors.Contains(err, ErrPortalGone) {
+		ls.stats.PortalErrors++
+	}
+}
+
+// take returns the accumulated statistics and resets them.
+func (ls *loadStats) take() LoadStats {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	stats := ls.stats
+	if stats.Scans > 0 {
+		stats.ClamdLatency = ls.totalLatency / time.Duration(stats.Scans)
+	}
+	ls.stats = LoadStats{}
+	ls.totalLatency = 0
+	return stats
+}
+
+// Read implements io.Reader.
+func (et *eofTimer) Read(p []byte) (int, error) {
+	n, err := et.r.Read(p)
+	if err == io.EOF && et.eofAt.IsZero() {
+		et.eofAt = time.Now()
+	}
+	return n, err
+}
+
+// WriteTo implements io.WriterTo, so copying from the timer can use the
+// source's WriteTo or the destination's ReadFrom.
+func (et *eofTimer) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, et.r)
+	if err == nil && et.eofAt.IsZero() {
+		et.eofAt = time.Now()
+	}
+	return n, err
+}
@@ -0,0 +1,39 @@
+package clamav
+
+import (
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+)
+
+// TestTakeLoadStats ensures scans and download requests are counted, missing
+// content isn't counted as a portal error and taking the stats resets them.
+func TestTakeLoadStats(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL, "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	for _, skylink := range []string{"clean", "eicar", "missing"} {
+		_ = c.ScanSkylink(skylink, abort)
+	}
+	// The missing skylink fails over to the second portal, which is down.
+	ls := c.TakeLoadStats()
+	if ls.Scans != 2 || ls.ClamdLatency <= 0 || ls.PortalRequests != 4 || ls.PortalErrors != 1 {
+		t.Fatalf("Unexpected load stats %+v", ls)
+	}
+	if ls = c.TakeLoadStats(); ls != (LoadStats{}) {
+		t.Fatalf("Expected the stats to be reset, got %+v", ls)
+	}
+}
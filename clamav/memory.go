@@ -0,0 +1,123 @@
+package clamav
+
+import (
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// MemoryBudget is the number of bytes all scans together can hold in memory,
+// in the buffers of the pipelined reader, the ranges of parallel downloads and
+// the contents of batches. Each scan reserves the least it needs to make
+// progress before it starts, which bounds the number of scans which download
+// at the same time, and only uses more buffers while the budget has room for
+// them. Everything else is streamed from the portal to clamd in small chunks.
+// Zero disables the budget.
+// Set according to the MALWARE_SCANNER_MEMORY_BUDGET env var.
+var MemoryBudget int64 = 256 << 20
+
+// errMemoryWaitAborted is returned when a scan is aborted while it waits for
+// memory.
+var errMemoryWaitAborted = errors.New("scan aborted while waiting for memory")
+
+// memoryBudget hands out bytes of memory until it runs out. Its methods can be
+// called on a nil budget, which is unlimited.
+type memoryBudget struct {
+	size int64
+	used int64
+	// waiting is the number of reservations waiting for memory. Optional
+	// buffers aren't handed out while there are any, so they can't starve
+	// scans which haven't started yet.
+	waiting int
+	// released is closed and replaced whenever memory is released, to wake
+	// up the waiting reservations.
+	released chan struct{}
+	mu       sync.Mutex
+}
+
+// newMemoryBudget returns a budget of the given number of bytes, or nil if
+// it's not positive.
+func newMemoryBudget(size int64) *memoryBudget {
+	if size <= 0 {
+		return nil
+	}
+	return &memoryBudget{
+		size:     size,
+		released: make(chan struct{}),
+	}
+}
+
+// reserve waits until n bytes are available and takes them. Reservations
+// larger than the whole budget take the whole budget, so they still run, one
+// at a time. It returns a function which releases the bytes.
+func (b *memoryBudget) reserve(n int64, abort chan bool) (func(), error) {
+	if b == nil || n <= 0 {
+		return func() {}, nil
+	}
+	if n > b.size {
+		n = b.size
+	}
+	b.mu.Lock()
+	if b.used+n > b.size {
+		metricMemoryWaits.Inc()
+		b.waiting++
+		for b.used+n > b.size {
+			released := b.released
+			b.mu.Unlock()
+			select {
+			case <-abort:
+				b.mu.Lock()
+				b.waiting--
+				b.mu.Unlock()
+				return nil, errMemoryWaitAborted
+			case <-released:
+			}
+			b.mu.Lock()
+		}
+		b.waiting--
+	}
+	b.used += n
+	metricMemoryUsed.Add(float64(n))
+	b.mu.Unlock()
+	var once sync.Once
+	return func() { once.Do(func() { b.release(n) }) }, nil
+}
+
+// tryAcquire takes n bytes if they're available and no reservation is waiting
+// for memory. It returns whether it took them.
+func (b *memoryBudget) tryAcquire(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.waiting > 0 || b.used+n > b.size {
+		return false
+	}
+	b.used += n
+	metricMemoryUsed.Add(float64(n))
+	return true
+}
+
+// release returns n bytes to the budget.
+func (b *memoryBudget) release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	metricMemoryUsed.Add(-float64(n))
+	close(b.released)
+	b.released = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// inUse returns the number of bytes taken from the budget.
+func (b *memoryBudget) inUse() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
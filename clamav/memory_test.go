@@ -0,0 +1,121 @@
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestMemoryBudget ensures reservations wait for memory, optional memory is
+// only handed out while there's room for it and a nil budget is unlimited.
+func TestMemoryBudget(t *testing.T) {
+	b := newMemoryBudget(100)
+	abort := make(chan bool)
+	release, err := b.reserve(60, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.tryAcquire(40) || b.tryAcquire(1) {
+		t.Fatal("Expected to get exactly the rest of the budget")
+	}
+
+	// A reservation waits until there's room for it, and optional memory
+	// isn't handed out while it waits.
+	reserved := make(chan func())
+	go func() {
+		r, err := b.reserve(50, abort)
+		if err != nil {
+			t.Error(err)
+		}
+		reserved <- r
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("Expected the reservation to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.release(40)
+	if b.tryAcquire(10) {
+		t.Fatal("Expected no optional memory while a reservation waits")
+	}
+	release()
+	release()
+	r := <-reserved
+	if b.inUse() != 50 {
+		t.Fatalf("Expected 50 bytes in use, got %d", b.inUse())
+	}
+
+	// Reservations can be aborted, and reservations larger than the budget
+	// take all of it.
+	close(abort)
+	if _, err := b.reserve(60, abort); err != errMemoryWaitAborted {
+		t.Fatalf("Expected the reservation to be aborted, got %v", err)
+	}
+	r()
+	release, err = b.reserve(1000, abort)
+	if err != nil || b.inUse() != 100 {
+		t.Fatalf("Expected the whole budget to be in use, got %d, %v", b.inUse(), err)
+	}
+	release()
+	if b.inUse() != 0 {
+		t.Fatalf("Expected no memory in use, got %d", b.inUse())
+	}
+
+	var unlimited *memoryBudget
+	if _, err := unlimited.reserve(1<<40, abort); err != nil || !unlimited.tryAcquire(1<<40) {
+		t.Fatal("Expected a nil budget to be unlimited")
+	}
+}
+
+// TestMemoryBudgetReaders ensures the pipelined reader and the range reader
+// stay within the budget, still work when it has no room for extra buffers
+// and return their extra buffers once they're closed.
+func TestMemoryBudgetReaders(t *testing.T) {
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i * 3)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// The budget has room for none, some or all of the extra buffers.
+	for _, room := range []int64{0, 100, 1000} {
+		b := newMemoryBudget(1000)
+		if !b.tryAcquire(1000 - room) {
+			t.Fatal("Failed to fill the budget")
+		}
+		used := b.inUse()
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := newRangeReader(server.Client(), resp, uint64(len(content)), 50, 4, b)
+		pr := newPipelinedReader(rr, 50, 4, b)
+		got, err := io.ReadAll(pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("%d: unexpected content", room)
+		}
+		if b.inUse() > 1000 {
+			t.Fatalf("%d: exceeded the budget with %d bytes", room, b.inUse())
+		}
+		_ = pr.Close()
+		_ = rr.Close()
+		_ = resp.Body.Close()
+		// The pipelined reader's thread returns its buffers once it exits.
+		for i := 0; i < 100 && b.inUse() != used; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if b.inUse() != used {
+			t.Fatalf("%d: expected %d bytes in use, got %d", room, used, b.inUse())
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package clamav
+
+import (
+	"github.com/SkynetLabs/malware-scanner/metrics"
+)
+
+var (
+	// portalLatencyBuckets are the histogram buckets, in seconds, we use for
+	// the time it takes a portal to respond to a download request.
+	portalLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	// throughputBuckets are the histogram buckets, in bytes per second, we
+	// use for the throughput of scans.
+	throughputBuckets = []float64{1 << 16, 1 << 18, 1 << 20, 1 << 22, 1 << 24, 1 << 26, 1 << 28, 1 << 30}
+
+	// metricClamdConnections tracks the number of open clamd sockets. That's
+	// the number of in-flight go-clamd commands, which open a new connection
+	// each, plus the open sessions, including idle ones.
+	metricClamdConnections = metrics.NewGauge("clamav_clamd_open_connections", "Number of open connections to clamd.")
+	// metricClamdSessions counts the scans by whether they started a new
+	// clamd session or reused an idle one.
+	metricClamdSessions = metrics.NewCounterVec("clamav_clamd_sessions_total", "Number of scans by whether they used a new or a reused clamd session.", "session")
+	// metricBatchedScans counts the files scanned as part of a batch.
+	metricBatchedScans = metrics.NewCounter("clamav_batched_scans_total", "Number of files streamed to clamd in batches over a single session.")
+	// metricRangeDownloads counts the ranges of large files we downloaded in
+	// parallel.
+	metricRangeDownloads = metrics.NewCounter("clamav_range_downloads_total", "Number of ranges of large files downloaded in parallel.")
+	// metricMemoryUsed tracks the bytes of the memory budget held by scans.
+	metricMemoryUsed = metrics.NewGauge("clamav_memory_used_bytes", "Number of bytes of the memory budget held by scans.")
+	// metricMemoryWaits counts the scans which had to wait for memory
+	// before they could start.
+	metricMemoryWaits = metrics.NewCounter("clamav_memory_waits_total", "Number of scans which waited for the memory budget.")
+	// metricStalledDownloads counts the downloads we aborted because the
+	// portal stopped sending the content.
+	metricStalledDownloads = metrics.NewCounter("clamav_stalled_downloads_total", "Number of downloads aborted because the portal stopped sending.")
+	// metricCompressedDownloads counts the downloads the portal compressed,
+	// by encoding.
+	metricCompressedDownloads = metrics.NewCounterVec("clamav_portal_compressed_downloads_total", "Number of downloads compressed by the portal, by encoding.", "encoding")
+	// metricPortalConnections tracks the number of open connections to the
+	// portal, including idle keep-alive connections.
+	metricPortalConnections = metrics.NewGauge("clamav_portal_open_connections", "Number of open connections to the portal.")
+
+	// metricPortalRequests counts the download requests by portal and
+	// result, which is either "success" or "failure".
+	metricPortalRequests = metrics.NewCounterVec("clamav_portal_requests_total", "Number of download requests by portal and result.", "portal", "result")
+	// metricPortalLatency tracks the time it takes each portal to respond with
+	// the headers of a download.
+	metricPortalLatency = metrics.NewHistogramVec("clamav_portal_latency_seconds", "Time to the response headers of a download by portal.", portalLatencyBuckets, "portal")
+	// metricScanThroughput tracks the rate at which content is downloaded
+	// and streamed to clamd, per scan.
+	metricScanThroughput = metrics.NewHistogram("clamav_scan_throughput_bytes_per_second", "Throughput of downloading and scanning content, per scan.", throughputBuckets)
+	// metricPortalRateLimits counts the 429 Too Many Requests responses of
+	// each portal, which made us back off from it.
+	metricPortalRateLimits = metrics.NewCounterVec("clamav_portal_rate_limits_total", "Number of times a portal rate limited us, by portal.", "portal")
+	// metricPortalBytes counts the bytes downloaded from each portal.
+	metricPortalBytes = metrics.NewCounterVec("clamav_portal_downloaded_bytes_total", "Number of bytes downloaded by portal.", "portal")
+)
@@ -0,0 +1,209 @@
+package clamav
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// PipelineBufferSize is the size of each of the buffers we download
+	// content into while it's streamed to clamd. Zero disables pipelining,
+	// so every read clamd makes goes straight to the portal's response.
+	// Set according to the MALWARE_SCANNER_PIPELINE_BUFFER_SIZE env var.
+	PipelineBufferSize = 256 << 10
+	// PipelineBuffers is the number of buffers each scan can fill ahead of
+	// clamd. Every buffer after the first is only used while the memory
+	// budget has room for it.
+	// Set according to the MALWARE_SCANNER_PIPELINE_BUFFERS env var.
+	PipelineBuffers = 4
+)
+
+// pipelinedReader reads ahead of its consumer in a background thread, so
+// downloading the content overlaps with streaming it to clamd. go-clamd reads
+// the content in small chunks and sends each of them before reading the next
+// one, which would otherwise leave the download idle while clamd is busy.
+type pipelinedReader struct {
+	// cur is the unread part of buf.
+	cur []byte
+	buf []byte
+
+	// staticChunks holds the filled buffers, in order. It's closed once the
+	// source is exhausted.
+	staticChunks chan []byte
+	// staticFree holds the buffers which can be filled. Buffers are
+	// allocated when they're first needed, so small files don't pay for
+	// all of them.
+	staticFree chan []byte
+	staticDone chan struct{}
+	staticSize int
+	staticMax  int
+	staticSrc  io.Reader
+	// err is the error which ended reading from the source. It's set before
+	// staticChunks is closed.
+	err       error
+	closeOnce sync.Once
+
+	// allocated is the number of buffers we allocated, extra is the number
+	// of those we took from the budget. They're only used by the background
+	// thread.
+	allocated int
+	extra     int
+	// refs is released by both the background thread and Close. The extra
+	// buffers are returned to the budget once both are done with them.
+	refs         int32
+	staticBudget *memoryBudget
+}
+
+// newPipelinedReader returns a reader which reads ahead of its consumer into
+// up to n buffers of the given size. The caller accounts for the first buffer,
+// the others are taken from the budget while it has room for them. It must be
+// closed to stop the background thread. Closing it doesn't close the source,
+// which should be closed after the reader to unblock a pending read.
+func newPipelinedReader(src io.Reader, size, n int, budget *memoryBudget) *pipelinedReader {
+	pr := &pipelinedReader{
+		staticChunks: make(chan []byte, n),
+		staticFree:   make(chan []byte, n),
+		staticDone:   make(chan struct{}),
+		staticSize:   size,
+		staticMax:    n,
+		staticSrc:    src,
+		allocated:    1,
+		refs:         2,
+		staticBudget: budget,
+	}
+	pr.staticFree <- nil
+	go pr.threadedFill()
+	return pr
+}
+
+// Read implements io.Reader.
+func (pr *pipelinedReader) Read(p []byte) (int, error) {
+	for len(pr.cur) == 0 {
+		if err := pr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, pr.cur)
+	pr.cur = pr.cur[n:]
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo. It writes the filled buffers as they are,
+// instead of copying them to the caller's buffer first.
+func (pr *pipelinedReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		if len(pr.cur) > 0 {
+			n, err := w.Write(pr.cur)
+			written += int64(n)
+			pr.cur = pr.cur[n:]
+			if err != nil {
+				return written, err
+			}
+		}
+		err := pr.next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// next returns the current buffer to the background thread and waits for the
+// next filled one. It returns the error which ended reading from the source
+// once there are no more.
+func (pr *pipelinedReader) next() error {
+	if pr.buf != nil {
+		pr.staticFree <- pr.buf[:cap(pr.buf)]
+		pr.buf = nil
+	}
+	b, ok := <-pr.staticChunks
+	if !ok {
+		return pr.err
+	}
+	pr.buf, pr.cur = b, b
+	return nil
+}
+
+// Close stops the background thread.
+func (pr *pipelinedReader) Close() error {
+	pr.closeOnce.Do(func() {
+		close(pr.staticDone)
+		pr.releaseExtra()
+	})
+	return nil
+}
+
+// releaseExtra returns the extra buffers to the budget once both the
+// background thread and Close called it.
+func (pr *pipelinedReader) releaseExtra() {
+	if atomic.AddInt32(&pr.refs, -1) == 0 {
+		pr.staticBudget.release(int64(pr.extra * pr.staticSize))
+	}
+}
+
+// threadedFill fills free buffers from the source and hands them over to the
+// consumer until the source is exhausted or the reader is closed.
+func (pr *pipelinedReader) threadedFill() {
+	// Consumers keep reading until they get an error, so make sure they get
+	// one once we stop, even if we stop because the reader was closed.
+	err := io.ErrClosedPipe
+	defer func() {
+		pr.err = err
+		close(pr.staticChunks)
+		pr.releaseExtra()
+	}()
+	for {
+		var buf []byte
+		select {
+		case <-pr.staticDone:
+			return
+		case buf = <-pr.staticFree:
+		default:
+			// All our buffers are filled. Take another one if the budget
+			// has room for it, otherwise wait for the consumer to return
+			// one.
+			if pr.allocated < pr.staticMax && pr.staticBudget.tryAcquire(int64(pr.staticSize)) {
+				pr.allocated++
+				pr.extra++
+				break
+			}
+			select {
+			case <-pr.staticDone:
+				return
+			case buf = <-pr.staticFree:
+			}
+		}
+		if buf == nil {
+			buf = make([]byte, pr.staticSize)
+		}
+		n, errRead := fill(pr.staticSrc, buf)
+		if n > 0 {
+			select {
+			case <-pr.staticDone:
+				return
+			case pr.staticChunks <- buf[:n]:
+			}
+		}
+		if errRead != nil {
+			err = errRead
+			return
+		}
+	}
+}
+
+// fill reads from the source until the buffer is full or reading fails. Unlike
+// io.ReadFull, it returns the source's error as is, so a source which fails
+// with io.ErrUnexpectedEOF, like a truncated gzip stream, isn't mistaken for
+// one which ended early.
+func fill(src io.Reader, buf []byte) (n int, err error) {
+	for n < len(buf) && err == nil {
+		var read int
+		read, err = src.Read(buf[n:])
+		n += read
+	}
+	return n, err
+}
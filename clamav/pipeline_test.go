@@ -0,0 +1,78 @@
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestPipelinedReader ensures the pipelined reader returns the content of its
+// source, followed by the source's error.
+func TestPipelinedReader(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+	for _, size := range []int{1, 7, 1024, 20000} {
+		pr := newPipelinedReader(iotest.HalfReader(bytes.NewReader(data)), size, 3, nil)
+		b, err := io.ReadAll(iotest.OneByteReader(pr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("Unexpected content for buffer size %d", size)
+		}
+		_ = pr.Close()
+
+		// Copying from the reader writes the buffers as they are.
+		pr = newPipelinedReader(iotest.HalfReader(bytes.NewReader(data)), size, 3, nil)
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, pr)
+		if err != nil || n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("Unexpected copy for buffer size %d: %d bytes, %v", size, n, err)
+		}
+		_ = pr.Close()
+	}
+
+	errSrc := errors.New("source failed")
+	pr := newPipelinedReader(iotest.TimeoutReader(bytes.NewReader(data)), 100, 2, nil)
+	defer func() { _ = pr.Close() }()
+	b, err := io.ReadAll(pr)
+	if err != iotest.ErrTimeout || len(b) != 100 {
+		t.Fatalf("Expected a timeout after 100 bytes, got %d bytes and %v", len(b), err)
+	}
+	pr = newPipelinedReader(iotest.TimeoutReader(bytes.NewReader(data)), 100, 2, nil)
+	defer func() { _ = pr.Close() }()
+	if n, err := io.Copy(io.Discard, pr); err != iotest.ErrTimeout || n != 100 {
+		t.Fatalf("Expected a timeout after copying 100 bytes, got %d bytes and %v", n, err)
+	}
+	pr = newPipelinedReader(iotest.ErrReader(errSrc), 100, 2, nil)
+	defer func() { _ = pr.Close() }()
+	if _, err = pr.Read(make([]byte, 10)); err != errSrc {
+		t.Fatalf("Expected %v, got %v", errSrc, err)
+	}
+}
+
+// TestPipelinedReaderClose ensures closing the pipelined reader stops the
+// background thread and fails reads, even if the consumer stopped reading.
+func TestPipelinedReaderClose(t *testing.T) {
+	src, w := io.Pipe()
+	pr := newPipelinedReader(src, 10, 2, nil)
+	go func() { _, _ = w.Write(make([]byte, 100)) }()
+	// Wait for the buffers to fill up, so the background thread is blocked
+	// sending the next one.
+	time.Sleep(50 * time.Millisecond)
+	_ = pr.Close()
+	var err error
+	for err == nil {
+		_, err = pr.Read(make([]byte, 10))
+	}
+	if err != io.ErrClosedPipe {
+		t.Fatalf("Expected %v, got %v", io.ErrClosedPipe, err)
+	}
+	_ = w.Close()
+}
@@ -0,0 +1,97 @@
+package clamav
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+type (
+	// PortalStats describes how well a portal has been serving our downloads.
+	// Latency is the average time to the response headers, in seconds.
+	// RateLimitedUntil is set while we back off from the portal because it
+	// rate limited us.
+	PortalStats struct {
+		Portal           string     `json:"portal"`
+		Requests         uint64     `json:"requests"`
+		Failures         uint64     `json:"failures"`
+		SuccessRate      float64    `json:"successRate"`
+		AvgLatency       float64    `json:"avgLatency"`
+		BytesDownloaded  uint64     `json:"bytesDownloaded"`
+		RateLimitedUntil *time.Time `json:"rateLimitedUntil,omitempty"`
+	}
+
+	// portalStats keeps the download statistics of each portal since the
+	// service started, together with the time until which we back off from
+	// each portal which rate limited us.
+	portalStats struct {
+		portals          []string
+		stats            map[string]*PortalStats
+		totalLatency     map[string]time.Duration
+		rateLimitedUntil map[string]time.Time
+		mu               sync.Mutex
+	}
+)
+
+// newPortalStats returns empty statistics for the given portals.
+func newPortalStats(portals []string) *portalStats {
+	ps := &portalStats{
+		portals:          portals,
+		stats:            make(map[string]*PortalStats),
+		totalLatency:     make(map[string]time.Duration),
+		rateLimitedUntil: make(map[string]time.Time),
+	}
+	for _, p := range portals {
+		ps.stats[p] = &PortalStats{Portal: p}
+	}
+	return ps
+}
+
+// recordRequest records the outcome of a single download request.
+func (ps *portalStats) recordRequest(portal string, latency time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metricPortalRequests.With(portal, result).Inc()
+	metricPortalLatency.With(portal).Observe(latency.Seconds())
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	s := ps.stats[portal]
+	s.Requests++
+	if err != nil {
+		s.Failures++
+	}
+	ps.totalLatency[portal] += latency
+}
+
+// recordBytes records the number of bytes downloaded from the given portal.
+func (ps *portalStats) recordBytes(portal string, n uint64) {
+	metricPortalBytes.With(portal).Add(float64(n))
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.stats[portal].BytesDownloaded += n
+}
+
+// summary returns the statistics of all portals in their failover order.
+func (ps *portalStats) summary() []PortalStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	summary := make([]PortalStats, 0, len(ps.portals))
+	now := database.Clock.Now()
+	for _, p := range ps.portals {
+		s := *ps.stats[p]
+		if until := ps.rateLimitedUntil[p]; now.Before(until) {
+			s.RateLimitedUntil = &until
+		}
+		if s.Requests > 0 {
+			s.SuccessRate = float64(s.Requests-s.Failures) / float64(s.Requests)
+			s.AvgLatency = ps.totalLatency[p].Seconds() / float64(s.Requests)
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
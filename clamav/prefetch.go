@@ -0,0 +1,58 @@
+package clamav
+
+import (
+	"net/http"
+	"time"
+)
+
+// prefetchMaxAge is how long a prefetched download can wait for its scan.
+// The portal stops sending while we don't read the body and it might give up
+// on idle downloads, so older ones are started again.
+const prefetchMaxAge = 30 * time.Second
+
+// Download is the download of a skylink's content, which started before its
+// scan, so the scan doesn't wait for the portal to respond.
+type Download struct {
+	skylink string
+	resp    *http.Response
+	portal  string
+	err     error
+	started time.Time
+}
+
+// Prefetch starts downloading the content of the given skylink and returns
+// once the portal responded with the headers, which includes resolving v2
+// skylinks. The download must be either scanned with ScanDownload or closed.
+func (c *ClamAV) Prefetch(skylink string) *Download {
+	resp, portal, err := c.download(skylink)
+	return &Download{
+		skylink: skylink,
+		resp:    resp,
+		portal:  portal,
+		err:     err,
+		started: time.Now(),
+	}
+}
+
+// Close stops the download.
+func (d *Download) Close() error {
+	if d.resp == nil {
+		return nil
+	}
+	err := d.resp.Body.Close()
+	d.resp = nil
+	return err
+}
+
+// ScanDownload streams the content of the given prefetched download to ClamAV,
+// like ScanSkylink does. Downloads which failed or waited too long are started
+// again.
+func (c *ClamAV) ScanDownload(d *Download, abort chan bool) SkylinkScan {
+	if d.err != nil || d.resp == nil || time.Since(d.started) > prefetchMaxAge {
+		_ = d.Close()
+		return c.ScanSkylink(d.skylink, abort)
+	}
+	resp := d.resp
+	d.resp = nil
+	return c.scanResponse(d.skylink, resp, d.portal, abort)
+}
@@ -0,0 +1,67 @@
+package clamav
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+)
+
+// TestScanDownload ensures prefetched downloads are scanned without another
+// request to the portal, unless they failed or waited too long.
+func TestScanDownload(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+	requests := func() uint64 { return c.PortalStats()[0].Requests }
+
+	d := c.Prefetch("eicar")
+	if requests() != 1 {
+		t.Fatalf("Expected the prefetch to request the content, got %d requests", requests())
+	}
+	r := c.ScanDownload(d, abort)
+	if r.Err != nil || !r.Infected || r.Description != test.EICARSignature || r.Size != uint64(len(test.EICAR)) || r.ScannedSize != r.Size {
+		t.Fatalf("Unexpected scan %+v", r)
+	}
+	if requests() != 1 {
+		t.Fatalf("Expected no other request, got %d requests", requests())
+	}
+
+	// Stale downloads are started again.
+	d = c.Prefetch("clean")
+	d.started = time.Now().Add(-2 * prefetchMaxAge)
+	if r = c.ScanDownload(d, abort); r.Err != nil || r.Infected {
+		t.Fatalf("Unexpected scan %+v", r)
+	}
+	if requests() != 3 {
+		t.Fatalf("Expected the download to start again, got %d requests", requests())
+	}
+
+	// Failed downloads are tried again and closing a download is safe.
+	d = c.Prefetch("missing")
+	if r = c.ScanDownload(d, abort); r.Err == nil {
+		t.Fatal("Expected the scan to fail")
+	}
+	if requests() != 5 {
+		t.Fatalf("Expected the download to be tried again, got %d requests", requests())
+	}
+	d = c.Prefetch("clean")
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
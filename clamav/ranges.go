@@ -0,0 +1,239 @@
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ParallelDownloadThreshold is the size in bytes from which we download
+	// files in ranges over several connections, for portals which cap the
+	// throughput of each connection. Zero disables parallel downloads.
+	// Set according to the MALWARE_SCANNER_PARALLEL_DOWNLOAD_THRESHOLD env var.
+	ParallelDownloadThreshold uint64 = 64 << 20
+	// ParallelDownloads is the maximum number of ranges we download at the
+	// same time. Together with the range size, it bounds the memory each
+	// download uses. Every range after the first is only downloaded while
+	// the memory budget has room for it.
+	// Set according to the MALWARE_SCANNER_PARALLEL_DOWNLOADS env var.
+	ParallelDownloads = 4
+	// ParallelDownloadRangeSize is the size in bytes of each of the ranges
+	// we download.
+	// Set according to the MALWARE_SCANNER_PARALLEL_DOWNLOAD_RANGE_SIZE env var.
+	ParallelDownloadRangeSize uint64 = 8 << 20
+)
+
+type (
+	// rangeReader downloads a file in consecutive ranges, several at a time,
+	// and returns them in order. The first range is read from the response
+	// which started the download. Every range is downloaded once and dropped
+	// once it's read, since the scan never goes back to earlier bytes.
+	rangeReader struct {
+		// cur is the range we're currently reading from.
+		cur io.Reader
+		// pending are the ranges being downloaded, in order.
+		pending []chan rangeResult
+		// next is the offset of the next range we haven't started
+		// downloading yet.
+		next uint64
+		err  error
+		// fetched is whether cur is a downloaded range, rather than the
+		// original response.
+		fetched bool
+		// slots is the number of ranges we can hold in memory, whether
+		// they're being downloaded, waiting to be read or being read. The
+		// caller accounts for the first one, the others are taken from the
+		// budget.
+		slots int
+		// closed stops us from starting new downloads once the reader is
+		// closed.
+		closed bool
+
+		staticBudget    *memoryBudget
+		staticClient    *http.Client
+		staticURL       string
+		staticSize      uint64
+		staticRangeSize uint64
+		staticParallel  int
+		staticCtx       context.Context
+		staticCancel    context.CancelFunc
+		staticWG        sync.WaitGroup
+		mu              sync.Mutex
+	}
+
+	// rangeResult is the downloaded content of a range.
+	rangeResult struct {
+		data []byte
+		err  error
+	}
+)
+
+// useRanges returns whether we should download the content of the given
+// response in parallel ranges.
+func useRanges(resp *http.Response, size uint64) bool {
+	return ParallelDownloadThreshold > 0 && ParallelDownloads > 1 && ParallelDownloadRangeSize > 0 &&
+		size >= ParallelDownloadThreshold && resp.Header.Get("Accept-Ranges") == "bytes" && resp.Request != nil
+}
+
+// newRangeReader returns a reader of the content of the given response, which
+// has the given size. Everything after the first range is downloaded in up to
+// the given number of parallel ranges. The caller accounts for the memory of
+// one range, the others are taken from the budget while it has room for them.
+// The response's body isn't read past the first range, so the caller should
+// close it once it's done with the reader.
+func newRangeReader(client *http.Client, resp *http.Response, size, rangeSize uint64, parallel int, budget *memoryBudget) *rangeReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	rr := &rangeReader{
+		cur:             io.LimitReader(resp.Body, int64(rangeSize)),
+		next:            rangeSize,
+		slots:           1,
+		staticBudget:    budget,
+		staticClient:    client,
+		staticURL:       resp.Request.URL.String(),
+		staticSize:      size,
+		staticRangeSize: rangeSize,
+		staticParallel:  parallel,
+		staticCtx:       ctx,
+		staticCancel:    cancel,
+	}
+	// Download as many of the following ranges as the budget lets us.
+	rr.fetchNext()
+	for rr.grow() {
+	}
+	return rr
+}
+
+// Read implements io.Reader.
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	for rr.err == nil {
+		n, err := rr.cur.Read(p)
+		if err == io.EOF {
+			err = nil
+		} else if err != nil {
+			rr.err = err
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		// The range we finished reading frees up its slot for the next
+		// download.
+		if rr.fetched {
+			rr.fetchNext()
+		}
+		rr.grow()
+		if len(rr.pending) == 0 {
+			rr.err = io.EOF
+			break
+		}
+		var res rangeResult
+		select {
+		case res = <-rr.pending[0]:
+		case <-rr.staticCtx.Done():
+			res.err = io.ErrClosedPipe
+		}
+		rr.pending = rr.pending[1:]
+		if res.err != nil {
+			rr.err = res.err
+			break
+		}
+		rr.cur = bytes.NewReader(res.data)
+		rr.fetched = true
+	}
+	return 0, rr.err
+}
+
+// Close stops the downloads in progress, waits for them to exit and returns
+// the extra slots to the budget.
+func (rr *rangeReader) Close() error {
+	rr.mu.Lock()
+	rr.closed = true
+	rr.mu.Unlock()
+	rr.staticCancel()
+	rr.staticWG.Wait()
+	rr.mu.Lock()
+	rr.staticBudget.release(int64(rr.slots-1) * int64(rr.staticRangeSize))
+	rr.slots = 1
+	rr.mu.Unlock()
+	return nil
+}
+
+// grow starts downloading another range in a new slot, if there are ranges
+// left and the budget has room for it. It returns whether it did.
+func (rr *rangeReader) grow() bool {
+	rr.mu.Lock()
+	ok := !rr.closed && rr.slots < rr.staticParallel && rr.next < rr.staticSize &&
+		rr.staticBudget.tryAcquire(int64(rr.staticRangeSize))
+	if ok {
+		rr.slots++
+	}
+	rr.mu.Unlock()
+	if ok {
+		rr.fetchNext()
+	}
+	return ok
+}
+
+// fetchNext starts downloading the next range, if there is one left.
+func (rr *rangeReader) fetchNext() {
+	if rr.next >= rr.staticSize {
+		return
+	}
+	from := rr.next
+	to := from + rr.staticRangeSize
+	if to > rr.staticSize {
+		to = rr.staticSize
+	}
+	rr.next = to
+	// The channel is buffered, so the download can exit even if nobody
+	// reads its result.
+	c := make(chan rangeResult, 1)
+	rr.pending = append(rr.pending, c)
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.closed {
+		c <- rangeResult{err: io.ErrClosedPipe}
+		return
+	}
+	rr.staticWG.Add(1)
+	go func() {
+		defer rr.staticWG.Done()
+		data, err := rr.fetch(from, to)
+		c <- rangeResult{data: data, err: err}
+	}()
+}
+
+// fetch downloads the bytes of the given range, excluding the end.
+func (rr *rangeReader) fetch(from, to uint64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(rr.staticCtx, http.MethodGet, rr.staticURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to-1))
+	resp, err := rr.staticClient.Do(req)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to download range")
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusOK {
+		return nil, errors.New("portal ignored the range request")
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errors.AddContext(checkPortalStatus(resp.StatusCode), "failed to download range")
+	}
+	data := make([]byte, to-from)
+	_, err = io.ReadFull(newIdleTimeoutReader(resp.Body), data)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to download range")
+	}
+	metricRangeDownloads.Inc()
+	return data, nil
+}
@@ -0,0 +1,77 @@
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRangeReader ensures we download large files in parallel ranges and
+// return their content in order.
+func TestRangeReader(t *testing.T) {
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	var ranges int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranges, 1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if !useRanges(resp, ParallelDownloadThreshold) {
+		t.Fatal("Expected to download the file in ranges")
+	}
+	rr := newRangeReader(server.Client(), resp, uint64(len(content)), 64, 3, nil)
+	b, err := io.ReadAll(rr)
+	_ = rr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatal("Unexpected content")
+	}
+	// The first range comes from the original response.
+	if n := atomic.LoadInt32(&ranges); n != 15 {
+		t.Fatalf("Expected 15 range requests, got %d", n)
+	}
+}
+
+// TestRangeReaderIgnoredRanges ensures we fail when the portal doesn't respond
+// with the requested range.
+func TestRangeReaderIgnoredRanges(t *testing.T) {
+	content := strings.Repeat("a", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		_, _ = w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	rr := newRangeReader(server.Client(), resp, uint64(len(content)), 100, 2, nil)
+	defer func() { _ = rr.Close() }()
+	b, err := io.ReadAll(rr)
+	if err == nil || !strings.Contains(err.Error(), "ignored the range request") {
+		t.Fatalf("Expected an error, got %v", err)
+	}
+	if len(b) != 100 {
+		t.Fatalf("Expected the first range, got %d bytes", len(b))
+	}
+}
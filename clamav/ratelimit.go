@@ -0,0 +1,90 @@
+package clamav
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+var (
+	// RateLimitBackoff is how long we stop downloading from a portal which
+	// responded with 429 Too Many Requests without telling us how long to
+	// wait in a Retry-After header.
+	// Set according to the MALWARE_SCANNER_RATE_LIMIT_BACKOFF env var.
+	RateLimitBackoff = 10 * time.Second
+	// RateLimitMaxBackoff caps the time a portal's Retry-After header can
+	// make us wait, so a misconfigured portal doesn't stop the scanner for
+	// good.
+	// Set according to the MALWARE_SCANNER_RATE_LIMIT_MAX_BACKOFF env var.
+	RateLimitMaxBackoff = 10 * time.Minute
+)
+
+// retryAfter returns how long the portal asked us to wait in the Retry-After
+// header of the given 429 response, either in seconds or until a date. It
+// falls back to RateLimitBackoff and is capped at RateLimitMaxBackoff.
+func retryAfter(resp *http.Response) time.Duration {
+	d := RateLimitBackoff
+	h := resp.Header.Get("Retry-After")
+	if secs, err := strconv.ParseInt(h, 10, 64); err == nil && secs >= 0 {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = t.Sub(database.Clock.Now())
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > RateLimitMaxBackoff {
+		d = RateLimitMaxBackoff
+	}
+	return d
+}
+
+// rateLimit stops downloads from the given portal for the given duration,
+// unless it's rate limited for longer already. The backoff applies to every
+// scan, so all workers back off together instead of each of them running
+// into the limit. Backoffs run on the DB's clock, like the scanning workers
+// which wait for them.
+func (ps *portalStats) rateLimit(portal string, d time.Duration) {
+	metricPortalRateLimits.With(portal).Inc()
+	until := database.Clock.Now().Add(d).UTC()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if until.After(ps.rateLimitedUntil[portal]) {
+		ps.rateLimitedUntil[portal] = until
+	}
+}
+
+// rateLimited returns whether we're backing off from the given portal.
+func (ps *portalStats) rateLimited(portal string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return database.Clock.Now().Before(ps.rateLimitedUntil[portal])
+}
+
+// allRateLimitedUntil returns when the first portal accepts downloads again,
+// if we're backing off from all of them. It's zero otherwise.
+func (ps *portalStats) allRateLimitedUntil() time.Time {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := database.Clock.Now()
+	var first time.Time
+	for _, p := range ps.portals {
+		until := ps.rateLimitedUntil[p]
+		if !now.Before(until) {
+			return time.Time{}
+		}
+		if first.IsZero() || until.Before(first) {
+			first = until
+		}
+	}
+	return first
+}
+
+// RateLimitedUntil returns when downloads can resume, if every portal rate
+// limited us. It's zero while any portal accepts downloads. Scanners should
+// hold off on new scans until then, since they'd fail right away.
+func (c *ClamAV) RateLimitedUntil() time.Time {
+	return c.staticPortalStats.allRateLimitedUntil()
+}
@@ -0,0 +1,106 @@
+package clamav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestRetryAfter ensures we wait as long as the portal asks us to, within
+// bounds.
+func TestRetryAfter(t *testing.T) {
+	defer func(d time.Duration) { RateLimitMaxBackoff = d }(RateLimitMaxBackoff)
+	RateLimitMaxBackoff = time.Minute
+	tests := []struct {
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"", RateLimitBackoff, RateLimitBackoff},
+		{"gremlins", RateLimitBackoff, RateLimitBackoff},
+		{"5", 5 * time.Second, 5 * time.Second},
+		{"3600", time.Minute, time.Minute},
+		{time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), 28 * time.Second, 30 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: make(http.Header)}
+		resp.Header.Set("Retry-After", tt.header)
+		if d := retryAfter(resp); d < tt.min || d > tt.max {
+			t.Errorf("Expected a backoff between %s and %s for '%s', got %s", tt.min, tt.max, tt.header, d)
+		}
+	}
+}
+
+// TestRateLimit ensures a portal which rate limits us isn't asked for any
+// downloads until its backoff ends, by any scan, and that we fail over to the
+// other portals meanwhile.
+func TestRateLimit(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	var limited int32
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&limited, 1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer busy.Close()
+	portal := newMockPortal()
+	defer portal.Close()
+	ip, port := mc.Addr()
+	abort := make(chan bool)
+	defer close(abort)
+
+	// The busy portal is skipped once it rate limited us.
+	c, err := New(ip, port, busy.URL, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	for i := 0; i < 3; i++ {
+		if r := c.ScanSkylink("eicar", abort); r.Err != nil || !r.Infected {
+			t.Fatalf("Expected an infected verdict from the other portal, got %+v", r)
+		}
+	}
+	if n := atomic.LoadInt32(&limited); n != 1 {
+		t.Fatalf("Expected a single request to the busy portal, got %d", n)
+	}
+	if until := c.RateLimitedUntil(); !until.IsZero() {
+		t.Fatalf("Expected downloads to go on while a portal accepts them, got %v", until)
+	}
+	if stats := c.PortalStats(); stats[0].RateLimitedUntil == nil || stats[1].RateLimitedUntil != nil {
+		t.Fatalf("Expected only the busy portal to be rate limited, got %+v", stats)
+	}
+
+	// Without another portal, scans fail without a request until the
+	// backoff ends.
+	c2, err := New(ip, port, busy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c2.Close() }()
+	for i := 0; i < 2; i++ {
+		if r := c2.ScanSkylink("eicar", abort); !errors.Contains(r.Err, ErrPortalRateLimited) {
+			t.Fatalf("Expected the scan to be rate limited, got %v", r.Err)
+		}
+	}
+	if n := atomic.LoadInt32(&limited); n != 2 {
+		t.Fatalf("Expected another request to the busy portal, got %d", n)
+	}
+	until := c2.RateLimitedUntil()
+	if d := time.Until(until); d <= 0 || d > time.Second {
+		t.Fatalf("Expected to back off for a second, got %s", d)
+	}
+	time.Sleep(time.Until(until))
+	if r := c2.ScanSkylink("eicar", abort); !errors.Contains(r.Err, ErrPortalRateLimited) || atomic.LoadInt32(&limited) != 3 {
+		t.Fatalf("Expected another request once the backoff ended, got %v", r.Err)
+	}
+}
@@ -1,9 +1,12 @@
 package clamav
 
-import "io"
+import (
+	"io"
+	"sync/atomic"
+)
 
 // ReaderCounter is a wrapper of io.Reader that counts how many bytes are read
-// from it.
+// from it. The count can be safely read while another thread reads from it.
 type ReaderCounter struct {
 	readBytes uint64
 	r         io.Reader
@@ -24,11 +27,35 @@ func NewReaderCounter(r io.Reader) *ReaderCounter {
 // returns what is available instead of waiting for more.
 func (rc *ReaderCounter) Read(p []byte) (n int, err error) {
 	n, err = rc.r.Read(p)
-	rc.readBytes += uint64(n)
+	atomic.AddUint64(&rc.readBytes, uint64(n))
 	return
 }
 
+// WriteTo implements io.WriterTo. Copying from the counter uses the source's
+// WriteTo or, failing that, the destination's ReadFrom, instead of an
+// intermediate buffer, and the count still grows as the content is copied.
+func (rc *ReaderCounter) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := rc.r.(io.WriterTo); ok {
+		return wt.WriteTo(&countingWriter{w: w, n: &rc.readBytes})
+	}
+	// Hide our WriteTo from io.Copy, which would call it again.
+	return io.Copy(w, struct{ io.Reader }{rc})
+}
+
 // ReadBytes returns the number of bytes read from the reader so far.
 func (rc *ReaderCounter) ReadBytes() uint64 {
-	return rc.readBytes
+	return atomic.LoadUint64(&rc.readBytes)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+// Write implements io.Writer.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddUint64(cw.n, uint64(n))
+	return n, err
 }
@@ -0,0 +1,55 @@
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// BenchmarkReaderCounter measures the overhead of counting the bytes of a
+// scan, reading in clamd-sized chunks and copying to a writer.
+func BenchmarkReaderCounter(b *testing.B) {
+	data := make([]byte, 1<<20)
+	b.Run("Read", func(b *testing.B) {
+		buf := make([]byte, ClamdChunkSize)
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rc := NewReaderCounter(bytes.NewReader(data))
+			for {
+				if _, err := rc.Read(buf); err != nil {
+					break
+				}
+			}
+		}
+	})
+	b.Run("Copy", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rc := NewReaderCounter(bytes.NewReader(data))
+			_, _ = io.Copy(io.Discard, rc)
+		}
+	})
+}
+
+// TestReaderCounterWriteTo ensures copying from the counter counts the bytes
+// whether or not the source implements io.WriterTo.
+func TestReaderCounterWriteTo(t *testing.T) {
+	data := bytes.Repeat([]byte("content"), 10000)
+	sources := map[string]io.Reader{
+		"WriterTo": bytes.NewReader(data),
+		"Reader":   struct{ io.Reader }{bytes.NewReader(data)},
+	}
+	for name, src := range sources {
+		rc := NewReaderCounter(src)
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, rc)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if n != int64(len(data)) || rc.ReadBytes() != uint64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("%s: copied %d bytes, counted %d bytes, expected %d", name, n, rc.ReadBytes(), len(data))
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// clamdDialTimeout is how long we wait for a connection to clamd.
+	clamdDialTimeout = 10 * time.Second
+	// sessionCheckTimeout is how long we wait for clamd to close an idle
+	// session when checking whether it's still open. The check reads from
+	// the connection and a deadline which already passed would fail the
+	// read before it even looks at the connection.
+	sessionCheckTimeout = time.Millisecond
+	// sessionCheckAfter is how long a session must have been idle before we
+	// check whether it's still open. Sessions which were just used are open
+	// unless clamd went down, in which case the scan fails and is retried.
+	sessionCheckAfter = time.Second
+)
+
+var (
+	// ClamdChunkSize is the maximum size of the chunks we stream to clamd
+	// over sessions. Readers which implement io.WriterTo hand us their
+	// buffers as they are, up to this size, others are read in chunks of
+	// io.Copy's buffer size. The best size depends on whether clamd is
+	// local or across the network, see CalibrateChunkSize.
+	// Set according to the MALWARE_SCANNER_CLAMD_CHUNK_SIZE env var.
+	ClamdChunkSize = 256 << 10
+	// ClamdSessions is the maximum number of idle clamd sessions we keep
+	// open for reuse. Zero disables sessions, so every scan opens its own
+	// connection to clamd. It must be set before creating a ClamAV client.
+	// Set according to the MALWARE_SCANNER_CLAMD_SESSIONS env var.
+	ClamdSessions = 8
+	// ClamdSessionIdleTimeout is how long a session can stay idle before we
+	// close it instead of reusing it. It should be lower than clamd's
+	// IdleTimeout, after which clamd closes idle sessions on its end.
+	// Set according to the MALWARE_SCANNER_CLAMD_SESSION_IDLE_TIMEOUT env var.
+	ClamdSessionIdleTimeout = 20 * time.Second
+
+	// errSessionAborted is returned when a scan is aborted.
+	errSessionAborted = errors.New("scan aborted")
+)
+
+type (
+	// clamdSession is a connection to clamd in IDSESSION mode, which lets
+	// us run any number of commands over it, one after another.
+	clamdSession struct {
+		conn     net.Conn
+		r        *bufio.Reader
+		lastUsed time.Time
+		// lastID is the ID clamd gave the last command we sent. clamd
+		// numbers the commands of a session from 1.
+		lastID int
+		once   sync.Once
+	}
+
+	// streamVerdict is clamd's verdict on a stream.
+	streamVerdict struct {
+		infected    bool
+		description string
+	}
+
+	// sessionPool keeps idle clamd sessions open, so we don't pay for
+	// connection setup on every scan or exhaust ephemeral ports at high scan
+	// rates.
+	sessionPool struct {
+		idle   []*clamdSession
+		closed bool
+
+		staticAddr    string
+		staticMaxIdle int
+		mu            sync.Mutex
+	}
+)
+
+// newSessionPool returns a pool of sessions with clamd at the given address.
+func newSessionPool(addr string, maxIdle int) *sessionPool {
+	return &sessionPool{
+		staticAddr:    addr,
+		staticMaxIdle: maxIdle,
+	}
+}
+
+// Close ends all idle sessions.
+func (p *sessionPool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+	var err error
+	for _, s := range idle {
+		err = errors.Compose(err, s.end())
+	}
+	return err
+}
+
+// ScanStream streams the content of the reader to clamd over a pooled session
+// and returns its verdict. Closing the abort channel interrupts the scan.
+func (p *sessionPool) ScanStream(r io.Reader, abort chan bool) (infected bool, description string, err error) {
+	return p.scanStream(r, ClamdChunkSize, abort)
+}
+
+// scanStream is ScanStream with the given chunk size.
+func (p *sessionPool) scanStream(r io.Reader, chunkSize int, abort chan bool) (infected bool, description string, err error) {
+	s, err := p.get()
+	if err != nil {
+		return false, "", err
+	}
+	stop := s.watch(abort)
+	resp, err := s.instream(r, chunkSize)
+	if stop() {
+		return false, "", errSessionAborted
+	}
+	if err != nil {
+		_ = s.close()
+		return false, "", err
+	}
+	infected, description, err = parseStreamResponse(resp)
+	if err != nil {
+		// clamd ends the session when a command fails.
+		_ = s.close()
+		return false, "", err
+	}
+	p.put(s)
+	return infected, description, nil
+}
+
+// ScanBatch streams the given contents to clamd back to back over a single
+// session and returns clamd's verdicts in the same order. clamd can respond to
+// the commands of a session in any order, so we match the responses to the
+// contents by their request IDs. clamd ends the session when any of the
+// commands fails, so the batch fails as a whole.
+func (p *sessionPool) ScanBatch(contents [][]byte, abort chan bool) ([]streamVerdict, error) {
+	s, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	stop := s.watch(abort)
+	verdicts, err := s.instreamBatch(contents)
+	if stop() {
+		return nil, errSessionAborted
+	}
+	if err != nil {
+		_ = s.close()
+		return nil, err
+	}
+	p.put(s)
+	return verdicts, nil
+}
+
+// get returns an idle session, or a new one if there are none which are still
+// usable.
+func (p *sessionPool) get() (*clamdSession, error) {
+	for {
+		// Take the most recently used session, it's the most likely one to
+		// still be open.
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		s := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		idle := time.Since(s.lastUsed)
+		if idle < ClamdSessionIdleTimeout && (idle < sessionCheckAfter || s.alive()) {
+			metricClamdSessions.With("reused").Inc()
+			return s, nil
+		}
+		_ = s.close()
+	}
+
+	conn, err := net.DialTimeout("tcp", p.staticAddr, clamdDialTimeout)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to connect to clamd")
+	}
+	metricClamdConnections.Inc()
+	s := &clamdSession{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	if err = s.command("IDSESSION"); err != nil {
+		_ = s.close()
+		return nil, errors.AddContext(err, "failed to start a clamd session")
+	}
+	metricClamdSessions.With("new").Inc()
+	return s, nil
+}
+
+// put returns the session to the pool, or ends it if the pool is full.
+func (p *sessionPool) put(s *clamdSession) {
+	s.lastUsed = time.Now()
+	p.mu.Lock()
+	if !p.closed && len(p.idle) < p.staticMaxIdle {
+		p.idle = append(p.idle, s)
+		s = nil
+	}
+	p.mu.Unlock()
+	if s != nil {
+		_ = s.end()
+	}
+}
+
+// alive returns whether clamd still keeps the idle session open. There's never
+// anything to read from an idle session, so a read which doesn't time out
+// means clamd closed it.
+func (s *clamdSession) alive() bool {
+	if err := s.conn.SetReadDeadline(time.Now().Add(sessionCheckTimeout)); err != nil {
+		return false
+	}
+	_, err := s.r.Peek(1)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		return false
+	}
+	return s.conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// watch closes the session if the abort channel is closed before the returned
+// function is called. Closing the connection unblocks any pending read or
+// write. The returned function waits for the watching thread to exit, so it
+// can't close the session once it's back in the pool, and returns whether the
+// session was aborted.
+func (s *clamdSession) watch(abort chan bool) func() bool {
+	done := make(chan struct{})
+	watched := make(chan bool)
+	go func() {
+		select {
+		case <-abort:
+			_ = s.close()
+			watched <- true
+		case <-done:
+			watched <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-watched
+	}
+}
+
+// command sends the given command to clamd, in the null-terminated format
+// which sessions require.
+func (s *clamdSession) command(cmd string) error {
+	_, err := s.conn.Write([]byte("z" + cmd + "\x00"))
+	if err == nil && cmd != "IDSESSION" && cmd != "END" {
+		s.lastID++
+	}
+	return err
+}
+
+// instream streams the content of the reader to clamd and returns clamd's
+// response, without the request ID.
+func (s *clamdSession) instream(r io.Reader, chunkSize int) (string, error) {
+	if err := s.stream(r, chunkSize); err != nil {
+		return "", err
+	}
+	id, resp, err := s.response()
+	if err != nil {
+		return "", err
+	}
+	if id != s.lastID {
+		return "", errors.New(fmt.Sprintf("unexpected clamd response ID %d, expected %d", id, s.lastID))
+	}
+	return resp, nil
+}
+
+// instreamBatch streams the given contents to clamd and returns its verdicts
+// in the same order.
+func (s *clamdSession) instreamBatch(contents [][]byte) ([]streamVerdict, error) {
+	// The IDs of our commands are consecutive.
+	firstID := s.lastID + 1
+	for _, c := range contents {
+		if err := s.stream(bytes.NewReader(c), ClamdChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	verdicts := make([]streamVerdict, len(contents))
+	received := make([]bool, len(contents))
+	for range contents {
+		id, resp, err := s.response()
+		if err != nil {
+			return nil, err
+		}
+		i := id - firstID
+		if i < 0 || i >= len(contents) || received[i] {
+			return nil, errors.New(fmt.Sprintf("unexpected clamd response ID %d", id))
+		}
+		received[i] = true
+		infected, description, err := parseStreamResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		verdicts[i] = streamVerdict{infected: infected, description: description}
+	}
+	return verdicts, nil
+}
+
+// stream sends the INSTREAM command followed by the content of the reader, in
+// chunks of up to the given size.
+func (s *clamdSession) stream(r io.Reader, chunkSize int) error {
+	if err := s.command("INSTREAM"); err != nil {
+		return errors.AddContext(err, "failed to send the INSTREAM command")
+	}
+	// Failing to read the content ends the stream early, clamd scans what
+	// it got.
+	cw := &chunkWriter{w: s.conn, size: chunkSize}
+	_, _ = io.Copy(cw, r)
+	if cw.err != nil {
+		return errors.AddContext(cw.err, "failed to stream content to clamd")
+	}
+	// A zero length ends the stream.
+	if _, err := s.conn.Write(make([]byte, 4)); err != nil {
+		return errors.AddContext(err, "failed to end the stream")
+	}
+	return nil
+}
+
+// chunkWriter frames what's written to it as INSTREAM chunks, each prefixed
+// with its length. It records the first error of the underlying writer, so it
+// can be told apart from the errors of the reader copied to it.
+type chunkWriter struct {
+	w    io.Writer
+	size int
+	err  error
+	hdr  [4]byte
+}
+
+// Write implements io.Writer.
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 && cw.err == nil {
+		chunk := p
+		if len(chunk) > cw.size {
+			chunk = chunk[:cw.size]
+		}
+		binary.BigEndian.PutUint32(cw.hdr[:], uint32(len(chunk)))
+		// Send the header and the chunk together, without copying them
+		// into a single buffer.
+		bufs := net.Buffers{cw.hdr[:], chunk}
+		if _, err := bufs.WriteTo(cw.w); err != nil {
+			cw.err = err
+			break
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, cw.err
+}
+
+// response reads clamd's next response and returns the ID of the command it
+// responds to, along with the response itself. Responses within a session are
+// prefixed with the ID, e.g. "3: stream: OK".
+func (s *clamdSession) response() (int, string, error) {
+	resp, err := s.r.ReadString(0)
+	if err != nil {
+		return 0, "", errors.AddContext(err, "failed to read clamd's response")
+	}
+	resp = strings.TrimSuffix(resp, "\x00")
+	parts := strings.SplitN(resp, ": ", 2)
+	if len(parts) != 2 {
+		return 0, "", errors.New("unexpected clamd response: " + resp)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", errors.New("unexpected clamd response: " + resp)
+	}
+	return id, parts[1], nil
+}
+
+// end ends the session gracefully and closes the connection.
+func (s *clamdSession) end() error {
+	_ = s.command("END")
+	return s.close()
+}
+
+// close closes the session's connection.
+func (s *clamdSession) close() error {
+	var err error
+	s.once.Do(func() {
+		metricClamdConnections.Dec()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// parseStreamResponse parses clamd's response to INSTREAM, e.g.
+// "stream: OK" or "stream: Win.Test.EICAR_HDB-1 FOUND".
+func parseStreamResponse(resp string) (infected bool, description string, err error) {
+	resp = strings.TrimSpace(resp)
+	switch {
+	case resp == "stream: OK":
+		return false, "", nil
+	case strings.HasPrefix(resp, "stream: ") && strings.HasSuffix(resp, " FOUND"):
+		return true, strings.TrimSuffix(strings.TrimPrefix(resp, "stream: "), " FOUND"), nil
+	}
+	return false, "", errors.New("unexpected clamd response: " + resp)
+}
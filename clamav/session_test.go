@@ -0,0 +1,128 @@
+package clamav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+)
+
+// TestSessionPool ensures scans reuse idle clamd sessions, and that sessions
+// clamd closed or which failed aren't reused.
+func TestSessionPool(t *testing.T) {
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	ip, port := mc.Addr()
+	p := newSessionPool(ip+":"+port, 1)
+	defer func() { _ = p.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	for i := 0; i < 5; i++ {
+		inf, desc, err := p.ScanStream(strings.NewReader("clean"), abort)
+		if err != nil || inf {
+			t.Fatalf("Unexpected clean scan result: %t, '%s', %v", inf, desc, err)
+		}
+		inf, desc, err = p.ScanStream(strings.NewReader(test.EICAR), abort)
+		if err != nil || !inf || desc != test.EICARSignature {
+			t.Fatalf("Unexpected infected scan result: %t, '%s', %v", inf, desc, err)
+		}
+	}
+	if mc.Scans() != 10 || mc.Connections() != 1 {
+		t.Fatalf("Expected 10 scans over 1 connection, got %d scans over %d connections", mc.Scans(), mc.Connections())
+	}
+
+	// A session clamd closed is replaced with a new one.
+	p.mu.Lock()
+	_ = p.idle[0].conn.(interface{ CloseRead() error }).CloseRead()
+	p.idle[0].lastUsed = time.Now().Add(-sessionCheckAfter)
+	p.mu.Unlock()
+	if _, _, err = p.ScanStream(strings.NewReader("clean"), abort); err != nil {
+		t.Fatal(err)
+	}
+	if mc.Connections() != 2 {
+		t.Fatalf("Expected a new connection, got %d connections", mc.Connections())
+	}
+
+	// Aborted scans fail and don't return their session to the pool.
+	aborted := make(chan bool)
+	close(aborted)
+	if _, _, err = p.ScanStream(strings.NewReader("clean"), aborted); err == nil {
+		t.Fatal("Expected an aborted scan to fail")
+	}
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+	if idle != 0 {
+		t.Fatalf("Expected no idle sessions, got %d", idle)
+	}
+}
+
+// TestParseStreamResponse ensures we parse clamd's INSTREAM responses.
+func TestParseStreamResponse(t *testing.T) {
+	inf, desc, err := parseStreamResponse("stream: OK")
+	if err != nil || inf || desc != "" {
+		t.Fatalf("Unexpected result %t, '%s', %v", inf, desc, err)
+	}
+	inf, desc, err = parseStreamResponse("stream: Win.Test.EICAR_HDB-1 FOUND\n")
+	if err != nil || !inf || desc != "Win.Test.EICAR_HDB-1" {
+		t.Fatalf("Unexpected result %t, '%s', %v", inf, desc, err)
+	}
+	if _, _, err = parseStreamResponse("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+// TestInstreamBatch ensures we match clamd's responses to the contents of a
+// batch by their request IDs, whatever order they come in.
+func TestInstreamBatch(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = server.Close() }()
+	s := &clamdSession{conn: client, r: bufio.NewReader(client), lastID: 2}
+	go func() {
+		r := bufio.NewReader(server)
+		for i := 0; i < 2; i++ {
+			if _, err := r.ReadString(0); err != nil {
+				return
+			}
+			if _, err := readChunks(r); err != nil {
+				return
+			}
+		}
+		_, _ = server.Write([]byte("4: stream: Eicar FOUND\x003: stream: OK\x00"))
+	}()
+	verdicts, err := s.instreamBatch([][]byte{[]byte("clean"), []byte("eicar")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdicts[0].infected || !verdicts[1].infected || verdicts[1].description != "Eicar" {
+		t.Fatalf("Unexpected verdicts %+v", verdicts)
+	}
+	_ = client.Close()
+}
+
+// readChunks reads INSTREAM chunks until the zero-length terminator.
+func readChunks(r io.Reader) (int, error) {
+	var total int
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			return total, nil
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return 0, err
+		}
+		total += int(size)
+	}
+}
@@ -0,0 +1,57 @@
+package clamav
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// SignatureInfo describes the engine and the signature database ClamAV is
+// currently running with.
+type SignatureInfo struct {
+	Engine  string    `json:"engine"`
+	Version int       `json:"version"`
+	Date    time.Time `json:"date"`
+}
+
+// SignatureInfo queries ClamAV for its engine version and the version and date
+// of its signature database.
+func (c *ClamAV) SignatureInfo() (SignatureInfo, error) {
+	metricClamdConnections.Inc()
+	defer metricClamdConnections.Dec()
+	result, err := c.staticClam.Version()
+	if err != nil {
+		return SignatureInfo{}, errors.Extend(err, ErrClamd)
+	}
+	var raw string
+	for s := range result {
+		if raw == "" {
+			raw = s.Raw
+		}
+	}
+	return parseVersion(raw)
+}
+
+// parseVersion parses the response to clamd's VERSION command, e.g.
+// "ClamAV 0.104.1/26390/Tue Dec  7 09:21:39 2021".
+func parseVersion(raw string) (SignatureInfo, error) {
+	parts := strings.SplitN(strings.TrimSpace(raw), "/", 3)
+	if len(parts) != 3 {
+		return SignatureInfo{}, errors.New("unexpected clamd version format: " + raw)
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return SignatureInfo{}, errors.AddContext(err, "invalid signature database version")
+	}
+	date, err := time.Parse(time.ANSIC, parts[2])
+	if err != nil {
+		return SignatureInfo{}, errors.AddContext(err, "invalid signature database date")
+	}
+	return SignatureInfo{
+		Engine:  parts[0],
+		Version: version,
+		Date:    date.UTC(),
+	}, nil
+}
@@ -0,0 +1,106 @@
+package clamav
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// PortalMaxRedirects is the length of the chain of redirects we follow
+	// within the pinned portal hosts, e.g. while resolving a v2 skylink.
+	// Set according to the MALWARE_SCANNER_PORTAL_MAX_REDIRECTS env var.
+	PortalMaxRedirects = 10
+
+	// PortalAllowPrivate allows portal requests to connect to private,
+	// loopback and link-local addresses, e.g. for a portal inside the
+	// cluster which is addressed by name. Portals configured by IP address
+	// are always allowed.
+	// Set according to the MALWARE_SCANNER_PORTAL_ALLOW_PRIVATE env var.
+	PortalAllowPrivate bool
+
+	// ErrPortalHostNotAllowed is returned when a portal request would reach
+	// a host other than the pinned portal hosts, or a private address.
+	ErrPortalHostNotAllowed = errors.New("portal host not allowed")
+
+	// pinnedHosts are the hosts portal requests may reach. Portal requests
+	// aren't restricted while it's empty.
+	pinnedHosts   map[string]bool
+	pinnedHostsMu sync.RWMutex
+)
+
+// PinPortalHosts restricts all portal requests, including the HEAD requests
+// which resolve v2 skylinks, to the hosts of the given portal URLs. Redirects
+// to other hosts aren't followed and, unless PortalAllowPrivate is set,
+// connections to private addresses are refused, even if a pinned name
+// resolves to one.
+func PinPortalHosts(portals ...string) error {
+	hosts := make(map[string]bool, len(portals))
+	for _, p := range portals {
+		u, err := url.Parse(p)
+		if err != nil || u.Hostname() == "" {
+			return errors.New("invalid portal URL: " + p)
+		}
+		hosts[strings.ToLower(u.Hostname())] = true
+	}
+	pinnedHostsMu.Lock()
+	pinnedHosts = hosts
+	pinnedHostsMu.Unlock()
+	return nil
+}
+
+// portalHostPinned returns whether portal requests may reach the given host.
+func portalHostPinned(host string) bool {
+	pinnedHostsMu.RLock()
+	defer pinnedHostsMu.RUnlock()
+	return len(pinnedHosts) == 0 || pinnedHosts[strings.ToLower(host)]
+}
+
+// checkPortalRedirect is the portal client's CheckRedirect. It only follows
+// redirects within the pinned portal hosts.
+func checkPortalRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > PortalMaxRedirects {
+		return errors.New("stopped after too many redirects")
+	}
+	if !portalHostPinned(req.URL.Hostname()) {
+		return errors.Extend(errors.New("redirect to "+req.URL.Host), ErrPortalHostNotAllowed)
+	}
+	return nil
+}
+
+// checkPortalConn returns an error if the given connection, which was dialed
+// for the given host, reached a private address it isn't allowed to. We check
+// the address we actually connected to, so it covers every way of resolving
+// the host, including the Handshake resolver.
+func checkPortalConn(host string, conn net.Conn) error {
+	pinnedHostsMu.RLock()
+	pinned := len(pinnedHosts) > 0
+	pinnedHostsMu.RUnlock()
+	if !pinned || PortalAllowPrivate {
+		return nil
+	}
+	if !portalHostPinned(host) {
+		return errors.Extend(errors.New("connection to "+host), ErrPortalHostNotAllowed)
+	}
+	// Portals configured by IP address are explicitly allowed.
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if privateIP(addr.IP) {
+		return errors.Extend(errors.New(host+" resolved to private address "+addr.IP.String()), ErrPortalHostNotAllowed)
+	}
+	return nil
+}
+
+// privateIP returns whether the given IP address isn't publicly routable.
+func privateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
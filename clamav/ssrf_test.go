@@ -0,0 +1,110 @@
+package clamav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestPinPortalHosts ensures portal requests don't follow redirects to other
+// hosts or connect to private addresses once the portal hosts are pinned.
+func TestPinPortalHosts(t *testing.T) {
+	defer func(allow bool) {
+		PortalAllowPrivate = allow
+		pinnedHosts = nil
+	}(PortalAllowPrivate)
+	PortalAllowPrivate = false
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	// The same server, addressed by a name which resolves to a private
+	// address.
+	otherByName := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, otherByName, http.StatusFound)
+		case "/within":
+			http.Redirect(w, r, "/content", http.StatusFound)
+		}
+	}))
+	defer portal.Close()
+	if err := PinPortalHosts(portal.URL, otherByName); err != nil {
+		t.Fatal(err)
+	}
+	if err := PinPortalHosts("not a url"); err == nil {
+		t.Fatal("Expected an error for an invalid URL")
+	}
+
+	get := func(u string) error {
+		resp, err := PortalClient().Get(u)
+		if err != nil {
+			return err.(*url.Error).Err
+		}
+		return resp.Body.Close()
+	}
+	// The portal is configured by IP address, so it's allowed, and so are
+	// redirects within it.
+	if err := get(portal.URL + "/within"); err != nil {
+		t.Fatal(err)
+	}
+	// A pinned name which resolves to a private address isn't.
+	if err := get(otherByName); !errors.Contains(err, ErrPortalHostNotAllowed) {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	PortalAllowPrivate = true
+	if err := get(otherByName); err != nil {
+		t.Fatal(err)
+	}
+	// Redirects to hosts which aren't pinned aren't followed.
+	if err := PinPortalHosts(portal.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(portal.URL + "/away"); !errors.Contains(err, ErrPortalHostNotAllowed) {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+// TestPortalLimits ensures hostile portal responses with endless redirects or
+// headers are cut short.
+func TestPortalLimits(t *testing.T) {
+	defer func(redirects int, headerBytes int64) {
+		PortalMaxRedirects, PortalMaxHeaderBytes = redirects, headerBytes
+	}(PortalMaxRedirects, PortalMaxHeaderBytes)
+	PortalMaxRedirects, PortalMaxHeaderBytes = 3, 4096
+
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/chain/3":
+			w.WriteHeader(http.StatusOK)
+		case "/chain/0", "/chain/1", "/chain/2":
+			http.Redirect(w, r, "/chain/"+string(r.URL.Path[7]+1), http.StatusFound)
+		case "/headers":
+			w.Header().Set("X-Padding", strings.Repeat("a", 8192))
+		}
+	}))
+	defer portal.Close()
+	client := newPortalClient()
+	get := func(path string) error {
+		resp, err := client.Get(portal.URL + path)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err := get("/chain/0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("/loop"); err == nil || !strings.Contains(err.Error(), "too many redirects") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := get("/headers"); err == nil || !strings.Contains(err.Error(), "headers exceeded") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
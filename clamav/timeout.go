@@ -0,0 +1,98 @@
+package clamav
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// PortalReadTimeout is how long a read from a download can wait for the
+// portal to send more bytes. Downloads which stall mid-transfer are aborted
+// and their scans fail with a timeout, so they're retried instead of
+// occupying a worker until the stuck scan is unlocked. Time spent waiting for
+// clamd doesn't count. Zero disables the timeout.
+// Set according to the MALWARE_SCANNER_PORTAL_READ_TIMEOUT env var.
+var PortalReadTimeout = time.Minute
+
+// errDownloadStalled is returned when the portal stops sending the content.
+var errDownloadStalled = errors.Extend(errors.New("download stalled"), ErrTimeout)
+
+type (
+	// idleTimeoutReader closes its source when a read waits longer than
+	// the timeout, which unblocks the read.
+	idleTimeoutReader struct {
+		staticSrc     io.ReadCloser
+		staticTimeout time.Duration
+		staticTimer   *time.Timer
+		timedOut      int32
+	}
+
+	// errRecorder remembers the first error other than io.EOF its source
+	// returned. Scans end early when reading the content fails and scan
+	// what they got, so this is how we find out why.
+	errRecorder struct {
+		r   io.Reader
+		err error
+	}
+)
+
+// newIdleTimeoutReader wraps the given source with PortalReadTimeout. It
+// returns the source itself if the timeout is disabled.
+func newIdleTimeoutReader(src io.ReadCloser) io.ReadCloser {
+	if PortalReadTimeout <= 0 {
+		return src
+	}
+	it := &idleTimeoutReader{
+		staticSrc:     src,
+		staticTimeout: PortalReadTimeout,
+	}
+	it.staticTimer = time.AfterFunc(PortalReadTimeout, func() {
+		atomic.StoreInt32(&it.timedOut, 1)
+		_ = src.Close()
+	})
+	it.staticTimer.Stop()
+	return it
+}
+
+// Read implements io.Reader.
+func (it *idleTimeoutReader) Read(p []byte) (int, error) {
+	it.staticTimer.Reset(it.staticTimeout)
+	n, err := it.staticSrc.Read(p)
+	it.staticTimer.Stop()
+	if err != nil && atomic.LoadInt32(&it.timedOut) == 1 {
+		metricStalledDownloads.Inc()
+		err = errDownloadStalled
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (it *idleTimeoutReader) Close() error {
+	it.staticTimer.Stop()
+	return it.staticSrc.Close()
+}
+
+// Read implements io.Reader.
+func (er *errRecorder) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	er.record(err)
+	return n, err
+}
+
+// WriteTo implements io.WriterTo, so copying from the recorder can use the
+// source's WriteTo or the destination's ReadFrom.
+func (er *errRecorder) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, er.r)
+	er.record(err)
+	return n, err
+}
+
+// record remembers the given error, unless it's io.EOF or we already have
+// one.
+func (er *errRecorder) record(err error) {
+	if err != nil && err != io.EOF && er.err == nil {
+		er.err = err
+	}
+}
@@ -0,0 +1,82 @@
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestIdleTimeoutReader ensures reads fail once the source stops sending,
+// and the time between reads doesn't count towards the timeout.
+func TestIdleTimeoutReader(t *testing.T) {
+	defer func(timeout time.Duration) { PortalReadTimeout = timeout }(PortalReadTimeout)
+	PortalReadTimeout = 50 * time.Millisecond
+
+	// A slow consumer doesn't trigger the timeout.
+	r := newIdleTimeoutReader(io.NopCloser(bytes.NewReader([]byte("hello"))))
+	p := make([]byte, 1)
+	for i := 0; i < 5; i++ {
+		time.Sleep(2 * PortalReadTimeout)
+		if _, err := r.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Read(p); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+
+	// A source which stops sending is closed.
+	pr, pw := io.Pipe()
+	defer func() { _ = pw.Close() }()
+	r = newIdleTimeoutReader(pr)
+	start := time.Now()
+	if _, err := r.Read(p); !errors.Contains(err, ErrTimeout) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 10*PortalReadTimeout {
+		t.Fatalf("Expected the read to fail after the timeout, it took %s", d)
+	}
+}
+
+// TestScanStalledDownload ensures the scan of a download which stalls midway
+// fails with a timeout, rather than waiting for the portal or reporting a
+// partial scan as a success.
+func TestScanStalledDownload(t *testing.T) {
+	defer func(timeout time.Duration) { PortalReadTimeout = timeout }(PortalReadTimeout)
+	PortalReadTimeout = 100 * time.Millisecond
+
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer portal.Close()
+	ip, port := mc.Addr()
+	c, err := New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	r := c.ScanSkylink("stalled", abort)
+	if !errors.Contains(r.Err, ErrTimeout) || r.Infected {
+		t.Fatalf("Expected the scan to time out, got %v %v", r.Infected, r.Err)
+	}
+	if r.ScannedSize != 5 {
+		t.Fatalf("Expected 5 bytes to be scanned, got %d", r.ScannedSize)
+	}
+}
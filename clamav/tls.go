@@ -0,0 +1,48 @@
+package clamav
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// portalTLSConfig is the TLS configuration of portal requests. They use the
+// system's CAs and verify the portal's certificate while it's nil.
+var portalTLSConfig *tls.Config
+
+// SetPortalTLS configures how portal requests verify the portal's
+// certificate. The CA file holds PEM-encoded certificates which are trusted in
+// addition to the system's, e.g. the self-signed CA of a dev portal. Setting
+// insecureSkipVerify disables the verification altogether, which leaves
+// downloads open to tampering, so it's only meant for dev portals and logged
+// loudly. It must be called before the portal client is first used.
+func SetPortalTLS(caFile string, insecureSkipVerify bool) error {
+	if caFile == "" && !insecureSkipVerify {
+		portalTLSConfig = nil
+		return nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return errors.AddContext(err, "failed to read the portal CA file")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates found in the portal CA file")
+		}
+		cfg.RootCAs = pool
+	}
+	if insecureSkipVerify {
+		log.Println("WARNING: portal TLS certificates are not verified, downloads can be tampered with. Never use this in production.")
+		cfg.InsecureSkipVerify = true
+	}
+	portalTLSConfig = cfg
+	return nil
+}
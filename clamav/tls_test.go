@@ -0,0 +1,60 @@
+package clamav
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// TestSetPortalTLS ensures portal requests verify the portal's certificate
+// against the configured CAs unless verification is disabled.
+func TestSetPortalTLS(t *testing.T) {
+	defer func() { portalTLSConfig = nil }()
+	portal := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer portal.Close()
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: portal.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() error {
+		resp, err := newPortalClient().Get(portal.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	// Production is strict by default.
+	if err = SetPortalTLS("", false); err != nil {
+		t.Fatal(err)
+	}
+	if get() == nil {
+		t.Fatal("Expected an untrusted certificate to be refused")
+	}
+	if err = SetPortalTLS(caFile+".missing", false); err == nil {
+		t.Fatal("Expected an error for a missing CA file")
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "empty.crt"), []byte("nothing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetPortalTLS(filepath.Join(dir, "empty.crt"), false); err == nil {
+		t.Fatal("Expected an error for a CA file without certificates")
+	}
+	if err = SetPortalTLS(caFile, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = get(); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetPortalTLS("", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = get(); err != nil {
+		t.Fatal(err)
+	}
+}
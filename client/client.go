@@ -0,0 +1,485 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// StatusQueued is the status Submit returns for a skylink it added to
+	// the scanning queue.
+	StatusQueued = "queued"
+	// StatusDuplicate is the status Submit returns for a skylink which was
+	// already in the scanning queue.
+	StatusDuplicate = "duplicate"
+
+	// defaultRetries is the number of times a failed request is retried by
+	// default.
+	defaultRetries = 3
+	// defaultRetryBackoff is the time we wait before the first retry by
+	// default. It doubles with every retry.
+	defaultRetryBackoff = 500 * time.Millisecond
+	// defaultTimeout is the timeout of a single request by default.
+	defaultTimeout = 30 * time.Second
+	// maxErrorBodySize is the maximum number of bytes we read from the body
+	// of an error response.
+	maxErrorBodySize = 1 << 12
+)
+
+var (
+	// ErrNotFound is returned when the scanner has no record of a skylink, or
+	// of a backfill or campaign, or no detection pending review.
+	ErrNotFound = errors.New("skylink not found")
+	// ErrBackfillOffset is returned when a batch of a backfill doesn't start
+	// where the backfill left off.
+	ErrBackfillOffset = errors.New("the batch doesn't start at the backfill's offset")
+)
+
+type (
+	// Client is a client of the malware scanner's HTTP API. It retries
+	// requests which fail due to network errors, 5xx or 429 responses.
+	Client struct {
+		staticBaseURL      string
+		staticAdminKey     string
+		staticHTTPClient   *http.Client
+		staticRetries      int
+		staticRetryBackoff time.Duration
+	}
+
+	// Options configure a client. Zero values are replaced by defaults. Set
+	// Retries to a negative value to disable retries. AdminKey is only
+	// required by the admin calls.
+	Options struct {
+		AdminKey     string
+		HTTPClient   *http.Client
+		Retries      int
+		RetryBackoff time.Duration
+	}
+
+	// Stats is the scanner's throughput and SLA compliance over a period of
+	// time, together with its infection rate anomaly, queue depth and portal
+	// stats.
+	Stats struct {
+		*database.ScanStats
+		Anomaly *database.Anomaly    `json:"infectionAnomaly"`
+		Queue   *database.QueueDepth `json:"queue"`
+		Portals []clamav.PortalStats `json:"portals"`
+	}
+
+	// BulkStatus holds the scanning status of a list of skylinks. Statuses
+	// are keyed by the skylinks as they were given.
+	BulkStatus struct {
+		Statuses map[string]database.Skylink `json:"statuses"`
+		NotFound []string                    `json:"notFound"`
+		Invalid  []string                    `json:"invalid"`
+	}
+
+	// BackfillBatch is a batch of the skylinks of a backfill. It covers the
+	// backfill's source from From up to To. Failed counts the skylinks of it
+	// which were left out because they couldn't be read.
+	BackfillBatch struct {
+		From     int64    `json:"from"`
+		To       int64    `json:"to"`
+		Failed   int64    `json:"failed"`
+		Skylinks []string `json:"skylinks"`
+	}
+
+	// BackfillBatchResult is the result of enqueueing a batch of a backfill.
+	// Invalid lists the skylinks of the batch which weren't enqueued because
+	// they're invalid.
+	BackfillBatchResult struct {
+		Job     *database.BackfillJob `json:"job"`
+		Invalid []string              `json:"invalid"`
+	}
+
+	// RootSubmission is the result of submitting a sector by merkle root.
+	// Skylink is the skylink built from the root and Hash the hash of the
+	// root, by either of which Status can look up the scan.
+	RootSubmission struct {
+		Status  string `json:"status"`
+		Skylink string `json:"skylink"`
+		Hash    string `json:"hash"`
+	}
+
+	// scanResponse is the response to scan requests.
+	scanResponse struct {
+		Status string `json:"status"`
+	}
+
+	// statusError is the error returned for non-2xx responses.
+	statusError struct {
+		StatusCode int
+		Message    string
+	}
+)
+
+// Error implements error.
+func (e statusError) Error() string {
+	return fmt.Sprintf("malware scanner responded with status code %d: %s", e.StatusCode, e.Message)
+}
+
+// New creates a new client of the malware scanner API at the given base URL,
+// e.g. "http://malware-scanner:4000".
+func New(baseURL string, opts Options) *Client {
+	c := &Client{
+		staticBaseURL:      strings.TrimSuffix(baseURL, "/"),
+		staticAdminKey:     opts.AdminKey,
+		staticHTTPClient:   opts.HTTPClient,
+		staticRetries:      opts.Retries,
+		staticRetryBackoff: opts.RetryBackoff,
+	}
+	if c.staticHTTPClient == nil {
+		c.staticHTTPClient = &http.Client{Timeout: defaultTimeout}
+	}
+	if c.staticRetries == 0 {
+		c.staticRetries = defaultRetries
+	}
+	if c.staticRetries < 0 {
+		c.staticRetries = 0
+	}
+	if c.staticRetryBackoff == 0 {
+		c.staticRetryBackoff = defaultRetryBackoff
+	}
+	return c
+}
+
+// Submit adds the given skylink to the scanning queue. It returns
+// StatusQueued, or StatusDuplicate if the skylink was already queued.
+func (c *Client) Submit(ctx context.Context, skylink string) (string, error) {
+	var resp scanResponse
+	err := c.do(ctx, http.MethodPost, "/scan/"+url.PathEscape(skylink), nil, &resp)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to submit skylink")
+	}
+	return resp.Status, nil
+}
+
+// SubmitRoot adds the first length bytes of the sector with the given merkle
+// root to the scanning queue, for content we don't have a skylink of. The
+// submission's status is StatusQueued, or StatusDuplicate if the content was
+// already queued.
+func (c *Client) SubmitRoot(ctx context.Context, root string, length uint64) (*RootSubmission, error) {
+	var rs RootSubmission
+	path := fmt.Sprintf("/scanroot/%s?length=%d", url.PathEscape(root), length)
+	err := c.do(ctx, http.MethodPost, path, nil, &rs)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to submit merkle root")
+	}
+	return &rs, nil
+}
+
+// Status returns the scanning status of the given skylink. It returns
+// ErrNotFound if the scanner has no record of it.
+func (c *Client) Status(ctx context.Context, skylink string) (*database.Skylink, error) {
+	var sl database.Skylink
+	err := c.do(ctx, http.MethodGet, "/status/"+url.PathEscape(skylink), nil, &sl)
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch skylink status")
+	}
+	return &sl, nil
+}
+
+// BulkStatus returns the scanning status of all given skylinks in a single
+// request.
+func (c *Client) BulkStatus(ctx context.Context, skylinks []string) (*BulkStatus, error) {
+	body, err := json.Marshal(struct {
+		Skylinks []string `json:"skylinks"`
+	}{skylinks})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build bulk status request")
+	}
+	var bs BulkStatus
+	err = c.do(ctx, http.MethodPost, "/status", body, &bs)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch skylink statuses")
+	}
+	return &bs, nil
+}
+
+// Stats returns the scanner's stats over the last given number of hours. A
+// non-positive number of hours uses the scanner's default.
+func (c *Client) Stats(ctx context.Context, hours int) (*Stats, error) {
+	path := "/stats"
+	if hours > 0 {
+		path += "?hours=" + strconv.Itoa(hours)
+	}
+	var s Stats
+	err := c.do(ctx, http.MethodGet, path, nil, &s)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch stats")
+	}
+	return &s, nil
+}
+
+// Pause pauses the scanning of new skylinks. It requires an admin key.
+func (c *Client) Pause(ctx context.Context) error {
+	return errors.AddContext(c.do(ctx, http.MethodPost, "/admin/pause", nil, nil), "failed to pause scanning")
+}
+
+// Resume resumes the scanning of new skylinks. It requires an admin key.
+func (c *Client) Resume(ctx context.Context) error {
+	return errors.AddContext(c.do(ctx, http.MethodPost, "/admin/resume", nil, nil), "failed to resume scanning")
+}
+
+// Purge removes the record of the given skylink. It returns ErrNotFound if the
+// scanner has no record of it. It requires an admin key.
+func (c *Client) Purge(ctx context.Context, skylink string) error {
+	err := c.do(ctx, http.MethodDelete, "/admin/skylink/"+url.PathEscape(skylink), nil, nil)
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return errors.AddContext(err, "failed to purge skylink")
+}
+
+// Export returns the records of the given skylinks together with their
+// history. Skylinks without a record are left out. It requires an admin key.
+func (c *Client) Export(ctx context.Context, skylinks []string) (*database.Snapshot, error) {
+	body, err := json.Marshal(struct {
+		Skylinks []string `json:"skylinks"`
+	}{skylinks})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build export request")
+	}
+	var s database.Snapshot
+	err = c.do(ctx, http.MethodPost, "/admin/export", body, &s)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to export records")
+	}
+	return &s, nil
+}
+
+// Import stores the records of the given export, replacing the records of the
+// same skylinks, and returns the number of records it stored. It requires an
+// admin key and a scanner with imports enabled.
+func (c *Client) Import(ctx context.Context, s *database.Snapshot) (int, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to build import request")
+	}
+	var resp struct {
+		Imported int `json:"imported"`
+	}
+	err = c.do(ctx, http.MethodPost, "/admin/import", body, &resp)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to import records")
+	}
+	return resp.Imported, nil
+}
+
+// CreateBackfill starts a backfill of the given number of skylinks from the
+// given source. It requires an admin key.
+func (c *Client) CreateBackfill(ctx context.Context, source string, total int64) (*database.BackfillJob, error) {
+	body, err := json.Marshal(struct {
+		Source string `json:"source"`
+		Total  int64  `json:"total"`
+	}{source, total})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build backfill request")
+	}
+	var job database.BackfillJob
+	err = c.do(ctx, http.MethodPost, "/admin/backfills", body, &job)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create backfill")
+	}
+	return &job, nil
+}
+
+// Backfill returns the progress of the backfill with the given ID. It returns
+// ErrNotFound if there is no such backfill. It requires an admin key.
+func (c *Client) Backfill(ctx context.Context, id string) (*database.BackfillJob, error) {
+	var job database.BackfillJob
+	err := c.do(ctx, http.MethodGet, "/admin/backfills/"+url.PathEscape(id), nil, &job)
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch backfill")
+	}
+	return &job, nil
+}
+
+// BackfillEnqueue adds the given batch of the backfill with the given ID to
+// the scanning queue. It returns ErrBackfillOffset if the batch doesn't start
+// where the backfill left off. It requires an admin key.
+func (c *Client) BackfillEnqueue(ctx context.Context, id string, batch BackfillBatch) (*BackfillBatchResult, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build backfill request")
+	}
+	var res BackfillBatchResult
+	err = c.do(ctx, http.MethodPost, "/admin/backfills/"+url.PathEscape(id)+"/skylinks", body, &res)
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusConflict {
+		return nil, errors.Compose(ErrBackfillOffset, err)
+	}
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to enqueue backfill batch")
+	}
+	return &res, nil
+}
+
+// CreateCampaign starts a re-scan campaign, which queues the records that
+// match the given criteria for scanning again at low priority. It requires an
+// admin key.
+func (c *Client) CreateCampaign(ctx context.Context, criteria database.CampaignCriteria) (*database.Campaign, error) {
+	body, err := json.Marshal(criteria)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build campaign request")
+	}
+	var campaign database.Campaign
+	err = c.do(ctx, http.MethodPost, "/admin/campaigns", body, &campaign)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create campaign")
+	}
+	return &campaign, nil
+}
+
+// Campaign returns the re-scan campaign with the given ID with the summary of
+// its progress and results. It returns ErrNotFound if there is no such
+// campaign. It requires an admin key.
+func (c *Client) Campaign(ctx context.Context, id string) (*database.Campaign, error) {
+	var campaign database.Campaign
+	err := c.do(ctx, http.MethodGet, "/admin/campaigns/"+url.PathEscape(id), nil, &campaign)
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch campaign")
+	}
+	return &campaign, nil
+}
+
+// Reviews returns up to the given number of detections which are pending
+// review, the most severe first. A non-positive limit uses the scanner's
+// default. It requires an admin key.
+func (c *Client) Reviews(ctx context.Context, limit int) ([]database.Skylink, error) {
+	path := "/admin/reviews"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var resp struct {
+		Reviews []database.Skylink `json:"reviews"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &resp)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch reviews")
+	}
+	return resp.Reviews, nil
+}
+
+// ApproveReview approves the detection of the record with the given hash,
+// which is pending review, so it's reported to blocker. It returns ErrNotFound
+// if no detection of the hash is pending review. It requires an admin key.
+func (c *Client) ApproveReview(ctx context.Context, hash string) (*database.Skylink, error) {
+	return c.review(ctx, hash, "approve")
+}
+
+// RejectReview rejects the detection of the record with the given hash, which
+// is pending review, and marks the record as a false positive. It returns
+// ErrNotFound if no detection of the hash is pending review. It requires an
+// admin key.
+func (c *Client) RejectReview(ctx context.Context, hash string) (*database.Skylink, error) {
+	return c.review(ctx, hash, "reject")
+}
+
+// review approves or rejects the detection of the record with the given hash.
+func (c *Client) review(ctx context.Context, hash, decision string) (*database.Skylink, error) {
+	var sl database.Skylink
+	err := c.do(ctx, http.MethodPost, "/admin/reviews/"+url.PathEscape(hash)+"/"+decision, nil, &sl)
+	if se, ok := err.(statusError); ok && se.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to "+decision+" review")
+	}
+	return &sl, nil
+}
+
+// do performs the given request, retrying it when it fails with a transient
+// error, and decodes the JSON response into resp.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, resp interface{}) error {
+	backoff := c.staticRetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = c.doOnce(ctx, method, path, body, resp)
+		if err == nil || !retry || attempt >= c.staticRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Compose(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// doOnce performs a single attempt of the given request. It returns whether a
+// failed request is worth retrying.
+func (c *Client) doOnce(ctx context.Context, method, path string, body []byte, resp interface{}) (bool, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.staticBaseURL+path, r)
+	if err != nil {
+		return false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.staticAdminKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.staticAdminKey)
+	}
+	res, err := c.staticHTTPClient.Do(req)
+	if err != nil {
+		// Don't retry once the caller has given up.
+		return ctx.Err() == nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		retry := res.StatusCode/100 == 5 || res.StatusCode == http.StatusTooManyRequests
+		return retry, readError(res)
+	}
+	if resp == nil || res.StatusCode == http.StatusNoContent {
+		return false, nil
+	}
+	err = json.NewDecoder(res.Body).Decode(resp)
+	if err != nil {
+		return false, errors.AddContext(err, "failed to decode response")
+	}
+	return false, nil
+}
+
+// readError builds an error from a non-2xx response. It uses the message of
+// the API's JSON error body if there is one.
+func readError(res *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &apiErr) == nil && apiErr.Message != "" {
+		msg = apiErr.Message
+	}
+	return statusError{StatusCode: res.StatusCode, Message: msg}
+}
@@ -0,0 +1,304 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// scannerURL is the base URL of the mocked malware scanner.
+const scannerURL = "http://malware-scanner:4000"
+
+// testSkylink is a valid skylink used in the tests.
+const testSkylink = "AABEKWZ_wc2R9qlhYkzbG8mImFVi08kBu1nsvvwPLBtpEg"
+
+// newTestClient returns a client which retries quickly.
+func newTestClient() *Client {
+	return New(scannerURL+"/", Options{RetryBackoff: time.Millisecond})
+}
+
+// TestSubmit ensures Submit works as expected.
+func TestSubmit(t *testing.T) {
+	defer gock.Off()
+	c := newTestClient()
+
+	gock.New(scannerURL).
+		Post("/scan/" + testSkylink).
+		Reply(http.StatusOK).
+		JSON(map[string]string{"status": StatusQueued})
+	status, err := c.Submit(context.Background(), testSkylink)
+	if err != nil || status != StatusQueued {
+		t.Fatalf("Expected status '%s', got '%s', error %v", StatusQueued, status, err)
+	}
+
+	// Sectors are submitted by merkle root.
+	root := "82a925be13a9d970a4bda34ed67c8e5be179a499e39895b15ff081d62a317ec8"
+	gock.New(scannerURL).
+		Post("/scanroot/"+root).
+		MatchParam("length", "4096").
+		Reply(http.StatusOK).
+		JSON(map[string]string{"status": StatusQueued, "skylink": testSkylink, "hash": root})
+	rs, err := c.SubmitRoot(context.Background(), root, 4096)
+	if err != nil || rs.Status != StatusQueued || rs.Skylink != testSkylink {
+		t.Fatalf("Unexpected submission %+v, error %v", rs, err)
+	}
+
+	// Bad requests are not retried.
+	gock.New(scannerURL).
+		Post("/scan/invalid").
+		Times(1).
+		Reply(http.StatusBadRequest).
+		JSON(map[string]string{"message": "invalid skylink"})
+	_, err = c.Submit(context.Background(), "invalid")
+	if err == nil || !strings.Contains(err.Error(), "status code 400: invalid skylink") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestRetries ensures transient failures are retried.
+func TestRetries(t *testing.T) {
+	defer gock.Off()
+	c := newTestClient()
+
+	gock.New(scannerURL).
+		Post("/scan/" + testSkylink).
+		Times(1).
+		ReplyError(errors.New("simulated error"))
+	gock.New(scannerURL).
+		Post("/scan/" + testSkylink).
+		Times(1).
+		Reply(http.StatusServiceUnavailable)
+	gock.New(scannerURL).
+		Post("/scan/" + testSkylink).
+		Reply(http.StatusOK).
+		JSON(map[string]string{"status": StatusDuplicate})
+	status, err := c.Submit(context.Background(), testSkylink)
+	if err != nil || status != StatusDuplicate {
+		t.Fatalf("Expected status '%s', got '%s', error %v", StatusDuplicate, status, err)
+	}
+
+	// Give up once all retries are used.
+	gock.New(scannerURL).
+		Get("/stats").
+		Times(defaultRetries + 1).
+		Reply(http.StatusInternalServerError)
+	_, err = c.Stats(context.Background(), 0)
+	if err == nil || !strings.Contains(err.Error(), "status code 500") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestStatus ensures Status and BulkStatus work as expected.
+func TestStatus(t *testing.T) {
+	defer gock.Off()
+	c := newTestClient()
+
+	gock.New(scannerURL).
+		Get("/status/" + testSkylink).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"skylink": testSkylink, "infected": true})
+	sl, err := c.Status(context.Background(), testSkylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Skylink != testSkylink || !sl.Infected {
+		t.Fatalf("Unexpected status %+v", sl)
+	}
+
+	gock.New(scannerURL).
+		Get("/status/" + testSkylink).
+		Reply(http.StatusNotFound).
+		JSON(map[string]string{"message": "skylink not found"})
+	_, err = c.Status(context.Background(), testSkylink)
+	if !errors.Contains(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	gock.New(scannerURL).
+		Post("/status").
+		JSON(map[string][]string{"skylinks": {testSkylink, "invalid"}}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{
+			"statuses": map[string]interface{}{testSkylink: map[string]interface{}{"skylink": testSkylink}},
+			"invalid":  []string{"invalid"},
+		})
+	bs, err := c.BulkStatus(context.Background(), []string{testSkylink, "invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bs.Statuses[testSkylink]; !ok || len(bs.Invalid) != 1 || len(bs.NotFound) != 0 {
+		t.Fatalf("Unexpected bulk status %+v", bs)
+	}
+}
+
+// TestAdmin ensures the admin calls send the admin key and work as expected.
+func TestAdmin(t *testing.T) {
+	defer gock.Off()
+	c := New(scannerURL, Options{AdminKey: "secret", Retries: -1})
+
+	gock.New(scannerURL).
+		Post("/admin/pause").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusNoContent)
+	if err := c.Pause(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	gock.New(scannerURL).
+		Post("/admin/resume").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusNoContent)
+	if err := c.Resume(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gock.New(scannerURL).
+		Delete("/admin/skylink/"+testSkylink).
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusNoContent)
+	if err := c.Purge(context.Background(), testSkylink); err != nil {
+		t.Fatal(err)
+	}
+	gock.New(scannerURL).
+		Delete("/admin/skylink/" + testSkylink).
+		Reply(http.StatusNotFound).
+		JSON(map[string]string{"message": "skylink not found"})
+	if err := c.Purge(context.Background(), testSkylink); !errors.Contains(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	snapshot := map[string]interface{}{
+		"records": []map[string]interface{}{{"record": map[string]string{"status": "complete"}, "rescanSkylink": testSkylink}},
+	}
+	gock.New(scannerURL).
+		Post("/admin/export").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string][]string{"skylinks": {testSkylink}}).
+		Reply(http.StatusOK).
+		JSON(snapshot)
+	s, err := c.Export(context.Background(), []string{testSkylink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Records) != 1 || s.Records[0].Record.Status != "complete" || s.Records[0].RescanSkylink != testSkylink {
+		t.Fatalf("Unexpected export %+v", s)
+	}
+	gock.New(scannerURL).
+		Post("/admin/import").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(map[string]int{"imported": 1})
+	n, err := c.Import(context.Background(), s)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 imported record, got %d, %v", n, err)
+	}
+
+	job := map[string]interface{}{"id": "61a74c46a1b2c3d4e5f60718", "source": "skylinks.txt", "total": 2, "offset": 2, "enqueued": 1, "failed": 1}
+	gock.New(scannerURL).
+		Post("/admin/backfills").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{"source": "skylinks.txt", "total": 2}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"id": "61a74c46a1b2c3d4e5f60718", "source": "skylinks.txt", "total": 2})
+	created, err := c.CreateBackfill(context.Background(), "skylinks.txt", 2)
+	if err != nil || created.ID.Hex() != "61a74c46a1b2c3d4e5f60718" || created.Total != 2 {
+		t.Fatalf("Unexpected backfill %+v, %v", created, err)
+	}
+	gock.New(scannerURL).
+		Post("/admin/backfills/61a74c46a1b2c3d4e5f60718/skylinks").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{"from": 0, "to": 2, "failed": 0, "skylinks": []string{testSkylink, "not-a-skylink"}}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"job": job, "invalid": []string{"not-a-skylink"}})
+	res, err := c.BackfillEnqueue(context.Background(), "61a74c46a1b2c3d4e5f60718", BackfillBatch{To: 2, Skylinks: []string{testSkylink, "not-a-skylink"}})
+	if err != nil || res.Job.Offset != 2 || len(res.Invalid) != 1 {
+		t.Fatalf("Unexpected batch result %+v, %v", res, err)
+	}
+	gock.New(scannerURL).
+		Post("/admin/backfills/61a74c46a1b2c3d4e5f60718/skylinks").
+		Reply(http.StatusConflict).
+		JSON(map[string]string{"message": "the batch doesn't start at the backfill's offset 2"})
+	_, err = c.BackfillEnqueue(context.Background(), "61a74c46a1b2c3d4e5f60718", BackfillBatch{To: 2, Skylinks: []string{testSkylink}})
+	if !errors.Contains(err, ErrBackfillOffset) {
+		t.Fatalf("Expected ErrBackfillOffset, got %v", err)
+	}
+	gock.New(scannerURL).
+		Get("/admin/backfills/61a74c46a1b2c3d4e5f60718").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(job)
+	fetched, err := c.Backfill(context.Background(), "61a74c46a1b2c3d4e5f60718")
+	if err != nil || fetched.Enqueued != 1 || fetched.Failed != 1 {
+		t.Fatalf("Unexpected backfill %+v, %v", fetched, err)
+	}
+	gock.New(scannerURL).
+		Get("/admin/backfills/61a74c46a1b2c3d4e5f60718").
+		Reply(http.StatusNotFound).
+		JSON(map[string]string{"message": "backfill not found"})
+	if _, err := c.Backfill(context.Background(), "61a74c46a1b2c3d4e5f60718"); !errors.Contains(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	gock.New(scannerURL).
+		Post("/admin/campaigns").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{"maxSize": 1024, "infected": false}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"id": "61a74c46a1b2c3d4e5f60718", "queued": 3, "skipped": 1})
+	clean := false
+	campaign, err := c.CreateCampaign(context.Background(), database.CampaignCriteria{MaxSize: 1024, Infected: &clean})
+	if err != nil || campaign.Queued != 3 || campaign.Skipped != 1 {
+		t.Fatalf("Unexpected campaign %+v, %v", campaign, err)
+	}
+	gock.New(scannerURL).
+		Get("/admin/campaigns/61a74c46a1b2c3d4e5f60718").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"id": "61a74c46a1b2c3d4e5f60718", "queued": 3, "summary": map[string]int{"pending": 1, "completed": 2, "newlyInfected": 1}})
+	campaign, err = c.Campaign(context.Background(), "61a74c46a1b2c3d4e5f60718")
+	if err != nil || campaign.Summary.Completed != 2 || campaign.Summary.NewlyInfected != 1 {
+		t.Fatalf("Unexpected campaign %+v, %v", campaign, err)
+	}
+
+	hash := "ffbb3ed32667fe423f27d6d1dc89194b454ec411d402988f3edc2a7f9b2ce6e4"
+	gock.New(scannerURL).
+		Get("/admin/reviews").
+		MatchParam("limit", "10").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"reviews": []map[string]interface{}{{"status": "pending_review", "severity": 40}}})
+	reviews, err := c.Reviews(context.Background(), 10)
+	if err != nil || len(reviews) != 1 || reviews[0].Severity != 40 {
+		t.Fatalf("Unexpected reviews %+v, %v", reviews, err)
+	}
+	gock.New(scannerURL).
+		Post("/admin/reviews/"+hash+"/approve").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(map[string]string{"status": "unreported"})
+	reviewed, err := c.ApproveReview(context.Background(), hash)
+	if err != nil || reviewed.Status != database.SkylinkStatusUnreported {
+		t.Fatalf("Unexpected approved record %+v, %v", reviewed, err)
+	}
+	gock.New(scannerURL).
+		Post("/admin/reviews/" + hash + "/reject").
+		Reply(http.StatusNotFound).
+		JSON(map[string]string{"message": "no detection of this hash is pending review"})
+	if _, err := c.RejectReview(context.Background(), hash); !errors.Contains(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
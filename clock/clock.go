@@ -0,0 +1,193 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock tells the time and waits for it to pass. The scanner's loops and
+	// the timestamps we store use it instead of the time package, so tests
+	// can replace it with a Fake and control time deterministically.
+	Clock interface {
+		Now() time.Time
+		Since(t time.Time) time.Duration
+		After(d time.Duration) <-chan time.Time
+		NewTicker(d time.Duration) Ticker
+	}
+
+	// Ticker delivers ticks on its channel at intervals, like time.Ticker.
+	Ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	// realClock is the Clock of the time package.
+	realClock struct{}
+
+	// realTicker is a Ticker backed by a time.Ticker.
+	realTicker struct {
+		*time.Ticker
+	}
+
+	// Fake is a Clock which only moves when it's told to. Timers and tickers
+	// fire during Advance, once the fake time reaches them.
+	Fake struct {
+		now     time.Time
+		waiters []*waiter
+		cond    *sync.Cond
+		mu      sync.Mutex
+	}
+
+	// waiter is a timer or ticker of a Fake. Tickers have a period.
+	waiter struct {
+		at     time.Time
+		period time.Duration
+		c      chan time.Time
+	}
+
+	// fakeTicker is a Ticker of a Fake.
+	fakeTicker struct {
+		staticClock  *Fake
+		staticWaiter *waiter
+	}
+)
+
+// New returns the real clock.
+func New() Clock {
+	return realClock{}
+}
+
+// Now implements Clock.
+func (realClock) Now() time.Time { return time.Now() }
+
+// Since implements Clock.
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// After implements Clock.
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// NewTicker implements Clock.
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// C implements Ticker.
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// NewFake returns a Fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now implements Clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since implements Clock.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After implements Clock. Durations which aren't positive fire right away.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{at: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+		return w.c
+	}
+	f.add(w)
+	return w.c
+}
+
+// NewTicker implements Clock. It panics for durations which aren't positive,
+// like time.NewTicker.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{at: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.add(w)
+	return &fakeTicker{staticClock: f, staticWaiter: w}
+}
+
+// Advance moves the clock forward by the given duration and fires the timers
+// and tickers it passes, in order. Like time.Ticker, a ticker whose channel
+// is full drops ticks.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].at.After(end) {
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		f.now = w.at
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+			f.add(w)
+		}
+	}
+	f.now = end
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until there are at least n pending timers and tickers,
+// e.g. until a loop under test waits for the clock, so Advance doesn't run
+// before it.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// add adds the given waiter, keeping the waiters sorted by the time they
+// fire. It must be called with the lock held.
+func (f *Fake) add(w *waiter) {
+	i := sort.Search(len(f.waiters), func(i int) bool { return f.waiters[i].at.After(w.at) })
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = w
+	f.cond.Broadcast()
+}
+
+// remove removes the given waiter. It must be called with the lock held.
+func (f *Fake) remove(w *waiter) {
+	for i := range f.waiters {
+		if f.waiters[i] == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// C implements Ticker.
+func (t *fakeTicker) C() <-chan time.Time { return t.staticWaiter.c }
+
+// Stop implements Ticker.
+func (t *fakeTicker) Stop() {
+	t.staticClock.mu.Lock()
+	defer t.staticClock.mu.Unlock()
+	t.staticClock.remove(t.staticWaiter)
+}
@@ -0,0 +1,72 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFake ensures the fake clock only fires timers and tickers once it's
+// advanced past them, in order.
+func TestFake(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	after := f.After(time.Minute)
+	ticker := f.NewTicker(20 * time.Second)
+	if f.Waiters() != 2 {
+		t.Fatalf("Expected 2 waiters, got %d", f.Waiters())
+	}
+	select {
+	case <-f.After(0):
+	default:
+		t.Fatal("Expected a zero timer to fire right away")
+	}
+
+	f.Advance(59 * time.Second)
+	if f.Since(start) != 59*time.Second {
+		t.Fatalf("Unexpected time %v", f.Now())
+	}
+	select {
+	case <-after:
+		t.Fatal("Expected the timer not to fire yet")
+	default:
+	}
+	// The ticker fired twice, but its channel only holds one tick.
+	if tick := <-ticker.C(); !tick.Equal(start.Add(20 * time.Second)) {
+		t.Fatalf("Unexpected tick %v", tick)
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected the second tick to be dropped")
+	default:
+	}
+
+	f.Advance(time.Second)
+	if fired := <-after; !fired.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Unexpected timer time %v", fired)
+	}
+	if tick := <-ticker.C(); !tick.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Unexpected tick %v", tick)
+	}
+	ticker.Stop()
+	if f.Waiters() != 0 {
+		t.Fatalf("Expected no waiters, got %d", f.Waiters())
+	}
+}
+
+// TestBlockUntil ensures BlockUntil waits for a goroutine to wait for the
+// clock.
+func TestBlockUntil(t *testing.T) {
+	f := NewFake(time.Now())
+	done := make(chan struct{})
+	go func() {
+		<-f.After(time.Hour)
+		close(done)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the goroutine to wake up")
+	}
+}
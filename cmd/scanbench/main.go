@@ -0,0 +1,144 @@
+// scanbench generates load on the scanning pipeline, against a mock portal
+// and a mock clamd, and prints the scans/sec and MB/sec it sustains for each
+// combination of file size, worker count and batch size.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/bench"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// usage describes the tool.
+const usage = `Usage: scanbench [flags]
+
+Runs each combination of file size, worker count and batch size for the given
+duration and prints the throughput of the scanning pipeline.
+
+Flags:
+`
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdout)
+	if errors.Contains(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run parses the flags and runs the benchmarks they describe.
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("scanbench", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	sizesFlag := fs.String("sizes", "1k,64k,1m,16m", "comma-separated file sizes, with optional k, m or g suffixes")
+	workersFlag := fs.String("workers", "1,4,16", "comma-separated numbers of concurrent workers")
+	batchFlag := fs.String("batch", "1", "comma-separated numbers of files scanned per batch")
+	duration := fs.Duration("duration", 5*time.Second, "how long to run each combination for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	sizes, err := parseList(*sizesFlag, parseSize)
+	if err != nil {
+		return errors.AddContext(err, "invalid -sizes")
+	}
+	workers, err := parseList(*workersFlag, strconv.Atoi)
+	if err != nil {
+		return errors.AddContext(err, "invalid -workers")
+	}
+	batches, err := parseList(*batchFlag, strconv.Atoi)
+	if err != nil {
+		return errors.AddContext(err, "invalid -batch")
+	}
+	if *duration <= 0 {
+		return errors.New("-duration must be positive")
+	}
+
+	env, err := bench.NewEnv()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = env.Close() }()
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "size\tworkers\tbatch\tscans/s\tMB/s\terrors\t")
+	for _, size := range sizes {
+		for _, w := range workers {
+			for _, b := range batches {
+				runCtx, cancel := context.WithTimeout(ctx, *duration)
+				r := env.Run(runCtx, size, w, b, 0)
+				cancel()
+				fmt.Fprintf(tw, "%d\t%d\t%d\t%.1f\t%.1f\t%d\t\n", r.Size, r.Workers, r.Batch, r.ScansPerSecond(), r.MBPerSecond(), r.Errors)
+				// Flush after every row, so long runs show progress.
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseList parses a comma-separated list of positive integers with the given
+// parser.
+func parseList(s string, parse func(string) (int, error)) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v, err := parse(item)
+		if err != nil {
+			return nil, err
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("%q isn't positive", item)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("empty list")
+	}
+	return values, nil
+}
+
+// parseSize parses a size in bytes, with an optional k, m or g suffix for
+// KiB, MiB and GiB.
+func parseSize(s string) (int, error) {
+	mult := 1
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
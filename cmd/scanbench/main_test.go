@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestParseSize ensures sizes with and without suffixes are parsed.
+func TestParseSize(t *testing.T) {
+	tests := map[string]int{
+		"512": 512,
+		"4k":  4 << 10,
+		"16M": 16 << 20,
+		"1g":  1 << 30,
+	}
+	for s, expected := range tests {
+		n, err := parseSize(s)
+		if err != nil || n != expected {
+			t.Fatalf("%s: expected %d, got %d, %v", s, expected, n, err)
+		}
+	}
+	for _, s := range []string{"k", "1x", "1.5m"} {
+		if _, err := parseSize(s); err == nil {
+			t.Fatalf("%s: expected an error", s)
+		}
+	}
+}
+
+// TestRun ensures a short run prints a row for each combination.
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	args := []string{"-sizes", "1k,64k", "-workers", "2", "-batch", "1,4", "-duration", "50ms"}
+	if err := run(context.Background(), args, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected a header and 4 rows, got %s", out.String())
+	}
+	if !strings.Contains(lines[1], "1024") || !strings.Contains(lines[4], "65536") {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+
+	if err := run(context.Background(), []string{"-workers", "0"}, &out); err == nil {
+		t.Fatal("Expected an error for a non-positive worker count")
+	}
+}
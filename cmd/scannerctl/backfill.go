@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/SkynetLabs/malware-scanner/client"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// defaultBackfillBatch is the number of skylinks backfill sends per request by
+// default. It's the most the scanner accepts.
+const defaultBackfillBatch = 1000
+
+// backfill adds the skylinks listed in a file to the scanning queue at low
+// priority, in batches, while the scanner tracks the progress in a backfill
+// job. Without -id it starts a new job. With -id it resumes the given job
+// where it left off, which requires the same file.
+func backfill(ctx context.Context, c *client.Client, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	id := fs.String("id", "", "the ID of a backfill to resume")
+	batch := fs.Int("batch", defaultBackfillBatch, "the number of skylinks to send per request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: scannerctl backfill [-id <id>] [-batch N] <file>")
+	}
+	if *batch <= 0 || *batch > defaultBackfillBatch {
+		return fmt.Errorf("-batch must be between 1 and %d", defaultBackfillBatch)
+	}
+	file := fs.Arg(0)
+	r := stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return errors.AddContext(err, "failed to open skylinks file")
+		}
+		defer f.Close()
+		r = f
+	}
+	skylinks, err := readSkylinks(r)
+	if err != nil {
+		return errors.AddContext(err, "failed to read skylinks file")
+	}
+	total := int64(len(skylinks))
+
+	var job *database.BackfillJob
+	if *id == "" {
+		job, err = c.CreateBackfill(ctx, file, total)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Started backfill %s of %d skylinks, resume it with: scannerctl backfill -id %s %s\n", job.ID.Hex(), total, job.ID.Hex(), file)
+	} else {
+		job, err = c.Backfill(ctx, *id)
+		if err != nil {
+			return err
+		}
+		if job.Total != total {
+			return fmt.Errorf("backfill %s is of %d skylinks but the file lists %d", *id, job.Total, total)
+		}
+		fmt.Fprintf(stdout, "Resuming backfill %s at %d of %d skylinks\n", *id, job.Offset, total)
+	}
+
+	for from := job.Offset; from < total; from = job.Offset {
+		to := from + int64(*batch)
+		if to > total {
+			to = total
+		}
+		res, err := c.BackfillEnqueue(ctx, job.ID.Hex(), client.BackfillBatch{
+			From:     from,
+			To:       to,
+			Skylinks: skylinks[from:to],
+		})
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("failed to enqueue skylinks %d to %d, resume with: scannerctl backfill -id %s %s", from, to, job.ID.Hex(), file))
+		}
+		for _, sl := range res.Invalid {
+			fmt.Fprintf(stdout, "%s\tinvalid\n", sl)
+		}
+		job = res.Job
+		fmt.Fprintf(stdout, "Enqueued %d of %d skylinks, %d failed\n", job.Enqueued, total, job.Failed)
+	}
+	fmt.Fprintf(stdout, "Backfill %s is enqueued, check its progress with: scannerctl backfill-status %s\n", job.ID.Hex(), job.ID.Hex())
+	return nil
+}
+
+// backfillStatus prints the progress of a backfill as JSON.
+func backfillStatus(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: scannerctl backfill-status <id>")
+	}
+	job, err := c.Backfill(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, job)
+}
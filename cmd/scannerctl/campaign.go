@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/client"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// campaign starts a re-scan campaign of the records which match the given
+// criteria and prints it as JSON.
+func campaign(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("campaign", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	before := fs.String("scanned-before", "", "only re-scan records scanned before this RFC3339 time or YYYY-MM-DD date")
+	minSize := fs.Uint64("min-size", 0, "only re-scan records of at least this many bytes")
+	maxSize := fs.Uint64("max-size", 0, "only re-scan records of at most this many bytes")
+	infected := fs.String("infected", "", "only re-scan records with this prior verdict, true or false")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: scannerctl campaign [-scanned-before DATE] [-min-size N] [-max-size N] [-infected true|false]")
+	}
+	criteria := database.CampaignCriteria{MinSize: *minSize, MaxSize: *maxSize}
+	if *before != "" {
+		t, err := time.Parse(time.RFC3339, *before)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", *before)
+		}
+		if err != nil {
+			return errors.New("invalid -scanned-before, expected an RFC3339 time or YYYY-MM-DD date")
+		}
+		criteria.ScannedBefore = &t
+	}
+	if *infected != "" {
+		inf, err := strconv.ParseBool(*infected)
+		if err != nil {
+			return errors.New("invalid -infected, expected true or false")
+		}
+		criteria.Infected = &inf
+	}
+	cp, err := c.CreateCampaign(ctx, criteria)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, cp)
+}
+
+// campaignStatus prints the progress and results of a re-scan campaign as
+// JSON.
+func campaignStatus(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: scannerctl campaign-status <id>")
+	}
+	cp, err := c.Campaign(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, cp)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/client"
+	"github.com/SkynetLabs/malware-scanner/test"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+)
+
+// loadtestStatusBatch is the number of skylinks whose status we fetch per
+// request, which is the most the scanner accepts.
+const loadtestStatusBatch = 1000
+
+// loadtestUsage describes the loadtest command.
+const loadtestUsage = `Usage: scannerctl loadtest [flags]
+
+Serves synthetic skylinks from a built-in mock portal, submits them to the
+scanner, waits for their verdicts and prints the throughput the scanner
+achieved. The scanner must download from the mock portal, i.e. run with
+PORTAL_DOMAIN set to the portal's URL and no failover portals, and its
+submission quota must allow the load.
+
+Flags:
+`
+
+// loadtestResult describes a finished load test.
+type loadtestResult struct {
+	Submitted int
+	Scanned   int
+	Failures  int
+	Bytes     uint64
+	// Elapsed is the time from the first submission to the last verdict,
+	// as recorded by the scanner.
+	Elapsed   time.Duration
+	Latencies []time.Duration
+}
+
+// loadtest runs a load test against the scanner and prints its results.
+func loadtest(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), loadtestUsage)
+		fs.PrintDefaults()
+	}
+	count := fs.Int("count", 100, "the number of skylinks to submit")
+	sizesFlag := fs.String("sizes", "64k", "comma-separated file sizes, with optional k, m or g suffixes, which the skylinks cycle through")
+	rate := fs.Float64("rate", 0, "the number of skylinks submitted per second, 0 submits them as fast as possible")
+	workers := fs.Int("workers", 4, "the number of concurrent submissions")
+	listen := fs.String("listen", ":8090", "the address the mock portal listens on")
+	portalURL := fs.String("portal-url", "", "the mock portal's URL as the scanner reaches it, defaults to http://localhost with the listening port")
+	timeout := fs.Duration("timeout", 10*time.Minute, "how long to wait for the verdicts after the last submission")
+	poll := fs.Duration("poll", time.Second, "how often to check the verdicts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	sizes, err := parseSizes(*sizesFlag)
+	if err != nil {
+		return errors.AddContext(err, "invalid -sizes")
+	}
+	if *count <= 0 || *workers <= 0 || *rate < 0 || *timeout <= 0 || *poll <= 0 {
+		return errors.New("-count, -workers, -timeout and -poll must be positive and -rate can't be negative")
+	}
+
+	portal, err := test.NewMockPortalAt(*listen)
+	if err != nil {
+		return errors.AddContext(err, "failed to start the mock portal")
+	}
+	defer portal.Close()
+	if *portalURL == "" {
+		*portalURL = advertisedURL(portal.Listener.Addr())
+	}
+	skylinks, err := loadtestSkylinks(*count)
+	if err != nil {
+		return err
+	}
+	for i, sl := range skylinks {
+		portal.SetSize(sl, sizes[i%len(sizes)])
+	}
+	fmt.Fprintf(stdout, "Serving %d synthetic skylinks at %s\n", len(skylinks), *portalURL)
+
+	submitted, submitErrs := submitLoad(ctx, c, skylinks, *rate, *workers, stdout)
+	fmt.Fprintf(stdout, "Submitted %d skylinks, %d failed\n", len(submitted), submitErrs)
+	res, err := awaitVerdicts(ctx, c, submitted, *timeout, *poll)
+	if err != nil {
+		return err
+	}
+	printLoadtestResult(stdout, res)
+	if res.Scanned < len(submitted) {
+		return fmt.Errorf("only %d of %d skylinks were scanned within %s", res.Scanned, len(submitted), *timeout)
+	}
+	return nil
+}
+
+// loadtestSkylinks returns the given number of unique v1 skylinks. They're
+// random, so repeated load tests don't hit the records of earlier ones.
+func loadtestSkylinks(n int) ([]string, error) {
+	var run [16]byte
+	if _, err := rand.Read(run[:]); err != nil {
+		return nil, errors.AddContext(err, "failed to generate skylinks")
+	}
+	skylinks := make([]string, n)
+	for i := range skylinks {
+		sl, err := skymodules.NewSkylinkV1(crypto.HashAll(run, i), 0, 4096)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to generate skylinks")
+		}
+		skylinks[i] = sl.String()
+	}
+	return skylinks, nil
+}
+
+// submitLoad submits the given skylinks at the given rate, with the given
+// number of concurrent submissions. It returns the skylinks it submitted and
+// the number of submissions which failed.
+func submitLoad(ctx context.Context, c *client.Client, skylinks []string, rate float64, workers int, stdout io.Writer) ([]string, int) {
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		var tick <-chan time.Time
+		if rate > 0 {
+			t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer t.Stop()
+			tick = t.C
+		}
+		for _, sl := range skylinks {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case queue <- sl:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var submitted []string
+	var failed int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sl := range queue {
+				_, err := c.Submit(ctx, sl)
+				mu.Lock()
+				if err != nil {
+					// Only print the first failure, the others are
+					// likely the same.
+					if failed == 0 {
+						fmt.Fprintf(stdout, "Failed to submit %s: %v\n", sl, err)
+					}
+					failed++
+				} else {
+					submitted = append(submitted, sl)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return submitted, failed
+}
+
+// awaitVerdicts polls the status of the given skylinks until they all have a
+// verdict or the timeout passes.
+func awaitVerdicts(ctx context.Context, c *client.Client, skylinks []string, timeout, poll time.Duration) (loadtestResult, error) {
+	res := loadtestResult{Submitted: len(skylinks)}
+	deadline := time.Now().Add(timeout)
+	pending := skylinks
+	var first, last time.Time
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		var still []string
+		for start := 0; start < len(pending); start += loadtestStatusBatch {
+			end := start + loadtestStatusBatch
+			if end > len(pending) {
+				end = len(pending)
+			}
+			bs, err := c.BulkStatus(ctx, pending[start:end])
+			if err != nil {
+				return res, err
+			}
+			for _, sl := range pending[start:end] {
+				s, ok := bs.Statuses[sl]
+				if !ok || s.ScannedAt.IsZero() {
+					still = append(still, sl)
+					continue
+				}
+				res.Scanned++
+				res.Failures += s.Failures
+				res.Bytes += s.ScannedSize
+				res.Latencies = append(res.Latencies, s.ScannedAt.Sub(s.SubmittedAt))
+				if first.IsZero() || s.SubmittedAt.Before(first) {
+					first = s.SubmittedAt
+				}
+				if s.ScannedAt.After(last) {
+					last = s.ScannedAt
+				}
+			}
+		}
+		pending = still
+		if len(pending) == 0 {
+			break
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+	res.Elapsed = last.Sub(first)
+	return res, nil
+}
+
+// printLoadtestResult prints the throughput and verdict latency of a load
+// test.
+func printLoadtestResult(w io.Writer, r loadtestResult) {
+	fmt.Fprintf(w, "Scanned %d of %d skylinks in %s, %d failed attempts\n", r.Scanned, r.Submitted, r.Elapsed.Round(time.Millisecond), r.Failures)
+	if r.Elapsed <= 0 || len(r.Latencies) == 0 {
+		return
+	}
+	secs := r.Elapsed.Seconds()
+	fmt.Fprintf(w, "Throughput: %.1f scans/s, %.1f MB/s\n", float64(r.Scanned)/secs, float64(r.Bytes)/secs/1e6)
+	sort.Slice(r.Latencies, func(i, j int) bool { return r.Latencies[i] < r.Latencies[j] })
+	pct := func(p float64) time.Duration {
+		return r.Latencies[int(p*float64(len(r.Latencies)-1))].Round(time.Millisecond)
+	}
+	fmt.Fprintf(w, "Verdict latency: p50 %s, p95 %s, max %s\n", pct(0.5), pct(0.95), pct(1))
+}
+
+// advertisedURL returns the URL under which the scanner reaches a server
+// listening on the given address, assuming it runs on the same host unless
+// the server listens on a specific address.
+func advertisedURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "http://" + addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return (&url.URL{Scheme: "http", Host: net.JoinHostPort(host, port)}).String()
+}
+
+// parseSizes parses a comma-separated list of sizes in bytes, with optional
+// k, m or g suffixes for KiB, MiB and GiB.
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		mult := 1
+		switch item[len(item)-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			item = item[:len(item)-1]
+		}
+		n, err := strconv.Atoi(item)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid size %q", item)
+		}
+		sizes = append(sizes, n*mult)
+	}
+	if len(sizes) == 0 {
+		return nil, errors.New("empty list")
+	}
+	return sizes, nil
+}
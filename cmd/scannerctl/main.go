@@ -0,0 +1,339 @@
+// scannerctl is a command line client of the malware scanner's API, so
+// operators don't need to remember curl invocations.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SkynetLabs/malware-scanner/client"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// defaultURL is the scanner's URL when neither -url nor MALWARE_SCANNER_URL is
+// set.
+const defaultURL = "http://localhost:4000"
+
+// usage describes the available commands.
+const usage = `Usage: scannerctl [-url URL] [-key KEY] <command> [arguments]
+
+Commands:
+  submit <skylink>...      add skylinks to the scanning queue
+  submit -f <file>         add the skylinks in the file, one per line ("-" for stdin)
+  submit-root <root> <length>
+                           add the first length bytes of the sector with the
+                           given merkle root to the scanning queue
+  status <skylink>...      print the scanning status of skylinks
+  stats [-hours N]         print the scanner's stats over the last N hours
+  pause                    pause scanning (admin)
+  resume                   resume scanning (admin)
+  purge <skylink>          remove the record of a skylink (admin)
+  export [-o <file>] <skylink>...
+                           export the records of skylinks with their history
+                           as JSON (admin)
+  import [<file>]          import exported records, from stdin if no file is
+                           given (admin, dev environments only)
+  backfill [-id <id>] [-batch N] <file>
+                           add the skylinks in the file to the queue at low
+                           priority, tracking the progress in a backfill which
+                           -id resumes (admin)
+  backfill-status <id>     print the progress of a backfill (admin)
+  campaign [flags]         re-scan the records which match the given criteria
+                           at low priority, see campaign -h (admin)
+  campaign-status <id>     print the progress and results of a re-scan
+                           campaign (admin)
+  reviews [-limit N]       print the detections pending review, the most severe
+                           first (admin)
+  approve <hash>           report a detection pending review to blocker (admin)
+  reject <hash>            mark a detection pending review as a false positive
+                           (admin)
+  loadtest [flags]         submit synthetic skylinks and print the achieved
+                           scans/sec, see loadtest -h
+
+The URL and admin key default to the MALWARE_SCANNER_URL and
+MALWARE_SCANNER_ADMIN_KEY env vars.
+
+Flags:
+`
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout)
+	if errors.Contains(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by the arguments.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("scannerctl", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	baseURL := fs.String("url", envOr("MALWARE_SCANNER_URL", defaultURL), "the scanner's URL")
+	key := fs.String("key", os.Getenv("MALWARE_SCANNER_ADMIN_KEY"), "the admin key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+	c := client.New(*baseURL, client.Options{AdminKey: *key})
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "submit":
+		return submit(ctx, c, args, stdin, stdout)
+	case "submit-root":
+		return submitRoot(ctx, c, args, stdout)
+	case "status":
+		return status(ctx, c, args, stdout)
+	case "stats":
+		return stats(ctx, c, args, stdout)
+	case "pause":
+		return c.Pause(ctx)
+	case "resume":
+		return c.Resume(ctx)
+	case "purge":
+		if len(args) != 1 {
+			return errors.New("usage: scannerctl purge <skylink>")
+		}
+		return c.Purge(ctx, args[0])
+	case "export":
+		return export(ctx, c, args, stdin, stdout)
+	case "import":
+		return importRecords(ctx, c, args, stdin, stdout)
+	case "backfill":
+		return backfill(ctx, c, args, stdin, stdout)
+	case "backfill-status":
+		return backfillStatus(ctx, c, args, stdout)
+	case "campaign":
+		return campaign(ctx, c, args, stdout)
+	case "campaign-status":
+		return campaignStatus(ctx, c, args, stdout)
+	case "reviews":
+		return reviews(ctx, c, args, stdout)
+	case "approve", "reject":
+		return review(ctx, c, cmd, args, stdout)
+	case "loadtest":
+		return loadtest(ctx, c, args, stdout)
+	default:
+		fs.Usage()
+		return errors.New("unknown command: " + cmd)
+	}
+}
+
+// submit adds the given skylinks, or those listed in a file, to the scanning
+// queue. It prints the status of each skylink and keeps going when a skylink
+// fails.
+func submit(ctx context.Context, c *client.Client, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("submit", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	file := fs.String("f", "", "a file listing skylinks, one per line, or \"-\" for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	skylinks, err := skylinkArgs(fs.Args(), *file, stdin)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, sl := range skylinks {
+		s, err := c.Submit(ctx, sl)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "%s\terror: %v\n", sl, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\t%s\n", sl, s)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to submit %d of %d skylinks", failed, len(skylinks))
+	}
+	return nil
+}
+
+// submitRoot adds the sector with the given merkle root to the scanning queue
+// and prints the submission as JSON, including the skylink built from the
+// root.
+func submitRoot(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errors.New("usage: scannerctl submit-root <root> <length>")
+	}
+	length, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return errors.AddContext(err, "invalid length")
+	}
+	rs, err := c.SubmitRoot(ctx, args[0], length)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, rs)
+}
+
+// status prints the scanning status of the given skylinks as JSON.
+func status(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: scannerctl status <skylink>...")
+	}
+	if len(args) == 1 {
+		sl, err := c.Status(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return printJSON(stdout, sl)
+	}
+	bs, err := c.BulkStatus(ctx, args)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, bs)
+}
+
+// stats prints the scanner's stats as JSON.
+func stats(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	hours := fs.Int("hours", 0, "the number of hours to report on, defaults to the scanner's default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := c.Stats(ctx, *hours)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, s)
+}
+
+// export prints the records of the given skylinks, or those listed in a file,
+// together with their history as JSON, or writes them to a file.
+func export(ctx context.Context, c *client.Client, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	file := fs.String("f", "", "a file listing skylinks, one per line, or \"-\" for stdin")
+	out := fs.String("o", "", "the file to write the export to, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	skylinks, err := skylinkArgs(fs.Args(), *file, stdin)
+	if err != nil {
+		return err
+	}
+	s, err := c.Export(ctx, skylinks)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return printJSON(stdout, s)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return errors.AddContext(err, "failed to create export file")
+	}
+	err = printJSON(f, s)
+	if err != nil {
+		_ = f.Close()
+		return errors.AddContext(err, "failed to write export file")
+	}
+	if err = f.Close(); err != nil {
+		return errors.AddContext(err, "failed to write export file")
+	}
+	fmt.Fprintf(stdout, "Exported %d of %d skylinks to %s\n", len(s.Records), len(skylinks), *out)
+	return nil
+}
+
+// importRecords imports the records of an export read from the given file, or
+// stdin if none is given.
+func importRecords(ctx context.Context, c *client.Client, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) > 1 {
+		return errors.New("usage: scannerctl import [<file>]")
+	}
+	r := stdin
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return errors.AddContext(err, "failed to open export file")
+		}
+		defer f.Close()
+		r = f
+	}
+	var s database.Snapshot
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return errors.AddContext(err, "failed to read export")
+	}
+	n, err := c.Import(ctx, &s)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Imported %d records\n", n)
+	return nil
+}
+
+// skylinkArgs returns the given skylinks together with those listed in the
+// given file, if any, which is read from stdin if it's "-".
+func skylinkArgs(skylinks []string, file string, stdin io.Reader) ([]string, error) {
+	if file != "" {
+		r := stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, errors.AddContext(err, "failed to open skylinks file")
+			}
+			defer f.Close()
+			r = f
+		}
+		fromFile, err := readSkylinks(r)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to read skylinks file")
+		}
+		skylinks = append(skylinks, fromFile...)
+	}
+	if len(skylinks) == 0 {
+		return nil, errors.New("no skylinks given")
+	}
+	return skylinks, nil
+}
+
+// readSkylinks reads skylinks from r, one per line. Empty lines and lines
+// starting with "#" are ignored.
+func readSkylinks(r io.Reader) ([]string, error) {
+	var skylinks []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		skylinks = append(skylinks, line)
+	}
+	return skylinks, sc.Err()
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// envOr returns the value of the given env var or def if it's not set.
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return def
+}
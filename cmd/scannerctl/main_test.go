@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/client"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// scannerURL is the URL of the mocked scanner.
+const scannerURL = "http://scanner:4000"
+
+// testSkylink is a valid skylink used in the tests.
+const testSkylink = "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+
+// TestSubmitFromFile ensures skylinks are read from stdin, skipping comments,
+// and submitted.
+func TestSubmitFromFile(t *testing.T) {
+	defer gock.Off()
+	gock.New(scannerURL).
+		Post("/scan/" + testSkylink).
+		Reply(http.StatusOK).
+		JSON(map[string]string{"status": "queued"})
+	gock.New(scannerURL).
+		Post("/scan/invalid").
+		Reply(http.StatusBadRequest).
+		JSON(map[string]string{"message": "invalid skylink"})
+
+	stdin := strings.NewReader("# skylinks\n" + testSkylink + "\n\ninvalid\n")
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"-url", scannerURL, "submit", "-f", "-"}, stdin, &out)
+	if err == nil || !strings.Contains(err.Error(), "failed to submit 1 of 2 skylinks") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(out.String(), testSkylink+"\tqueued") || !strings.Contains(out.String(), "invalid\terror:") {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestSubmitRoot ensures sectors are submitted by merkle root and length.
+func TestSubmitRoot(t *testing.T) {
+	defer gock.Off()
+	root := "82a925be13a9d970a4bda34ed67c8e5be179a499e39895b15ff081d62a317ec8"
+	gock.New(scannerURL).
+		Post("/scanroot/"+root).
+		MatchParam("length", "4096").
+		Reply(http.StatusOK).
+		JSON(map[string]string{"status": "queued", "skylink": testSkylink, "hash": root})
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"-url", scannerURL, "submit-root", root, "4096"}, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rs client.RootSubmission
+	if err = json.Unmarshal(out.Bytes(), &rs); err != nil || rs.Skylink != testSkylink {
+		t.Fatalf("Unexpected output %s, error %v", out.String(), err)
+	}
+	err = run(context.Background(), []string{"-url", scannerURL, "submit-root", root, "big"}, nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "invalid length") {
+		t.Fatalf("Expected an invalid length, got %v", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestAdminCommands ensures the admin commands send the admin key.
+func TestAdminCommands(t *testing.T) {
+	defer gock.Off()
+	gock.New(scannerURL).
+		Post("/admin/pause").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusNoContent)
+	gock.New(scannerURL).
+		Delete("/admin/skylink/"+testSkylink).
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusNoContent)
+
+	for _, args := range [][]string{{"pause"}, {"purge", testSkylink}} {
+		args = append([]string{"-url", scannerURL, "-key", "secret"}, args...)
+		if err := run(context.Background(), args, nil, &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+	if err := run(context.Background(), []string{"-url", scannerURL, "frobnicate"}, nil, &bytes.Buffer{}); err == nil {
+		t.Fatal("Expected an error for an unknown command")
+	}
+}
+
+// TestExportImport ensures an export written to a file can be imported again
+// unchanged.
+func TestExportImport(t *testing.T) {
+	defer gock.Off()
+	snapshot := database.Snapshot{
+		ExportedAt: time.Date(2021, 12, 1, 10, 20, 30, 0, time.UTC),
+		Records: []database.RecordSnapshot{{
+			Record:        database.Skylink{Skylink: testSkylink, Status: database.SkylinkStatusComplete},
+			RescanSkylink: testSkylink,
+			Audit:         []database.AuditEntry{{Caller: "alice", Action: "rescan"}},
+		}},
+	}
+	gock.New(scannerURL).
+		Post("/admin/export").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(snapshot)
+	var imported database.Snapshot
+	gock.New(scannerURL).
+		Post("/admin/import").
+		MatchHeader("Authorization", "Bearer secret").
+		AddMatcher(func(r *http.Request, _ *gock.Request) (bool, error) {
+			return true, json.NewDecoder(r.Body).Decode(&imported)
+		}).
+		Reply(http.StatusOK).
+		JSON(map[string]int{"imported": 1})
+
+	file := filepath.Join(t.TempDir(), "export.json")
+	args := []string{"-url", scannerURL, "-key", "secret"}
+	var out bytes.Buffer
+	err := run(context.Background(), append(args, "export", "-o", file, testSkylink), nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Exported 1 of 1 skylinks") {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	out.Reset()
+	err = run(context.Background(), append(args, "import", file), nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Imported 1 records") {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	if !reflect.DeepEqual(imported, snapshot) {
+		t.Fatalf("Expected the import %+v, got %+v", snapshot, imported)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestBackfill ensures backfill enqueues a file in batches and resumes an
+// interrupted backfill where it left off.
+func TestBackfill(t *testing.T) {
+	defer gock.Off()
+	const id = "61a74c46a1b2c3d4e5f60718"
+	job := func(offset, enqueued int) map[string]interface{} {
+		return map[string]interface{}{"id": id, "source": "skylinks.txt", "total": 3, "offset": offset, "enqueued": enqueued}
+	}
+	file := filepath.Join(t.TempDir(), "skylinks.txt")
+	err := os.WriteFile(file, []byte(testSkylink+"\n# comment\nsecond\n\nthird\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gock.New(scannerURL).
+		Post("/admin/backfills").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{"source": file, "total": 3}).
+		Reply(http.StatusOK).
+		JSON(job(0, 0))
+	gock.New(scannerURL).
+		Post("/admin/backfills/" + id + "/skylinks").
+		JSON(map[string]interface{}{"from": 0, "to": 2, "failed": 0, "skylinks": []string{testSkylink, "second"}}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"job": job(2, 1), "invalid": []string{"second"}})
+	gock.New(scannerURL).
+		Post("/admin/backfills/" + id + "/skylinks").
+		Reply(http.StatusConflict).
+		JSON(map[string]string{"message": "the batch doesn't start at the backfill's offset 3"})
+	args := []string{"-url", scannerURL, "-key", "secret"}
+	var out bytes.Buffer
+	err = run(context.Background(), append(args, "backfill", "-batch", "2", file), nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "resume with: scannerctl backfill -id "+id) {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(out.String(), "second\tinvalid") || !strings.Contains(out.String(), "Enqueued 1 of 3 skylinks") {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+
+	gock.New(scannerURL).
+		Get("/admin/backfills/" + id).
+		Reply(http.StatusOK).
+		JSON(job(2, 1))
+	gock.New(scannerURL).
+		Post("/admin/backfills/" + id + "/skylinks").
+		JSON(map[string]interface{}{"from": 2, "to": 3, "failed": 0, "skylinks": []string{"third"}}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"job": job(3, 2), "invalid": []string{}})
+	out.Reset()
+	err = run(context.Background(), append(args, "backfill", "-id", id, "-batch", "2", file), nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Resuming backfill "+id+" at 2 of 3") || !strings.Contains(out.String(), "Enqueued 2 of 3 skylinks") {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestCampaign ensures campaign sends the given criteria.
+func TestCampaign(t *testing.T) {
+	defer gock.Off()
+	gock.New(scannerURL).
+		Post("/admin/campaigns").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{"scannedBefore": "2022-03-01T00:00:00Z", "minSize": 1024, "infected": false}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"id": "61a74c46a1b2c3d4e5f60718", "queued": 3})
+
+	args := []string{"-url", scannerURL, "-key", "secret", "campaign", "-scanned-before", "2022-03-01", "-min-size", "1024", "-infected", "false"}
+	var out bytes.Buffer
+	if err := run(context.Background(), args, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"queued": 3`) {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+	args = []string{"-url", scannerURL, "campaign", "-infected", "maybe"}
+	if err := run(context.Background(), args, nil, &bytes.Buffer{}); err == nil {
+		t.Fatal("Expected an error for an invalid -infected")
+	}
+}
+
+// TestReview ensures detections pending review are listed, approved and
+// rejected by their hash.
+func TestReview(t *testing.T) {
+	defer gock.Off()
+	hash := "ffbb3ed32667fe423f27d6d1dc89194b454ec411d402988f3edc2a7f9b2ce6e4"
+	gock.New(scannerURL).
+		Get("/admin/reviews").
+		MatchParam("limit", "5").
+		MatchHeader("Authorization", "Bearer secret").
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"reviews": []map[string]interface{}{{"hash": hash, "status": "pending_review", "severity": 40}}})
+	gock.New(scannerURL).
+		Post("/admin/reviews/" + hash + "/approve").
+		Reply(http.StatusOK).
+		JSON(map[string]string{"hash": hash, "status": "unreported"})
+	gock.New(scannerURL).
+		Post("/admin/reviews/" + hash + "/reject").
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{"hash": hash, "status": "complete", "falsePositive": true})
+
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"-url", scannerURL, "-key", "secret", "reviews", "-limit", "5"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"severity": 40`) {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	out.Reset()
+	if err := run(context.Background(), []string{"-url", scannerURL, "approve", hash}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"status": "unreported"`) {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	out.Reset()
+	if err := run(context.Background(), []string{"-url", scannerURL, "reject", hash}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"falsePositive": true`) {
+		t.Fatalf("Unexpected output %s", out.String())
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+	if err := run(context.Background(), []string{"-url", scannerURL, "approve"}, nil, &bytes.Buffer{}); err == nil {
+		t.Fatal("Expected an error without a hash")
+	}
+}
+
+// TestLoadtest ensures the load test serves the skylinks it submits from its
+// mock portal and reports the scanner's throughput once they're all scanned.
+func TestLoadtest(t *testing.T) {
+	// Pick a free port for the mock portal, so the stub scanner knows where
+	// to download from.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	portalAddr := l.Addr().String()
+	_ = l.Close()
+
+	// The stub scanner downloads every submitted skylink right away and
+	// takes 10ms per scan.
+	var mu sync.Mutex
+	start := time.Now()
+	scanned := make(map[string]database.Skylink)
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/scan/"):
+			skylink := strings.TrimPrefix(r.URL.Path, "/scan/")
+			resp, err := http.Get("http://" + portalAddr + "/" + skylink)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			n, _ := io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			mu.Lock()
+			submittedAt := start.Add(time.Duration(len(scanned)) * 10 * time.Millisecond)
+			scanned[skylink] = database.Skylink{
+				Status:      database.SkylinkStatusComplete,
+				ScannedSize: uint64(n),
+				SubmittedAt: submittedAt,
+				ScannedAt:   submittedAt.Add(10 * time.Millisecond),
+			}
+			mu.Unlock()
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "queued"})
+		case r.Method == http.MethodPost && r.URL.Path == "/status":
+			var req struct {
+				Skylinks []string `json:"skylinks"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			resp := client.BulkStatus{Statuses: make(map[string]database.Skylink)}
+			mu.Lock()
+			for _, sl := range req.Skylinks {
+				resp.Statuses[sl] = scanned[sl]
+			}
+			mu.Unlock()
+			_ = json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer scanner.Close()
+
+	var out bytes.Buffer
+	args := []string{"-url", scanner.URL, "loadtest", "-count", "10", "-sizes", "1k,2k", "-listen", portalAddr, "-poll", "10ms"}
+	if err := run(context.Background(), args, nil, &out); err != nil {
+		t.Fatal(err, out.String())
+	}
+	var total uint64
+	for _, sl := range scanned {
+		total += sl.ScannedSize
+	}
+	if len(scanned) != 10 || total != 5*1024+5*2048 {
+		t.Fatalf("Expected 10 skylinks of 1k and 2k to be downloaded, got %d skylinks of %d bytes", len(scanned), total)
+	}
+	for _, expected := range []string{"Serving 10 synthetic skylinks at http://" + portalAddr, "Scanned 10 of 10 skylinks in 100ms", "Throughput: 100.0 scans/s", "Verdict latency: p50 10ms"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("Expected the output to contain '%s', got %s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+
+	"github.com/SkynetLabs/malware-scanner/client"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// reviews prints the detections which are pending review as JSON.
+func reviews(ctx context.Context, c *client.Client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("reviews", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	limit := fs.Int("limit", 0, "the maximum number of detections to print, the scanner's default if 0")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: scannerctl reviews [-limit N]")
+	}
+	sls, err := c.Reviews(ctx, *limit)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, sls)
+}
+
+// review approves or rejects the detection of the record with the given hash,
+// which is pending review, and prints the updated record as JSON.
+func review(ctx context.Context, c *client.Client, cmd string, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: scannerctl " + cmd + " <hash>")
+	}
+	decide := c.ApproveReview
+	if cmd == "reject" {
+		decide = c.RejectReview
+	}
+	sl, err := decide(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, sl)
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// AbuseReport is a single abuse report parsed by the abuse-scanner from
+	// an email sent to the portal's abuse mailbox.
+	AbuseReport struct {
+		EmailID    primitive.ObjectID
+		InsertedAt time.Time
+		Reporter   string
+		Skylinks   []string
+	}
+
+	// AbuseCursor marks the last abuse-scanner email we processed. Emails are
+	// ordered by the time they were received and then by their ID, since
+	// several of them can be received at the same time.
+	AbuseCursor struct {
+		InsertedAt time.Time
+		EmailID    primitive.ObjectID
+	}
+
+	// abuseEmail is the part of the abuse-scanner's email records we use.
+	abuseEmail struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		InsertedAt    time.Time          `bson:"inserted_at"`
+		ParsedReports []struct {
+			Skylinks []string `bson:"skylinks"`
+			Reporter struct {
+				Name  string `bson:"name"`
+				Email string `bson:"email"`
+			} `bson:"reporter"`
+		} `bson:"parsed_reports"`
+	}
+)
+
+// AbuseReports returns the abuse reports the abuse-scanner parsed from up to
+// limit emails it received after the given cursor, oldest first. It also
+// returns the cursor of the newest of these emails, so the caller can continue
+// from there, or the given cursor if there are no new emails. The
+// abuse-scanner stores its emails in the given database and collection on the
+// same MongoDB cluster.
+func (db *DB) AbuseReports(ctx context.Context, abuseDB, collection string, after AbuseCursor, limit int) ([]AbuseReport, AbuseCursor, error) {
+	filter := bson.M{
+		"parsed": true,
+		"$or": bson.A{
+			bson.M{"inserted_at": bson.M{"$gt": after.InsertedAt}},
+			bson.M{"inserted_at": after.InsertedAt, "_id": bson.M{"$gt": after.EmailID}},
+		},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"inserted_at", 1}, {"_id", 1}}).
+		SetProjection(bson.M{"inserted_at": 1, "parsed_reports": 1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	coll := db.staticDB.Client().Database(abuseDB).Collection(collection)
+	c, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, after, errors.AddContext(err, "failed to fetch abuse reports")
+	}
+	var emails []abuseEmail
+	err = c.All(ctx, &emails)
+	if err != nil {
+		return nil, after, errors.AddContext(err, "failed to decode abuse reports")
+	}
+	var reports []AbuseReport
+	for _, e := range emails {
+		after = AbuseCursor{InsertedAt: e.InsertedAt, EmailID: e.ID}
+		for _, pr := range e.ParsedReports {
+			reporter := pr.Reporter.Email
+			if reporter == "" {
+				reporter = pr.Reporter.Name
+			}
+			reports = append(reports, AbuseReport{
+				EmailID:    e.ID,
+				InsertedAt: e.InsertedAt,
+				Reporter:   reporter,
+				Skylinks:   pr.Skylinks,
+			})
+		}
+	}
+	return reports, after, nil
+}
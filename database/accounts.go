@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	accdb "github.com/SkynetLabs/skynet-accounts/database"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collAccountsSkylinks is the name of skynet-accounts' skylinks
+	// collection.
+	collAccountsSkylinks = "skylinks"
+	// collAccountsUploads is the name of skynet-accounts' uploads collection.
+	collAccountsUploads = "uploads"
+	// collAccountsUsers is the name of skynet-accounts' users collection.
+	collAccountsUsers = "users"
+)
+
+type (
+	// Uploader describes the uploads of a skylink by a single portal user,
+	// as recorded by skynet-accounts. A zero UserID groups all anonymous
+	// uploads.
+	Uploader struct {
+		UserID      primitive.ObjectID `bson:"user_id,omitempty" json:"userId,omitempty"`
+		Sub         string             `bson:"sub,omitempty" json:"sub,omitempty"`
+		Email       string             `bson:"email,omitempty" json:"email,omitempty"`
+		Uploads     int                `bson:"uploads" json:"uploads"`
+		FirstUpload time.Time          `bson:"first_upload" json:"firstUpload"`
+		LastUpload  time.Time          `bson:"last_upload" json:"lastUpload"`
+	}
+
+	// RepeatUploader is a portal user who uploaded infected content.
+	RepeatUploader struct {
+		UserID           primitive.ObjectID `bson:"_id" json:"userId"`
+		Sub              string             `bson:"sub" json:"sub"`
+		Email            string             `bson:"email,omitempty" json:"email,omitempty"`
+		InfectedSkylinks int                `bson:"infected_skylinks" json:"infectedSkylinks"`
+		LastDetected     time.Time          `bson:"last_detected" json:"lastDetected"`
+	}
+)
+
+// Uploaders looks up who uploaded the given skylink in skynet-accounts'
+// database with the given name on the same MongoDB cluster. It returns the
+// uploaders with the most uploads first.
+func (db *DB) Uploaders(ctx context.Context, accountsDB, skylink string) ([]Uploader, error) {
+	hash, err := accdb.ExtractSkylinkHash(skylink)
+	if err != nil {
+		return nil, ErrInvalidSkylink
+	}
+	adb := db.staticDB.Client().Database(accountsDB)
+	var sl accdb.Skylink
+	err = adb.Collection(collAccountsSkylinks).FindOne(ctx, bson.M{"skylink": hash}).Decode(&sl)
+	if err == mongo.ErrNoDocuments {
+		// The portal has no record of this skylink being uploaded.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to look up skylink in accounts")
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"skylink_id": sl.ID}}},
+		{{"$group", bson.M{
+			"_id":          "$user_id",
+			"uploads":      bson.M{"$sum": 1},
+			"first_upload": bson.M{"$min": "$timestamp"},
+			"last_upload":  bson.M{"$max": "$timestamp"},
+		}}},
+		{{"$lookup", bson.M{
+			"from":         collAccountsUsers,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{"$project", bson.M{
+			"user_id":      "$_id",
+			"sub":          bson.M{"$arrayElemAt": bson.A{"$user.sub", 0}},
+			"email":        bson.M{"$arrayElemAt": bson.A{"$user.email", 0}},
+			"uploads":      1,
+			"first_upload": 1,
+			"last_upload":  1,
+		}}},
+		{{"$sort", bson.D{{"uploads", -1}}}},
+	}
+	c, err := adb.Collection(collAccountsUploads).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to look up uploads in accounts")
+	}
+	var uploaders []Uploader
+	err = c.All(ctx, &uploaders)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode uploads")
+	}
+	return uploaders, nil
+}
+
+// RepeatUploaders returns the portal users who uploaded the most infected
+// skylinks, with at least minInfected each.
+func (db *DB) RepeatUploaders(ctx context.Context, minInfected, limit int) ([]RepeatUploader, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"infected": true, "uploaders.sub": bson.M{"$gt": ""}}}},
+		{{"$unwind", "$uploaders"}},
+		{{"$match", bson.M{"uploaders.sub": bson.M{"$gt": ""}}}},
+		{{"$group", bson.M{
+			"_id":               "$uploaders.user_id",
+			"sub":               bson.M{"$first": "$uploaders.sub"},
+			"email":             bson.M{"$first": "$uploaders.email"},
+			"infected_skylinks": bson.M{"$sum": 1},
+			"last_detected":     bson.M{"$max": "$scanned_at"},
+		}}},
+		{{"$match", bson.M{"infected_skylinks": bson.M{"$gte": minInfected}}}},
+		{{"$sort", bson.D{{"infected_skylinks", -1}, {"last_detected", -1}}}},
+		{{"$limit", limit}},
+	}
+	c, err := db.Collection(collSkylinks).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to aggregate uploaders")
+	}
+	uploaders := []RepeatUploader{}
+	err = c.All(ctx, &uploaders)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode uploaders")
+	}
+	return uploaders, nil
+}
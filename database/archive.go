@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ForEachScanned calls fn with every skylink which received its verdict in
+// the given time range, including from but excluding to, oldest first. It
+// stops at the first error fn returns.
+func (db *DB) ForEachScanned(ctx context.Context, from, to time.Time, fn func(Skylink) error) error {
+	filter := bson.M{
+		"scanned_at": bson.M{"$gte": from, "$lt": to},
+	}
+	opts := options.Find().SetSort(bson.D{{"scanned_at", 1}})
+	c, err := db.Collection(collSkylinks).Find(ctx, filter, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch scanned skylinks")
+	}
+	defer func() { _ = c.Close(ctx) }()
+	for c.Next(ctx) {
+		var sl Skylink
+		if err = c.Decode(&sl); err != nil {
+			return errors.AddContext(err, "failed to decode scanned skylink")
+		}
+		if err = fn(sl); err != nil {
+			return err
+		}
+	}
+	return errors.AddContext(c.Err(), "failed to iterate over scanned skylinks")
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collAudit defines the name of the audit log collection
+	collAudit = "audit"
+)
+
+type (
+	// AuditEntry records a single admin action, who performed it and with
+	// what parameters. Error is empty if the action succeeded.
+	AuditEntry struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+		Caller    string             `bson:"caller" json:"caller"`
+		Action    string             `bson:"action" json:"action"`
+		Params    map[string]string  `bson:"params,omitempty" json:"params,omitempty"`
+		Error     string             `bson:"error,omitempty" json:"error,omitempty"`
+	}
+
+	// AuditFilter narrows down the audit log entries we fetch. Empty fields
+	// match all entries. Hash matches the entries about the skylink with the
+	// given hash.
+	AuditFilter struct {
+		From   time.Time
+		To     time.Time
+		Caller string
+		Action string
+		Hash   string
+		Limit  int
+	}
+)
+
+// AuditLog stores the given entry in the audit log.
+func (db *DB) AuditLog(ctx context.Context, e *AuditEntry) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = Clock.Now().UTC()
+	}
+	_, err := db.Collection(collAudit).InsertOne(ctx, e)
+	if err != nil {
+		return errors.AddContext(err, "failed to store audit log entry")
+	}
+	return nil
+}
+
+// AuditEntries returns the audit log entries matching the given filter,
+// newest first.
+func (db *DB) AuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{"timestamp", -1}})
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+	c, err := db.Collection(collAudit).Find(ctx, f.query(), opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch audit log entries")
+	}
+	entries := []AuditEntry{}
+	err = c.All(ctx, &entries)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode audit log entries")
+	}
+	return entries, nil
+}
+
+// query returns the MongoDB query matching the filter.
+func (f AuditFilter) query() bson.M {
+	q := bson.M{}
+	ts := bson.M{}
+	if !f.From.IsZero() {
+		ts["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		ts["$lt"] = f.To
+	}
+	if len(ts) > 0 {
+		q["timestamp"] = ts
+	}
+	if f.Caller != "" {
+		q["caller"] = f.Caller
+	}
+	if f.Action != "" {
+		q["action"] = f.Action
+	}
+	if f.Hash != "" {
+		q["params.hash"] = f.Hash
+	}
+	return q
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collBackfills defines the name of the collection which holds the
+	// progress of bulk backfills.
+	collBackfills = "backfills"
+)
+
+var (
+	// ErrBackfillOffset is returned when a batch of a backfill doesn't start
+	// where the backfill left off, e.g. because it was already enqueued by an
+	// interrupted run or another run is going on.
+	ErrBackfillOffset = errors.New("the batch doesn't start at the backfill's offset")
+)
+
+// BackfillJob tracks the progress of a bulk backfill of skylinks, e.g. of the
+// skylinks a portal stored before it ran the scanner. Source describes where
+// the skylinks come from, e.g. a file name, and Total is the number of
+// skylinks in it. Offset is the position in the source up to which the
+// skylinks have been enqueued, so an interrupted backfill can resume there.
+// Enqueued counts the skylinks which were added to the queue and Failed
+// those which couldn't be, e.g. because they're invalid. Completed counts the
+// enqueued skylinks which have a verdict by now. It isn't stored but counted
+// when the job is fetched.
+type BackfillJob struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Source    string             `bson:"source" json:"source"`
+	Total     int64              `bson:"total" json:"total"`
+	Offset    int64              `bson:"offset" json:"offset"`
+	Enqueued  int64              `bson:"enqueued" json:"enqueued"`
+	Failed    int64              `bson:"failed" json:"failed"`
+	Completed int64              `bson:"-" json:"completed"`
+	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updatedAt"`
+}
+
+// BackfillCreate creates a backfill job of the given number of skylinks from
+// the given source.
+func (db *DB) BackfillCreate(ctx context.Context, source string, total int64) (*BackfillJob, error) {
+	now := Clock.Now().UTC()
+	job := &BackfillJob{
+		ID:        primitive.NewObjectID(),
+		Source:    source,
+		Total:     total,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	_, err := db.Collection(collBackfills).InsertOne(ctx, job)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create backfill")
+	}
+	return job, nil
+}
+
+// Backfill fetches the backfill job with the given ID, with the number of its
+// skylinks which have a verdict by now.
+func (db *DB) Backfill(ctx context.Context, id primitive.ObjectID) (*BackfillJob, error) {
+	var job BackfillJob
+	err := db.Collection(collBackfills).FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch backfill")
+	}
+	filter := bson.M{
+		"backfill": id,
+		"status":   bson.M{"$in": []string{SkylinkStatusUnreported, SkylinkStatusPendingReview, SkylinkStatusComplete}},
+	}
+	job.Completed, err = db.Collection(collSkylinks).CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count completed skylinks")
+	}
+	return &job, nil
+}
+
+// BackfillEnqueue adds the given skylinks of the backfill with the given ID
+// to the queue, at PriorityLow, and records its progress: the batch covers the
+// source from the given offset up to the given next one and failed skylinks
+// of it couldn't be enqueued. Skylinks which already have a record keep it,
+// but they count towards the backfill too. The batch must start at the
+// backfill's offset, or ErrBackfillOffset is returned, so a batch is counted
+// once even if it's sent again after an interruption.
+func (db *DB) BackfillEnqueue(ctx context.Context, id primitive.ObjectID, sls []*Skylink, failed, from, to int64) (*BackfillJob, error) {
+	if to < from {
+		return nil, errors.New("the batch must not end before it starts")
+	}
+	var job BackfillJob
+	err := db.Collection(collBackfills).FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch backfill")
+	}
+	if job.Offset != from {
+		return &job, ErrBackfillOffset
+	}
+	now := Clock.Now().UTC()
+	if len(sls) > 0 {
+		models := make([]mongo.WriteModel, 0, len(sls))
+		for _, sl := range sls {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"hash": sl.Hash}).
+				SetUpdate(bson.M{
+					"$setOnInsert": bson.M{
+						"skylink":      encryptField(sl.Skylink),
+						"status":       SkylinkStatusNew,
+						"timestamp":    now,
+						"submitted_at": now,
+						"priority":     PriorityLow,
+						"source":       SourceBackfill,
+					},
+					"$set": bson.M{"backfill": id},
+				}).
+				SetUpsert(true))
+		}
+		// The upserts are idempotent, so enqueueing them again after an
+		// interruption before the job is updated does no harm.
+		_, err = db.Collection(collSkylinks).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to enqueue backfilled skylinks")
+		}
+	}
+	filter := bson.M{"_id": id, "offset": from}
+	update := bson.M{
+		"$set": bson.M{"offset": to, "updated_at": now},
+		"$inc": bson.M{"enqueued": int64(len(sls)), "failed": failed},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = db.Collection(collBackfills).FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		// Another run recorded the batch first.
+		return nil, ErrBackfillOffset
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to record backfill progress")
+	}
+	return &job, nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// collCampaigns defines the name of the collection which holds the
+	// re-scan campaigns.
+	collCampaigns = "campaigns"
+)
+
+type (
+	// CampaignCriteria select the records a re-scan campaign queues for
+	// scanning again. Zero values don't restrict the selection. Infected
+	// selects records by their prior verdict.
+	CampaignCriteria struct {
+		ScannedBefore *time.Time `bson:"scanned_before,omitempty" json:"scannedBefore,omitempty"`
+		MinSize       uint64     `bson:"min_size,omitempty" json:"minSize,omitempty"`
+		MaxSize       uint64     `bson:"max_size,omitempty" json:"maxSize,omitempty"`
+		Infected      *bool      `bson:"infected,omitempty" json:"infected,omitempty"`
+	}
+
+	// Campaign is a re-scan of the records which match its criteria. Queued
+	// counts the records it queued, at PriorityLow, and Skipped those which
+	// matched but couldn't be queued because we don't keep their skylink,
+	// e.g. because they're infected or fell out of the re-scan lookback.
+	// Summary isn't stored but computed when the campaign is fetched.
+	Campaign struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		Criteria  CampaignCriteria   `bson:"criteria" json:"criteria"`
+		Queued    int64              `bson:"queued" json:"queued"`
+		Skipped   int64              `bson:"skipped" json:"skipped"`
+		CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+		Summary   CampaignSummary    `bson:"-" json:"summary"`
+	}
+
+	// CampaignSummary describes the progress and results of a campaign.
+	// Pending counts the queued records which are yet to be scanned and
+	// Completed those which have a verdict again. Of those, Infected counts
+	// the infected ones, NewlyInfected the ones which were clean before and
+	// Cleared the ones which were infected before. Records which a later
+	// campaign queued again count towards that one instead.
+	CampaignSummary struct {
+		Pending       int64 `bson:"pending" json:"pending"`
+		Completed     int64 `bson:"completed" json:"completed"`
+		Infected      int64 `bson:"infected" json:"infected"`
+		NewlyInfected int64 `bson:"newly_infected" json:"newlyInfected"`
+		Cleared       int64 `bson:"cleared" json:"cleared"`
+	}
+)
+
+// filter returns the filter of the complete records which match the
+// criteria. Records marked as false positives are left out, so a re-scan
+// doesn't undo an admin's decision.
+func (c CampaignCriteria) filter() bson.M {
+	filter := bson.M{
+		"status":         SkylinkStatusComplete,
+		"false_positive": bson.M{"$ne": true},
+	}
+	if c.ScannedBefore != nil {
+		filter["scanned_at"] = bson.M{"$lt": *c.ScannedBefore}
+	}
+	size := bson.M{}
+	if c.MinSize > 0 {
+		size["$gte"] = c.MinSize
+	}
+	if c.MaxSize > 0 {
+		size["$lte"] = c.MaxSize
+	}
+	if len(size) > 0 {
+		filter["size"] = size
+	}
+	if c.Infected != nil {
+		filter["infected"] = *c.Infected
+	}
+	return filter
+}
+
+// CampaignCreate starts a re-scan campaign with the given criteria. It queues
+// the matching records which kept their skylink for re-scans, at
+// PriorityLow, and tags them with the campaign and their prior verdict.
+func (db *DB) CampaignCreate(ctx context.Context, criteria CampaignCriteria) (*Campaign, error) {
+	now := Clock.Now().UTC()
+	c := &Campaign{
+		ID:        primitive.NewObjectID(),
+		Criteria:  criteria,
+		CreatedAt: now,
+	}
+	_, err := db.Collection(collCampaigns).InsertOne(ctx, c)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create campaign")
+	}
+	filter := criteria.filter()
+	matched, err := db.Collection(collSkylinks).CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count campaign records")
+	}
+	filter["rescan_skylink"] = bson.M{"$gt": ""}
+	// Use an update pipeline, so we can copy the skylink over from
+	// rescan_skylink and keep the prior verdict.
+	update := mongo.Pipeline{
+		{{"$set", bson.M{
+			"skylink":        "$rescan_skylink",
+			"status":         SkylinkStatusNew,
+			"timestamp":      now,
+			"submitted_at":   now,
+			"priority":       PriorityLow,
+			"campaign":       c.ID,
+			"prior_infected": "$infected",
+			"source":         SourceCampaign,
+		}}},
+		{{"$unset", "submitter"}},
+	}
+	res, err := db.Collection(collSkylinks).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to queue campaign records")
+	}
+	c.Queued = res.ModifiedCount
+	c.Skipped = matched - c.Queued
+	if c.Skipped < 0 {
+		// Records were scanned between counting and queueing them.
+		c.Skipped = 0
+	}
+	_, err = db.Collection(collCampaigns).UpdateOne(ctx, bson.M{"_id": c.ID}, bson.M{"$set": bson.M{
+		"queued":  c.Queued,
+		"skipped": c.Skipped,
+	}})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to record campaign counts")
+	}
+	return c, nil
+}
+
+// Campaign fetches the campaign with the given ID, with the summary of its
+// progress and results.
+func (db *DB) Campaign(ctx context.Context, id primitive.ObjectID) (*Campaign, error) {
+	var c Campaign
+	err := db.Collection(collCampaigns).FindOne(ctx, bson.M{"_id": id}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch campaign")
+	}
+	count := func(cond interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	completed := bson.M{"$in": bson.A{"$status", bson.A{SkylinkStatusUnreported, SkylinkStatusPendingReview, SkylinkStatusComplete}}}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"campaign": id}}},
+		{{"$group", bson.M{
+			"_id":            nil,
+			"pending":        count(bson.M{"$in": bson.A{"$status", bson.A{SkylinkStatusNew, SkylinkStatusScanning}}}),
+			"completed":      count(completed),
+			"infected":       count(bson.M{"$and": bson.A{completed, "$infected"}}),
+			"newly_infected": count(bson.M{"$and": bson.A{completed, "$infected", bson.M{"$not": bson.A{"$prior_infected"}}}}),
+			"cleared":        count(bson.M{"$and": bson.A{completed, bson.M{"$not": bson.A{"$infected"}}, "$prior_infected"}}),
+		}}},
+	}
+	cur, err := db.Collection(collSkylinks).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to summarize campaign")
+	}
+	var summaries []CampaignSummary
+	err = cur.All(ctx, &summaries)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode campaign summary")
+	}
+	if len(summaries) > 0 {
+		c.Summary = summaries[0]
+	}
+	return &c, nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// collConfirmations defines the name of the collection which holds the
+	// destructive admin actions waiting for confirmation.
+	collConfirmations = "admin_confirmations"
+)
+
+// Confirmation is a destructive admin action which is waiting to be
+// confirmed. ID is the hash of the confirmation token, so the stored
+// confirmations can't be used to confirm actions. The action is identified by
+// the method, path and canonical query of its request, so a confirmation can't
+// be used for the same action with other parameters. Requester is the admin
+// who requested it.
+type Confirmation struct {
+	ID        string    `bson:"_id"`
+	Action    string    `bson:"action"`
+	Method    string    `bson:"method"`
+	Path      string    `bson:"path"`
+	Query     string    `bson:"query"`
+	Requester string    `bson:"requester"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// RequestConfirmation stores the given action as waiting for confirmation.
+func (db *DB) RequestConfirmation(ctx context.Context, c Confirmation) error {
+	c.ExpiresAt = c.ExpiresAt.UTC()
+	_, err := db.Collection(collConfirmations).InsertOne(ctx, c)
+	if err != nil {
+		return errors.AddContext(err, "failed to store confirmation")
+	}
+	return nil
+}
+
+// Confirm consumes the confirmation with the given ID of the action with the
+// given method, path and query, so it can only be used once. Unless excludeRequester
+// is empty, the confirmation must have been requested by someone else. It
+// returns the confirmation, or ErrNoDocumentsFound if there's no matching one
+// which hasn't expired.
+func (db *DB) Confirm(ctx context.Context, id, method, path, query, excludeRequester string) (*Confirmation, error) {
+	filter := bson.M{
+		"_id":        id,
+		"method":     method,
+		"path":       path,
+		"query":      query,
+		"expires_at": bson.M{"$gt": Clock.Now().UTC()},
+	}
+	if excludeRequester != "" {
+		filter["requester"] = bson.M{"$ne": excludeRequester}
+	}
+	var c Confirmation
+	err := db.Collection(collConfirmations).FindOneAndDelete(ctx, filter).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to confirm")
+	}
+	return &c, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collCursors defines the name of the collection which holds the progress
+	// of our periodic exports.
+	collCursors = "cursors"
+)
+
+// Cursor returns the time up to which the periodic job with the given name
+// has processed records. It returns the zero time if the job hasn't run yet.
+func (db *DB) Cursor(ctx context.Context, name string) (time.Time, error) {
+	var c struct {
+		Time time.Time `bson:"time"`
+	}
+	err := db.Collection(collCursors).FindOne(ctx, bson.M{"_id": name}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "failed to fetch cursor "+name)
+	}
+	return c.Time, nil
+}
+
+// SetCursor stores the time up to which the periodic job with the given name
+// has processed records.
+func (db *DB) SetCursor(ctx context.Context, name string, t time.Time) error {
+	opts := options.Update().SetUpsert(true)
+	_, err := db.Collection(collCursors).UpdateOne(ctx, bson.M{"_id": name}, bson.M{"$set": bson.M{"time": t}}, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to store cursor "+name)
+	}
+	return nil
+}
+
+// InfectedSince returns up to limit infected skylinks which received their
+// verdict after the given time, oldest first. Overridden false positives are
+// left out.
+func (db *DB) InfectedSince(ctx context.Context, since time.Time, limit int) ([]Skylink, error) {
+	filter := bson.M{
+		"infected":   true,
+		"scanned_at": bson.M{"$gt": since},
+	}
+	opts := options.Find().SetSort(bson.D{{"scanned_at", 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	c, err := db.Collection(collSkylinks).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch infected skylinks")
+	}
+	var sls []Skylink
+	err = c.All(ctx, &sls)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode infected skylinks")
+	}
+	return sls, nil
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/SkynetLabs/malware-scanner/clock"
 	"github.com/SkynetLabs/skynet-accounts/build"
 	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/sirupsen/logrus"
@@ -41,6 +42,10 @@ var (
 	// and it already exists there.
 	ErrSkylinkExists = errors.New("skylink already exists")
 
+	// Clock provides the current time for the timestamps we store and the
+	// deadlines we query by. Tests replace it with a fake clock.
+	Clock = clock.New()
+
 	// True is a helper value, so we can pass a *bool to MongoDB's methods.
 	True = true
 
@@ -53,8 +58,9 @@ var (
 // DB holds a connection to the database, as well as helpful shortcuts to
 // collections and utilities.
 type DB struct {
-	staticDB     *mongo.Database
-	staticLogger *logrus.Logger
+	staticDB           *mongo.Database
+	staticLogger       *logrus.Logger
+	staticVerdictCache *verdictMemCache
 }
 
 // New creates a new database connection.
@@ -88,6 +94,7 @@ func New(ctx context.Context, creds database.DBCredentials, logger *logrus.Logge
 	return &DB{
 		db,
 		logger,
+		newVerdictMemCache(),
 	}, nil
 }
 
@@ -121,6 +128,9 @@ func (db *DB) Ping(ctx context.Context) error {
 // database.
 func (db *DB) Skylink(ctx context.Context, hash crypto.Hash) (*Skylink, error) {
 	sr := db.Collection(collSkylinks).FindOne(ctx, bson.M{"hash": hash})
+	if sr.Err() == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
 	if sr.Err() != nil {
 		return nil, sr.Err()
 	}
@@ -132,6 +142,21 @@ func (db *DB) Skylink(ctx context.Context, hash crypto.Hash) (*Skylink, error) {
 	return &sl, nil
 }
 
+// Skylinks fetches the DB records of the given skylinks. Skylinks without a
+// record are left out of the result.
+func (db *DB) Skylinks(ctx context.Context, hashes []crypto.Hash) ([]Skylink, error) {
+	c, err := db.Collection(collSkylinks).Find(ctx, bson.M{"hash": bson.M{"$in": hashes}})
+	if err != nil {
+		return nil, err
+	}
+	var sls []Skylink
+	err = c.All(ctx, &sls)
+	if err != nil {
+		return nil, err
+	}
+	return sls, nil
+}
+
 // SkylinkByID fetches the DB record that corresponds to the given skylink by
 // its DB ID.
 func (db *DB) SkylinkByID(ctx context.Context, id primitive.ObjectID) (*Skylink, error) {
@@ -158,30 +183,344 @@ func (db *DB) SkylinkCreate(ctx context.Context, skylink *Skylink) error {
 	return err
 }
 
-// SkylinkSave saves the given Skylink record to the database.
+// SkylinkSave replaces the stored record of the given skylink with it. It
+// returns ErrInvalidTransition if there's no stored record whose status can
+// move to the given record's status.
 func (db *DB) SkylinkSave(ctx context.Context, skylink *Skylink) error {
-	filter := bson.M{"_id": skylink.ID}
-	opts := &options.ReplaceOptions{
-		Upsert: &True,
+	filter := bson.M{
+		"_id":    skylink.ID,
+		"status": transitionFilter(skylink.Status),
 	}
-	_, err := db.Collection(collSkylinks).ReplaceOne(ctx, filter, skylink, opts)
+	ur, err := db.Collection(collSkylinks).ReplaceOne(ctx, filter, skylink)
 	if err != nil {
 		return errors.AddContext(err, "failed to save")
 	}
+	if ur.MatchedCount == 0 {
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+// SkylinkSaveVerdict records the verdict of the given locked skylink and
+// unlocks it. Unlike SkylinkSave, it only sets the fields of the verdict, so it
+// sends a fraction of the document and it keeps the changes other requests
+// made while the skylink was being scanned, e.g. a raised priority. It returns
+// ErrStatusChanged if the skylink isn't locked anymore.
+func (db *DB) SkylinkSaveVerdict(ctx context.Context, sl *Skylink) error {
+	if sl.Status != SkylinkStatusUnreported && sl.Status != SkylinkStatusPendingReview && sl.Status != SkylinkStatusComplete {
+		return errors.AddContext(ErrInvalidTransition, "a verdict can't leave a skylink "+sl.Status)
+	}
+	ur, err := db.Collection(collSkylinks).UpdateOne(ctx, lockedFilter(sl), verdictUpdate(sl))
+	if err != nil {
+		return errors.AddContext(err, "failed to save verdict")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// SkylinkSaveFailure records the failure of the scan of the given locked
+// skylink and unlocks it for another attempt. It returns ErrStatusChanged if the
+// skylink isn't locked anymore.
+func (db *DB) SkylinkSaveFailure(ctx context.Context, sl *Skylink) error {
+	set := bson.M{
+		"status":          SkylinkStatusNew,
+		"timestamp":       sl.Timestamp,
+		"last_error_kind": sl.LastErrorKind,
+		"last_error":      sl.LastError,
+	}
+	if sl.NotFound > 0 {
+		set["not_found"] = sl.NotFound
+	}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"failures": 1},
+	}
+	ur, err := db.Collection(collSkylinks).UpdateOne(ctx, lockedFilter(sl), update)
+	if err != nil {
+		return errors.AddContext(err, "failed to save scan failure")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// SkylinkSaveGone records the failure of the scan of the given locked skylink
+// and marks it as gone, so it's not scanned again. The skylink is removed from
+// the record, like once we're done with clean skylinks. It returns
+// ErrStatusChanged if the skylink isn't locked anymore.
+func (db *DB) SkylinkSaveGone(ctx context.Context, sl *Skylink) error {
+	update := bson.M{
+		"$set": bson.M{
+			"skylink":         "",
+			"status":          SkylinkStatusGone,
+			"timestamp":       sl.Timestamp,
+			"last_error_kind": sl.LastErrorKind,
+			"last_error":      sl.LastError,
+			"not_found":       sl.NotFound,
+		},
+		"$inc": bson.M{"failures": 1},
+	}
+	ur, err := db.Collection(collSkylinks).UpdateOne(ctx, lockedFilter(sl), update)
+	if err != nil {
+		return errors.AddContext(err, "failed to save gone skylink")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// SkylinkUnlock returns the given locked skylink to the queue without
+// recording a failure, e.g. when it was locked ahead of a scan which never
+// happened.
+func (db *DB) SkylinkUnlock(ctx context.Context, sl *Skylink) error {
+	update := bson.M{"$set": bson.M{
+		"status":    SkylinkStatusNew,
+		"timestamp": Clock.Now().UTC(),
+	}}
+	_, err := db.Collection(collSkylinks).UpdateOne(ctx, lockedFilter(sl), update)
+	if err != nil {
+		return errors.AddContext(err, "failed to unlock skylink")
+	}
 	return nil
 }
 
+// SkylinkSaveReports records blocker's responses to the reports of the given
+// unreported skylink. If it was reported to every target, it completes the
+// skylink and removes the skylink from the record. It returns ErrStatusChanged
+// if the skylink isn't unreported anymore, e.g. because it was re-queued.
+func (db *DB) SkylinkSaveReports(ctx context.Context, sl *Skylink, reported bool) error {
+	set := bson.M{
+		"reports": sl.Reports,
+		"blocker": sl.Blocker,
+	}
+	if reported {
+		set["skylink"] = ""
+		set["status"] = SkylinkStatusComplete
+		if sl.Unpinned {
+			set["unpinned"] = true
+		}
+	}
+	filter := bson.M{
+		"_id":    sl.ID,
+		"status": SkylinkStatusUnreported,
+	}
+	ur, err := db.Collection(collSkylinks).UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return errors.AddContext(err, "failed to save reports")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// lockedFilter matches the record of the given skylink while it's locked for
+// scanning.
+func lockedFilter(sl *Skylink) bson.M {
+	return bson.M{
+		"_id":    sl.ID,
+		"status": SkylinkStatusScanning,
+	}
+}
+
+// verdictUpdate returns the update which sets the verdict fields of the given
+// skylink. Fields which are omitted from the document when they're empty are
+// unset, like saving the whole document would.
+func verdictUpdate(sl *Skylink) bson.M {
+	set := bson.M{
+		"skylink":               encryptField(sl.Skylink),
+		"status":                sl.Status,
+		"infected":              sl.Infected,
+		"infection_description": encryptField(capDescription(sl.InfectionDescription)),
+		"scanned_all_content":   sl.ScannedAllContent,
+		"scanned_all_offsets":   sl.ScannedAllOffsets,
+		"size":                  sl.Size,
+		"scanned_size":          sl.ScannedSize,
+		"timestamp":             sl.Timestamp,
+	}
+	unset := bson.M{}
+	optional := []struct {
+		key   string
+		value interface{}
+		empty bool
+	}{
+		{"scanned_at", sl.ScannedAt, sl.ScannedAt.IsZero()},
+		{"last_error_kind", sl.LastErrorKind, sl.LastErrorKind == ""},
+		{"last_error", capDescription(sl.LastError), sl.LastError == ""},
+		{"not_found", sl.NotFound, sl.NotFound == 0},
+		{"uploaders", sl.Uploaders, len(sl.Uploaders) == 0},
+		{"verdict_source", sl.VerdictSource, sl.VerdictSource == ""},
+		{"rescan_skylink", encryptField(sl.RescanSkylink), sl.RescanSkylink == ""},
+		{"signature_version", sl.SignatureVersion, sl.SignatureVersion == 0},
+		{"severity", sl.Severity, sl.Severity == 0},
+		{"policy_action", sl.PolicyAction, sl.PolicyAction == ""},
+		{"filename", encryptField(sl.Filename), sl.Filename == ""},
+		{"content_type", sl.ContentType, sl.ContentType == ""},
+		{"directory", sl.Directory, !sl.Directory},
+	}
+	for _, f := range optional {
+		if f.empty {
+			unset[f.key] = ""
+		} else {
+			set[f.key] = f.value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// SkylinkRescan queues the given skylink for scanning again, regardless of
+// any verdict it might already have. This also clears any false positive
+// override, so the new verdict stands. The record takes the skylink's Source
+// and Submitter. If there's no record of the skylink, we create one.
+func (db *DB) SkylinkRescan(ctx context.Context, skylink *Skylink) error {
+	now := Clock.Now().UTC()
+	filter := bson.M{"hash": skylink.Hash}
+	set := bson.M{
+		"skylink":      encryptField(skylink.Skylink),
+		"status":       SkylinkStatusNew,
+		"timestamp":    now,
+		"submitted_at": now,
+	}
+	unset := bson.M{"false_positive": "", "not_found": ""}
+	if skylink.Source != "" {
+		set["source"] = skylink.Source
+	} else {
+		unset["source"] = ""
+	}
+	if skylink.Submitter != "" {
+		set["submitter"] = encryptField(skylink.Submitter)
+	} else {
+		unset["submitter"] = ""
+	}
+	update := bson.M{"$set": set, "$unset": unset}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.Collection(collSkylinks).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to queue skylink for rescan")
+	}
+	return nil
+}
+
+// SkylinkEnqueueReported queues the given skylink for scanning with the given
+// priority and reporter tag. If the skylink is already known, we keep its
+// verdict, tag it with the reporter and raise its priority in case it's still
+// waiting to be scanned. It returns whether the skylink is new.
+func (db *DB) SkylinkEnqueueReported(ctx context.Context, skylink *Skylink, priority int, reporter string) (bool, error) {
+	now := Clock.Now().UTC()
+	filter := bson.M{"hash": skylink.Hash}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"skylink":      encryptField(skylink.Skylink),
+			"status":       SkylinkStatusNew,
+			"timestamp":    now,
+			"submitted_at": now,
+			"source":       SourceReport,
+		},
+		"$set": bson.M{"reporter": reporter},
+		"$max": bson.M{"priority": priority},
+	}
+	opts := options.Update().SetUpsert(true)
+	ur, err := db.Collection(collSkylinks).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return false, errors.AddContext(err, "failed to queue reported skylink")
+	}
+	return ur.UpsertedCount > 0, nil
+}
+
+// MarkKnownInfected marks the queued skylinks with any of the given hashes as
+// infected without scanning them, because the given external blocklist already
+// lists them. They are then reported to blocker as usual. It returns the number
+// of marked skylinks.
+func (db *DB) MarkKnownInfected(ctx context.Context, hashes []crypto.Hash, source string) (int64, error) {
+	now := Clock.Now().UTC()
+	filter := bson.M{
+		"hash":   bson.M{"$in": hashes},
+		"status": SkylinkStatusNew,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":                SkylinkStatusUnreported,
+			"infected":              true,
+			"infection_description": encryptField("Listed on blocklist " + source),
+			"verdict_source":        source,
+			"timestamp":             now,
+			"scanned_at":            now,
+		},
+	}
+	ur, err := db.Collection(collSkylinks).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to mark blocklisted skylinks")
+	}
+	return ur.ModifiedCount, nil
+}
+
+// SkylinkPurge removes the record with the given hash from the database.
+func (db *DB) SkylinkPurge(ctx context.Context, hash crypto.Hash) error {
+	dr, err := db.Collection(collSkylinks).DeleteOne(ctx, bson.M{"hash": hash})
+	if err != nil {
+		return errors.AddContext(err, "failed to purge skylink")
+	}
+	if dr.DeletedCount == 0 {
+		return ErrNoDocumentsFound
+	}
+	return nil
+}
+
+// SkylinkMarkFalsePositive overrides the infected verdict of the record with
+// the given hash. If the record hasn't been reported to blocker yet, it won't
+// be. The cached verdicts of the content are dropped, so it's scanned again if
+// it's submitted again after the record is gone. It returns the record as it
+// was before the change.
+func (db *DB) SkylinkMarkFalsePositive(ctx context.Context, hash crypto.Hash) (*Skylink, error) {
+	err := db.DropCachedVerdicts(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{
+		"hash":     hash,
+		"infected": true,
+		"status":   transitionFilter(SkylinkStatusComplete),
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"skylink":        "",
+			"status":         SkylinkStatusComplete,
+			"infected":       false,
+			"false_positive": true,
+			"timestamp":      Clock.Now().UTC(),
+		},
+	}
+	sr := db.Collection(collSkylinks).FindOneAndUpdate(ctx, filter, update)
+	if sr.Err() == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if sr.Err() != nil {
+		return nil, errors.AddContext(sr.Err(), "failed to mark skylink as false positive")
+	}
+	var sl Skylink
+	err = sr.Decode(&sl)
+	if err != nil {
+		return nil, err
+	}
+	return &sl, nil
+}
+
 // CancelStuckScans resets the status of scans that have been going on for more
 // than scanner.ScanTimeout. We assume that these scans have terminated
 // unexpectedly without reporting their results (e.g. server crash).
 func (db *DB) CancelStuckScans(ctx context.Context) (int64, error) {
-	filter := bson.M{
-		"status":    SkylinkStatusScanning,
-		"timestamp": bson.M{"$gt": time.Now().Add(-ScanTimeout)},
-	}
+	filter := stuckScansFilter()
 	update := bson.M{
 		"$set": bson.M{
-			"timestamp": time.Now().UTC(),
+			"timestamp": Clock.Now().UTC(),
 			"status":    SkylinkStatusNew,
 		},
 	}
@@ -192,23 +531,47 @@ func (db *DB) CancelStuckScans(ctx context.Context) (int64, error) {
 	return ur.ModifiedCount, nil
 }
 
+// stuckScansFilter matches the skylinks which have been locked for scanning
+// for more than ScanTimeout.
+func stuckScansFilter() bson.M {
+	return bson.M{
+		"status":    SkylinkStatusScanning,
+		"timestamp": bson.M{"$lt": Clock.Now().UTC().Add(-ScanTimeout)},
+	}
+}
+
 // SweepAndLock sweeps the database for new skylinks. It "locks" and returns the
 // first one it encounters. The "locking" is done by updating the skylink's
 // status from "new" to "scanning".
 func (db *DB) SweepAndLock(ctx context.Context) (*Skylink, error) {
+	// Records of regular submissions don't store their priority, and MongoDB
+	// sorts missing fields before any number. So we only look at negative
+	// priorities once there are no other new records.
+	sl, err := db.sweepAndLock(ctx, bson.M{"$not": bson.M{"$lt": 0}})
+	if errors.Contains(err, ErrNoDocumentsFound) {
+		sl, err = db.sweepAndLock(ctx, bson.M{"$lt": 0})
+	}
+	return sl, err
+}
+
+// sweepAndLock locks the new record with the highest priority among those
+// whose priority matches the given filter.
+func (db *DB) sweepAndLock(ctx context.Context, priority bson.M) (*Skylink, error) {
 	filter := bson.M{
-		"status":  SkylinkStatusNew,
-		"skylink": bson.M{"$ne": ""},
+		"status":   SkylinkStatusNew,
+		"skylink":  bson.M{"$ne": ""},
+		"priority": priority,
 	}
 	update := bson.M{
 		"$set": bson.M{
-			"timestamp": time.Now().UTC(),
+			"timestamp": Clock.Now().UTC(),
 			"status":    SkylinkStatusScanning,
 		},
 	}
-	// Look for a single new record and change its status to "scanning".
-	// ur, err := db.Collection(collSkylinks).UpdateOne(ctx, filter, update)
-	sr := db.Collection(collSkylinks).FindOneAndUpdate(ctx, filter, update)
+	// Look for a single new record with the highest priority and change its
+	// status to "scanning".
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{"priority", -1}})
+	sr := db.Collection(collSkylinks).FindOneAndUpdate(ctx, filter, update, opts)
 	if sr.Err() == mongo.ErrNoDocuments {
 		return nil, ErrNoDocumentsFound
 	}
@@ -228,9 +591,26 @@ func (db *DB) SweepAndLock(ctx context.Context) (*Skylink, error) {
 // See https://docs.mongodb.com/manual/indexes/
 // See https://docs.mongodb.com/manual/core/index-unique/
 func ensureDBSchema(ctx context.Context, db *mongo.Database, log *logrus.Logger) error {
-	// schema defines a mapping between a collection name and the indexes that
-	// must exist for that collection.
-	schema := map[string][]mongo.IndexModel{
+	for collName, models := range dbSchema() {
+		coll, err := ensureCollection(ctx, db, collName)
+		if err != nil {
+			return err
+		}
+		iv := coll.Indexes()
+		var names []string
+		names, err = iv.CreateMany(ctx, models)
+		if err != nil {
+			return errors.AddContext(err, "failed to create indexes")
+		}
+		log.Debugf("Ensured index exists: %v", names)
+	}
+	return nil
+}
+
+// dbSchema returns a mapping between a collection name and the indexes that
+// must exist for that collection.
+func dbSchema() map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
 		collSkylinks: {
 			{
 				Keys:    bson.D{{"skylink", 1}},
@@ -248,23 +628,124 @@ func ensureDBSchema(ctx context.Context, db *mongo.Database, log *logrus.Logger)
 				Keys:    bson.D{{"timestamp", 1}},
 				Options: options.Index().SetName("timestamp"),
 			},
+			{
+				Keys:    bson.D{{"scanned_at", 1}},
+				Options: options.Index().SetName("scanned_at"),
+			},
+			{
+				Keys:    bson.D{{"scanned_at", 1}, {"submitted_at", 1}, {"scanned_size", 1}},
+				Options: options.Index().SetName("scanned_at_submitted_at_scanned_size"),
+			},
+			{
+				Keys:    bson.D{{"infected", 1}, {"scanned_at", 1}},
+				Options: options.Index().SetName("infected_scanned_at"),
+			},
+			{
+				Keys:    bson.D{{"status", 1}, {"submitted_at", 1}},
+				Options: options.Index().SetName("status_submitted_at"),
+			},
+			{
+				Keys:    bson.D{{"status", 1}, {"scanned_at", 1}},
+				Options: options.Index().SetName("status_scanned_at"),
+			},
+			{
+				Keys:    bson.D{{"status", 1}, {"priority", -1}},
+				Options: options.Index().SetName("status_priority"),
+			},
+			{
+				Keys:    bson.D{{"infected", 1}, {"uploaders.sub", 1}},
+				Options: options.Index().SetName("infected_uploaders_sub"),
+			},
+			{
+				Keys:    bson.D{{"backfill", 1}, {"status", 1}},
+				Options: options.Index().SetName("backfill_status").SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{"campaign", 1}, {"status", 1}},
+				Options: options.Index().SetName("campaign_status").SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{"rollback", 1}, {"status", 1}},
+				Options: options.Index().SetName("rollback_status").SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{"rescan_skylink", 1}, {"scanned_at", 1}},
+				Options: options.Index().SetName("rescan_skylink_scanned_at").SetSparse(true),
+			},
+		},
+		collV2Resolutions: {
+			{
+				Keys:    bson.D{{"expires_at", 1}},
+				Options: options.Index().SetName("expires_at").SetExpireAfterSeconds(0),
+			},
+		},
+		collVerdictCache: {
+			{
+				Keys:    bson.D{{"hash", 1}, {"signature_version", 1}},
+				Options: options.Index().SetName("hash_signature_version").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{"expires_at", 1}},
+				Options: options.Index().SetName("expires_at").SetExpireAfterSeconds(0),
+			},
+		},
+		collBackfills: {
+			{
+				Keys:    bson.D{{"created_at", 1}},
+				Options: options.Index().SetName("created_at"),
+			},
+		},
+		collReportLog: {
+			{
+				Keys:    bson.D{{"skylink_hash", 1}},
+				Options: options.Index().SetName("skylink_hash"),
+			},
+		},
+		collSignatureWithdrawals: {
+			{
+				Keys:    bson.D{{"withdrawn_at", 1}},
+				Options: options.Index().SetName("withdrawn_at"),
+			},
+		},
+		collCampaigns: {
+			{
+				Keys:    bson.D{{"created_at", 1}},
+				Options: options.Index().SetName("created_at"),
+			},
+		},
+		collAudit: {
+			{
+				Keys:    bson.D{{"timestamp", 1}},
+				Options: options.Index().SetName("timestamp"),
+			},
+			{
+				Keys:    bson.D{{"caller", 1}, {"timestamp", 1}},
+				Options: options.Index().SetName("caller_timestamp"),
+			},
+			{
+				Keys:    bson.D{{"action", 1}, {"timestamp", 1}},
+				Options: options.Index().SetName("action_timestamp"),
+			},
+		},
+		collSubmissions: {
+			{
+				Keys:    bson.D{{"expires_at", 1}},
+				Options: options.Index().SetName("expires_at").SetExpireAfterSeconds(0),
+			},
+		},
+		collSubmitterBans: {
+			{
+				Keys:    bson.D{{"until", 1}},
+				Options: options.Index().SetName("until").SetExpireAfterSeconds(0),
+			},
+		},
+		collConfirmations: {
+			{
+				Keys:    bson.D{{"expires_at", 1}},
+				Options: options.Index().SetName("expires_at").SetExpireAfterSeconds(0),
+			},
 		},
 	}
-
-	for collName, models := range schema {
-		coll, err := ensureCollection(ctx, db, collName)
-		if err != nil {
-			return err
-		}
-		iv := coll.Indexes()
-		var names []string
-		names, err = iv.CreateMany(ctx, models)
-		if err != nil {
-			return errors.AddContext(err, "failed to create indexes")
-		}
-		log.Debugf("Ensured index exists: %v", names)
-	}
-	return nil
 }
 
 // ensureCollection gets the given collection from the
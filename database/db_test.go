@@ -0,0 +1,100 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestVerdictUpdate ensures the update of a verdict sets its fields and unsets
+// the empty optional ones, instead of leaving stale values behind.
+func TestVerdictUpdate(t *testing.T) {
+	now := time.Now().UTC()
+	sl := &Skylink{
+		Status:               SkylinkStatusUnreported,
+		Skylink:              "skylink",
+		Infected:             true,
+		InfectionDescription: "Win.Test.EICAR_HDB-1",
+		Size:                 68,
+		ScannedSize:          68,
+		ScannedAllContent:    true,
+		Timestamp:            now,
+		ScannedAt:            now,
+		SignatureVersion:     26000,
+		Priority:             5,
+	}
+	update := verdictUpdate(sl)
+	set, unset := update["$set"].(bson.M), update["$unset"].(bson.M)
+	if set["status"] != SkylinkStatusUnreported || set["infected"] != true || set["scanned_at"] != now || set["signature_version"] != 26000 {
+		t.Fatalf("Unexpected $set %v", set)
+	}
+	for _, key := range []string{"last_error_kind", "last_error", "not_found", "uploaders", "verdict_source", "rescan_skylink", "severity", "policy_action", "filename", "content_type", "directory"} {
+		if _, ok := unset[key]; !ok {
+			t.Fatalf("Expected %s to be unset, got %v", key, unset)
+		}
+		if _, ok := set[key]; ok {
+			t.Fatalf("Expected %s not to be set, got %v", key, set)
+		}
+	}
+	// Fields which aren't part of the verdict aren't touched.
+	if _, ok := set["priority"]; ok {
+		t.Fatal("Expected the priority to be left alone")
+	}
+	if _, ok := set["failures"]; ok {
+		t.Fatal("Expected the failures to be left alone")
+	}
+
+	// There's nothing to unset when all optional fields are present.
+	sl.LastErrorKind, sl.LastError, sl.NotFound = "portal_not_found", "portal responded with 404", 1
+	sl.Uploaders = []Uploader{{Uploads: 1}}
+	sl.VerdictSource, sl.RescanSkylink = "peer:a", "skylink"
+	sl.Severity, sl.PolicyAction = 50, PolicyActionBlock
+	sl.Filename, sl.ContentType, sl.Directory = "site.zip", "application/zip", true
+	if _, ok := verdictUpdate(sl)["$unset"]; ok {
+		t.Fatal("Expected no $unset")
+	}
+}
+
+// TestCapDescription ensures overly long descriptions are truncated without
+// splitting characters.
+func TestCapDescription(t *testing.T) {
+	if d := capDescription("Win.Test.EICAR_HDB-1"); d != "Win.Test.EICAR_HDB-1" {
+		t.Fatalf("Unexpected description %s", d)
+	}
+	if d := capDescription(strings.Repeat("a", 10*MaxDescriptionLength)); len(d) != MaxDescriptionLength {
+		t.Fatalf("Unexpected length %d", len(d))
+	}
+	// The limit falls into the middle of a three byte character.
+	d := capDescription("aa" + strings.Repeat("€", MaxDescriptionLength))
+	if !utf8.ValidString(d) || len(d) != MaxDescriptionLength-2 {
+		t.Fatalf("Unexpected description of length %d", len(d))
+	}
+	update := verdictUpdate(&Skylink{LastError: strings.Repeat("e", 2*MaxDescriptionLength)})
+	if s := update["$set"].(bson.M)["last_error"].(string); len(s) != MaxDescriptionLength {
+		t.Fatalf("Unexpected error length %d", len(s))
+	}
+}
+
+// TestStuckScansFilter ensures we only unlock the scans which have been
+// locked for longer than ScanTimeout.
+func TestStuckScansFilter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer func(c clock.Clock) { Clock = c }(Clock)
+	fake := clock.NewFake(now)
+	Clock = fake
+
+	filter := stuckScansFilter()
+	cutoff := filter["timestamp"].(bson.M)["$lt"].(time.Time)
+	if filter["status"] != SkylinkStatusScanning || !cutoff.Equal(now.Add(-ScanTimeout)) {
+		t.Fatalf("Unexpected filter %v", filter)
+	}
+	fake.Advance(time.Minute)
+	cutoff = stuckScansFilter()["timestamp"].(bson.M)["$lt"].(time.Time)
+	if !cutoff.Equal(now.Add(time.Minute - ScanTimeout)) {
+		t.Fatalf("Expected the cutoff to move with the clock, got %v", cutoff)
+	}
+}
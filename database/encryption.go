@@ -0,0 +1,159 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// encryptedPrefix marks encrypted field values. The version allows changing
+// the scheme later.
+const encryptedPrefix = "enc1:"
+
+var (
+	// ErrNoEncryptionKey is returned when we read an encrypted field without
+	// an encryption key.
+	ErrNoEncryptionKey = errors.New("field is encrypted but no encryption key is set")
+
+	// fieldKey encrypts the sensitive fields of skylink records. They are
+	// stored in plain text while it's nil.
+	fieldKey   *fieldCipher
+	fieldKeyMu sync.RWMutex
+)
+
+// fieldCipher encrypts field values with AES-256-GCM. The nonce is derived
+// from the value with HMAC-SHA256, which makes the encryption deterministic:
+// equal values encrypt to equal ciphertexts, so we can still group by them,
+// e.g. for the signature stats, and a nonce is never reused for different
+// values.
+type fieldCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// skylinkRecord is a Skylink without its BSON methods.
+type skylinkRecord Skylink
+
+// SetEncryptionKey makes us encrypt the skylinks and infection descriptions
+// of the skylink records we store with the given 32 byte key, so a leaked DB
+// snapshot doesn't expose live links to malware. Records stored in plain text
+// before remain readable, so encryption can be enabled at any time, but
+// records stored encrypted can't be read without the key.
+func SetEncryptionKey(key []byte) error {
+	if len(key) != 32 {
+		return errors.New("the encryption key must be 32 bytes long")
+	}
+	// Derive separate keys for encryption and the nonces.
+	block, err := aes.NewCipher(deriveKey(key, "encryption"))
+	if err != nil {
+		return errors.AddContext(err, "failed to create cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return errors.AddContext(err, "failed to create cipher")
+	}
+	fieldKeyMu.Lock()
+	fieldKey = &fieldCipher{aead: aead, macKey: deriveKey(key, "nonce")}
+	fieldKeyMu.Unlock()
+	return nil
+}
+
+// ParseEncryptionKey parses a 32 byte key encoded in hex or base64.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("the encryption key must be 32 bytes encoded in hex or base64")
+}
+
+// deriveKey derives a key for the given purpose from the given master key.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// encryptField encrypts the given field value, if there's an encryption key.
+// Empty values stay empty, so queries for records with a skylink keep
+// working.
+func encryptField(s string) string {
+	fieldKeyMu.RLock()
+	fc := fieldKey
+	fieldKeyMu.RUnlock()
+	if fc == nil || s == "" || strings.HasPrefix(s, encryptedPrefix) {
+		return s
+	}
+	mac := hmac.New(sha256.New, fc.macKey)
+	_, _ = mac.Write([]byte(s))
+	nonce := mac.Sum(nil)[:fc.aead.NonceSize()]
+	sealed := fc.aead.Seal(nonce, nonce, []byte(s), nil)
+	return encryptedPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// decryptField decrypts the given field value. Values which aren't encrypted
+// are returned as they are.
+func decryptField(s string) (string, error) {
+	if !strings.HasPrefix(s, encryptedPrefix) {
+		return s, nil
+	}
+	fieldKeyMu.RLock()
+	fc := fieldKey
+	fieldKeyMu.RUnlock()
+	if fc == nil {
+		return "", ErrNoEncryptionKey
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, encryptedPrefix))
+	if err != nil || len(sealed) < fc.aead.NonceSize() {
+		return "", errors.New("invalid encrypted field")
+	}
+	nonce := sealed[:fc.aead.NonceSize()]
+	plain, err := fc.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to decrypt field")
+	}
+	return string(plain), nil
+}
+
+// MarshalBSON implements bson.Marshaler. It encrypts the sensitive fields.
+func (sl Skylink) MarshalBSON() ([]byte, error) {
+	r := skylinkRecord(sl)
+	r.Skylink = encryptField(r.Skylink)
+	r.RescanSkylink = encryptField(r.RescanSkylink)
+	r.InfectionDescription = encryptField(r.InfectionDescription)
+	r.Filename = encryptField(r.Filename)
+	r.Submitter = encryptField(r.Submitter)
+	return bson.Marshal(r)
+}
+
+// UnmarshalBSON implements bson.Unmarshaler. It decrypts the sensitive
+// fields.
+func (sl *Skylink) UnmarshalBSON(b []byte) error {
+	var r skylinkRecord
+	err := bson.Unmarshal(b, &r)
+	if err != nil {
+		return err
+	}
+	var errs [5]error
+	r.Skylink, errs[0] = decryptField(r.Skylink)
+	r.RescanSkylink, errs[1] = decryptField(r.RescanSkylink)
+	r.InfectionDescription, errs[2] = decryptField(r.InfectionDescription)
+	r.Filename, errs[3] = decryptField(r.Filename)
+	r.Submitter, errs[4] = decryptField(r.Submitter)
+	if err = errors.Compose(errs[:]...); err != nil {
+		return errors.AddContext(err, "failed to decrypt skylink record")
+	}
+	*sl = Skylink(r)
+	return nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestSkylinkEncryption ensures the sensitive fields of skylink records are
+// encrypted in their BSON form once there's a key, and that records stored
+// in plain text remain readable.
+func TestSkylinkEncryption(t *testing.T) {
+	defer func() { fieldKey = nil }()
+	sl := Skylink{
+		Skylink:              "AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw",
+		RescanSkylink:        "AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw",
+		InfectionDescription: "Win.Test.EICAR_HDB-1",
+		Status:               SkylinkStatusUnreported,
+		Submitter:            "ip:192.0.2.1",
+	}
+	plain, err := bson.Marshal(sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParseEncryptionKey(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+	b, err := bson.Marshal(sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(sl.Skylink)) || bytes.Contains(b, []byte(sl.InfectionDescription)) || bytes.Contains(b, []byte(sl.Submitter)) {
+		t.Fatal("Expected the sensitive fields to be encrypted")
+	}
+	var raw struct {
+		Skylink string `bson:"skylink"`
+		Status  string `bson:"status"`
+	}
+	if err = bson.Unmarshal(b, &raw); err != nil || !strings.HasPrefix(raw.Skylink, encryptedPrefix) || raw.Status != sl.Status {
+		t.Fatalf("Unexpected raw record %+v, %v", raw, err)
+	}
+	// Equal values encrypt to equal ciphertexts, so we can group by them.
+	if encryptField(sl.InfectionDescription) != encryptField(sl.InfectionDescription) {
+		t.Fatal("Expected deterministic encryption")
+	}
+	for _, doc := range [][]byte{b, plain} {
+		var decoded Skylink
+		if err = bson.Unmarshal(doc, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Skylink != sl.Skylink || decoded.RescanSkylink != sl.RescanSkylink || decoded.InfectionDescription != sl.InfectionDescription || decoded.Submitter != sl.Submitter {
+			t.Fatalf("Unexpected record %+v", decoded)
+		}
+	}
+
+	// Encrypted records can't be read with another key or without one.
+	if err = SetEncryptionKey(bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Skylink
+	if err = bson.Unmarshal(b, &decoded); err == nil {
+		t.Fatal("Expected decrypting with the wrong key to fail")
+	}
+	fieldKey = nil
+	if err = bson.Unmarshal(b, &decoded); !errors.Contains(err, ErrNoEncryptionKey) {
+		t.Fatalf("Expected %v, got %v", ErrNoEncryptionKey, err)
+	}
+	if _, err = ParseEncryptionKey("too short"); err == nil {
+		t.Fatal("Expected an error for an invalid key")
+	}
+}
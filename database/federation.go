@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// collPeerVerdicts defines the name of the collection which holds the
+	// verdicts we pulled from federated scanner instances.
+	collPeerVerdicts = "peer_verdicts"
+)
+
+// PeerVerdict is a verdict of a federated scanner instance. Peer names the
+// instance it was pulled from.
+type PeerVerdict struct {
+	Hash                 crypto.Hash `bson:"_id" json:"hash"`
+	Infected             bool        `bson:"infected" json:"infected"`
+	InfectionDescription string      `bson:"infection_description" json:"infectionDescription"`
+	ScannedAt            time.Time   `bson:"scanned_at" json:"scannedAt"`
+	Peer                 string      `bson:"peer" json:"-"`
+}
+
+// VerdictsSince returns up to limit verdicts of our own scans which were
+// reached after the given time, oldest first. Verdicts we imported from
+// blocklists or peers are left out, so federated instances don't echo each
+// other's verdicts. Overridden false positives are reported as clean.
+func (db *DB) VerdictsSince(ctx context.Context, since time.Time, limit int) ([]PeerVerdict, error) {
+	filter := bson.M{
+		"scanned_at":     bson.M{"$gt": since},
+		"verdict_source": bson.M{"$exists": false},
+	}
+	opts := options.Find().SetSort(bson.D{{"scanned_at", 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	c, err := db.Collection(collSkylinks).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch verdicts")
+	}
+	var sls []Skylink
+	err = c.All(ctx, &sls)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode verdicts")
+	}
+	verdicts := make([]PeerVerdict, 0, len(sls))
+	for _, sl := range sls {
+		verdicts = append(verdicts, PeerVerdict{
+			Hash:                 sl.Hash,
+			Infected:             sl.Infected && !sl.FalsePositive,
+			InfectionDescription: sl.InfectionDescription,
+			ScannedAt:            sl.ScannedAt,
+		})
+	}
+	return verdicts, nil
+}
+
+// SavePeerVerdicts stores the given verdicts of federated scanner instances.
+// Newer verdicts for the same hash replace older ones.
+func (db *DB) SavePeerVerdicts(ctx context.Context, verdicts []PeerVerdict) error {
+	if len(verdicts) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(verdicts))
+	for _, v := range verdicts {
+		v.InfectionDescription = capDescription(v.InfectionDescription)
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": v.Hash}).
+			SetReplacement(v).
+			SetUpsert(true))
+	}
+	_, err := db.Collection(collPeerVerdicts).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return errors.AddContext(err, "failed to store peer verdicts")
+	}
+	return nil
+}
+
+// PeerVerdict returns the verdict a federated scanner instance reached for the
+// content with the given hash. It returns ErrNoDocumentsFound if no peer has
+// scanned it.
+func (db *DB) PeerVerdict(ctx context.Context, hash crypto.Hash) (*PeerVerdict, error) {
+	var v PeerVerdict
+	err := db.Collection(collPeerVerdicts).FindOne(ctx, bson.M{"_id": hash}).Decode(&v)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch peer verdict")
+	}
+	return &v, nil
+}
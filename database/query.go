@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// SkylinkFilter narrows down the skylink records we fetch for reporting.
+	// Empty fields match all records. Records are returned newest first,
+	// starting after the record with the given ID, if set.
+	SkylinkFilter struct {
+		Status        string
+		Infected      *bool
+		FalsePositive *bool
+		ScannedFrom   time.Time
+		ScannedTo     time.Time
+		SubmittedFrom time.Time
+		SubmittedTo   time.Time
+		After         primitive.ObjectID
+		Limit         int
+	}
+)
+
+// FindSkylinks returns the skylink records matching the given filter, newest
+// first.
+func (db *DB) FindSkylinks(ctx context.Context, f SkylinkFilter) ([]Skylink, error) {
+	q := f.query()
+	if !f.After.IsZero() {
+		q["_id"] = bson.M{"$lt": f.After}
+	}
+	opts := options.Find().SetSort(bson.D{{"_id", -1}})
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+	c, err := db.Collection(collSkylinks).Find(ctx, q, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch skylinks")
+	}
+	sls := []Skylink{}
+	err = c.All(ctx, &sls)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode skylinks")
+	}
+	return sls, nil
+}
+
+// CountSkylinks returns the number of skylink records matching the given
+// filter. The filter's After and Limit are ignored.
+func (db *DB) CountSkylinks(ctx context.Context, f SkylinkFilter) (int64, error) {
+	n, err := db.Collection(collSkylinks).CountDocuments(ctx, f.query())
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to count skylinks")
+	}
+	return n, nil
+}
+
+// query returns the MongoDB query matching the filter, without the
+// pagination.
+func (f SkylinkFilter) query() bson.M {
+	q := bson.M{}
+	if f.Status != "" {
+		q["status"] = f.Status
+	}
+	if f.Infected != nil {
+		q["infected"] = *f.Infected
+	}
+	if f.FalsePositive != nil {
+		if *f.FalsePositive {
+			q["false_positive"] = true
+		} else {
+			q["false_positive"] = bson.M{"$ne": true}
+		}
+	}
+	if r := timeRange(f.ScannedFrom, f.ScannedTo); len(r) > 0 {
+		q["scanned_at"] = r
+	}
+	if r := timeRange(f.SubmittedFrom, f.SubmittedTo); len(r) > 0 {
+		q["submitted_at"] = r
+	}
+	return q
+}
+
+// timeRange returns the MongoDB condition matching times within the given
+// range. Zero bounds are left open.
+func timeRange(from, to time.Time) bson.M {
+	r := bson.M{}
+	if !from.IsZero() {
+		r["$gte"] = from
+	}
+	if !to.IsZero() {
+		r["$lt"] = to
+	}
+	return r
+}
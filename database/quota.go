@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collSubmissions defines the name of the collection which counts the
+	// daily submissions of each submitter.
+	collSubmissions = "submissions"
+	// collSubmitterBans defines the name of the collection which holds the
+	// temporary bans of abusive submitters.
+	collSubmitterBans = "submitter_bans"
+
+	// submissionsRetention is how long we keep the daily counts after the
+	// day ends, so recent abuse can be looked into.
+	submissionsRetention = 7 * 24 * time.Hour
+)
+
+type (
+	// SubmitterUsage counts a submitter's submissions during a UTC day.
+	// Submitted includes the submissions we rejected over the quota.
+	SubmitterUsage struct {
+		ID        string    `bson:"_id" json:"-"`
+		Submitter string    `bson:"submitter" json:"submitter"`
+		Day       string    `bson:"day" json:"day"`
+		Submitted int64     `bson:"submitted" json:"submitted"`
+		Invalid   int64     `bson:"invalid" json:"invalid"`
+		ExpiresAt time.Time `bson:"expires_at" json:"-"`
+	}
+
+	// SubmitterBan is a temporary ban of a submitter. It's lifted, and the
+	// record removed, once it expires.
+	SubmitterBan struct {
+		Submitter string    `bson:"_id" json:"submitter"`
+		Reason    string    `bson:"reason" json:"reason"`
+		BannedAt  time.Time `bson:"banned_at" json:"bannedAt"`
+		Until     time.Time `bson:"until" json:"until"`
+	}
+)
+
+// RecordSubmissions adds the given numbers of submitted and invalid skylinks
+// to the submitter's usage of the current day and returns the updated usage.
+func (db *DB) RecordSubmissions(ctx context.Context, submitter string, submitted, invalid int64) (SubmitterUsage, error) {
+	now := Clock.Now().UTC()
+	day := now.Format("2006-01-02")
+	dayStart, _ := time.Parse("2006-01-02", day)
+	filter := bson.M{"_id": submitter + "|" + day}
+	update := bson.M{
+		"$inc": bson.M{"submitted": submitted, "invalid": invalid},
+		"$setOnInsert": bson.M{
+			"submitter":  submitter,
+			"day":        day,
+			"expires_at": dayStart.Add(24*time.Hour + submissionsRetention),
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var u SubmitterUsage
+	err := db.Collection(collSubmissions).FindOneAndUpdate(ctx, filter, update, opts).Decode(&u)
+	if err != nil {
+		return SubmitterUsage{}, errors.AddContext(err, "failed to record submissions")
+	}
+	return u, nil
+}
+
+// BanSubmitter bans the given submitter until the given time. An existing ban
+// is replaced.
+func (db *DB) BanSubmitter(ctx context.Context, submitter, reason string, until time.Time) error {
+	ban := SubmitterBan{
+		Submitter: submitter,
+		Reason:    reason,
+		BannedAt:  Clock.Now().UTC(),
+		Until:     until.UTC(),
+	}
+	opts := options.Replace().SetUpsert(true)
+	_, err := db.Collection(collSubmitterBans).ReplaceOne(ctx, bson.M{"_id": submitter}, ban, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to ban submitter")
+	}
+	return nil
+}
+
+// SubmitterBanned returns the active ban of the given submitter, or nil if
+// it's not banned. Expired bans which MongoDB hasn't removed yet are ignored.
+func (db *DB) SubmitterBanned(ctx context.Context, submitter string) (*SubmitterBan, error) {
+	filter := bson.M{"_id": submitter, "until": bson.M{"$gt": Clock.Now().UTC()}}
+	var ban SubmitterBan
+	err := db.Collection(collSubmitterBans).FindOne(ctx, filter).Decode(&ban)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to look up submitter ban")
+	}
+	return &ban, nil
+}
+
+// SubmitterBans returns all active bans, the ones which last longest first.
+func (db *DB) SubmitterBans(ctx context.Context) ([]SubmitterBan, error) {
+	filter := bson.M{"until": bson.M{"$gt": Clock.Now().UTC()}}
+	opts := options.Find().SetSort(bson.D{{"until", -1}})
+	c, err := db.Collection(collSubmitterBans).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch submitter bans")
+	}
+	bans := []SubmitterBan{}
+	if err = c.All(ctx, &bans); err != nil {
+		return nil, errors.AddContext(err, "failed to decode submitter bans")
+	}
+	return bans, nil
+}
+
+// LiftSubmitterBan removes the ban of the given submitter. It returns
+// ErrNoDocumentsFound if the submitter isn't banned.
+func (db *DB) LiftSubmitterBan(ctx context.Context, submitter string) error {
+	res, err := db.Collection(collSubmitterBans).DeleteOne(ctx, bson.M{"_id": submitter})
+	if err != nil {
+		return errors.AddContext(err, "failed to lift submitter ban")
+	}
+	if res.DeletedCount == 0 {
+		return ErrNoDocumentsFound
+	}
+	return nil
+}
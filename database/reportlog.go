@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collReportLog defines the name of the collection which holds the
+	// hash-chained log of our reports to blocker.
+	collReportLog = "report_log"
+
+	// ReportActionBlock marks report log entries of block requests.
+	ReportActionBlock = "block"
+
+	// reportLogAppendAttempts is how many times we try to append an entry
+	// when other instances append at the same time.
+	reportLogAppendAttempts = 10
+)
+
+var (
+	// ErrReportLogTampered is returned when the report log's hash chain
+	// doesn't verify.
+	ErrReportLogTampered = errors.New("report log hash chain is broken")
+)
+
+// ReportLogEntry records a single request we sent to a blocker target, what
+// it was about and how blocker responded. Entries are numbered from one and
+// chained: each one's Hash covers its fields and the Hash of the entry before
+// it, PrevHash, so altering or removing an entry breaks the chain of all
+// entries after it. The Skylink and Description are stored encrypted, like in
+// skylink records, and the hashes cover their stored form, so the chain can be
+// verified without the encryption key. Caller is the admin who asked for the
+// request, if any. Older logs also have entries of unblock requests.
+type ReportLogEntry struct {
+	Seq         int64     `bson:"_id" json:"seq"`
+	Timestamp   time.Time `bson:"timestamp" json:"timestamp"`
+	Action      string    `bson:"action" json:"action"`
+	Target      string    `bson:"target" json:"target"`
+	TargetURL   string    `bson:"target_url" json:"targetUrl"`
+	SkylinkHash string    `bson:"skylink_hash" json:"skylinkHash"`
+	Skylink     string    `bson:"skylink" json:"skylink"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	Caller      string    `bson:"caller,omitempty" json:"caller,omitempty"`
+	Result      string    `bson:"result" json:"result"`
+	StatusCode  int       `bson:"status_code,omitempty" json:"statusCode,omitempty"`
+	BlockID     string    `bson:"block_id,omitempty" json:"blockId,omitempty"`
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+	PrevHash    string    `bson:"prev_hash" json:"prevHash"`
+	Hash        string    `bson:"hash" json:"hash"`
+}
+
+// AppendReportLog appends the given entry to the report log. It sets the
+// entry's sequence number, timestamp and hashes. Concurrent appends, also by
+// other instances, are serialized by the unique sequence numbers.
+func (db *DB) AppendReportLog(ctx context.Context, e *ReportLogEntry) error {
+	// The DB stores milliseconds, so the hash must not cover more.
+	e.Timestamp = Clock.Now().UTC().Truncate(time.Millisecond)
+	e.Skylink = encryptField(e.Skylink)
+	e.Description = encryptField(capDescription(e.Description))
+	e.Error = capDescription(e.Error)
+	coll := db.Collection(collReportLog)
+	for i := 0; i < reportLogAppendAttempts; i++ {
+		var last ReportLogEntry
+		opts := options.FindOne().SetSort(bson.D{{"_id", -1}})
+		err := coll.FindOne(ctx, bson.M{}, opts).Decode(&last)
+		if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+			return errors.AddContext(err, "failed to fetch the last report log entry")
+		}
+		e.Seq = last.Seq + 1
+		e.PrevHash = last.Hash
+		e.Hash = e.digest()
+		_, err = coll.InsertOne(ctx, e)
+		if err != nil && strings.Contains(err.Error(), "E11000 duplicate key error") {
+			// Another append took this sequence number.
+			continue
+		}
+		if err != nil {
+			return errors.AddContext(err, "failed to append to the report log")
+		}
+		return nil
+	}
+	return errors.New("failed to append to the report log: too many concurrent appends")
+}
+
+// ReportLogEntries returns up to limit report log entries after the given
+// sequence number, oldest first, with their skylinks and descriptions
+// decrypted.
+func (db *DB) ReportLogEntries(ctx context.Context, after int64, limit int) ([]ReportLogEntry, error) {
+	opts := options.Find().SetSort(bson.D{{"_id", 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	c, err := db.Collection(collReportLog).Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch report log entries")
+	}
+	entries := []ReportLogEntry{}
+	err = c.All(ctx, &entries)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode report log entries")
+	}
+	err = decryptReportLog(entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// decryptReportLog decrypts the skylinks and descriptions of the given
+// report log entries.
+func decryptReportLog(entries []ReportLogEntry) error {
+	for i := range entries {
+		var errs [2]error
+		entries[i].Skylink, errs[0] = decryptField(entries[i].Skylink)
+		entries[i].Description, errs[1] = decryptField(entries[i].Description)
+		if err := errors.Compose(errs[:]...); err != nil {
+			return errors.AddContext(err, "failed to decrypt report log entry")
+		}
+	}
+	return nil
+}
+
+// VerifyReportLog walks the whole report log and verifies its hash chain. It
+// returns the sequence number and hash of the last entry it verified, up to
+// the first broken one, and ErrReportLogTampered if there is one. Recording
+// the hash elsewhere lets us prove later that the log up to that entry wasn't
+// rewritten as a whole.
+func (db *DB) VerifyReportLog(ctx context.Context) (int64, string, error) {
+	opts := options.Find().SetSort(bson.D{{"_id", 1}})
+	c, err := db.Collection(collReportLog).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return 0, "", errors.AddContext(err, "failed to fetch report log entries")
+	}
+	defer func() { _ = c.Close(ctx) }()
+	var prev ReportLogEntry
+	for c.Next(ctx) {
+		var e ReportLogEntry
+		if err = c.Decode(&e); err != nil {
+			return prev.Seq, prev.Hash, errors.AddContext(err, "failed to decode report log entry")
+		}
+		if err = e.verify(&prev); err != nil {
+			return prev.Seq, prev.Hash, err
+		}
+		prev = e
+	}
+	return prev.Seq, prev.Hash, errors.AddContext(c.Err(), "failed to iterate over the report log")
+}
+
+// verify returns ErrReportLogTampered if the entry doesn't follow the given
+// previous entry in the chain, or its hash doesn't match its fields.
+func (e *ReportLogEntry) verify(prev *ReportLogEntry) error {
+	switch {
+	case e.Seq != prev.Seq+1:
+		return errors.AddContext(ErrReportLogTampered, fmt.Sprintf("entry %d follows entry %d", e.Seq, prev.Seq))
+	case e.PrevHash != prev.Hash:
+		return errors.AddContext(ErrReportLogTampered, fmt.Sprintf("entry %d doesn't chain to the entry before it", e.Seq))
+	case e.Hash != e.digest():
+		return errors.AddContext(ErrReportLogTampered, fmt.Sprintf("entry %d was altered", e.Seq))
+	}
+	return nil
+}
+
+// digest returns the hex-encoded SHA-256 hash of the entry's fields and the
+// hash of the entry before it. Strings are quoted, so no two entries encode
+// the same.
+func (e *ReportLogEntry) digest() string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d\n%d\n%q\n%q\n%q\n%q\n%q\n%q\n%q\n%q\n%d\n%q\n%q\n%q\n",
+		e.Seq, e.Timestamp.UnixMilli(), e.Action, e.Target, e.TargetURL, e.SkylinkHash, e.Skylink,
+		e.Description, e.Caller, e.Result, e.StatusCode, e.BlockID, e.Error, e.PrevHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestReportLogChain ensures the hash chain of the report log verifies as long
+// as its entries are left alone, and breaks when one is altered, removed or
+// reordered.
+func TestReportLogChain(t *testing.T) {
+	var entries []ReportLogEntry
+	var prev ReportLogEntry
+	for i, target := range []string{"production", "staging", "production"} {
+		e := ReportLogEntry{
+			Seq:         int64(i + 1),
+			Action:      ReportActionBlock,
+			Target:      target,
+			SkylinkHash: "hash",
+			Result:      BlockerResultBlocked,
+			PrevHash:    prev.Hash,
+		}
+		e.Hash = e.digest()
+		entries = append(entries, e)
+		prev = e
+	}
+	verify := func(entries []ReportLogEntry) error {
+		var prev ReportLogEntry
+		for i := range entries {
+			if err := entries[i].verify(&prev); err != nil {
+				return err
+			}
+			prev = entries[i]
+		}
+		return nil
+	}
+	if err := verify(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	altered := append([]ReportLogEntry{}, entries...)
+	altered[1].Result = BlockerResultFailed
+	removed := []ReportLogEntry{entries[0], entries[2]}
+	reordered := []ReportLogEntry{entries[0], entries[2], entries[1]}
+	// Renumbering the entries after a removed one breaks the chain as well.
+	renumbered := []ReportLogEntry{entries[0], entries[2]}
+	renumbered[1].Seq = 2
+	for _, tampered := range [][]ReportLogEntry{altered, removed, reordered, renumbered} {
+		if err := verify(tampered); !errors.Contains(err, ErrReportLogTampered) {
+			t.Fatalf("Expected %v, got %v", ErrReportLogTampered, err)
+		}
+	}
+}
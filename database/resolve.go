@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collV2Resolutions defines the name of the collection which caches the
+	// v1 skylinks v2 skylinks resolved to.
+	collV2Resolutions = "v2_resolutions"
+	// v2CacheDBTimeout is how long we wait for the DB cache of resolutions.
+	v2CacheDBTimeout = 5 * time.Second
+)
+
+var (
+	// V2CacheTTL is how long we remember the v1 skylink a v2 skylink
+	// resolved to, so bulk submissions of the same v2 skylinks don't hammer
+	// the portal with HEAD requests. v2 skylinks can be updated to point to
+	// other content, so a resolution can be this stale. Zero disables the
+	// cache.
+	// Set according to the MALWARE_SCANNER_V2_CACHE_TTL env var.
+	V2CacheTTL = 5 * time.Minute
+	// V2CacheSize is the maximum number of resolutions we keep in memory.
+	// Set according to the MALWARE_SCANNER_V2_CACHE_SIZE env var.
+	V2CacheSize = 10000
+	// MaxV2ResolutionDepth is the number of nested v2 skylinks we resolve
+	// through before we give up on a v2 skylink, so a hostile chain of
+	// registry entries can't keep us resolving.
+	// Set according to the MALWARE_SCANNER_MAX_V2_RESOLUTION_DEPTH env var.
+	MaxV2ResolutionDepth = 3
+
+	// v2Cache is the in-memory cache of resolutions.
+	v2Cache = newResolutionCache()
+)
+
+type (
+	// resolutionCache is an in-memory cache of v2 skylink resolutions, which
+	// is optionally backed by the DB, so it's shared between instances and
+	// survives restarts.
+	resolutionCache struct {
+		entries map[string]resolution
+		db      *DB
+		mu      sync.Mutex
+	}
+
+	// resolution is a cached v2 skylink resolution.
+	resolution struct {
+		V1        string    `bson:"v1"`
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+)
+
+// CacheV2Resolutions makes the cache of v2 skylink resolutions use the DB, in
+// addition to memory.
+func (db *DB) CacheV2Resolutions() {
+	v2Cache.mu.Lock()
+	v2Cache.db = db
+	v2Cache.mu.Unlock()
+}
+
+// newResolutionCache returns an empty cache of resolutions.
+func newResolutionCache() *resolutionCache {
+	return &resolutionCache{
+		entries: make(map[string]resolution),
+	}
+}
+
+// get returns the v1 skylink the given v2 skylink resolved to, if it's cached
+// and fresh. Failing to query the DB is treated as a miss.
+func (rc *resolutionCache) get(v2 string) (skymodules.Skylink, bool) {
+	now := Clock.Now()
+	rc.mu.Lock()
+	r, ok := rc.entries[v2]
+	db := rc.db
+	rc.mu.Unlock()
+	if (!ok || now.After(r.ExpiresAt)) && db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), v2CacheDBTimeout)
+		defer cancel()
+		ok = db.Collection(collV2Resolutions).FindOne(ctx, bson.M{"_id": v2}).Decode(&r) == nil
+		if ok {
+			rc.add(v2, r)
+		}
+	}
+	var sl skymodules.Skylink
+	if !ok || now.After(r.ExpiresAt) || sl.LoadString(r.V1) != nil {
+		return skymodules.Skylink{}, false
+	}
+	return sl, true
+}
+
+// put caches the v1 skylink the given v2 skylink resolved to. Failing to save
+// it in the DB is ignored, the cache is only an optimisation.
+func (rc *resolutionCache) put(v2 string, v1 skymodules.Skylink) {
+	r := resolution{
+		V1:        v1.String(),
+		ExpiresAt: Clock.Now().Add(V2CacheTTL).UTC(),
+	}
+	rc.add(v2, r)
+	rc.mu.Lock()
+	db := rc.db
+	rc.mu.Unlock()
+	if db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), v2CacheDBTimeout)
+	defer cancel()
+	opts := options.Replace().SetUpsert(true)
+	_, _ = db.Collection(collV2Resolutions).ReplaceOne(ctx, bson.M{"_id": v2}, r, opts)
+}
+
+// add adds the resolution to the in-memory cache. When the cache is full, we
+// drop the expired resolutions or, if there are none, an arbitrary one.
+func (rc *resolutionCache) add(v2 string, r resolution) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if _, exists := rc.entries[v2]; !exists && len(rc.entries) >= V2CacheSize {
+		now := Clock.Now()
+		for k, e := range rc.entries {
+			if now.After(e.ExpiresAt) {
+				delete(rc.entries, k)
+			}
+		}
+		for k := range rc.entries {
+			if len(rc.entries) < V2CacheSize {
+				break
+			}
+			delete(rc.entries, k)
+		}
+	}
+	if V2CacheSize > 0 {
+		rc.entries[v2] = r
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestResolveSkylinkV2Cache ensures we only resolve a v2 skylink with the
+// portal again once its cached resolution expires.
+func TestResolveSkylinkV2Cache(t *testing.T) {
+	defer gock.Off()
+	defer func(c *resolutionCache) { v2Cache = c }(v2Cache)
+	v2Cache = newResolutionCache()
+
+	v1 := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	v2 := "AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw"
+	var sl skymodules.Skylink
+	if err := sl.LoadString(v2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first resolution hits the portal.
+	gock.New(testPortal).
+		Head(v2).
+		Reply(200).
+		SetHeader("skynet-skylink", v1)
+	for i := 0; i < 3; i++ {
+		sl2, err := resolveSkylinkV2(sl, testPortal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sl2.String() != v1 {
+			t.Fatalf("Expected to get v1 skylink %s, got %s", v1, sl2.String())
+		}
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected the portal to be called")
+	}
+
+	// Expired resolutions are resolved again.
+	v2Cache.add(v2, resolution{V1: v1, ExpiresAt: time.Now().Add(-time.Second)})
+	gock.New(testPortal).
+		Head(v2).
+		Reply(200).
+		SetHeader("skynet-skylink", v1)
+	if _, err := resolveSkylinkV2(sl, testPortal); err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected the portal to be called again")
+	}
+}
+
+// TestResolutionCacheSize ensures the in-memory cache doesn't grow beyond
+// its maximum size, dropping expired resolutions first.
+func TestResolutionCacheSize(t *testing.T) {
+	defer func(n int) { V2CacheSize = n }(V2CacheSize)
+	V2CacheSize = 2
+	rc := newResolutionCache()
+	fresh := time.Now().Add(time.Hour)
+	rc.add("a", resolution{ExpiresAt: fresh})
+	rc.add("b", resolution{ExpiresAt: time.Now().Add(-time.Hour)})
+	rc.add("c", resolution{ExpiresAt: fresh})
+	if _, ok := rc.entries["b"]; ok || len(rc.entries) != 2 {
+		t.Fatalf("Expected the expired resolution to be dropped, got %v", rc.entries)
+	}
+	rc.add("d", resolution{ExpiresAt: fresh})
+	if _, ok := rc.entries["d"]; !ok || len(rc.entries) != 2 {
+		t.Fatalf("Unexpected resolutions %v", rc.entries)
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// VerdictClassInfected covers the infected records which were reported
+	// or otherwise completed, including those marked as false positives,
+	// since they record an admin's decision.
+	VerdictClassInfected = "infected"
+	// VerdictClassClean covers the records which were scanned and found
+	// clean.
+	VerdictClassClean = "clean"
+	// VerdictClassFailed covers the records which are still waiting for a
+	// verdict because their latest scan attempt failed, and those which
+	// are gone because their content doesn't exist anymore.
+	VerdictClassFailed = "failed"
+)
+
+// VerdictClasses lists the verdict classes records can be retained by.
+var VerdictClasses = []string{VerdictClassInfected, VerdictClassClean, VerdictClassFailed}
+
+// DeleteExpired removes the records of the given verdict class which are
+// older than the given time and returns their number. Infected and clean
+// records age from the time they received their verdict, failed records from
+// the time they were submitted. Records which are being scanned, reported or
+// reviewed are never removed.
+func (db *DB) DeleteExpired(ctx context.Context, class string, before time.Time) (int64, error) {
+	var filter bson.M
+	switch class {
+	case VerdictClassInfected:
+		filter = bson.M{
+			"status":     SkylinkStatusComplete,
+			"$or":        bson.A{bson.M{"infected": true}, bson.M{"false_positive": true}},
+			"scanned_at": bson.M{"$lt": before},
+		}
+	case VerdictClassClean:
+		filter = bson.M{
+			"status":         SkylinkStatusComplete,
+			"infected":       false,
+			"false_positive": bson.M{"$ne": true},
+			"scanned_at":     bson.M{"$lt": before},
+		}
+	case VerdictClassFailed:
+		filter = bson.M{
+			"$or": bson.A{
+				bson.M{"status": SkylinkStatusNew, "last_error_kind": bson.M{"$exists": true}},
+				bson.M{"status": SkylinkStatusGone},
+			},
+			"submitted_at": bson.M{"$lt": before},
+		}
+	default:
+		return 0, errors.New("unknown verdict class " + class)
+	}
+	res, err := db.Collection(collSkylinks).DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to delete expired "+class+" records")
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+)
+
+// PendingReviews returns up to the given number of detections which are
+// pending review, the most severe first and, among equally severe ones, the
+// oldest first.
+func (db *DB) PendingReviews(ctx context.Context, limit int) ([]Skylink, error) {
+	opts := options.Find().
+		SetSort(bson.D{{"severity", -1}, {"_id", 1}}).
+		SetLimit(int64(limit))
+	c, err := db.Collection(collSkylinks).Find(ctx, bson.M{"status": SkylinkStatusPendingReview}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch pending reviews")
+	}
+	sls := []Skylink{}
+	if err = c.All(ctx, &sls); err != nil {
+		return nil, errors.AddContext(err, "failed to decode pending reviews")
+	}
+	return sls, nil
+}
+
+// SkylinkApproveReview approves the detection of the record with the given
+// hash, which is pending review, so it's reported to blocker like any other
+// detection. It returns ErrNoDocumentsFound if there's no such record.
+func (db *DB) SkylinkApproveReview(ctx context.Context, hash crypto.Hash) (*Skylink, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"status":    SkylinkStatusUnreported,
+			"timestamp": Clock.Now().UTC(),
+		},
+	}
+	return db.review(ctx, hash, update)
+}
+
+// SkylinkRejectReview rejects the detection of the record with the given hash,
+// which is pending review, and marks the record as a false positive, so it's
+// clean and never reported. It returns ErrNoDocumentsFound if there's no such
+// record.
+func (db *DB) SkylinkRejectReview(ctx context.Context, hash crypto.Hash) (*Skylink, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"skylink":        "",
+			"status":         SkylinkStatusComplete,
+			"infected":       false,
+			"false_positive": true,
+			"timestamp":      Clock.Now().UTC(),
+		},
+	}
+	return db.review(ctx, hash, update)
+}
+
+// review applies the given update to the record with the given hash if it's
+// pending review and returns the updated record.
+func (db *DB) review(ctx context.Context, hash crypto.Hash, update bson.M) (*Skylink, error) {
+	filter := bson.M{
+		"hash":   hash,
+		"status": SkylinkStatusPendingReview,
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var sl Skylink
+	err := db.Collection(collSkylinks).FindOneAndUpdate(ctx, filter, update, opts).Decode(&sl)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to review skylink")
+	}
+	return &sl, nil
+}
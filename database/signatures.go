@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collSignatureUpdates defines the name of the collection which holds
+	// the ClamAV signature versions we've been notified about.
+	collSignatureUpdates = "signature_updates"
+)
+
+// SignatureUpdate records a new version of ClamAV's signature database.
+// Source describes who told us about it. RescannedAt marks when we re-queued
+// the clean skylinks scanned with older signatures and Rescanned counts them.
+type SignatureUpdate struct {
+	Version     int       `bson:"_id" json:"version"`
+	Date        time.Time `bson:"date,omitempty" json:"date,omitempty"`
+	Source      string    `bson:"source" json:"source"`
+	ReceivedAt  time.Time `bson:"received_at" json:"receivedAt"`
+	RescannedAt time.Time `bson:"rescanned_at,omitempty" json:"rescannedAt,omitempty"`
+	Rescanned   int64     `bson:"rescanned" json:"rescanned"`
+}
+
+// SaveSignatureUpdate records the given signature update. It returns whether
+// it's a version we haven't seen before. Updates of known versions are
+// ignored.
+func (db *DB) SaveSignatureUpdate(ctx context.Context, su SignatureUpdate) (bool, error) {
+	insert := bson.M{
+		"source":      su.Source,
+		"received_at": su.ReceivedAt,
+		"rescanned":   0,
+	}
+	if !su.Date.IsZero() {
+		insert["date"] = su.Date
+	}
+	opts := options.Update().SetUpsert(true)
+	res, err := db.Collection(collSignatureUpdates).UpdateOne(ctx, bson.M{"_id": su.Version}, bson.M{"$setOnInsert": insert}, opts)
+	if err != nil {
+		return false, errors.AddContext(err, "failed to save signature update")
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+// LatestSignatureUpdate returns the update with the highest signature version.
+// It returns ErrNoDocumentsFound if we haven't recorded any.
+func (db *DB) LatestSignatureUpdate(ctx context.Context) (*SignatureUpdate, error) {
+	opts := options.FindOne().SetSort(bson.D{{"_id", -1}})
+	var su SignatureUpdate
+	err := db.Collection(collSignatureUpdates).FindOne(ctx, bson.M{}, opts).Decode(&su)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch the latest signature update")
+	}
+	return &su, nil
+}
+
+// MarkSignatureUpdateRescanned records that we re-queued the given number of
+// skylinks after the given signature update.
+func (db *DB) MarkSignatureUpdateRescanned(ctx context.Context, version int, rescanned int64) error {
+	update := bson.M{"$set": bson.M{
+		"rescanned_at": Clock.Now().UTC(),
+		"rescanned":    rescanned,
+	}}
+	_, err := db.Collection(collSignatureUpdates).UpdateOne(ctx, bson.M{"_id": version}, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to mark signature update as rescanned")
+	}
+	return nil
+}
+
+// RescanClean queues the clean skylinks which were scanned within the given
+// time range for scanning again. Only records which kept their skylink for
+// re-scans qualify. It returns the number of queued skylinks.
+func (db *DB) RescanClean(ctx context.Context, from, to time.Time) (int64, error) {
+	filter := bson.M{
+		"status":         SkylinkStatusComplete,
+		"infected":       false,
+		"scanned_at":     bson.M{"$gte": from, "$lt": to},
+		"rescan_skylink": bson.M{"$gt": ""},
+	}
+	now := Clock.Now().UTC()
+	// Use an update pipeline, so we can copy the skylink over from
+	// rescan_skylink.
+	update := mongo.Pipeline{
+		{{"$set", bson.M{
+			"skylink":      "$rescan_skylink",
+			"status":       SkylinkStatusNew,
+			"timestamp":    now,
+			"submitted_at": now,
+			"source":       SourceRescan,
+		}}},
+		{{"$unset", "submitter"}},
+	}
+	res, err := db.Collection(collSkylinks).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to queue clean skylinks for rescan")
+	}
+	return res.ModifiedCount, nil
+}
+
+// ExpireRescanSkylinks removes the skylinks we kept for re-scans from the
+// records scanned before the given time. It returns the number of updated
+// records.
+func (db *DB) ExpireRescanSkylinks(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.M{
+		"rescan_skylink": bson.M{"$exists": true},
+		"scanned_at":     bson.M{"$lt": before},
+	}
+	update := bson.M{"$unset": bson.M{"rescan_skylink": ""}}
+	res, err := db.Collection(collSkylinks).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to expire rescan skylinks")
+	}
+	return res.ModifiedCount, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+	"unicode/utf8"
 
 	accdb "github.com/SkynetLabs/skynet-accounts/database"
 	"gitlab.com/NebulousLabs/errors"
@@ -13,6 +14,15 @@ import (
 	"go.sia.tech/siad/crypto"
 )
 
+// MaxDescriptionLength is the number of bytes of infection descriptions and
+// error messages we store. ClamAV's signature names are far shorter, so longer
+// ones come from hostile peers or portals.
+const MaxDescriptionLength = 1024
+
+// MaxRootLength is the maximum number of bytes of a sector we scan for records
+// submitted by merkle root, the size of a sector.
+const MaxRootLength = skymodules.SkylinkMaxFetchSize
+
 var (
 	// ErrInvalidSkylink is the error returned when the passed skylink is
 	// invalid.
@@ -27,8 +37,68 @@ var (
 	// found to be malicious but before it has been successfully reported to the
 	// blocker service. We only use this status if we fail to talk to blocker.
 	SkylinkStatusUnreported = "unreported"
+	// SkylinkStatusPendingReview is the status of the skylink after it has
+	// been found to be malicious, if the scanner's policy holds it back until
+	// a human decides whether it's reported to blocker.
+	SkylinkStatusPendingReview = "pending_review"
 	// SkylinkStatusComplete is the status of the skylink after it's scanned.
 	SkylinkStatusComplete = "complete"
+	// SkylinkStatusGone is the status of the skylink after the portal
+	// confirmed enough times that its content doesn't exist anymore. We
+	// don't try to scan it again unless it's queued for a re-scan.
+	SkylinkStatusGone = "gone"
+
+	// BlockerResultBlocked means blocker blocked the skylink.
+	BlockerResultBlocked = "blocked"
+	// BlockerResultDuplicate means blocker had already blocked the skylink.
+	BlockerResultDuplicate = "duplicate"
+	// BlockerResultFailed means the report failed and will be retried.
+	BlockerResultFailed = "failed"
+
+	// PriorityNormal is the scanning priority of regular submissions.
+	PriorityNormal = 0
+	// PriorityHigh is the scanning priority of skylinks which users have
+	// reported as abusive. They are scanned before any regular submissions.
+	PriorityHigh = 10
+	// PriorityLow is the scanning priority of bulk backfills. They are only
+	// scanned while there are no other submissions.
+	PriorityLow = -10
+
+	// SourceUser is the source of scans users requested via the API.
+	SourceUser = "user"
+	// SourceUploadHook is the source of scans the portal's upload hook
+	// requested.
+	SourceUploadHook = "upload_hook"
+	// SourceQueue is the source of scans requested via the message queue.
+	SourceQueue = "queue"
+	// SourceReport is the source of scans of skylinks reported as abusive.
+	SourceReport = "report"
+	// SourceBackfill is the source of scans of bulk backfills.
+	SourceBackfill = "backfill"
+	// SourceAdmin is the source of re-scans an admin requested.
+	SourceAdmin = "admin"
+	// SourceRescan is the source of re-scans of clean skylinks after
+	// signature updates.
+	SourceRescan = "rescan"
+	// SourceCampaign is the source of re-scans of re-scan campaigns.
+	SourceCampaign = "campaign"
+	// SourceRollback is the source of re-scans of skylinks detected by a
+	// withdrawn signature.
+	SourceRollback = "rollback"
+
+	// PolicyActionBlock means the policy reports a detection to blocker.
+	PolicyActionBlock = "block"
+	// PolicyActionReview means the policy holds a detection back from
+	// blocker, so a human can decide whether it's blocked.
+	PolicyActionReview = "review"
+	// PolicyActionIgnore means the policy neither reports a detection nor
+	// holds it back for review.
+	PolicyActionIgnore = "ignore"
+
+	// PortalClient is the HTTP client we use for resolving v2 skylinks. It's
+	// set in main to the scanner's portal client, so resolutions reuse the
+	// connections downloads keep alive.
+	PortalClient = http.DefaultClient
 )
 
 // Skylink represents a skylink in the queue and holds its scanning status.
@@ -44,17 +114,121 @@ var (
 //
 // Timestamp marks the last status change that happened to the record. It
 // can be the time when it was created, locked for scanning, or scanned.
+// SubmittedAt and ScannedAt mark when the skylink was submitted for scanning
+// and when it received its verdict. We use them to track whether we meet our
+// scanning SLA.
+//
+// Failures counts the failed scan attempts. LastErrorKind and LastError
+// describe the latest failure and are cleared once the scan succeeds.
+// NotFound counts the failed attempts at which the portal responded that the
+// content doesn't exist, which eventually marks the skylink as gone.
+//
+// Blocker holds blocker's response to our latest attempt to report the
+// skylink, so we can verify the detection resulted in a block. Reports holds
+// the responses of all blocker targets, keyed by target name. The skylink
+// stays unreported until every target has blocked it, and Blocker mirrors the
+// response of the first target.
+//
+// FalsePositive marks records whose infected verdict an admin has overridden.
+// The InfectionDescription of such records is kept for reference.
+//
+// Priority determines the order in which new skylinks are scanned, highest
+// first. Reporter identifies who reported the skylink as abusive, if anyone.
+// Backfill is the ID of the last backfill job which enqueued the skylink.
+// Campaign is the ID of the last re-scan campaign which queued the skylink
+// again and PriorInfected the verdict the skylink had at that time.
+// Rollback is the withdrawn signature an infected skylink was queued for
+// scanning again for, until it has a verdict again.
+//
+// Uploaders lists the portal users who uploaded infected skylinks, if we look
+// them up in skynet-accounts. Unpinned marks blocked skylinks which we removed
+// from the portal's storage.
+//
+// VerdictSource names the external blocklist the verdict was imported from.
+// It's empty for verdicts of our own scans.
+//
+// RescanSkylink keeps the skylink of a clean record after it's been scanned,
+// so we can scan it again when ClamAV's signatures are updated. It's only set
+// while re-scans are enabled and expires with the re-scan lookback.
+//
+// Severity is the score the scanner's policy gave the detection of an infected
+// skylink and PolicyAction the action it took on it, one of the PolicyAction
+// constants. Both are empty if the scanner has no policy.
+//
+// SignatureVersion is the version of ClamAV's signature database the skylink
+// was scanned with. It's zero if we don't know it, e.g. for verdicts we didn't
+// reach ourselves.
+//
+// RootLength is only set on records submitted by the merkle root of a sector
+// rather than by a skylink. It's the number of bytes of the sector we download
+// and scan. The Skylink of such records is a v1 skylink built from the root,
+// so they're reported to blocker like any other record.
+//
+// Filename, ContentType and Directory describe the skyfile as the portal
+// served it when we scanned it, see clamav.FileInfo. The Filename is stored
+// encrypted, like the skylink.
+//
+// Source tells what queued the latest scan of the skylink, one of the Source
+// constants, so we can tell scans users requested from automated ones. It's
+// empty for records which predate it. Submitter identifies who requested the
+// scan, if a user or an admin did: the hashed API key or IP address of the
+// submitter, or the name of the admin. It's stored encrypted, like the
+// skylink.
 type Skylink struct {
-	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"-"`
-	Hash                 crypto.Hash        `bson:"hash" json:"hash"`
-	Skylink              string             `bson:"skylink" json:"skylink"`
-	Status               string             `bson:"status" json:"status"`
-	Infected             bool               `bson:"infected" json:"infected"`
-	InfectionDescription string             `bson:"infection_description" json:"infectionDescription"`
-	ScannedAllContent    bool               `bson:"scanned_all_content" json:"scannedAllContent"`
-	ScannedAllOffsets    bool               `bson:"scanned_all_offsets" json:"scannedAllOffsets"`
-	Size                 uint64             `bson:"size" json:"size"`
-	Timestamp            time.Time          `bson:"timestamp" json:"timestamp"`
+	ID                   primitive.ObjectID          `bson:"_id,omitempty" json:"-"`
+	Hash                 crypto.Hash                 `bson:"hash" json:"hash"`
+	Skylink              string                      `bson:"skylink" json:"skylink"`
+	Status               string                      `bson:"status" json:"status"`
+	Infected             bool                        `bson:"infected" json:"infected"`
+	InfectionDescription string                      `bson:"infection_description" json:"infectionDescription"`
+	ScannedAllContent    bool                        `bson:"scanned_all_content" json:"scannedAllContent"`
+	ScannedAllOffsets    bool                        `bson:"scanned_all_offsets" json:"scannedAllOffsets"`
+	Size                 uint64                      `bson:"size" json:"size"`
+	ScannedSize          uint64                      `bson:"scanned_size" json:"scannedSize"`
+	Timestamp            time.Time                   `bson:"timestamp" json:"timestamp"`
+	SubmittedAt          time.Time                   `bson:"submitted_at" json:"submittedAt"`
+	ScannedAt            time.Time                   `bson:"scanned_at,omitempty" json:"scannedAt,omitempty"`
+	Failures             int                         `bson:"failures" json:"failures"`
+	LastErrorKind        string                      `bson:"last_error_kind,omitempty" json:"lastErrorKind,omitempty"`
+	LastError            string                      `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	NotFound             int                         `bson:"not_found,omitempty" json:"notFound,omitempty"`
+	Blocker              *BlockerResponse            `bson:"blocker,omitempty" json:"blocker,omitempty"`
+	Reports              map[string]*BlockerResponse `bson:"reports,omitempty" json:"reports,omitempty"`
+	FalsePositive        bool                        `bson:"false_positive,omitempty" json:"falsePositive,omitempty"`
+	Priority             int                         `bson:"priority,omitempty" json:"priority,omitempty"`
+	Reporter             string                      `bson:"reporter,omitempty" json:"reporter,omitempty"`
+	Backfill             *primitive.ObjectID         `bson:"backfill,omitempty" json:"backfill,omitempty"`
+	Campaign             *primitive.ObjectID         `bson:"campaign,omitempty" json:"campaign,omitempty"`
+	PriorInfected        *bool                       `bson:"prior_infected,omitempty" json:"priorInfected,omitempty"`
+	Rollback             string                      `bson:"rollback,omitempty" json:"rollback,omitempty"`
+	Uploaders            []Uploader                  `bson:"uploaders,omitempty" json:"uploaders,omitempty"`
+	Unpinned             bool                        `bson:"unpinned,omitempty" json:"unpinned,omitempty"`
+	VerdictSource        string                      `bson:"verdict_source,omitempty" json:"verdictSource,omitempty"`
+	RescanSkylink        string                      `bson:"rescan_skylink,omitempty" json:"-"`
+	SignatureVersion     int                         `bson:"signature_version,omitempty" json:"signatureVersion,omitempty"`
+	Severity             int                         `bson:"severity,omitempty" json:"severity,omitempty"`
+	PolicyAction         string                      `bson:"policy_action,omitempty" json:"policyAction,omitempty"`
+	RootLength           uint64                      `bson:"root_length,omitempty" json:"rootLength,omitempty"`
+	Filename             string                      `bson:"filename,omitempty" json:"filename,omitempty"`
+	ContentType          string                      `bson:"content_type,omitempty" json:"contentType,omitempty"`
+	Directory            bool                        `bson:"directory,omitempty" json:"directory,omitempty"`
+	Source               string                      `bson:"source,omitempty" json:"source,omitempty"`
+	Submitter            string                      `bson:"submitter,omitempty" json:"submitter,omitempty"`
+}
+
+// BlockerResponse describes blocker's response to a report. Result is one of
+// the BlockerResult constants. BlockID is only set if blocker returns one.
+type BlockerResponse struct {
+	Result     string    `bson:"result" json:"result"`
+	StatusCode int       `bson:"status_code,omitempty" json:"statusCode,omitempty"`
+	BlockID    string    `bson:"block_id,omitempty" json:"blockId,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	ReportedAt time.Time `bson:"reported_at" json:"reportedAt"`
+}
+
+// Succeeded returns whether the report resulted in a block.
+func (br *BlockerResponse) Succeeded() bool {
+	return br != nil && (br.Result == BlockerResultBlocked || br.Result == BlockerResultDuplicate)
 }
 
 // LoadString parses a skylink from string and populates all required fields.
@@ -81,20 +255,76 @@ func (s *Skylink) LoadString(skylink, portal string) error {
 	default:
 		return renter.ErrInvalidSkylinkVersion
 	}
+	s.setDefaults()
+	return nil
+}
+
+// LoadRoot populates all required fields from the given hex-encoded merkle root
+// of a sector and the number of bytes of the sector to scan, for content we
+// don't have a skylink of. The record gets a v1 skylink built from the root,
+// which identifies the same content to blocker.
+func (s *Skylink) LoadRoot(root string, length uint64) error {
+	var mr crypto.Hash
+	if err := mr.LoadString(root); err != nil {
+		return errors.AddContext(err, "invalid merkle root")
+	}
+	if length == 0 || length > MaxRootLength {
+		return fmt.Errorf("length must be between 1 and %d bytes", MaxRootLength)
+	}
+	sl, err := skymodules.NewSkylinkV1(mr, 0, length)
+	if err != nil {
+		return err
+	}
+	s.Skylink = sl.String()
+	s.Hash = crypto.HashObject(mr)
+	s.RootLength = length
+	s.setDefaults()
+	return nil
+}
+
+// DownloadPath returns the path relative to the portal's URL we download the
+// record's content from. Records submitted by merkle root are downloaded from
+// the portal's /skynet/root endpoint, the others by their skylink.
+func (s *Skylink) DownloadPath() string {
+	if s.RootLength == 0 {
+		return s.Skylink
+	}
+	var sl skymodules.Skylink
+	if err := sl.LoadString(s.Skylink); err != nil {
+		return s.Skylink
+	}
+	return fmt.Sprintf("skynet/root?root=%s&offset=0&length=%d", sl.MerkleRoot(), s.RootLength)
+}
 
+// setDefaults fills in the submission time and the status of a new record.
+func (s *Skylink) setDefaults() {
 	if s.Timestamp.IsZero() {
-		s.Timestamp = time.Now().UTC()
+		s.Timestamp = Clock.Now().UTC()
+	}
+	if s.SubmittedAt.IsZero() {
+		s.SubmittedAt = s.Timestamp
 	}
 	if s.Status == "" {
 		s.Status = SkylinkStatusNew
 	}
-	return nil
 }
 
 // resolveSkylinkV2 returns the v1 skylink to which the given v2 skylink is
-// currently pointing. Resolves up to three levels of nested v2 skylinks.
+// currently pointing. Resolves up to MaxV2ResolutionDepth levels of nested v2
+// skylinks. Resolutions are cached for V2CacheTTL.
 func resolveSkylinkV2(s skymodules.Skylink, portal string) (*skymodules.Skylink, error) {
-	return recursivelyResolveSkylinkV2(s, portal, 3)
+	if V2CacheTTL <= 0 {
+		return recursivelyResolveSkylinkV2(s, portal, MaxV2ResolutionDepth)
+	}
+	if sl, ok := v2Cache.get(s.String()); ok {
+		return &sl, nil
+	}
+	sl, err := recursivelyResolveSkylinkV2(s, portal, MaxV2ResolutionDepth)
+	if err != nil {
+		return nil, err
+	}
+	v2Cache.put(s.String(), *sl)
+	return sl, nil
 }
 
 // recursivelyResolveSkylinkV2 resolves a v2 skylink to the v1 skylink it points
@@ -107,10 +337,11 @@ func recursivelyResolveSkylinkV2(s skymodules.Skylink, portal string, attemptsLe
 	if !s.IsSkylinkV2() {
 		return nil, renter.ErrInvalidSkylinkVersion
 	}
-	resp, err := http.Head(fmt.Sprintf("%s/%s", portal, s.String()))
+	resp, err := PortalClient.Head(fmt.Sprintf("%s/%s", portal, s.String()))
 	if err != nil {
 		return nil, errors.AddContext(err, fmt.Sprintf("failed to download metadata for skylink %s", s.String()))
 	}
+	_ = resp.Body.Close()
 	skylinkHeader := resp.Header.Get("skynet-skylink")
 	if skylinkHeader == "" {
 		return nil, errors.New("empty skynet-skylink header")
@@ -127,3 +358,17 @@ func recursivelyResolveSkylinkV2(s skymodules.Skylink, portal string, attemptsLe
 	}
 	return &sl, nil
 }
+
+// capDescription truncates the given description or error message to
+// MaxDescriptionLength bytes, without splitting a character.
+func capDescription(s string) string {
+	if len(s) <= MaxDescriptionLength {
+		return s
+	}
+	// Back up to the start of the character the limit falls into.
+	n := MaxDescriptionLength
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -2,13 +2,17 @@ package database
 
 import (
 	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/SkynetLabs/skyd/skymodules"
 	"gitlab.com/SkynetLabs/skyd/skymodules/renter"
+	"go.sia.tech/siad/crypto"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -75,6 +79,51 @@ func TestSkylink_LoadString(t *testing.T) {
 	}
 }
 
+// TestSkylink_LoadRoot ensures that records submitted by merkle root get a
+// skylink of the same content and are downloaded by their root.
+func TestSkylink_LoadRoot(t *testing.T) {
+	v1 := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	var v1sl skymodules.Skylink
+	if err := v1sl.LoadString(v1); err != nil {
+		t.Fatal(err)
+	}
+	root := v1sl.MerkleRoot().String()
+
+	// Invalid
+	var sl Skylink
+	if err := sl.LoadRoot("not a root", 4096); err == nil {
+		t.Fatal("Expected an invalid root to fail")
+	}
+	for _, length := range []uint64{0, MaxRootLength + 1} {
+		if err := sl.LoadRoot(root, length); err == nil || !strings.Contains(err.Error(), "length must be between") {
+			t.Fatalf("Expected length %d to fail, got %v", length, err)
+		}
+	}
+
+	// The record is identified like the skylink of the same content.
+	err := sl.LoadRoot(root, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var byLink Skylink
+	if err = byLink.LoadString(v1, testPortal); err != nil {
+		t.Fatal(err)
+	}
+	if sl.Hash != byLink.Hash || sl.Status != SkylinkStatusNew || sl.SubmittedAt.IsZero() {
+		t.Fatalf("Unexpected record %+v", sl)
+	}
+	var built skymodules.Skylink
+	if err = built.LoadString(sl.Skylink); err != nil || built.MerkleRoot() != v1sl.MerkleRoot() {
+		t.Fatalf("Expected a skylink of root %s, got %s, %v", root, sl.Skylink, err)
+	}
+	if path := sl.DownloadPath(); path != "skynet/root?root="+root+"&offset=0&length=1000" {
+		t.Fatalf("Unexpected download path %s", path)
+	}
+	if path := byLink.DownloadPath(); path != v1 {
+		t.Fatalf("Expected skylinks to be downloaded as is, got %s", path)
+	}
+}
+
 // TestRecursivelyResolveSkylinkV2 ensures recursivelyResolveSkylinkV2 works as
 // expected.
 func TestRecursivelyResolveSkylinkV2(t *testing.T) {
@@ -162,3 +211,85 @@ func TestRecursivelyResolveSkylinkV2(t *testing.T) {
 		t.Fatalf("Expected error '%s', got '%s'", "v2 skylinks are nested too deeply", err)
 	}
 }
+
+// fuzzPortal starts a portal which resolves every v2 skylink to the skylink in
+// the returned header value, for fuzzing the resolution of v2 skylinks.
+func fuzzPortal(f *testing.F) (*httptest.Server, *atomic.Value) {
+	var header atomic.Value
+	header.Store("")
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("skynet-skylink", header.Load().(string))
+	}))
+	f.Cleanup(portal.Close)
+	return portal, &header
+}
+
+// validHeader tells whether the given header value reaches the client as is.
+func validHeader(s string) bool {
+	return s == strings.TrimSpace(s) && !strings.ContainsAny(s, "\r\n\x00")
+}
+
+// FuzzSkylinkLoadString ensures loading arbitrary skylinks, which v2 skylinks
+// resolve to through arbitrary portal headers, doesn't panic and only accepts
+// skylinks which we can identify by the merkle root of a v1 skylink.
+func FuzzSkylinkLoadString(f *testing.F) {
+	defer func(ttl time.Duration) { V2CacheTTL = ttl }(V2CacheTTL)
+	V2CacheTTL = 0
+	portal, header := fuzzPortal(f)
+
+	v1 := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	v2 := "AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw"
+	for _, s := range [][2]string{{v1, ""}, {v2, v1}, {v2, v2}, {v2, ""}, {v2, "not a skylink"}, {"not a skylink", v1}, {v1 + "/path", v1}} {
+		f.Add(s[0], s[1])
+	}
+	f.Fuzz(func(t *testing.T, skylink, resolved string) {
+		if !validHeader(resolved) {
+			t.Skip()
+		}
+		header.Store(resolved)
+		var sl Skylink
+		if err := sl.LoadString(skylink, portal.URL); err != nil {
+			return
+		}
+		if sl.Skylink != skylink || sl.Status != SkylinkStatusNew || sl.Timestamp.IsZero() {
+			t.Fatalf("Skylink '%s' loaded as %+v", skylink, sl)
+		}
+		var parsed, expected skymodules.Skylink
+		if err := parsed.LoadString(skylink); err != nil {
+			t.Fatalf("Accepted skylink '%s' doesn't parse: %v", skylink, err)
+		}
+		expected = parsed
+		if parsed.IsSkylinkV2() {
+			if err := expected.LoadString(resolved); err != nil || !expected.IsSkylinkV1() {
+				t.Fatalf("Skylink '%s' resolved through invalid header '%s'", skylink, resolved)
+			}
+		}
+		if sl.Hash != crypto.HashObject(expected.MerkleRoot()) {
+			t.Fatalf("Skylink '%s' has hash %s, expected the hash of %s", skylink, sl.Hash, expected)
+		}
+	})
+}
+
+// FuzzResolveSkylinkV2 ensures arbitrary skynet-skylink headers don't make the
+// resolution of v2 skylinks panic or loop, and that it only ever resolves to
+// v1 skylinks.
+func FuzzResolveSkylinkV2(f *testing.F) {
+	portal, header := fuzzPortal(f)
+	var v2 skymodules.Skylink
+	if err := v2.LoadString("AQAh2vxStoSJ_M9tWcTgqebUWerCAbpMfn9xxa9E29UOuw"); err != nil {
+		f.Fatal(err)
+	}
+	for _, s := range []string{"CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw", v2.String(), "", "sia://CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw", "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw/path?x=1"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, resolved string) {
+		if !validHeader(resolved) {
+			t.Skip()
+		}
+		header.Store(resolved)
+		sl, err := recursivelyResolveSkylinkV2(v2, portal.URL, MaxV2ResolutionDepth)
+		if err == nil && !sl.IsSkylinkV1() {
+			t.Fatalf("Header '%s' resolved to non-v1 skylink %s", resolved, sl)
+		}
+	})
+}
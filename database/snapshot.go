@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+)
+
+type (
+	// Snapshot holds the full state of a set of skylink records together with
+	// their history, so the records can be exported from one scanner and
+	// imported into another, e.g. to reproduce a production incident in a
+	// dev environment.
+	Snapshot struct {
+		ExportedAt time.Time        `json:"exportedAt"`
+		Records    []RecordSnapshot `json:"records"`
+	}
+
+	// RecordSnapshot is a skylink record together with its history: the
+	// admin actions on it, newest first, and our reports about it to
+	// blocker, oldest first. RescanSkylink is the record's field of the same
+	// name, which the record's JSON leaves out.
+	RecordSnapshot struct {
+		Record        Skylink          `json:"record"`
+		RescanSkylink string           `json:"rescanSkylink,omitempty"`
+		Audit         []AuditEntry     `json:"audit"`
+		Reports       []ReportLogEntry `json:"reports"`
+	}
+)
+
+// ExportSnapshot returns a snapshot of the records of the given skylinks.
+// Skylinks without a record are left out.
+func (db *DB) ExportSnapshot(ctx context.Context, hashes []crypto.Hash) (*Snapshot, error) {
+	sls, err := db.Skylinks(ctx, hashes)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch skylink records")
+	}
+	s := &Snapshot{
+		ExportedAt: Clock.Now().UTC(),
+		Records:    make([]RecordSnapshot, 0, len(sls)),
+	}
+	for _, sl := range sls {
+		r := RecordSnapshot{
+			Record:        sl,
+			RescanSkylink: sl.RescanSkylink,
+		}
+		r.Audit, err = db.AuditEntries(ctx, AuditFilter{Hash: sl.Hash.String()})
+		if err != nil {
+			return nil, err
+		}
+		r.Reports, err = db.skylinkReportLog(ctx, sl.Hash)
+		if err != nil {
+			return nil, err
+		}
+		s.Records = append(s.Records, r)
+	}
+	return s, nil
+}
+
+// ImportSnapshot stores the records of the given snapshot, replacing any
+// records of the same skylinks. It returns the number of records it stored.
+// The records' history isn't imported: the audit log is the importing
+// scanner's own record of admin actions and the report log's hash chain
+// doesn't allow inserting entries.
+func (db *DB) ImportSnapshot(ctx context.Context, s *Snapshot) (int, error) {
+	opts := options.Replace().SetUpsert(true)
+	for i, r := range s.Records {
+		sl := r.Record
+		if sl.Hash == (crypto.Hash{}) {
+			return i, errors.New("record without a hash")
+		}
+		// The record keeps its ID if it exists and gets a new one
+		// otherwise.
+		sl.ID = primitive.ObjectID{}
+		sl.RescanSkylink = r.RescanSkylink
+		_, err := db.Collection(collSkylinks).ReplaceOne(ctx, bson.M{"hash": sl.Hash}, sl, opts)
+		if err != nil {
+			return i, errors.AddContext(err, "failed to import record "+sl.Hash.String())
+		}
+	}
+	return len(s.Records), nil
+}
+
+// skylinkReportLog returns the report log entries about the skylink with the
+// given hash, oldest first, with their skylinks and descriptions decrypted.
+func (db *DB) skylinkReportLog(ctx context.Context, hash crypto.Hash) ([]ReportLogEntry, error) {
+	opts := options.Find().SetSort(bson.D{{"_id", 1}})
+	c, err := db.Collection(collReportLog).Find(ctx, bson.M{"skylink_hash": hash.String()}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch report log entries")
+	}
+	entries := []ReportLogEntry{}
+	err = c.All(ctx, &entries)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode report log entries")
+	}
+	err = decryptReportLog(entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
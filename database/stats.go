@@ -0,0 +1,505 @@
+package database
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// SLATarget is the time within which we aim to give a verdict on each
+	// submitted skylink. Stats report which fraction of the scans met it.
+	// Set according to the MALWARE_SCANNER_SLA env var.
+	SLATarget = 30 * time.Minute
+
+	// AnomalyWindow is the recent period whose infection rate we compare
+	// against the baseline.
+	// Set according to the MALWARE_SCANNER_ANOMALY_WINDOW env var.
+	AnomalyWindow = time.Hour
+	// AnomalyBaseline is the period preceding AnomalyWindow over which we
+	// compute the baseline infection rate.
+	// Set according to the MALWARE_SCANNER_ANOMALY_BASELINE env var.
+	AnomalyBaseline = 7 * 24 * time.Hour
+	// AnomalyThreshold is the z-score above which we consider the recent
+	// infection rate anomalous.
+	// Set according to the MALWARE_SCANNER_ANOMALY_THRESHOLD env var.
+	AnomalyThreshold = 3.0
+	// AnomalyMinScans is the minimum number of scans within AnomalyWindow
+	// before we consider the recent infection rate meaningful.
+	// Set according to the MALWARE_SCANNER_ANOMALY_MIN_SCANS env var.
+	AnomalyMinScans int64 = 20
+
+	// The indexes of the stats and queue depth queries. Dashboards poll
+	// them every few seconds, so we hint the indexes and the queries fail
+	// rather than fall back to scanning the collection if one is missing.
+	//
+	// hintScanStats covers the scan stats query, which reads all of its
+	// fields from the index.
+	hintScanStats = bson.D{{"scanned_at", 1}, {"submitted_at", 1}, {"scanned_size", 1}}
+	// hintScannedAt serves counting the records scanned since a given time.
+	hintScannedAt = bson.D{{"scanned_at", 1}}
+	// hintInfectedScannedAt serves counting and grouping the infected
+	// records scanned since a given time.
+	hintInfectedScannedAt = bson.D{{"infected", 1}, {"scanned_at", 1}}
+	// hintStatusSubmittedAt serves finding the oldest record with a given
+	// status by submission time.
+	hintStatusSubmittedAt = bson.D{{"status", 1}, {"submitted_at", 1}}
+	// hintStatusScannedAt serves counting the records with a given status
+	// and finding the oldest of them by scan time.
+	hintStatusScannedAt = bson.D{{"status", 1}, {"scanned_at", 1}}
+
+	// latencyBoundaries are the lower boundaries in seconds of the buckets of
+	// the latency histogram we compute percentiles from. The last one is
+	// beyond any latency we expect.
+	latencyBoundaries = bson.A{0, 1, 2, 5, 10, 15, 30, 45, 60, 90, 120, 180, 240, 300, 450, 600, 900, 1200, 1800, 2700, 3600, 5400, 7200, 10800, 14400, 21600, 43200, 86400, 172800, 604800, math.MaxInt32}
+)
+
+type (
+	// HourlyThroughput describes how much work the scanner did within a
+	// given hour.
+	HourlyThroughput struct {
+		Hour         time.Time `json:"hour"`
+		Records      int       `json:"records"`
+		BytesScanned uint64    `json:"bytesScanned"`
+	}
+
+	// LatencyPercentiles describes the distribution of the time it took to go
+	// from submission to verdict, in seconds.
+	LatencyPercentiles struct {
+		P50 float64 `json:"p50"`
+		P90 float64 `json:"p90"`
+		P95 float64 `json:"p95"`
+		P99 float64 `json:"p99"`
+		Max float64 `json:"max"`
+	}
+
+	// ScanStats holds the scanner's throughput and SLA compliance over a
+	// period of time.
+	ScanStats struct {
+		Since        time.Time          `json:"since"`
+		Records      int                `json:"records"`
+		BytesScanned uint64             `json:"bytesScanned"`
+		Hourly       []HourlyThroughput `json:"hourly"`
+		Latency      LatencyPercentiles `json:"latency"`
+		SLATarget    float64            `json:"slaTarget"`
+		WithinSLA    float64            `json:"withinSLA"`
+	}
+
+	// QueueDepth describes how many skylinks are at each stage of the queue.
+	QueueDepth struct {
+		New        int64 `json:"new"`
+		Scanning   int64 `json:"scanning"`
+		Unreported int64 `json:"unreported"`
+		// PendingReview is the number of detections held back for
+		// review.
+		PendingReview int64 `json:"pendingReview"`
+		// Total is the number of records, including the scanned ones. It's
+		// estimated from the collection's metadata, so it's cheap to get
+		// but might be slightly off after an unclean shutdown.
+		Total int64 `json:"total"`
+	}
+
+	// Anomaly describes how the recent infection rate compares to the
+	// baseline. Window and Baseline are in seconds.
+	Anomaly struct {
+		Window         float64 `json:"window"`
+		Baseline       float64 `json:"baseline"`
+		RecentScanned  int64   `json:"recentScanned"`
+		RecentInfected int64   `json:"recentInfected"`
+		RecentRate     float64 `json:"recentRate"`
+		BaselineRate   float64 `json:"baselineRate"`
+		ZScore         float64 `json:"zScore"`
+		Anomalous      bool    `json:"anomalous"`
+	}
+
+	// SignatureCount describes how often a given malware signature was
+	// detected.
+	SignatureCount struct {
+		Signature string    `bson:"_id" json:"signature"`
+		Count     int       `bson:"count" json:"count"`
+		FirstSeen time.Time `bson:"first_seen" json:"firstSeen"`
+		LastSeen  time.Time `bson:"last_seen" json:"lastSeen"`
+	}
+
+	// FailureCount describes how many skylinks failed to be scanned with a
+	// given kind of error.
+	FailureCount struct {
+		Kind  string `bson:"_id" json:"kind"`
+		Count int    `bson:"count" json:"count"`
+	}
+
+	// scanStatsFacets holds the scan stats as they're aggregated in the
+	// DB: the throughput by hour and the latency histogram, both ascending.
+	scanStatsFacets struct {
+		Hourly  []hourlyBucket  `bson:"hourly"`
+		Latency []latencyBucket `bson:"latency"`
+	}
+
+	// hourlyBucket is the throughput within the hour starting at Hour.
+	hourlyBucket struct {
+		Hour         time.Time `bson:"_id"`
+		Records      int       `bson:"records"`
+		BytesScanned int64     `bson:"bytes_scanned"`
+	}
+
+	// latencyBucket is a bucket of the latency histogram. Lower is its lower
+	// boundary in seconds, Max the longest latency in it and WithinSLA the
+	// number of its latencies within SLATarget.
+	latencyBucket struct {
+		Lower     float64 `bson:"_id"`
+		Count     int64   `bson:"count"`
+		Max       float64 `bson:"max"`
+		WithinSLA int64   `bson:"within_sla"`
+	}
+
+	// scanTimes holds the subset of a Skylink record we need for computing
+	// stats.
+	scanTimes struct {
+		ScannedSize uint64    `bson:"scanned_size"`
+		SubmittedAt time.Time `bson:"submitted_at"`
+		ScannedAt   time.Time `bson:"scanned_at"`
+	}
+)
+
+// ScanStats returns the scanner's throughput and SLA compliance for all
+// skylinks that received their verdict since the given time. The records are
+// aggregated in the DB, so the window can hold any number of them.
+func (db *DB) ScanStats(ctx context.Context, since time.Time) (*ScanStats, error) {
+	scannedAt := bson.M{"$toLong": "$scanned_at"}
+	latency := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$scanned_at", "$submitted_at"}}, 1000}}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"scanned_at": bson.M{"$gte": since}}}},
+		{{"$project", bson.M{
+			"_id":          0,
+			"scanned_size": 1,
+			"hour":         bson.M{"$toDate": bson.M{"$subtract": bson.A{scannedAt, bson.M{"$mod": bson.A{scannedAt, time.Hour.Milliseconds()}}}}},
+			// Records created before we started tracking submission times
+			// don't have a meaningful latency.
+			"latency": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$submitted_at", time.Time{}}},
+				bson.M{"$max": bson.A{0, latency}},
+				nil,
+			}},
+		}}},
+		{{"$facet", bson.M{
+			"hourly": mongo.Pipeline{
+				{{"$group", bson.M{
+					"_id":           "$hour",
+					"records":       bson.M{"$sum": 1},
+					"bytes_scanned": bson.M{"$sum": "$scanned_size"},
+				}}},
+				{{"$sort", bson.M{"_id": 1}}},
+			},
+			"latency": mongo.Pipeline{
+				{{"$match", bson.M{"latency": bson.M{"$ne": nil}}}},
+				{{"$bucket", bson.M{
+					"groupBy":    "$latency",
+					"boundaries": latencyBoundaries,
+					"output": bson.M{
+						"count":      bson.M{"$sum": 1},
+						"max":        bson.M{"$max": "$latency"},
+						"within_sla": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$lte": bson.A{"$latency", SLATarget.Seconds()}}, 1, 0}}},
+					},
+				}}},
+			},
+		}}},
+	}
+	c, err := db.Collection(collSkylinks).Aggregate(ctx, pipeline, options.Aggregate().SetHint(hintScanStats))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to aggregate scan stats")
+	}
+	var facets []scanStatsFacets
+	err = c.All(ctx, &facets)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode scan stats")
+	}
+	if len(facets) == 0 {
+		facets = append(facets, scanStatsFacets{})
+	}
+	return computeScanStats(facets[0], since, SLATarget), nil
+}
+
+// computeScanStats turns the aggregated hourly throughput and latency
+// histogram into the scan stats. Percentiles are the longest latency within
+// the histogram bucket which holds the percentile's rank, so they overestimate
+// the exact percentile by less than the bucket's width.
+func computeScanStats(f scanStatsFacets, since time.Time, sla time.Duration) *ScanStats {
+	stats := &ScanStats{
+		Since:     since.UTC(),
+		Hourly:    []HourlyThroughput{},
+		SLATarget: sla.Seconds(),
+	}
+	for _, h := range f.Hourly {
+		stats.Records += h.Records
+		stats.BytesScanned += uint64(h.BytesScanned)
+		stats.Hourly = append(stats.Hourly, HourlyThroughput{
+			Hour:         h.Hour.UTC(),
+			Records:      h.Records,
+			BytesScanned: uint64(h.BytesScanned),
+		})
+	}
+	var total, withinSLA int64
+	for _, b := range f.Latency {
+		total += b.Count
+		withinSLA += b.WithinSLA
+	}
+	if total == 0 {
+		return stats
+	}
+	stats.Latency = LatencyPercentiles{
+		P50: histogramPercentile(f.Latency, total, 50),
+		P90: histogramPercentile(f.Latency, total, 90),
+		P95: histogramPercentile(f.Latency, total, 95),
+		P99: histogramPercentile(f.Latency, total, 99),
+		Max: f.Latency[len(f.Latency)-1].Max,
+	}
+	stats.WithinSLA = float64(withinSLA) / float64(total)
+	return stats
+}
+
+// histogramPercentile returns the p-th percentile of the latencies in the
+// given histogram, which holds total latencies in ascending buckets, using the
+// nearest-rank method.
+func histogramPercentile(buckets []latencyBucket, total int64, p float64) float64 {
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for _, b := range buckets {
+		seen += b.Count
+		if seen >= rank {
+			return b.Max
+		}
+	}
+	return buckets[len(buckets)-1].Max
+}
+
+// OldestQueued returns the submission time of the oldest skylink waiting to be
+// scanned. It returns ErrNoDocumentsFound if the queue is empty.
+func (db *DB) OldestQueued(ctx context.Context) (time.Time, error) {
+	filter := bson.M{"status": SkylinkStatusNew}
+	opts := options.FindOne().
+		SetSort(bson.D{{"submitted_at", 1}}).
+		SetProjection(bson.M{"submitted_at": 1}).
+		SetHint(hintStatusSubmittedAt)
+	sr := db.Collection(collSkylinks).FindOne(ctx, filter, opts)
+	if sr.Err() == mongo.ErrNoDocuments {
+		return time.Time{}, ErrNoDocumentsFound
+	}
+	if sr.Err() != nil {
+		return time.Time{}, sr.Err()
+	}
+	var st scanTimes
+	err := sr.Decode(&st)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.SubmittedAt, nil
+}
+
+// QueueDepth returns the number of skylinks waiting to be scanned, being
+// scanned, waiting to be reported to blocker and waiting for review. The counts are answered
+// from the index without reading the records.
+func (db *DB) QueueDepth(ctx context.Context) (*QueueDepth, error) {
+	coll := db.Collection(collSkylinks)
+	opts := options.Count().SetHint(hintStatusScannedAt)
+	var qd QueueDepth
+	counts := []struct {
+		status string
+		n      *int64
+	}{
+		{SkylinkStatusNew, &qd.New},
+		{SkylinkStatusScanning, &qd.Scanning},
+		{SkylinkStatusUnreported, &qd.Unreported},
+		{SkylinkStatusPendingReview, &qd.PendingReview},
+	}
+	for _, c := range counts {
+		n, err := coll.CountDocuments(ctx, bson.M{"status": c.status}, opts)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to count "+c.status+" skylinks")
+		}
+		*c.n = n
+	}
+	total, err := coll.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to estimate the number of skylinks")
+	}
+	qd.Total = total
+	return &qd, nil
+}
+
+// InfectionCounts returns the number of skylinks that received a verdict since
+// the given time and how many of them were infected.
+func (db *DB) InfectionCounts(ctx context.Context, since time.Time) (scanned int64, infected int64, err error) {
+	filter := bson.M{"scanned_at": bson.M{"$gte": since}}
+	scanned, err = db.Collection(collSkylinks).CountDocuments(ctx, filter, options.Count().SetHint(hintScannedAt))
+	if err != nil {
+		return 0, 0, errors.AddContext(err, "failed to count scanned skylinks")
+	}
+	filter["infected"] = true
+	infected, err = db.Collection(collSkylinks).CountDocuments(ctx, filter, options.Count().SetHint(hintInfectedScannedAt))
+	if err != nil {
+		return 0, 0, errors.AddContext(err, "failed to count infected skylinks")
+	}
+	return scanned, infected, nil
+}
+
+// SignatureStats returns the most frequently detected signatures among the
+// skylinks scanned within the given time range, up to limit signatures.
+func (db *DB) SignatureStats(ctx context.Context, from, to time.Time, limit int) ([]SignatureCount, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{
+			"infected":   true,
+			"scanned_at": bson.M{"$gte": from, "$lt": to},
+		}}},
+		{{"$group", bson.M{
+			"_id":        "$infection_description",
+			"count":      bson.M{"$sum": 1},
+			"first_seen": bson.M{"$min": "$scanned_at"},
+			"last_seen":  bson.M{"$max": "$scanned_at"},
+		}}},
+		{{"$sort", bson.D{{"count", -1}, {"_id", 1}}}},
+		{{"$limit", limit}},
+	}
+	c, err := db.Collection(collSkylinks).Aggregate(ctx, pipeline, options.Aggregate().SetHint(hintInfectedScannedAt))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to aggregate signature stats")
+	}
+	sigs := []SignatureCount{}
+	err = c.All(ctx, &sigs)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode signature stats")
+	}
+	for i := range sigs {
+		sigs[i].Signature, err = decryptField(sigs[i].Signature)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to decrypt signature stats")
+		}
+	}
+	return sigs, nil
+}
+
+// ScanFailures returns the number of skylinks whose latest scan attempt
+// failed since the given time, by the kind of error, the most frequent kind
+// first. Skylinks which were scanned successfully after a failure aren't
+// counted.
+func (db *DB) ScanFailures(ctx context.Context, since time.Time) ([]FailureCount, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{
+			"last_error_kind": bson.M{"$exists": true},
+			"timestamp":       bson.M{"$gte": since},
+		}}},
+		{{"$group", bson.M{
+			"_id":   "$last_error_kind",
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{"$sort", bson.D{{"count", -1}, {"_id", 1}}}},
+	}
+	c, err := db.Collection(collSkylinks).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to aggregate scan failures")
+	}
+	fcs := []FailureCount{}
+	err = c.All(ctx, &fcs)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to decode scan failures")
+	}
+	return fcs, nil
+}
+
+// UnreportedSkylinks returns a cursor over the infected skylinks which
+// haven't been reported to all blocker targets yet, oldest first.
+func (db *DB) UnreportedSkylinks(ctx context.Context) (*mongo.Cursor, error) {
+	filter := bson.M{
+		"status":  SkylinkStatusUnreported,
+		"skylink": bson.M{"$ne": ""},
+	}
+	opts := options.Find().SetSort(bson.D{{"_id", 1}})
+	c, err := db.Collection(collSkylinks).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch unreported skylinks")
+	}
+	return c, nil
+}
+
+// UnreportedStats returns the number of infected skylinks which haven't been
+// reported to blocker yet and the time the oldest of them was detected. The
+// time is zero if there are no unreported skylinks.
+func (db *DB) UnreportedStats(ctx context.Context) (int64, time.Time, error) {
+	filter := bson.M{"status": SkylinkStatusUnreported}
+	n, err := db.Collection(collSkylinks).CountDocuments(ctx, filter, options.Count().SetHint(hintStatusScannedAt))
+	if err != nil {
+		return 0, time.Time{}, errors.AddContext(err, "failed to count unreported skylinks")
+	}
+	if n == 0 {
+		return 0, time.Time{}, nil
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{{"scanned_at", 1}}).
+		SetProjection(bson.M{"scanned_at": 1}).
+		SetHint(hintStatusScannedAt)
+	sr := db.Collection(collSkylinks).FindOne(ctx, filter, opts)
+	if sr.Err() == mongo.ErrNoDocuments {
+		// The records were reported in the meantime.
+		return 0, time.Time{}, nil
+	}
+	if sr.Err() != nil {
+		return 0, time.Time{}, errors.AddContext(sr.Err(), "failed to fetch the oldest unreported skylink")
+	}
+	var st scanTimes
+	err = sr.Decode(&st)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return n, st.ScannedAt, nil
+}
+
+// InfectionAnomaly compares the infection rate within the most recent
+// AnomalyWindow against the baseline rate over the preceding AnomalyBaseline.
+func (db *DB) InfectionAnomaly(ctx context.Context) (*Anomaly, error) {
+	now := Clock.Now().UTC()
+	recentScanned, recentInfected, err := db.InfectionCounts(ctx, now.Add(-AnomalyWindow))
+	if err != nil {
+		return nil, err
+	}
+	allScanned, allInfected, err := db.InfectionCounts(ctx, now.Add(-AnomalyWindow-AnomalyBaseline))
+	if err != nil {
+		return nil, err
+	}
+	return detectAnomaly(recentScanned, recentInfected, allScanned-recentScanned, allInfected-recentInfected), nil
+}
+
+// detectAnomaly checks whether the recent infection rate is significantly
+// higher than the baseline rate. It uses a one-sided z-test for proportions,
+// with Laplace smoothing of the baseline rate. The baseline rate is floored at
+// a single infection per recent window, so an all-clean baseline doesn't make
+// every single detection look like an anomaly.
+func detectAnomaly(recentScanned, recentInfected, baseScanned, baseInfected int64) *Anomaly {
+	a := &Anomaly{
+		Window:         AnomalyWindow.Seconds(),
+		Baseline:       AnomalyBaseline.Seconds(),
+		RecentScanned:  recentScanned,
+		RecentInfected: recentInfected,
+	}
+	if recentScanned > 0 {
+		a.RecentRate = float64(recentInfected) / float64(recentScanned)
+	}
+	p := float64(baseInfected+1) / float64(baseScanned+2)
+	a.BaselineRate = p
+	if recentScanned < AnomalyMinScans {
+		return a
+	}
+	p = math.Max(p, 1/float64(recentScanned))
+	if p >= 1 {
+		return a
+	}
+	a.ZScore = (a.RecentRate - p) / math.Sqrt(p*(1-p)/float64(recentScanned))
+	a.Anomalous = a.ZScore > AnomalyThreshold
+	return a
+}
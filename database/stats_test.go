@@ -0,0 +1,139 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// histogram buckets the given latencies like the scan stats aggregation does.
+func histogram(latencies []float64, sla time.Duration) []latencyBucket {
+	var buckets []latencyBucket
+	for _, l := range latencies {
+		var lower float64
+		for _, b := range latencyBoundaries {
+			if f := float64(b.(int)); f <= l {
+				lower = f
+			}
+		}
+		if len(buckets) == 0 || buckets[len(buckets)-1].Lower != lower {
+			buckets = append(buckets, latencyBucket{Lower: lower})
+		}
+		b := &buckets[len(buckets)-1]
+		b.Count++
+		if l > b.Max {
+			b.Max = l
+		}
+		if l <= sla.Seconds() {
+			b.WithinSLA++
+		}
+	}
+	return buckets
+}
+
+// TestComputeScanStats ensures computeScanStats turns the aggregated
+// throughput and latency histogram into the right stats.
+func TestComputeScanStats(t *testing.T) {
+	since := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	var f scanStatsFacets
+	// Ten records scanned in the first hour, with latencies of 1 to 10
+	// minutes, and one legacy record scanned in the second hour without a
+	// submission time, so it has no latency.
+	f.Hourly = []hourlyBucket{
+		{Hour: since, Records: 10, BytesScanned: 1000},
+		{Hour: since.Add(time.Hour), Records: 1, BytesScanned: 1000},
+	}
+	var latencies []float64
+	for i := 1; i <= 10; i++ {
+		latencies = append(latencies, float64(60*i))
+	}
+	f.Latency = histogram(latencies, 5*time.Minute)
+
+	stats := computeScanStats(f, since, 5*time.Minute)
+	if stats.Records != 11 {
+		t.Fatalf("Expected 11 records, got %d", stats.Records)
+	}
+	if stats.BytesScanned != 2000 {
+		t.Fatalf("Expected 2000 bytes, got %d", stats.BytesScanned)
+	}
+	if len(stats.Hourly) != 2 {
+		t.Fatalf("Expected 2 hourly buckets, got %d", len(stats.Hourly))
+	}
+	if !stats.Hourly[0].Hour.Equal(since) || stats.Hourly[0].Records != 10 || stats.Hourly[0].BytesScanned != 1000 {
+		t.Fatalf("Unexpected first bucket %+v", stats.Hourly[0])
+	}
+	if stats.Hourly[1].Records != 1 || stats.Hourly[1].BytesScanned != 1000 {
+		t.Fatalf("Unexpected second bucket %+v", stats.Hourly[1])
+	}
+	// The median, 300s, shares its bucket with 360s and 420s, so it's
+	// overestimated as 420s.
+	if stats.Latency.P50 != 420 || stats.Latency.P90 != 540 || stats.Latency.P99 != 600 || stats.Latency.Max != 600 {
+		t.Fatalf("Unexpected latency percentiles %+v", stats.Latency)
+	}
+	if stats.WithinSLA != 0.5 {
+		t.Fatalf("Expected half the records to be within SLA, got %f", stats.WithinSLA)
+	}
+
+	// Latencies which each have a bucket of their own are exact.
+	f.Latency = histogram([]float64{1, 2, 5, 10}, time.Minute)
+	stats = computeScanStats(f, since, time.Minute)
+	if stats.Latency.P50 != 2 || stats.Latency.P90 != 10 || stats.Latency.Max != 10 || stats.WithinSLA != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	// No records.
+	stats = computeScanStats(scanStatsFacets{}, since, time.Minute)
+	if stats.Records != 0 || stats.WithinSLA != 0 || len(stats.Hourly) != 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+// TestDetectAnomaly ensures detectAnomaly flags significant spikes of the
+// infection rate only.
+func TestDetectAnomaly(t *testing.T) {
+	// Too few recent scans.
+	a := detectAnomaly(AnomalyMinScans-1, AnomalyMinScans-1, 10000, 10)
+	if a.Anomalous || a.ZScore != 0 {
+		t.Fatalf("Expected no anomaly with too few scans, got %+v", a)
+	}
+	// A rate in line with the baseline.
+	a = detectAnomaly(1000, 1, 100000, 100)
+	if a.Anomalous {
+		t.Fatalf("Expected no anomaly, got %+v", a)
+	}
+	// A malware wave.
+	a = detectAnomaly(1000, 50, 100000, 100)
+	if !a.Anomalous || a.RecentRate != 0.05 {
+		t.Fatalf("Expected an anomaly, got %+v", a)
+	}
+	// A single detection after an all-clean baseline is not an anomaly.
+	a = detectAnomaly(100, 1, 100000, 0)
+	if a.Anomalous {
+		t.Fatalf("Expected no anomaly, got %+v", a)
+	}
+	// A drop in the infection rate is not an anomaly.
+	a = detectAnomaly(1000, 0, 100000, 10000)
+	if a.Anomalous {
+		t.Fatalf("Expected no anomaly, got %+v", a)
+	}
+}
+
+// TestStatsHints ensures the indexes the stats queries hint exist, since the
+// queries fail otherwise.
+func TestStatsHints(t *testing.T) {
+	hints := []bson.D{hintScanStats, hintScannedAt, hintInfectedScannedAt, hintStatusSubmittedAt, hintStatusScannedAt}
+	for _, hint := range hints {
+		found := false
+		for _, model := range dbSchema()[collSkylinks] {
+			if reflect.DeepEqual(model.Keys, hint) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("No index for hint %v", hint)
+		}
+	}
+}
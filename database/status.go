@@ -0,0 +1,72 @@
+package database
+
+import (
+	"sort"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// ErrInvalidTransition is returned when an update would move a record to
+	// a status it can't have after its current one.
+	ErrInvalidTransition = errors.New("invalid status transition")
+	// ErrStatusChanged is returned when an update expects a record to have
+	// a status it no longer has, e.g. because its scan was cancelled as
+	// stuck or an admin re-queued it meanwhile.
+	ErrStatusChanged = errors.New("the record's status changed")
+
+	// statusTransitions holds the statuses a record can move to from each
+	// status. The empty status stands for records which don't exist yet.
+	//
+	// Records are created new. New records are locked for scanning, get a
+	// verdict without a scan, e.g. from a blocklist, or are cleared as false
+	// positives. Scans either fail, which returns the record to the queue,
+	// or reach a verdict: infected records are unreported until every
+	// blocker target has blocked them, clean ones are complete. Records
+	// whose content the portal confirmed enough times not to exist are gone
+	// instead of returning to the queue. Infected records which the policy
+	// holds back are pending review until a human approves them, which
+	// makes them unreported, or rejects them, which completes them. Records
+	// of any status can be queued again, e.g. for re-scans, and infected
+	// ones can be cleared as false positives.
+	statusTransitions = map[string][]string{
+		"":                         {SkylinkStatusNew},
+		SkylinkStatusNew:           {SkylinkStatusNew, SkylinkStatusScanning, SkylinkStatusUnreported, SkylinkStatusPendingReview, SkylinkStatusComplete},
+		SkylinkStatusScanning:      {SkylinkStatusNew, SkylinkStatusUnreported, SkylinkStatusPendingReview, SkylinkStatusComplete, SkylinkStatusGone},
+		SkylinkStatusUnreported:    {SkylinkStatusNew, SkylinkStatusComplete},
+		SkylinkStatusPendingReview: {SkylinkStatusNew, SkylinkStatusUnreported, SkylinkStatusComplete},
+		SkylinkStatusComplete:      {SkylinkStatusNew, SkylinkStatusComplete},
+		SkylinkStatusGone:          {SkylinkStatusNew},
+	}
+)
+
+// ValidTransition returns whether a record with the given status can move to
+// the other given status.
+func ValidTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// statusesBefore returns the statuses of existing records which can move to
+// the given status, sorted.
+func statusesBefore(to string) []string {
+	var from []string
+	for s := range statusTransitions {
+		if s != "" && ValidTransition(s, to) {
+			from = append(from, s)
+		}
+	}
+	sort.Strings(from)
+	return from
+}
+
+// transitionFilter returns the filter on the status of the records which can
+// move to the given status.
+func transitionFilter(to string) bson.M {
+	return bson.M{"$in": statusesBefore(to)}
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// statuses are the statuses of existing records.
+var statuses = []string{SkylinkStatusNew, SkylinkStatusScanning, SkylinkStatusUnreported, SkylinkStatusPendingReview, SkylinkStatusComplete, SkylinkStatusGone}
+
+// TestValidTransition ensures the state machine only allows the transitions of
+// the record lifecycle.
+func TestValidTransition(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{"", SkylinkStatusNew}:                                true,
+		{SkylinkStatusNew, SkylinkStatusNew}:                  true,
+		{SkylinkStatusNew, SkylinkStatusScanning}:             true,
+		{SkylinkStatusNew, SkylinkStatusUnreported}:           true,
+		{SkylinkStatusNew, SkylinkStatusPendingReview}:        true,
+		{SkylinkStatusNew, SkylinkStatusComplete}:             true,
+		{SkylinkStatusScanning, SkylinkStatusNew}:             true,
+		{SkylinkStatusScanning, SkylinkStatusUnreported}:      true,
+		{SkylinkStatusScanning, SkylinkStatusPendingReview}:   true,
+		{SkylinkStatusScanning, SkylinkStatusComplete}:        true,
+		{SkylinkStatusScanning, SkylinkStatusGone}:            true,
+		{SkylinkStatusUnreported, SkylinkStatusNew}:           true,
+		{SkylinkStatusUnreported, SkylinkStatusComplete}:      true,
+		{SkylinkStatusPendingReview, SkylinkStatusNew}:        true,
+		{SkylinkStatusPendingReview, SkylinkStatusUnreported}: true,
+		{SkylinkStatusPendingReview, SkylinkStatusComplete}:   true,
+		{SkylinkStatusComplete, SkylinkStatusNew}:             true,
+		{SkylinkStatusComplete, SkylinkStatusComplete}:        true,
+		{SkylinkStatusGone, SkylinkStatusNew}:                 true,
+	}
+	all := append([]string{"", "gremlins"}, statuses...)
+	f := func(i, j uint8) bool {
+		from, to := all[int(i)%len(all)], all[int(j)%len(all)]
+		return ValidTransition(from, to) == allowed[[2]string{from, to}]
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every status can be reached from every other one through a series of
+	// valid transitions, so no record gets stuck, and none can be left
+	// without a status.
+	for _, from := range statuses {
+		reached := map[string]bool{from: true}
+		queue := []string{from}
+		for len(queue) > 0 {
+			s := queue[0]
+			queue = queue[1:]
+			for _, to := range statusTransitions[s] {
+				if to == "" {
+					t.Fatalf("Expected no transition from %s to the empty status", s)
+				}
+				if !reached[to] {
+					reached[to] = true
+					queue = append(queue, to)
+				}
+			}
+		}
+		if len(reached) != len(statuses) {
+			t.Fatalf("Expected every status to be reachable from %s, got %v", from, reached)
+		}
+	}
+}
+
+// TestStatusesBefore ensures statusesBefore returns exactly the statuses which
+// can move to the given one.
+func TestStatusesBefore(t *testing.T) {
+	if before := statusesBefore(SkylinkStatusScanning); !reflect.DeepEqual(before, []string{SkylinkStatusNew}) {
+		t.Fatalf("Expected only new records to be locked, got %v", before)
+	}
+	f := func(seed int64) bool {
+		to := statuses[rand.New(rand.NewSource(seed)).Intn(len(statuses))]
+		before := statusesBefore(to)
+		for _, from := range statuses {
+			found := false
+			for _, s := range before {
+				found = found || s == from
+			}
+			if found != ValidTransition(from, to) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// collVerdictCache defines the name of the collection which caches the
+	// verdicts of our scans by content hash and signature version.
+	collVerdictCache = "verdict_cache"
+)
+
+var (
+	// VerdictCacheTTL is how long we remember the verdict we reached for
+	// some content with some signature version, so the same content
+	// submitted again after its record was removed isn't downloaded and
+	// scanned again. Verdicts are only reused while ClamAV runs with the
+	// same or older signatures, so keeping them for much longer than the
+	// signature update interval doesn't help. Zero disables the cache.
+	// Set according to the MALWARE_SCANNER_VERDICT_CACHE_TTL env var.
+	VerdictCacheTTL = 24 * time.Hour
+	// VerdictCacheSize is the maximum number of verdicts we keep in memory.
+	// Set according to the MALWARE_SCANNER_VERDICT_CACHE_SIZE env var.
+	VerdictCacheSize = 10000
+)
+
+type (
+	// CachedVerdict is the verdict of a scan of some content with some
+	// version of ClamAV's signatures, along with what we learnt about the
+	// content while scanning it. The InfectionDescription and the Filename
+	// are stored encrypted, like they are in skylink records.
+	CachedVerdict struct {
+		Hash                 crypto.Hash `bson:"hash"`
+		SignatureVersion     int         `bson:"signature_version"`
+		Infected             bool        `bson:"infected"`
+		InfectionDescription string      `bson:"infection_description,omitempty"`
+		Size                 uint64      `bson:"size"`
+		ScannedSize          uint64      `bson:"scanned_size"`
+		Filename             string      `bson:"filename,omitempty"`
+		ContentType          string      `bson:"content_type,omitempty"`
+		Directory            bool        `bson:"directory,omitempty"`
+		ExpiresAt            time.Time   `bson:"expires_at"`
+	}
+
+	// verdictMemCache is the in-memory cache of verdicts in front of the DB.
+	// It holds the verdict with the newest signature version of each hash.
+	verdictMemCache struct {
+		entries map[crypto.Hash]CachedVerdict
+		mu      sync.Mutex
+	}
+)
+
+// newVerdictMemCache returns an empty in-memory cache of verdicts.
+func newVerdictMemCache() *verdictMemCache {
+	return &verdictMemCache{
+		entries: make(map[crypto.Hash]CachedVerdict),
+	}
+}
+
+// CachedVerdict returns the verdict we reached for the content with the given
+// hash with the given signature version or a newer one, if it's cached and
+// fresh. It returns ErrNoDocumentsFound otherwise.
+func (db *DB) CachedVerdict(ctx context.Context, hash crypto.Hash, version int) (*CachedVerdict, error) {
+	if VerdictCacheTTL <= 0 || version <= 0 {
+		return nil, ErrNoDocumentsFound
+	}
+	now := Clock.Now()
+	if v, ok := db.staticVerdictCache.get(hash); ok && v.SignatureVersion >= version && now.Before(v.ExpiresAt) {
+		return &v, nil
+	}
+	filter := bson.M{
+		"hash":              hash,
+		"signature_version": bson.M{"$gte": version},
+		"expires_at":        bson.M{"$gt": now.UTC()},
+	}
+	opts := options.FindOne().SetSort(bson.D{{"signature_version", -1}})
+	var v CachedVerdict
+	err := db.Collection(collVerdictCache).FindOne(ctx, filter, opts).Decode(&v)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoDocumentsFound
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch cached verdict")
+	}
+	var errs [2]error
+	v.InfectionDescription, errs[0] = decryptField(v.InfectionDescription)
+	v.Filename, errs[1] = decryptField(v.Filename)
+	if err = errors.Compose(errs[:]...); err != nil {
+		return nil, errors.AddContext(err, "failed to decrypt cached verdict")
+	}
+	db.staticVerdictCache.add(v)
+	return &v, nil
+}
+
+// CacheVerdict caches the verdict of the given scanned record, which it
+// reached with its SignatureVersion. Verdicts of unknown signature versions
+// aren't cached.
+func (db *DB) CacheVerdict(ctx context.Context, sl *Skylink) error {
+	if VerdictCacheTTL <= 0 || sl.SignatureVersion <= 0 {
+		return nil
+	}
+	v := CachedVerdict{
+		Hash:                 sl.Hash,
+		SignatureVersion:     sl.SignatureVersion,
+		Infected:             sl.Infected,
+		InfectionDescription: sl.InfectionDescription,
+		Size:                 sl.Size,
+		ScannedSize:          sl.ScannedSize,
+		Filename:             sl.Filename,
+		ContentType:          sl.ContentType,
+		Directory:            sl.Directory,
+		ExpiresAt:            Clock.Now().Add(VerdictCacheTTL).UTC(),
+	}
+	db.staticVerdictCache.add(v)
+	stored := v
+	stored.InfectionDescription = encryptField(capDescription(v.InfectionDescription))
+	stored.Filename = encryptField(v.Filename)
+	filter := bson.M{"hash": v.Hash, "signature_version": v.SignatureVersion}
+	opts := options.Replace().SetUpsert(true)
+	_, err := db.Collection(collVerdictCache).ReplaceOne(ctx, filter, stored, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to cache verdict")
+	}
+	return nil
+}
+
+// DropCachedVerdicts removes the cached verdicts of the content with the given
+// hash, so it's scanned again when it's submitted again.
+func (db *DB) DropCachedVerdicts(ctx context.Context, hash crypto.Hash) error {
+	db.staticVerdictCache.remove(hash)
+	_, err := db.Collection(collVerdictCache).DeleteMany(ctx, bson.M{"hash": hash})
+	if err != nil {
+		return errors.AddContext(err, "failed to drop cached verdicts")
+	}
+	return nil
+}
+
+// get returns the cached verdict of the given hash, regardless of its
+// signature version and freshness.
+func (vc *verdictMemCache) get(hash crypto.Hash) (CachedVerdict, bool) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	v, ok := vc.entries[hash]
+	return v, ok
+}
+
+// add adds the verdict to the in-memory cache, unless it already holds one
+// with a newer signature version. When the cache is full, we drop the expired
+// verdicts or, if there are none, an arbitrary one.
+func (vc *verdictMemCache) add(v CachedVerdict) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	existing, exists := vc.entries[v.Hash]
+	if exists && existing.SignatureVersion > v.SignatureVersion {
+		return
+	}
+	if !exists && len(vc.entries) >= VerdictCacheSize {
+		now := Clock.Now()
+		for k, e := range vc.entries {
+			if now.After(e.ExpiresAt) {
+				delete(vc.entries, k)
+			}
+		}
+		for k := range vc.entries {
+			if len(vc.entries) < VerdictCacheSize {
+				break
+			}
+			delete(vc.entries, k)
+		}
+	}
+	if VerdictCacheSize > 0 {
+		vc.entries[v.Hash] = v
+	}
+}
+
+// remove drops the cached verdict of the given hash from memory.
+func (vc *verdictMemCache) remove(hash crypto.Hash) {
+	vc.mu.Lock()
+	delete(vc.entries, hash)
+	vc.mu.Unlock()
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+)
+
+// TestVerdictMemCache ensures the in-memory cache keeps the verdict with the
+// newest signature version of each hash and doesn't grow beyond its maximum
+// size, dropping expired verdicts first.
+func TestVerdictMemCache(t *testing.T) {
+	defer func(n int) { VerdictCacheSize = n }(VerdictCacheSize)
+	VerdictCacheSize = 2
+	vc := newVerdictMemCache()
+	fresh := time.Now().Add(time.Hour)
+	a, b, c, d := crypto.HashObject("a"), crypto.HashObject("b"), crypto.HashObject("c"), crypto.HashObject("d")
+
+	vc.add(CachedVerdict{Hash: a, SignatureVersion: 2, Infected: true, ExpiresAt: fresh})
+	vc.add(CachedVerdict{Hash: a, SignatureVersion: 1, ExpiresAt: fresh})
+	if v, ok := vc.get(a); !ok || v.SignatureVersion != 2 || !v.Infected {
+		t.Fatalf("Expected to keep the newer verdict, got %+v", v)
+	}
+
+	vc.add(CachedVerdict{Hash: b, SignatureVersion: 1, ExpiresAt: time.Now().Add(-time.Hour)})
+	vc.add(CachedVerdict{Hash: c, SignatureVersion: 1, ExpiresAt: fresh})
+	if _, ok := vc.entries[b]; ok || len(vc.entries) != 2 {
+		t.Fatalf("Expected the expired verdict to be dropped, got %v", vc.entries)
+	}
+	vc.add(CachedVerdict{Hash: d, SignatureVersion: 1, ExpiresAt: fresh})
+	if _, ok := vc.entries[d]; !ok || len(vc.entries) != 2 {
+		t.Fatalf("Unexpected verdicts %v", vc.entries)
+	}
+
+	vc.remove(d)
+	if _, ok := vc.get(d); ok {
+		t.Fatal("Expected the verdict to be removed")
+	}
+}
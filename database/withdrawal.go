@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// collSignatureWithdrawals defines the name of the collection which holds
+	// the ClamAV signatures we no longer act on.
+	collSignatureWithdrawals = "signature_withdrawals"
+)
+
+// SignatureWithdrawal records that a ClamAV signature was withdrawn, e.g.
+// because upstream confirmed it as a false positive. Detections by withdrawn
+// signatures don't make skylinks infected and the skylinks blocked under them
+// are scanned again. Blocker has no unblock endpoint, so the ones which turn
+// out to be clean stay blocked until they're unblocked there by hand. Queued
+// counts the records we queued for that and Skipped those we couldn't queue
+// because we no longer know their skylink. Pending counts the queued records
+// which are yet to be scanned. It isn't stored but counted when the
+// withdrawals are fetched.
+type SignatureWithdrawal struct {
+	Signature   string    `bson:"_id" json:"signature"`
+	Reason      string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	Caller      string    `bson:"caller,omitempty" json:"caller,omitempty"`
+	WithdrawnAt time.Time `bson:"withdrawn_at" json:"withdrawnAt"`
+	Queued      int64     `bson:"queued" json:"queued"`
+	Skipped     int64     `bson:"skipped" json:"skipped"`
+	Pending     int64     `bson:"-" json:"pending"`
+}
+
+// WithdrawSignature records the withdrawal of the given signature and queues
+// the infected records detected by it for scanning again, at PriorityHigh, so
+// their verdicts are corrected soon. Records which were reported already no
+// longer have their skylink, so we take it from the report log. Withdrawing a
+// signature again queues the records detected by it since.
+func (db *DB) WithdrawSignature(ctx context.Context, w SignatureWithdrawal) (*SignatureWithdrawal, error) {
+	now := Clock.Now().UTC()
+	set := bson.M{"withdrawn_at": now}
+	if w.Reason != "" {
+		set["reason"] = w.Reason
+	}
+	if w.Caller != "" {
+		set["caller"] = w.Caller
+	}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.Collection(collSignatureWithdrawals).UpdateOne(ctx, bson.M{"_id": w.Signature}, bson.M{"$set": set}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to withdraw signature")
+	}
+	queued, skipped, err := db.rollbackSignature(ctx, w.Signature, now)
+	// Record the progress even if we failed half way.
+	update := bson.M{"$inc": bson.M{"queued": queued, "skipped": skipped}}
+	var sw SignatureWithdrawal
+	errUpdate := db.Collection(collSignatureWithdrawals).FindOneAndUpdate(ctx, bson.M{"_id": w.Signature}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&sw)
+	if errUpdate != nil {
+		errUpdate = errors.AddContext(errUpdate, "failed to record the rolled back records")
+	}
+	if err = errors.Compose(err, errUpdate); err != nil {
+		return nil, err
+	}
+	return &sw, nil
+}
+
+// rollbackSignature queues the infected records detected by the given
+// signature for scanning again. It returns the number of queued records and
+// of those it skipped because their skylink is unknown.
+func (db *DB) rollbackSignature(ctx context.Context, sig string, now time.Time) (int64, int64, error) {
+	filter := bson.M{
+		"infected":              true,
+		"false_positive":        bson.M{"$ne": true},
+		"status":                bson.M{"$in": []string{SkylinkStatusUnreported, SkylinkStatusPendingReview, SkylinkStatusComplete}},
+		"infection_description": bson.M{"$in": descriptionValues(sig)},
+	}
+	c, err := db.Collection(collSkylinks).Find(ctx, filter)
+	if err != nil {
+		return 0, 0, errors.AddContext(err, "failed to find the records of the signature")
+	}
+	defer func() { _ = c.Close(ctx) }()
+	var queued, skipped int64
+	for c.Next(ctx) {
+		var sl Skylink
+		if err = c.Decode(&sl); err != nil {
+			return queued, skipped, errors.AddContext(err, "failed to decode record")
+		}
+		skylink := sl.Skylink
+		if skylink == "" {
+			skylink, err = db.reportedSkylink(ctx, sl)
+			if err != nil {
+				return queued, skipped, err
+			}
+		}
+		if skylink == "" {
+			// The skylink was reported in privacy mode.
+			skipped++
+			continue
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"skylink":      encryptField(skylink),
+				"status":       SkylinkStatusNew,
+				"timestamp":    now,
+				"submitted_at": now,
+				"priority":     PriorityHigh,
+				"rollback":     sig,
+				"source":       SourceRollback,
+			},
+			// Make sure the skylink is scanned again rather than given the
+			// verdict it already has.
+			"$unset": bson.M{"signature_version": "", "submitter": ""},
+		}
+		ur, err := db.Collection(collSkylinks).UpdateOne(ctx, bson.M{"_id": sl.ID, "status": sl.Status}, update)
+		if err != nil {
+			return queued, skipped, errors.AddContext(err, "failed to queue record for rollback")
+		}
+		queued += ur.ModifiedCount
+	}
+	if c.Err() != nil {
+		return queued, skipped, errors.AddContext(c.Err(), "failed to fetch the records of the signature")
+	}
+	return queued, skipped, nil
+}
+
+// reportedSkylink returns the skylink the given record was last reported to
+// blocker with, according to the report log. It's empty if the report log
+// doesn't have it, e.g. because it was reported in privacy mode.
+func (db *DB) reportedSkylink(ctx context.Context, sl Skylink) (string, error) {
+	entries, err := db.skylinkReportLog(ctx, sl.Hash)
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Action == ReportActionBlock && entries[i].Skylink != "" {
+			return entries[i].Skylink, nil
+		}
+	}
+	return "", nil
+}
+
+// SignatureWithdrawn returns whether the given signature was withdrawn.
+func (db *DB) SignatureWithdrawn(ctx context.Context, sig string) (bool, error) {
+	n, err := db.Collection(collSignatureWithdrawals).CountDocuments(ctx, bson.M{"_id": sig}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, errors.AddContext(err, "failed to look up signature withdrawal")
+	}
+	return n > 0, nil
+}
+
+// SignatureWithdrawals returns all signature withdrawals, most recent first,
+// with the number of their records which are yet to be rolled back.
+func (db *DB) SignatureWithdrawals(ctx context.Context) ([]SignatureWithdrawal, error) {
+	opts := options.Find().SetSort(bson.D{{"withdrawn_at", -1}})
+	c, err := db.Collection(collSignatureWithdrawals).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch signature withdrawals")
+	}
+	ws := []SignatureWithdrawal{}
+	if err = c.All(ctx, &ws); err != nil {
+		return nil, errors.AddContext(err, "failed to decode signature withdrawals")
+	}
+	for i := range ws {
+		ws[i].Pending, err = db.Collection(collSkylinks).CountDocuments(ctx, bson.M{"rollback": ws[i].Signature})
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to count pending rollbacks")
+		}
+	}
+	return ws, nil
+}
+
+// RollbackSkylinks returns a cursor over the records queued for a rollback
+// which have a verdict again.
+func (db *DB) RollbackSkylinks(ctx context.Context) (*mongo.Cursor, error) {
+	filter := bson.M{
+		"rollback": bson.M{"$exists": true},
+		"status":   SkylinkStatusComplete,
+	}
+	c, err := db.Collection(collSkylinks).Find(ctx, filter, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch rollback skylinks")
+	}
+	return c, nil
+}
+
+// SkylinkRollbackDone finishes the rollback of the given record. Its reports
+// are kept, since blocker still blocks it.
+func (db *DB) SkylinkRollbackDone(ctx context.Context, sl *Skylink) error {
+	filter := bson.M{
+		"_id":      sl.ID,
+		"rollback": bson.M{"$exists": true},
+		"status":   SkylinkStatusComplete,
+	}
+	_, err := db.Collection(collSkylinks).UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"rollback": ""}})
+	if err != nil {
+		return errors.AddContext(err, "failed to finish rollback")
+	}
+	return nil
+}
+
+// descriptionValues returns the values the infection description of records
+// detected by the given signature can be stored as: encrypted, or in plain
+// text if it was stored before encryption was enabled.
+func descriptionValues(sig string) []string {
+	plain := capDescription(sig)
+	return []string{plain, encryptField(plain)}
+}
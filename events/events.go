@@ -0,0 +1,203 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// TypeSubmitted is emitted when a skylink is added to the queue.
+	TypeSubmitted = "submitted"
+	// TypeLocked is emitted when a skylink is locked for scanning.
+	TypeLocked = "locked"
+	// TypeScanned is emitted when a skylink is scanned and found clean.
+	TypeScanned = "scanned"
+	// TypeInfected is emitted when a skylink is scanned and found infected.
+	TypeInfected = "infected"
+	// TypeReported is emitted when an infected skylink is reported to blocker.
+	TypeReported = "reported"
+	// TypeFailed is emitted when scanning or reporting a skylink fails.
+	TypeFailed = "failed"
+	// TypeGone is emitted when we give up on scanning a skylink because its
+	// content doesn't exist anymore.
+	TypeGone = "gone"
+
+	// bufferSize is the number of events we buffer before we start dropping
+	// them. This prevents a slow sink from slowing down the scanner.
+	bufferSize = 1024
+)
+
+type (
+	// Event describes a single lifecycle transition of a skylink record.
+	Event struct {
+		Type        string    `json:"type"`
+		Hash        string    `json:"hash"`
+		Skylink     string    `json:"skylink,omitempty"`
+		Status      string    `json:"status,omitempty"`
+		Infected    bool      `json:"infected,omitempty"`
+		Description string    `json:"description,omitempty"`
+		Size        uint64    `json:"size,omitempty"`
+		ScannedSize uint64    `json:"scannedSize,omitempty"`
+		Filename    string    `json:"filename,omitempty"`
+		ContentType string    `json:"contentType,omitempty"`
+		Source      string    `json:"source,omitempty"`
+		Error       string    `json:"error,omitempty"`
+		Timestamp   time.Time `json:"timestamp"`
+	}
+
+	// Sink is a destination for events.
+	Sink interface {
+		Write(e Event) error
+	}
+
+	// WriterSink writes events as newline-delimited JSON to a writer.
+	WriterSink struct {
+		staticEnc *json.Encoder
+		mu        sync.Mutex
+	}
+
+	// MultiSink writes each event to all of its sinks.
+	MultiSink []Sink
+
+	// HTTPSink sends each event as a JSON POST request to a URL.
+	HTTPSink struct {
+		staticURL string
+	}
+
+	// Emitter delivers events to a sink in the background. An Emitter without
+	// a sink discards all events.
+	Emitter struct {
+		staticEvents chan Event
+		staticLogger *logrus.Logger
+		staticSink   Sink
+	}
+)
+
+// NewEmitter returns a new Emitter which delivers events to the given sink
+// until the context is cancelled. The sink can be nil, in which case all
+// events are discarded.
+func NewEmitter(ctx context.Context, sink Sink, logger *logrus.Logger) (*Emitter, error) {
+	if ctx == nil {
+		return nil, errors.New("invalid context provided")
+	}
+	if logger == nil {
+		return nil, errors.New("invalid logger provided")
+	}
+	e := &Emitter{
+		staticEvents: make(chan Event, bufferSize),
+		staticLogger: logger,
+		staticSink:   sink,
+	}
+	if sink != nil {
+		go e.threadedDeliver(ctx)
+	}
+	return e, nil
+}
+
+// NewSink creates a sink from its description. Supported values are "stdout",
+// "file:<path>" and any http(s) URL.
+func NewSink(desc string) (Sink, error) {
+	switch {
+	case desc == "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(desc, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(desc, "file:"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to open events file")
+		}
+		return NewWriterSink(f), nil
+	case strings.HasPrefix(desc, "http://") || strings.HasPrefix(desc, "https://"):
+		return &HTTPSink{staticURL: desc}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported events sink '%s'", desc))
+}
+
+// NewWriterSink returns a sink that writes newline-delimited JSON to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{staticEnc: json.NewEncoder(w)}
+}
+
+// Emit queues an event for delivery. If the queue is full, the event is
+// dropped.
+func (e *Emitter) Emit(ev Event) {
+	if e.staticSink == nil {
+		return
+	}
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now().UTC()
+	}
+	// Events identify records by their hash, the skylink and the filename
+	// are a convenience we leave out in privacy mode.
+	if logging.PrivacyMode {
+		ev.Skylink = ""
+		ev.Filename = ""
+	}
+	select {
+	case e.staticEvents <- ev:
+	default:
+		e.staticLogger.Warnf("Events buffer is full, dropping '%s' event for hash %s", ev.Type, ev.Hash)
+	}
+}
+
+// threadedDeliver delivers queued events to the sink until the context is
+// cancelled.
+func (e *Emitter) threadedDeliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-e.staticEvents:
+			err := e.staticSink.Write(ev)
+			if err != nil {
+				e.staticLogger.Warnf("Failed to deliver '%s' event for hash %s: %s", ev.Type, ev.Hash, err)
+			}
+		}
+	}
+}
+
+// Write implements Sink.
+func (ws *WriterSink) Write(e Event) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.staticEnc.Encode(e)
+}
+
+// Write implements Sink.
+func (ms MultiSink) Write(e Event) error {
+	var errs []error
+	for _, s := range ms {
+		errs = append(errs, s.Write(e))
+	}
+	return errors.Compose(errs...)
+}
+
+// Write implements Sink.
+func (hs *HTTPSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.AddContext(err, "failed to build request body")
+	}
+	res, err := http.Post(hs.staticURL, "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		return errors.AddContext(err, "failed to send event")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		rb, _ := ioutil.ReadAll(res.Body)
+		return errors.New(fmt.Sprintf("events sink failed. status code %d, body: '%s'", res.StatusCode, string(rb)))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+// Write implements io.Writer.
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+// String returns the buffer's content.
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+// TestEmitter ensures the Emitter delivers events to its sink as
+// newline-delimited JSON.
+func TestEmitter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf syncBuffer
+	e, err := NewEmitter(ctx, NewWriterSink(&buf), logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Emit(Event{Type: TypeSubmitted, Hash: "aa"})
+	e.Emit(Event{Type: TypeInfected, Hash: "aa", Infected: true, Description: "Eicar-Signature"})
+
+	var lines [][]byte
+	for i := 0; i < 100; i++ {
+		lines = bytes.Split(bytes.TrimSpace([]byte(buf.String())), []byte("\n"))
+		if len(lines) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 events, got '%s'", buf.String())
+	}
+	var ev Event
+	err = json.Unmarshal(lines[1], &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != TypeInfected || !ev.Infected || ev.Description != "Eicar-Signature" || ev.Timestamp.IsZero() {
+		t.Fatalf("Unexpected event %+v", ev)
+	}
+
+	// An emitter without a sink discards events.
+	e, err = NewEmitter(ctx, nil, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Emit(Event{Type: TypeSubmitted})
+	if len(e.staticEvents) != 0 {
+		t.Fatal("Expected the event to be discarded")
+	}
+}
+
+// TestNewSink ensures NewSink recognises all supported sink descriptions.
+func TestNewSink(t *testing.T) {
+	s, err := NewSink("stdout")
+	if _, ok := s.(*WriterSink); err != nil || !ok {
+		t.Fatalf("Expected a WriterSink, got %T, %v", s, err)
+	}
+	s, err = NewSink("https://siem.test/ingest")
+	if _, ok := s.(*HTTPSink); err != nil || !ok {
+		t.Fatalf("Expected an HTTPSink, got %T, %v", s, err)
+	}
+	s, err = NewSink("file:" + t.TempDir() + "/events.log")
+	if _, ok := s.(*WriterSink); err != nil || !ok {
+		t.Fatalf("Expected a WriterSink, got %T, %v", s, err)
+	}
+	_, err = NewSink("kafka://nope")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+// failingSink is a sink which always fails.
+type failingSink struct{}
+
+// Write implements Sink.
+func (failingSink) Write(Event) error {
+	return errors.New("failed")
+}
+
+// TestMultiSink ensures MultiSink writes each event to all sinks, even if one
+// of them fails.
+func TestMultiSink(t *testing.T) {
+	var buf1, buf2 syncBuffer
+	ms := MultiSink{NewWriterSink(&buf1), failingSink{}, NewWriterSink(&buf2)}
+	err := ms.Write(Event{Type: TypeSubmitted, Hash: "aa"})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if buf1.String() == "" || buf1.String() != buf2.String() {
+		t.Fatalf("Expected the event in both sinks, got '%s' and '%s'", buf1.String(), buf2.String())
+	}
+}
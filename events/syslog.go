@@ -0,0 +1,251 @@
+package events
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// FormatCEF is ArcSight's Common Event Format, e.g. for Splunk.
+	FormatCEF = "cef"
+	// FormatLEEF is IBM's Log Event Extended Format, e.g. for QRadar.
+	FormatLEEF = "leef"
+
+	// siemVendor, siemProduct and siemVersion identify us in CEF and LEEF
+	// headers.
+	siemVendor  = "SkynetLabs"
+	siemProduct = "malware-scanner"
+	siemVersion = "1.0"
+
+	// syslogFacilityLocal0 is the syslog facility of our messages.
+	syslogFacilityLocal0 = 16
+	// syslogSeverityWarning and syslogSeverityNotice are the syslog
+	// severities of detections and of blocks.
+	syslogSeverityWarning = 4
+	syslogSeverityNotice  = 5
+
+	// syslogDialTimeout is how long we wait to connect to the syslog server.
+	syslogDialTimeout = 10 * time.Second
+	// syslogWriteTimeout is how long we wait to send a message.
+	syslogWriteTimeout = 10 * time.Second
+)
+
+var (
+	// cefHeaderEscaper escapes the values of CEF header fields.
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	// cefExtEscaper escapes the values of CEF extension fields.
+	cefExtEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	// leefEscaper escapes the values of LEEF attributes, which are tab
+	// separated.
+	leefEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+type (
+	// SyslogSink sends infection events to a syslog server, formatted as CEF
+	// or LEEF, so SIEMs can ingest them. Other events are ignored. Messages
+	// use the RFC 5424 format. Over TCP and TLS, they are newline delimited.
+	SyslogSink struct {
+		staticNetwork   string
+		staticAddr      string
+		staticFormat    string
+		staticHostname  string
+		staticTLSConfig *tls.Config
+
+		conn net.Conn
+		mu   sync.Mutex
+	}
+)
+
+// NewSyslogSink returns a sink to the syslog server at the given address, e.g.
+// "udp://siem.example.com:514", "tcp://siem.example.com:514" or
+// "tls://siem.example.com:6514", using the given format. TLS connections
+// verify the server against the system's root CAs or, if caFile is set, the
+// CAs in the given PEM file.
+func NewSyslogSink(addr, format, caFile string) (*SyslogSink, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("invalid syslog address: " + addr)
+	}
+	if u.Port() == "" {
+		return nil, errors.New("missing port in syslog address: " + addr)
+	}
+	if format != FormatCEF && format != FormatLEEF {
+		return nil, errors.New(fmt.Sprintf("unsupported SIEM format '%s'", format))
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	s := &SyslogSink{
+		staticAddr:     u.Host,
+		staticFormat:   format,
+		staticHostname: hostname,
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.staticNetwork = u.Scheme
+	case "tls":
+		s.staticNetwork = "tcp"
+		s.staticTLSConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if caFile != "" {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, errors.AddContext(err, "failed to read syslog CA file")
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("no certificates found in syslog CA file")
+			}
+			s.staticTLSConfig.RootCAs = pool
+		}
+	default:
+		return nil, errors.New("unsupported syslog protocol: " + u.Scheme)
+	}
+	return s, nil
+}
+
+// Write implements Sink. If sending fails, we reconnect and retry once.
+func (s *SyslogSink) Write(e Event) error {
+	if e.Type != TypeInfected && e.Type != TypeReported {
+		return nil
+	}
+	msg := s.message(e)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.send(msg)
+	if err != nil {
+		s.closeConn()
+		err = errors.Compose(err, s.send(msg))
+	}
+	if err != nil {
+		s.closeConn()
+		return errors.AddContext(err, "failed to send syslog message")
+	}
+	return nil
+}
+
+// send sends the given message, connecting first if needed.
+func (s *SyslogSink) send(msg string) error {
+	if s.conn == nil {
+		d := &net.Dialer{Timeout: syslogDialTimeout}
+		var conn net.Conn
+		var err error
+		if s.staticTLSConfig != nil {
+			conn, err = tls.DialWithDialer(d, s.staticNetwork, s.staticAddr, s.staticTLSConfig)
+		} else {
+			conn, err = d.Dial(s.staticNetwork, s.staticAddr)
+		}
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if s.staticNetwork == "tcp" {
+		msg += "\n"
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+// closeConn closes the connection to the syslog server, if any.
+func (s *SyslogSink) closeConn() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// message returns the RFC 5424 syslog message of the given event.
+func (s *SyslogSink) message(e Event) string {
+	severity := syslogSeverityWarning
+	if e.Type == TypeReported {
+		severity = syslogSeverityNotice
+	}
+	payload := formatCEF(e)
+	if s.staticFormat == FormatLEEF {
+		payload = formatLEEF(e)
+	}
+	pri := syslogFacilityLocal0*8 + severity
+	ts := e.Timestamp.UTC().Format(time.RFC3339Nano)
+	return fmt.Sprintf("<%d>1 %s %s %s - %s - %s", pri, ts, s.staticHostname, siemProduct, e.Type, payload)
+}
+
+// eventName returns a human readable name of the given event's type.
+func eventName(e Event) string {
+	if e.Type == TypeReported {
+		return "Infected content blocked"
+	}
+	return "Infected content detected"
+}
+
+// formatCEF formats the given event as a CEF record.
+func formatCEF(e Event) string {
+	severity := "8"
+	act := "detected"
+	if e.Type == TypeReported {
+		severity = "5"
+		act = "blocked"
+	}
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.Timestamp.UnixNano()/int64(time.Millisecond), 10),
+		"act=" + act,
+		"fileHash=" + cefExtEscaper.Replace(e.Hash),
+	}
+	if e.Skylink != "" {
+		ext = append(ext, "fname="+cefExtEscaper.Replace(e.Skylink))
+	}
+	if e.Size > 0 {
+		ext = append(ext, "fsize="+strconv.FormatUint(e.Size, 10))
+	}
+	if e.Description != "" {
+		ext = append(ext, "cs1Label=signature", "cs1="+cefExtEscaper.Replace(e.Description))
+	}
+	return strings.Join([]string{
+		"CEF:0",
+		cefHeaderEscaper.Replace(siemVendor),
+		cefHeaderEscaper.Replace(siemProduct),
+		cefHeaderEscaper.Replace(siemVersion),
+		cefHeaderEscaper.Replace(e.Type),
+		cefHeaderEscaper.Replace(eventName(e)),
+		severity,
+		strings.Join(ext, " "),
+	}, "|")
+}
+
+// formatLEEF formats the given event as a LEEF 1.0 record.
+func formatLEEF(e Event) string {
+	severity := "8"
+	if e.Type == TypeReported {
+		severity = "5"
+	}
+	attrs := []string{
+		"devTime=" + e.Timestamp.UTC().Format("Jan 02 2006 15:04:05.000 MST"),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss.SSS z",
+		"cat=malware",
+		"sev=" + severity,
+		"fileHash=" + leefEscaper.Replace(e.Hash),
+	}
+	if e.Skylink != "" {
+		attrs = append(attrs, "resource="+leefEscaper.Replace(e.Skylink))
+	}
+	if e.Size > 0 {
+		attrs = append(attrs, "fileSize="+strconv.FormatUint(e.Size, 10))
+	}
+	if e.Description != "" {
+		attrs = append(attrs, "signature="+leefEscaper.Replace(e.Description))
+	}
+	header := strings.Join([]string{"LEEF:1.0", siemVendor, siemProduct, siemVersion, e.Type}, "|")
+	return header + "|" + strings.Join(attrs, "\t")
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFormatCEF ensures we format events as CEF records and escape their
+// values.
+func TestFormatCEF(t *testing.T) {
+	e := Event{
+		Type:        TypeInfected,
+		Hash:        "aa",
+		Skylink:     "AAC0uO43g64ULpyrW0zO3bjEu3Et",
+		Size:        68,
+		Description: `Eicar=Test|Sig\1`,
+		Timestamp:   time.Unix(1646136000, 123456789),
+	}
+	expected := `CEF:0|SkynetLabs|malware-scanner|1.0|infected|Infected content detected|8|rt=1646136000123 act=detected fileHash=aa fname=AAC0uO43g64ULpyrW0zO3bjEu3Et fsize=68 cs1Label=signature cs1=Eicar\=Test|Sig\\1`
+	if s := formatCEF(e); s != expected {
+		t.Fatalf("Unexpected CEF record\nexpected: %s\ngot:      %s", expected, s)
+	}
+	e.Type = TypeReported
+	if s := formatCEF(e); !strings.Contains(s, "|reported|Infected content blocked|5|") || !strings.Contains(s, "act=blocked") {
+		t.Fatalf("Unexpected CEF record %s", s)
+	}
+}
+
+// TestFormatLEEF ensures we format events as LEEF records.
+func TestFormatLEEF(t *testing.T) {
+	e := Event{
+		Type:        TypeInfected,
+		Hash:        "aa",
+		Description: "Eicar\tTest",
+		Timestamp:   time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	expected := "LEEF:1.0|SkynetLabs|malware-scanner|1.0|infected|devTime=Mar 01 2022 12:00:00.000 UTC\tdevTimeFormat=MMM dd yyyy HH:mm:ss.SSS z\tcat=malware\tsev=8\tfileHash=aa\tsignature=Eicar Test"
+	if s := formatLEEF(e); s != expected {
+		t.Fatalf("Unexpected LEEF record\nexpected: %s\ngot:      %s", expected, s)
+	}
+}
+
+// TestSyslogSink ensures the sink sends infection events over TCP, skips
+// other events and reconnects after the server drops the connection.
+func TestSyslogSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sc := bufio.NewScanner(conn)
+			for sc.Scan() {
+				lines <- sc.Text()
+				// Drop the connection after every message, so the sink
+				// needs to reconnect.
+				_ = conn.Close()
+			}
+		}
+	}()
+
+	s, err := NewSyslogSink("tcp://"+ln.Addr().String(), FormatCEF, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, e := range []Event{
+		{Type: TypeScanned, Hash: "aa", Timestamp: ts},
+		{Type: TypeInfected, Hash: "bb", Timestamp: ts},
+	} {
+		if err = s.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case line := <-lines:
+		prefix := "<132>1 2022-03-01T12:00:00Z " + s.staticHostname + " malware-scanner - infected - CEF:0|"
+		if !strings.HasPrefix(line, prefix) || !strings.Contains(line, "fileHash=bb") {
+			t.Fatalf("Unexpected message %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the message.")
+	}
+
+	// The server closed the connection. Writes fail once the closure is
+	// noticed, after which the sink reconnects.
+	for i := 0; i < 3; i++ {
+		_ = s.Write(Event{Type: TypeReported, Hash: "cc", Timestamp: ts})
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "fileHash=cc") {
+			t.Fatalf("Unexpected message %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the message after reconnecting.")
+	}
+
+	for _, addr := range []string{"siem.example.com:514", "tcp://siem.example.com", "http://siem.example.com:514"} {
+		if _, err = NewSyslogSink(addr, FormatCEF, ""); err == nil {
+			t.Fatalf("Expected an error for '%s'", addr)
+		}
+	}
+	if _, err = NewSyslogSink("udp://siem.example.com:514", "json", ""); err == nil {
+		t.Fatal("Expected an error for an unsupported format.")
+	}
+}
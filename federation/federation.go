@@ -0,0 +1,184 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/metrics"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// pullBatchSize is the number of verdicts we pull from a peer at once.
+	pullBatchSize = 1000
+	// maxResponseSize is the maximum size of a peer's response we read.
+	maxResponseSize = 16 << 20
+)
+
+var (
+	// metricPulled counts the verdicts pulled from federated scanner
+	// instances by peer.
+	metricPulled = metrics.NewCounterVec("federation_pulled_verdicts_total", "Number of verdicts pulled from federated scanner instances.", "peer")
+	// metricPullFailures counts the failed pulls by peer.
+	metricPullFailures = metrics.NewCounterVec("federation_pull_failures_total", "Number of failed verdict pulls from federated scanner instances.", "peer")
+)
+
+type (
+	// Peer is a federated scanner instance whose verdicts we pull. Key is the
+	// federation key the peer has issued to us.
+	Peer struct {
+		Name string
+		URL  string
+		Key  string
+	}
+
+	// verdictsResponse is a peer's response to a verdicts request.
+	verdictsResponse struct {
+		Verdicts []database.PeerVerdict `json:"verdicts"`
+	}
+)
+
+// ParsePeers parses a comma-separated list of `name=url` pairs, authenticating
+// with all peers with the given key.
+func ParsePeers(s, key string) ([]Peer, error) {
+	var peers []Peer
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, errors.New("invalid peer, expected name=url: " + pair)
+		}
+		p := Peer{Name: pair[:i], URL: strings.TrimSuffix(pair[i+1:], "/"), Key: key}
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("invalid peer URL: " + p.URL)
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// Start launches a background thread which pulls the new verdicts of the given
+// peers every interval until the context is cancelled. The scanner consults
+// them before downloading a skylink, so content a peer has already scanned
+// isn't scanned again. The progress of each peer is stored in the database.
+// Peers we pull for the first time start with the verdicts of the last lookback
+// period.
+func Start(ctx context.Context, db *database.DB, peers []Peer, interval, lookback time.Duration, logger *logrus.Logger) error {
+	if db == nil {
+		return errors.New("no DB provided")
+	}
+	if len(peers) == 0 {
+		return errors.New("no peers provided")
+	}
+	if interval <= 0 {
+		return errors.New("invalid federation pull interval")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	client := &http.Client{Timeout: time.Minute}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, p := range peers {
+				n, err := pull(ctx, db, client, p, lookback)
+				if err != nil {
+					metricPullFailures.With(p.Name).Inc()
+					logger.Warnln(errors.AddContext(err, "failed to pull verdicts from peer "+p.Name))
+				}
+				if n > 0 {
+					logger.Infof("Pulled %d verdicts from peer %s", n, p.Name)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// pull stores all verdicts of the given peer we haven't pulled yet. It returns
+// the number of pulled verdicts.
+func pull(ctx context.Context, db *database.DB, client *http.Client, p Peer, lookback time.Duration) (int, error) {
+	cursorName := "federation_" + p.Name
+	since, err := db.Cursor(ctx, cursorName)
+	if err != nil {
+		return 0, err
+	}
+	if since.IsZero() {
+		since = time.Now().UTC().Add(-lookback)
+	}
+	var count int
+	for {
+		verdicts, err := Fetch(ctx, client, p, since, pullBatchSize)
+		if err != nil {
+			return count, err
+		}
+		if len(verdicts) == 0 {
+			return count, nil
+		}
+		for i := range verdicts {
+			verdicts[i].Peer = p.Name
+		}
+		err = db.SavePeerVerdicts(ctx, verdicts)
+		if err != nil {
+			return count, err
+		}
+		count += len(verdicts)
+		metricPulled.With(p.Name).Add(float64(len(verdicts)))
+		since = verdicts[len(verdicts)-1].ScannedAt
+		err = db.SetCursor(ctx, cursorName, since)
+		if err != nil {
+			return count, err
+		}
+		if len(verdicts) < pullBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// Fetch returns up to limit verdicts the given peer reached after the given
+// time, oldest first.
+func Fetch(ctx context.Context, client *http.Client, p Peer, since time.Time, limit int) ([]database.PeerVerdict, error) {
+	q := url.Values{}
+	q.Set("since", since.UTC().Format(time.RFC3339Nano))
+	q.Set("limit", strconv.Itoa(limit))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+"/federation/verdicts?"+q.Encode(), nil)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build verdicts request")
+	}
+	req.Header.Set("Authorization", "Bearer "+p.Key)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to call peer")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return nil, errors.New(fmt.Sprintf("peer failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	var vr verdictsResponse
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&vr)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse peer's response")
+	}
+	return vr.Verdicts, nil
+}
@@ -0,0 +1,82 @@
+package federation
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestParsePeers ensures we parse peers and reject invalid ones.
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers(" eu=https://scanner.eu.example.com/, us=http://10.0.0.2:4000,", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("Expected 2 peers, got %d", len(peers))
+	}
+	if peers[0] != (Peer{Name: "eu", URL: "https://scanner.eu.example.com", Key: "key"}) {
+		t.Fatalf("Unexpected peer %+v", peers[0])
+	}
+	if peers[1] != (Peer{Name: "us", URL: "http://10.0.0.2:4000", Key: "key"}) {
+		t.Fatalf("Unexpected peer %+v", peers[1])
+	}
+	for _, s := range []string{"https://scanner.example.com", "=https://scanner.example.com", "eu=ftp://scanner.example.com"} {
+		if _, err = ParsePeers(s, "key"); err == nil {
+			t.Fatalf("Expected an error for '%s'", s)
+		}
+	}
+}
+
+// TestFetch ensures we pull a peer's verdicts with the right parameters and
+// credentials.
+func TestFetch(t *testing.T) {
+	defer gock.Off()
+	client := &http.Client{}
+	gock.InterceptClient(client)
+
+	p := Peer{Name: "eu", URL: "https://scanner.example.com", Key: "key"}
+	since := time.Date(2022, 3, 1, 12, 0, 0, 500, time.UTC)
+	h := crypto.HashBytes([]byte("content"))
+	gock.New(p.URL).
+		Get("/federation/verdicts").
+		MatchParam("since", "2022-03-01T12:00:00.0000005Z").
+		MatchParam("limit", "10").
+		MatchHeader("Authorization", "Bearer key").
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{
+			"verdicts": []map[string]interface{}{{
+				"hash":                 h.String(),
+				"infected":             true,
+				"infectionDescription": "Win.Test.EICAR_HDB-1",
+				"scannedAt":            "2022-03-01T12:05:00Z",
+			}},
+		})
+	verdicts, err := Fetch(context.Background(), client, p, since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verdicts) != 1 {
+		t.Fatalf("Expected 1 verdict, got %d", len(verdicts))
+	}
+	v := verdicts[0]
+	if v.Hash != h || !v.Infected || v.InfectionDescription != "Win.Test.EICAR_HDB-1" || !v.ScannedAt.Equal(since.Add(5*time.Minute-500)) {
+		t.Fatalf("Unexpected verdict %+v", v)
+	}
+
+	gock.New(p.URL).
+		Get("/federation/verdicts").
+		Reply(http.StatusUnauthorized).
+		JSON(map[string]string{"message": "invalid federation key"})
+	_, err = Fetch(context.Background(), client, p, since, 10)
+	if err == nil {
+		t.Fatal("Expected an error for a failed pull.")
+	}
+	if !gock.IsDone() {
+		t.Fatal("Not all expected requests were made.")
+	}
+}
@@ -0,0 +1,615 @@
+// Package graphql parses the subset of GraphQL we serve read-only reporting
+// queries with: a query operation, optionally named and with variables,
+// selecting fields with aliases and arguments. Fragments, directives,
+// mutations and subscriptions aren't supported.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// maxDepth is the maximum nesting depth of selections and values we
+	// parse, so hostile queries can't exhaust the stack.
+	maxDepth = 32
+)
+
+type (
+	// Field is a field selected by a query, together with its arguments and
+	// the fields selected on its value. Alias is the key of the field in the
+	// response, which defaults to its name. Arguments hold strings, int64s,
+	// float64s, bools, nils, []interface{}s and map[string]interface{}s,
+	// with variables already substituted.
+	Field struct {
+		Alias  string
+		Name   string
+		Args   map[string]interface{}
+		Fields []*Field
+	}
+
+	// token is a lexical token of a query.
+	token struct {
+		kind  tokenKind
+		value string
+		pos   int
+	}
+
+	// tokenKind is the kind of a token.
+	tokenKind int
+
+	// operation is a parsed operation definition.
+	operation struct {
+		name     string
+		typ      string
+		defaults map[string]interface{}
+		vars     map[string]struct{}
+		fields   []*Field
+	}
+
+	// parser parses a tokenised query.
+	parser struct {
+		tokens []token
+		pos    int
+		depth  int
+		// declared holds the variables the operation being parsed declares.
+		declared map[string]struct{}
+	}
+
+	// variableRef is a reference to a variable, which is substituted once
+	// the operation's variable definitions are known.
+	variableRef string
+)
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// Parse parses the given query document and returns the fields its operation
+// selects on the root type, with the given variables substituted. If the
+// document holds several operations, operationName picks the one to return.
+func Parse(query string, variables map[string]interface{}, operationName string) ([]*Field, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	var ops []*operation
+	for p.peek().kind != tokenEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("no operation found")
+	}
+	var op *operation
+	for _, o := range ops {
+		if (operationName == "" && len(ops) == 1) || (operationName != "" && o.name == operationName) {
+			op = o
+			break
+		}
+	}
+	if op == nil {
+		if operationName == "" {
+			return nil, errors.New("operationName is required for documents with several operations")
+		}
+		return nil, errors.New("unknown operation " + operationName)
+	}
+	if op.typ != "query" {
+		return nil, errors.New("only queries are supported")
+	}
+	vars := make(map[string]interface{}, len(op.defaults))
+	for name, v := range op.defaults {
+		vars[name] = v
+	}
+	for name, v := range variables {
+		if _, ok := op.vars[name]; ok {
+			vars[name] = normalize(v)
+		}
+	}
+	for _, f := range op.fields {
+		substitute(f, vars)
+	}
+	return op.fields, nil
+}
+
+// substitute replaces the variable references in the arguments of the given
+// field and its subfields.
+func substitute(f *Field, vars map[string]interface{}) {
+	for name, arg := range f.Args {
+		f.Args[name] = resolveValue(arg, vars)
+	}
+	for _, sub := range f.Fields {
+		substitute(sub, vars)
+	}
+}
+
+// resolveValue replaces the variable references in the given value.
+func resolveValue(v interface{}, vars map[string]interface{}) interface{} {
+	switch val := v.(type) {
+	case variableRef:
+		return vars[string(val)]
+	case []interface{}:
+		for i := range val {
+			val[i] = resolveValue(val[i], vars)
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = resolveValue(val[k], vars)
+		}
+	}
+	return v
+}
+
+// normalize converts JSON numbers without a fractional part to int64s, so
+// variables look the same as literals.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case float64:
+		if val == float64(int64(val)) {
+			return int64(val)
+		}
+	case []interface{}:
+		for i := range val {
+			val[i] = normalize(val[i])
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = normalize(val[k])
+		}
+	}
+	return v
+}
+
+// parseOperation parses an operation definition, which is either a bare
+// selection set or an operation type followed by an optional name, variable
+// definitions and a selection set.
+func (p *parser) parseOperation() (*operation, error) {
+	op := &operation{
+		typ:      "query",
+		defaults: make(map[string]interface{}),
+		vars:     make(map[string]struct{}),
+	}
+	p.declared = op.vars
+	if t := p.peek(); t.kind == tokenName {
+		switch t.value {
+		case "query", "mutation", "subscription":
+		case "fragment":
+			return nil, p.errorf(t, "fragments are not supported")
+		default:
+			return nil, p.errorf(t, "unexpected %s", t.value)
+		}
+		op.typ = p.next().value
+		if p.peek().kind == tokenName {
+			op.name = p.next().value
+		}
+		if p.peekPunct("(") {
+			err := p.parseVariableDefinitions(op)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.peekPunct("@") {
+		return nil, p.errorf(p.peek(), "directives are not supported")
+	}
+	fields, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.fields = fields
+	return op, nil
+}
+
+// parseVariableDefinitions parses a list of variable definitions, e.g.
+// `($first: Int = 10, $status: String!)`. Types aren't checked.
+func (p *parser) parseVariableDefinitions(op *operation) error {
+	p.next()
+	for !p.peekPunct(")") {
+		if err := p.expectPunct("$"); err != nil {
+			return err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		if err = p.expectPunct(":"); err != nil {
+			return err
+		}
+		if err = p.parseType(); err != nil {
+			return err
+		}
+		op.vars[name] = struct{}{}
+		if p.peekPunct("=") {
+			p.next()
+			v, err := p.parseValue(true)
+			if err != nil {
+				return err
+			}
+			op.defaults[name] = v
+		}
+	}
+	p.next()
+	return nil
+}
+
+// parseType parses a type reference, e.g. `String`, `[Int!]!`.
+func (p *parser) parseType() error {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return p.errorf(p.peek(), "type is nested too deeply")
+	}
+	if p.peekPunct("[") {
+		p.next()
+		if err := p.parseType(); err != nil {
+			return err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return err
+	}
+	if p.peekPunct("!") {
+		p.next()
+	}
+	return nil
+}
+
+// parseSelectionSet parses a selection set, e.g. `{ a b: c(x: 1) { d } }`.
+func (p *parser) parseSelectionSet() ([]*Field, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf(p.peek(), "query is nested too deeply")
+	}
+	var fields []*Field
+	for !p.peekPunct("}") {
+		if p.peekPunct("...") {
+			return nil, p.errorf(p.peek(), "fragments are not supported")
+		}
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.next()
+	if len(fields) == 0 {
+		return nil, errors.New("empty selection set")
+	}
+	return fields, nil
+}
+
+// parseField parses a single field selection.
+func (p *parser) parseField() (*Field, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	f := &Field{Alias: name, Name: name, Args: make(map[string]interface{})}
+	if p.peekPunct(":") {
+		p.next()
+		f.Name, err = p.expectName()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.peekPunct("(") {
+		p.next()
+		for !p.peekPunct(")") {
+			arg, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+			v, err := p.parseValue(false)
+			if err != nil {
+				return nil, err
+			}
+			f.Args[arg] = v
+		}
+		p.next()
+	}
+	if p.peekPunct("@") {
+		return nil, p.errorf(p.peek(), "directives are not supported")
+	}
+	if p.peekPunct("{") {
+		f.Fields, err = p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// parseValue parses an input value. Constant values, such as variable
+// defaults, can't reference variables.
+func (p *parser) parseValue(constant bool) (interface{}, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	t := p.next()
+	if p.depth > maxDepth {
+		return nil, p.errorf(t, "value is nested too deeply")
+	}
+	switch t.kind {
+	case tokenInt:
+		n, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid integer %s", t.value)
+		}
+		return n, nil
+	case tokenFloat:
+		n, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.value)
+		}
+		return n, nil
+	case tokenString:
+		return t.value, nil
+	case tokenName:
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		// Enum values are passed on as strings.
+		return t.value, nil
+	case tokenPunct:
+		switch t.value {
+		case "$":
+			if constant {
+				return nil, p.errorf(t, "unexpected variable")
+			}
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.declared[name]; !ok {
+				return nil, p.errorf(t, "variable $%s is not defined", name)
+			}
+			return variableRef(name), nil
+		case "[":
+			list := []interface{}{}
+			for !p.peekPunct("]") {
+				if p.peek().kind == tokenEOF {
+					return nil, p.errorf(p.peek(), "unterminated list")
+				}
+				v, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			obj := make(map[string]interface{})
+			for !p.peekPunct("}") {
+				name, err := p.expectName()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expectPunct(":"); err != nil {
+					return nil, err
+				}
+				v, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				obj[name] = v
+			}
+			p.next()
+			return obj, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", describe(t))
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token. It keeps returning the EOF token
+// at the end of the query.
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// peekPunct returns whether the next token is the given punctuator.
+func (p *parser) peekPunct(s string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.value == s
+}
+
+// expectPunct consumes the given punctuator or fails.
+func (p *parser) expectPunct(s string) error {
+	t := p.next()
+	if t.kind != tokenPunct || t.value != s {
+		return p.errorf(t, "expected %s, found %s", s, describe(t))
+	}
+	return nil
+}
+
+// expectName consumes a name or fails.
+func (p *parser) expectName() (string, error) {
+	t := p.next()
+	if t.kind != tokenName {
+		return "", p.errorf(t, "expected a name, found %s", describe(t))
+	}
+	return t.value, nil
+}
+
+// errorf returns a syntax error at the given token.
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return errors.New(fmt.Sprintf("syntax error at position %d: %s", t.pos, fmt.Sprintf(format, args...)))
+}
+
+// describe returns a description of the given token for error messages.
+func describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return strconv.Quote(t.value)
+	}
+	return t.value
+}
+
+// lex splits the given query into tokens. Commas, whitespace and comments are
+// skipped.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' && s[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "\ufeff"):
+			i += len("\ufeff")
+		case strings.HasPrefix(s[i:], "..."):
+			tokens = append(tokens, token{tokenPunct, "...", i})
+			i += 3
+		case strings.IndexByte("!$():=@[]{|}&", c) >= 0:
+			tokens = append(tokens, token{tokenPunct, string(c), i})
+			i++
+		case c == '_' || isLetter(c):
+			start := i
+			for i < len(s) && (s[i] == '_' || isLetter(s[i]) || isDigit(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenName, s[start:i], start})
+		case c == '-' || isDigit(c):
+			start := i
+			kind := tokenInt
+			if c == '-' {
+				i++
+			}
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+			if i < len(s) && s[i] == '.' {
+				kind = tokenFloat
+				i++
+				for i < len(s) && isDigit(s[i]) {
+					i++
+				}
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				kind = tokenFloat
+				i++
+				if i < len(s) && (s[i] == '+' || s[i] == '-') {
+					i++
+				}
+				for i < len(s) && isDigit(s[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind, s[start:i], start})
+		case c == '"':
+			if strings.HasPrefix(s[i:], `"""`) {
+				return nil, errors.New(fmt.Sprintf("syntax error at position %d: block strings are not supported", i))
+			}
+			str, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("syntax error at position %d: %s", i, err))
+			}
+			tokens = append(tokens, token{tokenString, str, i})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(s[i:])
+			return nil, errors.New(fmt.Sprintf("syntax error at position %d: unexpected character %q", i, r))
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(s)}), nil
+}
+
+// lexString lexes the string literal at the start of s. It returns its value
+// and length.
+func lexString(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n', '\r':
+			return "", 0, errors.New("unterminated string")
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+			switch s[i] {
+			case '"', '\\', '/':
+				b.WriteByte(s[i])
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(s) {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", 0, errors.New("invalid escape sequence")
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// isLetter returns whether c is an ASCII letter.
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isDigit returns whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,95 @@
+package graphql
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParse ensures we parse queries with aliases, arguments, variables and
+// nested selections.
+func TestParse(t *testing.T) {
+	query := `
+# Recent infections.
+query Infected($first: Int = 10, $from: String!, $status: [String]) {
+  recent: skylinks(infected: true, first: $first, scannedFrom: $from, status: $status, filter: {size: [1, 2.5]}) {
+    totalCount
+    nodes { hash infectionDescription blocker { result } }
+  }
+}`
+	fields, err := Parse(query, map[string]interface{}{"from": "2022-03-01", "status": []interface{}{"complete"}, "unused": 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 {
+		t.Fatalf("Expected 1 field, got %d", len(fields))
+	}
+	f := fields[0]
+	if f.Alias != "recent" || f.Name != "skylinks" {
+		t.Fatalf("Unexpected field %s: %s", f.Alias, f.Name)
+	}
+	if f.Args["infected"] != true || f.Args["first"] != int64(10) || f.Args["scannedFrom"] != "2022-03-01" {
+		t.Fatalf("Unexpected arguments %v", f.Args)
+	}
+	if s, ok := f.Args["status"].([]interface{}); !ok || len(s) != 1 || s[0] != "complete" {
+		t.Fatalf("Unexpected status argument %v", f.Args["status"])
+	}
+	filter, ok := f.Args["filter"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Unexpected filter argument %v", f.Args["filter"])
+	}
+	if size, ok := filter["size"].([]interface{}); !ok || size[0] != int64(1) || size[1] != 2.5 {
+		t.Fatalf("Unexpected size %v", filter["size"])
+	}
+	if len(f.Fields) != 2 || f.Fields[0].Name != "totalCount" || len(f.Fields[1].Fields) != 3 {
+		t.Fatalf("Unexpected selection %+v", f.Fields)
+	}
+	if blocker := f.Fields[1].Fields[2]; blocker.Name != "blocker" || blocker.Fields[0].Name != "result" {
+		t.Fatalf("Unexpected nested selection %+v", blocker)
+	}
+
+	// Variables override defaults and JSON numbers become integers.
+	fields, err = Parse(query, map[string]interface{}{"first": float64(5)}, "Infected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields[0].Args["first"] != int64(5) || fields[0].Args["scannedFrom"] != nil {
+		t.Fatalf("Unexpected arguments %v", fields[0].Args)
+	}
+
+	// Shorthand queries and string escapes.
+	fields, err = Parse(`{ skylinks(status: "a\"bé\n") { nodes { hash } } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields[0].Args["status"] != "a\"bé\n" {
+		t.Fatalf("Unexpected string %q", fields[0].Args["status"])
+	}
+}
+
+// TestParseErrors ensures we reject invalid and unsupported queries.
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"":                                   "no operation",
+		"{}":                                 "empty selection set",
+		"{ a(x: 1 }":                         "expected a name",
+		"{ a":                                "expected a name",
+		`{ a(x: "abc) }`:                     "unterminated string",
+		"{ a(x: $y) }":                       "variable $y is not defined",
+		"mutation { a }":                     "only queries",
+		"{ a { ...f } }":                     "fragments are not supported",
+		"fragment f on A { a }":              "fragments are not supported",
+		"{ a @include(if: true) }":           "directives are not supported",
+		"query A { a } query B { b }":        "operationName is required",
+		"{ a(x: " + strings.Repeat("[", 100): "nested too deeply",
+		"{ a ~ }":                            "unexpected character",
+	}
+	for query, msg := range tests {
+		_, err := Parse(query, nil, "")
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("Expected an error containing '%s' for '%s', got %v", msg, query, err)
+		}
+	}
+	if _, err := Parse("query A { a } query B { b }", nil, "C"); err == nil || !strings.Contains(err.Error(), "unknown operation C") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package intel
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// exportBatchSize is the maximum number of indicators we export at once.
+	exportBatchSize = 1000
+)
+
+type (
+	// Indicator describes a piece of infected content. Hash is the hash of
+	// the content's merkle root, which identifies it regardless of the
+	// skylink used to access it.
+	Indicator struct {
+		Hash       string
+		Signature  string
+		Size       uint64
+		DetectedAt time.Time
+	}
+
+	// Exporter publishes indicators to an external threat intelligence
+	// platform.
+	Exporter interface {
+		// Name identifies the exporter. It's used to track its progress.
+		Name() string
+		// Export publishes the given indicators.
+		Export(ctx context.Context, indicators []Indicator) error
+	}
+)
+
+// Start launches a background thread which exports the indicators of newly
+// detected infected content every interval until the context is cancelled.
+// The progress of each exporter is stored in the database, so nothing is
+// exported twice across restarts. Exporters which run for the first time start
+// with the detections of the last lookback period.
+func Start(ctx context.Context, db *database.DB, e Exporter, interval, lookback time.Duration, logger *logrus.Logger) error {
+	if db == nil {
+		return errors.New("no DB provided")
+	}
+	if e == nil {
+		return errors.New("no exporter provided")
+	}
+	if interval <= 0 {
+		return errors.New("invalid export interval")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n, err := export(ctx, db, e, lookback)
+			if err != nil {
+				logger.Warnln(errors.AddContext(err, "failed to export indicators to "+e.Name()))
+			}
+			if n > 0 {
+				logger.Infof("Exported %d indicators to %s", n, e.Name())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// export exports all indicators the exporter hasn't exported yet. It returns
+// the number of exported indicators.
+func export(ctx context.Context, db *database.DB, e Exporter, lookback time.Duration) (int, error) {
+	cursorName := "intel_" + e.Name()
+	since, err := db.Cursor(ctx, cursorName)
+	if err != nil {
+		return 0, err
+	}
+	if since.IsZero() {
+		since = time.Now().UTC().Add(-lookback)
+	}
+	var count int
+	for {
+		sls, err := db.InfectedSince(ctx, since, exportBatchSize)
+		if err != nil {
+			return count, err
+		}
+		if len(sls) == 0 {
+			return count, nil
+		}
+		err = e.Export(ctx, indicators(sls))
+		if err != nil {
+			return count, err
+		}
+		count += len(sls)
+		since = sls[len(sls)-1].ScannedAt
+		err = db.SetCursor(ctx, cursorName, since)
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// indicators converts the given infected skylink records into indicators.
+func indicators(sls []database.Skylink) []Indicator {
+	inds := make([]Indicator, 0, len(sls))
+	for _, sl := range sls {
+		inds = append(inds, Indicator{
+			Hash:       sl.Hash.String(),
+			Signature:  sl.InfectionDescription,
+			Size:       sl.Size,
+			DetectedAt: sl.ScannedAt,
+		})
+	}
+	return inds
+}
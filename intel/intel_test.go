@@ -0,0 +1,84 @@
+package intel
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v1"
+)
+
+// testIndicators are the indicators used in the tests.
+var testIndicators = []Indicator{{
+	Hash:       "4dbd5cb4a3d5c7b1cb07ec0bdf8c5f4fb3e0d1b0e5fd0b2f81b1e9c6a5d5c1a2",
+	Signature:  "Win.Test.EICAR_HDB-1",
+	Size:       68,
+	DetectedAt: time.Date(2021, 12, 7, 9, 21, 39, 0, time.UTC),
+}}
+
+// TestSTIXObjects ensures we build valid STIX objects with stable IDs.
+func TestSTIXObjects(t *testing.T) {
+	now := time.Date(2021, 12, 8, 0, 0, 0, 0, time.UTC)
+	objs := stixObjects(testIndicators, now)
+	if len(objs) != 2 || objs[0].Type != "identity" || objs[1].Type != "indicator" {
+		t.Fatalf("Unexpected objects %+v", objs)
+	}
+	ind := objs[1]
+	uuidRe := regexp.MustCompile(`^indicator--[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuidRe.MatchString(ind.ID) {
+		t.Fatalf("Invalid STIX ID '%s'", ind.ID)
+	}
+	if ind.ID != stixObjects(testIndicators, now.Add(time.Hour))[1].ID {
+		t.Fatal("Expected the ID to be stable across exports")
+	}
+	if ind.Pattern != "[x-skynet-content:hash = '"+testIndicators[0].Hash+"']" {
+		t.Fatalf("Unexpected pattern '%s'", ind.Pattern)
+	}
+	if ind.ValidFrom != "2021-12-07T09:21:39.000Z" || ind.Modified != "2021-12-08T00:00:00.000Z" || ind.CreatedByRef != objs[0].ID {
+		t.Fatalf("Unexpected indicator %+v", ind)
+	}
+}
+
+// TestExporters ensures the MISP and TAXII exporters call their APIs as
+// expected.
+func TestExporters(t *testing.T) {
+	defer gock.Off()
+
+	m, err := NewMISPExporter("https://misp.example.com/", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gock.New("https://misp.example.com").
+		Post("/events/add").
+		MatchHeader("Authorization", "^key$").
+		BodyString(testIndicators[0].Hash).
+		Reply(http.StatusOK)
+	if err = m.Export(context.Background(), testIndicators); err != nil {
+		t.Fatal(err)
+	}
+	gock.New("https://misp.example.com").
+		Post("/events/add").
+		Reply(http.StatusForbidden)
+	if err = m.Export(context.Background(), testIndicators); err == nil || !strings.Contains(err.Error(), "status code 403") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	x, err := NewTAXIIExporter("https://taxii.example.com/api1/collections/abc/", "Bearer token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gock.New("https://taxii.example.com").
+		Post("/api1/collections/abc/objects/").
+		MatchHeader("Content-Type", "application/taxii\\+json;version=2.1").
+		MatchHeader("Authorization", "^Bearer token$").
+		Reply(http.StatusAccepted)
+	if err = x.Export(context.Background(), testIndicators); err != nil {
+		t.Fatal(err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
@@ -0,0 +1,137 @@
+package intel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// mispDistributionCommunity shares MISP events with the MISP
+	// instance's community.
+	mispDistributionCommunity = "1"
+	// mispThreatLevelMedium is MISP's medium threat level.
+	mispThreatLevelMedium = "2"
+	// mispAnalysisCompleted marks the analysis of a MISP event as completed.
+	mispAnalysisCompleted = "2"
+)
+
+type (
+	// MISPExporter publishes indicators to a MISP instance. Each export
+	// creates a single MISP event with an attribute per indicator.
+	MISPExporter struct {
+		staticURL    string
+		staticKey    string
+		staticClient *http.Client
+	}
+
+	// mispEvent is the body of MISP's add event request.
+	mispEvent struct {
+		Event struct {
+			Info          string          `json:"info"`
+			Date          string          `json:"date"`
+			Distribution  string          `json:"distribution"`
+			ThreatLevelID string          `json:"threat_level_id"`
+			Analysis      string          `json:"analysis"`
+			Attribute     []mispAttribute `json:"Attribute"`
+			Tag           []mispTag       `json:"Tag"`
+		} `json:"Event"`
+	}
+
+	// mispAttribute is a single attribute of a MISP event.
+	mispAttribute struct {
+		Type      string `json:"type"`
+		Category  string `json:"category"`
+		Value     string `json:"value"`
+		Comment   string `json:"comment"`
+		ToIDS     bool   `json:"to_ids"`
+		Timestamp string `json:"timestamp"`
+	}
+
+	// mispTag is a tag of a MISP event.
+	mispTag struct {
+		Name string `json:"name"`
+	}
+)
+
+// NewMISPExporter returns an exporter to the MISP instance at the given URL,
+// authenticating with the given API key.
+func NewMISPExporter(url, key string) (*MISPExporter, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("invalid MISP URL: " + url)
+	}
+	if key == "" {
+		return nil, errors.New("missing MISP API key")
+	}
+	return &MISPExporter{
+		staticURL:    strings.TrimSuffix(url, "/"),
+		staticKey:    key,
+		staticClient: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Name implements Exporter.
+func (m *MISPExporter) Name() string {
+	return "misp"
+}
+
+// Export implements Exporter.
+func (m *MISPExporter) Export(ctx context.Context, indicators []Indicator) error {
+	if len(indicators) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(newMISPEvent(indicators, time.Now().UTC()))
+	if err != nil {
+		return errors.AddContext(err, "failed to build MISP event")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.staticURL+"/events/add", bytes.NewReader(body))
+	if err != nil {
+		return errors.AddContext(err, "failed to build MISP request")
+	}
+	req.Header.Set("Authorization", m.staticKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	res, err := m.staticClient.Do(req)
+	if err != nil {
+		return errors.AddContext(err, "failed to call MISP")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return errors.New(fmt.Sprintf("MISP failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	return nil
+}
+
+// newMISPEvent builds a MISP event holding the given indicators.
+func newMISPEvent(indicators []Indicator, now time.Time) mispEvent {
+	var e mispEvent
+	e.Event.Info = fmt.Sprintf("Skynet malware scanner detections (%d)", len(indicators))
+	e.Event.Date = now.Format("2006-01-02")
+	e.Event.Distribution = mispDistributionCommunity
+	e.Event.ThreatLevelID = mispThreatLevelMedium
+	e.Event.Analysis = mispAnalysisCompleted
+	e.Event.Tag = []mispTag{{Name: "tlp:white"}, {Name: "source:skynet-malware-scanner"}}
+	for _, ind := range indicators {
+		e.Event.Attribute = append(e.Event.Attribute, mispAttribute{
+			// The hash is of the content's merkle root, which none of MISP's
+			// hash types describe.
+			Type:      "other",
+			Category:  "Payload delivery",
+			Value:     ind.Hash,
+			Comment:   fmt.Sprintf("Skynet content hash. Signature: %s. Size: %d bytes.", ind.Signature, ind.Size),
+			ToIDS:     false,
+			Timestamp: strconv.FormatInt(ind.DetectedAt.Unix(), 10),
+		})
+	}
+	return e
+}
@@ -0,0 +1,162 @@
+package intel
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// taxiiContentType is the media type of TAXII 2.1 requests.
+	taxiiContentType = "application/taxii+json;version=2.1"
+	// stixTimeFormat is the timestamp format STIX requires.
+	stixTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+var (
+	// stixNamespace is the UUID namespace of the IDs of the STIX objects we
+	// create. Deriving the IDs from the content means that exporting the
+	// same detection twice updates the same object.
+	stixNamespace = [16]byte{0x6b, 0x1f, 0x3e, 0x52, 0x8c, 0x0d, 0x4a, 0x7e, 0x9b, 0x51, 0x2f, 0x64, 0xd3, 0x0a, 0x8e, 0x17}
+	// stixIdentityID is the ID of the STIX identity of the scanner, which
+	// creates all indicators.
+	stixIdentityID = stixID("identity", "skynet-malware-scanner")
+)
+
+type (
+	// TAXIIExporter publishes indicators as STIX 2.1 objects to a TAXII 2.1
+	// collection.
+	TAXIIExporter struct {
+		staticURL    string
+		staticAuth   string
+		staticClient *http.Client
+	}
+
+	// stixObject is a STIX 2.1 object. We only use the properties of
+	// identities and indicators.
+	stixObject struct {
+		Type           string   `json:"type"`
+		SpecVersion    string   `json:"spec_version"`
+		ID             string   `json:"id"`
+		Created        string   `json:"created"`
+		Modified       string   `json:"modified"`
+		Name           string   `json:"name"`
+		IdentityClass  string   `json:"identity_class,omitempty"`
+		CreatedByRef   string   `json:"created_by_ref,omitempty"`
+		Description    string   `json:"description,omitempty"`
+		IndicatorTypes []string `json:"indicator_types,omitempty"`
+		Pattern        string   `json:"pattern,omitempty"`
+		PatternType    string   `json:"pattern_type,omitempty"`
+		ValidFrom      string   `json:"valid_from,omitempty"`
+		Labels         []string `json:"labels,omitempty"`
+	}
+)
+
+// NewTAXIIExporter returns an exporter to the TAXII 2.1 collection at the
+// given URL, e.g. "https://taxii.example.com/api1/collections/<id>/". The auth
+// value is sent as the Authorization header, if set.
+func NewTAXIIExporter(url, auth string) (*TAXIIExporter, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("invalid TAXII collection URL: " + url)
+	}
+	return &TAXIIExporter{
+		staticURL:    strings.TrimSuffix(url, "/"),
+		staticAuth:   auth,
+		staticClient: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Name implements Exporter.
+func (t *TAXIIExporter) Name() string {
+	return "taxii"
+}
+
+// Export implements Exporter.
+func (t *TAXIIExporter) Export(ctx context.Context, indicators []Indicator) error {
+	if len(indicators) == 0 {
+		return nil
+	}
+	envelope := struct {
+		Objects []stixObject `json:"objects"`
+	}{stixObjects(indicators, time.Now().UTC())}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return errors.AddContext(err, "failed to build STIX envelope")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.staticURL+"/objects/", bytes.NewReader(body))
+	if err != nil {
+		return errors.AddContext(err, "failed to build TAXII request")
+	}
+	req.Header.Set("Accept", taxiiContentType)
+	req.Header.Set("Content-Type", taxiiContentType)
+	if t.staticAuth != "" {
+		req.Header.Set("Authorization", t.staticAuth)
+	}
+	res, err := t.staticClient.Do(req)
+	if err != nil {
+		return errors.AddContext(err, "failed to call TAXII server")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return errors.New(fmt.Sprintf("TAXII server failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	return nil
+}
+
+// stixObjects converts the given indicators into STIX 2.1 indicator objects,
+// preceded by the identity which creates them. Skynet content hashes have no
+// STIX observable type, so the patterns use the custom
+// `x-skynet-content:hash` property.
+func stixObjects(indicators []Indicator, now time.Time) []stixObject {
+	ts := now.UTC().Format(stixTimeFormat)
+	objects := []stixObject{{
+		Type:          "identity",
+		SpecVersion:   "2.1",
+		ID:            stixIdentityID,
+		Created:       ts,
+		Modified:      ts,
+		Name:          "Skynet Malware Scanner",
+		IdentityClass: "system",
+	}}
+	for _, ind := range indicators {
+		detected := ind.DetectedAt.UTC().Format(stixTimeFormat)
+		objects = append(objects, stixObject{
+			Type:           "indicator",
+			SpecVersion:    "2.1",
+			ID:             stixID("indicator", ind.Hash),
+			Created:        detected,
+			Modified:       ts,
+			Name:           "Skynet content detected as " + ind.Signature,
+			CreatedByRef:   stixIdentityID,
+			Description:    fmt.Sprintf("Content of %d bytes detected by ClamAV as %s.", ind.Size, ind.Signature),
+			IndicatorTypes: []string{"malicious-activity"},
+			Pattern:        fmt.Sprintf("[x-skynet-content:hash = '%s']", ind.Hash),
+			PatternType:    "stix",
+			ValidFrom:      detected,
+			Labels:         []string{ind.Signature},
+		})
+	}
+	return objects
+}
+
+// stixID returns the STIX ID of the object of the given type, derived from the
+// given name as a version 5 UUID.
+func stixID(typ, name string) string {
+	h := sha1.New()
+	_, _ = h.Write(stixNamespace[:])
+	_, _ = h.Write([]byte(typ + ":" + name))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%s--%x-%x-%x-%x-%x", typ, u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"encoding/hex"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+)
+
+const (
+	// redacted replaces the secrets we redact.
+	redacted = "[REDACTED]"
+	// minSecretLen is the length below which we don't redact a secret, so
+	// a short value doesn't garble every message which happens to contain
+	// it.
+	minSecretLen = 4
+	// skylinkLen is the length of a base64-encoded skylink.
+	skylinkLen = 46
+	// skylinkBase32Len is the length of a base32-encoded skylink, as used in
+	// subdomains.
+	skylinkBase32Len = 55
+)
+
+var (
+	// PrivacyMode replaces skylinks with their hashes in log output and
+	// error strings, so operators aren't exposed to references to
+	// potentially illegal content.
+	// Set according to the MALWARE_SCANNER_PRIVACY_MODE env var.
+	PrivacyMode bool
+
+	// secrets are the values we redact, longest first, so a secret which
+	// contains another one is redacted as a whole.
+	secrets   []string
+	secretsMu sync.RWMutex
+
+	// credentialsRegexp matches the credentials in URLs, e.g. a MongoDB
+	// connection string.
+	credentialsRegexp = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/\s:@]+:[^/\s@]+@`)
+	// bearerRegexp matches bearer tokens, e.g. in a dumped request.
+	bearerRegexp = regexp.MustCompile(`(Bearer\s+)[^\s"',]+`)
+	// skylinkRegexp matches the candidates for skylinks.
+	skylinkRegexp = regexp.MustCompile(`[a-zA-Z0-9_-]+`)
+)
+
+type (
+	// RedactHook is a logrus hook which redacts secrets from every entry's
+	// message and fields before they are formatted.
+	RedactHook struct{}
+
+	// redactWriter redacts secrets from everything written to it, for
+	// loggers we can't hook into, like the standard library's.
+	redactWriter struct {
+		w io.Writer
+	}
+)
+
+// AddSecrets registers values which are redacted from log output and error
+// strings, e.g. passwords and API keys. Empty and very short values are
+// ignored.
+func AddSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if len(v) >= minSecretLen {
+			secrets = append(secrets, v)
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+// Redact returns the given string with the registered secrets, the
+// credentials in URLs and bearer tokens redacted. In privacy mode skylinks
+// are replaced with their hashes as well.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	s = credentialsRegexp.ReplaceAllString(s, "${1}"+redacted+"@")
+	s = bearerRegexp.ReplaceAllString(s, "${1}"+redacted)
+	if PrivacyMode {
+		s = skylinkRegexp.ReplaceAllStringFunc(s, func(m string) string {
+			if len(m) != skylinkLen && len(m) != skylinkBase32Len {
+				return m
+			}
+			if h, ok := SkylinkHash(m); ok {
+				return "skylink:" + h
+			}
+			return m
+		})
+	}
+	return s
+}
+
+// SkylinkHash returns the hex-encoded hash of the given skylink's merkle root,
+// which is the hash records keep once their skylink is wiped for v1 skylinks.
+// It returns false if the string isn't a skylink.
+func SkylinkHash(skylink string) (string, bool) {
+	var sl skymodules.Skylink
+	if err := sl.LoadString(skylink); err != nil {
+		return "", false
+	}
+	h := crypto.HashObject(sl.MerkleRoot())
+	return hex.EncodeToString(h[:]), true
+}
+
+// NewRedactHook returns a hook which redacts log entries.
+func NewRedactHook() *RedactHook {
+	return &RedactHook{}
+}
+
+// Levels implements logrus.Hook.
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (h *RedactHook) Fire(e *logrus.Entry) error {
+	e.Message = Redact(e.Message)
+	for k, v := range e.Data {
+		switch val := v.(type) {
+		case string:
+			e.Data[k] = Redact(val)
+		case error:
+			e.Data[k] = Redact(val.Error())
+		}
+	}
+	return nil
+}
+
+// NewRedactWriter returns a writer which redacts everything written to it
+// before passing it on to the given writer. Each write must hold complete
+// messages, which is what log.Logger does.
+func NewRedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+// Write implements io.Writer.
+func (rw *redactWriter) Write(b []byte) (int, error) {
+	_, err := io.WriteString(rw.w, Redact(string(b)))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
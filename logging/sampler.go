@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// SampleBurst is the number of messages with the same key we log within
+	// each sampling interval before we start suppressing them.
+	// Set according to the MALWARE_SCANNER_LOG_SAMPLE_BURST env var.
+	SampleBurst = 10
+	// SampleInterval is the length of the sampling interval. At the end of
+	// each interval we log a summary of the suppressed messages.
+	// Set according to the MALWARE_SCANNER_LOG_SAMPLE_INTERVAL env var.
+	SampleInterval = time.Minute
+)
+
+type (
+	// Sampler rate-limits repetitive log messages, e.g. the same error
+	// repeated thousands of times per minute while the portal is down. Within
+	// each interval it logs the first messages with the same key and
+	// suppresses the rest. At the end of the interval it logs how many
+	// messages were suppressed.
+	Sampler struct {
+		counts map[string]*sampleCount
+
+		staticBurst    int
+		staticInterval time.Duration
+		staticLogger   *logrus.Logger
+		mu             sync.Mutex
+	}
+
+	// sampleCount tracks the messages with a given key within the current
+	// interval.
+	sampleCount struct {
+		level      logrus.Level
+		logged     int
+		suppressed int
+		last       string
+	}
+)
+
+// NewSampler returns a new Sampler with the package's burst and interval
+// settings. It logs its summaries until the context is cancelled.
+func NewSampler(ctx context.Context, logger *logrus.Logger) (*Sampler, error) {
+	if ctx == nil {
+		return nil, errors.New("invalid context provided")
+	}
+	if logger == nil {
+		return nil, errors.New("invalid logger provided")
+	}
+	if SampleInterval <= 0 {
+		return nil, errors.New("invalid sampling interval")
+	}
+	s := &Sampler{
+		counts:         make(map[string]*sampleCount),
+		staticBurst:    SampleBurst,
+		staticInterval: SampleInterval,
+		staticLogger:   logger,
+	}
+	go s.threadedSummarize(ctx)
+	return s, nil
+}
+
+// Logf logs the formatted message at the given level, unless too many messages
+// with the same key were already logged within the current interval.
+func (s *Sampler) Logf(level logrus.Level, key, format string, args ...interface{}) {
+	if !s.staticLogger.IsLevelEnabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	s.mu.Lock()
+	c, ok := s.counts[key]
+	if !ok {
+		c = &sampleCount{level: level}
+		s.counts[key] = c
+	}
+	if c.logged >= s.staticBurst {
+		c.suppressed++
+		c.last = msg
+		s.mu.Unlock()
+		return
+	}
+	c.logged++
+	s.mu.Unlock()
+	s.staticLogger.Log(level, msg)
+}
+
+// Debugf logs a sampled message at debug level.
+func (s *Sampler) Debugf(key, format string, args ...interface{}) {
+	s.Logf(logrus.DebugLevel, key, format, args...)
+}
+
+// Infof logs a sampled message at info level.
+func (s *Sampler) Infof(key, format string, args ...interface{}) {
+	s.Logf(logrus.InfoLevel, key, format, args...)
+}
+
+// Warnf logs a sampled message at warn level.
+func (s *Sampler) Warnf(key, format string, args ...interface{}) {
+	s.Logf(logrus.WarnLevel, key, format, args...)
+}
+
+// flush logs a summary line for each key with suppressed messages and starts
+// a new interval.
+func (s *Sampler) flush() {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = make(map[string]*sampleCount)
+	s.mu.Unlock()
+
+	keys := make([]string, 0, len(counts))
+	for k, c := range counts {
+		if c.suppressed > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c := counts[k]
+		s.staticLogger.Logf(c.level, "Suppressed %d more '%s' messages in the last %s. Last one: %s", c.suppressed, k, s.staticInterval, c.last)
+	}
+}
+
+// threadedSummarize flushes the sampler at the end of each interval.
+func (s *Sampler) threadedSummarize(ctx context.Context) {
+	ticker := time.NewTicker(s.staticInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush()
+			return
+		case <-ticker.C:
+		}
+		s.flush()
+	}
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestSampler ensures Sampler suppresses messages over the burst and
+// summarizes them at the end of the interval.
+func TestSampler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetLevel(logrus.DebugLevel)
+
+	s, err := NewSampler(ctx, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < SampleBurst+5; i++ {
+		s.Warnf("portal_down", "portal is down, attempt %d", i)
+	}
+	s.Warnf("other", "a different message")
+
+	out := buf.String()
+	if n := strings.Count(out, "portal is down"); n != SampleBurst {
+		t.Fatalf("Expected %d messages, got %d", SampleBurst, n)
+	}
+	if !strings.Contains(out, "a different message") {
+		t.Fatal("Expected messages with other keys to be logged")
+	}
+
+	buf.Reset()
+	s.flush()
+	out = buf.String()
+	if !strings.Contains(out, "Suppressed 5 more 'portal_down' messages") || !strings.Contains(out, "attempt 14") {
+		t.Fatalf("Unexpected summary '%s'", out)
+	}
+	if strings.Contains(out, "'other'") {
+		t.Fatalf("Expected no summary for keys without suppressed messages, got '%s'", out)
+	}
+
+	// A new interval starts after the flush.
+	buf.Reset()
+	s.Warnf("portal_down", "portal is down again")
+	if !strings.Contains(buf.String(), "portal is down again") {
+		t.Fatal("Expected the message to be logged in the new interval")
+	}
+}
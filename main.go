@@ -4,12 +4,27 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/SkynetLabs/malware-scanner/api"
+	"github.com/SkynetLabs/malware-scanner/archive"
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/blocklist"
 	"github.com/SkynetLabs/malware-scanner/clamav"
 	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/federation"
+	"github.com/SkynetLabs/malware-scanner/intel"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"github.com/SkynetLabs/malware-scanner/metrics"
+	"github.com/SkynetLabs/malware-scanner/mq"
+	"github.com/SkynetLabs/malware-scanner/notify"
+	"github.com/SkynetLabs/malware-scanner/resolver"
 	"github.com/SkynetLabs/malware-scanner/scanner"
 	accdb "github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/joho/godotenv"
@@ -37,6 +52,155 @@ func loadDBCredentials() (accdb.DBCredentials, error) {
 	return cds, nil
 }
 
+// envDuration parses the duration stored in the given environment variable. If
+// the variable is not set it returns the given default value.
+func envDuration(name string, def time.Duration) time.Duration {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatal(errors.AddContext(err, fmt.Sprintf("invalid value of env var %s", name)))
+	}
+	return d
+}
+
+// envFloat parses the float stored in the given environment variable. If the
+// variable is not set it returns the given default value.
+func envFloat(name string, def float64) float64 {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		log.Fatal(errors.AddContext(err, fmt.Sprintf("invalid value of env var %s", name)))
+	}
+	return f
+}
+
+// envInt parses the integer stored in the given environment variable. If the
+// variable is not set it returns the given default value.
+func envInt(name string, def int) int {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return def
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatal(errors.AddContext(err, fmt.Sprintf("invalid value of env var %s", name)))
+	}
+	return i
+}
+
+// runOnce scans the queued skylinks one after the other until the queue is
+// empty and then reports the infected ones to blocker. It exits with an error
+// status if anything fails, leaving the rest of the queue for the next run.
+func runOnce(scan *scanner.Scanner, logger *logrus.Logger) {
+	scanned := 0
+	for {
+		err := scan.RunOnce()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
+		}
+		if err != nil {
+			log.Fatal(errors.AddContext(err, fmt.Sprintf("scanning failed after %d sweeps", scanned)))
+		}
+		scanned++
+	}
+	n, err := scan.ReportOnce()
+	if err != nil {
+		log.Fatal(errors.AddContext(err, fmt.Sprintf("reporting failed after %d reported skylinks", n)))
+	}
+	logger.Infof("Scanned the queue in %d sweeps and reported %d infected skylinks", scanned, n)
+}
+
+// loadNotifier builds a notifier which delivers notifications to all
+// destinations configured in the environment variables with the given prefix,
+// e.g. MALWARE_SCANNER_ALERT. It returns nil if none are configured.
+func loadNotifier(prefix string) notify.Notifier {
+	var n notify.Multi
+	if url := os.Getenv(prefix + "_WEBHOOK_URL"); url != "" {
+		n = append(n, notify.Webhook{URL: url})
+	}
+	if url := os.Getenv(prefix + "_SLACK_URL"); url != "" {
+		n = append(n, notify.Slack{WebhookURL: url})
+	}
+	if host := os.Getenv(prefix + "_SMTP_HOST"); host != "" {
+		port := os.Getenv(prefix + "_SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		var to []string
+		for _, addr := range strings.Split(os.Getenv(prefix+"_SMTP_TO"), ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				to = append(to, addr)
+			}
+		}
+		if len(to) == 0 {
+			log.Fatal(errors.New("missing env var " + prefix + "_SMTP_TO"))
+		}
+		n = append(n, notify.Email{
+			Host:     host,
+			Port:     port,
+			User:     os.Getenv(prefix + "_SMTP_USER"),
+			Password: os.Getenv(prefix + "_SMTP_PASS"),
+			From:     os.Getenv(prefix + "_SMTP_FROM"),
+			To:       to,
+		})
+	}
+	if len(n) == 0 {
+		return nil
+	}
+	return n
+}
+
+// envSecrets returns the secrets held by env vars, so they can be redacted
+// from log output. Lists of keys and headers are split into their values.
+func envSecrets() []string {
+	var secrets []string
+	for _, name := range []string{
+		"SKYNET_DB_PASS",
+		"SIA_API_PASSWORD",
+		"MALWARE_SCANNER_UNPIN_API_PASSWORD",
+		"MALWARE_SCANNER_ALERT_SMTP_PASS",
+		"MALWARE_SCANNER_ALERT_WEBHOOK_URL",
+		"MALWARE_SCANNER_ALERT_SLACK_URL",
+		"MALWARE_SCANNER_DIGEST_SMTP_PASS",
+		"MALWARE_SCANNER_DIGEST_WEBHOOK_URL",
+		"MALWARE_SCANNER_DIGEST_SLACK_URL",
+		"MALWARE_SCANNER_BLOCKER_TOKEN",
+		"MALWARE_SCANNER_BLOCKER_SIGNING_SECRET",
+		"MALWARE_SCANNER_INTEL_MISP_KEY",
+		"MALWARE_SCANNER_INTEL_TAXII_AUTH",
+		"MALWARE_SCANNER_ARCHIVE_S3_SECRET_KEY",
+		"MALWARE_SCANNER_FEDERATION_PEER_KEY",
+		"MALWARE_SCANNER_UPLOAD_HOOK_TOKEN",
+		"MALWARE_SCANNER_SIGNATURE_HOOK_TOKEN",
+		"MALWARE_SCANNER_HOOK_SIGNING_SECRET",
+		"MALWARE_SCANNER_ACCOUNTS_COOKIE_HASH_KEY",
+		"MALWARE_SCANNER_ACCOUNTS_COOKIE_ENC_KEY",
+		"MALWARE_SCANNER_DB_ENCRYPTION_KEY",
+	} {
+		secrets = append(secrets, os.Getenv(name))
+	}
+	// Keys are listed as `name:key` and headers as `Name: value`.
+	for _, name := range []string{
+		"MALWARE_SCANNER_ADMIN_KEYS",
+		"MALWARE_SCANNER_FEDERATION_KEYS",
+		"MALWARE_SCANNER_BLOCKER_HEADERS",
+		"MALWARE_SCANNER_PORTAL_METADATA_HEADERS",
+	} {
+		for _, pair := range strings.Split(os.Getenv(name), ",") {
+			if i := strings.Index(pair, ":"); i >= 0 {
+				secrets = append(secrets, strings.TrimSpace(pair[i+1:]))
+			}
+		}
+	}
+	return secrets
+}
+
 func main() {
 	// Load the environment variables from the .env file.
 	// Existing variables take precedence and won't be overwritten.
@@ -53,6 +217,13 @@ func main() {
 	}
 	logger.SetLevel(logLevel)
 
+	// Redact secrets from all log output, including the fatal errors we log
+	// through the standard library's logger.
+	logging.PrivacyMode = envInt("MALWARE_SCANNER_PRIVACY_MODE", 0) != 0
+	logging.AddSecrets(envSecrets()...)
+	logger.AddHook(logging.NewRedactHook())
+	log.SetOutput(logging.NewRedactWriter(os.Stderr))
+
 	// portalAddr tells us which Skynet portal to use for downloading skylinks.
 	portal := os.Getenv("PORTAL_DOMAIN")
 	if portal == "" {
@@ -65,6 +236,84 @@ func main() {
 	if !strings.HasPrefix(portal, "http") {
 		portal = "https://" + portal
 	}
+	// Additional portals we fall back to, in order, when downloading from the
+	// main one fails.
+	portals := []string{portal}
+	for _, p := range strings.Split(os.Getenv("PORTAL_FAILOVER_DOMAINS"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "http") {
+			p = "https://" + p
+		}
+		portals = append(portals, p)
+	}
+
+	// Resolve the portals' and blocker's names via a Handshake-aware DNS
+	// resolver, if configured.
+	var res *resolver.Resolver
+	if server := os.Getenv("MALWARE_SCANNER_HNS_RESOLVER"); server != "" {
+		res, err = resolver.New(server)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_HNS_RESOLVER"))
+		}
+		for _, p := range portals {
+			if _, err = res.Add(ctx, p); err != nil {
+				log.Fatal(errors.AddContext(err, "failed to resolve portal"))
+			}
+		}
+		clamav.Dial = res.DialContext
+	}
+
+	// Share keep-alive connections between all portal requests.
+	clamav.PortalMaxIdleConnsPerHost = envInt("MALWARE_SCANNER_PORTAL_MAX_IDLE_CONNS", clamav.PortalMaxIdleConnsPerHost)
+	clamav.PortalResponseTimeout = envDuration("MALWARE_SCANNER_PORTAL_RESPONSE_TIMEOUT", clamav.PortalResponseTimeout)
+	clamav.PortalReadTimeout = envDuration("MALWARE_SCANNER_PORTAL_READ_TIMEOUT", clamav.PortalReadTimeout)
+	clamav.RateLimitBackoff = envDuration("MALWARE_SCANNER_RATE_LIMIT_BACKOFF", clamav.RateLimitBackoff)
+	clamav.RateLimitMaxBackoff = envDuration("MALWARE_SCANNER_RATE_LIMIT_MAX_BACKOFF", clamav.RateLimitMaxBackoff)
+	clamav.PortalCompression = envInt("MALWARE_SCANNER_PORTAL_COMPRESSION", 0) != 0
+	clamav.MaxDecompressionRatio = uint64(envInt("MALWARE_SCANNER_MAX_DECOMPRESSION_RATIO", int(clamav.MaxDecompressionRatio)))
+	clamav.PortalMaxHeaderBytes = int64(envInt("MALWARE_SCANNER_PORTAL_MAX_HEADER_BYTES", int(clamav.PortalMaxHeaderBytes)))
+	clamav.PortalMaxRedirects = envInt("MALWARE_SCANNER_PORTAL_MAX_REDIRECTS", clamav.PortalMaxRedirects)
+	clamav.PortalAllowPrivate = envInt("MALWARE_SCANNER_PORTAL_ALLOW_PRIVATE", 0) != 0
+	err = clamav.SetPortalTLS(os.Getenv("MALWARE_SCANNER_PORTAL_CA"), envInt("MALWARE_SCANNER_PORTAL_INSECURE_SKIP_VERIFY", 0) != 0)
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid portal TLS configuration"))
+	}
+	if err = clamav.PinPortalHosts(portals...); err != nil {
+		log.Fatal(err)
+	}
+	database.PortalClient = clamav.PortalClient()
+
+	// The SLA is only used for reporting, so we don't require it.
+	database.SLATarget = envDuration("MALWARE_SCANNER_SLA", database.SLATarget)
+
+	// Settings of the infection rate anomaly detection.
+	database.AnomalyWindow = envDuration("MALWARE_SCANNER_ANOMALY_WINDOW", database.AnomalyWindow)
+	database.AnomalyBaseline = envDuration("MALWARE_SCANNER_ANOMALY_BASELINE", database.AnomalyBaseline)
+	database.AnomalyThreshold = envFloat("MALWARE_SCANNER_ANOMALY_THRESHOLD", database.AnomalyThreshold)
+	database.AnomalyMinScans = int64(envInt("MALWARE_SCANNER_ANOMALY_MIN_SCANS", int(database.AnomalyMinScans)))
+
+	// Encrypt the skylinks and infection descriptions we store, if there's a
+	// key. The key file is meant for keys a KMS or secret store provides.
+	encKey := os.Getenv("MALWARE_SCANNER_DB_ENCRYPTION_KEY")
+	if keyFile := os.Getenv("MALWARE_SCANNER_DB_ENCRYPTION_KEY_FILE"); keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to read MALWARE_SCANNER_DB_ENCRYPTION_KEY_FILE"))
+		}
+		encKey = string(b)
+		logging.AddSecrets(strings.TrimSpace(encKey))
+	}
+	if encKey != "" {
+		key, err := database.ParseEncryptionKey(encKey)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_DB_ENCRYPTION_KEY"))
+		}
+		if err = database.SetEncryptionKey(key); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Initialised the database connection.
 	dbCreds, err := loadDBCredentials()
@@ -76,6 +325,50 @@ func main() {
 		log.Fatal(errors.AddContext(err, "failed to connect to the db"))
 	}
 
+	// Cache v2 skylink resolutions, optionally in the DB as well.
+	database.V2CacheTTL = envDuration("MALWARE_SCANNER_V2_CACHE_TTL", database.V2CacheTTL)
+	database.V2CacheSize = envInt("MALWARE_SCANNER_V2_CACHE_SIZE", database.V2CacheSize)
+	database.MaxV2ResolutionDepth = envInt("MALWARE_SCANNER_MAX_V2_RESOLUTION_DEPTH", database.MaxV2ResolutionDepth)
+	if envInt("MALWARE_SCANNER_V2_CACHE_DB", 0) != 0 {
+		db.CacheV2Resolutions()
+	}
+
+	// Cache the verdicts of our scans.
+	database.VerdictCacheTTL = envDuration("MALWARE_SCANNER_VERDICT_CACHE_TTL", database.VerdictCacheTTL)
+	database.VerdictCacheSize = envInt("MALWARE_SCANNER_VERDICT_CACHE_SIZE", database.VerdictCacheSize)
+
+	// Download large files in parallel ranges.
+	clamav.ParallelDownloadThreshold = uint64(envInt("MALWARE_SCANNER_PARALLEL_DOWNLOAD_THRESHOLD", int(clamav.ParallelDownloadThreshold)))
+	clamav.ParallelDownloads = envInt("MALWARE_SCANNER_PARALLEL_DOWNLOADS", clamav.ParallelDownloads)
+	clamav.ParallelDownloadRangeSize = uint64(envInt("MALWARE_SCANNER_PARALLEL_DOWNLOAD_RANGE_SIZE", int(clamav.ParallelDownloadRangeSize)))
+
+	// Download content ahead of clamd while scanning.
+	clamav.PipelineBufferSize = envInt("MALWARE_SCANNER_PIPELINE_BUFFER_SIZE", clamav.PipelineBufferSize)
+	clamav.PipelineBuffers = envInt("MALWARE_SCANNER_PIPELINE_BUFFERS", clamav.PipelineBuffers)
+
+	// Batch the scans of small files.
+	clamav.BatchFileSize = uint64(envInt("MALWARE_SCANNER_BATCH_FILE_SIZE", int(clamav.BatchFileSize)))
+	scanner.ScanBatchSize = envInt("MALWARE_SCANNER_SCAN_BATCH_SIZE", scanner.ScanBatchSize)
+
+	// Lock the next skylink and start its download while scanning.
+	scanner.Prefetch = envInt("MALWARE_SCANNER_PREFETCH", 0) != 0
+
+	// Adapt the number of concurrent scans to the load of clamd and the
+	// portal.
+	scanner.MaxConcurrentScans = envInt("MALWARE_SCANNER_MAX_CONCURRENT_SCANS", scanner.MaxConcurrentScans)
+	scanner.ClamdLatencyTarget = envDuration("MALWARE_SCANNER_CLAMD_LATENCY_TARGET", scanner.ClamdLatencyTarget)
+	scanner.PortalErrorRateTarget = envFloat("MALWARE_SCANNER_PORTAL_ERROR_RATE_TARGET", scanner.PortalErrorRateTarget)
+	scanner.DrainThreshold = int64(envInt("MALWARE_SCANNER_DRAIN_THRESHOLD", int(scanner.DrainThreshold)))
+	scanner.DrainMaxConcurrentScans = envInt("MALWARE_SCANNER_DRAIN_MAX_CONCURRENT_SCANS", scanner.DrainMaxConcurrentScans)
+
+	// Bound the memory all scans together hold.
+	clamav.MemoryBudget = int64(envInt("MALWARE_SCANNER_MEMORY_BUDGET", int(clamav.MemoryBudget)))
+
+	// Reuse clamd sessions across scans.
+	clamav.ClamdSessions = envInt("MALWARE_SCANNER_CLAMD_SESSIONS", clamav.ClamdSessions)
+	clamav.ClamdSessionIdleTimeout = envDuration("MALWARE_SCANNER_CLAMD_SESSION_IDLE_TIMEOUT", clamav.ClamdSessionIdleTimeout)
+	clamav.ClamdChunkSize = envInt("MALWARE_SCANNER_CLAMD_CHUNK_SIZE", clamav.ClamdChunkSize)
+
 	// Connect to ClamAV.
 	clamIP := os.Getenv("CLAMAV_IP")
 	if clamIP == "" {
@@ -85,36 +378,432 @@ func main() {
 	if clamPort == "" {
 		log.Fatal(errors.New("missing CLAMAV_PORT environment variable - cannot connect to ClamAV"))
 	}
-	clam, err := clamav.New(clamIP, clamPort, portal)
+	clam, err := clamav.New(clamIP, clamPort, portals...)
 	if err != nil {
 		log.Fatal(errors.AddContext(err, fmt.Sprintf("cannot connect to ClamAV on %s:%s", clamIP, clamPort)))
 	}
+	defer func() { _ = clam.Close() }()
+	// Measure which INSTREAM chunk size works best with this clamd before we
+	// start scanning.
+	if envInt("MALWARE_SCANNER_CLAMD_CHUNK_CALIBRATION", 0) != 0 {
+		best, results, err := clam.CalibrateChunkSize(nil)
+		if err != nil {
+			log.Println(errors.AddContext(err, "failed to calibrate the clamd chunk size, keeping the configured one"))
+		} else {
+			for _, r := range results {
+				log.Printf("clamd chunk size %d: %.1f MB/s", r.ChunkSize, r.Throughput/1e6)
+			}
+			log.Printf("using a clamd chunk size of %d bytes", best)
+			clamav.ClamdChunkSize = best
+		}
+	}
+
+	// Connect to Blocker. MALWARE_SCANNER_BLOCKER_TARGETS lists all blocker
+	// instances we report to. Without it, we report to a single one.
+	blockerHeaders, err := blocker.ParseHeaders(os.Getenv("MALWARE_SCANNER_BLOCKER_HEADERS"))
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_BLOCKER_HEADERS"))
+	}
+	scanner.BlockerReportWorkers = envInt("MALWARE_SCANNER_BLOCKER_REPORT_WORKERS", scanner.BlockerReportWorkers)
+	blockerOpts := blocker.Options{
+		Headers:       blockerHeaders,
+		Timeout:       envDuration("MALWARE_SCANNER_BLOCKER_TIMEOUT", 30*time.Second),
+		Token:         os.Getenv("MALWARE_SCANNER_BLOCKER_TOKEN"),
+		TokenHeader:   os.Getenv("MALWARE_SCANNER_BLOCKER_TOKEN_HEADER"),
+		SigningSecret: []byte(os.Getenv("MALWARE_SCANNER_BLOCKER_SIGNING_SECRET")),
+	}
+	if res != nil {
+		blockerOpts.HTTPClient = &http.Client{Transport: res.Transport()}
+	}
+	blockerCert := os.Getenv("MALWARE_SCANNER_BLOCKER_CERT")
+	blockerKey := os.Getenv("MALWARE_SCANNER_BLOCKER_KEY")
+	blockerCA := os.Getenv("MALWARE_SCANNER_BLOCKER_CA")
+	if blockerCert != "" || blockerKey != "" || blockerCA != "" {
+		blockerOpts.TLSConfig, err = blocker.TLSConfig(blockerCert, blockerKey, blockerCA)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid blocker TLS configuration"))
+		}
+	}
+	blockers, err := blocker.ParseTargets(os.Getenv("MALWARE_SCANNER_BLOCKER_TARGETS"), blockerOpts)
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_BLOCKER_TARGETS"))
+	}
+	if len(blockers) == 0 {
+		blockerURL := os.Getenv("MALWARE_SCANNER_BLOCKER_URL")
+		if blockerURL == "" {
+			blockerIP := os.Getenv("BLOCKER_IP")
+			if blockerIP == "" {
+				log.Fatal(errors.New("missing BLOCKER_IP environment variable - cannot connect to Blocker"))
+			}
+			blockerPort := os.Getenv("BLOCKER_PORT")
+			if blockerPort == "" {
+				log.Fatal(errors.New("missing BLOCKER_PORT environment variable - cannot connect to Blocker"))
+			}
+			scheme := "http://"
+			if blockerOpts.TLSConfig != nil {
+				scheme = "https://"
+			}
+			blockerURL = scheme + net.JoinHostPort(blockerIP, blockerPort)
+		}
+		bc, err := blocker.New(blockerURL, blockerOpts)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "cannot connect to Blocker"))
+		}
+		blockers = append(blockers, bc)
+	}
+
+	if res != nil {
+		for _, bc := range blockers {
+			if _, err = res.Add(ctx, bc.BaseURL()); err != nil {
+				log.Fatal(errors.AddContext(err, "failed to resolve blocker"))
+			}
+		}
+		err = res.Start(ctx, envDuration("MALWARE_SCANNER_HNS_REFRESH_INTERVAL", 5*time.Minute), logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start re-resolving names"))
+		}
+	}
+
+	// Connect to the message queue, if configured.
+	var nc *mq.NATS
+	if url := os.Getenv("MALWARE_SCANNER_NATS_URL"); url != "" {
+		nc, err = mq.Connect(url)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to connect to the message queue"))
+		}
+		defer nc.Close()
+	}
 
-	// Connect to Blocker.
-	scanner.BlockerIP = os.Getenv("BLOCKER_IP")
-	if scanner.BlockerIP == "" {
-		log.Fatal(errors.New("missing BLOCKER_IP environment variable - cannot connect to Blocker"))
+	// Initialise the structured event stream. Events are only delivered if a
+	// sink is configured.
+	var sinks events.MultiSink
+	if desc := os.Getenv("MALWARE_SCANNER_EVENTS_SINK"); desc != "" {
+		sink, err := events.NewSink(desc)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to initialise the events sink"))
+		}
+		sinks = append(sinks, sink)
+	}
+	// Send infection events to a SIEM over syslog, if configured.
+	if addr := os.Getenv("MALWARE_SCANNER_SIEM_SYSLOG_ADDR"); addr != "" {
+		format := os.Getenv("MALWARE_SCANNER_SIEM_FORMAT")
+		if format == "" {
+			format = events.FormatCEF
+		}
+		sink, err := events.NewSyslogSink(addr, format, os.Getenv("MALWARE_SCANNER_SIEM_TLS_CA"))
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to initialise the SIEM sink"))
+		}
+		sinks = append(sinks, sink)
+	}
+	// Publish the verdicts of completed scans to the message queue, if
+	// configured.
+	if subject := os.Getenv("MALWARE_SCANNER_NATS_VERDICT_SUBJECT"); subject != "" && nc != nil {
+		sink, err := mq.NewVerdictSink(nc, subject)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to initialise the verdict publisher"))
+		}
+		sinks = append(sinks, sink)
 	}
-	scanner.BlockerPort = os.Getenv("BLOCKER_PORT")
-	if scanner.BlockerPort == "" {
-		log.Fatal(errors.New("missing BLOCKER_PORT environment variable - cannot connect to Blocker"))
+	var sink events.Sink
+	if len(sinks) > 0 {
+		sink = sinks
+	}
+	ev, err := events.NewEmitter(ctx, sink, logger)
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "failed to instantiate the events emitter"))
+	}
+
+	// Settings for rate-limiting repetitive log messages.
+	logging.SampleBurst = envInt("MALWARE_SCANNER_LOG_SAMPLE_BURST", logging.SampleBurst)
+	logging.SampleInterval = envDuration("MALWARE_SCANNER_LOG_SAMPLE_INTERVAL", logging.SampleInterval)
+
+	// Thresholds above which we log scans as outliers.
+	scanner.SlowScanThreshold = envDuration("MALWARE_SCANNER_SLOW_SCAN_THRESHOLD", scanner.SlowScanThreshold)
+	scanner.AccountsDB = os.Getenv("MALWARE_SCANNER_ACCOUNTS_DB")
+	scanner.RescanLookback = envDuration("MALWARE_SCANNER_RESCAN_LOOKBACK", 0)
+	scanner.DryRun = envInt("MALWARE_SCANNER_DRY_RUN", 0) != 0
+	scanner.DigestInterval = envDuration("MALWARE_SCANNER_DIGEST_INTERVAL", scanner.DigestInterval)
+	scanner.RetentionInfected = envDuration("MALWARE_SCANNER_RETENTION_INFECTED", 0)
+	scanner.RetentionClean = envDuration("MALWARE_SCANNER_RETENTION_CLEAN", 0)
+	scanner.RetentionFailed = envDuration("MALWARE_SCANNER_RETENTION_FAILED", 0)
+	scanner.RetentionInterval = envDuration("MALWARE_SCANNER_RETENTION_INTERVAL", scanner.RetentionInterval)
+	scanner.GoneConfirmations = envInt("MALWARE_SCANNER_GONE_CONFIRMATIONS", scanner.GoneConfirmations)
+	scanner.LargeFileThreshold = uint64(envInt("MALWARE_SCANNER_LARGE_FILE_THRESHOLD", int(scanner.LargeFileThreshold)))
+
+	// Push the metrics to an external system, if configured, for deployments
+	// which don't scrape /metrics.
+	if url := os.Getenv("MALWARE_SCANNER_METRICS_PUSH_URL"); url != "" {
+		pusher, err := metrics.NewPusher(url)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_METRICS_PUSH_URL"))
+		}
+		interval := envDuration("MALWARE_SCANNER_METRICS_PUSH_INTERVAL", 15*time.Second)
+		err = metrics.StartPush(ctx, pusher, metrics.DefaultRegistry, interval, logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start pushing metrics"))
+		}
+	}
+
+	// Share the indicators of infected content with threat intelligence
+	// platforms, if configured.
+	var exporters []intel.Exporter
+	if url := os.Getenv("MALWARE_SCANNER_INTEL_MISP_URL"); url != "" {
+		e, err := intel.NewMISPExporter(url, os.Getenv("MALWARE_SCANNER_INTEL_MISP_KEY"))
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MISP configuration"))
+		}
+		exporters = append(exporters, e)
+	}
+	if url := os.Getenv("MALWARE_SCANNER_INTEL_TAXII_URL"); url != "" {
+		e, err := intel.NewTAXIIExporter(url, os.Getenv("MALWARE_SCANNER_INTEL_TAXII_AUTH"))
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid TAXII configuration"))
+		}
+		exporters = append(exporters, e)
+	}
+	for _, e := range exporters {
+		interval := envDuration("MALWARE_SCANNER_INTEL_INTERVAL", time.Hour)
+		lookback := envDuration("MALWARE_SCANNER_INTEL_LOOKBACK", 24*time.Hour)
+		err = intel.Start(ctx, db, e, interval, lookback, logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start exporting indicators"))
+		}
+	}
+
+	// Archive the scan results to an S3-compatible bucket, if configured.
+	if endpoint := os.Getenv("MALWARE_SCANNER_ARCHIVE_S3_ENDPOINT"); endpoint != "" {
+		u, err := archive.NewS3Uploader(archive.S3Options{
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("MALWARE_SCANNER_ARCHIVE_S3_BUCKET"),
+			Region:    os.Getenv("MALWARE_SCANNER_ARCHIVE_S3_REGION"),
+			AccessKey: os.Getenv("MALWARE_SCANNER_ARCHIVE_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("MALWARE_SCANNER_ARCHIVE_S3_SECRET_KEY"),
+		})
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid S3 archive configuration"))
+		}
+		prefix := os.Getenv("MALWARE_SCANNER_ARCHIVE_S3_PREFIX")
+		lookback := envDuration("MALWARE_SCANNER_ARCHIVE_LOOKBACK", 24*time.Hour)
+		err = archive.Start(ctx, db, u, prefix, lookback, logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start archiving scan results"))
+		}
+	}
+
+	// Mark queued skylinks which are on external blocklists as infected
+	// without scanning them, if configured.
+	if urls := os.Getenv("MALWARE_SCANNER_BLOCKLIST_URLS"); urls != "" {
+		sources, err := blocklist.ParseSources(urls)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_BLOCKLIST_URLS"))
+		}
+		interval := envDuration("MALWARE_SCANNER_BLOCKLIST_INTERVAL", time.Hour)
+		err = blocklist.Start(ctx, db, sources, interval, logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start importing blocklists"))
+		}
+	}
+
+	// Reuse the verdicts of federated scanner instances, if configured.
+	if peersStr := os.Getenv("MALWARE_SCANNER_FEDERATION_PEERS"); peersStr != "" {
+		peers, err := federation.ParsePeers(peersStr, os.Getenv("MALWARE_SCANNER_FEDERATION_PEER_KEY"))
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_FEDERATION_PEERS"))
+		}
+		interval := envDuration("MALWARE_SCANNER_FEDERATION_INTERVAL", time.Minute)
+		lookback := envDuration("MALWARE_SCANNER_FEDERATION_LOOKBACK", 7*24*time.Hour)
+		err = federation.Start(ctx, db, peers, interval, lookback, logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start pulling peer verdicts"))
+		}
+		scanner.Federated = true
+	}
+
+	// Unpin blocked skylinks from the portal, if configured.
+	var unpinner *scanner.Unpinner
+	if urls := os.Getenv("MALWARE_SCANNER_UNPIN_SKYD_URLS"); urls != "" {
+		password := os.Getenv("MALWARE_SCANNER_UNPIN_API_PASSWORD")
+		if password == "" {
+			password = os.Getenv("SIA_API_PASSWORD")
+		}
+		unpinner, err = scanner.NewUnpinner(strings.Split(urls, ","), password)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_UNPIN_SKYD_URLS"))
+		}
 	}
 
 	// Initialise and start the background scanner task.
-	scan, err := scanner.New(ctx, db, clam, logger)
+	scan, err := scanner.New(ctx, db, clam, blockers, unpinner, ev, logger)
 	if err != nil {
 		log.Fatal(errors.AddContext(err, "failed to instantiate scanner"))
 	}
+	// Replay recorded verdicts instead of scanning, if configured.
+	if path := os.Getenv("MALWARE_SCANNER_REPLAY_VERDICTS"); path != "" {
+		replay, err := scanner.LoadReplayFile(path)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_REPLAY_VERDICTS"))
+		}
+		err = scan.SetReplay(replay)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to replay recorded verdicts"))
+		}
+		logger.Warnf("Replaying %d recorded verdicts from %s instead of scanning", replay.Len(), path)
+	}
+	// Decide what happens to detections by a policy, if configured.
+	if path := os.Getenv("MALWARE_SCANNER_POLICY_FILE"); path != "" {
+		policy, err := scanner.LoadPolicyFile(path)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_POLICY_FILE"))
+		}
+		err = scan.SetPolicy(policy)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to set policy"))
+		}
+	}
+	// Scan the queue and report the infected skylinks once, then exit, if
+	// configured.
+	if envInt("MALWARE_SCANNER_RUN_ONCE", 0) != 0 {
+		runOnce(scan, logger)
+		return
+	}
 	scan.Start()
 	// Start the background thread that resets the status of scans that take
 	// too long and are considered stuck.
 	scan.StartUnlocker()
+	// Push the verdicts of scanned skylinks to the portal's metadata service,
+	// if configured.
+	if u := os.Getenv("MALWARE_SCANNER_PORTAL_METADATA_URL"); u != "" {
+		headers, err := blocker.ParseHeaders(os.Getenv("MALWARE_SCANNER_PORTAL_METADATA_HEADERS"))
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_PORTAL_METADATA_HEADERS"))
+		}
+		publisher, err := scanner.NewPortalPublisher(u, headers)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "invalid MALWARE_SCANNER_PORTAL_METADATA_URL"))
+		}
+		err = scan.StartPortalPush(publisher)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start pushing verdicts to the portal"))
+		}
+	}
+	// Start the background thread that re-scans recent clean skylinks when
+	// the signature hook reports a signature update, if enabled.
+	if scanner.RescanLookback > 0 {
+		err = scan.StartRescans()
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start re-scans"))
+		}
+	}
+	// Start the background thread that removes expired records, if any
+	// retention is configured.
+	if scanner.RetentionInfected > 0 || scanner.RetentionClean > 0 || scanner.RetentionFailed > 0 {
+		err = scan.StartRetention()
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start retention"))
+		}
+	}
+	// Start the background thread that alerts about critical conditions, if
+	// any alert destinations are configured.
+	if notifier := loadNotifier("MALWARE_SCANNER_ALERT"); notifier != nil {
+		alerter, err := notify.NewAlerter(notifier, envDuration("MALWARE_SCANNER_ALERT_REPEAT", time.Hour), logger)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to instantiate alerter"))
+		}
+		thresholds := scanner.AlertThresholds{
+			CheckInterval:     envDuration("MALWARE_SCANNER_ALERT_CHECK_INTERVAL", time.Minute),
+			QueueAge:          envDuration("MALWARE_SCANNER_ALERT_QUEUE_AGE", time.Hour),
+			ClamAVDown:        envDuration("MALWARE_SCANNER_ALERT_CLAMAV_DOWN", 5*time.Minute),
+			BlockerFailures:   envInt("MALWARE_SCANNER_ALERT_BLOCKER_FAILURES", 5),
+			InfectionRate:     envFloat("MALWARE_SCANNER_ALERT_INFECTION_RATE", 0.1),
+			InfectionWindow:   envDuration("MALWARE_SCANNER_ALERT_INFECTION_WINDOW", time.Hour),
+			InfectionMinScans: int64(envInt("MALWARE_SCANNER_ALERT_INFECTION_MIN_SCANS", 20)),
+			InfectionAnomaly:  envInt("MALWARE_SCANNER_ALERT_INFECTION_ANOMALY", 1) != 0,
+		}
+		err = scan.StartAlerts(alerter, thresholds)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start alerts"))
+		}
+	}
+
+	// Start the background thread that sends digests of the scanner's
+	// activity, if any digest destinations are configured.
+	if notifier := loadNotifier("MALWARE_SCANNER_DIGEST"); notifier != nil {
+		err = scan.StartDigests(notifier)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start digests"))
+		}
+	}
 
 	// Initialise the server.
-	server, err := api.New(db, clam, logger)
+	api.AdminKeys, err = api.ParseAdminKeys(os.Getenv("MALWARE_SCANNER_ADMIN_KEYS"))
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_ADMIN_KEYS"))
+	}
+	api.AccountsAdmins = api.ParseAccountsAdmins(os.Getenv("MALWARE_SCANNER_ACCOUNTS_ADMINS"))
+	if jwks := os.Getenv("MALWARE_SCANNER_ACCOUNTS_JWKS_FILE"); jwks != "" {
+		if err = api.LoadAccountsJWKS(jwks, logger); err != nil {
+			log.Fatal(err)
+		}
+		api.AccountsCookieHashKey = []byte(os.Getenv("MALWARE_SCANNER_ACCOUNTS_COOKIE_HASH_KEY"))
+		api.AccountsCookieEncKey = []byte(os.Getenv("MALWARE_SCANNER_ACCOUNTS_COOKIE_ENC_KEY"))
+	}
+	api.AdminAllowlist, err = api.ParseCIDRs(os.Getenv("MALWARE_SCANNER_ADMIN_ALLOWED_CIDRS"))
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_ADMIN_ALLOWED_CIDRS"))
+	}
+	api.SubmitAllowlist, err = api.ParseCIDRs(os.Getenv("MALWARE_SCANNER_SUBMIT_ALLOWED_CIDRS"))
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_SUBMIT_ALLOWED_CIDRS"))
+	}
+	api.SubmissionQuota = int64(envInt("MALWARE_SCANNER_SUBMISSION_QUOTA", 0))
+	api.SubmissionInvalidLimit = int64(envInt("MALWARE_SCANNER_SUBMISSION_INVALID_LIMIT", 0))
+	api.SubmissionBanDuration = envDuration("MALWARE_SCANNER_SUBMISSION_BAN_DURATION", api.SubmissionBanDuration)
+	api.AdminConfirmation, err = api.ParseConfirmationMode(os.Getenv("MALWARE_SCANNER_ADMIN_CONFIRMATION"))
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_ADMIN_CONFIRMATION"))
+	}
+	api.ConfirmationTTL = envDuration("MALWARE_SCANNER_ADMIN_CONFIRMATION_TTL", api.ConfirmationTTL)
+	api.UploadHookToken = os.Getenv("MALWARE_SCANNER_UPLOAD_HOOK_TOKEN")
+	api.SignatureHookToken = os.Getenv("MALWARE_SCANNER_SIGNATURE_HOOK_TOKEN")
+	api.HookSigningSecret = []byte(os.Getenv("MALWARE_SCANNER_HOOK_SIGNING_SECRET"))
+	api.GraphQLEnabled = envInt("MALWARE_SCANNER_GRAPHQL", 0) != 0
+	api.ImportEnabled = envInt("MALWARE_SCANNER_IMPORT", 0) != 0
+	api.FederationKeys, err = api.ParseAdminKeys(os.Getenv("MALWARE_SCANNER_FEDERATION_KEYS"))
+	if err != nil {
+		log.Fatal(errors.AddContext(err, "invalid env var MALWARE_SCANNER_FEDERATION_KEYS"))
+	}
+	server, err := api.New(db, clam, blockers, scan, ev, logger)
 	if err != nil {
 		log.Fatal(errors.AddContext(err, "failed to build the api"))
 	}
+	api.SignatureMaxAge = time.Duration(envInt("MALWARE_SCANNER_SIGNATURE_MAX_AGE_DAYS", 0)) * 24 * time.Hour
+	api.SignatureStaleUnready = envInt("MALWARE_SCANNER_SIGNATURE_STALE_UNREADY", 0) != 0
+	// Consume scan requests from the message queue, if configured.
+	if subject := os.Getenv("MALWARE_SCANNER_NATS_SCAN_SUBJECT"); subject != "" && nc != nil {
+		durable := os.Getenv("MALWARE_SCANNER_NATS_DURABLE")
+		if durable == "" {
+			durable = "malware-scanner"
+		}
+		err = server.StartQueueConsumer(ctx, nc, subject, durable)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start consuming scan requests"))
+		}
+	}
+	// Queue skylinks reported via the abuse-scanner, if configured.
+	if abuseDB := os.Getenv("MALWARE_SCANNER_ABUSE_DB"); abuseDB != "" {
+		collection := os.Getenv("MALWARE_SCANNER_ABUSE_COLLECTION")
+		if collection == "" {
+			collection = "emails"
+		}
+		interval := envDuration("MALWARE_SCANNER_ABUSE_POLL_INTERVAL", time.Minute)
+		lookback := envDuration("MALWARE_SCANNER_ABUSE_LOOKBACK", 24*time.Hour)
+		err = server.StartAbuseConsumer(ctx, abuseDB, collection, interval, lookback)
+		if err != nil {
+			log.Fatal(errors.AddContext(err, "failed to start consuming abuse reports"))
+		}
+	}
+	server.StartHealthMonitor(ctx, envDuration("MALWARE_SCANNER_HEALTH_CHECK_INTERVAL", 10*time.Second))
 
 	log.Fatal(server.ListenAndServe(4000))
 }
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// typeCounter is the Prometheus type of monotonically increasing values.
+	typeCounter = "counter"
+	// typeGauge is the Prometheus type of values that can go up and down.
+	typeGauge = "gauge"
+	// typeHistogram is the Prometheus type of bucketed observations.
+	typeHistogram = "histogram"
+)
+
+var (
+	// DefaultRegistry is the registry used by the package-level constructors.
+	// It's the one exposed on the service's /metrics endpoint.
+	DefaultRegistry = NewRegistry()
+)
+
+type (
+	// Label is a single name-value pair attached to a sample.
+	Label struct {
+		Name  string
+		Value string
+	}
+
+	// Sample is a single value of a metric at the time it was collected.
+	Sample struct {
+		Name   string
+		Labels []Label
+		Value  float64
+	}
+
+	// Registry holds a set of metric families and renders them in the
+	// Prometheus text exposition format.
+	Registry struct {
+		families []*family
+		names    map[string]struct{}
+		mu       sync.Mutex
+	}
+
+	// child is a single time series (or a group of them, for histograms)
+	// within a family.
+	child interface {
+		samples(name string, labels []Label) []Sample
+	}
+
+	// family is a metric name together with all of its labelled children.
+	family struct {
+		name       string
+		help       string
+		kind       string
+		labelNames []string
+		newChild   func() child
+
+		children map[string]child
+		values   map[string][]string
+		mu       sync.Mutex
+	}
+)
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]struct{}),
+	}
+}
+
+// register adds a new family to the registry. Registering the same name twice
+// is a developer error and panics.
+func (r *Registry) register(name, help, kind string, labelNames []string, newChild func() child) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.names[name]; exists {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		newChild:   newChild,
+		children:   make(map[string]child),
+		values:     make(map[string][]string),
+	}
+	r.names[name] = struct{}{}
+	r.families = append(r.families, f)
+	return f
+}
+
+// Samples returns the current value of all metrics in the registry, sorted by
+// name.
+func (r *Registry) Samples() []Sample {
+	var samples []Sample
+	for _, f := range r.sortedFamilies() {
+		samples = append(samples, f.samples()...)
+	}
+	return samples
+}
+
+// WritePrometheus writes all metrics in the registry to w in the Prometheus
+// text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	for _, f := range r.sortedFamilies() {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		if err != nil {
+			return err
+		}
+		for _, s := range f.samples() {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", s.Name, formatLabels(s.Labels), formatValue(s.Value))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortedFamilies returns a copy of the registry's families sorted by name.
+func (r *Registry) sortedFamilies() []*family {
+	r.mu.Lock()
+	fs := make([]*family, len(r.families))
+	copy(fs, r.families)
+	r.mu.Unlock()
+	sort.Slice(fs, func(i, j int) bool { return fs[i].name < fs[j].name })
+	return fs
+}
+
+// with returns the child with the given label values, creating it if needed.
+func (f *family) with(values ...string) child {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.children[key]
+	if !ok {
+		c = f.newChild()
+		f.children[key] = c
+		f.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// samples collects the samples of all children of the family.
+func (f *family) samples() []Sample {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.children))
+	for k := range f.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	children := make([]child, 0, len(keys))
+	labels := make([][]Label, 0, len(keys))
+	for _, k := range keys {
+		children = append(children, f.children[k])
+		ls := make([]Label, len(f.labelNames))
+		for i, n := range f.labelNames {
+			ls[i] = Label{Name: n, Value: f.values[k][i]}
+		}
+		labels = append(labels, ls)
+	}
+	f.mu.Unlock()
+
+	var samples []Sample
+	for i, c := range children {
+		samples = append(samples, c.samples(f.name, labels[i])...)
+	}
+	return samples
+}
+
+// escapeHelp escapes a help string as required by the exposition format.
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// formatLabels renders a set of labels as `{name="value",...}`.
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		v := strings.ReplaceAll(l.Value, `\`, `\\`)
+		v = strings.ReplaceAll(v, `"`, `\"`)
+		v = strings.ReplaceAll(v, "\n", `\n`)
+		parts[i] = fmt.Sprintf(`%s="%s"`, l.Name, v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatValue renders a sample value the way Prometheus expects it.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestWritePrometheus ensures the registry renders all metric types in the
+// Prometheus text exposition format.
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_counter_total", "A counter.")
+	cv := r.NewCounterVec("test_labelled_total", "A labelled counter.", "kind")
+	g := r.NewGauge("test_gauge", "A gauge.")
+	r.NewGaugeFunc("test_gauge_func", "A computed gauge.", func() float64 { return 42 })
+	h := r.NewHistogram("test_histogram", "A histogram.", []float64{1, 10})
+
+	c.Add(3)
+	c.Add(-1) // ignored
+	cv.With(`a"b`).Inc()
+	g.Set(5)
+	g.Dec()
+	h.Observe(0.5)
+	h.Observe(5)
+	h.Observe(50)
+
+	var buf bytes.Buffer
+	err := r.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	expected := []string{
+		"# TYPE test_counter_total counter\ntest_counter_total 3\n",
+		`test_labelled_total{kind="a\"b"} 1`,
+		"# TYPE test_gauge gauge\ntest_gauge 4\n",
+		"test_gauge_func 42\n",
+		`test_histogram_bucket{le="1"} 1`,
+		`test_histogram_bucket{le="10"} 2`,
+		`test_histogram_bucket{le="+Inf"} 3`,
+		"test_histogram_sum 55.5\n",
+		"test_histogram_count 3\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Fatalf("Expected output to contain '%s', got:\n%s", e, out)
+		}
+	}
+}
+
+// TestRegisterTwice ensures registering the same name twice panics.
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup", "")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic")
+		}
+	}()
+	r.NewGauge("dup", "")
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// maxStatsdPacketSize is the maximum size of a single statsd UDP packet.
+	// It keeps the packets below the typical MTU, so they don't get
+	// fragmented.
+	maxStatsdPacketSize = 1432
+)
+
+type (
+	// Pusher sends the current value of all metrics in a registry to an
+	// external system, for deployments which can't scrape /metrics.
+	Pusher interface {
+		Push(ctx context.Context, r *Registry) error
+	}
+
+	// StatsdPusher sends all samples as statsd gauges over UDP. Labels are
+	// sent as DogStatsD tags. Counters are sent with their absolute value, so
+	// they keep the same semantics as on /metrics.
+	StatsdPusher struct {
+		staticAddr string
+	}
+
+	// PushgatewayPusher sends all metrics in the Prometheus text format to a
+	// Prometheus Pushgateway.
+	PushgatewayPusher struct {
+		staticURL    string
+		staticClient *http.Client
+	}
+)
+
+// NewPusher creates a pusher from its URL. Supported URLs are
+// "statsd://host:port" and http(s) URLs of a Pushgateway's job endpoint, e.g.
+// "http://pushgateway:9091/metrics/job/malware-scanner".
+func NewPusher(url string) (Pusher, error) {
+	switch {
+	case strings.HasPrefix(url, "statsd://"):
+		addr := strings.TrimPrefix(url, "statsd://")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, errors.AddContext(err, "invalid statsd address")
+		}
+		return &StatsdPusher{staticAddr: addr}, nil
+	case strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://"):
+		return &PushgatewayPusher{
+			staticURL:    url,
+			staticClient: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, errors.New("unsupported metrics push URL: " + url)
+	}
+}
+
+// StartPush launches a background thread which pushes the metrics in the
+// registry every interval until the context is cancelled.
+func StartPush(ctx context.Context, p Pusher, r *Registry, interval time.Duration, logger *logrus.Logger) error {
+	if p == nil {
+		return errors.New("no pusher provided")
+	}
+	if interval <= 0 {
+		return errors.New("invalid push interval")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := p.Push(ctx, r)
+			if err != nil {
+				logger.Debugln(errors.AddContext(err, "failed to push metrics"))
+			}
+		}
+	}()
+	return nil
+}
+
+// Push implements Pusher.
+func (sp *StatsdPusher) Push(ctx context.Context, r *Registry) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", sp.staticAddr)
+	if err != nil {
+		return errors.AddContext(err, "failed to connect to statsd")
+	}
+	defer func() { _ = conn.Close() }()
+	var packet bytes.Buffer
+	for _, s := range r.Samples() {
+		line := formatStatsd(s)
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsdPacketSize {
+			if _, err = conn.Write(packet.Bytes()); err != nil {
+				return errors.AddContext(err, "failed to send metrics to statsd")
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if _, err = conn.Write(packet.Bytes()); err != nil {
+			return errors.AddContext(err, "failed to send metrics to statsd")
+		}
+	}
+	return nil
+}
+
+// Push implements Pusher.
+func (pp *PushgatewayPusher) Push(ctx context.Context, r *Registry) error {
+	var body bytes.Buffer
+	err := r.WritePrometheus(&body)
+	if err != nil {
+		return errors.AddContext(err, "failed to render metrics")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pp.staticURL, &body)
+	if err != nil {
+		return errors.AddContext(err, "failed to build pushgateway request")
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	res, err := pp.staticClient.Do(req)
+	if err != nil {
+		return errors.AddContext(err, "failed to call pushgateway")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return errors.New(fmt.Sprintf("pushgateway failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	return nil
+}
+
+// formatStatsd renders a sample as a statsd gauge with DogStatsD tags, e.g.
+// `name:1|g|#label:value`.
+func formatStatsd(s Sample) string {
+	line := fmt.Sprintf("%s:%s|g", s.Name, formatValue(s.Value))
+	if len(s.Labels) == 0 {
+		return line
+	}
+	tags := make([]string, len(s.Labels))
+	for i, l := range s.Labels {
+		// Commas separate tags and pipes separate the fields of the line, so
+		// neither can appear in a tag.
+		v := strings.NewReplacer(",", "_", "|", "_", "\n", "_").Replace(l.Value)
+		tags[i] = l.Name + ":" + v
+	}
+	return line + "|#" + strings.Join(tags, ",")
+}
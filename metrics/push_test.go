@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewPusher ensures we create the right pusher for each URL.
+func TestNewPusher(t *testing.T) {
+	p, err := NewPusher("statsd://127.0.0.1:8125")
+	if _, ok := p.(*StatsdPusher); err != nil || !ok {
+		t.Fatalf("Expected a statsd pusher, got %T, %v", p, err)
+	}
+	p, err = NewPusher("http://pushgateway:9091/metrics/job/test")
+	if _, ok := p.(*PushgatewayPusher); err != nil || !ok {
+		t.Fatalf("Expected a pushgateway pusher, got %T, %v", p, err)
+	}
+	for _, url := range []string{"statsd://nohost", "udp://127.0.0.1:8125", ""} {
+		if _, err = NewPusher(url); err == nil {
+			t.Fatalf("Expected an error for '%s'", url)
+		}
+	}
+}
+
+// TestStatsdPush ensures we send all samples as statsd gauges.
+func TestStatsdPush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	r := NewRegistry()
+	r.NewCounter("test_total", "").Add(3)
+	r.NewGaugeVec("test_gauge", "", "kind").With("a,b").Set(1.5)
+	p, err := NewPusher("statsd://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Push(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxStatsdPacketSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "test_gauge:1.5|g|#kind:a_b\ntest_total:3|g"
+	if string(buf[:n]) != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, string(buf[:n]))
+	}
+}
+
+// TestPushgatewayPush ensures we send all metrics in the Prometheus text format
+// to the pushgateway.
+func TestPushgatewayPush(t *testing.T) {
+	var method, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		method = req.Method
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	r := NewRegistry()
+	r.NewCounter("test_total", "A counter.").Add(3)
+	p, err := NewPusher(srv.URL + "/metrics/job/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Push(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || !strings.Contains(body, "test_total 3\n") {
+		t.Fatalf("Unexpected push: %s '%s'", method, body)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	err = p.Push(context.Background(), r)
+	if err == nil || !strings.Contains(err.Error(), "status code 400") {
+		t.Fatalf("Expected a status code error, got %v", err)
+	}
+}
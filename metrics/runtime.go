@@ -0,0 +1,11 @@
+package metrics
+
+import (
+	"runtime"
+)
+
+func init() {
+	DefaultRegistry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"sync"
+)
+
+type (
+	// Counter is a value that only ever goes up.
+	Counter struct {
+		value float64
+		mu    sync.Mutex
+	}
+
+	// CounterVec is a set of counters partitioned by label values.
+	CounterVec struct {
+		f *family
+	}
+
+	// Gauge is a value that can go up and down.
+	Gauge struct {
+		value float64
+		mu    sync.Mutex
+	}
+
+	// GaugeVec is a set of gauges partitioned by label values.
+	GaugeVec struct {
+		f *family
+	}
+
+	// gaugeFunc is a gauge whose value is computed at collection time.
+	gaugeFunc struct {
+		fn func() float64
+	}
+
+	// Histogram counts observations in a set of cumulative buckets.
+	Histogram struct {
+		buckets []float64
+		counts  []uint64
+		count   uint64
+		sum     float64
+		mu      sync.Mutex
+	}
+
+	// HistogramVec is a set of histograms partitioned by label values.
+	HistogramVec struct {
+		f *family
+	}
+)
+
+// NewCounter registers a new counter with the default registry.
+func NewCounter(name, help string) *Counter {
+	return DefaultRegistry.NewCounter(name, help)
+}
+
+// NewCounterVec registers a new labelled counter with the default registry.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labelNames...)
+}
+
+// NewGauge registers a new gauge with the default registry.
+func NewGauge(name, help string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help)
+}
+
+// NewGaugeVec registers a new labelled gauge with the default registry.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labelNames...)
+}
+
+// NewGaugeFunc registers a new gauge with the default registry. Its value is
+// whatever fn returns at the time of collection.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	DefaultRegistry.NewGaugeFunc(name, help, fn)
+}
+
+// NewHistogram registers a new histogram with the default registry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets)
+}
+
+// NewHistogramVec registers a new labelled histogram with the default
+// registry.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+// NewCounter registers a new counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	f := r.register(name, help, typeCounter, nil, func() child { return &Counter{} })
+	return f.with().(*Counter)
+}
+
+// NewCounterVec registers a new labelled counter.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	f := r.register(name, help, typeCounter, labelNames, func() child { return &Counter{} })
+	return &CounterVec{f: f}
+}
+
+// NewGauge registers a new gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	f := r.register(name, help, typeGauge, nil, func() child { return &Gauge{} })
+	return f.with().(*Gauge)
+}
+
+// NewGaugeVec registers a new labelled gauge.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	f := r.register(name, help, typeGauge, labelNames, func() child { return &Gauge{} })
+	return &GaugeVec{f: f}
+}
+
+// NewGaugeFunc registers a new gauge whose value is computed by fn at the time
+// of collection.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, typeGauge, nil, func() child { return &gaugeFunc{fn: fn} })
+	f.with()
+}
+
+// NewHistogram registers a new histogram with the given upper bucket bounds.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	f := r.register(name, help, typeHistogram, nil, func() child { return newHistogram(buckets) })
+	return f.with().(*Histogram)
+}
+
+// NewHistogramVec registers a new labelled histogram.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	f := r.register(name, help, typeHistogram, labelNames, func() child { return newHistogram(buckets) })
+	return &HistogramVec{f: f}
+}
+
+// Add increases the counter by v. Negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// samples implements child.
+func (c *Counter) samples(name string, labels []Label) []Sample {
+	return []Sample{{Name: name, Labels: labels, Value: c.Value()}}
+}
+
+// With returns the counter with the given label values.
+func (cv *CounterVec) With(values ...string) *Counter {
+	return cv.f.with(values...).(*Counter)
+}
+
+// Add changes the gauge by v.
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+// Dec decreases the gauge by one.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Inc increases the gauge by one.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// samples implements child.
+func (g *Gauge) samples(name string, labels []Label) []Sample {
+	return []Sample{{Name: name, Labels: labels, Value: g.Value()}}
+}
+
+// With returns the gauge with the given label values.
+func (gv *GaugeVec) With(values ...string) *Gauge {
+	return gv.f.with(values...).(*Gauge)
+}
+
+// samples implements child.
+func (gf *gaugeFunc) samples(name string, labels []Label) []Sample {
+	return []Sample{{Name: name, Labels: labels, Value: gf.fn()}}
+}
+
+// newHistogram returns a histogram with the given upper bucket bounds. The
+// bounds are expected to be sorted in increasing order.
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns the total number of observations.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// samples implements child.
+func (h *Histogram) samples(name string, labels []Label) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := make([]Sample, 0, len(h.buckets)+3)
+	for i, b := range h.buckets {
+		samples = append(samples, Sample{
+			Name:   name + "_bucket",
+			Labels: withLabel(labels, "le", formatValue(b)),
+			Value:  float64(h.counts[i]),
+		})
+	}
+	samples = append(samples,
+		Sample{Name: name + "_bucket", Labels: withLabel(labels, "le", "+Inf"), Value: float64(h.count)},
+		Sample{Name: name + "_sum", Labels: labels, Value: h.sum},
+		Sample{Name: name + "_count", Labels: labels, Value: float64(h.count)},
+	)
+	return samples
+}
+
+// With returns the histogram with the given label values.
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	return hv.f.with(values...).(*Histogram)
+}
+
+// withLabel returns a copy of labels with an extra label appended.
+func withLabel(labels []Label, name, value string) []Label {
+	ls := make([]Label, len(labels), len(labels)+1)
+	copy(ls, labels)
+	return append(ls, Label{Name: name, Value: value})
+}
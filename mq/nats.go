@@ -0,0 +1,103 @@
+package mq
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ErrPermanent is extended by handler errors which won't go away on
+	// redelivery, e.g. malformed messages. Such messages are dropped.
+	ErrPermanent = errors.New("permanent failure")
+)
+
+type (
+	// Handler processes a single message. Returning nil acknowledges the
+	// message. Returning an error which extends ErrPermanent drops it. Any
+	// other error leaves it unacknowledged, so the broker redelivers it once
+	// its ack wait expires.
+	Handler func(data []byte) error
+
+	// NATS is a connection to a NATS server with JetStream enabled.
+	// JetStream persists the messages, so they aren't lost while the
+	// scanner is unavailable.
+	NATS struct {
+		staticConn *nats.Conn
+		staticJS   nats.JetStreamContext
+	}
+)
+
+// Connect connects to the NATS server at the given URL. It keeps reconnecting
+// for as long as the connection is open.
+func Connect(url string) (*NATS, error) {
+	nc, err := nats.Connect(url, nats.Name("malware-scanner"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to connect to NATS")
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, errors.AddContext(err, "failed to initialise JetStream")
+	}
+	return &NATS{
+		staticConn: nc,
+		staticJS:   js,
+	}, nil
+}
+
+// Close closes the connection.
+func (n *NATS) Close() {
+	n.staticConn.Close()
+}
+
+// Publish publishes the given data to the given subject. It waits for
+// JetStream to acknowledge it, so a JetStream stream covering the subject must
+// exist.
+func (n *NATS) Publish(subject string, data []byte) error {
+	_, err := n.staticJS.Publish(subject, data)
+	if err != nil {
+		return errors.AddContext(err, "failed to publish to "+subject)
+	}
+	return nil
+}
+
+// Consume subscribes to the given subject with a durable consumer and passes
+// every message to the handler until the context is cancelled. A JetStream
+// stream covering the subject must already exist.
+func (n *NATS) Consume(ctx context.Context, subject, durable string, h Handler, logger *logrus.Logger) error {
+	if h == nil {
+		return errors.New("no handler provided")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	cb := func(msg *nats.Msg) {
+		err := h(msg.Data)
+		if errors.Contains(err, ErrPermanent) {
+			logger.Debugf("Dropping message on subject '%s': %s", msg.Subject, err)
+			err = msg.Term()
+		} else if err != nil {
+			logger.Debugf("Failed to handle message on subject '%s', it will be redelivered: %s", msg.Subject, err)
+			return
+		} else {
+			err = msg.Ack()
+		}
+		if err != nil {
+			logger.Debugf("Failed to acknowledge message on subject '%s': %s", msg.Subject, err)
+		}
+	}
+	sub, err := n.staticJS.Subscribe(subject, cb, nats.Durable(durable), nats.ManualAck(), nats.AckExplicit(), nats.DeliverAll())
+	if err != nil {
+		return errors.AddContext(err, "failed to subscribe to "+subject)
+	}
+	go func() {
+		<-ctx.Done()
+		if err := sub.Drain(); err != nil {
+			logger.Debugln(errors.AddContext(err, "failed to drain subscription"))
+		}
+	}()
+	return nil
+}
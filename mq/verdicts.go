@@ -0,0 +1,74 @@
+package mq
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/events"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// Verdict is the result of a completed scan, as published on the
+	// message queue.
+	Verdict struct {
+		Hash              string    `json:"hash"`
+		Infected          bool      `json:"infected"`
+		Signature         string    `json:"signature,omitempty"`
+		Size              uint64    `json:"size"`
+		ScannedSize       uint64    `json:"scannedSize"`
+		ScannedAllContent bool      `json:"scannedAllContent"`
+		Timestamp         time.Time `json:"timestamp"`
+	}
+
+	// VerdictSink is an events.Sink which publishes the verdict of every
+	// completed scan to a subject. It ignores all other events.
+	VerdictSink struct {
+		staticNATS    *NATS
+		staticSubject string
+	}
+)
+
+// NewVerdictSink returns a sink which publishes verdicts to the given subject.
+func NewVerdictSink(n *NATS, subject string) (*VerdictSink, error) {
+	if n == nil {
+		return nil, errors.New("no NATS connection provided")
+	}
+	if subject == "" {
+		return nil, errors.New("no subject provided")
+	}
+	return &VerdictSink{
+		staticNATS:    n,
+		staticSubject: subject,
+	}, nil
+}
+
+// Write implements events.Sink.
+func (vs *VerdictSink) Write(e events.Event) error {
+	v, ok := verdictFromEvent(e)
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.AddContext(err, "failed to encode verdict")
+	}
+	return vs.staticNATS.Publish(vs.staticSubject, b)
+}
+
+// verdictFromEvent builds a verdict from the given event. It returns false if
+// the event doesn't mark a completed scan.
+func verdictFromEvent(e events.Event) (Verdict, bool) {
+	if e.Type != events.TypeScanned && e.Type != events.TypeInfected {
+		return Verdict{}, false
+	}
+	return Verdict{
+		Hash:              e.Hash,
+		Infected:          e.Infected,
+		Signature:         e.Description,
+		Size:              e.Size,
+		ScannedSize:       e.ScannedSize,
+		ScannedAllContent: e.ScannedSize == e.Size,
+		Timestamp:         e.Timestamp,
+	}, true
+}
@@ -0,0 +1,46 @@
+package mq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/events"
+)
+
+// TestVerdictFromEvent ensures we only publish verdicts of completed scans.
+func TestVerdictFromEvent(t *testing.T) {
+	now := time.Now().UTC()
+	e := events.Event{
+		Type:        events.TypeInfected,
+		Hash:        "aa",
+		Infected:    true,
+		Description: "Eicar-Signature",
+		Size:        100,
+		ScannedSize: 50,
+		Timestamp:   now,
+	}
+	v, ok := verdictFromEvent(e)
+	if !ok {
+		t.Fatal("Expected a verdict")
+	}
+	expected := Verdict{
+		Hash:        "aa",
+		Infected:    true,
+		Signature:   "Eicar-Signature",
+		Size:        100,
+		ScannedSize: 50,
+		Timestamp:   now,
+	}
+	if v != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, v)
+	}
+	v, ok = verdictFromEvent(events.Event{Type: events.TypeScanned, Size: 10, ScannedSize: 10})
+	if !ok || v.Infected || !v.ScannedAllContent {
+		t.Fatalf("Unexpected verdict %+v, %t", v, ok)
+	}
+	for _, typ := range []string{events.TypeSubmitted, events.TypeLocked, events.TypeReported, events.TypeFailed} {
+		if _, ok = verdictFromEvent(events.Event{Type: typ}); ok {
+			t.Fatalf("Expected no verdict for a '%s' event", typ)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// SeverityCritical marks alerts that require immediate attention.
+	SeverityCritical = "critical"
+	// SeverityResolved marks notifications about a previously fired alert
+	// which is no longer active.
+	SeverityResolved = "resolved"
+	// SeverityInfo marks informational notifications, e.g. digests, which
+	// don't require any action.
+	SeverityInfo = "info"
+)
+
+type (
+	// Alert is a single notification about a critical condition. Details
+	// optionally holds the data behind the message, for the destinations
+	// which can process it, e.g. webhooks.
+	Alert struct {
+		Name      string      `json:"name"`
+		Severity  string      `json:"severity"`
+		Message   string      `json:"message"`
+		Details   interface{} `json:"details,omitempty"`
+		Timestamp time.Time   `json:"timestamp"`
+	}
+
+	// Notifier delivers alerts to a destination.
+	Notifier interface {
+		Notify(ctx context.Context, a Alert) error
+	}
+
+	// Multi delivers alerts to all of its notifiers.
+	Multi []Notifier
+
+	// Webhook delivers alerts as JSON POST requests to a URL.
+	Webhook struct {
+		URL string
+	}
+
+	// Slack delivers alerts to a Slack incoming webhook.
+	Slack struct {
+		WebhookURL string
+	}
+
+	// Email delivers alerts via SMTP.
+	Email struct {
+		Host     string
+		Port     string
+		User     string
+		Password string
+		From     string
+		To       []string
+	}
+
+	// Alerter keeps track of which alerts are currently active, so we don't
+	// flood the notifiers with the same alert every time we check for it.
+	Alerter struct {
+		active         map[string]time.Time
+		staticNotifier Notifier
+		staticRepeat   time.Duration
+		staticLogger   *logrus.Logger
+		mu             sync.Mutex
+	}
+)
+
+// NewAlerter returns a new Alerter which sends its alerts to the given
+// notifier. Active alerts are repeated every `repeat`.
+func NewAlerter(n Notifier, repeat time.Duration, logger *logrus.Logger) (*Alerter, error) {
+	if n == nil {
+		return nil, errors.New("invalid notifier provided")
+	}
+	if logger == nil {
+		return nil, errors.New("invalid logger provided")
+	}
+	return &Alerter{
+		active:         make(map[string]time.Time),
+		staticNotifier: n,
+		staticRepeat:   repeat,
+		staticLogger:   logger,
+	}, nil
+}
+
+// Fire sends a critical alert with the given name, unless the same alert was
+// already sent less than the repeat interval ago.
+func (a *Alerter) Fire(ctx context.Context, name, message string) {
+	a.mu.Lock()
+	last, ok := a.active[name]
+	if ok && time.Since(last) < a.staticRepeat {
+		a.mu.Unlock()
+		return
+	}
+	a.active[name] = time.Now()
+	a.mu.Unlock()
+	a.send(ctx, Alert{Name: name, Severity: SeverityCritical, Message: message})
+}
+
+// Resolve sends a resolution notice for the alert with the given name, if it
+// is currently active.
+func (a *Alerter) Resolve(ctx context.Context, name, message string) {
+	a.mu.Lock()
+	_, ok := a.active[name]
+	delete(a.active, name)
+	a.mu.Unlock()
+	if ok {
+		a.send(ctx, Alert{Name: name, Severity: SeverityResolved, Message: message})
+	}
+}
+
+// send delivers the alert, logging any errors.
+func (a *Alerter) send(ctx context.Context, alert Alert) {
+	alert.Timestamp = time.Now().UTC()
+	err := a.staticNotifier.Notify(ctx, alert)
+	if err != nil {
+		a.staticLogger.Warnf("Failed to send alert '%s': %s", alert.Name, err)
+	}
+}
+
+// Notify implements Notifier.
+func (m Multi) Notify(ctx context.Context, a Alert) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.Notify(ctx, a))
+	}
+	return errors.Compose(errs...)
+}
+
+// Notify implements Notifier.
+func (wh Webhook) Notify(ctx context.Context, a Alert) error {
+	return postJSON(ctx, wh.URL, a)
+}
+
+// Notify implements Notifier.
+func (s Slack) Notify(ctx context.Context, a Alert) error {
+	body := struct {
+		Text string `json:"text"`
+	}{
+		Text: fmt.Sprintf("[%s] %s: %s", strings.ToUpper(a.Severity), a.Name, a.Message),
+	}
+	return postJSON(ctx, s.WebhookURL, body)
+}
+
+// Notify implements Notifier.
+func (e Email) Notify(_ context.Context, a Alert) error {
+	var auth smtp.Auth
+	if e.User != "" {
+		auth = smtp.PlainAuth("", e.User, e.Password, e.Host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [malware-scanner] %s %s\r\n\r\n%s\r\n\r\n%s\r\n",
+		e.From, strings.Join(e.To, ", "), strings.ToUpper(a.Severity), a.Name, a.Message, a.Timestamp.Format(time.RFC3339))
+	err := smtp.SendMail(fmt.Sprintf("%s:%s", e.Host, e.Port), auth, e.From, e.To, []byte(msg))
+	if err != nil {
+		return errors.AddContext(err, "failed to send email")
+	}
+	return nil
+}
+
+// postJSON sends the given body as JSON to the given URL and expects a 2xx
+// response.
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.AddContext(err, "failed to build request body")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(b))
+	if err != nil {
+		return errors.AddContext(err, "failed to build request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.AddContext(err, "failed to send notification")
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		rb, _ := ioutil.ReadAll(res.Body)
+		return errors.New(fmt.Sprintf("notification failed. status code %d, body: '%s'", res.StatusCode, string(rb)))
+	}
+	return nil
+}
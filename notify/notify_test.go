@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// recorder is a Notifier which records all alerts it receives.
+type recorder struct {
+	alerts []Alert
+}
+
+// Notify implements Notifier.
+func (r *recorder) Notify(_ context.Context, a Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+// TestAlerter ensures Alerter only repeats active alerts after the repeat
+// interval and only resolves active alerts.
+func TestAlerter(t *testing.T) {
+	ctx := context.Background()
+	rec := &recorder{}
+	a, err := NewAlerter(rec, 50*time.Millisecond, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resolving an inactive alert does nothing.
+	a.Resolve(ctx, "test", "all good")
+	if len(rec.alerts) != 0 {
+		t.Fatalf("Expected no alerts, got %d", len(rec.alerts))
+	}
+	// Firing twice in quick succession only sends one alert.
+	a.Fire(ctx, "test", "bad")
+	a.Fire(ctx, "test", "bad")
+	if len(rec.alerts) != 1 || rec.alerts[0].Severity != SeverityCritical {
+		t.Fatalf("Expected one critical alert, got %+v", rec.alerts)
+	}
+	// After the repeat interval the alert is sent again.
+	time.Sleep(60 * time.Millisecond)
+	a.Fire(ctx, "test", "bad")
+	if len(rec.alerts) != 2 {
+		t.Fatalf("Expected two alerts, got %d", len(rec.alerts))
+	}
+	// Resolving an active alert sends a notice.
+	a.Resolve(ctx, "test", "all good")
+	a.Resolve(ctx, "test", "all good")
+	if len(rec.alerts) != 3 || rec.alerts[2].Severity != SeverityResolved {
+		t.Fatalf("Expected a resolution notice, got %+v", rec.alerts)
+	}
+}
+
+// TestWebhook ensures Webhook and Slack deliver alerts in the expected format.
+func TestWebhook(t *testing.T) {
+	defer gock.Off()
+
+	url := "http://alerts.test"
+	a := Alert{Name: "test", Severity: SeverityCritical, Message: "bad"}
+	b, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gock.New(url).
+		Post("/hook").
+		BodyString(string(b)).
+		Reply(http.StatusOK)
+	err = Webhook{URL: url + "/hook"}.Notify(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gock.New(url).
+		Post("/slack").
+		BodyString(`{"text":"[CRITICAL] test: bad"}`).
+		Reply(http.StatusOK)
+	err = Slack{WebhookURL: url + "/slack"}.Notify(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Non-2xx responses are errors.
+	gock.New(url).
+		Post("/hook").
+		Reply(http.StatusBadGateway)
+	err = Multi{Webhook{URL: url + "/hook"}}.Notify(context.Background(), a)
+	if err == nil || !strings.Contains(err.Error(), "status code 502") {
+		t.Fatalf("Expected error 'status code 502', got '%v'", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Not all mocks were used")
+	}
+}
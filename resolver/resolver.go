@@ -0,0 +1,217 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// dialTimeout is how long we wait to establish a connection.
+	dialTimeout = 30 * time.Second
+	// lookupTimeout is how long we wait for the resolver to resolve a name.
+	lookupTimeout = 10 * time.Second
+)
+
+type (
+	// Resolver resolves hostnames via a Handshake-aware DNS resolver, such as
+	// hnsd or HDNS, so portals and blocker instances can be addressed by HNS
+	// names. Names are resolved when they are added and re-resolved
+	// periodically, and connections to them use the cached addresses. Names
+	// the resolver doesn't know are left to the system's resolver, so regular
+	// domains and container names keep working.
+	Resolver struct {
+		// addrs holds the resolved addresses of the added names.
+		addrs map[string][]string
+
+		staticDialer *net.Dialer
+		staticLookup func(ctx context.Context, host string) ([]string, error)
+		mu           sync.Mutex
+	}
+)
+
+// New returns a resolver which resolves names via the DNS server at the given
+// address, e.g. "127.0.0.1:5350" for a local hnsd.
+func New(server string) (*Resolver, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return nil, errors.AddContext(err, "invalid resolver address")
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+	return &Resolver{
+		addrs:        make(map[string][]string),
+		staticDialer: dialer,
+		staticLookup: r.LookupHost,
+	}, nil
+}
+
+// Add resolves the host of the given URL or hostname and remembers its
+// addresses. It returns false if the resolver doesn't know the name or it's an
+// IP address, in which case connections to it are left to the system.
+func (r *Resolver) Add(ctx context.Context, hostOrURL string) (bool, error) {
+	host := hostname(hostOrURL)
+	if host == "" || net.ParseIP(host) != nil {
+		return false, nil
+	}
+	addrs, err := r.lookup(ctx, host)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.AddContext(err, "failed to resolve "+host)
+	}
+	r.mu.Lock()
+	r.addrs[host] = addrs
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Start launches a background thread which re-resolves the added names every
+// interval until the context is cancelled. If a name fails to resolve, we keep
+// using its previous addresses.
+func (r *Resolver) Start(ctx context.Context, interval time.Duration, logger *logrus.Logger) error {
+	if interval <= 0 {
+		return errors.New("invalid re-resolve interval")
+	}
+	if logger == nil {
+		return errors.New("invalid logger provided")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := r.refresh(ctx, logger)
+			if err != nil {
+				logger.Warnln(errors.AddContext(err, "failed to re-resolve names"))
+			}
+		}
+	}()
+	return nil
+}
+
+// refresh re-resolves all added names.
+func (r *Resolver) refresh(ctx context.Context, logger *logrus.Logger) error {
+	r.mu.Lock()
+	hosts := make([]string, 0, len(r.addrs))
+	for host := range r.addrs {
+		hosts = append(hosts, host)
+	}
+	r.mu.Unlock()
+	var errs []error
+	for _, host := range hosts {
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			errs = append(errs, errors.AddContext(err, host))
+			continue
+		}
+		r.mu.Lock()
+		if !equal(r.addrs[host], addrs) {
+			logger.Infof("%s now resolves to %s", host, strings.Join(addrs, ", "))
+		}
+		r.addrs[host] = addrs
+		r.mu.Unlock()
+	}
+	return errors.Compose(errs...)
+}
+
+// DialContext connects to the given address like net.Dialer does, except that
+// added names are dialed at their resolved addresses, in order, until one of
+// them accepts the connection.
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return r.staticDialer.DialContext(ctx, network, addr)
+	}
+	r.mu.Lock()
+	addrs := r.addrs[strings.ToLower(host)]
+	r.mu.Unlock()
+	if len(addrs) == 0 {
+		return r.staticDialer.DialContext(ctx, network, addr)
+	}
+	var errs []error
+	for _, a := range addrs {
+		conn, err := r.staticDialer.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.AddContext(errors.Compose(errs...), "failed to connect to "+host)
+}
+
+// Transport returns a clone of the default HTTP transport which dials via the
+// resolver. TLS connections are still verified against the original name.
+func (r *Resolver) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = r.DialContext
+	return t
+}
+
+// lookup resolves the given host, returning its addresses in a stable order.
+func (r *Resolver) lookup(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	addrs, err := r.staticLookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found")
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// hostname returns the lowercase hostname of the given URL or hostname.
+func hostname(hostOrURL string) string {
+	s := hostOrURL
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		s = h
+	}
+	return strings.ToLower(strings.TrimSuffix(s, "."))
+}
+
+// isNotFound returns whether the given error means the name doesn't exist.
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
+
+// equal returns whether the given sorted lists of addresses are equal.
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
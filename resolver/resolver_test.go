@@ -0,0 +1,101 @@
+package resolver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestResolver returns a resolver which resolves names from the given map.
+func newTestResolver(t *testing.T, names map[string][]string) *Resolver {
+	r, err := New("127.0.0.1:5350")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.staticLookup = func(_ context.Context, host string) ([]string, error) {
+		addrs, ok := names[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return addrs, nil
+	}
+	return r
+}
+
+// TestHostname ensures we extract hostnames from URLs and hostnames.
+func TestHostname(t *testing.T) {
+	tests := map[string]string{
+		"https://SkyPortal/":              "skyportal",
+		"https://user@portal.hns:443/a?b": "portal.hns",
+		"http://[::1]:4000":               "::1",
+		"portal.":                         "portal",
+		"portal:9980":                     "portal",
+	}
+	for in, expected := range tests {
+		if h := hostname(in); h != expected {
+			t.Fatalf("Expected '%s' for '%s', got '%s'", expected, in, h)
+		}
+	}
+}
+
+// TestResolver ensures added names are dialed at their resolved addresses and
+// other names are left to the system.
+func TestResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string][]string{"skyportal": {"127.0.0.1"}}
+	r := newTestResolver(t, names)
+	for _, name := range []string{"https://skyportal", "http://127.0.0.1:4000", "http://blocker:4000"} {
+		added, err := r.Add(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != (name == "https://skyportal") {
+			t.Fatalf("Unexpected result %t for %s", added, name)
+		}
+	}
+
+	client := &http.Client{Transport: r.Transport()}
+	res, err := client.Get("http://skyportal:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if string(b) != "ok" {
+		t.Fatalf("Unexpected response '%s'", string(b))
+	}
+
+	// Re-resolving picks up new addresses and keeps the old ones when
+	// resolving fails.
+	names["skyportal"] = []string{"127.0.0.2", "127.0.0.1"}
+	if err = r.refresh(context.Background(), logrus.New()); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := r.addrs["skyportal"]; len(addrs) != 2 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("Unexpected addresses %v", addrs)
+	}
+	delete(names, "skyportal")
+	if err = r.refresh(context.Background(), logrus.New()); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if addrs := r.addrs["skyportal"]; len(addrs) != 2 {
+		t.Fatalf("Expected the previous addresses to be kept, got %v", addrs)
+	}
+
+	if _, err = New("127.0.0.1"); err == nil {
+		t.Fatal("Expected an error for a resolver without a port.")
+	}
+}
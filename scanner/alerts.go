@@ -0,0 +1,183 @@
+package scanner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/notify"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// alertQueueAge is the name of the alert fired when the oldest queued
+	// skylink has been waiting for too long.
+	alertQueueAge = "queue_age"
+	// alertClamAVDown is the name of the alert fired when ClamAV has been
+	// unreachable for too long.
+	alertClamAVDown = "clamav_unreachable"
+	// alertBlockerFailures is the name of the alert fired when too many
+	// subsequent reports to blocker have failed.
+	alertBlockerFailures = "blocker_failures"
+	// alertInfectionRate is the name of the alert fired when the share of
+	// infected skylinks spikes.
+	alertInfectionRate = "infection_rate"
+	// alertInfectionAnomaly is the name of the alert fired when the recent
+	// infection rate is significantly higher than the baseline.
+	alertInfectionAnomaly = "infection_rate_anomaly"
+)
+
+// AlertThresholds defines the conditions under which the scanner sends out
+// alerts. A zero value disables the respective alert.
+type AlertThresholds struct {
+	// CheckInterval defines how often we check the conditions.
+	CheckInterval time.Duration
+	// QueueAge is the maximum time a skylink can wait in the queue.
+	QueueAge time.Duration
+	// ClamAVDown is the maximum time ClamAV can be unreachable.
+	ClamAVDown time.Duration
+	// BlockerFailures is the maximum number of subsequent failed reports.
+	BlockerFailures int
+	// InfectionRate is the maximum share of infected skylinks, between 0 and
+	// 1, among those scanned within InfectionWindow.
+	InfectionRate float64
+	// InfectionWindow is the period over which we compute the infection rate.
+	InfectionWindow time.Duration
+	// InfectionMinScans is the minimum number of scans within the window
+	// before we consider the infection rate meaningful.
+	InfectionMinScans int64
+	// InfectionAnomaly enables alerts about the recent infection rate being
+	// significantly higher than the historical baseline.
+	InfectionAnomaly bool
+}
+
+// StartAlerts launches a background thread that periodically checks for
+// critical conditions and notifies the given alerter about them.
+func (s *Scanner) StartAlerts(a *notify.Alerter, t AlertThresholds) error {
+	if a == nil {
+		return errors.New("invalid alerter provided")
+	}
+	if t.CheckInterval <= 0 {
+		return errors.New("invalid check interval")
+	}
+	go func() {
+		metricActiveWorkers.With("alerts").Inc()
+		defer metricActiveWorkers.With("alerts").Dec()
+		// clamDownSince is the time of the first failed ping in the current
+		// streak of failed pings.
+		var clamDownSince time.Time
+		ticker := database.Clock.NewTicker(t.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.staticCtx.Done():
+				return
+			case <-ticker.C():
+			}
+			if t.QueueAge > 0 {
+				s.checkQueueAge(a, t.QueueAge)
+			}
+			if t.ClamAVDown > 0 {
+				clamDownSince = s.checkClamAV(a, t.ClamAVDown, clamDownSince)
+			}
+			if t.BlockerFailures > 0 {
+				s.checkBlockerFailures(a, t.BlockerFailures)
+			}
+			if t.InfectionRate > 0 && t.InfectionWindow > 0 {
+				s.checkInfectionRate(a, t)
+			}
+			if t.InfectionAnomaly {
+				s.checkInfectionAnomaly(a)
+			}
+		}
+	}()
+	return nil
+}
+
+// checkQueueAge alerts if the oldest queued skylink is older than maxAge.
+func (s *Scanner) checkQueueAge(a *notify.Alerter, maxAge time.Duration) {
+	oldest, err := s.staticDB.OldestQueued(s.staticCtx)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		a.Resolve(s.staticCtx, alertQueueAge, "the queue is empty")
+		return
+	}
+	if err != nil {
+		s.staticLogger.Debugln(errors.AddContext(err, "failed to check queue age"))
+		return
+	}
+	// Legacy records don't have a submission time.
+	if oldest.IsZero() {
+		return
+	}
+	age := database.Clock.Since(oldest)
+	if age > maxAge {
+		a.Fire(s.staticCtx, alertQueueAge, fmt.Sprintf("the oldest queued skylink has been waiting for %s", age.Truncate(time.Second)))
+		return
+	}
+	a.Resolve(s.staticCtx, alertQueueAge, fmt.Sprintf("the oldest queued skylink has been waiting for %s", age.Truncate(time.Second)))
+}
+
+// checkClamAV alerts if ClamAV has been unreachable for longer than maxDown.
+// It returns the updated time since which ClamAV has been unreachable.
+func (s *Scanner) checkClamAV(a *notify.Alerter, maxDown time.Duration, downSince time.Time) time.Time {
+	err := s.staticClam.Ping()
+	if err == nil {
+		a.Resolve(s.staticCtx, alertClamAVDown, "ClamAV is reachable again")
+		return time.Time{}
+	}
+	if downSince.IsZero() {
+		downSince = database.Clock.Now()
+	}
+	if down := database.Clock.Since(downSince); down >= maxDown {
+		a.Fire(s.staticCtx, alertClamAVDown, fmt.Sprintf("ClamAV has been unreachable for %s: %s", down.Truncate(time.Second), err))
+	}
+	return downSince
+}
+
+// checkBlockerFailures alerts if the number of subsequent failed reports to
+// blocker has reached maxFailures.
+func (s *Scanner) checkBlockerFailures(a *notify.Alerter, maxFailures int) {
+	n := s.BlockerFailures()
+	if n >= maxFailures {
+		a.Fire(s.staticCtx, alertBlockerFailures, fmt.Sprintf("%d subsequent reports to blocker have failed", n))
+		return
+	}
+	a.Resolve(s.staticCtx, alertBlockerFailures, "reports to blocker are succeeding again")
+}
+
+// checkInfectionRate alerts if the share of infected skylinks within the
+// configured window exceeds the threshold.
+func (s *Scanner) checkInfectionRate(a *notify.Alerter, t AlertThresholds) {
+	scanned, infected, err := s.staticDB.InfectionCounts(s.staticCtx, database.Clock.Now().UTC().Add(-t.InfectionWindow))
+	if err != nil {
+		s.staticLogger.Debugln(errors.AddContext(err, "failed to check infection rate"))
+		return
+	}
+	if scanned == 0 || scanned < t.InfectionMinScans {
+		return
+	}
+	rate := float64(infected) / float64(scanned)
+	msg := fmt.Sprintf("%d out of %d skylinks scanned in the last %s were infected (%.1f%%)", infected, scanned, t.InfectionWindow, rate*100)
+	if rate > t.InfectionRate {
+		a.Fire(s.staticCtx, alertInfectionRate, msg)
+		return
+	}
+	a.Resolve(s.staticCtx, alertInfectionRate, msg)
+}
+
+// checkInfectionAnomaly alerts if the recent infection rate is significantly
+// higher than the historical baseline.
+func (s *Scanner) checkInfectionAnomaly(a *notify.Alerter) {
+	an, err := s.staticDB.InfectionAnomaly(s.staticCtx)
+	if err != nil {
+		s.staticLogger.Debugln(errors.AddContext(err, "failed to check infection anomaly"))
+		return
+	}
+	msg := fmt.Sprintf("the infection rate over the last %s is %.2f%% (%d out of %d) against a baseline of %.2f%%, z-score %.1f",
+		time.Duration(an.Window)*time.Second, an.RecentRate*100, an.RecentInfected, an.RecentScanned, an.BaselineRate*100, an.ZScore)
+	if an.Anomalous {
+		a.Fire(s.staticCtx, alertInfectionAnomaly, msg)
+		return
+	}
+	a.Resolve(s.staticCtx, alertInfectionAnomaly, msg)
+}
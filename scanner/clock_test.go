@@ -0,0 +1,153 @@
+package scanner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// useFakeClock replaces the clock with a fake one for the duration of the
+// test.
+func useFakeClock(t *testing.T) *clock.Fake {
+	c := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = c
+	t.Cleanup(func() { database.Clock = real })
+	return c
+}
+
+// TestScanSleep ensures the scanning workers back off further with every
+// error in a row, up to 100s, and reset once a sweep succeeds.
+func TestScanSleep(t *testing.T) {
+	errs := 0
+	var d time.Duration
+	for _, expected := range []time.Duration{100 * time.Millisecond, time.Second, 10 * time.Second, 100 * time.Second, 100 * time.Second} {
+		d, errs = scanSleep(errors.New("failed"), errs)
+		if d != expected {
+			t.Fatalf("Expected to sleep %v, got %v", expected, d)
+		}
+	}
+	if d, errs = scanSleep(nil, errs); d != 0 || errs != 0 {
+		t.Fatalf("Expected no sleep after a scan, got %v and %d errors", d, errs)
+	}
+	if d, _ = scanSleep(errors.New("failed"), errs); d != sleepOnErrStep {
+		t.Fatalf("Expected the backoff to start over, got %v", d)
+	}
+	if d, errs = scanSleep(database.ErrNoDocumentsFound, 2); d != sleepBetweenScans || errs != 0 {
+		t.Fatalf("Expected to wait for new skylinks, got %v and %d errors", d, errs)
+	}
+}
+
+// TestSleep ensures the scanner sleeps on its clock and wakes up when its
+// context is done.
+func TestSleep(t *testing.T) {
+	c := useFakeClock(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scanner{staticCtx: ctx}
+	woke := make(chan bool)
+	go func() { woke <- s.sleep(time.Minute) }()
+	c.BlockUntil(1)
+	c.Advance(time.Minute - time.Nanosecond)
+	select {
+	case <-woke:
+		t.Fatal("Expected the scanner to sleep for a minute")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Advance(time.Nanosecond)
+	if !<-woke {
+		t.Fatal("Expected the scanner to wake up")
+	}
+
+	go func() { woke <- s.sleep(time.Hour) }()
+	c.BlockUntil(1)
+	cancel()
+	if <-woke {
+		t.Fatal("Expected the sleep to be interrupted")
+	}
+}
+
+// TestSignatureVersionCache ensures we ask ClamAV for its signature version at
+// most once per signatureVersionTTL, unless the signatures changed.
+func TestSignatureVersionCache(t *testing.T) {
+	c := useFakeClock(t)
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	ip, port := mc.Addr()
+	clam, err := clamav.New(ip, port, "http://portal.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = clam.Close() }()
+	s := &Scanner{staticClam: clam}
+
+	conns := mc.Connections()
+	if v := s.currentSignatureVersion(); v != 26390 || mc.Connections() != conns+1 {
+		t.Fatalf("Expected version 26390 from clamd, got %d", v)
+	}
+	c.Advance(signatureVersionTTL - time.Second)
+	if v := s.currentSignatureVersion(); v != 26390 || mc.Connections() != conns+1 {
+		t.Fatalf("Expected the cached version, got %d", v)
+	}
+	c.Advance(time.Second)
+	if s.currentSignatureVersion(); mc.Connections() != conns+2 {
+		t.Fatal("Expected the version to be looked up again once it expired")
+	}
+	s.signaturesChanged()
+	if s.currentSignatureVersion(); mc.Connections() != conns+3 {
+		t.Fatal("Expected the version to be looked up again after an update")
+	}
+}
+
+// TestRateLimitedFor ensures the scanning workers hold off on the scanner's
+// clock for as long as every portal rate limits us.
+func TestRateLimitedFor(t *testing.T) {
+	c := useFakeClock(t)
+	mc, err := test.NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer busy.Close()
+	ip, port := mc.Addr()
+	clam, err := clamav.New(ip, port, busy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = clam.Close() }()
+	s := &Scanner{staticClam: clam}
+
+	if d := s.rateLimitedFor(); d != 0 {
+		t.Fatalf("Expected not to hold off before the portal rate limited us, got %s", d)
+	}
+	abort := make(chan bool)
+	defer close(abort)
+	if r := clam.ScanSkylink("eicar", abort); !errors.Contains(r.Err, clamav.ErrPortalRateLimited) {
+		t.Fatalf("Expected the scan to be rate limited, got %v", r.Err)
+	}
+	if d := s.rateLimitedFor(); d != time.Minute {
+		t.Fatalf("Expected to hold off for a minute, got %s", d)
+	}
+	c.Advance(45 * time.Second)
+	if d := s.rateLimitedFor(); d != 15*time.Second {
+		t.Fatalf("Expected to hold off for the rest of the minute, got %s", d)
+	}
+	c.Advance(15 * time.Second)
+	if d := s.rateLimitedFor(); d != 0 {
+		t.Fatalf("Expected not to hold off once the backoff ended, got %s", d)
+	}
+}
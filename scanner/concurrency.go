@@ -0,0 +1,179 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/skynet-accounts/build"
+)
+
+var (
+	// MaxConcurrentScans is the maximum number of skylinks we scan at the
+	// same time. The actual number is adjusted between one and this based
+	// on how loaded clamd and the portal are. One disables concurrent scans.
+	// Set according to the MALWARE_SCANNER_MAX_CONCURRENT_SCANS env var.
+	MaxConcurrentScans = 1
+	// ClamdLatencyTarget is the average time clamd may take to respond once
+	// it got all the content. Above it, we consider clamd saturated and
+	// halve the number of concurrent scans.
+	// Set according to the MALWARE_SCANNER_CLAMD_LATENCY_TARGET env var.
+	ClamdLatencyTarget = 2 * time.Second
+	// PortalErrorRateTarget is the share of download requests which may
+	// fail. Above it, we consider the portal saturated and halve the number
+	// of concurrent scans.
+	// Set according to the MALWARE_SCANNER_PORTAL_ERROR_RATE_TARGET env var.
+	PortalErrorRateTarget = 0.1
+
+	// concurrencyInterval is how often we adjust the number of concurrent
+	// scans.
+	concurrencyInterval = build.Select(
+		build.Var{
+			Dev:      5 * time.Second,
+			Testing:  50 * time.Millisecond,
+			Standard: 15 * time.Second,
+		},
+	).(time.Duration)
+)
+
+// concurrencyLimiter limits the number of concurrent scans. The limit grows
+// by one every interval in which the scans used all of it and dependencies
+// kept up, and it's halved every interval in which they didn't, which is the
+// additive-increase/multiplicative-decrease scheme TCP uses for congestion
+// control.
+type concurrencyLimiter struct {
+	limit  int
+	active int
+	// peak is the highest number of active scans since the last
+	// adjustment.
+	peak int
+	// changed is closed and replaced whenever a scan ends or the limit
+	// changes, to wake up the workers waiting for a slot.
+	changed chan struct{}
+	// max is the highest the limit can currently grow to. It's raised up
+	// to staticMax while we drain a backlog.
+	max int
+
+	// staticMax is the highest max can be raised to, which is the number
+	// of scanning workers.
+	staticMax int
+	mu        sync.Mutex
+}
+
+// newConcurrencyLimiter returns a limiter which allows a single scan at
+// first and up to n scans once dependencies prove they keep up.
+func newConcurrencyLimiter(n int) *concurrencyLimiter {
+	if n < 1 {
+		n = 1
+	}
+	metricConcurrencyLimit.Set(1)
+	return &concurrencyLimiter{
+		limit:     1,
+		changed:   make(chan struct{}),
+		max:       n,
+		staticMax: n,
+	}
+}
+
+// setMax changes the highest the limit can grow to, within staticMax. A lower
+// maximum applies right away, scans above it finish but aren't replaced.
+func (cl *concurrencyLimiter) setMax(n int) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	if n > cl.staticMax {
+		n = cl.staticMax
+	}
+	cl.max = n
+	if cl.limit > n {
+		cl.limit = n
+		metricConcurrencyLimit.Set(float64(cl.limit))
+	}
+}
+
+// acquire waits for a free slot and takes it. It returns false if the context
+// is done first.
+func (cl *concurrencyLimiter) acquire(ctx context.Context) bool {
+	cl.mu.Lock()
+	for cl.active >= cl.limit {
+		changed := cl.changed
+		cl.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+		cl.mu.Lock()
+	}
+	cl.active++
+	if cl.active > cl.peak {
+		cl.peak = cl.active
+	}
+	metricActiveScans.Set(float64(cl.active))
+	cl.mu.Unlock()
+	return true
+}
+
+// release frees a slot taken with acquire.
+func (cl *concurrencyLimiter) release() {
+	cl.mu.Lock()
+	cl.active--
+	metricActiveScans.Set(float64(cl.active))
+	cl.notify()
+	cl.mu.Unlock()
+}
+
+// adjust updates the limit based on the load dependencies were under since
+// the previous adjustment. It returns the new limit and whether it decreased.
+func (cl *concurrencyLimiter) adjust(load clamav.LoadStats) (int, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	prev := cl.limit
+	saturated := load.Scans > 0 && load.ClamdLatency > ClamdLatencyTarget
+	if load.PortalRequests > 0 && float64(load.PortalErrors)/float64(load.PortalRequests) > PortalErrorRateTarget {
+		saturated = true
+	}
+	switch {
+	case saturated && cl.limit > 1:
+		cl.limit /= 2
+	case !saturated && load.Scans > 0 && cl.peak >= cl.limit && cl.limit < cl.max:
+		// Only grow while we use the whole limit, otherwise an idle
+		// queue would let it grow unchecked.
+		cl.limit++
+		cl.notify()
+	}
+	cl.peak = cl.active
+	metricConcurrencyLimit.Set(float64(cl.limit))
+	return cl.limit, cl.limit < prev
+}
+
+// notify wakes up the workers waiting for a slot. The caller must hold the
+// lock.
+func (cl *concurrencyLimiter) notify() {
+	close(cl.changed)
+	cl.changed = make(chan struct{})
+}
+
+// threadedAdjustConcurrency adjusts the number of concurrent scans to the
+// load of clamd and the portal until the scanner's context is done.
+func (s *Scanner) threadedAdjustConcurrency() {
+	ticker := database.Clock.NewTicker(concurrencyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.staticCtx.Done():
+			return
+		case <-ticker.C():
+		}
+		load := s.staticClam.TakeLoadStats()
+		limit, decreased := s.staticConcurrency.adjust(load)
+		if decreased {
+			s.staticSampler.Infof("concurrency_decreased", "Decreased concurrent scans to %d: average clamd latency %s, %d of %d portal requests failed",
+				limit, load.ClamdLatency, load.PortalErrors, load.PortalRequests)
+		}
+	}
+}
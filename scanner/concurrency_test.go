@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+)
+
+// TestConcurrencyLimiter ensures the limit grows by one while the scans use
+// all of it and dependencies keep up, and it's halved when they don't.
+func TestConcurrencyLimiter(t *testing.T) {
+	cl := newConcurrencyLimiter(4)
+	ctx := context.Background()
+	healthy := clamav.LoadStats{Scans: 10, ClamdLatency: time.Millisecond, PortalRequests: 10}
+
+	// The limit doesn't grow while it's not used.
+	if limit, _ := cl.adjust(healthy); limit != 1 {
+		t.Fatalf("Expected the limit to stay at 1, got %d", limit)
+	}
+	for i := 2; i <= 4; i++ {
+		for j := 0; j < i-1; j++ {
+			if !cl.acquire(ctx) {
+				t.Fatal("Failed to acquire a slot")
+			}
+		}
+		if limit, decreased := cl.adjust(healthy); limit != i || decreased {
+			t.Fatalf("Expected the limit to grow to %d, got %d", i, limit)
+		}
+		for j := 0; j < i-1; j++ {
+			cl.release()
+		}
+	}
+	// The limit doesn't grow past the maximum.
+	for i := 0; i < 4; i++ {
+		cl.acquire(ctx)
+	}
+	if limit, _ := cl.adjust(healthy); limit != 4 {
+		t.Fatalf("Expected the limit to stay at 4, got %d", limit)
+	}
+
+	// Slots are only handed out below the limit.
+	acquired := make(chan bool)
+	go func() { acquired <- cl.acquire(ctx) }()
+	select {
+	case <-acquired:
+		t.Fatal("Expected to wait for a slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cl.release()
+	if !<-acquired {
+		t.Fatal("Expected to get the released slot")
+	}
+
+	// The limit is halved when clamd is slow or the portal fails, and a
+	// cancelled context stops the wait.
+	slow := clamav.LoadStats{Scans: 1, ClamdLatency: 2 * ClamdLatencyTarget}
+	if limit, decreased := cl.adjust(slow); limit != 2 || !decreased {
+		t.Fatalf("Expected the limit to halve to 2, got %d", limit)
+	}
+	failing := clamav.LoadStats{PortalRequests: 10, PortalErrors: 5}
+	if limit, decreased := cl.adjust(failing); limit != 1 || !decreased {
+		t.Fatalf("Expected the limit to halve to 1, got %d", limit)
+	}
+	if limit, decreased := cl.adjust(failing); limit != 1 || decreased {
+		t.Fatalf("Expected the limit to stay at 1, got %d", limit)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if cl.acquire(cancelled) {
+		t.Fatal("Expected no slot with a cancelled context")
+	}
+}
@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/notify"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// digestName is the name of the notifications which carry digests.
+	digestName = "digest"
+	// digestSignaturesLimit is the maximum number of signatures a digest
+	// lists.
+	digestSignaturesLimit = 20
+)
+
+// Digest summarizes the scanner's activity within a period of time, so the
+// abuse team doesn't need to watch the dashboards. Infected counts the new
+// infections, Signatures breaks the most frequent of them down by signature
+// and Failures counts the skylinks whose latest scan attempt failed, by the
+// kind of error. Queue describes the state of the queue, including the
+// detections pending review, at the time the digest was built.
+type Digest struct {
+	From       time.Time                 `json:"from"`
+	To         time.Time                 `json:"to"`
+	Scanned    int64                     `json:"scanned"`
+	Infected   int64                     `json:"infected"`
+	Signatures []database.SignatureCount `json:"signatures"`
+	Failures   []database.FailureCount   `json:"failures"`
+	Queue      database.QueueDepth       `json:"queue"`
+	// OldestQueued is the submission time of the oldest skylink waiting to
+	// be scanned. It's zero if the queue is empty.
+	OldestQueued time.Time `json:"oldestQueued"`
+}
+
+// StartDigests launches a background thread which sends a digest of the
+// preceding DigestInterval to the given notifier every DigestInterval.
+func (s *Scanner) StartDigests(n notify.Notifier) error {
+	if n == nil {
+		return errors.New("invalid notifier provided")
+	}
+	if DigestInterval <= 0 {
+		return errors.New("digests are disabled")
+	}
+	go func() {
+		s.loopStarted(loopDigest)
+		defer s.loopStopped(loopDigest)
+		ticker := database.Clock.NewTicker(DigestInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.staticCtx.Done():
+				return
+			case <-ticker.C():
+			}
+			err := s.SendDigest(n)
+			s.loopIteration(loopDigest, err)
+			if err != nil {
+				s.staticLogger.Warnln(errors.AddContext(err, "failed to send digest"))
+			}
+		}
+	}()
+	return nil
+}
+
+// SendDigest sends a digest of the preceding DigestInterval to the given
+// notifier.
+func (s *Scanner) SendDigest(n notify.Notifier) error {
+	to := database.Clock.Now().UTC()
+	d, err := s.BuildDigest(to.Add(-DigestInterval), to)
+	if err != nil {
+		return err
+	}
+	return n.Notify(s.staticCtx, notify.Alert{
+		Name:      digestName,
+		Severity:  notify.SeverityInfo,
+		Message:   d.String(),
+		Details:   d,
+		Timestamp: to,
+	})
+}
+
+// BuildDigest summarizes the scanner's activity between the given times.
+func (s *Scanner) BuildDigest(from, to time.Time) (*Digest, error) {
+	d := &Digest{From: from, To: to}
+	var err error
+	d.Scanned, d.Infected, err = s.staticDB.InfectionCounts(s.staticCtx, from)
+	if err != nil {
+		return nil, err
+	}
+	d.Signatures, err = s.staticDB.SignatureStats(s.staticCtx, from, to, digestSignaturesLimit)
+	if err != nil {
+		return nil, err
+	}
+	d.Failures, err = s.staticDB.ScanFailures(s.staticCtx, from)
+	if err != nil {
+		return nil, err
+	}
+	qd, err := s.staticDB.QueueDepth(s.staticCtx)
+	if err != nil {
+		return nil, err
+	}
+	d.Queue = *qd
+	d.OldestQueued, err = s.staticDB.OldestQueued(s.staticCtx)
+	if err != nil && !errors.Contains(err, database.ErrNoDocumentsFound) {
+		return nil, err
+	}
+	return d, nil
+}
+
+// String formats the digest as plain text, e.g. for emails.
+func (d *Digest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Scanner digest from %s to %s\n\n", d.From.Format(time.RFC3339), d.To.Format(time.RFC3339))
+	fmt.Fprintf(&b, "New infections: %d out of %d scanned skylinks\n", d.Infected, d.Scanned)
+	for _, sc := range d.Signatures {
+		fmt.Fprintf(&b, "  %s: %d\n", sc.Signature, sc.Count)
+	}
+	var failed int
+	for _, fc := range d.Failures {
+		failed += fc.Count
+	}
+	fmt.Fprintf(&b, "\nFailed scans: %d\n", failed)
+	for _, fc := range d.Failures {
+		fmt.Fprintf(&b, "  %s: %d\n", fc.Kind, fc.Count)
+	}
+	fmt.Fprintf(&b, "\nPending reviews: %d\n", d.Queue.PendingReview)
+	fmt.Fprintf(&b, "\nQueue: %d new, %d scanning, %d unreported, %d records in total\n", d.Queue.New, d.Queue.Scanning, d.Queue.Unreported, d.Queue.Total)
+	if !d.OldestQueued.IsZero() {
+		fmt.Fprintf(&b, "The oldest queued skylink has been waiting for %s\n", d.To.Sub(d.OldestQueued).Truncate(time.Second))
+	}
+	return b.String()
+}
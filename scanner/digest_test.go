@@ -0,0 +1,51 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+// TestDigestString ensures digests are formatted with all of their sections.
+func TestDigestString(t *testing.T) {
+	to := time.Date(2022, 3, 2, 0, 0, 0, 0, time.UTC)
+	d := Digest{
+		From:     to.Add(-24 * time.Hour),
+		To:       to,
+		Scanned:  100,
+		Infected: 3,
+		Signatures: []database.SignatureCount{
+			{Signature: "Win.Test.EICAR_HDB-1", Count: 2},
+			{Signature: "PUA.Win.Packer", Count: 1},
+		},
+		Failures: []database.FailureCount{
+			{Kind: "portal_timeout", Count: 4},
+			{Kind: "clamav_unreachable", Count: 1},
+		},
+		Queue:        database.QueueDepth{New: 7, Scanning: 1, Unreported: 2, PendingReview: 5, Total: 1000},
+		OldestQueued: to.Add(-90 * time.Minute),
+	}
+	s := d.String()
+	for _, want := range []string{
+		"from 2022-03-01T00:00:00Z to 2022-03-02T00:00:00Z",
+		"New infections: 3 out of 100 scanned skylinks",
+		"  Win.Test.EICAR_HDB-1: 2\n",
+		"Failed scans: 5",
+		"  portal_timeout: 4\n",
+		"Pending reviews: 5",
+		"Queue: 7 new, 1 scanning, 2 unreported, 1000 records in total",
+		"waiting for 1h30m0s",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("Expected the digest to contain '%s', got:\n%s", want, s)
+		}
+	}
+
+	// An empty queue has no oldest skylink.
+	d.OldestQueued = time.Time{}
+	if s = d.String(); strings.Contains(s, "waiting for") {
+		t.Fatalf("Expected no queue age, got:\n%s", s)
+	}
+}
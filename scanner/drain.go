@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/skynet-accounts/build"
+)
+
+var (
+	// DrainThreshold is the number of skylinks waiting to be scanned above
+	// which we drain the backlog: scans can grow up to
+	// DrainMaxConcurrentScans and infected skylinks are reported more often.
+	// We go back to normal once the queue is down to half the threshold.
+	// Zero disables draining.
+	// Set according to the MALWARE_SCANNER_DRAIN_THRESHOLD env var.
+	DrainThreshold int64
+	// DrainMaxConcurrentScans is the maximum number of concurrent scans
+	// while we drain a backlog. It's never lower than MaxConcurrentScans.
+	// Set according to the MALWARE_SCANNER_DRAIN_MAX_CONCURRENT_SCANS env var.
+	DrainMaxConcurrentScans = 8
+
+	// drainCheckInterval is how often we check the depth of the queue to
+	// start or stop draining.
+	drainCheckInterval = build.Select(
+		build.Var{
+			Dev:      10 * time.Second,
+			Testing:  50 * time.Millisecond,
+			Standard: time.Minute,
+		},
+	).(time.Duration)
+	// sleepBetweenReportsDraining replaces sleepBetweenReports while we
+	// drain a backlog, which usually holds more infected skylinks than
+	// usual.
+	sleepBetweenReportsDraining = build.Select(
+		build.Var{
+			Dev:      10 * time.Second,
+			Testing:  100 * time.Millisecond,
+			Standard: time.Minute,
+		},
+	).(time.Duration)
+)
+
+// maxConcurrentScans returns the highest number of concurrent scans we might
+// run, which is the number of scanning workers we start.
+func maxConcurrentScans() int {
+	if DrainThreshold > 0 && DrainMaxConcurrentScans > MaxConcurrentScans {
+		return DrainMaxConcurrentScans
+	}
+	return MaxConcurrentScans
+}
+
+// Draining returns whether we're draining a backlog.
+func (s *Scanner) Draining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// threadedDrain starts and stops draining the backlog based on the depth of
+// the queue until the scanner's context is done.
+func (s *Scanner) threadedDrain() {
+	s.loopStarted(loopDrain)
+	defer s.loopStopped(loopDrain)
+	ticker := database.Clock.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for {
+		qd, err := s.staticDB.QueueDepth(s.staticCtx)
+		s.loopIteration(loopDrain, err)
+		if err != nil {
+			s.staticSampler.Warnf("drain_check_failed", "failed to check the queue depth for draining: %s", err)
+		} else {
+			s.updateDrain(qd.New)
+		}
+		select {
+		case <-s.staticCtx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// updateDrain starts draining when the given queue depth exceeds
+// DrainThreshold and stops once it's down to half of it. It returns whether
+// we're draining.
+func (s *Scanner) updateDrain(depth int64) bool {
+	s.mu.Lock()
+	prev := s.draining
+	switch {
+	case !s.draining && depth > DrainThreshold:
+		s.draining = true
+	case s.draining && depth <= DrainThreshold/2:
+		s.draining = false
+	}
+	draining := s.draining
+	s.mu.Unlock()
+	if draining == prev {
+		return draining
+	}
+	if draining {
+		s.staticConcurrency.setMax(maxConcurrentScans())
+		metricDraining.Set(1)
+		s.staticLogger.Infof("Draining a backlog of %d skylinks with up to %d concurrent scans", depth, maxConcurrentScans())
+	} else {
+		s.staticConcurrency.setMax(MaxConcurrentScans)
+		metricDraining.Set(0)
+		s.staticLogger.Infof("Drained the backlog down to %d skylinks, back to up to %d concurrent scans", depth, MaxConcurrentScans)
+	}
+	return draining
+}
+
+// reportSleep returns how long the reporting loop sleeps between sweeps.
+func (s *Scanner) reportSleep() time.Duration {
+	if s.Draining() {
+		return sleepBetweenReportsDraining
+	}
+	return sleepBetweenReports
+}
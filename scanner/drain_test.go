@@ -0,0 +1,42 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestUpdateDrain ensures we drain a backlog with more concurrent scans once
+// the queue exceeds the threshold, until it's down to half of it.
+func TestUpdateDrain(t *testing.T) {
+	defer func(n int, threshold int64, drainMax int) {
+		MaxConcurrentScans, DrainThreshold, DrainMaxConcurrentScans = n, threshold, drainMax
+	}(MaxConcurrentScans, DrainThreshold, DrainMaxConcurrentScans)
+	MaxConcurrentScans, DrainThreshold, DrainMaxConcurrentScans = 2, 100, 6
+
+	s := &Scanner{
+		loops:             make(map[string]*LoopState),
+		staticConcurrency: newConcurrencyLimiter(maxConcurrentScans()),
+		staticLogger:      logrus.New(),
+	}
+	cl := s.staticConcurrency
+	cl.setMax(MaxConcurrentScans)
+	if cl.staticMax != 6 || cl.max != 2 {
+		t.Fatalf("Expected 6 workers scanning up to 2 at a time, got %d and %d", cl.staticMax, cl.max)
+	}
+
+	if s.updateDrain(100) || s.reportSleep() != sleepBetweenReports {
+		t.Fatal("Expected no draining at the threshold")
+	}
+	if !s.updateDrain(101) || cl.max != 6 || s.reportSleep() != sleepBetweenReportsDraining || !s.State().Draining {
+		t.Fatalf("Expected draining with up to 6 scans, got up to %d", cl.max)
+	}
+	if !s.updateDrain(51) {
+		t.Fatal("Expected draining until the queue is down to half the threshold")
+	}
+	// Going back to normal lowers the limit right away.
+	cl.limit = 5
+	if s.updateDrain(50) || cl.max != 2 || cl.limit != 2 {
+		t.Fatalf("Expected to stop draining with a limit of 2, got %d", cl.limit)
+	}
+}
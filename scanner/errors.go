@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"context"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// ErrKindPortalNotFound marks failures caused by the portal not finding
+	// the content.
+	ErrKindPortalNotFound = "portal_not_found"
+	// ErrKindPortalGone marks failures caused by the portal responding that
+	// the content was deleted.
+	ErrKindPortalGone = "portal_gone"
+	// ErrKindPortalRateLimited marks failures caused by the portal rate
+	// limiting us.
+	ErrKindPortalRateLimited = "portal_rate_limited"
+	// ErrKindPortalServerError marks failures caused by the portal responding
+	// with a 5xx status code.
+	ErrKindPortalServerError = "portal_server_error"
+	// ErrKindPortalError marks failures caused by any other unexpected portal
+	// response.
+	ErrKindPortalError = "portal_error"
+	// ErrKindTimeout marks failures caused by timeouts.
+	ErrKindTimeout = "timeout"
+	// ErrKindClamd marks failures caused by ClamAV.
+	ErrKindClamd = "clamd_error"
+	// ErrKindHostileContent marks failures caused by content crafted to
+	// exhaust our resources, like decompression bombs.
+	ErrKindHostileContent = "hostile_content"
+	// ErrKindDB marks failures caused by the database.
+	ErrKindDB = "db_error"
+	// ErrKindUnknown marks all failures we can't classify.
+	ErrKindUnknown = "unknown"
+)
+
+// classifyError returns the kind of failure described by the given error.
+func classifyError(err error) string {
+	switch {
+	case errors.Contains(err, clamav.ErrPortalNotFound):
+		return ErrKindPortalNotFound
+	case errors.Contains(err, clamav.ErrPortalGone):
+		return ErrKindPortalGone
+	case errors.Contains(err, clamav.ErrPortalRateLimited):
+		return ErrKindPortalRateLimited
+	case errors.Contains(err, clamav.ErrPortalServerError):
+		return ErrKindPortalServerError
+	case errors.Contains(err, clamav.ErrPortalUnexpectedStatus):
+		return ErrKindPortalError
+	case errors.Contains(err, clamav.ErrTimeout), errors.Contains(err, context.DeadlineExceeded):
+		return ErrKindTimeout
+	case errors.Contains(err, clamav.ErrClamd):
+		return ErrKindClamd
+	case errors.Contains(err, clamav.ErrDecompressionBomb):
+		return ErrKindHostileContent
+	}
+	return ErrKindUnknown
+}
@@ -0,0 +1,34 @@
+package scanner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestClassifyError ensures classifyError recognises all kinds of failures,
+// even when they're wrapped in context.
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{clamav.ErrPortalNotFound, ErrKindPortalNotFound},
+		{clamav.ErrPortalGone, ErrKindPortalGone},
+		{errors.AddContext(clamav.ErrPortalRateLimited, "backing off from https://siasky.net"), ErrKindPortalRateLimited},
+		{errors.AddContext(clamav.ErrPortalServerError, "status code 502"), ErrKindPortalServerError},
+		{errors.AddContext(clamav.ErrPortalUnexpectedStatus, "status code 403"), ErrKindPortalError},
+		{errors.Extend(errors.New("i/o timeout"), clamav.ErrTimeout), ErrKindTimeout},
+		{errors.AddContext(context.DeadlineExceeded, "scanning"), ErrKindTimeout},
+		{errors.Extend(errors.New("connection refused"), clamav.ErrClamd), ErrKindClamd},
+		{errors.AddContext(clamav.ErrDecompressionBomb, "failed to download the content"), ErrKindHostileContent},
+		{errors.New("something else"), ErrKindUnknown},
+	}
+	for _, tt := range tests {
+		if kind := classifyError(tt.err); kind != tt.expected {
+			t.Fatalf("Error '%s': expected kind %s, got %s", tt.err, tt.expected, kind)
+		}
+	}
+}
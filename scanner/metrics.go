@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"github.com/SkynetLabs/malware-scanner/metrics"
+)
+
+var (
+	// reportDurationBuckets are the histogram buckets, in seconds, we use for
+	// the duration of calls to blocker.
+	reportDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	// latencyBuckets are the histogram buckets, in seconds, we use for the
+	// time it takes a skylink to go from submission to verdict.
+	latencyBuckets = []float64{1, 5, 15, 30, 60, 300, 600, 1800, 3600, 7200, 21600, 86400}
+
+	// metricScannedRecords counts the skylinks which received a verdict.
+	metricScannedRecords = metrics.NewCounter("scanner_scanned_records_total", "Number of skylinks scanned.")
+	// metricScannedBytes counts the bytes streamed to ClamAV.
+	metricScannedBytes = metrics.NewCounter("scanner_scanned_bytes_total", "Number of bytes scanned.")
+	// metricVerdictLatency tracks the time between a skylink's submission and
+	// its verdict.
+	metricVerdictLatency = metrics.NewHistogram("scanner_verdict_latency_seconds", "Time from submission to verdict.", latencyBuckets)
+	// metricPeerVerdicts counts the skylinks which received the verdict of a
+	// federated scanner instance instead of being scanned, by whether they're
+	// infected.
+	metricPeerVerdicts = metrics.NewCounterVec("scanner_peer_verdicts_total", "Number of skylinks given a federated scanner's verdict instead of being scanned.", "infected")
+	// metricReplayedScans counts the scans whose verdicts were replayed, by
+	// whether the skylink had a recorded verdict.
+	metricReplayedScans = metrics.NewCounterVec("scanner_replayed_scans_total", "Number of skylinks given a recorded verdict instead of being scanned.", "recorded")
+	// metricCachedVerdicts counts the skylinks which received the cached
+	// verdict of a prior scan of the same content instead of being scanned,
+	// by whether they're infected.
+	metricCachedVerdicts = metrics.NewCounterVec("scanner_cached_verdicts_total", "Number of skylinks given the cached verdict of a prior scan of their content instead of being scanned.", "infected")
+	// metricReusedVerdicts counts the skylinks which kept the verdict they
+	// got with the current signatures instead of being downloaded again.
+	metricReusedVerdicts = metrics.NewCounter("scanner_reused_verdicts_total", "Number of skylinks which kept their verdict instead of being scanned again with the same signatures.")
+	// metricPortalPushes counts the verdicts pushed to the portal by result,
+	// which is either "success", "failure" or "dropped".
+	metricPortalPushes = metrics.NewCounterVec("scanner_portal_pushes_total", "Number of verdicts pushed to the portal by result.", "result")
+	// metricRescans counts the clean skylinks we queued for scanning again
+	// after signature updates.
+	metricRescans = metrics.NewCounter("scanner_rescans_total", "Number of clean skylinks queued for scanning again after signature updates.")
+	// metricBlockerReports counts the reports to blocker by target and
+	// result, which is either "success" or "failure".
+	metricBlockerReports = metrics.NewCounterVec("scanner_blocker_reports_total", "Number of reports to blocker by target and result.", "target", "result")
+	// metricBlockerReportDuration tracks the duration of calls to blocker.
+	metricBlockerReportDuration = metrics.NewHistogram("scanner_blocker_report_duration_seconds", "Duration of calls to blocker.", reportDurationBuckets)
+	// metricUnpins counts the attempts to unpin blocked skylinks by their
+	// result, which is either "success" or "failure".
+	metricUnpins = metrics.NewCounterVec("scanner_unpins_total", "Number of attempts to unpin blocked skylinks by result.", "result")
+	// metricSuppressedDetections counts the detections we ignored because
+	// their signature was withdrawn.
+	metricSuppressedDetections = metrics.NewCounter("scanner_suppressed_detections_total", "Number of detections ignored because their signature was withdrawn.")
+	// metricRollbacks counts the rollbacks of skylinks blocked under
+	// withdrawn signatures by result, which is either "clean" or "infected",
+	// if the skylink is still infected under other signatures.
+	metricRollbacks = metrics.NewCounterVec("scanner_rollbacks_total", "Number of rollbacks of skylinks blocked under withdrawn signatures by result.", "result")
+	// metricPolicyActions counts the actions the policy took on detections
+	// by action: "block", "review" or "ignore".
+	metricPolicyActions = metrics.NewCounterVec("scanner_policy_actions_total", "Number of detections by the action the policy took on them.", "action")
+	// metricRetentionDeletions counts the records we removed once they
+	// outlived their retention, by verdict class: "infected", "clean" or
+	// "failed".
+	metricRetentionDeletions = metrics.NewCounterVec("scanner_retention_deleted_total", "Number of records removed once they outlived their retention by verdict class.", "class")
+	// metricReportLag tracks the time between detecting an infected skylink
+	// and successfully reporting it to blocker.
+	metricReportLag = metrics.NewHistogram("scanner_report_lag_seconds", "Time from detection to a successful report to blocker.", latencyBuckets)
+	// metricUnreported tracks the number of infected skylinks which haven't
+	// been reported to blocker yet.
+	metricUnreported = metrics.NewGauge("scanner_unreported_records", "Number of infected skylinks not yet reported to blocker.")
+	// metricUnreportedOldestAge tracks the age of the oldest infected skylink
+	// which hasn't been reported to blocker yet.
+	metricUnreportedOldestAge = metrics.NewGauge("scanner_unreported_oldest_age_seconds", "Time since the oldest unreported infected skylink was detected.")
+	// metricDraining is one while we drain a backlog and zero otherwise.
+	metricDraining = metrics.NewGauge("scanner_draining", "Whether the scanner is draining a backlog.")
+	// metricQueueDepth tracks the number of skylinks by their status,
+	// excluding the complete ones.
+	metricQueueDepth = metrics.NewGaugeVec("scanner_queue_depth", "Number of skylinks waiting to be scanned, being scanned, waiting to be reported or waiting for review, by status.", "status")
+	// metricRecords tracks the estimated number of skylink records.
+	metricRecords = metrics.NewGauge("scanner_records", "Estimated number of skylink records, including scanned ones.")
+
+	// metricActiveWorkers tracks the number of running background goroutines
+	// by the loop they run.
+	metricActiveWorkers = metrics.NewGaugeVec("scanner_active_workers", "Number of running background workers by loop.", "loop")
+
+	// metricPrefetchedScans counts the skylinks we locked and started to
+	// download while the previous scan was still running.
+	metricPrefetchedScans = metrics.NewCounter("scanner_prefetched_scans_total", "Number of skylinks locked and downloaded ahead of their scan.")
+	// metricConcurrencyLimit tracks the number of skylinks we currently
+	// allow to be scanned at the same time.
+	metricConcurrencyLimit = metrics.NewGauge("scanner_concurrency_limit", "Number of skylinks allowed to be scanned at the same time.")
+	// metricActiveScans tracks the number of skylinks being scanned.
+	metricActiveScans = metrics.NewGauge("scanner_active_scans", "Number of skylinks being scanned.")
+	// metricGoneRecords counts the skylinks we gave up on because their
+	// content doesn't exist anymore.
+	metricGoneRecords = metrics.NewCounter("scanner_gone_records_total", "Number of skylinks marked as gone because their content doesn't exist anymore.")
+
+	// metricScanFailures counts failed scans by the kind of failure.
+	metricScanFailures = metrics.NewCounterVec("scanner_scan_failures_total", "Number of failed scans by kind of failure.", "kind")
+)
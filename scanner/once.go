@@ -0,0 +1,46 @@
+package scanner
+
+import "gitlab.com/NebulousLabs/errors"
+
+// RunOnce runs a single iteration of the scanning loop synchronously: it
+// sweeps the queue and scans the next skylink, or the next batch of them if
+// ScanBatchSize is more than one. It doesn't prefetch and it runs even while
+// the scanner is paused, so tests and one-off runs can drive the pipeline
+// without waiting on the background loops. It returns
+// database.ErrNoDocumentsFound once the queue is empty. The scan is aborted
+// when the scanner's context is done.
+func (s *Scanner) RunOnce() error {
+	abort := make(chan bool)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.staticCtx.Done():
+			close(abort)
+		case <-done:
+		}
+	}()
+	return s.scanOnce(abort)
+}
+
+// scanOnce sweeps the queue and scans the next skylink, or the next batch of
+// them.
+func (s *Scanner) scanOnce(abort chan bool) error {
+	if ScanBatchSize > 1 && s.currentReplay() == nil {
+		return s.SweepAndScanBatch(abort)
+	}
+	return s.SweepAndScan(abort)
+}
+
+// ReportOnce runs a single iteration of the reporting loop synchronously: it
+// reports the infected skylinks which haven't been reported to all blocker
+// targets yet, finishes the rollbacks of skylinks whose signature was
+// withdrawn and refreshes the queue metrics. It returns the
+// number of skylinks which are now reported to all targets.
+func (s *Scanner) ReportOnce() (int, error) {
+	n, err := s.SweepAndBlock()
+	_, errRollback := s.SweepRollbacks()
+	s.updateUnreportedMetrics()
+	s.updateQueueMetrics()
+	return n, errors.Compose(err, errRollback)
+}
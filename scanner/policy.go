@@ -0,0 +1,202 @@
+package scanner
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// PolicyInput describes a detection to the policy. Engines is the
+	// number of scanning engines which detected the content, e.g. our
+	// ClamAV and a federated scanner instance. ContentType is the media
+	// type the portal served the content with.
+	PolicyInput struct {
+		Signature   string
+		Engines     int
+		Size        uint64
+		ContentType string
+	}
+
+	// PolicyRule adds its Score to the severity of the detections it
+	// matches. Signature is a glob pattern, e.g. "PUA.*", matched against
+	// the name of the signature, and ContentType one matched against the
+	// content's media type, e.g. "image/*". The other conditions are lower
+	// and upper bounds. Empty conditions match every detection.
+	PolicyRule struct {
+		Signature   string `json:"signature,omitempty"`
+		ContentType string `json:"contentType,omitempty"`
+		MinEngines  int    `json:"minEngines,omitempty"`
+		MinSize     uint64 `json:"minSize,omitempty"`
+		MaxSize     uint64 `json:"maxSize,omitempty"`
+		Score       int    `json:"score"`
+	}
+
+	// Policy decides what happens to detections. Every detection starts
+	// with the BaseScore as its severity and gets the scores of all the
+	// rules it matches added. Detections whose severity reaches BlockAt
+	// are reported to blocker, those which reach ReviewAt are held back
+	// for review and the others are ignored. Without a policy, every
+	// detection is reported.
+	Policy struct {
+		BaseScore int          `json:"baseScore"`
+		BlockAt   int          `json:"blockAt"`
+		ReviewAt  int          `json:"reviewAt"`
+		Rules     []PolicyRule `json:"rules"`
+	}
+)
+
+// LoadPolicy reads a policy from the given JSON object, e.g.
+// `{"baseScore": 50, "blockAt": 50, "reviewAt": 20, "rules": [{"signature":
+// "PUA.*", "score": -40}]}`.
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid policy")
+	}
+	if p.ReviewAt > p.BlockAt {
+		return nil, errors.New("invalid policy: reviewAt is above blockAt")
+	}
+	for _, r := range p.Rules {
+		if _, err = path.Match(r.Signature, ""); err != nil {
+			return nil, errors.AddContext(err, "invalid signature pattern "+r.Signature)
+		}
+		if _, err = path.Match(r.ContentType, ""); err != nil {
+			return nil, errors.AddContext(err, "invalid content type pattern "+r.ContentType)
+		}
+		if r.MaxSize > 0 && r.MinSize > r.MaxSize {
+			return nil, errors.New("invalid size range in rule " + r.Signature)
+		}
+	}
+	return &p, nil
+}
+
+// LoadPolicyFile reads a policy from the given file, see LoadPolicy.
+func LoadPolicyFile(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to open policy")
+	}
+	defer f.Close()
+	return LoadPolicy(f)
+}
+
+// Evaluate returns the severity of the given detection and the action the
+// policy takes on it.
+func (p *Policy) Evaluate(in PolicyInput) (int, string) {
+	score := p.BaseScore
+	for _, r := range p.Rules {
+		if r.matches(in) {
+			score += r.Score
+		}
+	}
+	switch {
+	case score >= p.BlockAt:
+		return score, database.PolicyActionBlock
+	case score >= p.ReviewAt:
+		return score, database.PolicyActionReview
+	default:
+		return score, database.PolicyActionIgnore
+	}
+}
+
+// matches returns whether the rule applies to the given detection.
+func (r PolicyRule) matches(in PolicyInput) bool {
+	if r.Signature != "" {
+		if ok, _ := path.Match(r.Signature, in.Signature); !ok {
+			return false
+		}
+	}
+	if r.ContentType != "" {
+		if ok, _ := path.Match(r.ContentType, in.ContentType); !ok {
+			return false
+		}
+	}
+	if in.Engines < r.MinEngines {
+		return false
+	}
+	if in.Size < r.MinSize || (r.MaxSize > 0 && in.Size > r.MaxSize) {
+		return false
+	}
+	return true
+}
+
+// SetPolicy makes the scanner decide what happens to its detections by the
+// given policy.
+func (s *Scanner) SetPolicy(p *Policy) error {
+	if p == nil {
+		return errors.New("invalid policy provided")
+	}
+	s.mu.Lock()
+	s.policy = p
+	s.mu.Unlock()
+	return nil
+}
+
+// currentPolicy returns the scanner's policy, if any.
+func (s *Scanner) currentPolicy() *Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+// applyPolicy scores the verdict of the given skylink and sets its status by
+// the action the policy takes on it. Detections which are held back for
+// review keep their skylink, so they can still be reported once they're
+// approved. Ignored detections are completed like clean skylinks, but stay
+// infected. In DryRun mode, the detections the policy would block are held
+// back for review too.
+func (s *Scanner) applyPolicy(sl *database.Skylink) {
+	sl.Severity = 0
+	sl.PolicyAction = ""
+	p := s.currentPolicy()
+	if !sl.Infected || (p == nil && !DryRun) {
+		return
+	}
+	sl.PolicyAction = database.PolicyActionBlock
+	if p != nil {
+		sl.Severity, sl.PolicyAction = p.Evaluate(PolicyInput{
+			Signature:   sl.InfectionDescription,
+			Engines:     s.engines(sl),
+			Size:        sl.Size,
+			ContentType: sl.ContentType,
+		})
+	}
+	if DryRun && sl.PolicyAction == database.PolicyActionBlock {
+		sl.PolicyAction = database.PolicyActionReview
+	}
+	metricPolicyActions.With(sl.PolicyAction).Inc()
+	switch sl.PolicyAction {
+	case database.PolicyActionReview:
+		sl.Status = database.SkylinkStatusPendingReview
+	case database.PolicyActionIgnore:
+		sl.Skylink = ""
+		sl.Status = database.SkylinkStatusComplete
+	}
+	if sl.PolicyAction != database.PolicyActionBlock {
+		s.staticLogger.Infof("Not reporting hash %s detected as %s with severity %d: policy action %s", sl.Hash.String(), sl.InfectionDescription, sl.Severity, sl.PolicyAction)
+	}
+}
+
+// engines returns the number of engines which detected the given infected
+// skylink: our ClamAV and, if it agrees, the federated scanner instance which
+// has a verdict for the same content. Verdicts we took from a federated
+// instance only count that one.
+func (s *Scanner) engines(sl *database.Skylink) int {
+	if !Federated || strings.HasPrefix(sl.VerdictSource, "peer:") {
+		return 1
+	}
+	v, err := s.staticDB.PeerVerdict(s.staticCtx, sl.Hash)
+	if err != nil || !v.Infected {
+		return 1
+	}
+	return 2
+}
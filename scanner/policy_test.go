@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+// TestPolicy ensures policies score detections by the rules they match and
+// take the action their score reaches.
+func TestPolicy(t *testing.T) {
+	p, err := LoadPolicy(strings.NewReader(`{
+		"baseScore": 50,
+		"blockAt": 50,
+		"reviewAt": 20,
+		"rules": [
+			{"signature": "PUA.*", "score": -40},
+			{"signature": "Heuristics.*", "score": -20},
+			{"minEngines": 2, "score": 30},
+			{"maxSize": 1024, "score": -10},
+			{"contentType": "image/*", "score": -30}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in       PolicyInput
+		severity int
+		action   string
+	}{
+		{PolicyInput{Signature: "Win.Test.EICAR_HDB-1", Engines: 1, Size: 4096}, 50, database.PolicyActionBlock},
+		{PolicyInput{Signature: "Heuristics.Encrypted.PDF", Engines: 1, Size: 4096}, 30, database.PolicyActionReview},
+		{PolicyInput{Signature: "PUA.Win.Packer", Engines: 1, Size: 4096}, 10, database.PolicyActionIgnore},
+		{PolicyInput{Signature: "PUA.Win.Packer", Engines: 2, Size: 4096}, 40, database.PolicyActionReview},
+		{PolicyInput{Signature: "Win.Test.EICAR_HDB-1", Engines: 1, Size: 68}, 40, database.PolicyActionReview},
+		{PolicyInput{Signature: "Win.Test.EICAR_HDB-1", Engines: 1, Size: 4096, ContentType: "image/png"}, 20, database.PolicyActionReview},
+		{PolicyInput{Signature: "Win.Test.EICAR_HDB-1", Engines: 1, Size: 4096, ContentType: "application/x-msdownload"}, 50, database.PolicyActionBlock},
+	}
+	for _, tt := range tests {
+		severity, action := p.Evaluate(tt.in)
+		if severity != tt.severity || action != tt.action {
+			t.Errorf("Expected %+v to score %d and %s, got %d and %s", tt.in, tt.severity, tt.action, severity, action)
+		}
+	}
+
+	for _, invalid := range []string{
+		`[]`,
+		`{"blockAt": 10, "reviewAt": 20}`,
+		`{"rules": [{"signature": "[", "score": 1}]}`,
+		`{"rules": [{"contentType": "[", "score": 1}]}`,
+		`{"rules": [{"minSize": 10, "maxSize": 1, "score": 1}]}`,
+		`{"rules": [{"engines": 2, "score": 1}]}`,
+	} {
+		if _, err = LoadPolicy(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("Expected an error for %s", invalid)
+		}
+	}
+}
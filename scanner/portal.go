@@ -0,0 +1,166 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// PortalStatusClean marks content which was scanned in full and found
+	// clean.
+	PortalStatusClean = "clean"
+	// PortalStatusInfected marks content which was found infected.
+	PortalStatusInfected = "infected"
+	// PortalStatusScanned marks content which was found clean, but which
+	// ClamAV only scanned in part, e.g. because it's larger than its limits.
+	PortalStatusScanned = "scanned"
+
+	// portalPushTimeout is the timeout of a single push to the portal.
+	portalPushTimeout = 30 * time.Second
+	// portalQueueSize is the number of verdicts we queue for pushing to the
+	// portal before we start dropping them, so a slow portal doesn't slow
+	// down the scanner.
+	portalQueueSize = 1024
+)
+
+type (
+	// PortalPublisher pushes scan verdicts to the portal's skylink metadata
+	// service, so the portal's UI can show users downloading content whether
+	// it was scanned.
+	PortalPublisher struct {
+		staticURL     string
+		staticHeaders http.Header
+		staticClient  *http.Client
+	}
+
+	// PortalVerdict is the verdict we push to the portal. Status is one of
+	// the PortalStatus constants.
+	PortalVerdict struct {
+		Skylink   string    `json:"skylink"`
+		Hash      string    `json:"hash"`
+		Status    string    `json:"status"`
+		Signature string    `json:"signature,omitempty"`
+		ScannedAt time.Time `json:"scannedAt"`
+	}
+)
+
+// NewPortalPublisher returns a publisher which POSTs verdicts as JSON to the
+// given URL, sending the given extra headers, e.g. for authentication.
+func NewPortalPublisher(u string, headers http.Header) (*PortalPublisher, error) {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return nil, errors.New("invalid portal metadata URL: " + u)
+	}
+	return &PortalPublisher{
+		staticURL:     u,
+		staticHeaders: headers,
+		staticClient:  &http.Client{Timeout: portalPushTimeout},
+	}, nil
+}
+
+// Publish pushes the given verdict to the portal.
+func (p *PortalPublisher) Publish(ctx context.Context, v PortalVerdict) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.AddContext(err, "failed to encode verdict")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.staticURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for name, values := range p.staticHeaders {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := p.staticClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return errors.New(fmt.Sprintf("portal failed to accept the verdict. status code %d, body: '%s'", res.StatusCode, string(body)))
+	}
+	return nil
+}
+
+// StartPortalPush launches a background thread which pushes the verdict of
+// every scanned skylink to the portal via the given publisher. Pushes happen
+// asynchronously and aren't retried. If the portal can't keep up, verdicts
+// are dropped.
+func (s *Scanner) StartPortalPush(p *PortalPublisher) error {
+	if p == nil {
+		return errors.New("invalid portal publisher provided")
+	}
+	verdicts := make(chan PortalVerdict, portalQueueSize)
+	s.mu.Lock()
+	s.portalVerdicts = verdicts
+	s.mu.Unlock()
+	go func() {
+		metricActiveWorkers.With("portal").Inc()
+		defer metricActiveWorkers.With("portal").Dec()
+		for {
+			var v PortalVerdict
+			select {
+			case <-s.staticCtx.Done():
+				return
+			case v = <-verdicts:
+			}
+			err := p.Publish(s.staticCtx, v)
+			if err != nil {
+				metricPortalPushes.With("failure").Inc()
+				s.staticSampler.Warnf("portal_push_failed", "failed to push the verdict of hash %s to the portal: %s", v.Hash, err)
+				continue
+			}
+			metricPortalPushes.With("success").Inc()
+		}
+	}()
+	return nil
+}
+
+// pushVerdict queues the verdict of the given scanned skylink for pushing to
+// the portal, if enabled. The skylink is passed separately because clean
+// records no longer hold it.
+func (s *Scanner) pushVerdict(skylink string, sl *database.Skylink) {
+	s.mu.Lock()
+	verdicts := s.portalVerdicts
+	s.mu.Unlock()
+	if verdicts == nil {
+		return
+	}
+	v := portalVerdict(skylink, sl)
+	select {
+	case verdicts <- v:
+	default:
+		metricPortalPushes.With("dropped").Inc()
+		s.staticSampler.Warnf("portal_queue_full", "Portal push queue is full, dropping the verdict of hash %s", v.Hash)
+	}
+}
+
+// portalVerdict returns the verdict of the given scanned skylink.
+func portalVerdict(skylink string, sl *database.Skylink) PortalVerdict {
+	v := PortalVerdict{
+		Skylink:   skylink,
+		Hash:      sl.Hash.String(),
+		Status:    PortalStatusClean,
+		ScannedAt: sl.ScannedAt,
+	}
+	switch {
+	case sl.Infected:
+		v.Status = PortalStatusInfected
+		v.Signature = sl.InfectionDescription
+	case !sl.ScannedAllContent:
+		v.Status = PortalStatusScanned
+	}
+	return v
+}
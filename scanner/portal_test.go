@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestPortalPublisher ensures the publisher pushes verdicts to the portal.
+func TestPortalPublisher(t *testing.T) {
+	defer gock.Off()
+
+	if _, err := NewPortalPublisher("metadata:3100/verdicts", nil); err == nil {
+		t.Fatal("Expected an error for an invalid URL")
+	}
+	p, err := NewPortalPublisher("http://metadata:3100/verdicts", http.Header{"Authorization": {"Bearer secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	skylink := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+	scannedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	v := PortalVerdict{Skylink: skylink, Hash: "aa", Status: PortalStatusClean, ScannedAt: scannedAt}
+
+	gock.New("http://metadata:3100").
+		Post("/verdicts").
+		MatchHeader("Authorization", "Bearer secret").
+		JSON(map[string]interface{}{"skylink": skylink, "hash": "aa", "status": "clean", "scannedAt": scannedAt}).
+		Reply(http.StatusNoContent)
+	if err = p.Publish(context.Background(), v); err != nil {
+		t.Fatal(err)
+	}
+
+	gock.New("http://metadata:3100").
+		Post("/verdicts").
+		Reply(http.StatusBadGateway)
+	err = p.Publish(context.Background(), v)
+	if err == nil || !strings.Contains(err.Error(), "status code 502") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all mocks to be used")
+	}
+}
+
+// TestPortalVerdict ensures we derive the right portal status from a record.
+func TestPortalVerdict(t *testing.T) {
+	tests := []struct {
+		sl     database.Skylink
+		status string
+	}{
+		{database.Skylink{ScannedAllContent: true}, PortalStatusClean},
+		{database.Skylink{}, PortalStatusScanned},
+		{database.Skylink{Infected: true, InfectionDescription: "Eicar-Signature"}, PortalStatusInfected},
+	}
+	for _, tt := range tests {
+		v := portalVerdict("skylink", &tt.sl)
+		if v.Status != tt.status || v.Skylink != "skylink" || v.Signature != tt.sl.InfectionDescription {
+			t.Fatalf("Expected status %s, got %+v", tt.status, v)
+		}
+	}
+}
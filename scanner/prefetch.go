@@ -0,0 +1,76 @@
+package scanner
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+// unlockTimeout is how long we wait for the DB to unlock a prefetched skylink
+// we won't scan. It doesn't depend on the scanner's context, which is usually
+// done by then.
+const unlockTimeout = 5 * time.Second
+
+// Prefetch makes each scanning worker lock its next skylink and start its
+// download while it scans the current one, so it doesn't wait for the DB and
+// the portal between scans. It doesn't apply to batches.
+// Set according to the MALWARE_SCANNER_PREFETCH env var.
+var Prefetch bool
+
+// prefetched is a skylink locked ahead of its scan, along with its download.
+type prefetched struct {
+	sl *database.Skylink
+	dl *clamav.Download
+}
+
+// sweepAndScanPrefetched scans the given prefetched skylink or, if there's
+// none, locks a new one and scans it. While it scans, it prefetches the next
+// skylink, which it returns for the next call. The caller must release the
+// returned skylink if it doesn't scan it.
+func (s *Scanner) sweepAndScanPrefetched(abort chan bool, cur *prefetched) (*prefetched, error) {
+	if cur == nil {
+		sl, err := s.lockNext()
+		if sl == nil || err != nil {
+			return nil, err
+		}
+		cur = &prefetched{sl: sl, dl: s.staticClam.Prefetch(sl.DownloadPath())}
+	}
+	next := make(chan *prefetched, 1)
+	go func() { next <- s.prefetch() }()
+
+	sigVersion := s.currentSignatureVersion()
+	scanStart := database.Clock.Now()
+	res := s.staticClam.ScanDownload(cur.dl, abort)
+	err := s.saveScan(cur.sl, res, sigVersion, database.Clock.Since(scanStart))
+	return <-next, err
+}
+
+// prefetch locks the next skylink and starts its download. It returns nil if
+// there's nothing to scan, including when the skylink got a verdict without
+// being scanned. Errors are left for the next call to sweepAndScanPrefetched
+// to run into, so the scan loop backs off as usual.
+func (s *Scanner) prefetch() *prefetched {
+	sl, err := s.lockNext()
+	if sl == nil || err != nil {
+		return nil
+	}
+	metricPrefetchedScans.Inc()
+	return &prefetched{sl: sl, dl: s.staticClam.Prefetch(sl.DownloadPath())}
+}
+
+// releasePrefetched stops the download of the given prefetched skylink and
+// returns it to the queue.
+func (s *Scanner) releasePrefetched(p *prefetched) {
+	if p == nil {
+		return
+	}
+	_ = p.dl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	err := s.staticDB.SkylinkUnlock(ctx, p.sl)
+	if err != nil {
+		s.staticSampler.Warnf("unlock_prefetched_failed", "failed to unlock prefetched skylink, it will be unlocked once its lock expires: %s", err)
+	}
+}
@@ -0,0 +1,130 @@
+package scanner
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+var (
+	// replayErrors are the errors replayed failures fail with, by the kind
+	// of failure, so they're classified like real ones.
+	replayErrors = map[string]error{
+		ErrKindPortalNotFound:    clamav.ErrPortalNotFound,
+		ErrKindPortalGone:        clamav.ErrPortalGone,
+		ErrKindPortalRateLimited: clamav.ErrPortalRateLimited,
+		ErrKindPortalServerError: clamav.ErrPortalServerError,
+		ErrKindPortalError:       clamav.ErrPortalUnexpectedStatus,
+		ErrKindTimeout:           clamav.ErrTimeout,
+		ErrKindClamd:             clamav.ErrClamd,
+		ErrKindHostileContent:    clamav.ErrDecompressionBomb,
+	}
+)
+
+type (
+	// ReplayVerdict is a recorded verdict. Error fails the scan instead,
+	// with an error of the given kind, e.g. "portal_server_error", so
+	// retries can be exercised too.
+	ReplayVerdict struct {
+		Infected    bool   `json:"infected"`
+		Description string `json:"description,omitempty"`
+		Size        uint64 `json:"size,omitempty"`
+		Error       string `json:"error,omitempty"`
+	}
+
+	// Replay holds recorded verdicts by the hashes of the skylinks' merkle
+	// roots, as in the skylinks' records. While the scanner replays them,
+	// it takes the verdicts of the skylinks it scans from the recording
+	// instead of downloading the skylinks and scanning them with ClamAV.
+	// This makes the verdicts deterministic, e.g. in staging environments
+	// which report to a sandboxed blocker. Skylinks without a recorded
+	// verdict are clean.
+	Replay struct {
+		staticVerdicts map[crypto.Hash]ReplayVerdict
+	}
+)
+
+// LoadReplay reads recorded verdicts from the given JSON object, which maps
+// the hex encoded hashes to their verdicts, e.g.
+// `{"<hash>": {"infected": true, "description": "Win.Test.EICAR_HDB-1"}}`.
+func LoadReplay(r io.Reader) (*Replay, error) {
+	var recorded map[string]ReplayVerdict
+	err := json.NewDecoder(r).Decode(&recorded)
+	if err != nil {
+		return nil, errors.AddContext(err, "invalid recorded verdicts")
+	}
+	verdicts := make(map[crypto.Hash]ReplayVerdict, len(recorded))
+	for s, v := range recorded {
+		var h crypto.Hash
+		if err = h.LoadString(s); err != nil {
+			return nil, errors.AddContext(err, "invalid hash "+s)
+		}
+		if _, ok := replayErrors[v.Error]; !ok && v.Error != "" && v.Error != ErrKindUnknown {
+			return nil, errors.New("invalid error kind " + v.Error + " of hash " + s)
+		}
+		verdicts[h] = v
+	}
+	return &Replay{staticVerdicts: verdicts}, nil
+}
+
+// LoadReplayFile reads recorded verdicts from the given file, see LoadReplay.
+func LoadReplayFile(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to open recorded verdicts")
+	}
+	defer f.Close()
+	return LoadReplay(f)
+}
+
+// Len returns the number of recorded verdicts.
+func (r *Replay) Len() int {
+	return len(r.staticVerdicts)
+}
+
+// scan returns the recorded result of scanning the skylink with the given
+// hash.
+func (r *Replay) scan(h crypto.Hash) clamav.SkylinkScan {
+	v, ok := r.staticVerdicts[h]
+	if !ok {
+		metricReplayedScans.With("false").Inc()
+		return clamav.SkylinkScan{}
+	}
+	metricReplayedScans.With("true").Inc()
+	if v.Error != "" {
+		err, ok := replayErrors[v.Error]
+		if !ok {
+			return clamav.SkylinkScan{Err: errors.New("replayed failure")}
+		}
+		return clamav.SkylinkScan{Err: errors.AddContext(err, "replayed failure")}
+	}
+	return clamav.SkylinkScan{
+		Infected:    v.Infected,
+		Description: v.Description,
+		Size:        v.Size,
+		ScannedSize: v.Size,
+	}
+}
+
+// SetReplay makes the scanner replay the given recorded verdicts instead of
+// scanning skylinks. Batching and prefetching don't apply while it does.
+func (s *Scanner) SetReplay(r *Replay) error {
+	if r == nil {
+		return errors.New("invalid replay provided")
+	}
+	s.mu.Lock()
+	s.replay = r
+	s.mu.Unlock()
+	return nil
+}
+
+// currentReplay returns the verdicts the scanner replays, if any.
+func (s *Scanner) currentReplay() *Replay {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replay
+}
@@ -0,0 +1,52 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+
+	"go.sia.tech/siad/crypto"
+)
+
+// TestReplay ensures we load recorded verdicts and replay them, including
+// failures of every kind.
+func TestReplay(t *testing.T) {
+	infected := crypto.HashBytes([]byte("infected"))
+	failed := crypto.HashBytes([]byte("failed"))
+	unknown := crypto.HashBytes([]byte("unknown"))
+	recording := `{
+		"` + infected.String() + `": {"infected": true, "description": "Win.Test.EICAR_HDB-1", "size": 68},
+		"` + failed.String() + `": {"error": "portal_server_error"},
+		"` + unknown.String() + `": {"error": "unknown"}
+	}`
+	r, err := LoadReplay(strings.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 3 {
+		t.Fatalf("Expected 3 verdicts, got %d", r.Len())
+	}
+	res := r.scan(infected)
+	if !res.Infected || res.Description != "Win.Test.EICAR_HDB-1" || res.Size != 68 || res.ScannedSize != 68 || res.Err != nil {
+		t.Fatalf("Unexpected infected result %+v", res)
+	}
+	res = r.scan(crypto.HashBytes([]byte("clean")))
+	if res.Infected || res.Err != nil {
+		t.Fatalf("Expected skylinks without a recorded verdict to be clean, got %+v", res)
+	}
+	if kind := classifyError(r.scan(failed).Err); kind != ErrKindPortalServerError {
+		t.Fatalf("Expected a failure of kind %s, got %s", ErrKindPortalServerError, kind)
+	}
+	if kind := classifyError(r.scan(unknown).Err); kind != ErrKindUnknown {
+		t.Fatalf("Expected a failure of kind %s, got %s", ErrKindUnknown, kind)
+	}
+
+	for _, invalid := range []string{
+		`[]`,
+		`{"not-a-hash": {"infected": true}}`,
+		`{"` + infected.String() + `": {"error": "gremlins"}}`,
+	} {
+		if _, err = LoadReplay(strings.NewReader(invalid)); err == nil {
+			t.Fatalf("Expected an error for %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/logging"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// BlockerReportWorkers is the number of skylinks we report to blocker in
+// parallel.
+// Set according to the MALWARE_SCANNER_BLOCKER_REPORT_WORKERS env var.
+var BlockerReportWorkers = 4
+
+type (
+	// blockSweep is the state of a single SweepAndBlock, shared by its
+	// workers.
+	blockSweep struct {
+		// failed holds the targets which failed during the sweep. They're
+		// skipped for the rest of it.
+		failed   map[string]bool
+		reported int
+		errs     []error
+		mu       sync.Mutex
+	}
+
+	// reportStore holds the records SweepAndBlock reports and the report
+	// log. It's the DB, except in tests which need it to fail on demand.
+	reportStore interface {
+		UnreportedSkylinks(ctx context.Context) (skylinkCursor, error)
+		SkylinkSaveReports(ctx context.Context, sl *database.Skylink, reported bool) error
+		AppendReportLog(ctx context.Context, e *database.ReportLogEntry) error
+	}
+
+	// skylinkCursor iterates over skylink records, like a mongo.Cursor.
+	skylinkCursor interface {
+		Next(ctx context.Context) bool
+		Decode(v interface{}) error
+		Err() error
+		Close(ctx context.Context) error
+	}
+
+	// dbReportStore is the reportStore of a DB.
+	dbReportStore struct {
+		*database.DB
+	}
+)
+
+// UnreportedSkylinks implements reportStore.
+func (rs dbReportStore) UnreportedSkylinks(ctx context.Context) (skylinkCursor, error) {
+	c, err := rs.DB.UnreportedSkylinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SweepAndBlock scans the database for malicious skylinks that haven't been
+// reported to all blocker targets yet and reports them, BlockerReportWorkers
+// at a time. It doesn't lock the records because it isn't needed. Each target
+// keeps its own retry state: a skylink is only reported to the targets which
+// haven't blocked it yet, and a target which fails is skipped for the rest of
+// the sweep, without holding up the reports to the other targets. A record
+// which fails to decode or update doesn't hold up the others either. It
+// returns the number of skylinks which are now reported to all targets.
+func (s *Scanner) SweepAndBlock() (int, error) {
+	c, err := s.staticReports.UnreportedSkylinks(s.staticCtx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = c.Close(s.staticCtx) }()
+
+	sweep := &blockSweep{failed: make(map[string]bool)}
+	records := make(chan *database.Skylink)
+	workers := BlockerReportWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sl := range records {
+				sweep.done(s.reportSkylink(sl, sweep))
+			}
+		}()
+	}
+	// Keep feeding the workers while there are skylinks to report and
+	// targets to report them to.
+	for sweep.failedTargets() < len(s.staticBlockers) && c.Next(s.staticCtx) {
+		var sl database.Skylink
+		if err := c.Decode(&sl); err != nil {
+			sweep.done(false, errors.AddContext(err, "failed to decode malicious skylink"))
+			continue
+		}
+		records <- &sl
+	}
+	close(records)
+	wg.Wait()
+	if c.Err() != nil {
+		sweep.done(false, errors.AddContext(c.Err(), "failed to fetch malicious skylink from db"))
+	}
+	return sweep.reported, errors.Compose(sweep.errs...)
+}
+
+// reportSkylink reports the given skylink to every target which hasn't
+// blocked it yet and updates its record. It returns whether the skylink is
+// now reported to all targets.
+func (s *Scanner) reportSkylink(sl *database.Skylink, sweep *blockSweep) (bool, error) {
+	if sl.Reports == nil {
+		sl.Reports = make(map[string]*database.BlockerResponse)
+	}
+	var errs []error
+	done := true
+	for _, b := range s.staticBlockers {
+		target := b.Name()
+		if sl.Reports[target].Succeeded() {
+			continue
+		}
+		if sweep.targetFailed(target) {
+			done = false
+			continue
+		}
+		s.staticLogger.Infof("Reporting skylink '%s' as malicious with description '%s' to blocker %s", sl.Skylink, sl.InfectionDescription, target)
+		reportStart := database.Clock.Now()
+		br, err := b.Block(s.staticCtx, sl.Skylink)
+		metricBlockerReportDuration.Observe(database.Clock.Since(reportStart).Seconds())
+		s.trackBlockerResult(target, err)
+		// Keep blocker's response on the record, so operators can see why
+		// the skylink isn't blocked yet.
+		sl.Reports[target] = br
+		if errLog := s.logReport(sl, b, br); errLog != nil {
+			errs = append(errs, errLog)
+		}
+		if err != nil {
+			s.emit(events.TypeFailed, sl, err)
+			sweep.failTarget(target)
+			done = false
+			errs = append(errs, errors.AddContext(err, "blocker "+target+" error"))
+		}
+	}
+	sl.Blocker = sl.Reports[s.staticBlockers[0].Name()]
+	if done && s.staticUnpinner != nil {
+		sl.Unpinned = s.unpin(sl.Skylink)
+	}
+	// Mark the skylink as reported and remove the skylink from the record
+	// once every target blocked it.
+	err := s.staticReports.SkylinkSaveReports(s.staticCtx, sl, done)
+	if err != nil {
+		errs = append(errs, errors.AddContext(err, "failed to update the skylink's status in db"))
+		return false, errors.Compose(errs...)
+	}
+	if !done {
+		return false, errors.Compose(errs...)
+	}
+	s.emit(events.TypeReported, sl, nil)
+	if !sl.ScannedAt.IsZero() {
+		metricReportLag.Observe(database.Clock.Since(sl.ScannedAt).Seconds())
+	}
+	return true, errors.Compose(errs...)
+}
+
+// done records the outcome of reporting a skylink.
+func (bs *blockSweep) done(reported bool, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if reported {
+		bs.reported++
+	}
+	if err != nil {
+		bs.errs = append(bs.errs, err)
+	}
+}
+
+// failTarget skips the given target for the rest of the sweep.
+func (bs *blockSweep) failTarget(target string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.failed[target] = true
+}
+
+// targetFailed returns whether the given target failed during the sweep.
+func (bs *blockSweep) targetFailed(target string) bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.failed[target]
+}
+
+// failedTargets returns the number of targets which failed during the sweep.
+func (bs *blockSweep) failedTargets() int {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return len(bs.failed)
+}
+
+// logReport appends the given report of the skylink to the blocker target and
+// its response to the report log.
+func (s *Scanner) logReport(sl *database.Skylink, b *blocker.Client, br *database.BlockerResponse) error {
+	e := &database.ReportLogEntry{
+		Action:      database.ReportActionBlock,
+		Target:      b.Name(),
+		TargetURL:   b.BaseURL(),
+		SkylinkHash: sl.Hash.String(),
+		Skylink:     sl.Skylink,
+		Description: sl.InfectionDescription,
+		Result:      br.Result,
+		StatusCode:  br.StatusCode,
+		BlockID:     br.BlockID,
+		Error:       br.Error,
+	}
+	if logging.PrivacyMode {
+		e.Skylink = ""
+	}
+	return s.staticReports.AppendReportLog(s.staticCtx, e)
+}
@@ -0,0 +1,264 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/test"
+	"github.com/sirupsen/logrus"
+)
+
+// TestBlockSweep ensures the state of a sweep adds up the outcomes of its
+// workers.
+func TestBlockSweep(t *testing.T) {
+	bs := &blockSweep{failed: make(map[string]bool)}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				bs.done(true, nil)
+				return
+			}
+			bs.failTarget("target")
+			bs.done(false, errors.New("failed"))
+		}(i)
+	}
+	wg.Wait()
+	if bs.reported != 5 || len(bs.errs) != 5 {
+		t.Fatalf("Expected 5 reported skylinks and 5 errors, got %d and %d", bs.reported, len(bs.errs))
+	}
+	if !bs.targetFailed("target") || bs.targetFailed("other") || bs.failedTargets() != 1 {
+		t.Fatal("Expected only the failed target to be skipped")
+	}
+}
+
+// memReportStore is a reportStore in memory, which fails on demand.
+type memReportStore struct {
+	records []*database.Skylink
+	// broken holds the indexes of the records which fail to decode.
+	broken map[int]bool
+	// saveErr fails the updates of the records of the given skylinks.
+	saveErr map[string]error
+	// saved holds the skylinks whose records were updated and whether
+	// they were reported to all targets. Updates keep the reports on the
+	// records, like the DB does.
+	saved map[string]bool
+	log   []*database.ReportLogEntry
+	mu    sync.Mutex
+}
+
+// memCursor iterates over the records of a memReportStore.
+type memCursor struct {
+	rs  *memReportStore
+	pos int
+}
+
+// newMemReportStore returns a store with an unreported record of each of the
+// given skylinks.
+func newMemReportStore(skylinks ...string) *memReportStore {
+	rs := &memReportStore{
+		broken:  make(map[int]bool),
+		saveErr: make(map[string]error),
+		saved:   make(map[string]bool),
+	}
+	for _, sl := range skylinks {
+		rs.records = append(rs.records, &database.Skylink{Skylink: sl, Status: database.SkylinkStatusUnreported, Infected: true})
+	}
+	return rs
+}
+
+// UnreportedSkylinks implements reportStore.
+func (rs *memReportStore) UnreportedSkylinks(context.Context) (skylinkCursor, error) {
+	return &memCursor{rs: rs, pos: -1}, nil
+}
+
+// SkylinkSaveReports implements reportStore.
+func (rs *memReportStore) SkylinkSaveReports(_ context.Context, sl *database.Skylink, reported bool) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err := rs.saveErr[sl.Skylink]; err != nil {
+		return err
+	}
+	rs.saved[sl.Skylink] = reported
+	for _, r := range rs.records {
+		if r.Skylink == sl.Skylink {
+			r.Reports = sl.Reports
+		}
+	}
+	return nil
+}
+
+// AppendReportLog implements reportStore.
+func (rs *memReportStore) AppendReportLog(_ context.Context, e *database.ReportLogEntry) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.log = append(rs.log, e)
+	return nil
+}
+
+// Next implements skylinkCursor.
+func (c *memCursor) Next(context.Context) bool {
+	c.pos++
+	return c.pos < len(c.rs.records)
+}
+
+// Decode implements skylinkCursor.
+func (c *memCursor) Decode(v interface{}) error {
+	c.rs.mu.Lock()
+	defer c.rs.mu.Unlock()
+	if c.rs.broken[c.pos] {
+		return errors.New("invalid size")
+	}
+	sl := *c.rs.records[c.pos]
+	*v.(*database.Skylink) = sl
+	return nil
+}
+
+// Err implements skylinkCursor.
+func (c *memCursor) Err() error {
+	return nil
+}
+
+// Close implements skylinkCursor.
+func (c *memCursor) Close(context.Context) error {
+	return nil
+}
+
+// newSweepScanner returns a scanner which reports the records of the given
+// store to the given mock blocker targets, one record at a time.
+func newSweepScanner(t *testing.T, rs reportStore, targets map[string]*test.MockBlocker) *Scanner {
+	workers := BlockerReportWorkers
+	BlockerReportWorkers = 1
+	t.Cleanup(func() { BlockerReportWorkers = workers })
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	s := &Scanner{
+		blockerFailures: make(map[string]int),
+		staticCtx:       context.Background(),
+		staticReports:   rs,
+		staticEvents:    &events.Emitter{},
+		staticLogger:    logger,
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, err := blocker.New(targets[name].URL, blocker.Options{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.staticBlockers = append(s.staticBlockers, c)
+	}
+	return s
+}
+
+// newMockBlocker starts a mock blocker for the duration of the test.
+func newMockBlocker(t *testing.T) *test.MockBlocker {
+	mb := test.NewMockBlocker()
+	t.Cleanup(mb.Close)
+	return mb
+}
+
+// TestSweepAndBlockDecodeFailure ensures a record which fails to decode
+// doesn't hold up the reports of the others and isn't updated.
+func TestSweepAndBlockDecodeFailure(t *testing.T) {
+	rs := newMemReportStore("a", "broken", "c")
+	rs.broken[1] = true
+	mb := newMockBlocker(t)
+	s := newSweepScanner(t, rs, map[string]*test.MockBlocker{"default": mb})
+
+	n, err := s.SweepAndBlock()
+	if n != 2 || err == nil || !strings.Contains(err.Error(), "failed to decode") {
+		t.Fatalf("Expected 2 reported skylinks and a decoding error, got %d, %v", n, err)
+	}
+	if !rs.saved["a"] || !rs.saved["c"] || mb.Blocks("a") != 1 || mb.Blocks("c") != 1 {
+		t.Fatalf("Expected the other skylinks to be reported, got %v", rs.saved)
+	}
+	if _, ok := rs.saved["broken"]; ok || mb.Blocks("broken") != 0 {
+		t.Fatal("Expected the broken record not to be reported")
+	}
+}
+
+// TestSweepAndBlockRejected ensures a skylink blocker fails to block stays
+// unreported, with blocker's response on its record and in the report log,
+// and the failed target is skipped for the rest of the sweep.
+func TestSweepAndBlockRejected(t *testing.T) {
+	rs := newMemReportStore("a", "b")
+	mb := newMockBlocker(t)
+	s := newSweepScanner(t, rs, map[string]*test.MockBlocker{"default": mb})
+
+	mb.Fail(http.StatusInternalServerError)
+	n, err := s.SweepAndBlock()
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "blocker default error") {
+		t.Fatalf("Expected the rejected report to fail the sweep, got %d, %v", n, err)
+	}
+	if mb.Requests() != 1 {
+		t.Fatalf("Expected the failed target to be skipped, got %d requests", mb.Requests())
+	}
+	if reported, ok := rs.saved["a"]; !ok || reported {
+		t.Fatalf("Expected the rejected skylink to stay unreported, got %v", rs.saved)
+	}
+	if len(rs.log) != 1 || rs.log[0].Result != database.BlockerResultFailed || rs.log[0].StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected the rejection in the report log, got %+v", rs.log)
+	}
+}
+
+// TestSweepAndBlockPartialFailure ensures a skylink which only some targets
+// block stays unreported, keeps the reports of those which blocked it and is
+// only reported to the others in the next sweep.
+func TestSweepAndBlockPartialFailure(t *testing.T) {
+	rs := newMemReportStore("a")
+	good, bad := newMockBlocker(t), newMockBlocker(t)
+	s := newSweepScanner(t, rs, map[string]*test.MockBlocker{"good": good, "bad": bad})
+
+	bad.Fail(http.StatusServiceUnavailable)
+	n, err := s.SweepAndBlock()
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "blocker bad error") {
+		t.Fatalf("Expected the sweep to fail, got %d, %v", n, err)
+	}
+	if reported, ok := rs.saved["a"]; !ok || reported || !good.Blocked("a") || bad.Blocked("a") {
+		t.Fatalf("Expected the skylink to stay unreported, got %v", rs.saved)
+	}
+
+	reports := rs.records[0].Reports
+	if !reports["good"].Succeeded() || reports["bad"] == nil || reports["bad"].Result != database.BlockerResultFailed {
+		t.Fatalf("Expected the responses of both targets on the record, got %+v", reports)
+	}
+	if n, err = s.SweepAndBlock(); n != 1 || err != nil {
+		t.Fatalf("Expected 1 reported skylink, got %d, %v", n, err)
+	}
+	if !rs.saved["a"] || good.Blocks("a") != 1 || bad.Blocks("a") != 1 {
+		t.Fatalf("Expected the skylink to be reported to the bad target only, got %d and %d blocks", good.Blocks("a"), bad.Blocks("a"))
+	}
+}
+
+// TestSweepAndBlockUpdateFailure ensures a skylink whose record fails to
+// update after blocker blocked it isn't counted as reported and doesn't hold
+// up the others.
+func TestSweepAndBlockUpdateFailure(t *testing.T) {
+	rs := newMemReportStore("a", "b")
+	rs.saveErr["a"] = errors.New("connection reset")
+	mb := newMockBlocker(t)
+	s := newSweepScanner(t, rs, map[string]*test.MockBlocker{"default": mb})
+
+	n, err := s.SweepAndBlock()
+	if n != 1 || err == nil || !strings.Contains(err.Error(), "failed to update") {
+		t.Fatalf("Expected 1 reported skylink and an update error, got %d, %v", n, err)
+	}
+	if _, ok := rs.saved["a"]; ok || !mb.Blocked("a") || !rs.saved["b"] {
+		t.Fatalf("Expected only b's record to be updated, got %v", rs.saved)
+	}
+}
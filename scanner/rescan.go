@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// rescanExpiryInterval is how often we remove the skylinks of clean
+	// records which fell out of the re-scan lookback.
+	rescanExpiryInterval = time.Hour
+)
+
+// SignaturesUpdated records that ClamAV's signature database was updated to
+// the given version and, if it's a version we haven't seen before and
+// re-scans are enabled, wakes up the re-scan loop. It returns whether the
+// version is new.
+func (s *Scanner) SignaturesUpdated(ctx context.Context, su database.SignatureUpdate) (bool, error) {
+	if su.Version <= 0 {
+		return false, errors.New("invalid signature version")
+	}
+	if su.ReceivedAt.IsZero() {
+		su.ReceivedAt = database.Clock.Now().UTC()
+	}
+	isNew, err := s.staticDB.SaveSignatureUpdate(ctx, su)
+	if err != nil || !isNew {
+		return false, err
+	}
+	s.staticLogger.Infof("ClamAV signatures updated to version %d (source: %s)", su.Version, su.Source)
+	s.signaturesChanged()
+	if RescanLookback > 0 {
+		select {
+		case s.rescans <- struct{}{}:
+		default:
+			// A re-scan is already pending. It will pick up this update.
+		}
+	}
+	return true, nil
+}
+
+// StartRescans launches a background thread which queues the clean skylinks
+// scanned within RescanLookback before the latest signature update for
+// scanning again, whenever SignaturesUpdated reports a new version. It also
+// handles an update which was received before a restart and periodically
+// drops the skylinks we no longer need for re-scans.
+func (s *Scanner) StartRescans() error {
+	if RescanLookback <= 0 {
+		return errors.New("re-scans are disabled")
+	}
+	go func() {
+		s.loopStarted(loopRescan)
+		defer s.loopStopped(loopRescan)
+		ticker := database.Clock.NewTicker(rescanExpiryInterval)
+		defer ticker.Stop()
+		for {
+			err := s.rescan()
+			s.loopIteration(loopRescan, err)
+			if err != nil {
+				s.staticLogger.Warnln(errors.AddContext(err, "failed to queue clean skylinks for rescan"))
+			}
+			select {
+			case <-s.staticCtx.Done():
+				return
+			case <-s.rescans:
+			case <-ticker.C():
+			}
+		}
+	}()
+	return nil
+}
+
+// rescan queues the clean skylinks scanned with signatures older than the
+// latest update for scanning again, unless we already did that for this
+// update. It then drops the skylinks which fell out of the lookback.
+func (s *Scanner) rescan() error {
+	su, err := s.staticDB.LatestSignatureUpdate(s.staticCtx)
+	if err != nil && !errors.Contains(err, database.ErrNoDocumentsFound) {
+		return err
+	}
+	if err == nil && su.RescannedAt.IsZero() {
+		n, err := s.staticDB.RescanClean(s.staticCtx, su.ReceivedAt.Add(-RescanLookback), su.ReceivedAt)
+		if err != nil {
+			return err
+		}
+		err = s.staticDB.MarkSignatureUpdateRescanned(s.staticCtx, su.Version, n)
+		if err != nil {
+			return err
+		}
+		metricRescans.Add(float64(n))
+		s.staticLogger.Infof("Queued %d clean skylinks for rescan after the update to signature version %d", n, su.Version)
+	}
+	_, err = s.staticDB.ExpireRescanSkylinks(s.staticCtx, database.Clock.Now().UTC().Add(-RescanLookback))
+	return err
+}
+
+// rescanSkylink returns the skylink to keep on a clean record for re-scans,
+// which is empty if re-scans are disabled.
+func rescanSkylink(skylink string) string {
+	if RescanLookback <= 0 {
+		return ""
+	}
+	return skylink
+}
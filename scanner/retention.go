@@ -0,0 +1,77 @@
+package scanner
+
+import (
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// retentionEnabled returns whether we remove the records of any verdict class
+// after some time.
+func retentionEnabled() bool {
+	return RetentionInfected > 0 || RetentionClean > 0 || RetentionFailed > 0
+}
+
+// StartRetention launches a background thread which removes expired records
+// every RetentionInterval, see EnforceRetention.
+func (s *Scanner) StartRetention() error {
+	if !retentionEnabled() {
+		return errors.New("no retention configured")
+	}
+	if RetentionInterval <= 0 {
+		return errors.New("invalid retention interval")
+	}
+	go func() {
+		s.loopStarted(loopRetention)
+		defer s.loopStopped(loopRetention)
+		ticker := database.Clock.NewTicker(RetentionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.staticCtx.Done():
+				return
+			case <-ticker.C():
+			}
+			deleted, err := s.EnforceRetention()
+			s.loopIteration(loopRetention, err)
+			if err != nil {
+				s.staticLogger.Warnln(errors.AddContext(err, "failed to enforce retention"))
+			}
+			for class, n := range deleted {
+				s.staticLogger.Infof("Removed %d expired %s records", n, class)
+			}
+		}
+	}()
+	return nil
+}
+
+// EnforceRetention removes the records of every verdict class which are older
+// than the class' retention, replacing manual pruning. Classes without a
+// retention are kept forever. It returns the number of removed records by
+// verdict class.
+func (s *Scanner) EnforceRetention() (map[string]int64, error) {
+	retention := map[string]time.Duration{
+		database.VerdictClassInfected: RetentionInfected,
+		database.VerdictClassClean:    RetentionClean,
+		database.VerdictClassFailed:   RetentionFailed,
+	}
+	now := database.Clock.Now().UTC()
+	deleted := make(map[string]int64)
+	var errs []error
+	for _, class := range database.VerdictClasses {
+		if retention[class] <= 0 {
+			continue
+		}
+		n, err := s.staticDB.DeleteExpired(s.staticCtx, class, now.Add(-retention[class]))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		metricRetentionDeletions.With(class).Add(float64(n))
+		if n > 0 {
+			deleted[class] = n
+		}
+	}
+	return deleted, errors.Compose(errs...)
+}
@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// withdrawn returns whether the signature with the given name was withdrawn,
+// in which case we ignore its detections. Failing to look it up counts as not
+// withdrawn, so we rather keep blocking than let malware through.
+func (s *Scanner) withdrawn(sig string) bool {
+	w, err := s.staticDB.SignatureWithdrawn(s.staticCtx, sig)
+	if err != nil {
+		s.staticSampler.Warnf("withdrawal_lookup_failed", "failed to look up the withdrawal of signature %s: %s", sig, err)
+		return false
+	}
+	return w
+}
+
+// SweepRollbacks finishes the rollbacks of the skylinks which were blocked
+// under withdrawn signatures and have a verdict again. Blocker has no unblock
+// endpoint, so the ones which turned out to be clean stay blocked and we warn
+// about them, so they're unblocked there by hand. It returns the number of
+// clean skylinks.
+func (s *Scanner) SweepRollbacks() (int, error) {
+	c, err := s.staticDB.RollbackSkylinks(s.staticCtx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = c.Close(s.staticCtx) }()
+	var clean int
+	var errs []error
+	for c.Next(s.staticCtx) {
+		var sl database.Skylink
+		if err := c.Decode(&sl); err != nil {
+			errs = append(errs, errors.AddContext(err, "failed to decode rollback skylink"))
+			continue
+		}
+		err = s.staticDB.SkylinkRollbackDone(s.staticCtx, &sl)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if sl.Infected {
+			metricRollbacks.With("infected").Inc()
+			continue
+		}
+		metricRollbacks.With("clean").Inc()
+		s.staticLogger.Warnf("Hash %s is clean after the withdrawal of signature %s and stays blocked until it's unblocked in blocker", sl.Hash.String(), sl.Rollback)
+		clean++
+	}
+	if c.Err() != nil {
+		errs = append(errs, errors.AddContext(c.Err(), "failed to fetch rollback skylink from db"))
+	}
+	return clean, errors.Compose(errs...)
+}
@@ -1,40 +1,78 @@
 package scanner
 
 import (
-	"bytes"
 	"context"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	blockapi "github.com/SkynetLabs/blocker/api"
-	blockdb "github.com/SkynetLabs/blocker/database"
+	"github.com/SkynetLabs/malware-scanner/blocker"
 	"github.com/SkynetLabs/malware-scanner/clamav"
 	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/logging"
 	"github.com/SkynetLabs/skynet-accounts/build"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/NebulousLabs/errors"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	// malwareTag marks the skylink as blocked by malware-scanner, as opposed to
-	// user-reported malware.
-	malwareTag = "malware-scanner"
 )
 
 var (
-	// BlockerIP is the IP of the blocker service.
-	// Set according to the BLOCKER_IP env var.
-	BlockerIP string
-	// BlockerPort is the port of the blocker service.
-	// Set according to the BLOCKER_PORT env var.
-	BlockerPort string
+	// LargeFileThreshold is the size in bytes above which we log a warning
+	// about the scanned file. Zero disables the warning.
+	// Set according to the MALWARE_SCANNER_LARGE_FILE_THRESHOLD env var.
+	LargeFileThreshold uint64 = 1 << 30
+	// SlowScanThreshold is the scan duration above which we log a warning
+	// about the scan. Zero disables the warning.
+	// Set according to the MALWARE_SCANNER_SLOW_SCAN_THRESHOLD env var.
+	SlowScanThreshold = 5 * time.Minute
+	// AccountsDB is the name of skynet-accounts' database, in which we look
+	// up the uploaders of infected skylinks. Empty disables the lookup.
+	// Set according to the MALWARE_SCANNER_ACCOUNTS_DB env var.
+	AccountsDB string
+	// Federated makes us look up the verdicts of federated scanner instances
+	// before downloading a skylink and reuse them instead of scanning it.
+	// Set when the MALWARE_SCANNER_FEDERATION_PEERS env var is set.
+	Federated bool
+	// RescanLookback is how far back from a signature update we look for
+	// clean skylinks to scan again. We keep the skylinks of clean records
+	// for this long. Zero disables re-scans.
+	// Set according to the MALWARE_SCANNER_RESCAN_LOOKBACK env var.
+	RescanLookback time.Duration
+	// DryRun holds back every detection the policy would report to blocker
+	// for review instead, so a human decides whether it's blocked.
+	// Set according to the MALWARE_SCANNER_DRY_RUN env var.
+	DryRun bool
+	// DigestInterval is how often we send a digest of the scanner's activity
+	// and the period each digest covers.
+	// Set according to the MALWARE_SCANNER_DIGEST_INTERVAL env var.
+	DigestInterval = 24 * time.Hour
+	// RetentionInfected, RetentionClean and RetentionFailed are how long we
+	// keep the records of the respective verdict class, see
+	// database.VerdictClasses. Zero keeps them forever.
+	// Set according to the MALWARE_SCANNER_RETENTION_INFECTED,
+	// MALWARE_SCANNER_RETENTION_CLEAN and MALWARE_SCANNER_RETENTION_FAILED
+	// env vars.
+	RetentionInfected time.Duration
+	RetentionClean    time.Duration
+	RetentionFailed   time.Duration
+	// RetentionInterval is how often we remove expired records.
+	// Set according to the MALWARE_SCANNER_RETENTION_INTERVAL env var.
+	RetentionInterval = time.Hour
+	// GoneConfirmations is how many times the portal has to respond that
+	// the content of a skylink doesn't exist, with a 404 or a 410, before we
+	// mark the skylink as gone instead of trying to scan it again. Zero
+	// keeps trying.
+	// Set according to the MALWARE_SCANNER_GONE_CONFIRMATIONS env var.
+	GoneConfirmations = 3
+	// ScanBatchSize is the number of skylinks we lock and scan together, so
+	// small files can be streamed to clamd back to back. One disables
+	// batching.
+	// Set according to the MALWARE_SCANNER_SCAN_BATCH_SIZE env var.
+	ScanBatchSize = 1
 
 	// sleepBetweenReports defines how long the scanner should sleep after
 	// scanning the DB and not finding any skylinks to report to blocker.
@@ -65,14 +103,56 @@ var (
 
 // Scanner provides a convenient interface for working with ClamAV
 type Scanner struct {
-	staticCtx    context.Context
-	staticDB     *database.DB
-	staticClam   *clamav.ClamAV
-	staticLogger *logrus.Logger
+	// blockerFailures is the number of subsequent failed calls to each
+	// blocker target.
+	blockerFailures map[string]int
+	// draining is set while we drain a backlog of skylinks to scan.
+	draining bool
+	// loops holds the state of each of the background loops.
+	loops map[string]*LoopState
+	// paused stops the scanning loop from picking up new skylinks.
+	paused bool
+	// policy decides what happens to our detections. It's nil unless a
+	// policy is configured, in which case every detection is reported.
+	policy *Policy
+	// portalVerdicts queues the verdicts we push to the portal. It's nil
+	// unless pushing is enabled.
+	portalVerdicts chan PortalVerdict
+	// replay holds the recorded verdicts we replay instead of scanning. It's
+	// nil unless we replay verdicts.
+	replay *Replay
+	// rescans signals the re-scan loop that the signatures were updated.
+	rescans chan struct{}
+	// signatureVersion is the version of ClamAV's signature database as of
+	// signatureVersionAt. It's zero if we failed to look it up.
+	signatureVersion   int
+	signatureVersionAt time.Time
+
+	staticCtx  context.Context
+	staticDB   *database.DB
+	staticClam *clamav.ClamAV
+	// staticReports is where SweepAndBlock finds the skylinks to report
+	// and records the reports. It's staticDB outside of tests.
+	staticReports reportStore
+	// staticConcurrency limits the number of concurrent scans.
+	staticConcurrency *concurrencyLimiter
+	// staticBlockers are the blocker targets we report to. There is at
+	// least one.
+	staticBlockers []*blocker.Client
+	// staticUnpinner unpins blocked skylinks from the portal. It's nil if
+	// unpinning is disabled.
+	staticUnpinner *Unpinner
+	staticEvents   *events.Emitter
+	staticLogger   *logrus.Logger
+	// staticSampler rate-limits the log messages which can repeat many
+	// times per minute, e.g. while the portal is down.
+	staticSampler *logging.Sampler
+	mu            sync.Mutex
 }
 
-// New returns a new Scanner with the given parameters.
-func New(ctx context.Context, db *database.DB, clam *clamav.ClamAV, logger *logrus.Logger) (*Scanner, error) {
+// New returns a new Scanner with the given parameters. The unpinner is
+// optional.
+func New(ctx context.Context, db *database.DB, clam *clamav.ClamAV, blockers []*blocker.Client, unpinner *Unpinner, ev *events.Emitter, logger *logrus.Logger) (*Scanner, error) {
 	if ctx == nil {
 		return nil, errors.New("invalid context provided")
 	}
@@ -82,121 +162,332 @@ func New(ctx context.Context, db *database.DB, clam *clamav.ClamAV, logger *logr
 	if clam == nil {
 		return nil, errors.New("invalid ClamAV instance provided")
 	}
+	if len(blockers) == 0 {
+		return nil, errors.New("no blocker targets provided")
+	}
+	for _, bc := range blockers {
+		if bc == nil {
+			return nil, errors.New("invalid blocker client provided")
+		}
+	}
+	if ev == nil {
+		return nil, errors.New("invalid events emitter provided")
+	}
 	if logger == nil {
 		return nil, errors.New("invalid logger provided")
 	}
-	return &Scanner{
-		staticCtx:    ctx,
-		staticDB:     db,
-		staticClam:   clam,
-		staticLogger: logger,
-	}, nil
+	sampler, err := logging.NewSampler(ctx, logger)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create log sampler")
+	}
+	s := &Scanner{
+		blockerFailures:   make(map[string]int),
+		loops:             make(map[string]*LoopState),
+		rescans:           make(chan struct{}, 1),
+		staticCtx:         ctx,
+		staticDB:          db,
+		staticReports:     dbReportStore{db},
+		staticClam:        clam,
+		staticConcurrency: newConcurrencyLimiter(maxConcurrentScans()),
+		staticBlockers:    blockers,
+		staticUnpinner:    unpinner,
+		staticEvents:      ev,
+		staticLogger:      logger,
+		staticSampler:     sampler,
+	}
+	// The workers beyond MaxConcurrentScans only scan while we drain a
+	// backlog.
+	s.staticConcurrency.setMax(MaxConcurrentScans)
+	return s, nil
 }
 
-// SweepAndBlock scans the database for malicious skylinks that haven't been
-// reported to blocker yet and reports them. It doesn't lock the records because
-// it isn't needed.
-func (s Scanner) SweepAndBlock() (int, error) {
-	var count int
-	filter := bson.M{
-		"status":  database.SkylinkStatusUnreported,
-		"skylink": bson.M{"$ne": ""},
-	}
-	update := bson.M{
-		"$set": bson.M{
-			"skylink": "",
-			"status":  database.SkylinkStatusComplete,
-		},
+// SweepAndScan sweeps the DB for new skylinks, locks them, scans them,
+// and updates their records in the DB. While the scanner replays recorded
+// verdicts, it takes them from the recording instead of scanning.
+func (s *Scanner) SweepAndScan(abort chan bool) error {
+	sl, err := s.lockNext()
+	if sl == nil || err != nil {
+		return err
 	}
-	var sl database.Skylink
+	sigVersion := s.currentSignatureVersion()
+	scanStart := database.Clock.Now()
+	var res clamav.SkylinkScan
+	if r := s.currentReplay(); r != nil {
+		res = r.scan(sl.Hash)
+	} else {
+		res = s.staticClam.ScanSkylink(sl.DownloadPath(), abort)
+	}
+	return s.saveScan(sl, res, sigVersion, database.Clock.Since(scanStart))
+}
 
-	// Continue finding skylinks and reporting them while there are skylinks to
-	// report.
-	for {
-		// Find a malicious skylink to report.
-		sr := s.staticDB.FindOneSkylink(s.staticCtx, filter)
-		if sr.Err() == mongo.ErrNoDocuments {
-			// no more records to report
-			break
+// SweepAndScanBatch locks up to ScanBatchSize new skylinks, scans them
+// together and updates their records in the DB. Small files are streamed to
+// clamd back to back, which raises the scan rate of queues dominated by tiny
+// files.
+func (s *Scanner) SweepAndScanBatch(abort chan bool) error {
+	var sls []*database.Skylink
+	var errs []error
+	for i := 0; i < ScanBatchSize; i++ {
+		sl, err := s.lockNext()
+		if err != nil && i == 0 {
+			return err
 		}
-		if sr.Err() != nil {
-			return count, errors.AddContext(sr.Err(), "failed to fetch malicious skylink from db")
-		}
-		err := sr.Decode(&sl)
-		if err != nil {
-			s.staticLogger.Errorf("Failed to deserialize skylink from DB into a var. Error: '%s'", err.Error())
-			return count, err
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
 		}
-		// Report the skylink to blocker.
-		s.staticLogger.Infof("Reporting skylink '%s' as malicious with description '%s'", sl.Skylink, sl.InfectionDescription)
-		err = reportToBlocker(sl.Skylink)
 		if err != nil {
-			return count, errors.AddContext(err, "blocker error")
+			errs = append(errs, err)
+			break
 		}
-		// Mark the skylink as reported and remove the skylink from the record.
-		_, err = s.staticDB.UpdateOneSkylink(s.staticCtx, bson.M{"_id": sl.ID}, update)
-		if err != nil {
-			return count, errors.AddContext(err, "failed to update the skylink's status in db")
+		if sl != nil {
+			sls = append(sls, sl)
 		}
-		count++
 	}
-	return count, nil
+	if len(sls) == 0 {
+		return errors.Compose(errs...)
+	}
+	skylinks := make([]string, len(sls))
+	for i, sl := range sls {
+		skylinks[i] = sl.DownloadPath()
+	}
+	sigVersion := s.currentSignatureVersion()
+	scanStart := database.Clock.Now()
+	results := s.staticClam.ScanSkylinks(skylinks, abort)
+	scanDuration := database.Clock.Since(scanStart)
+	for i, sl := range sls {
+		errs = append(errs, s.saveScan(sl, results[i], sigVersion, scanDuration))
+	}
+	return errors.Compose(errs...)
 }
 
-// SweepAndScan sweeps the DB for new skylinks, locks them, scans them,
-// and updates their records in the DB.
-func (s Scanner) SweepAndScan(abort chan bool) error {
+// lockNext locks the next new skylink. It returns nil if the skylink already
+// got a verdict without being scanned, either because it has one from the
+// current signatures, because we cached one for its content or from a
+// federated peer.
+func (s *Scanner) lockNext() (*database.Skylink, error) {
 	sl, err := s.staticDB.SweepAndLock(s.staticCtx)
 	if err != nil {
 		if !errors.Contains(err, database.ErrNoDocumentsFound) {
-			s.staticLogger.Warnf("error while trying to lock a new record: %s", err)
+			s.staticSampler.Warnf("lock_failed", "error while trying to lock a new record: %s", err)
+			metricScanFailures.With(ErrKindDB).Inc()
 		}
-		return err
+		return nil, err
 	}
 	if sl.Skylink == "" {
 		s.staticLogger.Warnf("SweepAndLock returned a record with an empty skylink. Record hash: %s", hex.EncodeToString(sl.Hash[:]))
-		return errors.New("empty skylink")
+		return nil, errors.New("empty skylink")
+	}
+	s.emit(events.TypeLocked, sl, nil)
+	applied, err := s.applyExistingVerdict(sl)
+	if applied || err != nil {
+		return nil, err
+	}
+	applied, err = s.applyCachedVerdict(sl)
+	if applied || err != nil {
+		return nil, err
 	}
-	inf, desc, size, scannedSize, err := s.staticClam.ScanSkylink(sl.Skylink, abort)
+	if Federated {
+		applied, err := s.applyPeerVerdict(sl)
+		if applied || err != nil {
+			return nil, err
+		}
+	}
+	return sl, nil
+}
+
+// saveScan updates the record of the scanned skylink with the result of the
+// scan. Failed scans unlock the record for another attempt.
+func (s *Scanner) saveScan(sl *database.Skylink, res clamav.SkylinkScan, sigVersion int, scanDuration time.Duration) error {
+	inf, desc, size, scannedSize, err := res.Infected, res.Description, res.Size, res.ScannedSize, res.Err
 	if err != nil {
 		// Scanning failed, log the error and unlock the record for another attempt.
-		s.staticLogger.Debugln(errors.AddContext(err, "scanning failed"))
+		kind := classifyError(err)
+		metricScanFailures.With(kind).Inc()
+		s.staticSampler.Debugf("scan_failed_"+kind, "scanning failed (%s): %s", kind, err)
 		sl.Status = database.SkylinkStatusNew
-		sl.Timestamp = time.Now().UTC()
-		err = s.staticDB.SkylinkSave(s.staticCtx, sl)
-		if err != nil {
-			s.staticLogger.Debugln(errors.AddContext(err, "unlocking a skylink failed"))
+		sl.Timestamp = database.Clock.Now().UTC()
+		sl.Failures++
+		sl.LastErrorKind = kind
+		sl.LastError = err.Error()
+		if kind == ErrKindPortalNotFound || kind == ErrKindPortalGone {
+			sl.NotFound++
 		}
-		return err
+		var errSave error
+		if GoneConfirmations > 0 && sl.NotFound >= GoneConfirmations {
+			s.staticLogger.Infof("Giving up on hash %s after the portal confirmed %d times that its content doesn't exist", sl.Hash.String(), sl.NotFound)
+			metricGoneRecords.Inc()
+			sl.Status = database.SkylinkStatusGone
+			s.emit(events.TypeGone, sl, err)
+			errSave = s.staticDB.SkylinkSaveGone(s.staticCtx, sl)
+		} else {
+			s.emit(events.TypeFailed, sl, err)
+			errSave = s.staticDB.SkylinkSaveFailure(s.staticCtx, sl)
+		}
+		if errSave != nil {
+			s.staticSampler.Debugf("unlock_failed", "unlocking a skylink failed: %s", errSave)
+			metricScanFailures.With(ErrKindDB).Inc()
+		}
+		return errors.Compose(err, errSave)
+	}
+	if inf && s.withdrawn(desc) {
+		s.staticLogger.Infof("Ignoring the detection of hash %s by withdrawn signature %s", sl.Hash.String(), desc)
+		metricSuppressedDetections.Inc()
+		inf, desc = false, ""
 	}
 	// Sanity check: scannedSize vs size.
 	if scannedSize > size {
 		s.staticLogger.Warnf("Scanned size (%d bytes) is more than the content size (%d bytes) for skylink %s", scannedSize, size, sl.Skylink)
 	}
+	if reasons := outlierReasons(size, scanDuration); len(reasons) > 0 {
+		var queued time.Duration
+		if !sl.SubmittedAt.IsZero() {
+			queued = database.Clock.Since(sl.SubmittedAt) - scanDuration
+		}
+		s.staticLogger.Warnf("Outlier scan (%s) of hash %s: size %d bytes, scanned %d bytes, scan took %s, waited in queue %s",
+			strings.Join(reasons, ", "), sl.Hash.String(), size, scannedSize, scanDuration, queued)
+	}
+	skylink := sl.Skylink
 	sl.Status = database.SkylinkStatusUnreported
 	if !inf {
 		// The skylink is not infected, so we can already clean up its skylink
 		// and mark our work with it as done. If that wasn't the case, we would
 		// have left the skylink present until it's reported to blocker.
+		sl.RescanSkylink = rescanSkylink(sl.Skylink)
 		sl.Skylink = ""
 		sl.Status = database.SkylinkStatusComplete
+	} else {
+		sl.RescanSkylink = ""
 	}
 	sl.Infected = inf
 	sl.InfectionDescription = desc
 	sl.Size = size
+	sl.ScannedSize = scannedSize
 	sl.ScannedAllContent = scannedSize == size
 	sl.ScannedAllOffsets = false
-	sl.Timestamp = time.Now().UTC()
-	err = s.staticDB.SkylinkSave(s.staticCtx, sl)
+	sl.Timestamp = database.Clock.Now().UTC()
+	sl.ScannedAt = sl.Timestamp
+	sl.SignatureVersion = sigVersion
+	sl.VerdictSource = ""
+	sl.LastErrorKind = ""
+	sl.LastError = ""
+	sl.NotFound = 0
+	sl.Filename = res.File.Filename
+	sl.ContentType = res.File.ContentType
+	sl.Directory = res.File.Directory
+	if inf && AccountsDB != "" {
+		s.lookupUploaders(sl)
+	}
+	s.applyPolicy(sl)
+	err = s.staticDB.SkylinkSaveVerdict(s.staticCtx, sl)
+	if err != nil {
+		s.staticSampler.Debugf("update_failed", "updating a skylink's status failed: %s", err)
+		metricScanFailures.With(ErrKindDB).Inc()
+		return err
+	}
+	if inf {
+		s.emit(events.TypeInfected, sl, nil)
+	} else {
+		s.emit(events.TypeScanned, sl, nil)
+	}
+	if s.currentReplay() == nil {
+		// Failing to cache the verdict only means we scan the content again
+		// if it's submitted again.
+		err = s.staticDB.CacheVerdict(s.staticCtx, sl)
+		if err != nil {
+			s.staticSampler.Debugf("cache_verdict_failed", "caching the verdict of hash %s failed: %s", sl.Hash.String(), err)
+		}
+	}
+	s.pushVerdict(skylink, sl)
+	metricScannedRecords.Inc()
+	metricScannedBytes.Add(float64(scannedSize))
+	if !sl.SubmittedAt.IsZero() {
+		metricVerdictLatency.Observe(sl.ScannedAt.Sub(sl.SubmittedAt).Seconds())
+	}
+	return nil
+}
+
+// applyPeerVerdict gives the locked skylink the verdict a federated scanner
+// instance reached for the same content, if any, so we don't need to download
+// and scan it. It returns whether it applied a verdict. Failing to look up the
+// verdict doesn't fail the scan.
+func (s *Scanner) applyPeerVerdict(sl *database.Skylink) (bool, error) {
+	v, err := s.staticDB.PeerVerdict(s.staticCtx, sl.Hash)
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		return false, nil
+	}
+	if err != nil {
+		s.staticSampler.Warnf("peer_verdict_failed", "failed to look up the peer verdict of hash %s: %s", sl.Hash.String(), err)
+		return false, nil
+	}
+	if v.Infected && s.withdrawn(v.InfectionDescription) {
+		// Scan the skylink ourselves rather than take a verdict we no
+		// longer stand by.
+		return false, nil
+	}
+	skylink := sl.Skylink
+	sl.Status = database.SkylinkStatusUnreported
+	if !v.Infected {
+		sl.RescanSkylink = rescanSkylink(sl.Skylink)
+		sl.Skylink = ""
+		sl.Status = database.SkylinkStatusComplete
+	} else {
+		sl.RescanSkylink = ""
+	}
+	sl.Infected = v.Infected
+	sl.InfectionDescription = v.InfectionDescription
+	sl.VerdictSource = "peer:" + v.Peer
+	sl.SignatureVersion = 0
+	sl.Timestamp = database.Clock.Now().UTC()
+	sl.ScannedAt = sl.Timestamp
+	sl.LastErrorKind = ""
+	sl.LastError = ""
+	sl.NotFound = 0
+	if v.Infected && AccountsDB != "" {
+		s.lookupUploaders(sl)
+	}
+	s.applyPolicy(sl)
+	err = s.staticDB.SkylinkSaveVerdict(s.staticCtx, sl)
+	if err != nil {
+		s.staticSampler.Debugf("update_failed", "updating a skylink's status failed: %s", err)
+		metricScanFailures.With(ErrKindDB).Inc()
+		return false, err
+	}
+	if v.Infected {
+		s.emit(events.TypeInfected, sl, nil)
+	} else {
+		s.emit(events.TypeScanned, sl, nil)
+	}
+	s.pushVerdict(skylink, sl)
+	metricPeerVerdicts.With(strconv.FormatBool(v.Infected)).Inc()
+	if !sl.SubmittedAt.IsZero() {
+		metricVerdictLatency.Observe(sl.ScannedAt.Sub(sl.SubmittedAt).Seconds())
+	}
+	return true, nil
+}
+
+// lookupUploaders records the portal users who uploaded the given infected
+// skylink. Failing to look them up doesn't fail the scan.
+func (s *Scanner) lookupUploaders(sl *database.Skylink) {
+	uploaders, err := s.staticDB.Uploaders(s.staticCtx, AccountsDB, sl.Skylink)
 	if err != nil {
-		s.staticLogger.Debugln(errors.AddContext(err, "updating a skylink's status failed"))
+		s.staticSampler.Warnf("uploaders_failed", "failed to look up the uploaders of infected skylink %s: %s", sl.Skylink, err)
+		return
+	}
+	sl.Uploaders = uploaders
+	var users, anonymous int
+	for _, u := range uploaders {
+		if u.UserID.IsZero() {
+			anonymous += u.Uploads
+		} else {
+			users++
+		}
 	}
-	return err
+	s.staticLogger.Infof("Infected skylink %s was uploaded by %d users and %d times anonymously", sl.Skylink, users, anonymous)
 }
 
 // Start launches a background task that periodically scans the database for
 // new skylink records and sends them for scanning.
-func (s Scanner) Start() {
+func (s *Scanner) Start() {
 	// abort channel which interrupts the current scanning operation
 	abort := make(chan bool)
 
@@ -209,68 +500,36 @@ func (s Scanner) Start() {
 		close(abort)
 	}()
 
-	// Start the scanning loop.
-	go func() {
-		// sleepLength defines how long the thread will sleep before scanning
-		// the next skylink. Its value is controlled by SweepAndScan - while we
-		// keep finding files to scan, we'll keep this sleep at zero. Once we
-		// run out of files to scan we'll reset it to its full duration of
-		// sleepBetweenScans.
-		sleepLength := sleepBetweenScans
-		first := true
-		for {
-			numSubsequentErrs := 0
-			if !first {
-				select {
-				case <-s.staticCtx.Done():
-					return
-				case <-time.After(sleepLength):
-				}
-			}
-			first = false
-			err := s.SweepAndScan(abort)
-			if errors.Contains(err, database.ErrNoDocumentsFound) {
-				// This was a successful call, so the number of subsequent
-				// errors is reset and we sleep for a pre-determined period
-				// in waiting for new skylinks to be uploaded.
-				sleepLength = sleepBetweenScans
-				numSubsequentErrs = 0
-			} else if err != nil {
-				// On error, we sleep for an increasing amount of time -
-				// from 100ms on the first error to 100s on the fourth and
-				// subsequent errors.
-				sleepLength = sleepOnErrStep * time.Duration(math.Pow10(numSubsequentErrs))
-				numSubsequentErrs++
-				if numSubsequentErrs > sleepOnErrSteps {
-					numSubsequentErrs = sleepOnErrSteps
-				}
-			} else {
-				// A successful scan. Reset the number of subsequent errors.
-				numSubsequentErrs = 0
-				// No need to sleep after a successful scan.
-				sleepLength = 0
-			}
-		}
-	}()
+	// Start the scanning workers. They take turns at the slots of the
+	// concurrency limiter, which adapts their number to the load of clamd
+	// and the portal, and to the depth of the queue while we drain it.
+	for i := 0; i < s.staticConcurrency.staticMax; i++ {
+		go s.threadedScan(abort)
+	}
+	if s.staticConcurrency.staticMax > 1 {
+		go s.threadedAdjustConcurrency()
+	}
+	if DrainThreshold > 0 {
+		go s.threadedDrain()
+	}
 
 	// Start the reporting loop.
 	// This loop will look for skylinks that are detected as malicious and will
 	// report them to the blocker service, so they can be immediately blocked on
 	// all portals.
 	go func() {
+		s.loopStarted(loopReport)
+		defer s.loopStopped(loopReport)
 		first := true
 		for {
-			if !first {
-				select {
-				case <-s.staticCtx.Done():
-					return
-				case <-time.After(sleepBetweenReports):
-				}
+			if !first && !s.sleep(s.reportSleep()) {
+				return
 			}
 			first = false
-			n, err := s.SweepAndBlock()
+			n, err := s.ReportOnce()
+			s.loopIteration(loopReport, err)
 			if err != nil {
-				s.staticLogger.Infof("SweepAndBlock blocked %d malicious skylinks before it encountered an error: %s", n, err.Error())
+				s.staticSampler.Infof("sweep_and_block_failed", "SweepAndBlock blocked %d malicious skylinks before it encountered an error: %s", n, err.Error())
 			} else {
 				s.staticLogger.Tracef("SweepAndBlock blocked %d malicious skylinks.", n)
 			}
@@ -278,20 +537,122 @@ func (s Scanner) Start() {
 	}()
 }
 
+// threadedScan keeps scanning new skylinks until the scanner's context is
+// done.
+func (s *Scanner) threadedScan(abort chan bool) {
+	s.loopStarted(loopScan)
+	defer s.loopStopped(loopScan)
+	// sleepLength defines how long the thread will sleep before scanning
+	// the next skylink. Its value is controlled by SweepAndScan - while we
+	// keep finding files to scan, we'll keep this sleep at zero. Once we
+	// run out of files to scan we'll reset it to its full duration of
+	// sleepBetweenScans.
+	sleepLength := sleepBetweenScans
+	numSubsequentErrs := 0
+	first := true
+	// next is the skylink we prefetched while scanning the previous one.
+	var next *prefetched
+	defer func() { s.releasePrefetched(next) }()
+	for {
+		if !first && !s.sleep(sleepLength) {
+			return
+		}
+		first = false
+		if s.Paused() {
+			s.releasePrefetched(next)
+			next = nil
+			sleepLength = sleepBetweenScans
+			continue
+		}
+		// While every portal rate limits us, all workers hold off instead
+		// of locking skylinks whose downloads would fail right away.
+		if d := s.rateLimitedFor(); d > 0 {
+			sleepLength = d
+			continue
+		}
+		if !s.staticConcurrency.acquire(s.staticCtx) {
+			return
+		}
+		var err error
+		if Prefetch && ScanBatchSize <= 1 && s.currentReplay() == nil {
+			next, err = s.sweepAndScanPrefetched(abort, next)
+		} else {
+			err = s.scanOnce(abort)
+		}
+		s.staticConcurrency.release()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			s.loopIteration(loopScan, nil)
+		} else {
+			s.loopIteration(loopScan, err)
+		}
+		sleepLength, numSubsequentErrs = scanSleep(err, numSubsequentErrs)
+	}
+}
+
+// rateLimitedFor returns how long the scanning workers hold off because every
+// portal rate limits us. It's zero while any portal accepts downloads.
+func (s *Scanner) rateLimitedFor() time.Duration {
+	until := s.staticClam.RateLimitedUntil()
+	if until.IsZero() {
+		return 0
+	}
+	return until.Sub(database.Clock.Now())
+}
+
+// scanSleep returns how long a scanning worker sleeps after a sweep which
+// returned the given error, given the number of errors in a row before it. It
+// also returns the new number of errors in a row.
+func scanSleep(err error, numSubsequentErrs int) (time.Duration, int) {
+	switch {
+	case errors.Contains(err, database.ErrNoDocumentsFound):
+		// This was a successful call, so the number of subsequent errors
+		// is reset and we sleep for a pre-determined period in waiting for
+		// new skylinks to be uploaded.
+		return sleepBetweenScans, 0
+	case err != nil:
+		// On error, we sleep for an increasing amount of time - from
+		// 100ms on the first error to 100s on the fourth and subsequent
+		// errors.
+		sleepLength := sleepOnErrStep * time.Duration(math.Pow10(numSubsequentErrs))
+		if numSubsequentErrs < sleepOnErrSteps {
+			numSubsequentErrs++
+		}
+		return sleepLength, numSubsequentErrs
+	default:
+		// A successful scan. No need to sleep after it.
+		return 0, 0
+	}
+}
+
+// sleep waits for the given duration on the scanner's clock. It returns false
+// if the scanner's context is done first.
+func (s *Scanner) sleep(d time.Duration) bool {
+	select {
+	case <-s.staticCtx.Done():
+		return false
+	case <-database.Clock.After(d):
+		return true
+	}
+}
+
 // StartUnlocker launches a background thread that periodically scans the
 // database and resets the state of potentially stuck scans. If a scan has been
 // initiated too long ago it will put it back in "new" state, so it can be
 // retried.
-func (s Scanner) StartUnlocker() {
+func (s *Scanner) StartUnlocker() {
 	go func() {
-		ticker := time.NewTicker(database.ScanTimeout)
+		s.loopStarted(loopUnlock)
+		defer s.loopStopped(loopUnlock)
+		ticker := database.Clock.NewTicker(database.ScanTimeout)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-s.staticCtx.Done():
 				return
-			case <-ticker.C:
+			case <-ticker.C():
 			}
 			n, err := s.staticDB.CancelStuckScans(s.staticCtx)
+			s.loopIteration(loopUnlock, err)
 			if err != nil {
 				s.staticLogger.Debugln(errors.AddContext(err, "error while trying to cancel stuck scans"))
 			} else {
@@ -301,32 +662,135 @@ func (s Scanner) StartUnlocker() {
 	}()
 }
 
-// reportToBlocker calls the blocker service and instructs it to block the given
-// skylink as malware.
-func reportToBlocker(skylink string) error {
-	body := blockapi.BlockPOST{
-		Skylink: skylink,
-		Reporter: blockdb.Reporter{
-			Name: "Malware Scanner",
-		},
-		Tags: []string{malwareTag},
+// Pause stops the scanning loop from picking up new skylinks. The scan in
+// progress, if any, is allowed to finish. Reporting to blocker continues.
+func (s *Scanner) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Paused returns whether the scanning loop is paused.
+func (s *Scanner) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Resume resumes the scanning loop after a Pause.
+func (s *Scanner) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+}
+
+// unpin unpins the given blocked skylink from the portal and returns whether
+// it succeeded. Failing to unpin doesn't fail the report, since the skylink is
+// blocked either way.
+func (s *Scanner) unpin(skylink string) bool {
+	err := s.staticUnpinner.Unpin(s.staticCtx, skylink)
+	if err != nil {
+		metricUnpins.With("failure").Inc()
+		s.staticSampler.Warnf("unpin_failed", "failed to unpin blocked skylink %s: %s", skylink, err)
+		return false
+	}
+	metricUnpins.With("success").Inc()
+	return true
+}
+
+// BlockerFailures returns the highest number of subsequent failed calls to
+// any of the blocker targets.
+func (s *Scanner) BlockerFailures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var maxFailures int
+	for _, n := range s.blockerFailures {
+		if n > maxFailures {
+			maxFailures = n
+		}
 	}
-	bodyBytes, err := json.Marshal(body)
+	return maxFailures
+}
+
+// trackBlockerResult updates the number of subsequent failures of the given
+// blocker target based on the result of the latest call to it.
+func (s *Scanner) trackBlockerResult(target string, err error) {
 	if err != nil {
-		return errors.AddContext(err, "failed to build request body")
+		metricBlockerReports.With(target, "failure").Inc()
+	} else {
+		metricBlockerReports.With(target, "success").Inc()
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s:%s/block", BlockerIP, BlockerPort), bytes.NewBuffer(bodyBytes))
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if err != nil {
-		return errors.AddContext(err, "failed to build blocker request")
+		s.blockerFailures[target]++
+	} else {
+		s.blockerFailures[target] = 0
 	}
-	res, err := http.DefaultClient.Do(req)
+}
+
+// updateUnreportedMetrics refreshes the metrics describing the infected
+// skylinks which haven't been reported to blocker yet.
+func (s *Scanner) updateUnreportedMetrics() {
+	n, oldest, err := s.staticDB.UnreportedStats(s.staticCtx)
 	if err != nil {
-		return errors.AddContext(err, "failed to call blocker")
+		s.staticLogger.Debugln(errors.AddContext(err, "failed to update unreported metrics"))
+		return
 	}
-	defer func() { _ = res.Body.Close() }()
-	if res.StatusCode != http.StatusOK {
-		b, _ := ioutil.ReadAll(res.Body)
-		return errors.New(fmt.Sprintf("blocker failed. status code %d, body: '%s'", res.StatusCode, string(b)))
+	metricUnreported.Set(float64(n))
+	if oldest.IsZero() {
+		metricUnreportedOldestAge.Set(0)
+		return
 	}
-	return nil
+	metricUnreportedOldestAge.Set(database.Clock.Since(oldest).Seconds())
+}
+
+// updateQueueMetrics refreshes the metrics describing the depth of the queue,
+// so dashboards can follow it without querying the DB themselves.
+func (s *Scanner) updateQueueMetrics() {
+	qd, err := s.staticDB.QueueDepth(s.staticCtx)
+	if err != nil {
+		s.staticLogger.Debugln(errors.AddContext(err, "failed to update queue metrics"))
+		return
+	}
+	metricQueueDepth.With(database.SkylinkStatusNew).Set(float64(qd.New))
+	metricQueueDepth.With(database.SkylinkStatusScanning).Set(float64(qd.Scanning))
+	metricQueueDepth.With(database.SkylinkStatusUnreported).Set(float64(qd.Unreported))
+	metricQueueDepth.With(database.SkylinkStatusPendingReview).Set(float64(qd.PendingReview))
+	metricRecords.Set(float64(qd.Total))
+}
+
+// outlierReasons returns the reasons for which a scan of the given size and
+// duration is considered an outlier. It returns nil if the scan is not an
+// outlier.
+func outlierReasons(size uint64, duration time.Duration) []string {
+	var reasons []string
+	if SlowScanThreshold > 0 && duration > SlowScanThreshold {
+		reasons = append(reasons, fmt.Sprintf("slower than %s", SlowScanThreshold))
+	}
+	if LargeFileThreshold > 0 && size > LargeFileThreshold {
+		reasons = append(reasons, fmt.Sprintf("larger than %d bytes", LargeFileThreshold))
+	}
+	return reasons
+}
+
+// emit sends a lifecycle event about the given skylink record.
+func (s *Scanner) emit(typ string, sl *database.Skylink, err error) {
+	ev := events.Event{
+		Type:        typ,
+		Hash:        sl.Hash.String(),
+		Skylink:     sl.Skylink,
+		Status:      sl.Status,
+		Infected:    sl.Infected,
+		Description: sl.InfectionDescription,
+		Size:        sl.Size,
+		ScannedSize: sl.ScannedSize,
+		Filename:    sl.Filename,
+		ContentType: sl.ContentType,
+		Source:      sl.Source,
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	s.staticEvents.Emit(ev)
 }
@@ -1,75 +1,67 @@
 package scanner
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"net/http"
 	"strings"
 	"testing"
+	"time"
 
-	blockapi "github.com/SkynetLabs/blocker/api"
-	blockdb "github.com/SkynetLabs/blocker/database"
 	"gitlab.com/NebulousLabs/errors"
-	"gopkg.in/h2non/gock.v1"
 )
 
-// TestReportToBlocker ensures reportToBlocker works as expected.
-func TestReportToBlocker(t *testing.T) {
-	defer gock.Off()
+// TestOutlierReasons ensures outlierReasons respects the configured
+// thresholds.
+func TestOutlierReasons(t *testing.T) {
+	defer func(size uint64, dur time.Duration) {
+		LargeFileThreshold = size
+		SlowScanThreshold = dur
+	}(LargeFileThreshold, SlowScanThreshold)
+	LargeFileThreshold = 1000
+	SlowScanThreshold = time.Second
 
-	if BlockerIP == "" {
-		BlockerIP = "10.10.10.110"
+	if r := outlierReasons(1000, time.Second); len(r) != 0 {
+		t.Fatalf("Expected no reasons, got %v", r)
 	}
-	if BlockerPort == "" {
-		BlockerPort = "4000"
+	if r := outlierReasons(1001, time.Second); len(r) != 1 || !strings.Contains(r[0], "larger") {
+		t.Fatalf("Expected a size reason, got %v", r)
 	}
-
-	skylink := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
-	blockerURL := fmt.Sprintf("http://%s:%s", BlockerIP, BlockerPort)
-
-	// Happy case.
-	blockReqBody := blockapi.BlockPOST{
-		Skylink: skylink,
-		Reporter: blockdb.Reporter{
-			Name: "Malware Scanner",
-		},
-		Tags: []string{malwareTag},
+	if r := outlierReasons(1001, 2*time.Second); len(r) != 2 {
+		t.Fatalf("Expected two reasons, got %v", r)
 	}
-	blockReqBodyBytes, err := json.Marshal(blockReqBody)
-	if err != nil {
-		t.Fatalf("Failed to serialize request, Error: %s", err.Error())
+	// Zero disables the thresholds.
+	LargeFileThreshold = 0
+	SlowScanThreshold = 0
+	if r := outlierReasons(1<<40, time.Hour); len(r) != 0 {
+		t.Fatalf("Expected no reasons, got %v", r)
 	}
+}
 
-	gock.New(blockerURL).
-		Post("/block").
-		Body(bytes.NewBuffer(blockReqBodyBytes)).
-		Reply(http.StatusOK)
+// TestLoopState ensures the scanner keeps track of its loops' state.
+func TestLoopState(t *testing.T) {
+	s := &Scanner{loops: make(map[string]*LoopState)}
+	s.loopStarted(loopScan)
+	s.loopIteration(loopScan, nil)
+	s.loopIteration(loopScan, errors.New("failed"))
+	s.loopIteration(loopScan, nil)
 
-	err = reportToBlocker(skylink)
-	if err != nil {
-		t.Fatal(err)
+	ls := s.State().Loops[loopScan]
+	if !ls.Running || ls.Iterations != 3 || ls.LastError != "failed" || ls.LastErrAt.IsZero() || ls.LastRun.Before(ls.LastErrAt) {
+		t.Fatalf("Unexpected loop state %+v", ls)
 	}
-
-	// Error when calling blocker.
-	gock.New(blockerURL).
-		Post("/block").
-		Body(bytes.NewBuffer(blockReqBodyBytes)).
-		ReplyError(errors.New("simulated error"))
-
-	err = reportToBlocker(skylink)
-	if err == nil || !strings.Contains(err.Error(), "simulated error") {
-		t.Fatalf("Expected error 'simulated error', got '%s'", err)
+	s.loopStopped(loopScan)
+	if s.State().Loops[loopScan].Running {
+		t.Fatal("Expected the loop to be stopped")
 	}
+}
 
-	// Blocker failed to block
-	gock.New(blockerURL).
-		Post("/block").
-		Body(bytes.NewBuffer(blockReqBodyBytes)).
-		Reply(http.StatusInternalServerError)
-
-	err = reportToBlocker(skylink)
-	if err == nil || !strings.Contains(err.Error(), "blocker failed. status code 500") {
-		t.Fatalf("Expected error 'blocker failed. status code 500', got '%s'", err)
+// TestPause ensures pausing and resuming the scanner is reflected in its state.
+func TestPause(t *testing.T) {
+	s := &Scanner{loops: make(map[string]*LoopState)}
+	s.Pause()
+	if !s.Paused() || !s.State().Paused {
+		t.Fatal("Expected the scanner to be paused")
+	}
+	s.Resume()
+	if s.Paused() || s.State().Paused {
+		t.Fatal("Expected the scanner to be resumed")
 	}
 }
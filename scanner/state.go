@@ -0,0 +1,120 @@
+package scanner
+
+import (
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+const (
+	// loopScan is the name of the loop which scans new skylinks.
+	loopScan = "scan"
+	// loopReport is the name of the loop which reports infected skylinks to
+	// blocker.
+	loopReport = "report"
+	// loopUnlock is the name of the loop which unlocks stuck scans.
+	loopUnlock = "unlock"
+	// loopRescan is the name of the loop which re-queues clean skylinks
+	// after signature updates.
+	loopRescan = "rescan"
+	// loopDrain is the name of the loop which starts and stops draining
+	// backlogs.
+	loopDrain = "drain"
+	// loopDigest is the name of the loop which sends digests of the
+	// scanner's activity.
+	loopDigest = "digest"
+	// loopRetention is the name of the loop which removes expired records.
+	loopRetention = "retention"
+)
+
+type (
+	// LoopState describes the state of one of the scanner's background
+	// loops.
+	LoopState struct {
+		Running    bool      `json:"running"`
+		Iterations uint64    `json:"iterations"`
+		LastRun    time.Time `json:"lastRun"`
+		LastError  string    `json:"lastError,omitempty"`
+		LastErrAt  time.Time `json:"lastErrorAt,omitempty"`
+	}
+
+	// State is a snapshot of the scanner's internal state.
+	State struct {
+		Loops           map[string]LoopState `json:"loops"`
+		Paused          bool                 `json:"paused"`
+		Draining        bool                 `json:"draining"`
+		BlockerFailures int                  `json:"blockerFailures"`
+		// BlockerTargetFailures holds the number of subsequent failed
+		// calls to each blocker target. BlockerFailures is the highest.
+		BlockerTargetFailures map[string]int `json:"blockerTargetFailures"`
+		// Replaying is set while we replay recorded verdicts instead of
+		// scanning.
+		Replaying bool `json:"replaying,omitempty"`
+	}
+)
+
+// State returns a snapshot of the scanner's internal state.
+func (s *Scanner) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loops := make(map[string]LoopState, len(s.loops))
+	for name, ls := range s.loops {
+		loops[name] = *ls
+	}
+	failures := make(map[string]int, len(s.blockerFailures))
+	var maxFailures int
+	for target, n := range s.blockerFailures {
+		failures[target] = n
+		if n > maxFailures {
+			maxFailures = n
+		}
+	}
+	return State{
+		Loops:                 loops,
+		Paused:                s.paused,
+		Draining:              s.draining,
+		BlockerFailures:       maxFailures,
+		BlockerTargetFailures: failures,
+		Replaying:             s.replay != nil,
+	}
+}
+
+// loopStarted marks the given loop as running.
+func (s *Scanner) loopStarted(name string) {
+	metricActiveWorkers.With(name).Inc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loopState(name).Running = true
+}
+
+// loopStopped marks the given loop as no longer running.
+func (s *Scanner) loopStopped(name string) {
+	metricActiveWorkers.With(name).Dec()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loopState(name).Running = false
+}
+
+// loopIteration records the result of a single iteration of the given loop.
+func (s *Scanner) loopIteration(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ls := s.loopState(name)
+	ls.Iterations++
+	ls.LastRun = database.Clock.Now().UTC()
+	if err != nil {
+		ls.LastError = err.Error()
+		ls.LastErrAt = ls.LastRun
+	}
+}
+
+// loopState returns the state of the given loop, creating it if needed. The
+// caller must hold the lock.
+func (s *Scanner) loopState(name string) *LoopState {
+	ls, ok := s.loops[name]
+	if !ok {
+		ls = &LoopState{}
+		s.loops[name] = ls
+	}
+	return ls
+}
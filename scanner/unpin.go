@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// unpinTimeout is the timeout of a single unpin call.
+	unpinTimeout = time.Minute
+	// skydUserAgent is the user agent skyd requires on its API calls.
+	skydUserAgent = "Sia-Agent"
+)
+
+// Unpinner removes blocked skylinks from the portal's storage by calling the
+// unpin endpoint of each of the portal's skyd instances, so blocked malware
+// doesn't keep consuming disk space.
+type Unpinner struct {
+	staticURLs     []string
+	staticPassword string
+	staticClient   *http.Client
+}
+
+// NewUnpinner returns an unpinner which calls the skyd APIs at the given base
+// URLs, authenticating with the given API password.
+func NewUnpinner(urls []string, password string) (*Unpinner, error) {
+	var clean []string
+	for _, u := range urls {
+		u = strings.TrimSuffix(strings.TrimSpace(u), "/")
+		if u == "" {
+			continue
+		}
+		pu, err := url.Parse(u)
+		if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			return nil, errors.New("invalid skyd URL: " + u)
+		}
+		clean = append(clean, u)
+	}
+	if len(clean) == 0 {
+		return nil, errors.New("no skyd URLs provided")
+	}
+	return &Unpinner{
+		staticURLs:     clean,
+		staticPassword: password,
+		staticClient:   &http.Client{Timeout: unpinTimeout},
+	}, nil
+}
+
+// Unpin unpins the given skylink from all skyd instances. It tries all of
+// them, even if some fail.
+func (u *Unpinner) Unpin(ctx context.Context, skylink string) error {
+	var errs []error
+	for _, base := range u.staticURLs {
+		err := u.unpin(ctx, base, skylink)
+		if err != nil {
+			errs = append(errs, errors.AddContext(err, "failed to unpin from "+base))
+		}
+	}
+	return errors.Compose(errs...)
+}
+
+// unpin unpins the given skylink from the skyd instance at the given URL.
+func (u *Unpinner) unpin(ctx context.Context, base, skylink string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/skynet/unpin/"+url.PathEscape(skylink), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", skydUserAgent)
+	if u.staticPassword != "" {
+		req.SetBasicAuth("", u.staticPassword)
+	}
+	res, err := u.staticClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return errors.New(fmt.Sprintf("skyd failed to unpin. status code %d, body: '%s'", res.StatusCode, string(b)))
+	}
+	return nil
+}
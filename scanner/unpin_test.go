@@ -0,0 +1,54 @@
+package scanner
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gopkg.in/h2non/gock.v1"
+)
+
+// TestUnpinner ensures the unpinner calls all skyd instances.
+func TestUnpinner(t *testing.T) {
+	defer gock.Off()
+
+	if _, err := NewUnpinner([]string{" ", ""}, ""); err == nil {
+		t.Fatal("Expected an error without skyd URLs")
+	}
+	if _, err := NewUnpinner([]string{"sia:9980"}, ""); err == nil {
+		t.Fatal("Expected an error for an invalid URL")
+	}
+	u, err := NewUnpinner([]string{"http://sia-1:9980/", "http://sia-2:9980"}, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skylink := "CAD07c3_6RCANw-IgdddeRhxgibS3hZdWxQvKh2gViKPVw"
+
+	gock.New("http://sia-1:9980").
+		Post("/skynet/unpin/"+skylink).
+		MatchHeader("User-Agent", "Sia-Agent").
+		BasicAuth("", "pass").
+		Reply(http.StatusNoContent)
+	gock.New("http://sia-2:9980").
+		Post("/skynet/unpin/" + skylink).
+		Reply(http.StatusNoContent)
+	if err = u.Unpin(context.Background(), skylink); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failure on one instance doesn't stop the others.
+	gock.New("http://sia-1:9980").
+		Post("/skynet/unpin/" + skylink).
+		Reply(http.StatusInternalServerError)
+	gock.New("http://sia-2:9980").
+		Post("/skynet/unpin/" + skylink).
+		Reply(http.StatusNoContent)
+	err = u.Unpin(context.Background(), skylink)
+	if err == nil || !strings.Contains(err.Error(), "sia-1") || strings.Contains(err.Error(), "sia-2") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected all skyd instances to be called")
+	}
+}
@@ -0,0 +1,153 @@
+package scanner
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// signatureVersionTTL is how long we use the signature version we got
+	// from ClamAV before asking it again.
+	signatureVersionTTL = time.Minute
+)
+
+// applyExistingVerdict completes the locked skylink with the verdict it
+// already has, if it got it with the signatures ClamAV currently runs with, so
+// we don't download and scan the same content again. Submissions of the same
+// content under other skylinks are deduplicated by their hash, so this is
+// mostly about skylinks re-queued for re-scans. It returns whether it applied
+// the verdict.
+func (s *Scanner) applyExistingVerdict(sl *database.Skylink) (bool, error) {
+	if !hasCurrentVerdict(sl, s.currentSignatureVersion()) {
+		return false, nil
+	}
+	skylink := sl.Skylink
+	sl.Status = database.SkylinkStatusUnreported
+	if !sl.Infected {
+		sl.RescanSkylink = rescanSkylink(sl.Skylink)
+		sl.Skylink = ""
+		sl.Status = database.SkylinkStatusComplete
+	}
+	s.applyPolicy(sl)
+	sl.Timestamp = database.Clock.Now().UTC()
+	err := s.staticDB.SkylinkSaveVerdict(s.staticCtx, sl)
+	if err != nil {
+		s.staticSampler.Debugf("update_failed", "updating a skylink's status failed: %s", err)
+		metricScanFailures.With(ErrKindDB).Inc()
+		return false, err
+	}
+	if sl.Infected {
+		s.emit(events.TypeInfected, sl, nil)
+	} else {
+		s.emit(events.TypeScanned, sl, nil)
+	}
+	s.pushVerdict(skylink, sl)
+	metricReusedVerdicts.Inc()
+	return true, nil
+}
+
+// applyCachedVerdict gives the locked skylink the verdict we reached for the
+// same content with the current signatures or newer ones, if it's still
+// cached, so we don't download and scan it again. This covers content which
+// is submitted again after its record was removed. It returns whether it
+// applied a verdict. Failing to look up the verdict doesn't fail the scan.
+func (s *Scanner) applyCachedVerdict(sl *database.Skylink) (bool, error) {
+	v, err := s.staticDB.CachedVerdict(s.staticCtx, sl.Hash, s.currentSignatureVersion())
+	if errors.Contains(err, database.ErrNoDocumentsFound) {
+		return false, nil
+	}
+	if err != nil {
+		s.staticSampler.Warnf("cached_verdict_failed", "failed to look up the cached verdict of hash %s: %s", sl.Hash.String(), err)
+		return false, nil
+	}
+	if v.Infected && s.withdrawn(v.InfectionDescription) {
+		return false, nil
+	}
+	skylink := sl.Skylink
+	sl.Status = database.SkylinkStatusUnreported
+	if !v.Infected {
+		sl.RescanSkylink = rescanSkylink(sl.Skylink)
+		sl.Skylink = ""
+		sl.Status = database.SkylinkStatusComplete
+	} else {
+		sl.RescanSkylink = ""
+	}
+	sl.Infected = v.Infected
+	sl.InfectionDescription = v.InfectionDescription
+	sl.Size = v.Size
+	sl.ScannedSize = v.ScannedSize
+	sl.ScannedAllContent = v.ScannedSize == v.Size
+	sl.ScannedAllOffsets = false
+	sl.Timestamp = database.Clock.Now().UTC()
+	sl.ScannedAt = sl.Timestamp
+	sl.SignatureVersion = v.SignatureVersion
+	sl.VerdictSource = ""
+	sl.LastErrorKind = ""
+	sl.LastError = ""
+	sl.NotFound = 0
+	sl.Filename = v.Filename
+	sl.ContentType = v.ContentType
+	sl.Directory = v.Directory
+	if v.Infected && AccountsDB != "" {
+		s.lookupUploaders(sl)
+	}
+	s.applyPolicy(sl)
+	err = s.staticDB.SkylinkSaveVerdict(s.staticCtx, sl)
+	if err != nil {
+		s.staticSampler.Debugf("update_failed", "updating a skylink's status failed: %s", err)
+		metricScanFailures.With(ErrKindDB).Inc()
+		return false, err
+	}
+	if v.Infected {
+		s.emit(events.TypeInfected, sl, nil)
+	} else {
+		s.emit(events.TypeScanned, sl, nil)
+	}
+	s.pushVerdict(skylink, sl)
+	metricCachedVerdicts.With(strconv.FormatBool(v.Infected)).Inc()
+	if !sl.SubmittedAt.IsZero() {
+		metricVerdictLatency.Observe(sl.ScannedAt.Sub(sl.SubmittedAt).Seconds())
+	}
+	return true, nil
+}
+
+// hasCurrentVerdict returns whether the skylink was scanned with the given
+// signature version or a newer one. Unknown versions never match.
+func hasCurrentVerdict(sl *database.Skylink, version int) bool {
+	return version > 0 && !sl.ScannedAt.IsZero() && sl.SignatureVersion >= version
+}
+
+// currentSignatureVersion returns the version of ClamAV's signature database.
+// It's cached for signatureVersionTTL and it's zero if we failed to look it
+// up.
+func (s *Scanner) currentSignatureVersion() int {
+	s.mu.Lock()
+	if database.Clock.Since(s.signatureVersionAt) < signatureVersionTTL {
+		defer s.mu.Unlock()
+		return s.signatureVersion
+	}
+	s.mu.Unlock()
+	var version int
+	si, err := s.staticClam.SignatureInfo()
+	if err != nil {
+		s.staticSampler.Debugf("signature_version_failed", "failed to look up ClamAV's signature version: %s", err)
+	} else {
+		version = si.Version
+	}
+	s.mu.Lock()
+	s.signatureVersion = version
+	s.signatureVersionAt = database.Clock.Now()
+	s.mu.Unlock()
+	return version
+}
+
+// signaturesChanged makes us look up the signature version again on next use.
+func (s *Scanner) signaturesChanged() {
+	s.mu.Lock()
+	s.signatureVersionAt = time.Time{}
+	s.mu.Unlock()
+}
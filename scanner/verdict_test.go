@@ -0,0 +1,31 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+)
+
+// TestHasCurrentVerdict ensures we only reuse verdicts reached with the
+// current signatures.
+func TestHasCurrentVerdict(t *testing.T) {
+	scanned := time.Now()
+	tests := []struct {
+		sl       database.Skylink
+		version  int
+		expected bool
+	}{
+		{database.Skylink{ScannedAt: scanned, SignatureVersion: 26390}, 26390, true},
+		{database.Skylink{ScannedAt: scanned, SignatureVersion: 26391}, 26390, true},
+		{database.Skylink{ScannedAt: scanned, SignatureVersion: 26389}, 26390, false},
+		{database.Skylink{ScannedAt: scanned}, 26390, false},
+		{database.Skylink{SignatureVersion: 26390}, 26390, false},
+		{database.Skylink{ScannedAt: scanned, SignatureVersion: 26390}, 0, false},
+	}
+	for i, tt := range tests {
+		if hasCurrentVerdict(&tt.sl, tt.version) != tt.expected {
+			t.Fatalf("%d: expected %t", i, tt.expected)
+		}
+	}
+}
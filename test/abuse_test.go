@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestAbuseReports ensures we page through the abuse-scanner's parsed emails
+// in the order they were received without skipping the ones received at the
+// same time, and take the reporter's name if they have no email address.
+func TestAbuseReports(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	coll := db.Collection("skylinks").Database().Client().Database("abuse").Collection("emails")
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	// email returns an email received at the given time which reports the
+	// given skylink.
+	email := func(at time.Time, parsed bool, name, address, skylink string) bson.M {
+		return bson.M{
+			"_id":         primitive.NewObjectID(),
+			"inserted_at": at,
+			"parsed":      parsed,
+			"parsed_reports": bson.A{bson.M{
+				"skylinks": bson.A{skylink},
+				"reporter": bson.M{"name": name, "email": address},
+			}},
+		}
+	}
+	// Three emails are received at the same time, one of them before it's
+	// parsed, and another one later.
+	emails := []interface{}{
+		email(t0, true, "Alice", "alice@example.com", "a"),
+		email(t0, true, "Bob", "", "b"),
+		email(t0, false, "Carol", "carol@example.com", "c"),
+		email(t0, true, "Dave", "dave@example.com", "d"),
+		email(t0.Add(time.Minute), true, "Erin", "erin@example.com", "e"),
+	}
+	if _, err := coll.InsertMany(ctx, emails); err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []database.AbuseReport
+	cursor := database.AbuseCursor{InsertedAt: t0.Add(-time.Hour)}
+	for pages := 0; ; pages++ {
+		page, next, err := db.AbuseReports(ctx, "abuse", "emails", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			if next != cursor || pages != 2 {
+				t.Fatalf("Expected the cursor to stay put after 2 pages, got %+v after %d pages", next, pages)
+			}
+			break
+		}
+		if len(page) > 2 {
+			t.Fatalf("Expected at most 2 reports per page, got %d", len(page))
+		}
+		reports = append(reports, page...)
+		cursor = next
+	}
+	expected := []struct{ reporter, skylink string }{
+		{"alice@example.com", "a"},
+		{"Bob", "b"},
+		{"dave@example.com", "d"},
+		{"erin@example.com", "e"},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("Expected %d reports, got %+v", len(expected), reports)
+	}
+	for i, r := range reports {
+		if r.Reporter != expected[i].reporter || len(r.Skylinks) != 1 || r.Skylinks[0] != expected[i].skylink {
+			t.Fatalf("Expected report %d by '%s' of '%s', got %+v", i, expected[i].reporter, expected[i].skylink, r)
+		}
+	}
+	last := emails[len(emails)-1].(bson.M)
+	if cursor.EmailID != last["_id"] || !cursor.InsertedAt.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("Expected the cursor at the last email, got %+v", cursor)
+	}
+}
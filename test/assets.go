@@ -0,0 +1,299 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+)
+
+const (
+	// MaxBombSize is the most the generated decompression bombs decompress
+	// to. It keeps them harmless to code which doesn't stop at bombs, while
+	// it's far beyond any decompression ratio we accept.
+	MaxBombSize = 64 << 20
+)
+
+type (
+	// Asset is a generated skyfile, as a portal serves it. Its content is
+	// generated when it's served, so large assets don't take up memory.
+	Asset struct {
+		// Metadata is the skyfile's metadata, which the MockPortal serves
+		// under /skynet/metadata/<skylink>.
+		Metadata skymodules.SkyfileMetadata
+		// ContentType is the type the portal serves the content with.
+		ContentType string
+		// Encoding is the Content-Encoding the portal serves the content
+		// with when the client accepts it. The portal decodes the content
+		// for clients which don't.
+		Encoding string
+		// Size is the size of the content once it's decoded, i.e. the size
+		// the scanner records.
+		Size int64
+		// Infected tells whether the content contains EICAR, which
+		// MockClam detects anywhere in it.
+		Infected bool
+
+		body     []byte
+		sparse   []int64
+		bodySize int64
+	}
+
+	// AssetFile is a file of a multi-file asset.
+	AssetFile struct {
+		Name        string
+		ContentType string
+		Content     []byte
+	}
+
+	// sparseReader reads zeros, with EICAR at the given offsets.
+	sparseReader struct {
+		size    int64
+		off     int64
+		markers []int64
+	}
+
+	// zeros reads zeros forever.
+	zeros struct{}
+)
+
+// TextAsset returns a small text file.
+func TextAsset(name, text string) Asset {
+	return newAsset(name, "text/plain; charset=utf-8", []byte(text), bytes.Contains([]byte(text), []byte(EICAR)))
+}
+
+// EICARAsset returns the EICAR test file.
+func EICARAsset(name string) Asset {
+	return newAsset(name, "application/octet-stream", []byte(EICAR), true)
+}
+
+// SparseAsset returns a large file of the given size which is all zeros,
+// except for EICAR at each of the given offsets. It's infected if there are
+// any, which lets tests place infections beyond the parts of large files
+// they expect to be scanned.
+func SparseAsset(name string, size int64, eicarAt ...int64) Asset {
+	return Asset{
+		Metadata:    fileMetadata(name, size),
+		ContentType: "application/octet-stream",
+		Size:        size,
+		Infected:    len(eicarAt) > 0,
+		sparse:      eicarAt,
+		bodySize:    size,
+	}
+}
+
+// ZipBombAsset returns a zip archive with a single file of zeros, which is
+// deflated from the given size, capped at MaxBombSize, to a fraction of it.
+func ZipBombAsset(name string, size int64) Asset {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("zeros")
+	if err == nil {
+		_, err = io.CopyN(f, zeros{}, bombSize(size))
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		panic(err)
+	}
+	return newAsset(name, "application/zip", buf.Bytes(), false)
+}
+
+// GzipBombAsset returns a file of zeros of the given size, capped at
+// MaxBombSize, which the portal serves gzip compressed to a fraction of it,
+// i.e. a decompression bomb to clients which accept gzip.
+func GzipBombAsset(name string, size int64) Asset {
+	size = bombSize(size)
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err == nil {
+		_, err = io.CopyN(gw, zeros{}, size)
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if err != nil {
+		panic(err)
+	}
+	a := newAsset(name, "application/octet-stream", buf.Bytes(), false)
+	a.Encoding = "gzip"
+	a.Size = size
+	a.Metadata.Length = uint64(size)
+	return a
+}
+
+// MultiFileAsset returns a skyfile of the given files, without a default path,
+// which portals serve as a zip archive of the files. The files are stored
+// uncompressed, so MockClam, which doesn't unpack archives, sees their content
+// like clamd does.
+func MultiFileAsset(name string, files ...AssetFile) Asset {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	md := skymodules.SkyfileMetadata{
+		Filename: name,
+		Subfiles: make(skymodules.SkyfileSubfiles),
+	}
+	infected := false
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Store})
+		if err == nil {
+			_, err = f.Write(file.Content)
+		}
+		if err != nil {
+			panic(err)
+		}
+		md.Subfiles[file.Name] = skymodules.SkyfileSubfileMetadata{
+			Filename:    file.Name,
+			ContentType: file.ContentType,
+			Offset:      md.Length,
+			Len:         uint64(len(file.Content)),
+		}
+		md.Length += uint64(len(file.Content))
+		infected = infected || bytes.Contains(file.Content, []byte(EICAR))
+	}
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	a := newAsset(name, "application/zip", buf.Bytes(), infected)
+	a.Metadata = md
+	return a
+}
+
+// StandardAssets returns a set of assets covering the kinds of content
+// tests should exercise, by name.
+func StandardAssets() map[string]Asset {
+	assets := []Asset{
+		TextAsset("readme.txt", "Hello, Skynet!\n"),
+		EICARAsset("eicar.com"),
+		SparseAsset("sparse.bin", 32<<20),
+		SparseAsset("sparse-infected.bin", 32<<20, 30<<20),
+		ZipBombAsset("bomb.zip", 16<<20),
+		GzipBombAsset("bomb.bin", 16<<20),
+		MultiFileAsset("site",
+			AssetFile{Name: "index.html", ContentType: "text/html", Content: []byte("<h1>Hello</h1>")},
+			AssetFile{Name: "app.js", ContentType: "application/javascript", Content: []byte("console.log('hello')")},
+		),
+		MultiFileAsset("infected-site",
+			AssetFile{Name: "index.html", ContentType: "text/html", Content: []byte("<h1>Hello</h1>")},
+			AssetFile{Name: "payload.com", ContentType: "application/octet-stream", Content: []byte(EICAR)},
+		),
+	}
+	m := make(map[string]Asset, len(assets))
+	for _, a := range assets {
+		m[a.Metadata.Filename] = a
+	}
+	return m
+}
+
+// Bytes returns the asset's content, as the portal serves it to clients
+// which accept its encoding.
+func (a Asset) Bytes() []byte {
+	b, err := io.ReadAll(a.open())
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// open returns a reader of the asset's content, as the portal serves it to
+// clients which accept its encoding.
+func (a Asset) open() io.ReadSeeker {
+	if a.body != nil {
+		return bytes.NewReader(a.body)
+	}
+	return &sparseReader{size: a.bodySize, markers: a.sparse}
+}
+
+// disposition returns the Content-Disposition the portal serves the asset
+// with. Like skyd, it serves single files inline and attaches the archives of
+// directories.
+func (a Asset) disposition() string {
+	if a.Metadata.IsDirectory() {
+		return mime.FormatMediaType("attachment", map[string]string{"filename": a.Metadata.Filename + ".zip"})
+	}
+	return mime.FormatMediaType("inline", map[string]string{"filename": a.Metadata.Filename})
+}
+
+// newAsset returns an asset of the given content.
+func newAsset(name, contentType string, content []byte, infected bool) Asset {
+	return Asset{
+		Metadata:    fileMetadata(name, int64(len(content))),
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Infected:    infected,
+		body:        content,
+		bodySize:    int64(len(content)),
+	}
+}
+
+// fileMetadata returns the metadata of a single file skyfile.
+func fileMetadata(name string, size int64) skymodules.SkyfileMetadata {
+	return skymodules.SkyfileMetadata{
+		Filename: name,
+		Length:   uint64(size),
+	}
+}
+
+// bombSize caps the given size at MaxBombSize.
+func bombSize(size int64) int64 {
+	if size > MaxBombSize {
+		return MaxBombSize
+	}
+	return size
+}
+
+// Read implements io.Reader.
+func (r *sparseReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.off {
+		p = p[:r.size-r.off]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	for _, m := range r.markers {
+		// Copy the part of EICAR at m which overlaps p.
+		start, end := m-r.off, m-r.off+int64(len(EICAR))
+		if end <= 0 || start >= int64(len(p)) {
+			continue
+		}
+		src := []byte(EICAR)
+		if start < 0 {
+			src, start = src[-start:], 0
+		}
+		copy(p[start:], src)
+	}
+	r.off += int64(len(p))
+	return len(p), nil
+}
+
+// Seek implements io.Seeker.
+func (r *sparseReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Read implements io.Reader.
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
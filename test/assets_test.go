@@ -0,0 +1,159 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+)
+
+// TestAssets ensures the generated assets have the content and metadata they
+// describe.
+func TestAssets(t *testing.T) {
+	// EICAR straddles the reads of a small buffer, and sits at the start.
+	a := SparseAsset("sparse.bin", 1000, 0, 500)
+	r := a.open()
+	var content []byte
+	buf := make([]byte, 7)
+	for {
+		n, err := r.Read(buf)
+		content = append(content, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(content) != 1000 || !bytes.HasPrefix(content, []byte(EICAR)) || !bytes.Equal(content[500:500+len(EICAR)], []byte(EICAR)) {
+		t.Fatalf("Unexpected sparse content %q", content)
+	}
+	if _, err := r.Seek(990, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.Read(buf); n != 7 {
+		t.Fatalf("Expected to read 7 bytes, got %d", n)
+	}
+	if n, err := r.Read(buf); n != 3 || err != nil {
+		t.Fatalf("Expected to read the last 3 bytes, got %d, %v", n, err)
+	}
+
+	site := MultiFileAsset("site",
+		AssetFile{Name: "index.html", ContentType: "text/html", Content: []byte("<h1>Hello</h1>")},
+		AssetFile{Name: "payload.com", Content: []byte(EICAR)},
+	)
+	if !site.Infected || site.Metadata.Length != uint64(14+len(EICAR)) || site.Metadata.Subfiles["payload.com"].Offset != 14 {
+		t.Fatalf("Unexpected multi-file asset %+v", site)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(site.Bytes()), site.Size)
+	if err != nil || len(zr.File) != 2 {
+		t.Fatalf("Expected a zip archive of 2 files, got %v", err)
+	}
+
+	bomb := GzipBombAsset("bomb.bin", 1<<40)
+	if bomb.Size != MaxBombSize || len(bomb.Bytes()) > MaxBombSize/500 {
+		t.Fatalf("Expected a bomb of %d bytes compressed by more than 500x, got %d bytes compressed to %d", MaxBombSize, bomb.Size, len(bomb.Bytes()))
+	}
+}
+
+// TestMockPortalAssets ensures MockPortal serves assets with their content
+// type, encoding and metadata.
+func TestMockPortalAssets(t *testing.T) {
+	portal := NewMockPortal()
+	defer portal.Close()
+	site := StandardAssets()["site"]
+	portal.SetAsset("site", site)
+	portal.SetAsset("bomb", GzipBombAsset("bomb.bin", 1<<20))
+
+	resp, err := http.Get(portal.URL + "/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || resp.Header.Get("Content-Type") != "application/zip" || !bytes.Equal(body, site.Bytes()) {
+		t.Fatalf("Unexpected response %v %v", resp.Header, err)
+	}
+
+	resp, err = http.Get(portal.URL + "/skynet/metadata/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md skymodules.SkyfileMetadata
+	err = json.NewDecoder(resp.Body).Decode(&md)
+	_ = resp.Body.Close()
+	if err != nil || len(md.Subfiles) != 2 || md.Subfiles["index.html"].ContentType != "text/html" {
+		t.Fatalf("Unexpected metadata %+v, %v", md, err)
+	}
+
+	// Clients which don't accept gzip get the decoded content.
+	req, err := http.NewRequest(http.MethodGet, portal.URL+"/bomb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || n != 1<<20 || resp.ContentLength != 1<<20 || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("Expected 1 MiB of decoded content, got %d bytes, %v", n, err)
+	}
+}
+
+// TestAssetPipeline ensures skylinks of every kind of asset go through the
+// scanner's pipeline with the right verdict and size on their records.
+func TestAssetPipeline(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	var assets []Asset
+	for _, a := range StandardAssets() {
+		if a.Encoding == "" {
+			assets = append(assets, a)
+		}
+	}
+	sls := queueSkylinks(t, db, e.portal.URL, len(assets))
+	for i, sl := range sls {
+		e.portal.SetAsset(sl.Skylink, assets[i])
+	}
+
+	s := newReportScanner(ctx, t, db, e)
+	for {
+		err := s.RunOnce()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, sl := range sls {
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := assets[i]
+		if saved.Infected != a.Infected || saved.Size != uint64(a.Size) || !saved.ScannedAllContent {
+			t.Fatalf("Unexpected record of %s: %+v", a.Metadata.Filename, saved)
+		}
+		// Directories are served as zip archives.
+		filename := a.Metadata.Filename
+		if a.Metadata.IsDirectory() {
+			filename += ".zip"
+		}
+		contentType, _, _ := mime.ParseMediaType(a.ContentType)
+		if saved.Filename != filename || saved.ContentType != contentType || saved.Directory != a.Metadata.IsDirectory() {
+			t.Fatalf("Unexpected skyfile metadata of %s: %s, %s, %t", a.Metadata.Filename, saved.Filename, saved.ContentType, saved.Directory)
+		}
+	}
+}
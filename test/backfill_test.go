@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestBackfill ensures backfills count each batch once, even when it's sent
+// again after an interruption, that backfilled skylinks are only scanned once
+// the regular submissions are and that the backfill counts their verdicts.
+func TestBackfill(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	queued := queueSkylinks(t, db, "http://portal.invalid", 1)
+	sls := make([]*database.Skylink, 4)
+	for i := range sls {
+		skylink, err := skymodules.NewSkylinkV1(crypto.HashBytes([]byte(fmt.Sprint("backfill", i))), 0, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sls[i] = &database.Skylink{}
+		if err = sls[i].LoadString(skylink.String(), "http://portal.invalid"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := db.BackfillCreate(ctx, "skylinks.txt", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first batch also lists the regular submission, which keeps its
+	// priority, and one skylink which failed to parse.
+	_, err = db.BackfillEnqueue(ctx, job.ID, append(sls[:2:2], queued[0]), 1, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sending the batch again, e.g. after an interruption, doesn't count it
+	// twice.
+	j, err := db.BackfillEnqueue(ctx, job.ID, sls[:2], 0, 0, 4)
+	if !errors.Contains(err, database.ErrBackfillOffset) || j == nil || j.Offset != 4 {
+		t.Fatalf("Expected ErrBackfillOffset at offset 4, got %+v, %v", j, err)
+	}
+	j, err = db.BackfillEnqueue(ctx, job.ID, sls[2:], 0, 4, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Offset != 6 || j.Enqueued != 5 || j.Failed != 1 {
+		t.Fatalf("Unexpected backfill %+v", j)
+	}
+
+	// The regular submission is locked first.
+	locked, err := db.SweepAndLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked.Hash != queued[0].Hash {
+		t.Fatalf("Expected the regular submission to be locked first, got %+v", locked)
+	}
+	locked.Status = database.SkylinkStatusComplete
+	if err = db.SkylinkSaveVerdict(ctx, locked); err != nil {
+		t.Fatal(err)
+	}
+	for range sls {
+		locked, err = db.SweepAndLock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked.Priority != database.PriorityLow || locked.Backfill == nil || *locked.Backfill != job.ID {
+			t.Fatalf("Expected a backfilled skylink, got %+v", locked)
+		}
+		locked.Status = database.SkylinkStatusComplete
+		if err = db.SkylinkSaveVerdict(ctx, locked); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = db.SweepAndLock(ctx); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected an empty queue, got %v", err)
+	}
+	j, err = db.Backfill(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Completed != 5 {
+		t.Fatalf("Expected 5 completed skylinks, got %+v", j)
+	}
+}
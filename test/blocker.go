@@ -0,0 +1,169 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	blockapi "github.com/SkynetLabs/blocker/api"
+)
+
+// MockBlocker is an in-process blocker. It serves POST /block, the only
+// endpoint of blocker the scanner calls. It remembers the skylinks it blocked
+// and responds to repeated blocks like blocker does. Its responses can be delayed and failed on demand.
+type MockBlocker struct {
+	*httptest.Server
+
+	blocked  map[string][]string
+	blocks   map[string]int
+	chaos    *chaos
+	failures []int
+	latency  time.Duration
+	onBlock  func(skylink string)
+	requests int
+	mu       sync.Mutex
+}
+
+// NewMockBlocker starts a MockBlocker listening on a random local port.
+func NewMockBlocker() *MockBlocker {
+	mb := &MockBlocker{
+		blocked: make(map[string][]string),
+		blocks:  make(map[string]int),
+	}
+	mb.Server = httptest.NewServer(http.HandlerFunc(mb.serve))
+	return mb
+}
+
+// Blocked returns whether the mock has blocked the given skylink.
+func (mb *MockBlocker) Blocked(skylink string) bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	_, ok := mb.blocked[skylink]
+	return ok
+}
+
+// Tags returns the tags the given skylink was blocked with.
+func (mb *MockBlocker) Tags(skylink string) []string {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.blocked[skylink]
+}
+
+// Blocks returns the number of valid block requests the mock has received for
+// the given skylink, including repeated ones.
+func (mb *MockBlocker) Blocks(skylink string) int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.blocks[skylink]
+}
+
+// OnBlock makes the mock call the given function with every skylink it
+// blocks, before it responds.
+func (mb *MockBlocker) OnBlock(f func(skylink string)) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.onBlock = f
+}
+
+// Fail makes the mock respond to its next requests with the given status
+// codes, one per request, before it handles requests again.
+func (mb *MockBlocker) Fail(statuses ...int) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.failures = append(mb.failures, statuses...)
+}
+
+// SetChaos makes the mock inject the given faults into its responses. Failed
+// requests get a 500 and partial ones get their connection closed without a
+// response. Faults are injected before a request is handled, so a failed
+// block request doesn't block the skylink.
+func (mb *MockBlocker) SetChaos(c Chaos) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.chaos = newChaos(c)
+}
+
+// Faults returns the number of requests the mock injected a fault into.
+func (mb *MockBlocker) Faults() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.chaos.injected()
+}
+
+// SetLatency delays all responses of the mock by the given duration.
+func (mb *MockBlocker) SetLatency(d time.Duration) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.latency = d
+}
+
+// Requests returns the number of requests the mock has received.
+func (mb *MockBlocker) Requests() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.requests
+}
+
+// serve handles a single request.
+func (mb *MockBlocker) serve(w http.ResponseWriter, r *http.Request) {
+	mb.mu.Lock()
+	mb.requests++
+	latency := mb.latency
+	status := 0
+	if len(mb.failures) > 0 {
+		status, mb.failures = mb.failures[0], mb.failures[1:]
+	}
+	chaosLatency, f := mb.chaos.draw()
+	mb.mu.Unlock()
+
+	if !sleep(r, latency+chaosLatency) {
+		return
+	}
+	switch f {
+	case faultError:
+		status = http.StatusInternalServerError
+	case faultPartial:
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				_ = conn.Close()
+				return
+			}
+		}
+		status = http.StatusInternalServerError
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/block":
+		// Decode the body into blocker's own type, so the mock rejects
+		// what blocker would.
+		var body blockapi.BlockPOST
+		if json.NewDecoder(r.Body).Decode(&body) != nil || body.Skylink == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mb.mu.Lock()
+		mb.blocks[body.Skylink]++
+		_, dup := mb.blocked[body.Skylink]
+		if !dup {
+			mb.blocked[body.Skylink] = body.Tags
+		}
+		onBlock := mb.onBlock
+		mb.mu.Unlock()
+		if !dup && onBlock != nil {
+			onBlock(body.Skylink)
+		}
+		if dup {
+			// Blocker responds to repeated blocks with a JSON string.
+			_, _ = w.Write([]byte(`"skylink already exists"`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
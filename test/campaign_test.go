@@ -0,0 +1,84 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestCampaign ensures a re-scan campaign queues the complete records which
+// match its criteria and kept their skylink, and summarizes how their
+// verdicts changed.
+func TestCampaign(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, "http://portal.invalid", 4)
+	for i := range sls {
+		sl, err := db.SweepAndLock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sl.Size = uint64(1000 * (i + 1))
+		sl.ScannedAt = database.Clock.Now().UTC()
+		sl.Status = database.SkylinkStatusComplete
+		// The first record is infected and doesn't keep its skylink, all
+		// others are clean.
+		if i == 0 {
+			sl.Infected = true
+		} else {
+			sl.RescanSkylink = sl.Skylink
+		}
+		sl.Skylink = ""
+		if err = db.SkylinkSaveVerdict(ctx, sl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The largest record is out of range.
+	c, err := db.CampaignCreate(ctx, database.CampaignCriteria{MaxSize: 3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Queued != 2 || c.Skipped != 1 {
+		t.Fatalf("Expected 2 queued and 1 skipped records, got %+v", c)
+	}
+	c, err = db.Campaign(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Summary != (database.CampaignSummary{Pending: 2}) {
+		t.Fatalf("Unexpected summary %+v", c.Summary)
+	}
+
+	// One of the records turns out to be infected.
+	for i := 0; i < 2; i++ {
+		sl, err := db.SweepAndLock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sl.Skylink == "" || sl.Priority != database.PriorityLow || sl.Campaign == nil || *sl.Campaign != c.ID {
+			t.Fatalf("Expected a queued campaign record, got %+v", sl)
+		}
+		sl.Status = database.SkylinkStatusComplete
+		if i == 0 {
+			sl.Status = database.SkylinkStatusUnreported
+			sl.Infected = true
+		}
+		if err = db.SkylinkSaveVerdict(ctx, sl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = db.SweepAndLock(ctx); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected an empty queue, got %v", err)
+	}
+	c, err = db.Campaign(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Summary != (database.CampaignSummary{Completed: 2, Infected: 1, NewlyInfected: 1}) {
+		t.Fatalf("Unexpected summary %+v", c.Summary)
+	}
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// faultNone means a call is handled normally.
+	faultNone fault = iota
+	// faultError means a call fails with an error response.
+	faultError
+	// faultPartial means a call's response is cut short and its connection
+	// closed.
+	faultPartial
+)
+
+type (
+	// Chaos configures the faults a mock injects into a share of its calls,
+	// so soak tests can check how the scanner copes with flaky services.
+	// The rates are the shares of calls, between 0 and 1, which are delayed
+	// by Latency, fail with an error response or get a partial response.
+	// Faults are drawn from a generator seeded with Seed, so runs are
+	// reproducible.
+	Chaos struct {
+		Latency     time.Duration
+		LatencyRate float64
+		ErrorRate   float64
+		PartialRate float64
+		Seed        int64
+	}
+
+	// fault is the kind of fault injected into a call.
+	fault int
+
+	// chaos draws the faults of a mock's calls.
+	chaos struct {
+		faults int
+
+		staticConfig Chaos
+		staticRand   *rand.Rand
+		mu           sync.Mutex
+	}
+)
+
+// newChaos returns a chaos which injects the configured faults, or nil if
+// there aren't any.
+func newChaos(c Chaos) *chaos {
+	if c.LatencyRate <= 0 && c.ErrorRate <= 0 && c.PartialRate <= 0 {
+		return nil
+	}
+	return &chaos{
+		staticConfig: c,
+		staticRand:   rand.New(rand.NewSource(c.Seed)),
+	}
+}
+
+// draw returns the latency and the fault to inject into the next call. It's
+// a no-op for a nil chaos.
+func (c *chaos) draw() (time.Duration, fault) {
+	if c == nil {
+		return 0, faultNone
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var latency time.Duration
+	if c.staticRand.Float64() < c.staticConfig.LatencyRate {
+		latency = c.staticConfig.Latency
+	}
+	f := faultNone
+	switch r := c.staticRand.Float64(); {
+	case r < c.staticConfig.ErrorRate:
+		f = faultError
+	case r < c.staticConfig.ErrorRate+c.staticConfig.PartialRate:
+		f = faultPartial
+	}
+	if latency > 0 || f != faultNone {
+		c.faults++
+	}
+	return latency, f
+}
+
+// injected returns the number of calls a fault was injected into. It's zero
+// for a nil chaos.
+func (c *chaos) injected() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.faults
+}
@@ -0,0 +1,270 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// EICAR is the standard antivirus test file. MockClam reports it as
+	// infected.
+	EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+	// EICARSignature is the signature name MockClam reports for EICAR.
+	EICARSignature = "Win.Test.EICAR_HDB-1"
+	// MockClamVersion is the response MockClam gives to the VERSION command.
+	MockClamVersion = "ClamAV 0.104.1/26390/Tue Dec  7 09:21:39 2021"
+	// mockClamError is the response MockClam gives to INSTREAM commands it
+	// fails on purpose.
+	mockClamError = "INSTREAM size limit exceeded. ERROR"
+)
+
+// errPartial is returned by MockClam.respond when the response should be cut
+// short.
+var errPartial = errors.New("partial response")
+
+// MockClam is an in-process server which speaks enough of the clamd protocol
+// for tests: PING, VERSION and INSTREAM, on their own or within an IDSESSION.
+// It reports any stream containing one of its signatures' patterns as
+// infected.
+type MockClam struct {
+	signatures  map[string]string
+	chaos       *chaos
+	scans       int
+	connections int
+	open        map[net.Conn]struct{}
+	closed      bool
+
+	staticListener net.Listener
+	staticWG       sync.WaitGroup
+	mu             sync.Mutex
+}
+
+// NewMockClam starts a MockClam listening on a random local port. It knows
+// the EICAR signature.
+func NewMockClam() (*MockClam, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	mc := &MockClam{
+		signatures:     map[string]string{EICAR: EICARSignature},
+		open:           make(map[net.Conn]struct{}),
+		staticListener: l,
+	}
+	mc.staticWG.Add(1)
+	go mc.threadedAccept()
+	return mc, nil
+}
+
+// Addr returns the IP and port the mock is listening on.
+func (mc *MockClam) Addr() (string, string) {
+	addr := mc.staticListener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), fmt.Sprint(addr.Port)
+}
+
+// AddSignature makes the mock report streams containing the pattern as
+// infected with the given signature.
+func (mc *MockClam) AddSignature(pattern, signature string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.signatures[pattern] = signature
+}
+
+// SetChaos makes the mock inject the given faults into its scans. Failed
+// scans get an ERROR response and partial ones get half of their response
+// before the connection is closed.
+func (mc *MockClam) SetChaos(c Chaos) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.chaos = newChaos(c)
+}
+
+// Faults returns the number of scans the mock injected a fault into.
+func (mc *MockClam) Faults() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.chaos.injected()
+}
+
+// Close stops the mock, closes the open connections, such as idle sessions,
+// and waits for all of them to be handled.
+func (mc *MockClam) Close() error {
+	err := mc.staticListener.Close()
+	mc.mu.Lock()
+	mc.closed = true
+	for conn := range mc.open {
+		_ = conn.Close()
+	}
+	mc.mu.Unlock()
+	mc.staticWG.Wait()
+	return err
+}
+
+// Scans returns the number of INSTREAM commands the mock has handled.
+func (mc *MockClam) Scans() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.scans
+}
+
+// Connections returns the number of connections the mock has accepted.
+func (mc *MockClam) Connections() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.connections
+}
+
+// threadedAccept accepts connections until the listener is closed.
+func (mc *MockClam) threadedAccept() {
+	defer mc.staticWG.Done()
+	for {
+		conn, err := mc.staticListener.Accept()
+		if err != nil {
+			return
+		}
+		mc.mu.Lock()
+		mc.connections++
+		if mc.closed {
+			_ = conn.Close()
+		}
+		mc.open[conn] = struct{}{}
+		mc.mu.Unlock()
+		mc.staticWG.Add(1)
+		go func() {
+			defer mc.staticWG.Done()
+			defer func() { _ = conn.Close() }()
+			mc.handle(conn)
+		}()
+	}
+}
+
+// handle serves a single command on the given connection, or all commands of
+// a session until it's ended.
+func (mc *MockClam) handle(conn net.Conn) {
+	defer func() {
+		mc.mu.Lock()
+		delete(mc.open, conn)
+		mc.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	cmd, delim, err := readCommand(r)
+	if err != nil {
+		return
+	}
+	if cmd != "IDSESSION" {
+		resp, err := mc.respond(cmd, r)
+		if err == nil {
+			_, _ = conn.Write([]byte(resp + string(delim)))
+		} else if errors.Contains(err, errPartial) {
+			_, _ = conn.Write([]byte(resp))
+		}
+		return
+	}
+	// Responses within a session are prefixed with the request's ID.
+	for id := 1; ; id++ {
+		cmd, delim, err = readCommand(r)
+		if err != nil || cmd == "END" {
+			return
+		}
+		resp, err := mc.respond(cmd, r)
+		if errors.Contains(err, errPartial) {
+			_, _ = conn.Write([]byte(fmt.Sprintf("%d: %s", id, resp)))
+		}
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(fmt.Sprintf("%d: %s%c", id, resp, delim)))
+	}
+}
+
+// respond returns the response to the given command. It returns errPartial
+// along with the part of the response to send if it's cut short.
+func (mc *MockClam) respond(cmd string, r io.Reader) (string, error) {
+	switch cmd {
+	case "PING":
+		return "PONG", nil
+	case "VERSION":
+		return MockClamVersion, nil
+	case "INSTREAM":
+		data, err := readStream(r)
+		if err != nil {
+			return "", err
+		}
+		mc.mu.Lock()
+		latency, f := mc.chaos.draw()
+		mc.mu.Unlock()
+		time.Sleep(latency)
+		switch f {
+		case faultError:
+			return mockClamError, nil
+		case faultPartial:
+			resp := mc.verdict(data)
+			return resp[:len(resp)/2], errPartial
+		}
+		return mc.verdict(data), nil
+	}
+	return "UNKNOWN COMMAND", nil
+}
+
+// readCommand reads a command and returns it along with its delimiter, which is
+// a newline for "n"-prefixed commands and a null byte for "z"-prefixed ones.
+func readCommand(r *bufio.Reader) (string, byte, error) {
+	delim := byte('\n')
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return "", delim, err
+	}
+	if prefix == 'z' {
+		delim = 0
+	} else if prefix != 'n' {
+		_ = r.UnreadByte()
+	}
+	cmd, err := r.ReadString(delim)
+	if err != nil {
+		return "", delim, err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(cmd, string(delim))), delim, nil
+}
+
+// verdict returns the clamd response for the given content.
+func (mc *MockClam) verdict(data []byte) string {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.scans++
+	for pattern, sig := range mc.signatures {
+		if bytes.Contains(data, []byte(pattern)) {
+			return fmt.Sprintf("stream: %s FOUND", sig)
+		}
+	}
+	return "stream: OK"
+}
+
+// readStream reads INSTREAM chunks until the zero-length terminator.
+func readStream(r io.Reader) ([]byte, error) {
+	var data []byte
+	for {
+		var size uint32
+		err := binary.Read(r, binary.BigEndian, &size)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size)
+		_, err = io.ReadFull(r, chunk)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+	}
+}
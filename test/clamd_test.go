@@ -0,0 +1,52 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+)
+
+// TestRealClamd ensures a real clamd gives the verdicts MockClam mimics, so
+// the tests which run against MockClam stay representative.
+func TestRealClamd(t *testing.T) {
+	ip, port := containers.ClamAV(t)
+	c, err := clamav.New(ip, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	mc, err := NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mc.Close() }()
+	mip, mport := mc.Addr()
+	mock, err := clamav.New(mip, mport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mock.Close() }()
+	abort := make(chan bool)
+	defer close(abort)
+
+	tests := map[string][]byte{
+		"clean": Content(1 << 20),
+		"eicar": []byte(EICAR),
+	}
+	for name, content := range tests {
+		inf, desc, err := c.Scan(bytes.NewReader(content), abort)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		minf, mdesc, err := mock.Scan(bytes.NewReader(content), abort)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if inf != minf || !strings.Contains(desc, mdesc) {
+			t.Fatalf("%s: clamd reported %t, '%s', MockClam reported %t, '%s'", name, inf, desc, minf, mdesc)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestConfirm ensures a confirmation only confirms the action with the method,
+// path and query it was requested for, once and before it expires.
+func TestConfirm(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = fake
+	t.Cleanup(func() { database.Clock = real })
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+
+	path := "/admin/signatures/Win.Test.EICAR_HDB-1/withdraw"
+	request := func(id string) {
+		t.Helper()
+		err := db.RequestConfirmation(ctx, database.Confirmation{
+			ID:        id,
+			Action:    "withdraw-signature",
+			Method:    http.MethodPost,
+			Path:      path,
+			Query:     "reason=upstream",
+			Requester: "alice",
+			ExpiresAt: fake.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request("a")
+	if _, err := db.Confirm(ctx, "a", http.MethodPost, path, "reason=other", ""); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected other parameters not to be confirmed, got %v", err)
+	}
+	if _, err := db.Confirm(ctx, "a", http.MethodPost, path, "reason=upstream", "alice"); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected the requester not to confirm, got %v", err)
+	}
+	c, err := db.Confirm(ctx, "a", http.MethodPost, path, "reason=upstream", "bob")
+	if err != nil || c.Requester != "alice" {
+		t.Fatalf("Unexpected confirmation %+v, %v", c, err)
+	}
+	if _, err = db.Confirm(ctx, "a", http.MethodPost, path, "reason=upstream", ""); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected the confirmation to be used up, got %v", err)
+	}
+
+	request("b")
+	fake.Advance(2 * time.Minute)
+	if _, err = db.Confirm(ctx, "b", http.MethodPost, path, "reason=upstream", ""); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected the confirmation to expire, got %v", err)
+	}
+}
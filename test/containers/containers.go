@@ -0,0 +1,129 @@
+// Package containers starts ephemeral MongoDB and ClamAV containers for
+// integration tests. Every container belongs to the test which started it and
+// is removed when the test finishes. Tests which need one are skipped in short
+// mode and when Docker isn't available, so the regular test suite doesn't
+// depend on Docker.
+package containers
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	accdb "github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	// MongoImage is the image of the MongoDB containers.
+	MongoImage = "mongo:4.4"
+	// ClamAVImage is the image of the ClamAV containers. It comes with
+	// signatures, so clamd doesn't need to download them.
+	ClamAVImage = "clamav/clamav:0.104"
+
+	// mongoUser and mongoPassword are the credentials of the root user of
+	// the MongoDB containers.
+	mongoUser     = "admin"
+	mongoPassword = "aO4tV5tC1oU3oQ7u"
+
+	// startupTimeout is how long we wait for a container to be ready,
+	// including pulling its image.
+	startupTimeout = 5 * time.Minute
+)
+
+// MongoCredentials starts a MongoDB container and returns the credentials of
+// its root user.
+func MongoCredentials(t testing.TB) accdb.DBCredentials {
+	c := start(t, testcontainers.ContainerRequest{
+		Image:        MongoImage,
+		ExposedPorts: []string{"27017/tcp"},
+		Env: map[string]string{
+			"MONGO_INITDB_ROOT_USERNAME": mongoUser,
+			"MONGO_INITDB_ROOT_PASSWORD": mongoPassword,
+		},
+		// The server logs this once for the initialization with the
+		// credentials and once when it's ready.
+		WaitingFor: wait.ForLog("Waiting for connections").WithOccurrence(2).WithStartupTimeout(startupTimeout),
+	})
+	host, port := endpoint(t, c, "27017/tcp")
+	return accdb.DBCredentials{
+		User:     mongoUser,
+		Password: mongoPassword,
+		Host:     host,
+		Port:     port,
+	}
+}
+
+// MongoDB starts a MongoDB container and connects to it.
+func MongoDB(t testing.TB) *database.DB {
+	creds := MongoCredentials(t)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	db, err := database.New(ctx, creds, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// ClamAV starts a clamd container and returns the IP and port it listens on.
+func ClamAV(t testing.TB) (string, string) {
+	c := start(t, testcontainers.ContainerRequest{
+		Image:        ClamAVImage,
+		ExposedPorts: []string{"3310/tcp"},
+		// clamd only listens once it's loaded the signatures.
+		WaitingFor: wait.ForListeningPort("3310/tcp").WithStartupTimeout(startupTimeout),
+	})
+	return endpoint(t, c, "3310/tcp")
+}
+
+// start starts a container and removes it when the test finishes. It skips
+// the test in short mode or when Docker isn't available.
+func start(t testing.TB, req testcontainers.ContainerRequest) testcontainers.Container {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping the container tests in short mode")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		t.Skipf("Docker isn't available: %v", err)
+	}
+	if err = provider.Health(ctx); err != nil {
+		t.Skipf("Docker isn't available: %v", err)
+	}
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start a %s container: %v", req.Image, err)
+	}
+	t.Cleanup(func() { _ = c.Terminate(context.Background()) })
+	return c
+}
+
+// endpoint returns the host and port under which the given port of the
+// container is reachable.
+func endpoint(t testing.TB, c testcontainers.Container, port nat.Port) (string, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	host, err := c.Host(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := c.MappedPort(ctx, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, mapped.Port()
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/notify"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// digestRecorder is a Notifier which records all notifications it receives.
+type digestRecorder struct {
+	alerts []notify.Alert
+}
+
+// Notify implements notify.Notifier.
+func (r *digestRecorder) Notify(_ context.Context, a notify.Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+// TestDigest ensures digests summarize the infections found within their
+// period and the state of the queue.
+func TestDigest(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, e.portal.URL, 3)
+	e.portal.SetAsset(sls[0].Skylink, EICARAsset("eicar.com"))
+	e.portal.SetAsset(sls[1].Skylink, EICARAsset("eicar.txt"))
+	e.portal.SetAsset(sls[2].Skylink, TextAsset("clean.txt", "clean"))
+	s := newReportScanner(ctx, t, db, e)
+	for {
+		err := s.RunOnce()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := database.Clock.Now().UTC()
+	d, err := s.BuildDigest(now.Add(-scanner.DigestInterval), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Scanned != 3 || d.Infected != 2 {
+		t.Fatalf("Expected 2 out of 3 skylinks to be infected, got %d out of %d", d.Infected, d.Scanned)
+	}
+	if len(d.Signatures) != 1 || d.Signatures[0].Count != 2 {
+		t.Fatalf("Expected one signature detected twice, got %+v", d.Signatures)
+	}
+	if len(d.Failures) != 0 {
+		t.Fatalf("Expected no failed scans, got %+v", d.Failures)
+	}
+	if d.Queue.New != 0 || d.Queue.Unreported != 2 || d.Queue.PendingReview != 0 || !d.OldestQueued.IsZero() {
+		t.Fatalf("Expected 2 unreported skylinks, got %+v", d.Queue)
+	}
+
+	// The digest is delivered as an informational notification.
+	rec := &digestRecorder{}
+	if err = s.SendDigest(rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.alerts) != 1 || rec.alerts[0].Severity != notify.SeverityInfo || rec.alerts[0].Message == "" {
+		t.Fatalf("Expected an informational digest, got %+v", rec.alerts)
+	}
+	if sent, ok := rec.alerts[0].Details.(*scanner.Digest); !ok || sent.Infected != 2 {
+		t.Fatalf("Expected the digest in the details, got %+v", rec.alerts[0].Details)
+	}
+}
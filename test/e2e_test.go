@@ -0,0 +1,167 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// env holds the mocks of an end-to-end test and the clients which talk to
+// them.
+type env struct {
+	clam    *MockClam
+	portal  *MockPortal
+	blocker *MockBlocker
+
+	scanner *clamav.ClamAV
+	client  *blocker.Client
+}
+
+// newEnv starts a mock clamd, portal and blocker and connects to them.
+func newEnv(t *testing.T, opts blocker.Options) *env {
+	mc, err := NewMockClam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mc.Close() })
+	portal := NewMockPortal()
+	t.Cleanup(portal.Close)
+	mb := NewMockBlocker()
+	t.Cleanup(mb.Close)
+	ip, port := mc.Addr()
+	c, err := clamav.New(ip, port, portal.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	bc, err := blocker.New(mb.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &env{clam: mc, portal: portal, blocker: mb, scanner: c, client: bc}
+}
+
+// scanAndReport runs a submitted skylink through the scanner's pipeline: it
+// resolves the skylink, scans its content and reports it to blocker if it's
+// infected.
+func (e *env) scanAndReport(skylink string) (*database.Skylink, *database.BlockerResponse, error) {
+	sl, err := e.scan(skylink)
+	if err != nil || !sl.Infected {
+		return sl, nil, err
+	}
+	br, err := e.client.Block(context.Background(), sl.Skylink)
+	return sl, br, err
+}
+
+// scan resolves the given skylink and scans its content.
+func (e *env) scan(skylink string) (*database.Skylink, error) {
+	var sl database.Skylink
+	err := sl.LoadString(skylink, e.portal.URL)
+	if err != nil {
+		return nil, err
+	}
+	abort := make(chan bool)
+	defer close(abort)
+	r := e.scanner.ScanSkylink(skylink, abort)
+	sl.Infected, sl.InfectionDescription, sl.Size, sl.ScannedSize = r.Infected, r.Description, r.Size, r.ScannedSize
+	return &sl, r.Err
+}
+
+// testSkylinks returns a v1 skylink and a v2 skylink, which the caller can
+// make the mock portal resolve to the v1 one.
+func testSkylinks(t *testing.T) (string, string) {
+	v1, err := skymodules.NewSkylinkV1(crypto.HashBytes([]byte("e2e")), 0, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2 := skymodules.NewSkylinkV2(types.SiaPublicKey{Key: make([]byte, 32)}, crypto.HashBytes([]byte("e2e")))
+	return v1.String(), v2.String()
+}
+
+// TestEndToEnd ensures infected skylinks make it from submission through the
+// scan to a block, and clean ones don't.
+func TestEndToEnd(t *testing.T) {
+	e := newEnv(t, blocker.Options{})
+	infected, v2 := testSkylinks(t)
+	e.portal.SetContent(infected, []byte("prefix "+EICAR))
+	e.portal.SetV2(v2, infected)
+
+	sl, br, err := e.scanAndReport(infected)
+	if err != nil || !sl.Infected || sl.InfectionDescription != EICARSignature || !br.Succeeded() || br.Result != database.BlockerResultBlocked {
+		t.Fatalf("Unexpected result %+v %+v %v", sl, br, err)
+	}
+	if !e.blocker.Blocked(infected) || len(e.blocker.Tags(infected)) != 1 || e.blocker.Tags(infected)[0] != blocker.MalwareTag {
+		t.Fatalf("Expected the skylink to be blocked as malware, got tags %v", e.blocker.Tags(infected))
+	}
+
+	// A v2 skylink resolves to the same record and blocker already knows
+	// the skylink it points to.
+	sl2, _, err := e.scanAndReport(v2)
+	if err != nil || sl2.Hash != sl.Hash {
+		t.Fatalf("Expected the v2 skylink to resolve to %v, got %+v %v", sl.Hash, sl2, err)
+	}
+	br, err = e.client.Block(context.Background(), infected)
+	if err != nil || br.Result != database.BlockerResultDuplicate {
+		t.Fatalf("Expected a duplicate block, got %+v %v", br, err)
+	}
+
+	clean, err := skymodules.NewSkylinkV1(crypto.HashBytes([]byte("clean")), 0, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.portal.SetContent(clean.String(), Content(4096))
+	requests := e.blocker.Requests()
+	sl, br, err = e.scanAndReport(clean.String())
+	if err != nil || sl.Infected || sl.ScannedSize != 4096 || br != nil || e.blocker.Requests() != requests {
+		t.Fatalf("Unexpected result of a clean skylink %+v %+v %v", sl, br, err)
+	}
+}
+
+// TestEndToEndFailures ensures portal and blocker failures surface as errors
+// and the pipeline succeeds once the services recover.
+func TestEndToEndFailures(t *testing.T) {
+	e := newEnv(t, blocker.Options{Timeout: 100 * time.Millisecond})
+	skylink, _ := testSkylinks(t)
+	e.portal.SetContent(skylink, []byte(EICAR))
+
+	// The portal fails.
+	e.portal.Fail(skylink, http.StatusBadGateway)
+	if _, _, err := e.scanAndReport(skylink); err == nil {
+		t.Fatal("Expected the scan to fail while the portal is down")
+	}
+	if e.clam.Scans() != 0 {
+		t.Fatalf("Expected no scans, got %d", e.clam.Scans())
+	}
+
+	// Blocker fails, then times out.
+	e.blocker.Fail(http.StatusInternalServerError)
+	_, br, err := e.scanAndReport(skylink)
+	if err == nil || br.Result != database.BlockerResultFailed || br.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected a failed report, got %+v %v", br, err)
+	}
+	e.blocker.SetLatency(time.Second)
+	if _, br, err = e.scanAndReport(skylink); err == nil || br.Succeeded() {
+		t.Fatalf("Expected the report to time out, got %+v %v", br, err)
+	}
+	if e.blocker.Blocked(skylink) {
+		t.Fatal("Expected the skylink not to be blocked yet")
+	}
+
+	// Everything recovers.
+	e.blocker.SetLatency(0)
+	sl, br, err := e.scanAndReport(skylink)
+	if err != nil || !sl.Infected || !br.Succeeded() || !e.blocker.Blocked(skylink) {
+		t.Fatalf("Expected the skylink to be blocked, got %+v %+v %v", sl, br, err)
+	}
+	if e.portal.Requests(skylink) != 4 || e.clam.Scans() != 3 {
+		t.Fatalf("Unexpected number of portal requests %d and scans %d", e.portal.Requests(skylink), e.clam.Scans())
+	}
+}
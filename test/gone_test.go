@@ -0,0 +1,74 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestGone ensures skylinks whose content the portal keeps confirming not to
+// exist are marked as gone instead of being retried forever, while skylinks
+// which turn up again are scanned as usual.
+func TestGone(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	defer func(n int) { scanner.GoneConfirmations = n }(scanner.GoneConfirmations)
+	scanner.GoneConfirmations = 2
+
+	// The portal doesn't know the first skylink at all and deleted the
+	// second one. The third one turns up after a 404.
+	sls := queueSkylinks(t, db, e.portal.URL, 3)
+	e.portal.Fail(sls[1].Skylink, http.StatusGone, http.StatusGone)
+	e.portal.Fail(sls[2].Skylink, http.StatusNotFound)
+	e.portal.SetAsset(sls[1].Skylink, EICARAsset("eicar.com"))
+	e.portal.SetAsset(sls[2].Skylink, EICARAsset("eicar.com"))
+
+	s := newReportScanner(ctx, t, db, e)
+	for i := 0; ; i++ {
+		err := s.RunOnce()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
+		}
+		if i > 10 {
+			t.Fatal("Expected the queue to empty")
+		}
+	}
+	for i, sl := range sls {
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && (saved.Status != database.SkylinkStatusGone || saved.NotFound != 2 || saved.Skylink != "") {
+			t.Fatalf("Expected skylink %d to be gone, got %+v", i, saved)
+		}
+		if i == 2 && (saved.Status != database.SkylinkStatusUnreported || !saved.Infected || saved.NotFound != 0) {
+			t.Fatalf("Expected skylink %d to be scanned, got %+v", i, saved)
+		}
+	}
+	if _, err := db.SweepAndLock(ctx); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected gone skylinks to stay out of the queue, got %v", err)
+	}
+
+	// Gone skylinks can be queued for a re-scan, e.g. once they're
+	// uploaded again.
+	if err := db.SkylinkRescan(ctx, sls[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := db.Skylink(ctx, sls[1].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != database.SkylinkStatusUnreported || !saved.Infected || saved.NotFound != 0 {
+		t.Fatalf("Expected the re-scan to infect the skylink, got %+v", saved)
+	}
+}
@@ -0,0 +1,246 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/clamav"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+)
+
+// queueSkylinks adds the given number of new skylinks to the queue and returns
+// them.
+func queueSkylinks(t *testing.T, db *database.DB, portal string, n int) []*database.Skylink {
+	sls := make([]*database.Skylink, n)
+	for i := range sls {
+		skylink, err := skymodules.NewSkylinkV1(crypto.HashBytes([]byte(fmt.Sprint("locking", i))), 0, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sls[i] = &database.Skylink{}
+		if err = sls[i].LoadString(skylink.String(), portal); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.SkylinkCreate(context.Background(), sls[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sls
+}
+
+// TestSweepAndLockRace ensures concurrent workers never hold the lock of the
+// same record at the same time and that every record gets locked until it has
+// a verdict, even when scans fail and put their records back in the queue.
+func TestSweepAndLockRace(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	const records, workers = 200, 16
+	sls := queueSkylinks(t, db, "http://portal.invalid", records)
+
+	var mu sync.Mutex
+	locked := make(map[crypto.Hash]bool)
+	attempts := make(map[crypto.Hash]int)
+	var completed int
+	var violations []string
+	// fails tells whether the given attempt at locking the record fails.
+	// A third of the records fail their first attempt.
+	fails := func(h crypto.Hash, attempt int) bool {
+		return attempt == 0 && h[0]%3 == 0
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				sl, err := db.SweepAndLock(ctx)
+				if errors.Contains(err, database.ErrNoDocumentsFound) {
+					// Failed records might still be on their way back
+					// to the queue.
+					mu.Lock()
+					done := completed == records
+					mu.Unlock()
+					if done {
+						return
+					}
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				mu.Lock()
+				if locked[sl.Hash] {
+					violations = append(violations, "locked twice: "+sl.Hash.String())
+				}
+				if attempts[sl.Hash] > 0 && !fails(sl.Hash, attempts[sl.Hash]-1) {
+					violations = append(violations, "locked after its verdict: "+sl.Hash.String())
+				}
+				locked[sl.Hash] = true
+				attempt := attempts[sl.Hash]
+				attempts[sl.Hash]++
+				mu.Unlock()
+
+				// Hold the lock for a moment, so the other workers
+				// sweep the queue while we do.
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				delete(locked, sl.Hash)
+				mu.Unlock()
+				if fails(sl.Hash, attempt) {
+					sl.LastErrorKind = scanner.ErrKindPortalServerError
+					sl.LastError = "portal unavailable"
+					err = db.SkylinkSaveFailure(ctx, sl)
+				} else {
+					sl.Status = database.SkylinkStatusComplete
+					sl.Skylink = ""
+					sl.ScannedAt = database.Clock.Now().UTC()
+					err = db.SkylinkSaveVerdict(ctx, sl)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !fails(sl.Hash, attempt) {
+					mu.Lock()
+					completed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(violations) > 0 {
+		t.Fatalf("%d lock violations, e.g. %s", len(violations), violations[0])
+	}
+
+	hashes := make([]crypto.Hash, len(sls))
+	for i, sl := range sls {
+		hashes[i] = sl.Hash
+	}
+	saved, err := db.Skylinks(ctx, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != records {
+		t.Fatalf("Expected %d records, got %d", records, len(saved))
+	}
+	for _, sl := range saved {
+		expected := 1
+		if fails(sl.Hash, 0) {
+			expected = 2
+		}
+		if sl.Status != database.SkylinkStatusComplete || attempts[sl.Hash] != expected || sl.Failures != expected-1 {
+			t.Fatalf("Expected %s to be complete after %d attempts, got status '%s' after %d attempts and %d failures", sl.Hash, expected, sl.Status, attempts[sl.Hash], sl.Failures)
+		}
+	}
+}
+
+// TestSweepAndScanRace ensures scanners sharing a queue scan every skylink
+// exactly once.
+func TestSweepAndScanRace(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	e.portal.SetLatency(5 * time.Millisecond)
+	const records, scanners = 50, 8
+	sls := queueSkylinks(t, db, e.portal.URL, records)
+	for i, sl := range sls {
+		content := Content(4096)
+		if i%5 == 0 {
+			content = []byte("prefix " + EICAR)
+		}
+		e.portal.SetContent(sl.Skylink, content)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ev, err := events.NewEmitter(ctx, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, scanners)
+	for i := 0; i < scanners; i++ {
+		// Every scanner has its own connection to clamd, like the
+		// instances of a scaled out deployment.
+		ip, port := e.clam.Addr()
+		clam, err := clamav.New(ip, port, e.portal.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = clam.Close() }()
+		s, err := scanner.New(ctx, db, clam, []*blocker.Client{e.client}, nil, ev, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			abort := make(chan bool)
+			defer close(abort)
+			for {
+				err := s.SweepAndScan(abort)
+				if errors.Contains(err, database.ErrNoDocumentsFound) {
+					return
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	hashes := make([]crypto.Hash, len(sls))
+	for i, sl := range sls {
+		hashes[i] = sl.Hash
+	}
+	saved, err := db.Skylinks(ctx, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != records {
+		t.Fatalf("Expected %d records, got %d", records, len(saved))
+	}
+	infected := make(map[crypto.Hash]bool)
+	for i, sl := range sls {
+		infected[sl.Hash] = i%5 == 0
+		if n := e.portal.Requests(sl.Skylink); n != 1 {
+			t.Fatalf("Expected %s to be downloaded once, got %d downloads", sl.Skylink, n)
+		}
+	}
+	for _, sl := range saved {
+		expected := database.SkylinkStatusComplete
+		if infected[sl.Hash] {
+			expected = database.SkylinkStatusUnreported
+		}
+		if sl.Status != expected || sl.Infected != infected[sl.Hash] || sl.ScannedAt.IsZero() {
+			t.Fatalf("Expected %s to have status '%s' and infected %t, got %+v", sl.Hash, expected, infected[sl.Hash], sl)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestPolicyActions ensures detections are only reported to blocker if the
+// policy blocks them or a human approves them once they're held back for
+// review.
+func TestPolicyActions(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, e.portal.URL, 2)
+	for _, sl := range sls {
+		e.portal.SetAsset(sl.Skylink, EICARAsset("eicar.com"))
+	}
+	skylink := sls[0].Skylink
+	s := newReportScanner(ctx, t, db, e)
+	p, err := scanner.LoadPolicy(strings.NewReader(`{"baseScore": 50, "blockAt": 80, "reviewAt": 50}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		err := s.RunOnce()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.ReportOnce(); err != nil || n != 0 {
+		t.Fatalf("Expected no reported skylinks, got %d, %v", n, err)
+	}
+	if e.blocker.Blocked(skylink) {
+		t.Fatal("Expected the skylink not to be blocked")
+	}
+	sl, err := db.Skylink(ctx, sls[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sl.Infected || sl.Status != database.SkylinkStatusPendingReview || sl.Severity != 50 || sl.PolicyAction != database.PolicyActionReview || sl.Skylink != skylink {
+		t.Fatalf("Expected a detection held back for review, got %+v", sl)
+	}
+	reviews, err := db.PendingReviews(ctx, 10)
+	if err != nil || len(reviews) != 2 {
+		t.Fatalf("Expected 2 pending reviews, got %d, %v", len(reviews), err)
+	}
+
+	// An approved detection is reported.
+	if _, err = db.SkylinkApproveReview(ctx, sls[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.ReportOnce(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 reported skylink, got %d, %v", n, err)
+	}
+	if !e.blocker.Blocked(skylink) {
+		t.Fatal("Expected the approved skylink to be blocked")
+	}
+
+	// A rejected one is a false positive.
+	sl, err = db.SkylinkRejectReview(ctx, sls[1].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Infected || !sl.FalsePositive || sl.Status != database.SkylinkStatusComplete || sl.Skylink != "" {
+		t.Fatalf("Expected a false positive, got %+v", sl)
+	}
+	if _, err = db.SkylinkRejectReview(ctx, sls[1].Hash); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected ErrNoDocumentsFound, got %v", err)
+	}
+	if e.blocker.Blocked(sls[1].Skylink) {
+		t.Fatal("Expected the rejected skylink not to be blocked")
+	}
+}
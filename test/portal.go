@@ -0,0 +1,291 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// MockPortal is an in-process portal. It serves generated content of any
+// size, requested as "/size/<bytes>" or as a skylink it was given the size of,
+// and the content or asset set for any other skylink. It supports range
+// requests, like portals do, and resolves v2 skylinks in the "skynet-skylink"
+// header of HEAD requests. The sectors it was given are served by merkle root
+// under /skynet/root. Everything else gets a 404. Its responses can be
+// delayed and failed on demand.
+type MockPortal struct {
+	*httptest.Server
+
+	assets   map[string]Asset
+	content  map[string][]byte
+	sectors  map[string][]byte
+	sizes    map[string]int
+	v2       map[string]string
+	chaos    *chaos
+	failures map[string][]int
+	latency  time.Duration
+	requests map[string]int
+	mu       sync.Mutex
+}
+
+// NewMockPortal starts a MockPortal listening on a random local port.
+func NewMockPortal() *MockPortal {
+	mp := newMockPortal()
+	mp.Server = httptest.NewServer(http.HandlerFunc(mp.serve))
+	return mp
+}
+
+// NewMockPortalAt starts a MockPortal listening on the given address, so
+// services outside of the process can reach it.
+func NewMockPortalAt(addr string) (*MockPortal, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to listen")
+	}
+	mp := newMockPortal()
+	mp.Server = &httptest.Server{
+		Listener: l,
+		Config:   &http.Server{Handler: http.HandlerFunc(mp.serve)},
+	}
+	mp.Start()
+	return mp, nil
+}
+
+// newMockPortal returns a MockPortal which isn't serving yet.
+func newMockPortal() *MockPortal {
+	return &MockPortal{
+		assets:   make(map[string]Asset),
+		content:  make(map[string][]byte),
+		sectors:  make(map[string][]byte),
+		sizes:    make(map[string]int),
+		v2:       make(map[string]string),
+		failures: make(map[string][]int),
+		requests: make(map[string]int),
+	}
+}
+
+// MockPortalPath returns the path under which the mock portal serves content
+// of the given size.
+func MockPortalPath(size int) string {
+	return "size/" + strconv.Itoa(size)
+}
+
+// Content returns clean content of the given size.
+func Content(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// SetContent makes the mock serve the given content for the given skylink.
+func (mp *MockPortal) SetContent(skylink string, content []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.content[skylink] = content
+}
+
+// SetSector makes the mock serve the given content as the sector with the
+// given merkle root under /skynet/root.
+func (mp *MockPortal) SetSector(root string, content []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.sectors[root] = content
+}
+
+// SetAsset makes the mock serve the given asset for the given skylink, with
+// the asset's content type and encoding, and its metadata under
+// /skynet/metadata/<skylink>.
+func (mp *MockPortal) SetAsset(skylink string, a Asset) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.assets[skylink] = a
+}
+
+// SetSize makes the mock serve generated content of the given size for the
+// given skylink. Unlike SetContent, it doesn't keep the content in memory.
+func (mp *MockPortal) SetSize(skylink string, size int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.sizes[skylink] = size
+}
+
+// SetV2 makes the mock resolve the given v2 skylink to the given skylink.
+func (mp *MockPortal) SetV2(v2, skylink string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.v2[v2] = skylink
+}
+
+// Fail makes the mock respond to the next requests for the given skylink with
+// the given status codes, one per request, before it serves the skylink
+// again.
+func (mp *MockPortal) Fail(skylink string, statuses ...int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.failures[skylink] = append(mp.failures[skylink], statuses...)
+}
+
+// SetLatency delays all responses of the mock by the given duration.
+func (mp *MockPortal) SetLatency(d time.Duration) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.latency = d
+}
+
+// SetChaos makes the mock inject the given faults into its responses. Failed
+// requests get a 503 and partial ones get half of the content before the
+// connection is closed.
+func (mp *MockPortal) SetChaos(c Chaos) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.chaos = newChaos(c)
+}
+
+// Faults returns the number of requests the mock injected a fault into.
+func (mp *MockPortal) Faults() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.chaos.injected()
+}
+
+// Requests returns the number of requests the mock has received for the
+// given skylink.
+func (mp *MockPortal) Requests(skylink string) int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.requests[skylink]
+}
+
+// serve handles a single request.
+func (mp *MockPortal) serve(w http.ResponseWriter, r *http.Request) {
+	skylink := strings.TrimPrefix(r.URL.Path, "/")
+	mp.mu.Lock()
+	mp.requests[skylink]++
+	latency := mp.latency
+	status := 0
+	if f := mp.failures[skylink]; len(f) > 0 {
+		status, mp.failures[skylink] = f[0], f[1:]
+	}
+	asset, isAsset := mp.assets[skylink]
+	metadata, isMetadata := mp.assets[strings.TrimPrefix(skylink, "skynet/metadata/")]
+	content, ok := mp.content[skylink]
+	sector, isSector := mp.sectors[r.URL.Query().Get("root")]
+	size, generated := mp.sizes[skylink]
+	resolved, isV2 := mp.v2[skylink]
+	chaosLatency, f := mp.chaos.draw()
+	mp.mu.Unlock()
+
+	if !sleep(r, latency+chaosLatency) {
+		return
+	}
+	if f == faultError || (f == faultPartial && isV2) {
+		status = http.StatusServiceUnavailable
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if isV2 {
+		w.Header().Set("skynet-skylink", resolved)
+		return
+	}
+	if isMetadata && strings.HasPrefix(skylink, "skynet/metadata/") {
+		_ = json.NewEncoder(w).Encode(metadata.Metadata)
+		return
+	}
+	if isAsset {
+		serveAsset(w, r, asset, f == faultPartial)
+		return
+	}
+	if skylink == "skynet/root" {
+		offset, errOffset := strconv.Atoi(r.URL.Query().Get("offset"))
+		length, errLength := strconv.Atoi(r.URL.Query().Get("length"))
+		if !isSector || errOffset != nil || errLength != nil || offset < 0 || length < 0 || offset+length > len(sector) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content, ok = sector[offset:offset+length], true
+	}
+	if !ok && !generated {
+		var err error
+		size, err = strconv.Atoi(strings.TrimPrefix(skylink, "size/"))
+		if !strings.HasPrefix(skylink, "size/") || err != nil || size < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	if !ok {
+		content = Content(size)
+	}
+	if f == faultPartial {
+		// Promise all of the content but send only half of it, which makes
+		// the server close the connection.
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content[:len(content)/2])
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// serveAsset serves the given asset. Partial responses get half of the
+// content before the connection is closed.
+func serveAsset(w http.ResponseWriter, r *http.Request, a Asset, partial bool) {
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", a.disposition())
+	body := a.open()
+	size := a.bodySize
+	if a.Encoding != "" {
+		if strings.Contains(r.Header.Get("Accept-Encoding"), a.Encoding) {
+			w.Header().Set("Content-Encoding", a.Encoding)
+		} else {
+			// Decode the content for clients which don't accept its
+			// encoding, like portals do.
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+			if partial {
+				_, _ = io.CopyN(w, gz, a.Size/2)
+				return
+			}
+			_, _ = io.Copy(w, gz)
+			return
+		}
+	}
+	if partial {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		_, _ = io.CopyN(w, body, size/2)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, body)
+}
+
+// sleep waits for the given duration and returns true, or returns false if
+// the request is canceled first.
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestReplayPipeline ensures replayed verdicts go through the whole pipeline,
+// up to reporting infected skylinks to blocker, without downloading or
+// scanning anything.
+func TestReplayPipeline(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	sls := queueSkylinks(t, db, e.portal.URL, 3)
+	infected := sls[1]
+	replay, err := scanner.LoadReplay(strings.NewReader(`{"` + infected.Hash.String() + `": {"infected": true, "description": "` + EICARSignature + `", "size": 68}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ev, err := events.NewEmitter(ctx, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := scanner.New(ctx, db, e.scanner, []*blocker.Client{e.client}, nil, ev, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetReplay(replay); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		err = s.RunOnce()
+		if errors.Contains(err, database.ErrNoDocumentsFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.ReportOnce(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 blocked skylink, got %d, %v", n, err)
+	}
+
+	for _, sl := range sls {
+		if n := e.portal.Requests(sl.Skylink); n != 0 {
+			t.Fatalf("Expected %s not to be downloaded, got %d downloads", sl.Skylink, n)
+		}
+		if blocked := e.blocker.Blocked(sl.Skylink); blocked != (sl == infected) {
+			t.Fatalf("Expected %s to be blocked: %t, got %t", sl.Skylink, sl == infected, blocked)
+		}
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Infected != (sl == infected) || saved.Status != database.SkylinkStatusComplete {
+			t.Fatalf("Unexpected record %+v", saved)
+		}
+	}
+	if n := e.clam.Scans(); n != 0 {
+		t.Fatalf("Expected no scans, got %d", n)
+	}
+}
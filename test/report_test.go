@@ -0,0 +1,371 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/events"
+	"github.com/SkynetLabs/malware-scanner/metrics"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.sia.tech/siad/crypto"
+)
+
+// newReportScanner returns a scanner which reports to the env's blocker, and
+// to the given extra targets after it. It reports one skylink at a time, so
+// the order of the reports is predictable.
+func newReportScanner(ctx context.Context, t *testing.T, db *database.DB, e *env, extra ...*blocker.Client) *scanner.Scanner {
+	workers := scanner.BlockerReportWorkers
+	scanner.BlockerReportWorkers = 1
+	t.Cleanup(func() { scanner.BlockerReportWorkers = workers })
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ev, err := events.NewEmitter(ctx, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockers := append([]*blocker.Client{e.client}, extra...)
+	s, err := scanner.New(ctx, db, e.scanner, blockers, nil, ev, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// queueInfected adds the given number of infected skylinks, which are yet to
+// be reported, to the DB and returns them.
+func queueInfected(t *testing.T, db *database.DB, portal string, n int) []*database.Skylink {
+	sls := queueSkylinks(t, db, portal, n)
+	hashes := make([]crypto.Hash, n)
+	for i, sl := range sls {
+		hashes[i] = sl.Hash
+	}
+	if _, err := db.MarkKnownInfected(context.Background(), hashes, "test"); err != nil {
+		t.Fatal(err)
+	}
+	return sls
+}
+
+// TestSweepAndBlockDecodeFailure ensures a record which fails to decode
+// doesn't hold up the reports of the others and stays unreported.
+func TestSweepAndBlockDecodeFailure(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueInfected(t, db, e.portal.URL, 3)
+	broken := crypto.HashBytes([]byte("broken"))
+	_, err := db.Collection("skylinks").InsertOne(ctx, bson.M{
+		"hash":    broken,
+		"skylink": "broken",
+		"status":  database.SkylinkStatusUnreported,
+		"size":    "not a number",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newReportScanner(ctx, t, db, e)
+	n, err := s.SweepAndBlock()
+	if n != len(sls) || err == nil || !strings.Contains(err.Error(), "failed to decode") {
+		t.Fatalf("Expected %d reported skylinks and a decoding error, got %d, %v", len(sls), n, err)
+	}
+	for _, sl := range sls {
+		if blocks := e.blocker.Blocks(sl.Skylink); blocks != 1 {
+			t.Fatalf("Expected %s to be reported once, got %d reports", sl.Skylink, blocks)
+		}
+	}
+	if e.blocker.Blocks("broken") != 0 {
+		t.Fatal("Expected the broken record not to be reported")
+	}
+	// The broken record is still there, waiting to be fixed.
+	n64, err := db.Collection("skylinks").CountDocuments(ctx, bson.M{"hash": broken, "status": database.SkylinkStatusUnreported})
+	if err != nil || n64 != 1 {
+		t.Fatalf("Expected the broken record to stay unreported, got %d, %v", n64, err)
+	}
+}
+
+// TestSweepAndBlockRejected ensures a skylink blocker fails to block stays
+// unreported, with blocker's response on its record, and is reported once the
+// next sweep succeeds, without reporting any skylink twice.
+func TestSweepAndBlockRejected(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueInfected(t, db, e.portal.URL, 3)
+	s := newReportScanner(ctx, t, db, e)
+
+	e.blocker.Fail(http.StatusInternalServerError)
+	n, err := s.SweepAndBlock()
+	if err == nil || n == len(sls) {
+		t.Fatalf("Expected the rejected report to fail the sweep, got %d, %v", n, err)
+	}
+	rejected := 0
+	for _, sl := range sls {
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		br := saved.Reports[e.client.Name()]
+		if saved.Status == database.SkylinkStatusUnreported && br != nil && br.Result == database.BlockerResultFailed {
+			rejected++
+		}
+	}
+	if rejected != 1 {
+		t.Fatalf("Expected 1 unreported skylink with the rejection on its record, got %d", rejected)
+	}
+
+	if _, err = s.SweepAndBlock(); err != nil {
+		t.Fatal(err)
+	}
+	for _, sl := range sls {
+		if blocks := e.blocker.Blocks(sl.Skylink); blocks != 1 {
+			t.Fatalf("Expected %s to be reported once, got %d reports", sl.Skylink, blocks)
+		}
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Status != database.SkylinkStatusComplete || !saved.Reports[e.client.Name()].Succeeded() {
+			t.Fatalf("Expected %s to be reported, got %+v", sl.Skylink, saved)
+		}
+	}
+}
+
+// TestSweepAndBlockUpdateFailure ensures a skylink whose record fails to
+// update after blocker blocked it is neither lost nor blocked twice: it's
+// reported again, which blocker answers as a duplicate, until its record is
+// updated.
+func TestSweepAndBlockUpdateFailure(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	sl := queueInfected(t, db, e.portal.URL, 1)[0]
+
+	// Cancel the scanner's context once blocker blocked the skylink, so the
+	// update of the record fails.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.blocker.OnBlock(func(string) { cancel() })
+	n, err := newReportScanner(ctx, t, db, e).SweepAndBlock()
+	if n != 0 || err == nil {
+		t.Fatalf("Expected the update to fail, got %d, %v", n, err)
+	}
+	if !e.blocker.Blocked(sl.Skylink) {
+		t.Fatal("Expected the skylink to be blocked")
+	}
+	saved, err := db.Skylink(context.Background(), sl.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != database.SkylinkStatusUnreported || saved.Skylink != sl.Skylink {
+		t.Fatalf("Expected the record to stay unreported, got %+v", saved)
+	}
+
+	e.blocker.OnBlock(nil)
+	n, err = newReportScanner(context.Background(), t, db, e).SweepAndBlock()
+	if n != 1 || err != nil {
+		t.Fatalf("Expected 1 reported skylink, got %d, %v", n, err)
+	}
+	saved, err = db.Skylink(context.Background(), sl.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := saved.Reports[e.client.Name()]
+	if saved.Status != database.SkylinkStatusComplete || br == nil || br.Result != database.BlockerResultDuplicate {
+		t.Fatalf("Expected the repeated report to be a duplicate, got %+v", saved)
+	}
+	if blocks := e.blocker.Blocks(sl.Skylink); blocks != 2 {
+		t.Fatalf("Expected the skylink to be reported twice, got %d reports", blocks)
+	}
+}
+
+// TestSweepAndBlockRequeued ensures a skylink which is re-queued while it's
+// being reported keeps its new status instead of being completed, and isn't
+// reported again until it gets a new verdict.
+func TestSweepAndBlockRequeued(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sl := queueInfected(t, db, e.portal.URL, 1)[0]
+	s := newReportScanner(ctx, t, db, e)
+
+	e.blocker.OnBlock(func(string) {
+		if err := db.SkylinkRescan(ctx, sl); err != nil {
+			t.Error(err)
+		}
+	})
+	n, err := s.SweepAndBlock()
+	if n != 0 || !errors.Contains(err, database.ErrStatusChanged) {
+		t.Fatalf("Expected the update to find the record re-queued, got %d, %v", n, err)
+	}
+	saved, err := db.Skylink(ctx, sl.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != database.SkylinkStatusNew || saved.Skylink != sl.Skylink {
+		t.Fatalf("Expected the record to be queued for a re-scan, got %+v", saved)
+	}
+	if n, err = s.SweepAndBlock(); n != 0 || err != nil {
+		t.Fatalf("Expected nothing to report, got %d, %v", n, err)
+	}
+	if blocks := e.blocker.Blocks(sl.Skylink); blocks != 1 {
+		t.Fatalf("Expected the skylink to be reported once, got %d reports", blocks)
+	}
+}
+
+// TestRunOnce ensures the synchronous iterations of the scanning and reporting
+// loops take queued skylinks through the whole pipeline, one step at a time,
+// even while the background loops are paused.
+func TestRunOnce(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, e.portal.URL, 2)
+	clean, infected := sls[0].Skylink, sls[1].Skylink
+	e.portal.SetContent(clean, Content(4096))
+	e.portal.SetContent(infected, []byte(EICAR))
+	s := newReportScanner(ctx, t, db, e)
+	s.Pause()
+
+	for i := 0; i < 2; i++ {
+		if err := s.RunOnce(); err != nil {
+			t.Fatal(err)
+		}
+		if n := e.clam.Scans(); n != i+1 {
+			t.Fatalf("Expected %d scans, got %d", i+1, n)
+		}
+	}
+	if err := s.RunOnce(); !errors.Contains(err, database.ErrNoDocumentsFound) {
+		t.Fatalf("Expected the queue to be empty, got %v", err)
+	}
+	if e.blocker.Blocked(infected) {
+		t.Fatal("Expected the infected skylink not to be reported before ReportOnce")
+	}
+	if n, err := s.ReportOnce(); n != 1 || err != nil {
+		t.Fatalf("Expected 1 reported skylink, got %d, %v", n, err)
+	}
+	if !e.blocker.Blocked(infected) || e.blocker.Blocked(clean) {
+		t.Fatal("Expected only the infected skylink to be blocked")
+	}
+}
+
+// metricValue returns the value of the metric with the given name and label
+// values in the default registry, or zero if it has no such sample.
+func metricValue(name string, labelValues ...string) float64 {
+	for _, s := range metrics.DefaultRegistry.Samples() {
+		if s.Name != name || len(s.Labels) != len(labelValues) {
+			continue
+		}
+		match := true
+		for i, l := range s.Labels {
+			match = match && l.Value == labelValues[i]
+		}
+		if match {
+			return s.Value
+		}
+	}
+	return 0
+}
+
+// TestSweepAndBlockMetrics ensures SweepAndBlock counts the reports to each
+// blocker target by their result and tracks the time it took to report the
+// skylinks once every target blocked them.
+func TestSweepAndBlockMetrics(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	secondary := NewMockBlocker()
+	t.Cleanup(secondary.Close)
+	bc, err := blocker.New(secondary.URL, blocker.Options{Name: "secondary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sls := queueInfected(t, db, e.portal.URL, 2)
+	s := newReportScanner(ctx, t, db, e, bc)
+
+	primary := e.client.Name()
+	counts := func() [4]float64 {
+		return [4]float64{
+			metricValue("scanner_blocker_reports_total", primary, "success"),
+			metricValue("scanner_blocker_reports_total", primary, "failure"),
+			metricValue("scanner_blocker_reports_total", "secondary", "success"),
+			metricValue("scanner_blocker_reports_total", "secondary", "failure"),
+		}
+	}
+	lags := func() float64 { return metricValue("scanner_report_lag_seconds_count") }
+	before, lagsBefore := counts(), lags()
+
+	// The secondary target fails its first report and is skipped for the
+	// rest of the sweep, while the primary one blocks both skylinks.
+	secondary.Fail(http.StatusInternalServerError)
+	if n, err := s.SweepAndBlock(); n != 0 || err == nil {
+		t.Fatalf("Expected the sweep to fail on the secondary target, got %d, %v", n, err)
+	}
+	after := counts()
+	if after[0]-before[0] != 2 || after[1] != before[1] || after[2] != before[2] || after[3]-before[3] != 1 {
+		t.Fatalf("Unexpected reports, before %v, after %v", before, after)
+	}
+	if lags() != lagsBefore {
+		t.Fatal("Expected no report lag before every target blocked the skylinks")
+	}
+
+	// The next sweep only reports the skylinks to the secondary target.
+	if n, err := s.SweepAndBlock(); n != len(sls) || err != nil {
+		t.Fatalf("Expected %d reported skylinks, got %d, %v", len(sls), n, err)
+	}
+	before, after = after, counts()
+	if after[0] != before[0] || after[1] != before[1] || after[2]-before[2] != 2 || after[3] != before[3] {
+		t.Fatalf("Unexpected reports, before %v, after %v", before, after)
+	}
+	if lags()-lagsBefore != float64(len(sls)) {
+		t.Fatalf("Expected the report lag of %d skylinks, got %v", len(sls), lags()-lagsBefore)
+	}
+}
+
+// TestUnreportedMetrics ensures the reporting loop's gauges track the number
+// of infected skylinks which haven't been reported yet and the age of the
+// oldest of them.
+func TestUnreportedMetrics(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = fake
+	t.Cleanup(func() { database.Clock = real })
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, e.portal.URL, 2)
+	s := newReportScanner(ctx, t, db, e)
+
+	// The skylinks are detected ten minutes apart.
+	for _, sl := range sls {
+		if _, err := db.MarkKnownInfected(ctx, []crypto.Hash{sl.Hash}, "test"); err != nil {
+			t.Fatal(err)
+		}
+		fake.Advance(10 * time.Minute)
+	}
+	e.blocker.Fail(http.StatusInternalServerError)
+	if n, err := s.ReportOnce(); n != 0 || err == nil {
+		t.Fatalf("Expected the report to fail, got %d, %v", n, err)
+	}
+	unreported, age := metricValue("scanner_unreported_records"), metricValue("scanner_unreported_oldest_age_seconds")
+	if unreported != 2 || age != (20*time.Minute).Seconds() {
+		t.Fatalf("Expected 2 unreported skylinks, the oldest detected 20m ago, got %v and %vs", unreported, age)
+	}
+
+	if n, err := s.ReportOnce(); n != len(sls) || err != nil {
+		t.Fatalf("Expected %d reported skylinks, got %d, %v", len(sls), n, err)
+	}
+	unreported, age = metricValue("scanner_unreported_records"), metricValue("scanner_unreported_oldest_age_seconds")
+	if unreported != 0 || age != 0 {
+		t.Fatalf("Expected no unreported skylinks, got %v and %vs", unreported, age)
+	}
+}
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestRetention ensures only the records of the verdict classes with a
+// retention are removed once they outlive it, while records which are still
+// being worked on are kept.
+func TestRetention(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = fake
+	t.Cleanup(func() { database.Clock = real })
+	infected, clean, failed := scanner.RetentionInfected, scanner.RetentionClean, scanner.RetentionFailed
+	t.Cleanup(func() {
+		scanner.RetentionInfected, scanner.RetentionClean, scanner.RetentionFailed = infected, clean, failed
+	})
+
+	// Give the records one verdict class each: infected, clean, failed and
+	// pending review.
+	sls := queueSkylinks(t, db, e.portal.URL, 4)
+	index := make(map[crypto.Hash]int)
+	for i, sl := range sls {
+		index[sl.Hash] = i
+	}
+	var failing *database.Skylink
+	for range sls {
+		sl, err := db.SweepAndLock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sl.ScannedAt = database.Clock.Now().UTC()
+		switch index[sl.Hash] {
+		case 0:
+			sl.Status = database.SkylinkStatusComplete
+			sl.Infected = true
+			sl.InfectionDescription = "Win.Test.EICAR_HDB-1"
+			err = db.SkylinkSaveVerdict(ctx, sl)
+		case 1:
+			sl.Status = database.SkylinkStatusComplete
+			err = db.SkylinkSaveVerdict(ctx, sl)
+		case 2:
+			// Keep the record locked until the others are locked, so
+			// it isn't locked again once it's back in the queue.
+			failing = sl
+		case 3:
+			sl.Status = database.SkylinkStatusPendingReview
+			sl.Infected = true
+			sl.InfectionDescription = "Win.Test.EICAR_HDB-1"
+			err = db.SkylinkSaveVerdict(ctx, sl)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	failing.Status = database.SkylinkStatusNew
+	failing.LastErrorKind = scanner.ErrKindPortalServerError
+	failing.LastError = "portal unavailable"
+	if err := db.SkylinkSaveFailure(ctx, failing); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newReportScanner(ctx, t, db, e)
+	scanner.RetentionInfected = 0
+	scanner.RetentionClean = 48 * time.Hour
+	scanner.RetentionFailed = 24 * time.Hour
+
+	// Nothing has expired yet.
+	fake.Advance(12 * time.Hour)
+	deleted, err := s.EnforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("Expected no removed records, got %v", deleted)
+	}
+
+	// The failed record expires first.
+	fake.Advance(24 * time.Hour)
+	deleted, err = s.EnforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[database.VerdictClassFailed] != 1 {
+		t.Fatalf("Expected 1 removed failed record, got %v", deleted)
+	}
+
+	// Then the clean one, while infected records are kept forever.
+	fake.Advance(365 * 24 * time.Hour)
+	deleted, err = s.EnforceRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[database.VerdictClassClean] != 1 {
+		t.Fatalf("Expected 1 removed clean record, got %v", deleted)
+	}
+	for i, sl := range sls {
+		_, err = db.Skylink(ctx, sl.Hash)
+		kept := i == 0 || i == 3
+		if kept && err != nil {
+			t.Fatalf("Expected record %d to be kept, got %v", i, err)
+		}
+		if !kept && !errors.Contains(err, database.ErrNoDocumentsFound) {
+			t.Fatalf("Expected record %d to be removed, got %v", i, err)
+		}
+	}
+}
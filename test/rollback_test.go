@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestSignatureRollback ensures withdrawing a signature gets the skylinks
+// blocked under it scanned again, once their detection is ignored. Blocker
+// can't unblock them, so they stay blocked and keep their reports.
+func TestSignatureRollback(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, e.portal.URL, 1)
+	skylink := sls[0].Skylink
+	e.portal.SetAsset(skylink, EICARAsset("eicar.com"))
+	s := newReportScanner(ctx, t, db, e)
+	scanAll := func() {
+		t.Helper()
+		for {
+			err := s.RunOnce()
+			if errors.Contains(err, database.ErrNoDocumentsFound) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.ReportOnce(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanAll()
+	sl, err := db.Skylink(ctx, sls[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.blocker.Blocked(skylink) || sl.Status != database.SkylinkStatusComplete || sl.Skylink != "" {
+		t.Fatalf("Expected a blocked skylink, got %+v", sl)
+	}
+
+	// The skylink is queued again with its skylink taken from the report
+	// log.
+	sw, err := db.WithdrawSignature(ctx, database.SignatureWithdrawal{Signature: EICARSignature, Reason: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sw.Queued != 1 || sw.Skipped != 0 {
+		t.Fatalf("Expected 1 queued skylink, got %+v", sw)
+	}
+	sl, err = db.Skylink(ctx, sls[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Status != database.SkylinkStatusNew || sl.Skylink != skylink || sl.Rollback != EICARSignature {
+		t.Fatalf("Expected a queued rollback, got %+v", sl)
+	}
+
+	// MockClam still detects EICAR, but the detection is ignored.
+	scanAll()
+	if !e.blocker.Blocked(skylink) {
+		t.Fatal("Expected the skylink to stay blocked")
+	}
+	sl, err = db.Skylink(ctx, sls[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Infected || sl.Status != database.SkylinkStatusComplete || sl.Rollback != "" || !sl.Reports[e.client.Name()].Succeeded() {
+		t.Fatalf("Expected a clean record which is still reported, got %+v", sl)
+	}
+	ws, err := db.SignatureWithdrawals(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ws) != 1 || ws[0].Pending != 0 {
+		t.Fatalf("Expected a finished withdrawal, got %+v", ws)
+	}
+	entries, err := db.ReportLogEntries(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != database.ReportActionBlock {
+		t.Fatalf("Expected only the block to be logged, got %+v", entries)
+	}
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestScanRoot ensures content submitted by merkle root is downloaded from the
+// portal's /skynet/root endpoint, scanned and reported to blocker by the
+// skylink built from its root.
+func TestScanRoot(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	content := EICARAsset("eicar.com").Bytes()
+	root := crypto.HashBytes([]byte("root")).String()
+	e.portal.SetSector(root, append(content, make([]byte, 100)...))
+
+	var sl database.Skylink
+	if err := sl.LoadRoot(root, uint64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SkylinkCreate(ctx, &sl); err != nil {
+		t.Fatal(err)
+	}
+	s := newReportScanner(ctx, t, db, e)
+	if err := s.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if e.portal.Requests("skynet/root") != 1 {
+		t.Fatalf("Expected the content to be downloaded by root, got %d requests", e.portal.Requests("skynet/root"))
+	}
+	scanned, err := db.Skylink(ctx, sl.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scanned.Infected || scanned.Size != uint64(len(content)) || scanned.RootLength != uint64(len(content)) {
+		t.Fatalf("Expected the first %d bytes of the sector to be infected, got %+v", len(content), scanned)
+	}
+
+	if n, err := s.ReportOnce(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 reported skylink, got %d, %v", n, err)
+	}
+	if !e.blocker.Blocked(sl.Skylink) {
+		t.Fatal("Expected the skylink built from the root to be blocked")
+	}
+}
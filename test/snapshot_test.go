@@ -0,0 +1,81 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestSnapshotRoundTrip ensures records exported from one DB, together with
+// their history, are imported into another DB unchanged.
+func TestSnapshotRoundTrip(t *testing.T) {
+	prod := containers.MongoDB(t)
+	dev := containers.MongoDB(t)
+	ctx := context.Background()
+	sls := queueSkylinks(t, prod, "http://portal.invalid", 2)
+	// Fetch the record again for its ID.
+	infected, err := prod.Skylink(ctx, sls[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sls[0] = infected
+	infected.Status = database.SkylinkStatusComplete
+	infected.Infected = true
+	infected.InfectionDescription = EICARSignature
+	infected.ScannedAt = time.Now().UTC().Truncate(time.Millisecond)
+	infected.Blocker = &database.BlockerResponse{Result: database.BlockerResultBlocked, ReportedAt: infected.ScannedAt}
+	if err := prod.SkylinkSave(ctx, infected); err != nil {
+		t.Fatal(err)
+	}
+	err = prod.AuditLog(ctx, &database.AuditEntry{Caller: "alice", Action: "rescan", Params: map[string]string{"hash": infected.Hash.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = prod.AppendReportLog(ctx, &database.ReportLogEntry{
+		Action:      database.ReportActionBlock,
+		Target:      "default",
+		SkylinkHash: infected.Hash.String(),
+		Skylink:     infected.Skylink,
+		Result:      database.BlockerResultBlocked,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := prod.ExportSnapshot(ctx, []crypto.Hash{sls[0].Hash, sls[1].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(s.Records))
+	}
+	for _, r := range s.Records {
+		history := 0
+		if r.Record.Hash == infected.Hash {
+			history = 1
+		}
+		if len(r.Audit) != history || len(r.Reports) != history {
+			t.Fatalf("Expected %d audit entries and reports for %s, got %d and %d", history, r.Record.Hash, len(r.Audit), len(r.Reports))
+		}
+	}
+	// Importing twice replaces the records rather than duplicating them.
+	for i := 0; i < 2; i++ {
+		n, err := dev.ImportSnapshot(ctx, s)
+		if err != nil || n != 2 {
+			t.Fatalf("Expected 2 imported records, got %d, %v", n, err)
+		}
+	}
+	for _, sl := range sls {
+		imported, err := dev.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imported.Skylink != sl.Skylink || imported.Status != sl.Status || imported.Infected != sl.Infected || !imported.ScannedAt.Equal(sl.ScannedAt) {
+			t.Fatalf("Expected the imported record %+v, got %+v", sl, imported)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
+	"go.sia.tech/siad/crypto"
+)
+
+// soakAttempts is how many times the soak test scans or reports a skylink
+// before it gives up on it.
+const soakAttempts = 20
+
+// TestSoak runs skylinks through the pipeline while every mock injects
+// latency, errors and partial responses into a share of its calls. No fault
+// may turn into a wrong verdict: every infected skylink must end up blocked
+// and no clean one may, however many attempts it takes.
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	e := newEnv(t, blocker.Options{Timeout: time.Second})
+	chaos := Chaos{Latency: 5 * time.Millisecond, LatencyRate: 0.1, ErrorRate: 0.15, PartialRate: 0.15, Seed: 42}
+	e.clam.SetChaos(chaos)
+	chaos.Seed++
+	e.portal.SetChaos(chaos)
+	chaos.Seed++
+	e.blocker.SetChaos(chaos)
+
+	var retries int
+	for i := 0; i < 100; i++ {
+		sl, err := skymodules.NewSkylinkV1(crypto.HashBytes([]byte(fmt.Sprint("soak", i))), 0, 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		skylink := sl.String()
+		infected := i%2 == 0
+		content := Content(4096)
+		if infected {
+			content = append(content, EICAR...)
+		}
+		e.portal.SetContent(skylink, content)
+
+		// The scanner retries a failed scan until it gets a verdict, then
+		// retries the report until blocker blocks the skylink.
+		var res *database.Skylink
+		for attempt := 0; res == nil; attempt++ {
+			if attempt == soakAttempts {
+				t.Fatalf("Skylink %d wasn't scanned within %d attempts", i, soakAttempts)
+			}
+			if res, err = e.scan(skylink); err != nil {
+				res = nil
+				retries++
+			}
+		}
+		if res.Infected != infected || (!infected && res.ScannedSize != res.Size) {
+			t.Fatalf("Skylink %d got the wrong verdict: infected %t after scanning %d of %d bytes", i, res.Infected, res.ScannedSize, res.Size)
+		}
+		for attempt := 0; infected && !e.blocker.Blocked(skylink); attempt++ {
+			if attempt == soakAttempts {
+				t.Fatalf("Skylink %d wasn't blocked within %d attempts", i, soakAttempts)
+			}
+			if _, err = e.client.Block(context.Background(), skylink); err != nil {
+				retries++
+			}
+		}
+		if e.blocker.Blocked(skylink) != infected {
+			t.Fatalf("Skylink %d: expected blocked %t", i, infected)
+		}
+	}
+	if retries == 0 || e.clam.Faults() == 0 || e.portal.Faults() == 0 || e.blocker.Faults() == 0 {
+		t.Fatalf("Expected faults in every mock and retries, got %d retries and %d, %d and %d faults",
+			retries, e.clam.Faults(), e.portal.Faults(), e.blocker.Faults())
+	}
+}
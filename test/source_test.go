@@ -0,0 +1,86 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+)
+
+// TestSource ensures records keep the source and the submitter of the scan
+// which gave them their verdict, and that re-scans replace them.
+func TestSource(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	defer func(d time.Duration) { scanner.RescanLookback = d }(scanner.RescanLookback)
+	scanner.RescanLookback = time.Hour
+
+	// A user submits a skylink which is later reported as well, and another
+	// one is only reported.
+	sls := queueSkylinks(t, db, e.portal.URL, 2)
+	if err := db.SkylinkPurge(ctx, sls[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+	sls[0].Source, sls[0].Submitter = database.SourceUser, "ip:192.0.2.1"
+	if err := db.SkylinkCreate(ctx, sls[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SkylinkPurge(ctx, sls[1].Hash); err != nil {
+		t.Fatal(err)
+	}
+	for _, sl := range sls {
+		if _, err := db.SkylinkEnqueueReported(ctx, sl, database.PriorityHigh, "abuse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.portal.SetAsset(sls[0].Skylink, EICARAsset("eicar.com"))
+	e.portal.SetAsset(sls[1].Skylink, TextAsset("clean.txt", "clean"))
+	s := newReportScanner(ctx, t, db, e)
+	for range sls {
+		if err := s.RunOnce(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// check fetches the record of the i-th skylink and verifies its source
+	// and submitter.
+	check := func(i int, source, submitter string) {
+		t.Helper()
+		saved, err := db.Skylink(ctx, sls[i].Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.ScannedAt.IsZero() || saved.Source != source || saved.Submitter != submitter {
+			t.Fatalf("Expected skylink %d to be scanned for %s by '%s', got %+v", i, source, submitter, saved)
+		}
+	}
+	check(0, database.SourceUser, "ip:192.0.2.1")
+	check(1, database.SourceReport, "")
+
+	// An admin's re-scan replaces the source and the submitter.
+	rescan := *sls[0]
+	rescan.Source, rescan.Submitter = database.SourceAdmin, "alice"
+	if err := db.SkylinkRescan(ctx, &rescan); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	check(0, database.SourceAdmin, "alice")
+
+	// Re-scans after signature updates are automated.
+	if _, err := db.RescanClean(ctx, time.Time{}, database.Clock.Now().UTC().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := db.Skylink(ctx, sls[1].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != database.SkylinkStatusNew || saved.Source != database.SourceRescan || saved.Submitter != "" {
+		t.Fatalf("Expected the clean skylink to be queued for a re-scan, got %+v", saved)
+	}
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+)
+
+// TestScanStats ensures the scan stats aggregated in the DB bucket the
+// records by hour and leave records without a submission time out of the
+// latencies.
+func TestScanStats(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	defer func(d time.Duration) { database.SLATarget = d }(database.SLATarget)
+	database.SLATarget = 5 * time.Minute
+
+	since := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	sls := queueSkylinks(t, db, "http://portal.invalid", 4)
+	for i, sl := range sls {
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved.Status = database.SkylinkStatusComplete
+		saved.ScannedSize = 100
+		saved.ScannedAt = since.Add(time.Duration(i+1) * 10 * time.Minute)
+		saved.SubmittedAt = saved.ScannedAt.Add(-time.Duration(i+1) * 2 * time.Minute)
+		if i == 3 {
+			// A legacy record in the next hour.
+			saved.ScannedAt = saved.ScannedAt.Add(time.Hour)
+			saved.SubmittedAt = time.Time{}
+		}
+		if err = db.SkylinkSave(ctx, saved); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := db.ScanStats(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 4 || stats.BytesScanned != 400 || len(stats.Hourly) != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if !stats.Hourly[0].Hour.Equal(since) || stats.Hourly[0].Records != 3 || stats.Hourly[1].Records != 1 {
+		t.Fatalf("Unexpected hourly throughput %+v", stats.Hourly)
+	}
+	// The latencies are 2, 4 and 6 minutes.
+	if stats.Latency.P50 != 240 || stats.Latency.Max != 360 || stats.WithinSLA < 0.66 || stats.WithinSLA > 0.67 {
+		t.Fatalf("Unexpected latencies %+v, within SLA %f", stats.Latency, stats.WithinSLA)
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/clock"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+	"go.sia.tech/siad/crypto"
+)
+
+// TestStatusTransitions ensures random sequences of the operations which
+// update the records' statuses never move a record through a transition the
+// state machine doesn't allow.
+func TestStatusTransitions(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	fake := clock.NewFake(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	real := database.Clock
+	database.Clock = fake
+	t.Cleanup(func() { database.Clock = real })
+
+	sls := queueSkylinks(t, db, "http://portal.invalid", 5)
+	hashes := make([]crypto.Hash, len(sls))
+	for i, sl := range sls {
+		hashes[i] = sl.Hash
+	}
+	// record fetches the current record of the i-th skylink.
+	record := func(i int) *database.Skylink {
+		sl, err := db.Skylink(ctx, hashes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sl
+	}
+	// ops are the operations which update the statuses. Each applies to the
+	// record of the given skylink, if it applies to a single record at all,
+	// and fails only if it doesn't apply to the record's current status.
+	ops := map[string]func(i int, r *rand.Rand) error{
+		"lock": func(int, *rand.Rand) error {
+			_, err := db.SweepAndLock(ctx)
+			return err
+		},
+		"verdict": func(i int, r *rand.Rand) error {
+			sl := record(i)
+			sl.Status = database.SkylinkStatusComplete
+			sl.RescanSkylink = sl.Skylink
+			sl.Infected = r.Intn(2) == 0
+			if sl.Infected {
+				sl.Status = database.SkylinkStatusUnreported
+				sl.RescanSkylink = ""
+			} else {
+				sl.Skylink = ""
+			}
+			sl.ScannedAt = database.Clock.Now().UTC()
+			return db.SkylinkSaveVerdict(ctx, sl)
+		},
+		"failure": func(i int, _ *rand.Rand) error {
+			sl := record(i)
+			sl.LastError = "portal unavailable"
+			return db.SkylinkSaveFailure(ctx, sl)
+		},
+		"unlock": func(i int, _ *rand.Rand) error {
+			return db.SkylinkUnlock(ctx, record(i))
+		},
+		"cancel stuck": func(int, *rand.Rand) error {
+			fake.Advance(database.ScanTimeout + time.Second)
+			_, err := db.CancelStuckScans(ctx)
+			return err
+		},
+		"rescan": func(i int, _ *rand.Rand) error {
+			return db.SkylinkRescan(ctx, sls[i])
+		},
+		"enqueue reported": func(i int, _ *rand.Rand) error {
+			_, err := db.SkylinkEnqueueReported(ctx, sls[i], 1, "abuse")
+			return err
+		},
+		"known infected": func(i int, _ *rand.Rand) error {
+			_, err := db.MarkKnownInfected(ctx, hashes[i:i+1], "blocklist")
+			return err
+		},
+		"false positive": func(i int, _ *rand.Rand) error {
+			_, err := db.SkylinkMarkFalsePositive(ctx, hashes[i])
+			return err
+		},
+		"reports": func(i int, r *rand.Rand) error {
+			sl := record(i)
+			sl.Reports = map[string]*database.BlockerResponse{"default": {Result: database.BlockerResultBlocked}}
+			return db.SkylinkSaveReports(ctx, sl, r.Intn(2) == 0)
+		},
+		"save": func(i int, r *rand.Rand) error {
+			sl := record(i)
+			statuses := []string{database.SkylinkStatusNew, database.SkylinkStatusScanning, database.SkylinkStatusUnreported,
+				database.SkylinkStatusPendingReview, database.SkylinkStatusComplete, database.SkylinkStatusGone}
+			sl.Status = statuses[r.Intn(len(statuses))]
+			return db.SkylinkSave(ctx, sl)
+		},
+		"rescan clean": func(int, *rand.Rand) error {
+			_, err := db.RescanClean(ctx, time.Time{}, database.Clock.Now().UTC().Add(time.Second))
+			return err
+		},
+	}
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+
+	statuses := func() []string {
+		s := make([]string, len(hashes))
+		for i := range hashes {
+			s[i] = record(i).Status
+		}
+		return s
+	}
+	f := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		before := statuses()
+		for step := 0; step < 50; step++ {
+			name := names[r.Intn(len(names))]
+			i := r.Intn(len(hashes))
+			err := ops[name](i, r)
+			if err != nil && !errors.Contains(err, database.ErrStatusChanged) && !errors.Contains(err, database.ErrNoDocumentsFound) && !errors.Contains(err, database.ErrInvalidTransition) {
+				t.Fatalf("%s of record %d failed: %v", name, i, err)
+			}
+			after := statuses()
+			for j := range after {
+				if after[j] != before[j] && !database.ValidTransition(before[j], after[j]) {
+					t.Logf("%s of record %d moved record %d from %s to %s", name, i, j, before[j], after[j])
+					return false
+				}
+			}
+			before = after
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 20}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/scanner"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// accountsDB is the name of the skynet-accounts database the tests seed.
+const accountsDB = "accounts"
+
+// seedUploads records the given uploads of the skylink in skynet-accounts'
+// database, one per upload time, by the user with the given ID. A zero ID
+// records anonymous uploads. The users are created if they don't exist.
+func seedUploads(t *testing.T, db *database.DB, skylink string, userID primitive.ObjectID, times ...time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	adb := db.Collection("skylinks").Database().Client().Database(accountsDB)
+	var sl struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := adb.Collection("skylinks").FindOneAndUpdate(ctx, bson.M{"skylink": skylink}, bson.M{"$setOnInsert": bson.M{"size": 4096}}, opts).Decode(&sl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !userID.IsZero() {
+		user := bson.M{"sub": "sub-" + userID.Hex(), "email": userID.Hex() + "@example.com"}
+		_, err = adb.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": user}, options.Update().SetUpsert(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ts := range times {
+		upload := bson.M{"skylink_id": sl.ID, "timestamp": ts}
+		if !userID.IsZero() {
+			upload["user_id"] = userID
+		}
+		if _, err = adb.Collection("uploads").InsertOne(ctx, upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestUploaders ensures we look up who uploaded a skylink in skynet-accounts'
+// database, grouping the anonymous uploads and listing the users with the most
+// uploads first.
+func TestUploaders(t *testing.T) {
+	db := containers.MongoDB(t)
+	ctx := context.Background()
+	sls := queueSkylinks(t, db, "http://portal.invalid", 2)
+	skylink := sls[0].Skylink
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	seedUploads(t, db, skylink, alice, t0.Add(time.Hour), t0)
+	seedUploads(t, db, skylink, bob, t0)
+	seedUploads(t, db, skylink, primitive.NilObjectID, t0, t0, t0.Add(2*time.Hour))
+
+	uploaders, err := db.Uploaders(ctx, accountsDB, skylink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaders) != 3 {
+		t.Fatalf("Expected 3 uploaders, got %+v", uploaders)
+	}
+	anon, a, b := uploaders[0], uploaders[1], uploaders[2]
+	if !anon.UserID.IsZero() || anon.Sub != "" || anon.Uploads != 3 || !anon.LastUpload.Equal(t0.Add(2*time.Hour)) {
+		t.Fatalf("Expected the anonymous uploads first, got %+v", anon)
+	}
+	if a.UserID != alice || a.Sub != "sub-"+alice.Hex() || a.Email != alice.Hex()+"@example.com" || a.Uploads != 2 {
+		t.Fatalf("Expected alice's uploads, got %+v", a)
+	}
+	if !a.FirstUpload.Equal(t0) || !a.LastUpload.Equal(t0.Add(time.Hour)) {
+		t.Fatalf("Expected alice's first and last upload, got %+v", a)
+	}
+	if b.UserID != bob || b.Uploads != 1 {
+		t.Fatalf("Expected bob's upload, got %+v", b)
+	}
+
+	// Skylinks the portal has no record of have no uploaders.
+	if uploaders, err = db.Uploaders(ctx, accountsDB, sls[1].Skylink); err != nil || uploaders != nil {
+		t.Fatalf("Expected no uploaders, got %+v, %v", uploaders, err)
+	}
+	if _, err = db.Uploaders(ctx, accountsDB, "not-a-skylink"); !errors.Contains(err, database.ErrInvalidSkylink) {
+		t.Fatalf("Expected an invalid skylink, got %v", err)
+	}
+}
+
+// TestLookupUploaders ensures the scanner records the uploaders of infected
+// skylinks, and only of infected ones, on their records.
+func TestLookupUploaders(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+	defer func(name string) { scanner.AccountsDB = name }(scanner.AccountsDB)
+	scanner.AccountsDB = accountsDB
+
+	sls := queueSkylinks(t, db, e.portal.URL, 2)
+	infected, clean := sls[0], sls[1]
+	e.portal.SetAsset(infected.Skylink, EICARAsset("eicar.com"))
+	e.portal.SetAsset(clean.Skylink, TextAsset("clean.txt", "clean"))
+	user := primitive.NewObjectID()
+	for _, sl := range sls {
+		seedUploads(t, db, sl.Skylink, user, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	s := newReportScanner(ctx, t, db, e)
+	for range sls {
+		if err := s.RunOnce(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, err := db.Skylink(ctx, infected.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Infected || len(saved.Uploaders) != 1 || saved.Uploaders[0].UserID != user || saved.Uploaders[0].Uploads != 1 {
+		t.Fatalf("Expected the uploader of the infected skylink on its record, got %+v", saved)
+	}
+	saved, err = db.Skylink(ctx, clean.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Infected || saved.Uploaders != nil {
+		t.Fatalf("Expected no uploaders on the clean record, got %+v", saved)
+	}
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/malware-scanner/blocker"
+	"github.com/SkynetLabs/malware-scanner/database"
+	"github.com/SkynetLabs/malware-scanner/test/containers"
+)
+
+// TestVerdictCache ensures content which is submitted again after its record
+// was removed gets the verdict we cached for it instead of being downloaded
+// and scanned again, unless an admin overrode that verdict.
+func TestVerdictCache(t *testing.T) {
+	db := containers.MongoDB(t)
+	e := newEnv(t, blocker.Options{})
+	ctx := context.Background()
+
+	sl := queueSkylinks(t, db, e.portal.URL, 1)[0]
+	e.portal.SetAsset(sl.Skylink, EICARAsset("eicar.com"))
+	s := newReportScanner(ctx, t, db, e)
+	if err := s.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	requests, scans := e.portal.Requests(sl.Skylink), e.clam.Scans()
+
+	// resubmit removes the record and submits the skylink again.
+	resubmit := func() *database.Skylink {
+		t.Helper()
+		if err := db.SkylinkPurge(ctx, sl.Hash); err != nil {
+			t.Fatal(err)
+		}
+		var again database.Skylink
+		if err := again.LoadString(sl.Skylink, e.portal.URL); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SkylinkCreate(ctx, &again); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RunOnce(); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := db.Skylink(ctx, sl.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saved
+	}
+	saved := resubmit()
+	if !saved.Infected || saved.SignatureVersion == 0 || saved.Filename != "eicar.com" {
+		t.Fatalf("Expected the cached verdict, got %+v", saved)
+	}
+	if e.portal.Requests(sl.Skylink) != requests || e.clam.Scans() != scans {
+		t.Fatal("Expected the content not to be downloaded and scanned again")
+	}
+
+	// False positives drop the cached verdict.
+	if _, err := db.SkylinkMarkFalsePositive(ctx, sl.Hash); err != nil {
+		t.Fatal(err)
+	}
+	saved = resubmit()
+	if !saved.Infected {
+		t.Fatalf("Expected the content to be scanned again, got %+v", saved)
+	}
+	if e.portal.Requests(sl.Skylink) == requests || e.clam.Scans() == scans {
+		t.Fatal("Expected the content to be downloaded and scanned again")
+	}
+}